@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/provider"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/redact"
+)
+
+// configPollInterval is how often the config file is checked for changes.
+// Polling (rather than inotify) also catches the symlink swap Kubernetes uses
+// to update mounted ConfigMaps.
+const configPollInterval = 10 * time.Second
+
+// reloadableFlags are the settings applied on reload; changes to any other
+// setting in the config file need a restart.
+var reloadableFlags = []string{
+	"log-level",
+	"log-format",
+	"log-redact-env",
+	"log-redact-files",
+	"reconcile-interval",
+	"reconcile-jitter",
+	"disconnect-check-interval",
+	"device-reconnect-timeout",
+	"deployment-ready-timeout",
+}
+
+// readConfigFile parses a YAML or JSON config file into flag values keyed by
+// flag name. Unknown settings are rejected.
+func readConfigFile(path string, fs *pflag.FlagSet) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	var raw map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(jsonData))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		if name == "config" || fs.Lookup(name) == nil {
+			return nil, fmt.Errorf("config file %s: unknown setting %q", path, name)
+		}
+		if value == nil {
+			continue
+		}
+		s, err := flagValueString(value)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: setting %q: %w", path, name, err)
+		}
+		values[name] = s
+	}
+	return values, nil
+}
+
+// fileHash returns the SHA-256 of the file content.
+func fileHash(path string) ([32]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
+
+// flagValueString converts a decoded config value to its flag syntax. Maps
+// become comma-separated key=value pairs and lists comma-separated values.
+func flagValueString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case map[string]interface{}:
+		pairs := make([]string, 0, len(v))
+		for key, val := range v {
+			s, err := flagValueString(val)
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, key+"="+s)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, val := range v {
+			s, err := flagValueString(val)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// overridden reports whether a flag was set on the command line or by its
+// environment variable, in which case the config file does not apply.
+func (o *options) overridden(name string) bool {
+	if o.explicitFlags[name] {
+		return true
+	}
+	env, ok := flagEnvVars[name]
+	return ok && os.Getenv(env) != ""
+}
+
+// loadConfigFile applies the --config file to every flag not set on the
+// command line or through the environment.
+func (o *options) loadConfigFile(fs *pflag.FlagSet) error {
+	o.flags = fs
+	if o.explicitFlags == nil {
+		o.explicitFlags = make(map[string]bool)
+		fs.Visit(func(f *pflag.Flag) {
+			o.explicitFlags[f.Name] = true
+		})
+	}
+	if o.configFile == "" {
+		return nil
+	}
+
+	values, err := readConfigFile(o.configFile, fs)
+	if err != nil {
+		return err
+	}
+	for name, value := range values {
+		if o.overridden(name) {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("config file %s: invalid %s %q: %w", o.configFile, name, value, err)
+		}
+	}
+	return nil
+}
+
+// tunablesUpdater applies reloaded runtime settings: the provider in single
+// node mode, the device node controller in per-device mode.
+type tunablesUpdater interface {
+	UpdateTunables(t provider.Tunables) error
+}
+
+// reloadConfigFile re-reads the config file and applies the reloadable
+// settings to the logger and the provider. Settings removed from the file
+// revert to their defaults. Invalid files are rejected as a whole.
+func (o *options) reloadConfigFile(p tunablesUpdater, previous map[string]string) (map[string]string, error) {
+	values, err := readConfigFile(o.configFile, o.flags)
+	if err != nil {
+		return previous, err
+	}
+
+	next := *o
+	for _, name := range reloadableFlags {
+		if o.overridden(name) {
+			continue
+		}
+		value, ok := values[name]
+		if !ok {
+			value = o.flags.Lookup(name).DefValue
+		}
+		if err := next.set(name, value); err != nil {
+			return previous, fmt.Errorf("config file %s: invalid %s %q: %w", o.configFile, name, value, err)
+		}
+	}
+	if err := p.UpdateTunables(next.tunables()); err != nil {
+		return previous, fmt.Errorf("config file %s: %w", o.configFile, err)
+	}
+
+	*o = next
+	o.applyLogging()
+
+	for name, value := range values {
+		if !slices.Contains(reloadableFlags, name) && previous[name] != value && !o.overridden(name) {
+			logger.Warn("Config setting %s changed; restart the provider to apply it", name)
+		}
+	}
+	for name := range previous {
+		if _, ok := values[name]; !ok && !slices.Contains(reloadableFlags, name) && !o.overridden(name) {
+			logger.Warn("Config setting %s removed; restart the provider to apply it", name)
+		}
+	}
+	return values, nil
+}
+
+// set parses a reloadable setting into the options without touching the flag
+// set, so a rejected reload leaves the running configuration unchanged.
+func (o *options) set(name, value string) error {
+	var err error
+	switch name {
+	case "log-level":
+		o.logLevel = value
+	case "log-format":
+		o.logFormat = value
+	case "log-redact-env":
+		o.logRedactEnv = value
+		_, err = redact.ParsePatterns(value)
+	case "log-redact-files":
+		o.logRedactFiles = value
+		_, err = redact.ParsePatterns(value)
+	case "reconcile-interval":
+		o.reconcileInterval, err = time.ParseDuration(value)
+	case "reconcile-jitter":
+		o.reconcileJitter, err = time.ParseDuration(value)
+	case "disconnect-check-interval":
+		o.disconnectCheckInterval, err = time.ParseDuration(value)
+	case "device-reconnect-timeout":
+		o.deviceReconnectTimeout, err = time.ParseDuration(value)
+	case "deployment-ready-timeout":
+		o.deploymentReadyTimeout, err = time.ParseDuration(value)
+	default:
+		err = fmt.Errorf("setting cannot be reloaded")
+	}
+	return err
+}
+
+// watchConfigFile reloads the config file on SIGHUP or when its content
+// changes, until ctx is done.
+func (o *options) watchConfigFile(ctx context.Context, p tunablesUpdater) {
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	defer signal.Stop(hupCh)
+
+	if o.configFile == "" {
+		// Nothing to reload, but don't let SIGHUP terminate the process
+		<-ctx.Done()
+		return
+	}
+
+	// The file was validated at startup; an error here means it changed since
+	current, _ := readConfigFile(o.configFile, o.flags)
+	hash, _ := fileHash(o.configFile)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hupCh:
+			logger.Info("Received SIGHUP, reloading %s", o.configFile)
+		case <-ticker.C:
+			newHash, err := fileHash(o.configFile)
+			if err != nil || newHash == hash {
+				continue
+			}
+			hash = newHash
+			logger.Info("Config file %s changed, reloading", o.configFile)
+		}
+
+		var err error
+		current, err = o.reloadConfigFile(p, current)
+		if err != nil {
+			logger.Error("Config reload failed, keeping previous settings: %v", err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFilePrecedence(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "")
+	t.Setenv("RECONCILE_INTERVAL", "")
+	t.Setenv("NODE_LABELS", "")
+	t.Setenv("FLIGHTCTL_DEFAULT_FLEET", "env-fleet")
+
+	path := writeConfig(t, `
+node-name: file-node
+log-level: debug
+reconcile-interval: 45s
+default-fleet: file-fleet
+node-labels:
+  zone: edge
+  site: galway
+`)
+
+	opts := &options{}
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	opts.addFlags(fs)
+	if err := fs.Parse([]string{"--config", path, "--node-name", "flag-node"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.loadConfigFile(fs); err != nil {
+		t.Fatalf("loadConfigFile: %v", err)
+	}
+
+	if opts.nodeName != "flag-node" {
+		t.Errorf("nodeName = %q, want flag to win", opts.nodeName)
+	}
+	if opts.defaultFleet != "env-fleet" {
+		t.Errorf("defaultFleet = %q, want environment to win", opts.defaultFleet)
+	}
+	if opts.logLevel != "debug" {
+		t.Errorf("logLevel = %q, want debug from file", opts.logLevel)
+	}
+	if opts.reconcileInterval != 45*time.Second {
+		t.Errorf("reconcileInterval = %s, want 45s", opts.reconcileInterval)
+	}
+	if opts.nodeLabels["zone"] != "edge" || opts.nodeLabels["site"] != "galway" {
+		t.Errorf("nodeLabels = %v", opts.nodeLabels)
+	}
+}
+
+func TestLoadConfigFileRejectsUnknownSettings(t *testing.T) {
+	path := writeConfig(t, "reconcile-intervall: 10s\n")
+
+	opts := &options{}
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	opts.addFlags(fs)
+	if err := fs.Parse([]string{"--config", path}); err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.loadConfigFile(fs); err == nil {
+		t.Fatal("expected an error for an unknown setting")
+	}
+}
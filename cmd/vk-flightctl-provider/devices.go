@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+func newDevicesCommand(opts *options) *cobra.Command {
+	devices := &cobra.Command{
+		Use:   "devices",
+		Short: "Inspect the FlightCtl devices visible to the provider",
+	}
+
+	var fleet string
+	var selectors []string
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List devices, optionally filtered by fleet and labels",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			labels, err := parseSelectors(selectors)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(cmd.Context(), 30*time.Second)
+			defer cancel()
+			return listDevices(ctx, cmd, opts, fleet, labels)
+		},
+	}
+	list.Flags().StringVar(&fleet, "fleet", "", "Only list devices in this fleet")
+	list.Flags().StringSliceVarP(&selectors, "selector", "l", nil, "Label selector (key=value), may be repeated or comma-separated")
+
+	devices.AddCommand(list)
+	return devices
+}
+
+func listDevices(ctx context.Context, cmd *cobra.Command, opts *options, fleet string, labels map[string]string) error {
+	cfg, err := opts.providerConfig()
+	if err != nil {
+		return err
+	}
+	endpoints, err := opts.endpointConfigs(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Devices are listed with their endpoint, if there are several
+	type endpointDevice struct {
+		endpoint string
+		*models.Device
+	}
+	var devices []endpointDevice
+	for _, endpoint := range endpoints {
+		client, err := flightctl.NewClient(endpoint.cfg.FlightctlConfig())
+		if err != nil {
+			return fmt.Errorf("creating Flightctl client: %w", err)
+		}
+		listed, err := client.ListDevices(ctx, fleet, labels)
+		client.Close()
+		if err != nil {
+			return err
+		}
+		for _, device := range listed {
+			devices = append(devices, endpointDevice{endpoint.name, device})
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].endpoint != devices[j].endpoint {
+			return devices[i].endpoint < devices[j].endpoint
+		}
+		return devices[i].ID < devices[j].ID
+	})
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	header := "ID\tNAME\tFLEET\tPHASE\tCONNECTION\tCPU\tMEMORY\tLAST SEEN"
+	if opts.flightctlEndpointsFile != "" {
+		header = "ENDPOINT\t" + header
+	}
+	fmt.Fprintln(w, header)
+	for _, d := range devices {
+		lastSeen := "-"
+		if !d.LastHeartbeat.IsZero() {
+			lastSeen = time.Since(d.LastHeartbeat).Round(time.Second).String() + " ago"
+		}
+		if opts.flightctlEndpointsFile != "" {
+			fmt.Fprintf(w, "%s\t", d.endpoint)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			d.ID, d.Name, valueOrDash(d.FleetID), d.Status.Phase, d.ConnectionState,
+			d.Capacity.CPU.String(), d.Capacity.Memory.String(), lastSeen)
+	}
+	return w.Flush()
+}
+
+// parseSelectors parses key=value label selectors.
+func parseSelectors(selectors []string) (map[string]string, error) {
+	if len(selectors) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(selectors))
+	for _, selector := range selectors {
+		key, value, ok := strings.Cut(selector, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid selector %q, expected key=value", selector)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+func valueOrDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
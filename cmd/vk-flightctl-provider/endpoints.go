@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/provider"
+)
+
+// EndpointLabel is set on the nodes of each FlightCtl endpoint to its name,
+// so pods can select the FlightCtl instance they run under with a
+// nodeSelector.
+const EndpointLabel = "flightctl.io/endpoint"
+
+// flightctlEndpoint is a FlightCtl instance whose devices get virtual nodes
+// of their own, for clusters orchestrating devices managed by several
+// regional control planes. Its nodes are named after the node name, device
+// or fleet with the endpoint name added, and labeled with EndpointLabel.
+type flightctlEndpoint struct {
+	Name string `json:"name"`
+
+	APIURL       string `json:"apiURL"`
+	AuthMode     string `json:"authMode,omitempty"` // Default oauth
+	ClientID     string `json:"clientID,omitempty"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+	TokenURL     string `json:"tokenURL,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	Token        string `json:"token,omitempty"`
+	TokenFile    string `json:"tokenFile,omitempty"`
+	ClientCert   string `json:"clientCertFile,omitempty"`
+	ClientKey    string `json:"clientKeyFile,omitempty"`
+	CAFile       string `json:"caFile,omitempty"`
+	CAData       string `json:"caData,omitempty"`
+	InsecureTLS  bool   `json:"insecureTLS,omitempty"`
+
+	// Namespaces whose pods may run on the endpoint's nodes; empty allows
+	// all namespaces.
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// loadEndpoints reads the FlightCtl endpoints from a YAML or JSON list, e.g.
+//
+//   - name: eu
+//     apiURL: https://api.flightctl.eu.example.com/api/v1/
+//     authMode: token-file
+//     tokenFile: /var/run/secrets/flightctl-eu/token
+//     namespaces: [edge-eu]
+func loadEndpoints(path string) ([]flightctlEndpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading FlightCtl endpoints: %w", err)
+	}
+	var endpoints []flightctlEndpoint
+	if err := yaml.UnmarshalStrict(data, &endpoints); err != nil {
+		return nil, fmt.Errorf("parsing FlightCtl endpoints %s: %w", path, err)
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no FlightCtl endpoints in %s", path)
+	}
+	names := make(map[string]bool, len(endpoints))
+	for i := range endpoints {
+		endpoint := &endpoints[i]
+		if errs := validation.IsDNS1123Label(endpoint.Name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid FlightCtl endpoint name %q: %s", endpoint.Name, strings.Join(errs, "; "))
+		}
+		if names[endpoint.Name] {
+			return nil, fmt.Errorf("duplicate FlightCtl endpoint %s", endpoint.Name)
+		}
+		names[endpoint.Name] = true
+		if err := endpoint.validate(); err != nil {
+			return nil, fmt.Errorf("FlightCtl endpoint %s: %w", endpoint.Name, err)
+		}
+	}
+	return endpoints, nil
+}
+
+// validate checks that the endpoint has a URL and the credentials of its
+// auth mode.
+func (e *flightctlEndpoint) validate() error {
+	if e.APIURL == "" {
+		return fmt.Errorf("apiURL is required")
+	}
+	if e.AuthMode == "" {
+		e.AuthMode = flightctl.AuthModeOAuth
+	}
+	switch e.AuthMode {
+	case flightctl.AuthModeOAuth:
+		if e.ClientID == "" {
+			return fmt.Errorf("clientID is required")
+		}
+		if e.ClientSecret == "" && e.RefreshToken == "" {
+			return fmt.Errorf("clientSecret or refreshToken is required")
+		}
+		if e.TokenURL == "" {
+			return fmt.Errorf("tokenURL is required")
+		}
+	case flightctl.AuthModeToken:
+		if e.Token == "" {
+			return fmt.Errorf("token is required for auth mode token")
+		}
+	case flightctl.AuthModeTokenFile:
+		if e.TokenFile == "" {
+			return fmt.Errorf("tokenFile is required for auth mode token-file")
+		}
+	case flightctl.AuthModeNone:
+		if e.ClientCert == "" {
+			return fmt.Errorf("clientCertFile is required for auth mode none")
+		}
+	default:
+		return fmt.Errorf("unknown authMode %q (expected oauth, token, token-file or none)", e.AuthMode)
+	}
+	return nil
+}
+
+// apply configures the providers of the endpoint's nodes: its API and
+// credentials, the endpoint label, and the namespaces allowed on them.
+// cfg must not be shared with other endpoints.
+func (e flightctlEndpoint) apply(cfg *provider.Config) {
+	cfg.FlightctlAPIURL = e.APIURL
+	cfg.FlightctlAuthMode = e.AuthMode
+	cfg.FlightctlClientID = e.ClientID
+	cfg.FlightctlClientSecret = e.ClientSecret
+	cfg.FlightctlTokenURL = e.TokenURL
+	cfg.FlightctlRefreshToken = e.RefreshToken
+	cfg.FlightctlToken = e.Token
+	cfg.FlightctlTokenFile = e.TokenFile
+	cfg.FlightctlClientCertFile = e.ClientCert
+	cfg.FlightctlClientKeyFile = e.ClientKey
+	cfg.FlightctlCAFile = e.CAFile
+	cfg.FlightctlCAData = []byte(e.CAData)
+	cfg.FlightctlInsecureTLS = e.InsecureTLS
+
+	labels := make(map[string]string, len(cfg.NodeLabels)+1)
+	for key, value := range cfg.NodeLabels {
+		labels[key] = value
+	}
+	labels[EndpointLabel] = e.Name
+	cfg.NodeLabels = labels
+
+	if len(e.Namespaces) > 0 {
+		cfg.DeviceAccessPolicy = restrictNamespaces(cfg.DeviceAccessPolicy, e.Namespaces)
+	}
+}
+
+// endpointConfig is the provider configuration of a FlightCtl endpoint.
+type endpointConfig struct {
+	name string // Empty for the endpoint set by the flags
+	cfg  provider.Config
+}
+
+// endpointConfigs returns the configuration of each endpoint of the
+// --flightctl-endpoints-file, or of the endpoint set by the flags if there is
+// none. In single node mode the node of each endpoint is named
+// <node-name>-<endpoint>.
+func (o *options) endpointConfigs(cfg provider.Config) ([]endpointConfig, error) {
+	if o.flightctlEndpointsFile == "" {
+		return []endpointConfig{{cfg: cfg}}, nil
+	}
+	endpoints, err := loadEndpoints(o.flightctlEndpointsFile)
+	if err != nil {
+		return nil, err
+	}
+	configs := make([]endpointConfig, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		endpointCfg := cfg
+		endpoint.apply(&endpointCfg)
+		if o.nodeMode == nodeModeSingle {
+			endpointCfg.NodeName = cfg.NodeName + "-" + endpoint.Name
+		}
+		if o.shardGroup != "" {
+			group := shardGroupName(o.shardGroup, endpoint.Name)
+			if errs := validation.IsDNS1123Label(group); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid shard group %q of FlightCtl endpoint %s: %s", group, endpoint.Name, strings.Join(errs, "; "))
+			}
+		}
+		configs = append(configs, endpointConfig{name: endpoint.Name, cfg: endpointCfg})
+	}
+	return configs, nil
+}
+
+// restrictNamespaces returns the policy limited to the namespaces: they keep
+// their rule, or the AnyNamespace rule, and the other namespaces may not use
+// any device.
+func restrictNamespaces(policy provider.DeviceAccessPolicy, namespaces []string) provider.DeviceAccessPolicy {
+	restricted := make(provider.DeviceAccessPolicy, len(namespaces))
+	for _, namespace := range namespaces {
+		if policy == nil {
+			restricted[namespace] = provider.DeviceAccessRule{}
+		} else if rule, ok := policy[namespace]; ok {
+			restricted[namespace] = rule
+		} else if rule, ok := policy[provider.AnyNamespace]; ok {
+			restricted[namespace] = rule
+		}
+	}
+	return restricted
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/provider"
+)
+
+func writeEndpoints(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("writing endpoints: %v", err)
+	}
+	return path
+}
+
+func TestEndpointConfigs(t *testing.T) {
+	o := &options{
+		nodeMode: nodeModeSingle,
+		flightctlEndpointsFile: writeEndpoints(t, `
+- name: eu
+  apiURL: https://eu.example.com/api/v1/
+  authMode: token
+  token: eu-token
+  namespaces: [edge-eu]
+- name: us
+  apiURL: https://us.example.com/api/v1/
+  authMode: token-file
+  tokenFile: /var/run/secrets/us/token
+`),
+	}
+	base := provider.Config{NodeName: "flightctl", FlightctlAPIURL: "https://default.example.com", NodeLabels: map[string]string{"zone": "edge"}}
+
+	endpoints, err := o.endpointConfigs(base)
+	if err != nil {
+		t.Fatalf("endpointConfigs: %v", err)
+	}
+	if len(endpoints) != 2 {
+		t.Fatalf("got %d endpoints, want 2", len(endpoints))
+	}
+	eu, us := endpoints[0].cfg, endpoints[1].cfg
+	if eu.NodeName != "flightctl-eu" || us.NodeName != "flightctl-us" {
+		t.Errorf("node names = %s, %s, want flightctl-eu, flightctl-us", eu.NodeName, us.NodeName)
+	}
+	if eu.FlightctlAPIURL != "https://eu.example.com/api/v1/" || eu.FlightctlToken != "eu-token" {
+		t.Errorf("eu endpoint API = %s with token %q", eu.FlightctlAPIURL, eu.FlightctlToken)
+	}
+	if eu.NodeLabels[EndpointLabel] != "eu" || eu.NodeLabels["zone"] != "edge" || base.NodeLabels[EndpointLabel] != "" {
+		t.Errorf("eu node labels = %v (base %v)", eu.NodeLabels, base.NodeLabels)
+	}
+
+	device := &models.Device{ID: "d1"}
+	if err := eu.DeviceAccessPolicy.Allows("edge-eu", device); err != nil {
+		t.Errorf("edge-eu denied on the eu endpoint: %v", err)
+	}
+	if err := eu.DeviceAccessPolicy.Allows("default", device); err == nil {
+		t.Error("default namespace allowed on the eu endpoint")
+	}
+	if us.DeviceAccessPolicy != nil {
+		t.Errorf("us endpoint policy = %v, want all namespaces allowed", us.DeviceAccessPolicy)
+	}
+}
+
+func TestLoadEndpointsRejectsInvalidEndpoints(t *testing.T) {
+	for name, content := range map[string]string{
+		"empty":          `[]`,
+		"invalid name":   "- name: EU\n  apiURL: https://eu\n  authMode: token\n  token: t\n",
+		"duplicate name": "- name: eu\n  apiURL: https://eu\n  authMode: token\n  token: t\n- name: eu\n  apiURL: https://eu2\n  authMode: token\n  token: t\n",
+		"missing URL":    "- name: eu\n  authMode: token\n  token: t\n",
+		"missing token":  "- name: eu\n  apiURL: https://eu\n  authMode: token\n",
+		"unknown field":  "- name: eu\n  apiURL: https://eu\n  authMode: token\n  token: t\n  region: eu-west\n",
+	} {
+		if _, err := loadEndpoints(writeEndpoints(t, content)); err == nil {
+			t.Errorf("%s: loadEndpoints accepted %q", name, content)
+		} else if !strings.Contains(err.Error(), "endpoint") {
+			t.Errorf("%s: error %q does not mention the endpoints", name, err)
+		}
+	}
+}
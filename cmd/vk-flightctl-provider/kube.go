@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// kubeRestConfig returns the Kubernetes client configuration: the given
+// kubeconfig file when set (for running outside the cluster, e.g. on a
+// laptop), otherwise the in-cluster service account.
+func kubeRestConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig == "" {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("getting in-cluster config (set --kubeconfig or KUBECONFIG to run outside the cluster): %w", err)
+		}
+		return config, nil
+	}
+
+	// The loading rules accept a KUBECONFIG-style list of files
+	rules := &clientcmd.ClientConfigLoadingRules{Precedence: filepath.SplitList(kubeconfig)}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading kubeconfig %s: %w", kubeconfig, err)
+	}
+	log.Printf("Using kubeconfig %s (API server %s)", kubeconfig, config.Host)
+	return config, nil
+}
+
+// cordonedOnShutdownAnnotation marks a node cordoned by the provider when it
+// shut down, so it is uncordoned again when the node restarts while nodes
+// cordoned by an administrator are left alone.
+const cordonedOnShutdownAnnotation = "flightctl.io/cordoned-on-shutdown"
+
+// cordonNodes marks the nodes unschedulable so no pods are scheduled to them
+// while the provider is down. Failures are logged and do not stop shutdown.
+func cordonNodes(ctx context.Context, k8sClient kubernetes.Interface, names []string) {
+	patch := []byte(`{"metadata":{"annotations":{"` + cordonedOnShutdownAnnotation + `":"true"}},"spec":{"unschedulable":true}}`)
+	for _, name := range names {
+		node, err := k8sClient.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			log.Printf("Warning: Failed to get node %s for cordoning: %v", name, err)
+			continue
+		}
+		if node.Spec.Unschedulable {
+			continue
+		}
+		if _, err := k8sClient.CoreV1().Nodes().Patch(ctx, name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			log.Printf("Warning: Failed to cordon node %s: %v", name, err)
+			continue
+		}
+		log.Printf("Cordoned node %s", name)
+	}
+}
+
+// uncordonAfterRestart makes a node the provider cordoned on shutdown
+// schedulable again.
+func uncordonAfterRestart(ctx context.Context, k8sClient kubernetes.Interface, name string) {
+	node, err := k8sClient.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to get node %s: %v", name, err)
+		return
+	}
+	if node.Annotations[cordonedOnShutdownAnnotation] == "" {
+		return
+	}
+	patch := []byte(`{"metadata":{"annotations":{"` + cordonedOnShutdownAnnotation + `":null}},"spec":{"unschedulable":false}}`)
+	if _, err := k8sClient.CoreV1().Nodes().Patch(ctx, name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		log.Printf("Warning: Failed to uncordon node %s: %v", name, err)
+		return
+	}
+	log.Printf("Uncordoned node %s, cordoned when the provider last shut down", name)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/nodeutil"
+	"k8s.io/apiserver/pkg/server/dynamiccertificates"
+	"k8s.io/client-go/kubernetes"
+)
+
+// defaultKubeletAPIAddr is the kubelet's standard port.
+const defaultKubeletAPIAddr = ":10250"
+
+// kubeletAPI configures the kubelet API the virtual node serves: the
+// endpoints the API server (logs, exec) and metrics-server or Prometheus
+// (/stats/summary, /metrics/resource) call on a node.
+type kubeletAPI struct {
+	addr         string
+	certFile     string
+	keyFile      string
+	clientCAFile string
+}
+
+// nodeOpts returns the node options serving the kubelet API for a node, or
+// none if no serving certificate is configured.
+func (k *kubeletAPI) nodeOpts(k8sClient kubernetes.Interface, nodeName string) ([]nodeutil.NodeOpt, error) {
+	if k == nil || k.certFile == "" {
+		return nil, nil
+	}
+
+	// Requests are authenticated and authorized like the kubelet's: bearer
+	// tokens by TokenReview, client certificates (the API server's) against
+	// the client CA, and access to the node's subresources by
+	// SubjectAccessReview
+	var clientCA []byte
+	if k.clientCAFile != "" {
+		var err error
+		if clientCA, err = os.ReadFile(k.clientCAFile); err != nil {
+			return nil, fmt.Errorf("reading kubelet API client CA: %w", err)
+		}
+	}
+	auth, err := nodeutil.WebhookAuth(k8sClient, nodeName, func(cfg *nodeutil.WebhookAuthConfig) error {
+		if clientCA == nil {
+			return nil
+		}
+		ca, err := dynamiccertificates.NewStaticCAContent("kubelet-api-client-ca", clientCA)
+		if err != nil {
+			return fmt.Errorf("parsing kubelet API client CA: %w", err)
+		}
+		cfg.AuthnConfig.ClientCertificateCAContentProvider = ca
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("configuring kubelet API authentication: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	tlsOpts := []func(*tls.Config) error{nodeutil.WithKeyPairFromPath(k.certFile, k.keyFile)}
+	if clientCA != nil {
+		// Client certificates are optional: metrics-server and Prometheus
+		// authenticate with bearer tokens
+		tlsOpts = append(tlsOpts, func(cfg *tls.Config) error {
+			cfg.ClientCAs = x509.NewCertPool()
+			if !cfg.ClientCAs.AppendCertsFromPEM(clientCA) {
+				return fmt.Errorf("no certificates in kubelet API client CA %s", k.clientCAFile)
+			}
+			cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			return nil
+		})
+	}
+	return []nodeutil.NodeOpt{
+		nodeutil.WithTLSConfig(tlsOpts...),
+		nodeutil.AttachProviderRoutes(mux),
+		func(cfg *nodeutil.NodeConfig) error {
+			cfg.HTTPListenAddr = k.addr
+			cfg.Handler = nodeutil.WithAuth(auth, mux)
+			return nil
+		},
+	}, nil
+}
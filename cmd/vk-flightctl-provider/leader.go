@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const defaultLeaderElectionID = "vk-flightctl-provider"
+
+// Leader election timings: a standby replica takes over at most
+// leaseDuration after the leader stops renewing the lease, and immediately
+// when the leader releases it on shutdown. Tests shorten them.
+var (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// serviceAccountNamespaceFile holds the namespace of an in-cluster pod.
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// leaderElected wraps run so it only runs while this replica holds the
+// leader election lease. The returned function blocks until ctx is done, or
+// returns an error once leadership is lost so the process exits and
+// restarts as a standby instead of writing to devices alongside the new
+// leader. If run returns while leading, the lease is released so a standby
+// takes over, and its error is returned.
+func leaderElected(k8sClient kubernetes.Interface, namespace, name, identity string, run func(context.Context) error) (func(context.Context) error, error) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Client:     k8sClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+
+	runErr := make(chan error, 1)
+	var stopLeading context.CancelFunc
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Name:            name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Printf("Acquired leader election lease %s/%s as %s", namespace, name, identity)
+				err := run(ctx)
+				if ctx.Err() == nil {
+					// run stopped on its own; renewing the lease would keep
+					// standbys from taking over
+					runErr <- err
+					stopLeading()
+				}
+			},
+			OnStoppedLeading: func() {
+				log.Printf("Stopped leading lease %s/%s", namespace, name)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					log.Printf("Standing by: %s is the leader (lease %s/%s)", leader, namespace, name)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("configuring leader election: %w", err)
+	}
+
+	return func(ctx context.Context) error {
+		log.Printf("Waiting for leader election lease %s/%s as %s", namespace, name, identity)
+		electionCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stopLeading = cancel
+		elector.Run(electionCtx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		select {
+		case err := <-runErr:
+			return err
+		default:
+		}
+		return fmt.Errorf("lost leader election lease %s/%s", namespace, name)
+	}, nil
+}
+
+// leaseNamespace returns the namespace for the leader election or shard
+// membership leases: the given one, the provider pod's own namespace when
+// running in a cluster, or "default".
+func leaseNamespace(namespace string) string {
+	if namespace != "" {
+		return namespace
+	}
+	if data, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		if ns := strings.TrimSpace(string(data)); ns != "" {
+			return ns
+		}
+	}
+	return "default"
+}
+
+// leaderElectionIdentity identifies this replica in the lease: the pod
+// name (the hostname in a cluster) with its start time, so a restarted
+// replica is not mistaken for its previous run.
+func leaderElectionIdentity() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("getting hostname for the leader election identity: %w", err)
+	}
+	return fmt.Sprintf("%s_%d", hostname, time.Now().UnixNano()), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// shortLeases speeds up leader election for a test.
+func shortLeases(t *testing.T) {
+	t.Helper()
+	saved := []time.Duration{leaseDuration, renewDeadline, retryPeriod}
+	leaseDuration, renewDeadline, retryPeriod = time.Second, 500*time.Millisecond, 100*time.Millisecond
+	t.Cleanup(func() { leaseDuration, renewDeadline, retryPeriod = saved[0], saved[1], saved[2] })
+}
+
+func leaseHolder(t *testing.T, client *fake.Clientset) string {
+	t.Helper()
+	lease, err := client.CoordinationV1().Leases("default").Get(context.Background(), "vk", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting lease: %v", err)
+	}
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+// runElected starts the elected function and returns its result channel.
+func runElected(t *testing.T, client *fake.Clientset, run func(context.Context) error) <-chan error {
+	t.Helper()
+	elected, err := leaderElected(client, "default", "vk", "replica-1", run)
+	if err != nil {
+		t.Fatalf("leaderElected: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	result := make(chan error, 1)
+	go func() { result <- elected(ctx) }()
+	return result
+}
+
+func waitResult(t *testing.T, result <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the elected function to return")
+		return nil
+	}
+}
+
+func TestLeaderElectedReleasesLeaseWhenRunFails(t *testing.T) {
+	shortLeases(t)
+	client := fake.NewSimpleClientset()
+
+	failed := errors.New("provider failed")
+	result := runElected(t, client, func(context.Context) error { return failed })
+
+	if err := waitResult(t, result); !errors.Is(err, failed) {
+		t.Fatalf("error = %v, want the run error", err)
+	}
+	// A standby takes over right away rather than after the lease expires
+	if holder := leaseHolder(t, client); holder != "" {
+		t.Errorf("lease holder = %q, want the lease released", holder)
+	}
+}
+
+func TestLeaderElectedStopsWhenLeadershipIsLost(t *testing.T) {
+	shortLeases(t)
+	client := fake.NewSimpleClientset()
+
+	leading := make(chan struct{})
+	stopped := make(chan struct{})
+	result := runElected(t, client, func(ctx context.Context) error {
+		close(leading)
+		<-ctx.Done()
+		close(stopped)
+		return nil
+	})
+	select {
+	case <-leading:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting to lead")
+	}
+
+	// Another replica takes the lease over
+	ctx := context.Background()
+	lease, err := client.CoordinationV1().Leases("default").Get(ctx, "vk", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting lease: %v", err)
+	}
+	other := "replica-2"
+	seconds := int32(60)
+	lease.Spec = coordinationv1.LeaseSpec{
+		HolderIdentity:       &other,
+		LeaseDurationSeconds: &seconds,
+		AcquireTime:          &metav1.MicroTime{Time: time.Now()},
+		RenewTime:            &metav1.MicroTime{Time: time.Now()},
+	}
+	if _, err := client.CoordinationV1().Leases("default").Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating lease: %v", err)
+	}
+
+	if err := waitResult(t, result); err == nil || !strings.Contains(err.Error(), "lost leader election lease") {
+		t.Fatalf("error = %v, want leadership lost", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("run was not stopped after leadership was lost")
+	}
+}
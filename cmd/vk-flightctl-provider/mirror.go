@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"k8s.io/client-go/dynamic"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/devicemirror"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+)
+
+// withDeviceMirrors returns run extended to mirror the devices of each
+// endpoint into --device-mirror-namespace while it runs, so only the leader
+// writes them, and a func closing the mirrors' FlightCtl clients.
+func withDeviceMirrors(opts *options, endpoints []endpointConfig, dynamicClient dynamic.Interface, run func(context.Context) error) (func(context.Context) error, func(), error) {
+	var mirrors []*devicemirror.Mirror
+	var clients []*flightctl.Client
+	closeClients := func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	}
+	store := devicemirror.NewCRStore(dynamicClient)
+	for _, endpoint := range endpoints {
+		client, err := flightctl.NewClient(endpoint.cfg.FlightctlConfig())
+		if err != nil {
+			closeClients()
+			return nil, nil, fmt.Errorf("creating Flightctl client for the device mirror: %w", err)
+		}
+		clients = append(clients, client)
+		mirrors = append(mirrors, devicemirror.New(client, store, opts.deviceMirrorNamespace, endpoint.name, opts.deviceMirrorInterval))
+	}
+	log.Printf("Mirroring FlightCtl devices into namespace %s every %s", opts.deviceMirrorNamespace, opts.deviceMirrorInterval)
+
+	return func(ctx context.Context) error {
+		ctx, cancel := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		defer cancel()
+		for _, mirror := range mirrors {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				mirror.Run(ctx)
+			}()
+		}
+		return run(ctx)
+	}, closeClients, nil
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/provider"
+)
+
+const (
+	// fleetNodePrefix prefixes the fleet name in per-fleet node names.
+	fleetNodePrefix = "fleet-"
+)
+
+// nodeTarget is a device or fleet that gets its own virtual node.
+type nodeTarget struct {
+	// nodeName is the name of the virtual node.
+	nodeName string
+	// pin configures a provider for the target.
+	pin func(cfg *provider.Config)
+	// refresh passes the latest state of the target to its provider.
+	refresh func(p *provider.Provider)
+}
+
+// deviceTarget returns the node target for a device, named after it.
+func deviceTarget(device *models.Device) nodeTarget {
+	return nodeTarget{
+		nodeName: device.ID,
+		pin:      func(cfg *provider.Config) { cfg.DeviceID = device.ID },
+		refresh:  func(p *provider.Provider) { p.SetDevice(device) },
+	}
+}
+
+// fleetTarget returns the node target for a fleet, named fleet-<name>.
+func fleetTarget(fleet *models.Fleet, devices []*models.Device) nodeTarget {
+	return nodeTarget{
+		nodeName: fleetNodePrefix + fleet.ID,
+		pin:      func(cfg *provider.Config) { cfg.FleetID = fleet.ID },
+		refresh:  func(p *provider.Provider) { p.SetFleet(fleet, devices) },
+	}
+}
+
+// nodeSetController runs one virtual node per FlightCtl device or fleet. It
+// discovers the targets periodically, starts a node for each new one,
+// refreshes the labels, capacity and readiness of existing ones, and stops
+// and deletes the nodes of targets that are gone.
+type nodeSetController struct {
+	cfg       provider.Config
+	client    *flightctl.Client
+	k8sClient kubernetes.Interface
+	podConfig *podConfigWatcher
+	mode      string
+	selector  map[string]string
+	interval  time.Duration
+	shard     *shardMembership // nil runs nodes for all targets
+	// namePrefix prefixes the node names, keeping apart the nodes of
+	// several FlightCtl endpoints
+	namePrefix string
+
+	mu       sync.Mutex
+	nodes    map[string]*virtualNode // node name -> node
+	tunables provider.Tunables
+	synced   bool
+	stopped  bool
+}
+
+// virtualNode is a running virtual node for one target.
+type virtualNode struct {
+	provider   *provider.Provider
+	cancel     context.CancelFunc
+	done       chan struct{}
+	stopEvents func()
+}
+
+// sharedClient hides the Close method of the FlightCtl client shared by all
+// nodes, so shutting down one node's provider does not close it.
+type sharedClient struct {
+	flightctl.FlightctlClient
+}
+
+// CachedDevices describes the device reads cached by the shared client.
+func (c sharedClient) CachedDevices() []flightctl.CachedDevice {
+	if client, ok := c.FlightctlClient.(*flightctl.Client); ok {
+		return client.CachedDevices()
+	}
+	return nil
+}
+
+// newNodeSetController creates a controller for the per-device or per-fleet
+// node mode. selector filters devices by label in per-device mode and
+// fleets by label in per-fleet mode.
+func newNodeSetController(mode string, cfg provider.Config, client *flightctl.Client, k8sClient kubernetes.Interface, podConfig *podConfigWatcher, selector map[string]string) *nodeSetController {
+	return &nodeSetController{
+		cfg:       cfg,
+		client:    client,
+		k8sClient: k8sClient,
+		podConfig: podConfig,
+		mode:      mode,
+		selector:  selector,
+		interval:  cfg.DeviceRefreshInterval,
+		nodes:     make(map[string]*virtualNode),
+		tunables:  cfg.Tunables(),
+	}
+}
+
+// Run discovers targets until ctx is done.
+func (c *nodeSetController) Run(ctx context.Context) error {
+	logger.Info("Running one virtual node %s (selector=%v), discovering every %s",
+		c.mode, c.selector, c.interval)
+
+	if c.shard != nil {
+		logger.Info("Sharing targets with shard group %s as %s", c.shard.group, c.shard.identity)
+		go c.shard.run(ctx)
+	}
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.discover(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// discoverTargets lists the devices or fleets that should have a node.
+func (c *nodeSetController) discoverTargets(ctx context.Context) ([]nodeTarget, error) {
+	if c.mode == nodeModePerFleet {
+		fleets, err := c.client.ListFleets(ctx)
+		if err != nil {
+			return nil, err
+		}
+		devices, err := c.client.ListDevices(ctx, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		byFleet := make(map[string][]*models.Device)
+		for _, device := range devices {
+			byFleet[device.FleetID] = append(byFleet[device.FleetID], device)
+		}
+
+		var targets []nodeTarget
+		for _, fleet := range fleets {
+			if matchesLabels(fleet.Labels, c.selector) {
+				targets = append(targets, fleetTarget(fleet, byFleet[fleet.ID]))
+			}
+		}
+		return targets, nil
+	}
+
+	devices, err := c.client.ListDevices(ctx, c.cfg.DefaultFleet, c.selector)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]nodeTarget, 0, len(devices))
+	for _, device := range devices {
+		targets = append(targets, deviceTarget(device))
+	}
+	return targets, nil
+}
+
+// discover reconciles the running nodes with the discovered targets.
+func (c *nodeSetController) discover(ctx context.Context) {
+	targets, err := c.discoverTargets(ctx)
+	if err != nil {
+		logger.Warn("Node discovery failed, keeping %d node(s): %v", c.nodeCount(), err)
+		return
+	}
+	for i := range targets {
+		targets[i].nodeName = c.namePrefix + targets[i].nodeName
+	}
+
+	// Targets owned by another member of the shard group are handed off:
+	// their nodes are stopped without deleting them, so the new owner
+	// takes them over with their pods
+	handedOff := make(map[string]bool)
+	if c.shard != nil {
+		members, err := c.shard.members(ctx)
+		if err != nil {
+			logger.Warn("Shard membership unknown, keeping %d node(s): %v", c.nodeCount(), err)
+			return
+		}
+		targets, handedOff = c.ownTargets(targets, members)
+	}
+
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return
+	}
+	running := make([]string, 0, len(c.nodes))
+	for name := range c.nodes {
+		running = append(running, name)
+	}
+	c.mu.Unlock()
+
+	added, kept, removed := diffTargets(running, targets)
+	for _, name := range removed {
+		if handedOff[name] {
+			c.handOffNode(name)
+		} else {
+			c.removeNode(ctx, name)
+		}
+	}
+	for _, target := range kept {
+		c.mu.Lock()
+		node := c.nodes[target.nodeName]
+		c.mu.Unlock()
+		if node != nil {
+			target.refresh(node.provider)
+		}
+	}
+	for _, target := range added {
+		if err := c.startNode(ctx, target); err != nil {
+			logger.Error("Starting node %s: %v", target.nodeName, err)
+		}
+	}
+
+	c.mu.Lock()
+	c.synced = true
+	c.mu.Unlock()
+}
+
+// diffTargets splits the discovered targets into those that need a new
+// node and those whose node is already running, and returns the running
+// nodes whose target is gone. Targets whose node name is invalid are
+// skipped.
+func diffTargets(running []string, targets []nodeTarget) (added, kept []nodeTarget, removed []string) {
+	isRunning := make(map[string]bool, len(running))
+	for _, name := range running {
+		isRunning[name] = true
+	}
+
+	seen := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		if errs := validation.IsDNS1123Subdomain(target.nodeName); len(errs) > 0 {
+			logger.Warn("Skipping %s: not a valid node name: %v", target.nodeName, errs)
+			continue
+		}
+		seen[target.nodeName] = true
+		if isRunning[target.nodeName] {
+			kept = append(kept, target)
+		} else {
+			added = append(added, target)
+		}
+	}
+
+	for _, name := range running {
+		if !seen[name] {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	return added, kept, removed
+}
+
+// matchesLabels reports whether labels contain every selector entry.
+func matchesLabels(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// startNode creates a provider pinned to the target and runs its node.
+func (c *nodeSetController) startNode(ctx context.Context, target nodeTarget) error {
+	c.mu.Lock()
+	cfg := c.cfg
+	tunables := c.tunables
+	c.mu.Unlock()
+
+	cfg.NodeName = target.nodeName
+	target.pin(&cfg)
+	cfg.ReconcileInterval = tunables.ReconcileInterval
+	cfg.ReconcileJitter = tunables.ReconcileJitter
+	cfg.DisconnectCheckInterval = tunables.DisconnectCheckInterval
+	cfg.DeviceReconnectTimeout = tunables.DeviceReconnectTimeout
+	cfg.DeploymentReadyTimeout = tunables.DeploymentReadyTimeout
+
+	p, err := provider.NewProviderWithClient(cfg, sharedClient{c.client})
+	if err != nil {
+		return fmt.Errorf("creating provider: %w", err)
+	}
+	target.refresh(p)
+
+	nodeRunner, stopEvents, err := newVirtualNode(cfg.NodeName, p, c.k8sClient, c.podConfig, nil)
+	if err != nil {
+		p.Shutdown()
+		return err
+	}
+
+	nodeCtx, cancel := context.WithCancel(ctx)
+	node := &virtualNode{provider: p, cancel: cancel, done: make(chan struct{}), stopEvents: stopEvents}
+
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		cancel()
+		p.Shutdown()
+		stopEvents()
+		return nil
+	}
+	c.nodes[target.nodeName] = node
+	c.mu.Unlock()
+
+	go func() {
+		defer close(node.done)
+		err := nodeRunner.Run(nodeCtx)
+		if nodeCtx.Err() != nil {
+			return
+		}
+		// Forget the node so the next discovery starts it again
+		logger.Error("Node %s stopped unexpectedly: %v", target.nodeName, err)
+		c.mu.Lock()
+		if c.nodes[target.nodeName] == node {
+			delete(c.nodes, target.nodeName)
+		}
+		c.mu.Unlock()
+		node.stop()
+	}()
+
+	logger.Info("Started virtual node %s", target.nodeName)
+	return nil
+}
+
+// removeNode stops the node of a target that is gone and deletes the Node
+// object; the pods bound to it are then garbage collected by Kubernetes.
+func (c *nodeSetController) removeNode(ctx context.Context, name string) {
+	c.mu.Lock()
+	node := c.nodes[name]
+	delete(c.nodes, name)
+	c.mu.Unlock()
+	if node == nil {
+		return
+	}
+
+	logger.Info("Target of node %s is gone, removing the node", name)
+	node.cancel()
+	<-node.done
+	node.stop()
+
+	err := c.k8sClient.CoreV1().Nodes().Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		logger.Error("Deleting node %s: %v", name, err)
+	}
+}
+
+// handOffNode stops the node of a target now owned by another member of the
+// shard group, leaving the Node object to the new owner.
+func (c *nodeSetController) handOffNode(name string) {
+	c.mu.Lock()
+	node := c.nodes[name]
+	delete(c.nodes, name)
+	c.mu.Unlock()
+	if node == nil {
+		return
+	}
+
+	logger.Info("Node %s moved to another shard, stopping it", name)
+	node.cancel()
+	<-node.done
+	node.stop()
+}
+
+// ownTargets splits targets into those this instance owns among the shard
+// members and the names of those owned by others.
+func (c *nodeSetController) ownTargets(targets []nodeTarget, members []string) ([]nodeTarget, map[string]bool) {
+	owned := make([]nodeTarget, 0, len(targets))
+	others := make(map[string]bool)
+	for _, target := range targets {
+		if shardOwner(target.nodeName, members) == c.shard.identity {
+			owned = append(owned, target)
+		} else {
+			others[target.nodeName] = true
+		}
+	}
+	logger.Debug("Shard group %s has %d member(s); %d of %d target(s) owned", c.shard.group, len(members), len(owned), len(targets))
+	return owned, others
+}
+
+func (n *virtualNode) stop() {
+	n.provider.Shutdown()
+	n.stopEvents()
+}
+
+// UpdateTunables applies reloaded runtime settings to all nodes and to the
+// nodes started later.
+func (c *nodeSetController) UpdateTunables(t provider.Tunables) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.tunables = t
+	nodes := make([]*virtualNode, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		nodes = append(nodes, node)
+	}
+	c.mu.Unlock()
+
+	for _, node := range nodes {
+		if err := node.provider.UpdateTunables(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSynced is a readiness check that passes once targets were discovered.
+func (c *nodeSetController) checkSynced(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.synced {
+		return fmt.Errorf("%s nodes not discovered yet", c.mode)
+	}
+	return nil
+}
+
+func (c *nodeSetController) nodeCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.nodes)
+}
+
+// Shutdown stops all nodes without deleting them, so they are picked up
+// again after a restart, and closes the FlightCtl client.
+func (c *nodeSetController) Shutdown() {
+	_ = c.Drain(context.Background())
+}
+
+// Drain stops all nodes without deleting them, drains their providers and
+// closes the FlightCtl client. Nodes still stopping when ctx is done are
+// shut down without waiting for them.
+func (c *nodeSetController) Drain(ctx context.Context) error {
+	c.mu.Lock()
+	c.stopped = true
+	nodes := c.nodes
+	c.nodes = make(map[string]*virtualNode)
+	c.mu.Unlock()
+	defer c.client.Close()
+
+	for _, node := range nodes {
+		node.cancel()
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(nodes))
+	for name, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer node.stopEvents()
+			select {
+			case <-node.done:
+			case <-ctx.Done():
+			}
+			if err := node.provider.Drain(ctx); err != nil {
+				errs <- fmt.Errorf("draining node %s: %w", name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var all []error
+	for err := range errs {
+		all = append(all, err)
+	}
+	return errors.Join(all...)
+}
+
+// debugState returns the state of the running nodes' providers, sorted by
+// node name.
+func (c *nodeSetController) debugState() []provider.DebugState {
+	c.mu.Lock()
+	providers := make([]*provider.Provider, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		providers = append(providers, node.provider)
+	}
+	c.mu.Unlock()
+
+	states := make([]provider.DebugState, 0, len(providers))
+	for _, p := range providers {
+		states = append(states, p.DebugState())
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].NodeName < states[j].NodeName })
+	return states
+}
+
+// nodeNames returns the names of the running nodes.
+func (c *nodeSetController) nodeNames() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.nodes))
+	for name := range c.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
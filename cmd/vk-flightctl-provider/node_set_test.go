@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+func TestDiffTargets(t *testing.T) {
+	targets := []nodeTarget{
+		deviceTarget(&models.Device{ID: "device-1"}),
+		deviceTarget(&models.Device{ID: "device-3"}),
+		deviceTarget(&models.Device{ID: "Not_A_Node_Name"}),
+		fleetTarget(&models.Fleet{ID: "factory-a"}, nil),
+	}
+
+	added, kept, removed := diffTargets([]string{"device-2", "device-1"}, targets)
+
+	names := func(targets []nodeTarget) []string {
+		var out []string
+		for _, target := range targets {
+			out = append(out, target.nodeName)
+		}
+		return out
+	}
+	if got := names(added); !reflect.DeepEqual(got, []string{"device-3", "fleet-factory-a"}) {
+		t.Errorf("added = %v, want [device-3 fleet-factory-a]", got)
+	}
+	if got := names(kept); !reflect.DeepEqual(got, []string{"device-1"}) {
+		t.Errorf("kept = %v, want [device-1]", got)
+	}
+	if !reflect.DeepEqual(removed, []string{"device-2"}) {
+		t.Errorf("removed = %v, want [device-2]", removed)
+	}
+}
+
+func TestMatchesLabels(t *testing.T) {
+	labels := map[string]string{"site": "galway", "tier": "edge"}
+	if !matchesLabels(labels, nil) || !matchesLabels(labels, map[string]string{"site": "galway"}) {
+		t.Error("expected labels to match")
+	}
+	if matchesLabels(labels, map[string]string{"site": "cork"}) {
+		t.Error("expected a mismatch for a different value")
+	}
+}
@@ -0,0 +1,885 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/audit"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/devicemirror"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/provider"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/redact"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/registry"
+)
+
+// options holds the settings shared by all commands. Every flag defaults to
+// its environment variable, so flags override the environment.
+type options struct {
+	configFile string
+
+	nodeName              string
+	flightctlAPIURL       string
+	flightctlClientID     string
+	flightctlClientSecret string
+	flightctlTokenURL     string
+	flightctlAuthMode     string
+	flightctlAuthToken    string
+	flightctlRefreshToken string
+	flightctlTokenFile    string
+	flightctlClientCert   string
+	flightctlClientKey    string
+	flightctlCAFile       string
+	flightctlCAData       string
+	flightctlInsecureTLS  bool
+
+	flightctlEndpointsFile string
+
+	defaultAppType         string
+	composeFile            string
+	appNaming              string
+	disconnectAction       string
+	deviceReconnectTimeout time.Duration
+	offlineDeploymentTTL   time.Duration
+	deploymentReadyTimeout time.Duration
+	completedPodRetention  time.Duration
+	terminatedPodRetention time.Duration
+	defaultFleet           string
+	nodeLabels             map[string]string
+	nodeAnnotations        map[string]string
+	nodeTaints             string
+	topologyLabels         map[string]string
+	deviceLabelPrefixes    []string
+	deviceLabelConflicts   string
+	providerIDFormat       string
+	podValidation          string
+	translationPreview     string
+	denyPrivileged         []string
+	deviceAccessPolicy     string
+	namespaceCPUQuota      map[string]string
+	namespaceMemoryQuota   map[string]string
+	resolveImageDigests    bool
+	nodeExtendedResources  map[string]string
+	logCacheSize           string
+
+	cpuOvercommit     float64
+	memoryOvercommit  float64
+	placementStrategy string
+	deviceScorers     map[string]string
+	minDeviceBattery  float64
+	maxDeviceTemp     float64
+
+	nodeMode              string
+	deviceSelector        map[string]string
+	fleetSelector         map[string]string
+	nodeDiscoveryInterval time.Duration
+
+	reconcileInterval       time.Duration
+	reconcileJitter         time.Duration
+	reconcileWorkers        int
+	podWorkers              int
+	disconnectCheckInterval time.Duration
+	informerResyncPeriod    time.Duration
+	nodeHeartbeatInterval   time.Duration
+
+	reconcileHistorySize int
+	reconcileAuditLog    bool
+
+	retryMaxAttempts int
+	retryBaseDelay   time.Duration
+	retryMaxDelay    time.Duration
+
+	breakerThreshold     int
+	breakerCooldown      time.Duration
+	apiOutageNodeTimeout time.Duration
+
+	readTimeout  time.Duration
+	listTimeout  time.Duration
+	writeTimeout time.Duration
+
+	enrollmentCheckInterval time.Duration
+	enrollmentEvents        bool
+
+	deviceCacheTTL time.Duration
+
+	flightctlRecordFile string
+	flightctlReplayFile string
+	dryRun              bool
+	podMappingCRs       bool
+	keepOrphanedApps    bool
+
+	deviceMirrorNamespace string
+	deviceMirrorInterval  time.Duration
+
+	drainTimeout     time.Duration
+	cordonOnShutdown bool
+
+	leaderElect             bool
+	leaderElectionNamespace string
+	leaderElectionID        string
+
+	shardGroup     string
+	shardNamespace string
+
+	kubeletAPIAddr     string
+	nodeIP             string
+	kubeletAPICert     string
+	kubeletAPIKey      string
+	kubeletAPIClientCA string
+
+	kubeconfig      string
+	healthProbeAddr string
+	debugAddr       string
+	webhookAddr     string
+	webhookCertFile string
+	webhookKeyFile  string
+	logLevel        string
+	logFormat       string
+	logRedactEnv    string
+	logRedactFiles  string
+
+	// flags and explicitFlags are recorded by loadConfigFile so the file can
+	// be re-applied on reload without overriding the command line.
+	flags         *pflag.FlagSet
+	explicitFlags map[string]bool
+
+	// envErrs collects the environment variables addFlags could not parse;
+	// envError reports them once the command runs.
+	envErrs []error
+}
+
+// flagEnvVars maps each flag to the environment variable that sets its
+// default. Config file values are ignored for flags whose variable is set.
+var flagEnvVars = map[string]string{
+	"config":                       "CONFIG_FILE",
+	"node-name":                    "NODE_NAME",
+	"node-labels":                  "NODE_LABELS",
+	"node-annotations":             "NODE_ANNOTATIONS",
+	"node-taints":                  "NODE_TAINTS",
+	"topology-labels":              "TOPOLOGY_LABELS",
+	"device-label-prefixes":        "DEVICE_LABEL_PREFIXES",
+	"device-label-conflicts":       "DEVICE_LABEL_CONFLICTS",
+	"node-provider-id":             "NODE_PROVIDER_ID",
+	"pod-validation":               "POD_VALIDATION",
+	"translation-preview":          "TRANSLATION_PREVIEW",
+	"node-mode":                    "NODE_MODE",
+	"device-selector":              "DEVICE_SELECTOR",
+	"fleet-selector":               "FLEET_SELECTOR",
+	"node-discovery-interval":      "NODE_DISCOVERY_INTERVAL",
+	"flightctl-api-url":            "FLIGHTCTL_API_URL",
+	"flightctl-client-id":          "FLIGHTCTL_CLIENT_ID",
+	"flightctl-client-secret":      "FLIGHTCTL_CLIENT_SECRET",
+	"flightctl-token-url":          "FLIGHTCTL_TOKEN_URL",
+	"flightctl-auth-mode":          "FLIGHTCTL_AUTH_MODE",
+	"flightctl-auth-token":         "FLIGHTCTL_AUTH_TOKEN",
+	"flightctl-refresh-token":      "FLIGHTCTL_REFRESH_TOKEN",
+	"flightctl-token-file":         "FLIGHTCTL_TOKEN_FILE",
+	"flightctl-client-cert":        "FLIGHTCTL_CLIENT_CERT_FILE",
+	"flightctl-client-key":         "FLIGHTCTL_CLIENT_KEY_FILE",
+	"flightctl-ca-file":            "FLIGHTCTL_CA_FILE",
+	"flightctl-ca-data":            "FLIGHTCTL_CA_DATA",
+	"flightctl-insecure-tls":       "FLIGHTCTL_INSECURE_TLS",
+	"flightctl-endpoints-file":     "FLIGHTCTL_ENDPOINTS_FILE",
+	"default-app-type":             "FLIGHTCTL_DEFAULT_APP_TYPE",
+	"compose-file":                 "FLIGHTCTL_COMPOSE_FILE",
+	"app-naming":                   "FLIGHTCTL_APP_NAMING",
+	"default-fleet":                "FLIGHTCTL_DEFAULT_FLEET",
+	"device-disconnect-action":     "DEVICE_DISCONNECT_ACTION",
+	"device-reconnect-timeout":     "DEVICE_RECONNECT_TIMEOUT",
+	"offline-deployment-ttl":       "OFFLINE_DEPLOYMENT_TTL",
+	"deployment-ready-timeout":     "DEPLOYMENT_READY_TIMEOUT",
+	"completed-pod-retention":      "COMPLETED_POD_RETENTION",
+	"terminated-pod-retention":     "TERMINATED_POD_RETENTION",
+	"deny-privileged-namespaces":   "DENY_PRIVILEGED_NAMESPACES",
+	"namespace-device-policy":      "NAMESPACE_DEVICE_POLICY_FILE",
+	"namespace-cpu-quota":          "NAMESPACE_CPU_QUOTA",
+	"namespace-memory-quota":       "NAMESPACE_MEMORY_QUOTA",
+	"resolve-image-digests":        "RESOLVE_IMAGE_DIGESTS",
+	"node-extended-resources":      "NODE_EXTENDED_RESOURCES",
+	"log-cache-size":               "LOG_CACHE_SIZE",
+	"cpu-overcommit-ratio":         "CPU_OVERCOMMIT_RATIO",
+	"memory-overcommit-ratio":      "MEMORY_OVERCOMMIT_RATIO",
+	"placement-strategy":           "PLACEMENT_STRATEGY",
+	"device-scorers":               "DEVICE_SCORERS",
+	"min-device-battery":           "MIN_DEVICE_BATTERY",
+	"max-device-temperature":       "MAX_DEVICE_TEMPERATURE",
+	"reconcile-interval":           "RECONCILE_INTERVAL",
+	"reconcile-jitter":             "RECONCILE_JITTER",
+	"reconcile-workers":            "RECONCILE_WORKERS",
+	"pod-workers":                  "POD_WORKERS",
+	"disconnect-check-interval":    "DISCONNECT_CHECK_INTERVAL",
+	"informer-resync-period":       "INFORMER_RESYNC_PERIOD",
+	"node-heartbeat-interval":      "NODE_HEARTBEAT_INTERVAL",
+	"reconcile-history-size":       "RECONCILE_HISTORY_SIZE",
+	"reconcile-audit-log":          "RECONCILE_AUDIT_LOG",
+	"flightctl-retry-max-attempts": "FLIGHTCTL_RETRY_MAX_ATTEMPTS",
+	"flightctl-retry-base-delay":   "FLIGHTCTL_RETRY_BASE_DELAY",
+	"flightctl-retry-max-delay":    "FLIGHTCTL_RETRY_MAX_DELAY",
+	"flightctl-breaker-threshold":  "FLIGHTCTL_BREAKER_THRESHOLD",
+	"flightctl-breaker-cooldown":   "FLIGHTCTL_BREAKER_COOLDOWN",
+	"flightctl-read-timeout":       "FLIGHTCTL_READ_TIMEOUT",
+	"flightctl-list-timeout":       "FLIGHTCTL_LIST_TIMEOUT",
+	"flightctl-write-timeout":      "FLIGHTCTL_WRITE_TIMEOUT",
+	"api-outage-node-timeout":      "API_OUTAGE_NODE_TIMEOUT",
+	"enrollment-check-interval":    "ENROLLMENT_CHECK_INTERVAL",
+	"enrollment-events":            "ENROLLMENT_EVENTS",
+	"flightctl-device-cache-ttl":   "FLIGHTCTL_DEVICE_CACHE_TTL",
+	"flightctl-record-file":        "FLIGHTCTL_RECORD_FILE",
+	"flightctl-replay-file":        "FLIGHTCTL_REPLAY_FILE",
+	"dry-run":                      "DRY_RUN",
+	"pod-mapping-crs":              "POD_MAPPING_CRS",
+	"keep-orphaned-apps":           "KEEP_ORPHANED_APPS",
+	"device-mirror-namespace":      "DEVICE_MIRROR_NAMESPACE",
+	"device-mirror-interval":       "DEVICE_MIRROR_INTERVAL",
+	"drain-timeout":                "DRAIN_TIMEOUT",
+	"cordon-on-shutdown":           "CORDON_ON_SHUTDOWN",
+	"leader-elect":                 "LEADER_ELECT",
+	"leader-election-namespace":    "LEADER_ELECTION_NAMESPACE",
+	"leader-election-id":           "LEADER_ELECTION_ID",
+	"shard-group":                  "SHARD_GROUP",
+	"shard-namespace":              "SHARD_NAMESPACE",
+	"kubelet-api-addr":             "KUBELET_API_ADDR",
+	"node-ip":                      "NODE_IP",
+	"kubelet-api-cert":             "KUBELET_API_CERT_FILE",
+	"kubelet-api-key":              "KUBELET_API_KEY_FILE",
+	"kubelet-api-client-ca":        "KUBELET_API_CLIENT_CA_FILE",
+	"kubeconfig":                   "KUBECONFIG",
+	"health-probe-addr":            "HEALTH_PROBE_ADDR",
+	"debug-addr":                   "DEBUG_ADDR",
+	"webhook-addr":                 "WEBHOOK_ADDR",
+	"webhook-cert-file":            "WEBHOOK_CERT_FILE",
+	"webhook-key-file":             "WEBHOOK_KEY_FILE",
+	"log-level":                    "LOG_LEVEL",
+	"log-format":                   "LOG_FORMAT",
+	"log-redact-env":               "LOG_REDACT_ENV",
+	"log-redact-files":             "LOG_REDACT_FILES",
+}
+
+// addFlags registers the flags, with defaults taken from the environment.
+func (o *options) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.configFile, "config", os.Getenv("CONFIG_FILE"),
+		"YAML or JSON file with settings keyed by flag name; environment variables and flags take precedence [CONFIG_FILE]")
+
+	fs.StringVar(&o.nodeName, "node-name", getEnvOrDefault("NODE_NAME", "vk-flightctl-node"),
+		"Name of the virtual node [NODE_NAME]")
+	fs.StringToStringVar(&o.nodeLabels, "node-labels", o.getEnvStringMap("NODE_LABELS"),
+		"Extra labels for the virtual node, as key=value pairs [NODE_LABELS]")
+	fs.StringToStringVar(&o.nodeAnnotations, "node-annotations", o.getEnvStringMap("NODE_ANNOTATIONS"),
+		"Extra annotations for the virtual node, as key=value pairs [NODE_ANNOTATIONS]")
+	fs.StringVar(&o.nodeTaints, "node-taints", getEnvOrDefault("NODE_TAINTS", provider.DefaultNodeTaint.ToString()),
+		"Taints of the virtual node, as comma-separated key[=value]:Effect entries; none for an untainted node [NODE_TAINTS]")
+	fs.StringToStringVar(&o.topologyLabels, "topology-labels", o.getEnvStringMap("TOPOLOGY_LABELS"),
+		"Topology labels of device and fleet nodes, as topology-key=device-label pairs, also spreading pods with topologySpreadConstraints across devices (default topology.kubernetes.io/region=region,topology.kubernetes.io/zone=zone) [TOPOLOGY_LABELS]")
+	fs.StringSliceVar(&o.deviceLabelPrefixes, "device-label-prefixes", getEnvStringSlice("DEVICE_LABEL_PREFIXES"),
+		"Prefixes of the device labels copied onto the single node and fleet nodes when their devices agree on the value, e.g. site.example.com/; device nodes copy all their device's labels [DEVICE_LABEL_PREFIXES]")
+	fs.StringVar(&o.deviceLabelConflicts, "device-label-conflicts", getEnvOrDefault("DEVICE_LABEL_CONFLICTS", provider.DeviceLabelConflictKeepNode),
+		"keep-node: device labels do not replace the node's own or configured labels; prefer-device: they do. kubernetes.io and k8s.io labels are never taken from devices [DEVICE_LABEL_CONFLICTS]")
+	fs.StringToStringVar(&o.nodeExtendedResources, "node-extended-resources", o.getEnvStringMap("NODE_EXTENDED_RESOURCES"),
+		"Extended resources the single virtual node advertises, as name=quantity pairs, e.g. nvidia.com/gpu=4; per-device and per-fleet nodes advertise those their devices declare [NODE_EXTENDED_RESOURCES]")
+	fs.StringVar(&o.providerIDFormat, "node-provider-id", os.Getenv("NODE_PROVIDER_ID"),
+		"spec.providerID of the virtual node, with {node}, {device} and {fleet} replaced, e.g. flightctl://{fleet}/{device} (default unset) [NODE_PROVIDER_ID]")
+	fs.StringVar(&o.nodeMode, "node-mode", getEnvOrDefault("NODE_MODE", nodeModeSingle),
+		"single: one virtual node for all devices; per-device: one node per device, named after it; per-fleet: one node per fleet, named fleet-<name> [NODE_MODE]")
+	fs.StringToStringVar(&o.deviceSelector, "device-selector", o.getEnvStringMap("DEVICE_SELECTOR"),
+		"Device labels (key=value pairs) selecting the devices that get a node in per-device mode [DEVICE_SELECTOR]")
+	fs.StringToStringVar(&o.fleetSelector, "fleet-selector", o.getEnvStringMap("FLEET_SELECTOR"),
+		"Fleet labels (key=value pairs) selecting the fleets that get a node in per-fleet mode [FLEET_SELECTOR]")
+	fs.DurationVar(&o.nodeDiscoveryInterval, "node-discovery-interval", o.getEnvDuration("NODE_DISCOVERY_INTERVAL", provider.DefaultDeviceRefreshInterval),
+		"How often devices or fleets are listed to add or remove nodes in per-device and per-fleet mode, and devices to refresh the labels of the single node [NODE_DISCOVERY_INTERVAL]")
+	fs.StringVar(&o.flightctlAPIURL, "flightctl-api-url", getEnvOrDefault("FLIGHTCTL_API_URL", "https://api.flightctl.apps.ocp-rh-aio1.waltoninstitute.ie/api/v1/"),
+		"FlightCtl API URL [FLIGHTCTL_API_URL]")
+	fs.StringVar(&o.flightctlClientID, "flightctl-client-id", os.Getenv("FLIGHTCTL_CLIENT_ID"),
+		"OAuth client ID [FLIGHTCTL_CLIENT_ID]")
+	// The secret is read from the environment after parsing so it never shows up in --help
+	fs.StringVar(&o.flightctlClientSecret, "flightctl-client-secret", "",
+		"OAuth client secret [FLIGHTCTL_CLIENT_SECRET]")
+	fs.StringVar(&o.flightctlTokenURL, "flightctl-token-url", getEnvOrDefault("FLIGHTCTL_TOKEN_URL", "https://auth.flightctl.apps.ocp-rh-aio1.waltoninstitute.ie/realms/flightctl/protocol/openid-connect/token"),
+		"OAuth token endpoint [FLIGHTCTL_TOKEN_URL]")
+	fs.StringVar(&o.flightctlAuthMode, "flightctl-auth-mode", getEnvOrDefault("FLIGHTCTL_AUTH_MODE", flightctl.AuthModeOAuth),
+		"FlightCtl authentication: oauth (client credentials), token (static bearer token), token-file, or none (client certificate only) [FLIGHTCTL_AUTH_MODE]")
+	// Like the client secret, the token is read from the environment after parsing
+	fs.StringVar(&o.flightctlAuthToken, "flightctl-auth-token", "",
+		"Static bearer token for auth mode token [FLIGHTCTL_AUTH_TOKEN]")
+	fs.StringVar(&o.flightctlRefreshToken, "flightctl-refresh-token", "",
+		"Initial OAuth refresh token for auth mode oauth, e.g. an offline token; the client secret is then optional [FLIGHTCTL_REFRESH_TOKEN]")
+	fs.StringVar(&o.flightctlTokenFile, "flightctl-token-file", os.Getenv("FLIGHTCTL_TOKEN_FILE"),
+		"File holding the bearer token for auth mode token-file, re-read when it changes [FLIGHTCTL_TOKEN_FILE]")
+	fs.StringVar(&o.flightctlClientCert, "flightctl-client-cert", os.Getenv("FLIGHTCTL_CLIENT_CERT_FILE"),
+		"Client certificate (PEM) for mutual TLS to the FlightCtl API, reloaded when it changes [FLIGHTCTL_CLIENT_CERT_FILE]")
+	fs.StringVar(&o.flightctlClientKey, "flightctl-client-key", os.Getenv("FLIGHTCTL_CLIENT_KEY_FILE"),
+		"Private key (PEM) for --flightctl-client-cert [FLIGHTCTL_CLIENT_KEY_FILE]")
+	fs.StringVar(&o.flightctlCAFile, "flightctl-ca-file", os.Getenv("FLIGHTCTL_CA_FILE"),
+		"CA bundle (PEM) used to verify the FlightCtl API instead of the system roots [FLIGHTCTL_CA_FILE]")
+	fs.StringVar(&o.flightctlCAData, "flightctl-ca-data", os.Getenv("FLIGHTCTL_CA_DATA"),
+		"Inline PEM CA bundle, added to --flightctl-ca-file [FLIGHTCTL_CA_DATA]")
+	fs.BoolVar(&o.flightctlInsecureTLS, "flightctl-insecure-tls", getEnvOrDefault("FLIGHTCTL_INSECURE_TLS", "false") == "true",
+		"Skip TLS verification of the FlightCtl API (testing only; prefer --flightctl-ca-file) [FLIGHTCTL_INSECURE_TLS]")
+	fs.StringVar(&o.flightctlEndpointsFile, "flightctl-endpoints-file", os.Getenv("FLIGHTCTL_ENDPOINTS_FILE"),
+		"YAML or JSON file listing several FlightCtl instances (name, URL, credentials, namespaces), each run as separate virtual nodes labeled flightctl.io/endpoint; replaces the --flightctl-api-url and credential flags [FLIGHTCTL_ENDPOINTS_FILE]")
+
+	fs.StringVar(&o.defaultAppType, "default-app-type", getEnvOrDefault("FLIGHTCTL_DEFAULT_APP_TYPE", "compose"),
+		"Application type for pods without a flightctl.io/app-type annotation [FLIGHTCTL_DEFAULT_APP_TYPE]")
+	fs.StringVar(&o.composeFile, "compose-file", getEnvOrDefault("FLIGHTCTL_COMPOSE_FILE", flightctl.DefaultComposeFile),
+		"Compose file name of compose applications, for pods without a flightctl.io/compose-file annotation [FLIGHTCTL_COMPOSE_FILE]")
+	fs.StringVar(&o.appNaming, "app-naming", getEnvOrDefault("FLIGHTCTL_APP_NAMING", string(flightctl.AppNamingPodName)),
+		"How pod applications are named: pod-name (<namespace>-<name>) or hashed (sanitized, truncated and suffixed with a hash of the pod's namespace, name and UID) [FLIGHTCTL_APP_NAMING]")
+	fs.StringVar(&o.defaultFleet, "default-fleet", os.Getenv("FLIGHTCTL_DEFAULT_FLEET"),
+		"Fleet for pods without device or fleet targeting (default: built-in default device) [FLIGHTCTL_DEFAULT_FLEET]")
+	fs.StringVar(&o.podValidation, "pod-validation", getEnvOrDefault("POD_VALIDATION", provider.PodValidationPermissive),
+		"Pods using features the devices do not support (volumes, probes, ...): permissive deploys them without, with a warning event and annotation; strict rejects them [POD_VALIDATION]")
+	fs.StringVar(&o.translationPreview, "translation-preview", getEnvOrDefault("TRANSLATION_PREVIEW", provider.TranslationPreviewAnnotations),
+		"How the application each pod was translated to is shown on the pod, with secrets masked: annotations sets its content hash and a truncated preview; configmap also writes it in full to a ConfigMap owned by the pod; none [TRANSLATION_PREVIEW]")
+	fs.StringSliceVar(&o.denyPrivileged, "deny-privileged-namespaces", getEnvStringSlice("DENY_PRIVILEGED_NAMESPACES"),
+		"Namespaces whose pods may not run privileged containers, or * for all [DENY_PRIVILEGED_NAMESPACES]")
+	fs.StringVar(&o.deviceAccessPolicy, "namespace-device-policy", os.Getenv("NAMESPACE_DEVICE_POLICY_FILE"),
+		"YAML file mapping namespaces to the fleets and device labels their pods may use; unset allows all devices [NAMESPACE_DEVICE_POLICY_FILE]")
+	fs.StringToStringVar(&o.namespaceCPUQuota, "namespace-cpu-quota", o.getEnvStringMap("NAMESPACE_CPU_QUOTA"),
+		"CPU the pods of each namespace may request on a node, as namespace=quantity pairs; * sets it for namespaces not listed [NAMESPACE_CPU_QUOTA]")
+	fs.StringToStringVar(&o.namespaceMemoryQuota, "namespace-memory-quota", o.getEnvStringMap("NAMESPACE_MEMORY_QUOTA"),
+		"Memory the pods of each namespace may request on a node, as namespace=quantity pairs; * sets it for namespaces not listed [NAMESPACE_MEMORY_QUOTA]")
+	fs.BoolVar(&o.resolveImageDigests, "resolve-image-digests", getEnvOrDefault("RESOLVE_IMAGE_DIGESTS", "false") == "true",
+		"Resolve image tags to digests with the registries when pods are first deployed, and deploy the images pinned to them [RESOLVE_IMAGE_DIGESTS]")
+	fs.StringVar(&o.logCacheSize, "log-cache-size", os.Getenv("LOG_CACHE_SIZE"),
+		"Size of the recent logs of each running container fetched while its device is reconciled, and served by kubectl logs while the device cannot be reached, e.g. 64Ki (default unset: no cache) [LOG_CACHE_SIZE]")
+	fs.Float64Var(&o.cpuOvercommit, "cpu-overcommit-ratio", o.getEnvFloat("CPU_OVERCOMMIT_RATIO", 1),
+		"CPU requests allowed on a device per CPU of capacity, e.g. 2 to place twice its capacity [CPU_OVERCOMMIT_RATIO]")
+	fs.Float64Var(&o.memoryOvercommit, "memory-overcommit-ratio", o.getEnvFloat("MEMORY_OVERCOMMIT_RATIO", 1),
+		"Memory requests allowed on a device per byte of capacity [MEMORY_OVERCOMMIT_RATIO]")
+	fs.StringVar(&o.placementStrategy, "placement-strategy", getEnvOrDefault("PLACEMENT_STRATEGY", string(models.PlacementMostFree)),
+		"How a device is picked from a fleet: most-free, bin-pack, spread, random or least-recently-used [PLACEMENT_STRATEGY]")
+	fs.StringToStringVar(&o.deviceScorers, "device-scorers", o.getEnvStringMap("DEVICE_SCORERS"),
+		"Weights of the device scorers picking the device a pod is placed on before the placement strategy, as scorer=weight pairs of free-cpu, free-memory, pod-count, temperature and battery; 0 disables a scorer (default unset: none) [DEVICE_SCORERS]")
+	fs.Float64Var(&o.minDeviceBattery, "min-device-battery", o.getEnvFloat("MIN_DEVICE_BATTERY", 0),
+		"Battery charge in percent below which devices get no new pods, unless pods set flightctl.io/ignore-power-limits; 0 disables the limit [MIN_DEVICE_BATTERY]")
+	fs.Float64Var(&o.maxDeviceTemp, "max-device-temperature", o.getEnvFloat("MAX_DEVICE_TEMPERATURE", 0),
+		"Temperature in degrees Celsius above which devices get no new pods, unless pods set flightctl.io/ignore-power-limits; 0 disables the limit [MAX_DEVICE_TEMPERATURE]")
+	fs.StringVar(&o.disconnectAction, "device-disconnect-action", getEnvOrDefault("DEVICE_DISCONNECT_ACTION", provider.DisconnectActionReschedule),
+		"Action for pods on devices that do not reconnect: reschedule or fail [DEVICE_DISCONNECT_ACTION]")
+	fs.DurationVar(&o.deviceReconnectTimeout, "device-reconnect-timeout", o.getEnvDuration("DEVICE_RECONNECT_TIMEOUT", 0),
+		"How long to wait for a disconnected device, 1m-30m (default 5m) [DEVICE_RECONNECT_TIMEOUT]")
+	fs.DurationVar(&o.offlineDeploymentTTL, "offline-deployment-ttl", o.getEnvDuration("OFFLINE_DEPLOYMENT_TTL", 0),
+		"Hold pods for offline devices until they reconnect, failing them if the device stays offline this long after the pod was created; 0 deploys them right away [OFFLINE_DEPLOYMENT_TTL]")
+	fs.DurationVar(&o.deploymentReadyTimeout, "deployment-ready-timeout", o.getEnvDuration("DEPLOYMENT_READY_TIMEOUT", provider.DefaultDeploymentReadyTimeout),
+		"How long a created or updated pod's application has to start running before it is rolled back and the pod failed, at least 1m [DEPLOYMENT_READY_TIMEOUT]")
+	fs.DurationVar(&o.completedPodRetention, "completed-pod-retention", o.getEnvDuration("COMPLETED_POD_RETENTION", provider.DefaultCompletedPodRetention),
+		"How long the applications of completed pods (restart policy Never or OnFailure) stay on their devices; negative keeps them until the pod is deleted [COMPLETED_POD_RETENTION]")
+	fs.DurationVar(&o.terminatedPodRetention, "terminated-pod-retention", o.getEnvDuration("TERMINATED_POD_RETENTION", provider.DefaultTerminatedPodRetention),
+		"How long succeeded or failed pods keep their status once their device stops reporting their application, and stay served after they are deleted; 0 disables it [TERMINATED_POD_RETENTION]")
+	fs.DurationVar(&o.reconcileInterval, "reconcile-interval", o.getEnvDuration("RECONCILE_INTERVAL", provider.DefaultReconcileInterval),
+		"How often pod status is refreshed from FlightCtl, with one read per device [RECONCILE_INTERVAL]")
+	fs.DurationVar(&o.reconcileJitter, "reconcile-jitter", o.getEnvDuration("RECONCILE_JITTER", 0),
+		"Longest random delay of a device's status refresh after each interval, less than the interval (default a tenth of it) [RECONCILE_JITTER]")
+	fs.IntVar(&o.reconcileWorkers, "reconcile-workers", o.getEnvInt("RECONCILE_WORKERS", provider.DefaultReconcileWorkers),
+		"How many devices' status is refreshed concurrently [RECONCILE_WORKERS]")
+	fs.IntVar(&o.podWorkers, "pod-workers", o.getEnvInt("POD_WORKERS", provider.DefaultPodWorkers),
+		"How many devices' pod deployments, updates and removals run concurrently [POD_WORKERS]")
+	fs.DurationVar(&o.disconnectCheckInterval, "disconnect-check-interval", o.getEnvDuration("DISCONNECT_CHECK_INTERVAL", provider.DefaultDisconnectCheckInterval),
+		"How often device connectivity is checked [DISCONNECT_CHECK_INTERVAL]")
+	fs.DurationVar(&o.informerResyncPeriod, "informer-resync-period", o.getEnvDuration("INFORMER_RESYNC_PERIOD", provider.DefaultInformerResyncPeriod),
+		"How often the pod informers of each virtual node resync [INFORMER_RESYNC_PERIOD]")
+	fs.DurationVar(&o.nodeHeartbeatInterval, "node-heartbeat-interval", o.getEnvDuration("NODE_HEARTBEAT_INTERVAL", provider.DefaultNodeHeartbeatInterval),
+		"How often the status of each virtual node is sent to the API server while it does not change [NODE_HEARTBEAT_INTERVAL]")
+	fs.IntVar(&o.reconcileHistorySize, "reconcile-history-size", o.getEnvInt("RECONCILE_HISTORY_SIZE", audit.DefaultSize),
+		"How many reconcile actions are kept for "+audit.HandlerPath+" on the health probe address [RECONCILE_HISTORY_SIZE]")
+	fs.BoolVar(&o.reconcileAuditLog, "reconcile-audit-log", getEnvOrDefault("RECONCILE_AUDIT_LOG", "false") == "true",
+		"Also log every reconcile action with structured fields [RECONCILE_AUDIT_LOG]")
+
+	fs.IntVar(&o.retryMaxAttempts, "flightctl-retry-max-attempts", o.getEnvInt("FLIGHTCTL_RETRY_MAX_ATTEMPTS", 0),
+		"Attempts per FlightCtl API call, 1 disables retries (default 4) [FLIGHTCTL_RETRY_MAX_ATTEMPTS]")
+	fs.DurationVar(&o.retryBaseDelay, "flightctl-retry-base-delay", o.getEnvDuration("FLIGHTCTL_RETRY_BASE_DELAY", 0),
+		"Delay before the first retry (default 200ms) [FLIGHTCTL_RETRY_BASE_DELAY]")
+	fs.DurationVar(&o.retryMaxDelay, "flightctl-retry-max-delay", o.getEnvDuration("FLIGHTCTL_RETRY_MAX_DELAY", 0),
+		"Maximum delay between retries (default 5s) [FLIGHTCTL_RETRY_MAX_DELAY]")
+	fs.IntVar(&o.breakerThreshold, "flightctl-breaker-threshold", o.getEnvInt("FLIGHTCTL_BREAKER_THRESHOLD", flightctl.DefaultBreakerFailureThreshold),
+		"Consecutive failed FlightCtl API calls after which calls are paused [FLIGHTCTL_BREAKER_THRESHOLD]")
+	fs.DurationVar(&o.breakerCooldown, "flightctl-breaker-cooldown", o.getEnvDuration("FLIGHTCTL_BREAKER_COOLDOWN", flightctl.DefaultBreakerCooldown),
+		"How long FlightCtl API calls are paused before a trial call, doubled while it fails [FLIGHTCTL_BREAKER_COOLDOWN]")
+	fs.DurationVar(&o.readTimeout, "flightctl-read-timeout", o.getEnvDuration("FLIGHTCTL_READ_TIMEOUT", flightctl.DefaultOperationTimeouts.Read),
+		"Bound of a FlightCtl device or fleet read, including its retries; 0 disables it [FLIGHTCTL_READ_TIMEOUT]")
+	fs.DurationVar(&o.listTimeout, "flightctl-list-timeout", o.getEnvDuration("FLIGHTCTL_LIST_TIMEOUT", flightctl.DefaultOperationTimeouts.List),
+		"Bound of a FlightCtl device, fleet or enrollment request listing, including all pages; 0 disables it [FLIGHTCTL_LIST_TIMEOUT]")
+	fs.DurationVar(&o.writeTimeout, "flightctl-write-timeout", o.getEnvDuration("FLIGHTCTL_WRITE_TIMEOUT", flightctl.DefaultOperationTimeouts.Write),
+		"Bound of a FlightCtl device or fleet update, including its retries; 0 disables it [FLIGHTCTL_WRITE_TIMEOUT]")
+	fs.DurationVar(&o.apiOutageNodeTimeout, "api-outage-node-timeout", o.getEnvDuration("API_OUTAGE_NODE_TIMEOUT", provider.DefaultAPIOutageNodeTimeout),
+		"How long FlightCtl API calls may be paused before the node turns NotReady [API_OUTAGE_NODE_TIMEOUT]")
+	fs.DurationVar(&o.enrollmentCheckInterval, "enrollment-check-interval", o.getEnvDuration("ENROLLMENT_CHECK_INTERVAL", provider.DefaultEnrollmentCheckInterval),
+		"How often device enrollment requests awaiting approval are counted into the flightctl.io/pending-enrollments node annotation; 0 disables it [ENROLLMENT_CHECK_INTERVAL]")
+	fs.BoolVar(&o.enrollmentEvents, "enrollment-events", getEnvOrDefault("ENROLLMENT_EVENTS", "false") == "true",
+		"Emit an EnrollmentPending event on the node for each new enrollment request awaiting approval [ENROLLMENT_EVENTS]")
+	fs.DurationVar(&o.deviceCacheTTL, "flightctl-device-cache-ttl", o.getEnvDuration("FLIGHTCTL_DEVICE_CACHE_TTL", flightctl.DefaultDeviceCacheTTL),
+		"How long device reads are reused before asking FlightCtl again, 0 disables caching [FLIGHTCTL_DEVICE_CACHE_TTL]")
+	fs.StringVar(&o.flightctlRecordFile, "flightctl-record-file", os.Getenv("FLIGHTCTL_RECORD_FILE"),
+		"File the FlightCtl API requests and responses are appended to, with credentials and secrets removed, e.g. for a bug report [FLIGHTCTL_RECORD_FILE]")
+	fs.StringVar(&o.flightctlReplayFile, "flightctl-replay-file", os.Getenv("FLIGHTCTL_REPLAY_FILE"),
+		"Recording the FlightCtl API responses are served from instead of the API; no credentials are needed [FLIGHTCTL_REPLAY_FILE]")
+	fs.BoolVar(&o.dryRun, "dry-run", getEnvOrDefault("DRY_RUN", "false") == "true",
+		"Translate, place and validate pods but log the device spec changes instead of writing them to FlightCtl; the pods never start [DRY_RUN]")
+	fs.BoolVar(&o.podMappingCRs, "pod-mapping-crs", getEnvOrDefault("POD_MAPPING_CRS", "false") == "true",
+		"Record the device(s), application and rollout state of each pod in a PodDeviceMapping custom resource, and place pods re-created after a restart back on their recorded device; needs deploy/crd.yaml [POD_MAPPING_CRS]")
+	fs.BoolVar(&o.keepOrphanedApps, "keep-orphaned-apps", getEnvOrDefault("KEEP_ORPHANED_APPS", "false") == "true",
+		"Keep the applications found on startup whose pod no longer exists on their devices, instead of removing them [KEEP_ORPHANED_APPS]")
+	fs.StringVar(&o.deviceMirrorNamespace, "device-mirror-namespace", os.Getenv("DEVICE_MIRROR_NAMESPACE"),
+		"Namespace the FlightCtl devices are mirrored into as read-only FlightctlDevice custom resources; empty disables the mirror; needs deploy/crd.yaml [DEVICE_MIRROR_NAMESPACE]")
+	fs.DurationVar(&o.deviceMirrorInterval, "device-mirror-interval", o.getEnvDuration("DEVICE_MIRROR_INTERVAL", devicemirror.DefaultInterval),
+		"How often the FlightctlDevice custom resources are synced with FlightCtl [DEVICE_MIRROR_INTERVAL]")
+
+	fs.DurationVar(&o.drainTimeout, "drain-timeout", o.getEnvDuration("DRAIN_TIMEOUT", defaultDrainTimeout),
+		"How long shutdown waits for the node controller and background loops to stop and queued device updates to be written [DRAIN_TIMEOUT]")
+	fs.BoolVar(&o.cordonOnShutdown, "cordon-on-shutdown", getEnvOrDefault("CORDON_ON_SHUTDOWN", "false") == "true",
+		"Mark the virtual node(s) unschedulable on shutdown; they are made schedulable again on restart [CORDON_ON_SHUTDOWN]")
+
+	fs.BoolVar(&o.leaderElect, "leader-elect", getEnvOrDefault("LEADER_ELECT", "false") == "true",
+		"Run the node(s) only while holding a leader election lease, so replicas act as active/standby [LEADER_ELECT]")
+	fs.StringVar(&o.leaderElectionNamespace, "leader-election-namespace", os.Getenv("LEADER_ELECTION_NAMESPACE"),
+		"Namespace of the leader election lease (default: the provider's namespace) [LEADER_ELECTION_NAMESPACE]")
+	fs.StringVar(&o.leaderElectionID, "leader-election-id", getEnvOrDefault("LEADER_ELECTION_ID", defaultLeaderElectionID),
+		"Name of the leader election lease; replicas sharing it elect one leader [LEADER_ELECTION_ID]")
+	fs.StringVar(&o.shardGroup, "shard-group", os.Getenv("SHARD_GROUP"),
+		"Share the devices (per-device mode) or fleets (per-fleet mode) with the other instances of this shard group, each running the nodes of its share [SHARD_GROUP]")
+	fs.StringVar(&o.shardNamespace, "shard-namespace", os.Getenv("SHARD_NAMESPACE"),
+		"Namespace of the shard membership leases (default: the provider's namespace) [SHARD_NAMESPACE]")
+
+	fs.StringVar(&o.kubeletAPIAddr, "kubelet-api-addr", getEnvOrDefault("KUBELET_API_ADDR", defaultKubeletAPIAddr),
+		"Address the kubelet API (logs, exec, /stats/summary, /metrics/resource) is served on [KUBELET_API_ADDR]")
+	fs.StringVar(&o.nodeIP, "node-ip", os.Getenv("NODE_IP"),
+		"Address the API server reaches the kubelet API on, usually the provider's pod IP; the node's InternalIP unless it represents a device [NODE_IP]")
+	fs.StringVar(&o.kubeletAPICert, "kubelet-api-cert", os.Getenv("KUBELET_API_CERT_FILE"),
+		"Serving certificate (PEM) for the kubelet API, which is only served when set; single node mode only [KUBELET_API_CERT_FILE]")
+	fs.StringVar(&o.kubeletAPIKey, "kubelet-api-key", os.Getenv("KUBELET_API_KEY_FILE"),
+		"Private key (PEM) for --kubelet-api-cert [KUBELET_API_KEY_FILE]")
+	fs.StringVar(&o.kubeletAPIClientCA, "kubelet-api-client-ca", os.Getenv("KUBELET_API_CLIENT_CA_FILE"),
+		"CA bundle (PEM) verifying client certificates on the kubelet API, e.g. the API server's kubelet client certificate [KUBELET_API_CLIENT_CA_FILE]")
+
+	fs.StringVar(&o.kubeconfig, "kubeconfig", os.Getenv("KUBECONFIG"),
+		"Kubeconfig for running outside the cluster (default: in-cluster service account) [KUBECONFIG]")
+	fs.StringVar(&o.healthProbeAddr, "health-probe-addr", getEnvOrDefault("HEALTH_PROBE_ADDR", ":8080"),
+		"Address for the /healthz and /readyz endpoints [HEALTH_PROBE_ADDR]")
+	fs.StringVar(&o.debugAddr, "debug-addr", os.Getenv("DEBUG_ADDR"),
+		"Address for the pprof, expvar and /debug/state endpoints; empty disables them. Keep it unexposed: the state lists pods and devices [DEBUG_ADDR]")
+	fs.StringVar(&o.webhookAddr, "webhook-addr", os.Getenv("WEBHOOK_ADDR"),
+		"Address for the HTTPS pod admission webhook; empty disables it [WEBHOOK_ADDR]")
+	fs.StringVar(&o.webhookCertFile, "webhook-cert-file", os.Getenv("WEBHOOK_CERT_FILE"),
+		"TLS certificate of the admission webhook, reloaded when it changes [WEBHOOK_CERT_FILE]")
+	fs.StringVar(&o.webhookKeyFile, "webhook-key-file", os.Getenv("WEBHOOK_KEY_FILE"),
+		"TLS private key of the admission webhook [WEBHOOK_KEY_FILE]")
+	fs.StringVar(&o.logLevel, "log-level", getEnvOrDefault("LOG_LEVEL", "info"),
+		"Log level: debug, info, warn or error [LOG_LEVEL]")
+	fs.StringVar(&o.logFormat, "log-format", getEnvOrDefault("LOG_FORMAT", logger.FormatText),
+		"Log format: text or json [LOG_FORMAT]")
+	fs.StringVar(&o.logRedactEnv, "log-redact-env", getEnvOrDefault("LOG_REDACT_ENV", redact.DefaultEnvPatterns),
+		"Comma-separated, case-insensitive glob patterns of env variable names whose values are masked in logs, or none [LOG_REDACT_ENV]")
+	fs.StringVar(&o.logRedactFiles, "log-redact-files", getEnvOrDefault("LOG_REDACT_FILES", redact.DefaultFilePatterns),
+		"Comma-separated, case-insensitive glob patterns of inline file paths whose contents are never logged, or none [LOG_REDACT_FILES]")
+}
+
+// applyLogging configures the logger from the options.
+func (o *options) applyLogging() {
+	logger.SetLevelFromString(o.logLevel)
+	logger.SetFormat(o.logFormat)
+	if err := redact.SetEnvPatterns(o.logRedactEnv); err != nil {
+		logger.Warn("Invalid log-redact-env, keeping the previous patterns: %v", err)
+	}
+	if err := redact.SetFilePatterns(o.logRedactFiles); err != nil {
+		logger.Warn("Invalid log-redact-files, keeping the previous patterns: %v", err)
+	}
+}
+
+// validateAuth checks that the credentials of the FlightCtl auth mode are
+// set.
+func (o *options) validateAuth() error {
+	switch o.flightctlAuthMode {
+	case flightctl.AuthModeOAuth:
+		if o.flightctlClientID == "" {
+			return fmt.Errorf("--flightctl-client-id (FLIGHTCTL_CLIENT_ID) is required")
+		}
+		if o.flightctlClientSecret == "" && o.flightctlRefreshToken == "" {
+			return fmt.Errorf("--flightctl-client-secret (FLIGHTCTL_CLIENT_SECRET) or --flightctl-refresh-token (FLIGHTCTL_REFRESH_TOKEN) is required")
+		}
+		if o.flightctlTokenURL == "" {
+			return fmt.Errorf("--flightctl-token-url (FLIGHTCTL_TOKEN_URL) is required")
+		}
+	case flightctl.AuthModeToken:
+		if o.flightctlAuthToken == "" {
+			return fmt.Errorf("--flightctl-auth-token (FLIGHTCTL_AUTH_TOKEN) is required for auth mode token")
+		}
+	case flightctl.AuthModeTokenFile:
+		if o.flightctlTokenFile == "" {
+			return fmt.Errorf("--flightctl-token-file (FLIGHTCTL_TOKEN_FILE) is required for auth mode token-file")
+		}
+	case flightctl.AuthModeNone:
+		if o.flightctlClientCert == "" {
+			return fmt.Errorf("--flightctl-client-cert (FLIGHTCTL_CLIENT_CERT_FILE) is required for auth mode none")
+		}
+	default:
+		return fmt.Errorf("unknown --flightctl-auth-mode %q (expected oauth, token, token-file or none)", o.flightctlAuthMode)
+	}
+	return nil
+}
+
+// providerConfig validates the options and builds the provider configuration.
+func (o *options) providerConfig() (provider.Config, error) {
+	if o.flightctlClientSecret == "" {
+		o.flightctlClientSecret = os.Getenv("FLIGHTCTL_CLIENT_SECRET")
+	}
+	if o.flightctlAuthToken == "" {
+		o.flightctlAuthToken = os.Getenv("FLIGHTCTL_AUTH_TOKEN")
+	}
+	if o.flightctlRefreshToken == "" {
+		o.flightctlRefreshToken = os.Getenv("FLIGHTCTL_REFRESH_TOKEN")
+	}
+	// A replayed recording is served without authenticating, and the
+	// endpoints of an endpoints file have their own credentials
+	if o.flightctlReplayFile == "" && o.flightctlEndpointsFile == "" {
+		if err := o.validateAuth(); err != nil {
+			return provider.Config{}, err
+		}
+	}
+	if o.flightctlRecordFile != "" && o.flightctlReplayFile != "" {
+		return provider.Config{}, fmt.Errorf("--flightctl-record-file and --flightctl-replay-file are exclusive")
+	}
+	if o.flightctlEndpointsFile != "" && (o.flightctlRecordFile != "" || o.flightctlReplayFile != "") {
+		return provider.Config{}, fmt.Errorf("--flightctl-endpoints-file cannot be combined with --flightctl-record-file or --flightctl-replay-file")
+	}
+	switch o.nodeMode {
+	case nodeModeSingle, nodeModePerDevice, nodeModePerFleet:
+	default:
+		return provider.Config{}, fmt.Errorf("unknown --node-mode %q (expected %s, %s or %s)",
+			o.nodeMode, nodeModeSingle, nodeModePerDevice, nodeModePerFleet)
+	}
+	if o.nodeDiscoveryInterval < time.Second {
+		return provider.Config{}, fmt.Errorf("--node-discovery-interval must be at least 1s")
+	}
+	if o.informerResyncPeriod < time.Second {
+		return provider.Config{}, fmt.Errorf("--informer-resync-period must be at least 1s")
+	}
+	if o.nodeHeartbeatInterval < time.Second {
+		return provider.Config{}, fmt.Errorf("--node-heartbeat-interval must be at least 1s")
+	}
+	if o.shardGroup != "" {
+		if o.nodeMode == nodeModeSingle {
+			return provider.Config{}, fmt.Errorf("--shard-group needs --node-mode %s or %s", nodeModePerDevice, nodeModePerFleet)
+		}
+		if o.leaderElect {
+			return provider.Config{}, fmt.Errorf("--shard-group and --leader-elect cannot be combined: sharding already keeps instances from running the same nodes")
+		}
+		if errs := validation.IsDNS1123Label(o.shardGroup); len(errs) > 0 {
+			return provider.Config{}, fmt.Errorf("invalid --shard-group %q: %s", o.shardGroup, strings.Join(errs, "; "))
+		}
+	}
+	if (o.kubeletAPICert == "") != (o.kubeletAPIKey == "") {
+		return provider.Config{}, fmt.Errorf("--kubelet-api-cert (KUBELET_API_CERT_FILE) and --kubelet-api-key (KUBELET_API_KEY_FILE) must be set together")
+	}
+	if o.kubeletAPICert != "" && o.nodeMode != nodeModeSingle {
+		return provider.Config{}, fmt.Errorf("--kubelet-api-cert needs --node-mode %s: the nodes of one instance cannot share the kubelet API address", nodeModeSingle)
+	}
+	if o.kubeletAPICert != "" && o.flightctlEndpointsFile != "" {
+		return provider.Config{}, fmt.Errorf("--kubelet-api-cert cannot be combined with --flightctl-endpoints-file: the nodes of the endpoints cannot share the kubelet API address")
+	}
+	if o.kubeletAPIClientCA != "" && o.kubeletAPICert == "" {
+		return provider.Config{}, fmt.Errorf("--kubelet-api-client-ca needs --kubelet-api-cert")
+	}
+	var kubeletPort int32
+	if o.kubeletAPICert != "" {
+		_, port, err := net.SplitHostPort(o.kubeletAPIAddr)
+		if err != nil {
+			return provider.Config{}, fmt.Errorf("invalid --kubelet-api-addr %q: %w", o.kubeletAPIAddr, err)
+		}
+		n, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || n == 0 {
+			return provider.Config{}, fmt.Errorf("invalid --kubelet-api-addr %q: the port must be a number from 1 to 65535", o.kubeletAPIAddr)
+		}
+		kubeletPort = int32(n)
+	}
+	if o.nodeIP != "" && net.ParseIP(o.nodeIP) == nil {
+		return provider.Config{}, fmt.Errorf("invalid --node-ip %q", o.nodeIP)
+	}
+	if o.drainTimeout <= 0 {
+		return provider.Config{}, fmt.Errorf("--drain-timeout must be positive")
+	}
+	if o.reconcileWorkers <= 0 {
+		return provider.Config{}, fmt.Errorf("--reconcile-workers must be a positive integer")
+	}
+	if o.podWorkers <= 0 {
+		return provider.Config{}, fmt.Errorf("--pod-workers must be a positive integer")
+	}
+	nodeTaints, err := provider.ParseNodeTaints(o.nodeTaints)
+	if err != nil {
+		return provider.Config{}, fmt.Errorf("invalid --node-taints: %w", err)
+	}
+	if _, err := redact.ParsePatterns(o.logRedactEnv); err != nil {
+		return provider.Config{}, fmt.Errorf("invalid --log-redact-env: %w", err)
+	}
+	if _, err := redact.ParsePatterns(o.logRedactFiles); err != nil {
+		return provider.Config{}, fmt.Errorf("invalid --log-redact-files: %w", err)
+	}
+	if o.reconcileHistorySize <= 0 {
+		return provider.Config{}, fmt.Errorf("--reconcile-history-size must be a positive integer")
+	}
+	if o.readTimeout < 0 || o.listTimeout < 0 || o.writeTimeout < 0 {
+		return provider.Config{}, fmt.Errorf("--flightctl-read-timeout, --flightctl-list-timeout and --flightctl-write-timeout must not be negative")
+	}
+	if o.enrollmentCheckInterval < 0 {
+		return provider.Config{}, fmt.Errorf("--enrollment-check-interval must not be negative")
+	}
+	if o.terminatedPodRetention < 0 {
+		return provider.Config{}, fmt.Errorf("--terminated-pod-retention must not be negative")
+	}
+	if o.deviceCacheTTL < 0 {
+		return provider.Config{}, fmt.Errorf("--flightctl-device-cache-ttl must not be negative")
+	}
+	if o.deviceMirrorNamespace != "" {
+		if errs := validation.IsDNS1123Label(o.deviceMirrorNamespace); len(errs) > 0 {
+			return provider.Config{}, fmt.Errorf("invalid --device-mirror-namespace %q: %s", o.deviceMirrorNamespace, strings.Join(errs, "; "))
+		}
+		if o.deviceMirrorInterval <= 0 {
+			return provider.Config{}, fmt.Errorf("--device-mirror-interval must be positive")
+		}
+	}
+	if o.webhookAddr != "" && (o.webhookCertFile == "" || o.webhookKeyFile == "") {
+		return provider.Config{}, fmt.Errorf("--webhook-addr requires --webhook-cert-file and --webhook-key-file")
+	}
+	if o.retryMaxAttempts < 0 {
+		return provider.Config{}, fmt.Errorf("--flightctl-retry-max-attempts must be a positive integer")
+	}
+	if o.breakerThreshold < 1 {
+		return provider.Config{}, fmt.Errorf("--flightctl-breaker-threshold must be a positive integer")
+	}
+
+	cfg := provider.Config{
+		NodeName:                o.nodeName,
+		FlightctlAPIURL:         o.flightctlAPIURL,
+		FlightctlClientID:       o.flightctlClientID,
+		FlightctlClientSecret:   o.flightctlClientSecret,
+		FlightctlTokenURL:       o.flightctlTokenURL,
+		FlightctlAuthMode:       o.flightctlAuthMode,
+		FlightctlToken:          o.flightctlAuthToken,
+		FlightctlRefreshToken:   o.flightctlRefreshToken,
+		FlightctlTokenFile:      o.flightctlTokenFile,
+		FlightctlClientCertFile: o.flightctlClientCert,
+		FlightctlClientKeyFile:  o.flightctlClientKey,
+		FlightctlCAFile:         o.flightctlCAFile,
+		FlightctlCAData:         []byte(o.flightctlCAData),
+		FlightctlInsecureTLS:    o.flightctlInsecureTLS,
+		DefaultAppType:          o.defaultAppType,
+		ComposeFile:             o.composeFile,
+		AppNaming:               flightctl.AppNaming(o.appNaming),
+		DisconnectAction:        o.disconnectAction,
+		DeviceReconnectTimeout:  o.deviceReconnectTimeout,
+		OfflineDeploymentTTL:    o.offlineDeploymentTTL,
+		DeploymentReadyTimeout:  o.deploymentReadyTimeout,
+		CompletedPodRetention:   o.completedPodRetention,
+
+		ReconcileInterval:       o.reconcileInterval,
+		ReconcileJitter:         o.reconcileJitter,
+		ReconcileWorkers:        o.reconcileWorkers,
+		PodWorkers:              o.podWorkers,
+		DisconnectCheckInterval: o.disconnectCheckInterval,
+		DeviceRefreshInterval:   o.nodeDiscoveryInterval,
+		InformerResyncPeriod:    o.informerResyncPeriod,
+		NodeHeartbeatInterval:   o.nodeHeartbeatInterval,
+		NodeLabels:              o.nodeLabels,
+		NodeAnnotations:         o.nodeAnnotations,
+		NodeTaints:              nodeTaints,
+		TopologyLabels:          o.topologyLabels,
+		DeviceLabelPrefixes:     o.deviceLabelPrefixes,
+		DeviceLabelConflicts:    o.deviceLabelConflicts,
+		ProviderIDFormat:        o.providerIDFormat,
+		NodeIP:                  o.nodeIP,
+		PodValidation:           o.podValidation,
+		TranslationPreview:      o.translationPreview,
+		KubeletPort:             kubeletPort,
+		DefaultFleet:            o.defaultFleet,
+	}
+	cfg.FlightctlRetry.MaxAttempts = o.retryMaxAttempts
+	cfg.FlightctlRetry.BaseDelay = o.retryBaseDelay
+	cfg.FlightctlRetry.MaxDelay = o.retryMaxDelay
+	cfg.FlightctlBreaker.FailureThreshold = o.breakerThreshold
+	cfg.FlightctlBreaker.Cooldown = o.breakerCooldown
+	cfg.FlightctlTimeouts = flightctl.OperationTimeouts{
+		Read:  operationTimeout(o.readTimeout),
+		List:  operationTimeout(o.listTimeout),
+		Write: operationTimeout(o.writeTimeout),
+	}
+	cfg.APIOutageNodeTimeout = o.apiOutageNodeTimeout
+	cfg.EnrollmentCheckInterval = o.enrollmentCheckInterval
+	if o.enrollmentCheckInterval == 0 {
+		cfg.EnrollmentCheckInterval = -1
+	}
+	cfg.EnrollmentEvents = o.enrollmentEvents
+	cfg.TerminatedPodRetention = o.terminatedPodRetention
+	if o.terminatedPodRetention == 0 {
+		cfg.TerminatedPodRetention = -1
+	}
+	cfg.FlightctlDeviceCacheTTL = o.deviceCacheTTL
+	cfg.DenyPrivilegedNamespaces = o.denyPrivileged
+	if cfg.NamespaceQuotas, err = provider.ParseNamespaceQuotas(o.namespaceCPUQuota, o.namespaceMemoryQuota); err != nil {
+		return provider.Config{}, err
+	}
+	if cfg.NodeExtendedResources, err = provider.ParseNodeExtendedResources(o.nodeExtendedResources); err != nil {
+		return provider.Config{}, err
+	}
+	if o.logCacheSize != "" {
+		size, err := resource.ParseQuantity(o.logCacheSize)
+		if err != nil {
+			return provider.Config{}, fmt.Errorf("invalid --log-cache-size: %w", err)
+		}
+		if size.Sign() < 0 {
+			return provider.Config{}, fmt.Errorf("--log-cache-size must not be negative")
+		}
+		cfg.LogCacheSize = int(size.Value())
+	}
+	if o.deviceAccessPolicy != "" {
+		if cfg.DeviceAccessPolicy, err = provider.LoadDeviceAccessPolicy(o.deviceAccessPolicy); err != nil {
+			return provider.Config{}, err
+		}
+	}
+	if o.resolveImageDigests {
+		cfg.ImageDigestResolver = registry.NewResolver(nil)
+	}
+	cfg.CPUOvercommitRatio = o.cpuOvercommit
+	cfg.MemoryOvercommitRatio = o.memoryOvercommit
+	cfg.PlacementStrategy = o.placementStrategy
+	if cfg.DeviceScorers, err = provider.ParseDeviceScorers(o.deviceScorers); err != nil {
+		return provider.Config{}, err
+	}
+	cfg.MinDeviceBattery = o.minDeviceBattery
+	cfg.MaxDeviceTemperature = o.maxDeviceTemp
+	cfg.AuditTrail = audit.NewTrail(o.reconcileHistorySize, o.reconcileAuditLog)
+	cfg.FlightctlMetrics = flightctl.NewTransportMetrics()
+	cfg.FlightctlRecordFile = o.flightctlRecordFile
+	cfg.FlightctlReplayFile = o.flightctlReplayFile
+	cfg.DryRun = o.dryRun
+	cfg.KeepOrphanedApps = o.keepOrphanedApps
+	return cfg, nil
+}
+
+// kubeletAPI returns the kubelet API serving settings.
+func (o *options) kubeletAPI() *kubeletAPI {
+	return &kubeletAPI{
+		addr:         o.kubeletAPIAddr,
+		certFile:     o.kubeletAPICert,
+		keyFile:      o.kubeletAPIKey,
+		clientCAFile: o.kubeletAPIClientCA,
+	}
+}
+
+// tunables returns the settings the provider can change at runtime.
+func (o *options) tunables() provider.Tunables {
+	return provider.Tunables{
+		ReconcileInterval:       o.reconcileInterval,
+		ReconcileJitter:         o.reconcileJitter,
+		DisconnectCheckInterval: o.disconnectCheckInterval,
+		DeviceReconnectTimeout:  o.deviceReconnectTimeout,
+		DeploymentReadyTimeout:  o.deploymentReadyTimeout,
+	}
+}
+
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// envError returns the errors in the environment variables read by addFlags,
+// or nil if they all parsed.
+func (o *options) envError() error {
+	return errors.Join(o.envErrs...)
+}
+
+// invalidEnv records an environment variable that could not be parsed; the
+// flag keeps its default.
+func (o *options) invalidEnv(key, value, format string, args ...any) {
+	o.envErrs = append(o.envErrs, fmt.Errorf("invalid %s %q: %s", key, value, fmt.Sprintf(format, args...)))
+}
+
+func (o *options) getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		o.invalidEnv(key, value, "%v", err)
+		return defaultValue
+	}
+	return d
+}
+
+func (o *options) getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		o.invalidEnv(key, value, "must be a positive integer")
+		return defaultValue
+	}
+	return n
+}
+
+func (o *options) getEnvFloat(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f <= 0 {
+		o.invalidEnv(key, value, "must be a positive number")
+		return defaultValue
+	}
+	return f
+}
+
+// getEnvStringSlice parses a comma-separated list.
+func getEnvStringSlice(key string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	var values []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// getEnvStringMap parses a comma-separated list of key=value pairs.
+func (o *options) getEnvStringMap(key string) map[string]string {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	m := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || k == "" {
+			o.invalidEnv(key, value, "expected key=value pairs")
+			return nil
+		}
+		m[k] = v
+	}
+	return m
+}
+
+// operationTimeout converts a FlightCtl operation timeout flag, where 0
+// disables the bound, to the client's setting, where it takes the default.
+func operationTimeout(d time.Duration) time.Duration {
+	if d == 0 {
+		return -1
+	}
+	return d
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestInvalidEnvironmentIsReported(t *testing.T) {
+	t.Setenv("DEVICE_RECONNECT_TIMEOUT", "soon")
+	t.Setenv("FLIGHTCTL_RETRY_MAX_ATTEMPTS", "0")
+	t.Setenv("CPU_OVERCOMMIT_RATIO", "lots")
+	t.Setenv("NODE_LABELS", "zone")
+
+	for _, args := range [][]string{{"version"}, {"validate"}} {
+		var out bytes.Buffer
+		root := newRootCommand()
+		root.SetArgs(args)
+		root.SetOut(&out)
+		root.SetErr(&out)
+		if err := root.Execute(); err == nil {
+			t.Errorf("%s: succeeded with an invalid environment, want an error", args[0])
+		}
+		for _, key := range []string{"DEVICE_RECONNECT_TIMEOUT", "FLIGHTCTL_RETRY_MAX_ATTEMPTS", "CPU_OVERCOMMIT_RATIO", "NODE_LABELS"} {
+			if !strings.Contains(out.String(), key) {
+				t.Errorf("%s: output %q does not mention %s", args[0], out.String(), key)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/provider"
+)
+
+// podConfigResyncPeriod is how often the ConfigMap and Secret informers
+// resync; resyncs without changes do not update pods.
+const podConfigResyncPeriod = 10 * time.Minute
+
+// podConfigWatcher runs the ConfigMap and Secret informers shared by the
+// providers of all virtual nodes of the process.
+type podConfigWatcher struct {
+	factory informers.SharedInformerFactory
+	stopCh  chan struct{}
+}
+
+func newPodConfigWatcher(k8sClient kubernetes.Interface) *podConfigWatcher {
+	return &podConfigWatcher{
+		factory: informers.NewSharedInformerFactory(k8sClient, podConfigResyncPeriod),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// watch lets the provider read the ConfigMaps and Secrets pods refer to from
+// the informers, and updates its pods using one when it changes, until the
+// returned function is called.
+func (w *podConfigWatcher) watch(p *provider.Provider) (func(), error) {
+	configMaps := w.factory.Core().V1().ConfigMaps()
+	secrets := w.factory.Core().V1().Secrets()
+	p.SetConfigListers(configMaps.Lister(), secrets.Lister())
+
+	configMapHandler, err := configMaps.Informer().AddEventHandler(configChangeHandler(p.ConfigMapChanged))
+	if err != nil {
+		return nil, err
+	}
+	secretHandler, err := secrets.Informer().AddEventHandler(configChangeHandler(p.SecretChanged))
+	if err != nil {
+		_ = configMaps.Informer().RemoveEventHandler(configMapHandler)
+		return nil, err
+	}
+
+	w.factory.Start(w.stopCh)
+	w.factory.WaitForCacheSync(w.stopCh)
+	return func() {
+		_ = configMaps.Informer().RemoveEventHandler(configMapHandler)
+		_ = secrets.Informer().RemoveEventHandler(secretHandler)
+	}, nil
+}
+
+// stop stops the informers.
+func (w *podConfigWatcher) stop() {
+	close(w.stopCh)
+	w.factory.Shutdown()
+}
+
+// configChangeHandler calls changed, in the background, for objects added
+// after the initial list, updated or deleted.
+func configChangeHandler(changed func(ctx context.Context, namespace, name string)) cache.ResourceEventHandler {
+	notify := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		if object, ok := obj.(metav1.Object); ok {
+			go changed(context.Background(), object.GetNamespace(), object.GetName())
+		}
+	}
+	return cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if !isInInitialList {
+				notify(obj)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldObject, ok1 := oldObj.(metav1.Object)
+			newObject, ok2 := newObj.(metav1.Object)
+			if ok1 && ok2 && oldObject.GetResourceVersion() != newObject.GetResourceVersion() {
+				notify(newObj)
+			}
+		},
+		DeleteFunc: notify,
+	}
+}
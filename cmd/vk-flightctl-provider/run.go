@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/audit"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/debug"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/health"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/provider"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/tracing"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/webhook"
+	"github.com/virtual-kubelet/virtual-kubelet/node"
+	"github.com/virtual-kubelet/virtual-kubelet/node/nodeutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Node modes.
+const (
+	// nodeModeSingle runs one virtual node for all devices.
+	nodeModeSingle = "single"
+	// nodeModePerDevice runs one virtual node per discovered device.
+	nodeModePerDevice = "per-device"
+	// nodeModePerFleet runs one virtual node per discovered fleet.
+	nodeModePerFleet = "per-fleet"
+)
+
+// defaultDrainTimeout bounds the graceful shutdown sequence.
+const defaultDrainTimeout = 30 * time.Second
+
+// runProvider starts the provider and the virtual node(s), and blocks until
+// a shutdown signal is received or the node controller fails.
+func runProvider(opts *options) error {
+	log.Println("Starting VK-Flightctl Provider...")
+
+	cfg, err := opts.providerConfig()
+	if err != nil {
+		return err
+	}
+
+	// Tracing is configured by the standard OTEL_* environment variables
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		return fmt.Errorf("setting up tracing: %w", err)
+	}
+
+	// Create Kubernetes client (in-cluster config unless a kubeconfig is given)
+	config, err := kubeRestConfig(opts.kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	k8sClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("creating Kubernetes dynamic client: %w", err)
+	}
+	if opts.podMappingCRs {
+		cfg.MappingStore = provider.NewMappingCRStore(dynamicClient)
+	}
+
+	// ConfigMaps and Secrets pods refer to, shared by all virtual nodes
+	podConfig := newPodConfigWatcher(k8sClient)
+	defer podConfig.stop()
+
+	// Liveness/readiness endpoints for the Deployment probes
+	healthServer := health.NewServer(opts.healthProbeAddr)
+	healthServer.Handle(audit.HandlerPath, cfg.AuditTrail)
+	healthServer.Handle(flightctl.MetricsPath, cfg.FlightctlMetrics)
+
+	endpoints, err := opts.endpointConfigs(cfg)
+	if err != nil {
+		return err
+	}
+	var groups nodeGroups
+	for _, endpoint := range endpoints {
+		group, err := newNodeGroup(opts, endpoint, k8sClient, podConfig, healthServer)
+		if err != nil {
+			_ = groups.Drain(context.Background())
+			return err
+		}
+		defer group.release()
+		groups = append(groups, group)
+	}
+	run := groups.Run
+	if opts.deviceMirrorNamespace != "" {
+		var closeMirrors func()
+		run, closeMirrors, err = withDeviceMirrors(opts, endpoints, dynamicClient, run)
+		if err != nil {
+			_ = groups.Drain(context.Background())
+			return err
+		}
+		defer closeMirrors()
+	}
+	if opts.leaderElect {
+		identity, err := leaderElectionIdentity()
+		if err != nil {
+			return err
+		}
+		run, err = leaderElected(k8sClient, leaseNamespace(opts.leaderElectionNamespace), opts.leaderElectionID, identity, run)
+		if err != nil {
+			return err
+		}
+	}
+	healthServer.Start()
+
+	// Admission checks for pods, if enabled
+	var webhookServer *webhook.Server
+	if opts.webhookAddr != "" {
+		var admitters []webhook.Admitter
+		for _, group := range groups {
+			admitters = append(admitters, group.admitter)
+		}
+		webhookServer, err = webhook.NewServer(opts.webhookAddr, opts.webhookCertFile, opts.webhookKeyFile, webhook.NewHandler(admitters...))
+		if err != nil {
+			_ = groups.Drain(context.Background())
+			return err
+		}
+		webhookServer.Start()
+	}
+
+	// Profiling and state dumps, if enabled
+	var debugServer *debug.Server
+	if opts.debugAddr != "" {
+		debugServer = debug.NewServer(opts.debugAddr, func() interface{} {
+			return map[string][]provider.DebugState{"nodes": groups.debugState()}
+		})
+		debugServer.Start()
+	}
+
+	// Setup signal handling for graceful shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	// Reload tunable settings on SIGHUP or config file change
+	go opts.watchConfigFile(ctx, groups)
+
+	// Run the node controller in a goroutine
+	errCh := make(chan error, 1)
+	runDone := make(chan struct{})
+	go func() {
+		defer close(runDone)
+		log.Println("Starting Virtual Kubelet node controller...")
+		if err := run(ctx); err != nil {
+			errCh <- err
+		}
+	}()
+
+	// Wait for shutdown signal or error
+	var runErr error
+	select {
+	case <-sigCh:
+		log.Println("Received shutdown signal, shutting down gracefully...")
+	case runErr = <-errCh:
+		log.Printf("Node controller error: %v", runErr)
+	}
+
+	// Fail readiness first so traffic drains before the controller stops
+	healthServer.SetShuttingDown()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), opts.drainTimeout)
+	defer shutdownCancel()
+
+	// Cordon while the node controller still runs, so no new pods arrive
+	if opts.cordonOnShutdown {
+		cordonNodes(shutdownCtx, k8sClient, groups.nodeNames())
+	}
+
+	// Stop the node controller and let in-flight pod operations finish
+	cancel()
+	select {
+	case <-runDone:
+	case <-shutdownCtx.Done():
+		log.Printf("Warning: Node controller did not stop within %s", opts.drainTimeout)
+	}
+
+	// Stop reconciliation and write the device updates still queued
+	if err := groups.Drain(shutdownCtx); err != nil {
+		log.Printf("Warning: Shutdown did not drain cleanly: %v", err)
+	}
+
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stopCancel()
+	if err := healthServer.Shutdown(stopCtx); err != nil {
+		log.Printf("Warning: Failed to stop health server: %v", err)
+	}
+	if debugServer != nil {
+		if err := debugServer.Shutdown(stopCtx); err != nil {
+			log.Printf("Warning: Failed to stop debug server: %v", err)
+		}
+	}
+	if webhookServer != nil {
+		if err := webhookServer.Shutdown(stopCtx); err != nil {
+			log.Printf("Warning: Failed to stop admission webhook: %v", err)
+		}
+	}
+
+	if err := shutdownTracing(stopCtx); err != nil {
+		log.Printf("Warning: Failed to flush traces: %v", err)
+	}
+
+	log.Println("Shutdown complete")
+	return runErr
+}
+
+// nodeGroup runs the virtual nodes of one FlightCtl endpoint.
+type nodeGroup struct {
+	run        func(context.Context) error
+	tunables   tunablesUpdater
+	drain      func(context.Context) error
+	nodeNames  func() []string
+	debugState func() []provider.DebugState
+	admitter   webhook.Admitter // Checks pods for the admission webhook, if enabled
+	release    func()           // Stops what is left once the group is drained
+}
+
+// newNodeGroup creates the provider and node of an endpoint in single node
+// mode, or its node set controller in the per-device and per-fleet modes,
+// and registers their readiness checks.
+func newNodeGroup(opts *options, endpoint endpointConfig, k8sClient kubernetes.Interface, podConfig *podConfigWatcher, healthServer *health.Server) (*nodeGroup, error) {
+	cfg := endpoint.cfg
+	// Checks are named after the endpoint, if there are several
+	checkName := func(name string) string {
+		if endpoint.name == "" {
+			return name
+		}
+		return name + "-" + endpoint.name
+	}
+
+	switch opts.nodeMode {
+	case nodeModePerDevice, nodeModePerFleet:
+		client, err := flightctl.NewClient(cfg.FlightctlConfig())
+		if err != nil {
+			return nil, fmt.Errorf("creating Flightctl client: %w", err)
+		}
+		selector := opts.deviceSelector
+		if opts.nodeMode == nodeModePerFleet {
+			selector = opts.fleetSelector
+		}
+		controller := newNodeSetController(opts.nodeMode, cfg, client, k8sClient, podConfig, selector)
+		if endpoint.name != "" {
+			controller.namePrefix = endpoint.name + "-"
+		}
+		if opts.shardGroup != "" {
+			identity, err := os.Hostname()
+			if err != nil {
+				client.Close()
+				return nil, fmt.Errorf("getting hostname for the shard identity: %w", err)
+			}
+			controller.shard = newShardMembership(k8sClient, leaseNamespace(opts.shardNamespace), shardGroupName(opts.shardGroup, endpoint.name), identity)
+		}
+		healthServer.AddReadinessCheck(checkName("flightctl-api"), client.Ping)
+		healthServer.AddReadinessCheck(checkName("flightctl-token"), client.CheckToken)
+		healthServer.AddReadinessCheck(checkName("node-discovery"), controller.checkSynced)
+		group := &nodeGroup{
+			run:        controller.Run,
+			tunables:   controller,
+			drain:      controller.Drain,
+			nodeNames:  controller.nodeNames,
+			debugState: controller.debugState,
+			release:    func() {},
+		}
+		if opts.webhookAddr != "" {
+			// The nodes come and go with their devices or fleets, so pods
+			// are checked by a provider of their own, pinned to none
+			admissionCfg := cfg
+			admissionCfg.MappingStore = nil
+			admissionCfg.EnrollmentCheckInterval = -1
+			admissionCfg.DeviceLabelPrefixes = nil
+			admitter, err := provider.NewProviderWithClient(admissionCfg, sharedClient{client})
+			if err != nil {
+				client.Close()
+				return nil, fmt.Errorf("creating admission provider: %w", err)
+			}
+			group.admitter = admitter
+			group.release = admitter.Shutdown
+		}
+		return group, nil
+	default:
+		p, nodeRunner, stopEvents, err := newSingleNode(cfg, k8sClient, podConfig, opts.kubeletAPI())
+		if err != nil {
+			return nil, err
+		}
+		healthServer.AddReadinessCheck(checkName("flightctl-api"), p.Ping)
+		healthServer.AddReadinessCheck(checkName("flightctl-token"), p.CheckAuth)
+		healthServer.AddReadinessCheck(checkName("node-registered"), func(ctx context.Context) error {
+			select {
+			case <-nodeRunner.Ready():
+				return nil
+			default:
+				return fmt.Errorf("virtual node %s not registered yet", cfg.NodeName)
+			}
+		})
+		return &nodeGroup{
+			run:        nodeRunner.Run,
+			tunables:   p,
+			drain:      p.Drain,
+			nodeNames:  func() []string { return []string{cfg.NodeName} },
+			debugState: func() []provider.DebugState { return []provider.DebugState{p.DebugState()} },
+			admitter:   p,
+			release:    stopEvents,
+		}, nil
+	}
+}
+
+// nodeGroups are the node groups of all endpoints, run as one.
+type nodeGroups []*nodeGroup
+
+// Run runs all groups until ctx is done. The first group to fail stops the
+// others.
+func (g nodeGroups) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, len(g))
+	for _, group := range g {
+		go func() { errs <- group.run(ctx) }()
+	}
+	var first error
+	for range g {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+			cancel()
+		}
+	}
+	return first
+}
+
+// UpdateTunables applies reloaded runtime settings to all groups.
+func (g nodeGroups) UpdateTunables(t provider.Tunables) error {
+	for _, group := range g {
+		if err := group.tunables.UpdateTunables(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Drain drains all groups concurrently.
+func (g nodeGroups) Drain(ctx context.Context) error {
+	errs := make([]error, len(g))
+	var wg sync.WaitGroup
+	for i, group := range g {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = group.drain(ctx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (g nodeGroups) nodeNames() []string {
+	var names []string
+	for _, group := range g {
+		names = append(names, group.nodeNames()...)
+	}
+	return names
+}
+
+func (g nodeGroups) debugState() []provider.DebugState {
+	var states []provider.DebugState
+	for _, group := range g {
+		states = append(states, group.debugState()...)
+	}
+	return states
+}
+
+// newSingleNode creates the provider and the virtual node representing all
+// devices.
+func newSingleNode(cfg provider.Config, k8sClient kubernetes.Interface, podConfig *podConfigWatcher, kubelet *kubeletAPI) (*provider.Provider, *nodeutil.Node, func(), error) {
+	// Create provider
+	p, err := provider.NewProvider(cfg)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("creating provider: %w", err)
+	}
+
+	// Check connectivity
+	ctx := context.Background()
+	if err := p.Ping(ctx); err != nil {
+		log.Printf("Warning: Failed to ping Flightctl API: %v", err)
+	} else {
+		log.Println("Successfully connected to Flightctl API")
+	}
+
+	nodeRunner, stopEvents, err := newVirtualNode(cfg.NodeName, p, k8sClient, podConfig, kubelet)
+	if err != nil {
+		p.Shutdown()
+		return nil, nil, nil, err
+	}
+	return p, nodeRunner, stopEvents, nil
+}
+
+// newVirtualNode creates the Virtual Kubelet node controller for a provider,
+// serving the kubelet API if kubelet is set. The returned function stops the
+// node's event recorder and its ConfigMap and Secret watch.
+func newVirtualNode(nodeName string, p *provider.Provider, k8sClient kubernetes.Interface, podConfig *podConfigWatcher, kubelet *kubeletAPI) (*nodeutil.Node, func(), error) {
+	ctx := context.Background()
+
+	// Get initial node definition for logging
+	nodeSpec, err := p.GetNode(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting node spec: %w", err)
+	}
+	uncordonAfterRestart(ctx, k8sClient, nodeName)
+
+	// Serialize node to JSON for logging
+	nodeJSON, err := json.MarshalIndent(nodeSpec, "", "  ")
+	if err != nil {
+		log.Printf("Warning: Failed to serialize node to JSON: %v", err)
+	} else {
+		log.Printf("Node definition:\n%s", string(nodeJSON))
+	}
+
+	// Event recorder shared by the pod controller and the provider
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: k8sClient.CoreV1().Events("")})
+	eventRecorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: nodeName + "/pod-controller"})
+	p.SetEventRecorder(eventRecorder)
+	p.SetKubeClient(k8sClient)
+	if _, err := p.ReconcileStartup(ctx); err != nil {
+		log.Printf("Warning: Failed to reconcile the devices with the pods of node %s: %v", nodeName, err)
+	}
+
+	unwatchConfig, err := podConfig.watch(p)
+	if err != nil {
+		eventBroadcaster.Shutdown()
+		return nil, nil, fmt.Errorf("watching ConfigMaps and Secrets: %w", err)
+	}
+	stop := func() {
+		unwatchConfig()
+		eventBroadcaster.Shutdown()
+	}
+
+	kubeletOpts, err := kubelet.nodeOpts(k8sClient, nodeName)
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+
+	// Create node using Virtual Kubelet's nodeutil
+	nodeOpts := append([]nodeutil.NodeOpt{
+		nodeutil.WithClient(k8sClient),
+		func(nodeCfg *nodeutil.NodeConfig) error {
+			// Configure the node with our custom node spec
+			nodeCfg.NodeSpec = *nodeSpec
+			nodeCfg.NumWorkers = 10
+			nodeCfg.EventRecorder = eventRecorder
+			nodeCfg.InformerResyncPeriod = p.InformerResyncPeriod()
+			return nil
+		},
+	}, kubeletOpts...)
+	nodeRunner, err := nodeutil.NewNode(
+		nodeName,
+		func(providerCfg nodeutil.ProviderConfig) (nodeutil.Provider, node.NodeProvider, error) {
+			// The provider can be updated with the node from providerCfg if needed
+			// For now, just return the provider which implements both interfaces
+			return p, p, nil
+		},
+		nodeOpts...,
+	)
+	if err != nil {
+		stop()
+		return nil, nil, fmt.Errorf("creating node: %w", err)
+	}
+
+	log.Printf("Virtual node '%s' controller created with capacity: CPU=%s, Memory=%s",
+		nodeSpec.Name,
+		nodeSpec.Status.Capacity.Cpu().String(),
+		nodeSpec.Status.Capacity.Memory().String())
+	return nodeRunner, stop, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// Shard membership: every provider instance of a shard group keeps a Lease
+// labelled with the group; the instances whose lease is current share the
+// discovered devices or fleets between them.
+const (
+	// shardGroupLabel labels the membership leases with their shard group.
+	shardGroupLabel = "flightctl.io/shard-group"
+
+	shardLeaseDuration = 30 * time.Second
+	shardRenewInterval = 10 * time.Second
+)
+
+// shardMembership maintains this instance's membership lease and lists the
+// current members of its shard group.
+type shardMembership struct {
+	client    kubernetes.Interface
+	namespace string
+	group     string
+	identity  string
+}
+
+func newShardMembership(client kubernetes.Interface, namespace, group, identity string) *shardMembership {
+	return &shardMembership{client: client, namespace: namespace, group: group, identity: identity}
+}
+
+// shardGroupName returns the shard group of an endpoint's nodes: each
+// endpoint's targets are shared in a group of their own.
+func shardGroupName(group, endpoint string) string {
+	if endpoint == "" {
+		return group
+	}
+	return group + "-" + endpoint
+}
+
+// leaseName returns the name of this instance's membership lease.
+func (m *shardMembership) leaseName() string {
+	return strings.ToLower(m.group + "-" + m.identity)
+}
+
+// run renews the membership lease until ctx is done, then deletes it so the
+// other members take over this instance's share without waiting for the
+// lease to expire.
+func (m *shardMembership) run(ctx context.Context) {
+	ticker := time.NewTicker(shardRenewInterval)
+	defer ticker.Stop()
+	for {
+		if err := m.renew(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("Renewing shard membership lease %s/%s: %v", m.namespace, m.leaseName(), err)
+		}
+		select {
+		case <-ctx.Done():
+			m.leave()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// renew creates or renews the membership lease.
+func (m *shardMembership) renew(ctx context.Context) error {
+	leases := m.client.CoordinationV1().Leases(m.namespace)
+	now := metav1.NewMicroTime(time.Now())
+	duration := int32(shardLeaseDuration / time.Second)
+
+	lease, err := leases.Get(ctx, m.leaseName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   m.leaseName(),
+				Labels: map[string]string{shardGroupLabel: m.group},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &m.identity,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.HolderIdentity = &m.identity
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// leave deletes the membership lease.
+func (m *shardMembership) leave() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := m.client.CoordinationV1().Leases(m.namespace).Delete(ctx, m.leaseName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		logger.Warn("Deleting shard membership lease %s/%s: %v", m.namespace, m.leaseName(), err)
+	}
+}
+
+// members returns the identities of the instances in the shard group whose
+// lease is current, always including this one.
+func (m *shardMembership) members(ctx context.Context) ([]string, error) {
+	list, err := m.client.CoordinationV1().Leases(m.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: shardGroupLabel + "=" + m.group,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing shard group %s leases: %w", m.group, err)
+	}
+	return currentMembers(list.Items, m.identity, time.Now()), nil
+}
+
+// currentMembers returns the holders of the leases that have not expired at
+// now, plus self, sorted.
+func currentMembers(leases []coordinationv1.Lease, self string, now time.Time) []string {
+	seen := map[string]bool{self: true}
+	members := []string{self}
+	for _, lease := range leases {
+		spec := lease.Spec
+		if spec.HolderIdentity == nil || spec.RenewTime == nil || seen[*spec.HolderIdentity] {
+			continue
+		}
+		duration := shardLeaseDuration
+		if spec.LeaseDurationSeconds != nil {
+			duration = time.Duration(*spec.LeaseDurationSeconds) * time.Second
+		}
+		if spec.RenewTime.Add(duration).Before(now) {
+			continue
+		}
+		seen[*spec.HolderIdentity] = true
+		members = append(members, *spec.HolderIdentity)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// shardOwner returns the member that owns key, by rendezvous (highest
+// random weight) hashing: when a member joins or leaves, only the keys it
+// gains or owned move.
+func shardOwner(key string, members []string) string {
+	var owner string
+	var best uint64
+	for _, member := range members {
+		sum := sha256.Sum256([]byte(member + "\x00" + key))
+		if weight := binary.BigEndian.Uint64(sum[:8]); owner == "" || weight > best {
+			owner, best = member, weight
+		}
+	}
+	return owner
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestShardOwnerMovesOnlyTheJoiningMembersShare(t *testing.T) {
+	keys := make([]string, 200)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("device-%d", i)
+	}
+
+	before := []string{"vk-a", "vk-b"}
+	after := []string{"vk-a", "vk-b", "vk-c"}
+	counts := make(map[string]int)
+	for _, key := range keys {
+		owner := shardOwner(key, after)
+		counts[owner]++
+		if previous := shardOwner(key, before); owner != previous && owner != "vk-c" {
+			t.Errorf("%s moved from %s to %s, want only moves to the new member", key, previous, owner)
+		}
+	}
+	for _, member := range after {
+		if counts[member] < len(keys)/6 {
+			t.Errorf("member %s owns %d of %d keys, want a fair share: %v", member, counts[member], len(keys), counts)
+		}
+	}
+}
+
+func TestCurrentMembers(t *testing.T) {
+	now := time.Now()
+	lease := func(holder string, renewed time.Time) coordinationv1.Lease {
+		renewTime := metav1.NewMicroTime(renewed)
+		return coordinationv1.Lease{Spec: coordinationv1.LeaseSpec{HolderIdentity: &holder, RenewTime: &renewTime}}
+	}
+	leases := []coordinationv1.Lease{
+		lease("vk-b", now.Add(-5*time.Second)),
+		lease("vk-c", now.Add(-time.Minute)), // expired
+		lease("vk-a", now),
+	}
+
+	if got := currentMembers(leases, "vk-a", now); !reflect.DeepEqual(got, []string{"vk-a", "vk-b"}) {
+		t.Errorf("members = %v, want [vk-a vk-b]", got)
+	}
+}
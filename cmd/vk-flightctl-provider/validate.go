@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/provider"
+)
+
+func newValidateCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate",
+		Short: "Check the configuration and FlightCtl connectivity without starting the node",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(cmd.Context(), 30*time.Second)
+			defer cancel()
+			return validate(ctx, cmd, opts)
+		},
+	}
+}
+
+// validate runs each check in turn, printing the result, and stops at the
+// first failure.
+func validate(ctx context.Context, cmd *cobra.Command, opts *options) error {
+	out := cmd.OutOrStdout()
+	check := func(name string, err error) error {
+		if err != nil {
+			fmt.Fprintf(out, "FAIL  %s: %v\n", name, err)
+			return fmt.Errorf("validation failed: %s", name)
+		}
+		fmt.Fprintf(out, "OK    %s\n", name)
+		return nil
+	}
+
+	cfg, err := opts.providerConfig()
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err := check("configuration", errors.Join(opts.envError(), err)); err != nil {
+		return err
+	}
+
+	endpoints, err := opts.endpointConfigs(cfg)
+	if err := check("FlightCtl endpoints", err); err != nil {
+		return err
+	}
+	for _, endpoint := range endpoints {
+		// Checks are named after the endpoint, if there are several
+		prefix := ""
+		if endpoint.name != "" {
+			prefix = endpoint.name + ": "
+		}
+		cfg := endpoint.cfg
+		client, err := flightctl.NewClient(cfg.FlightctlConfig())
+		if err := check(prefix+"FlightCtl client", err); err != nil {
+			return err
+		}
+		if err := check(prefix+"access token ("+tokenOrigin(cfg)+")", client.CheckToken(ctx)); err != nil {
+			return err
+		}
+		if err := check(prefix+"FlightCtl API at "+cfg.FlightctlAPIURL, client.Ping(ctx)); err != nil {
+			return err
+		}
+
+		fleets, err := client.ListFleets(ctx)
+		if err := check(prefix+"list fleets", err); err != nil {
+			return err
+		}
+		devices, err := client.ListDevices(ctx, "", nil)
+		if err := check(prefix+"list devices", err); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "\n%sConfiguration is valid: %d fleet(s), %d device(s) visible\n", prefix, len(fleets), len(devices))
+	}
+	return nil
+}
+
+// tokenOrigin describes where the access token comes from.
+func tokenOrigin(cfg provider.Config) string {
+	switch cfg.FlightctlAuthMode {
+	case flightctl.AuthModeToken:
+		return "static token"
+	case flightctl.AuthModeTokenFile:
+		return cfg.FlightctlTokenFile
+	case flightctl.AuthModeNone:
+		return "client certificate only"
+	default:
+		return cfg.FlightctlTokenURL
+	}
+}
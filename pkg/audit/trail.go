@@ -0,0 +1,124 @@
+// Package audit keeps a bounded history of the actions the provider took
+// for pods, so operators can find out what was done to a pod and when.
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// DefaultSize is the number of records kept by default.
+const DefaultSize = 1000
+
+// HandlerPath is where the history is served.
+const HandlerPath = "/debug/reconciles"
+
+// Trail is a bounded in-memory history of reconciliation records. Once full,
+// the oldest records are dropped. It is safe for concurrent use and may be
+// shared by several providers.
+type Trail struct {
+	logRecords bool
+
+	mu      sync.Mutex
+	records []models.ReconciliationRecord // ring buffer
+	next    int                           // index of the next record once full
+	full    bool
+}
+
+// NewTrail returns a trail keeping up to size records. If logRecords is
+// set, every record is also logged with its fields.
+func NewTrail(size int, logRecords bool) *Trail {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Trail{logRecords: logRecords, records: make([]models.ReconciliationRecord, 0, size)}
+}
+
+// Record adds a record to the history.
+func (t *Trail) Record(record models.ReconciliationRecord) {
+	t.mu.Lock()
+	if !t.full {
+		t.records = append(t.records, record)
+		t.full = len(t.records) == cap(t.records)
+	} else {
+		t.records[t.next] = record
+		t.next = (t.next + 1) % len(t.records)
+	}
+	t.mu.Unlock()
+
+	if t.logRecords {
+		fields := []interface{}{
+			"pod", record.PodKey,
+			"operation", record.Operation,
+			"action", record.Action,
+			"result", record.Result,
+			"duration", record.DurationSeconds,
+		}
+		if record.DeviceID != "" {
+			fields = append(fields, "device", record.DeviceID)
+		}
+		if record.ActualState != "" {
+			fields = append(fields, "phase", record.ActualState)
+		}
+		if record.ErrorMessage != "" {
+			fields = append(fields, "error", record.ErrorMessage)
+		}
+		logger.With(fields...).Info("Reconcile %s %s: %s %s %s",
+			record.Operation, record.PodKey, record.Action, record.Result, record.Message)
+	}
+}
+
+// Records returns the records of a pod (namespace/name), or of all pods if
+// podKey is empty, oldest first. A positive limit keeps only the most recent
+// records.
+func (t *Trail) Records(podKey string, limit int) []models.ReconciliationRecord {
+	t.mu.Lock()
+	ordered := make([]models.ReconciliationRecord, 0, len(t.records))
+	ordered = append(ordered, t.records[t.next:]...)
+	ordered = append(ordered, t.records[:t.next]...)
+	t.mu.Unlock()
+
+	records := ordered[:0]
+	for _, record := range ordered {
+		if podKey == "" || record.PodKey == podKey {
+			records = append(records, record)
+		}
+	}
+	if limit > 0 && len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	return records
+}
+
+// ServeHTTP serves the history as JSON, filtered by the pod=namespace/name
+// and limit=N query parameters.
+func (t *Trail) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	podKey := r.URL.Query().Get("pod")
+	if podKey != "" && !strings.Contains(podKey, "/") {
+		http.Error(w, "pod must be namespace/name", http.StatusBadRequest)
+		return
+	}
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			http.Error(w, "limit must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(t.Records(podKey, limit))
+}
@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+func record(pod string, i int) models.ReconciliationRecord {
+	return models.ReconciliationRecord{PodKey: pod, Message: fmt.Sprint(i)}
+}
+
+func TestTrailDropsOldestRecords(t *testing.T) {
+	trail := NewTrail(3, false)
+	for i := 0; i < 5; i++ {
+		trail.Record(record("default/web", i))
+	}
+
+	records := trail.Records("", 0)
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3", len(records))
+	}
+	for i, r := range records {
+		if want := fmt.Sprint(i + 2); r.Message != want {
+			t.Errorf("record %d = %s, want %s", i, r.Message, want)
+		}
+	}
+}
+
+func TestTrailFiltersByPod(t *testing.T) {
+	trail := NewTrail(10, false)
+	for i := 0; i < 6; i++ {
+		pod := "default/web"
+		if i%2 == 1 {
+			pod = "default/db"
+		}
+		trail.Record(record(pod, i))
+	}
+
+	records := trail.Records("default/db", 2)
+	if len(records) != 2 || records[0].Message != "3" || records[1].Message != "5" {
+		t.Errorf("records of default/db limited to 2 = %+v, want the last two", records)
+	}
+}
+
+func TestTrailServesRecords(t *testing.T) {
+	trail := NewTrail(10, false)
+	trail.Record(record("default/web", 0))
+	trail.Record(record("default/db", 1))
+
+	rec := httptest.NewRecorder()
+	trail.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, HandlerPath+"?pod=default/web", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var records []models.ReconciliationRecord
+	if err := json.Unmarshal(rec.Body.Bytes(), &records); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if len(records) != 1 || records[0].PodKey != "default/web" {
+		t.Errorf("records = %+v, want the one of default/web", records)
+	}
+
+	for _, query := range []string{"?pod=web", "?limit=-1", "?limit=x"} {
+		rec := httptest.NewRecorder()
+		trail.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, HandlerPath+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, rec.Code)
+		}
+	}
+}
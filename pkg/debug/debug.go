@@ -0,0 +1,74 @@
+// Package debug serves profiling and runtime state endpoints, for
+// diagnosing memory growth and stuck reconciles in a running provider
+// without attaching a debugger. The endpoints expose internal state and must
+// not be reachable from outside the cluster.
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// StatePath is where the runtime state is served.
+const StatePath = "/debug/state"
+
+// Server serves net/http/pprof under /debug/pprof/, expvar under
+// /debug/vars and the state returned by a func, as JSON, under StatePath.
+type Server struct {
+	server *http.Server
+	state  func() interface{}
+}
+
+// NewServer creates a debug server listening on addr. state is called for
+// each request of StatePath and must be safe for concurrent use.
+func NewServer(addr string, state func() interface{}) *Server {
+	s := &Server{state: state}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc(StatePath, s.handleState)
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return s
+}
+
+// Start serves the endpoints in the background.
+func (s *Server) Start() {
+	go func() {
+		logger.Info("Debug endpoints listening on %s", s.server.Addr)
+		if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Debug server failed: %v", err)
+		}
+	}()
+}
+
+// Shutdown stops the server.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(s.state())
+}
@@ -0,0 +1,26 @@
+package debug
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEndpoints(t *testing.T) {
+	s := NewServer(":0", func() interface{} {
+		return map[string][]string{"nodes": {"vk-1"}}
+	})
+
+	for path, want := range map[string]string{
+		StatePath:       `"vk-1"`,
+		"/debug/vars":   `"memstats"`,
+		"/debug/pprof/": "goroutine",
+	} {
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("GET %s = %d, want 200 with %s:\n%s", path, rec.Code, want, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,383 @@
+// Package devicemirror mirrors the FlightCtl devices visible to the provider
+// into read-only FlightctlDevice custom resources, so platform teams can
+// watch them, build dashboards and write policies with Kubernetes tooling
+// instead of querying FlightCtl.
+package devicemirror
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// Resource is the resource of FlightctlDevice objects.
+var Resource = schema.GroupVersionResource{Group: "flightctl.io", Version: "v1alpha1", Resource: "flightctldevices"}
+
+// Kind is the kind of FlightctlDevice objects.
+const Kind = "FlightctlDevice"
+
+// DefaultInterval is how often devices are mirrored by default.
+const DefaultInterval = time.Minute
+
+// Labels of FlightctlDevice objects.
+const (
+	// ManagedByLabel marks the objects the mirror manages; others in the
+	// namespace are left alone.
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	managedBy      = "vk-flightctl-provider"
+	// EndpointLabel is the FlightCtl endpoint the device belongs to, when
+	// the provider serves several.
+	EndpointLabel = "flightctl.io/endpoint"
+	// FleetLabel is the fleet of the device.
+	FleetLabel = "flightctl.io/fleet-id"
+)
+
+// Condition types of FlightctlDevice objects.
+const (
+	ConditionReady          = "Ready"
+	ConditionConnected      = "Connected"
+	ConditionMemoryPressure = "MemoryPressure"
+	ConditionDiskPressure   = "DiskPressure"
+	ConditionUpdateFailed   = "UpdateFailed"
+	ConditionCordoned       = "Cordoned"
+)
+
+// fieldManager owns the fields of the objects the mirror applies.
+const fieldManager = "vk-flightctl-provider"
+
+// DeviceSpec is what identifies a device in FlightCtl.
+type DeviceSpec struct {
+	DeviceID string            `json:"deviceID"`
+	Name     string            `json:"name,omitempty"`
+	Fleet    string            `json:"fleet,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// SystemInfo is the platform reported by the device agent.
+type SystemInfo struct {
+	OperatingSystem string   `json:"operatingSystem,omitempty"`
+	Architecture    string   `json:"architecture,omitempty"`
+	OSImage         string   `json:"osImage,omitempty"`
+	KernelVersion   string   `json:"kernelVersion,omitempty"`
+	AgentVersion    string   `json:"agentVersion,omitempty"`
+	IPAddresses     []string `json:"ipAddresses,omitempty"`
+}
+
+// DeviceStatus is the state of a device as last read from FlightCtl.
+type DeviceStatus struct {
+	Phase           models.DevicePhase     `json:"phase,omitempty"`
+	ConnectionState models.ConnectionState `json:"connectionState,omitempty"`
+	Message         string                 `json:"message,omitempty"`
+	Capacity        corev1.ResourceList    `json:"capacity,omitempty"`
+	Allocatable     corev1.ResourceList    `json:"allocatable,omitempty"`
+	SystemInfo      SystemInfo             `json:"systemInfo,omitempty"`
+	Conditions      []metav1.Condition     `json:"conditions,omitempty"`
+	// LastSeen is the device's last heartbeat.
+	LastSeen *metav1.Time `json:"lastSeen,omitempty"`
+}
+
+// FlightctlDevice mirrors a FlightCtl device.
+type FlightctlDevice struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DeviceSpec   `json:"spec"`
+	Status DeviceStatus `json:"status,omitempty"`
+}
+
+// Store keeps the FlightctlDevice objects of a namespace.
+type Store interface {
+	// List returns the names of the objects with the labels.
+	List(ctx context.Context, namespace string, labels map[string]string) ([]string, error)
+	// Apply creates or updates an object.
+	Apply(ctx context.Context, device *FlightctlDevice) error
+	// Delete removes an object, if it exists.
+	Delete(ctx context.Context, namespace, name string) error
+}
+
+// crStore keeps the objects as custom resources.
+type crStore struct {
+	client dynamic.Interface
+}
+
+// NewCRStore returns a store keeping the objects as FlightctlDevice custom
+// resources; the CRD must be installed.
+func NewCRStore(client dynamic.Interface) Store {
+	return crStore{client: client}
+}
+
+func (s crStore) List(ctx context.Context, namespace string, labels map[string]string) ([]string, error) {
+	selector := make([]string, 0, len(labels))
+	for key, value := range labels {
+		selector = append(selector, key+"="+value)
+	}
+	list, err := s.client.Resource(Resource).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: strings.Join(selector, ",")})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(list.Items))
+	for _, item := range list.Items {
+		names = append(names, item.GetName())
+	}
+	return names, nil
+}
+
+func (s crStore) Apply(ctx context.Context, device *FlightctlDevice) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(device)
+	if err != nil {
+		return fmt.Errorf("encoding FlightctlDevice %s: %w", device.Name, err)
+	}
+	obj := &unstructured.Unstructured{Object: content}
+	unstructured.RemoveNestedField(obj.Object, "metadata", "creationTimestamp")
+	_, err = s.client.Resource(Resource).Namespace(device.Namespace).Apply(ctx, device.Name, obj,
+		metav1.ApplyOptions{FieldManager: fieldManager, Force: true})
+	return err
+}
+
+func (s crStore) Delete(ctx context.Context, namespace, name string) error {
+	err := s.client.Resource(Resource).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Lister lists the devices of a FlightCtl endpoint.
+type Lister interface {
+	ListDevices(ctx context.Context, fleetID string, labels map[string]string) ([]*models.Device, error)
+}
+
+// Mirror periodically mirrors the devices of a FlightCtl endpoint into a
+// namespace. Each device gets an object named after its ID, prefixed with
+// the endpoint name if set; objects of devices that are gone are deleted.
+// Changes made to the objects are overwritten on the next sync.
+type Mirror struct {
+	devices   Lister
+	store     Store
+	namespace string
+	endpoint  string
+	interval  time.Duration
+
+	written map[string]*FlightctlDevice // name -> object last written
+}
+
+// New returns a mirror of the devices listed by devices into the namespace,
+// synced every interval (DefaultInterval if not positive). endpoint names
+// the FlightCtl endpoint when the provider serves several, or is empty.
+func New(devices Lister, store Store, namespace, endpoint string, interval time.Duration) *Mirror {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Mirror{
+		devices:   devices,
+		store:     store,
+		namespace: namespace,
+		endpoint:  endpoint,
+		interval:  interval,
+		written:   make(map[string]*FlightctlDevice),
+	}
+}
+
+// Run syncs the devices until ctx is done. Failures are logged and retried
+// on the next sync.
+func (m *Mirror) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		if err := m.Sync(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("Failed to mirror FlightCtl devices into namespace %s: %v", m.namespace, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync mirrors the devices once: the objects that changed since they were
+// last written are applied, and those of devices no longer listed are
+// deleted.
+func (m *Mirror) Sync(ctx context.Context) error {
+	devices, err := m.devices.ListDevices(ctx, "", nil)
+	if err != nil {
+		return fmt.Errorf("listing devices: %w", err)
+	}
+
+	var errs []string
+	listed := make(map[string]bool, len(devices))
+	for _, device := range devices {
+		name := m.objectName(device.ID)
+		if msgs := validation.IsDNS1123Subdomain(name); len(msgs) > 0 {
+			logger.Debug("Not mirroring device %s: %s", device.ID, strings.Join(msgs, "; "))
+			continue
+		}
+		listed[name] = true
+		object := m.object(name, device, m.written[name])
+		if written, ok := m.written[name]; ok && reflect.DeepEqual(written, object) {
+			continue
+		}
+		if err := m.store.Apply(ctx, object); err != nil {
+			errs = append(errs, fmt.Sprintf("writing %s: %v", name, err))
+			continue
+		}
+		m.written[name] = object
+	}
+
+	// Objects left over from an earlier run are found by their labels
+	existing, err := m.store.List(ctx, m.namespace, m.selector())
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("listing mirrored devices: %v", err))
+	}
+	for name := range m.written {
+		if !slices.Contains(existing, name) {
+			existing = append(existing, name)
+		}
+	}
+	slices.Sort(existing)
+	for _, name := range existing {
+		if listed[name] {
+			continue
+		}
+		if err := m.store.Delete(ctx, m.namespace, name); err != nil {
+			errs = append(errs, fmt.Sprintf("deleting %s: %v", name, err))
+			continue
+		}
+		delete(m.written, name)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// objectName returns the name of a device's object.
+func (m *Mirror) objectName(deviceID string) string {
+	if m.endpoint == "" {
+		return deviceID
+	}
+	return m.endpoint + "-" + deviceID
+}
+
+// selector returns the labels of the objects the mirror manages.
+func (m *Mirror) selector() map[string]string {
+	labels := map[string]string{ManagedByLabel: managedBy}
+	if m.endpoint != "" {
+		labels[EndpointLabel] = m.endpoint
+	}
+	return labels
+}
+
+// object returns the object of a device. Conditions keep their transition
+// time from the previous object while their status is unchanged.
+func (m *Mirror) object(name string, device *models.Device, previous *FlightctlDevice) *FlightctlDevice {
+	labels := m.selector()
+	if device.FleetID != "" && len(validation.IsValidLabelValue(device.FleetID)) == 0 {
+		labels[FleetLabel] = device.FleetID
+	}
+	object := &FlightctlDevice{
+		TypeMeta: metav1.TypeMeta{APIVersion: Resource.GroupVersion().String(), Kind: Kind},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: m.namespace,
+			Labels:    labels,
+		},
+		Spec: DeviceSpec{
+			DeviceID: device.ID,
+			Name:     device.Name,
+			Fleet:    device.FleetID,
+			Labels:   device.Labels,
+		},
+		Status: DeviceStatus{
+			Phase:           device.Status.Phase,
+			ConnectionState: device.ConnectionState,
+			Message:         device.Status.Message,
+			Capacity:        device.Capacity.ToCore(),
+			Allocatable:     device.Allocatable.ToCore(),
+			SystemInfo: SystemInfo{
+				OperatingSystem: device.SystemInfo.OperatingSystem,
+				Architecture:    device.SystemInfo.Architecture,
+				OSImage:         device.SystemInfo.OSImage,
+				KernelVersion:   device.SystemInfo.KernelVersion,
+				AgentVersion:    device.SystemInfo.AgentVersion,
+				IPAddresses:     device.SystemInfo.IPAddresses,
+			},
+		},
+	}
+	if !device.LastHeartbeat.IsZero() {
+		lastSeen := metav1.NewTime(device.LastHeartbeat.Truncate(time.Second))
+		object.Status.LastSeen = &lastSeen
+	}
+
+	if previous != nil {
+		object.Status.Conditions = slices.Clone(previous.Status.Conditions)
+	}
+	setCondition := func(conditionType string, status bool, reason, message string) {
+		condition := metav1.Condition{Type: conditionType, Status: metav1.ConditionFalse, Reason: reason, Message: message}
+		if status {
+			condition.Status = metav1.ConditionTrue
+		}
+		meta.SetStatusCondition(&object.Status.Conditions, condition)
+	}
+	setCondition(ConditionReady, device.IsReady(), reasonOr(device.Status.Reason, string(device.Status.Phase)), device.Status.Message)
+	setCondition(ConditionConnected, device.ConnectionState == models.Connected, reasonOr(string(device.ConnectionState), "Unknown"), "")
+	setCondition(ConditionMemoryPressure, device.Health.MemoryPressure, pressureReason(device.Health.MemoryPressure), "")
+	setCondition(ConditionDiskPressure, device.Health.DiskPressure, pressureReason(device.Health.DiskPressure), "")
+	setCondition(ConditionUpdateFailed, device.Health.UpdateError != "", updateReason(device.Health.UpdateError), device.Health.UpdateError)
+	setCondition(ConditionCordoned, device.Cordoned, cordonReason(device), "")
+	return object
+}
+
+// conditionReason matches valid condition reasons.
+var conditionReason = regexp.MustCompile(`^[A-Za-z]([A-Za-z0-9_,:]*[A-Za-z0-9_])?$`)
+
+// reasonOr returns reason, or fallback if it is not a valid condition
+// reason.
+func reasonOr(reason, fallback string) string {
+	if !conditionReason.MatchString(reason) {
+		return fallback
+	}
+	return reason
+}
+
+func pressureReason(pressure bool) string {
+	if pressure {
+		return "Critical"
+	}
+	return "Normal"
+}
+
+func updateReason(updateError string) string {
+	if updateError != "" {
+		return "UpdateFailed"
+	}
+	return "Updated"
+}
+
+func cordonReason(device *models.Device) string {
+	switch {
+	case device.Draining:
+		return "Draining"
+	case device.Cordoned:
+		return "Cordoned"
+	}
+	return "Schedulable"
+}
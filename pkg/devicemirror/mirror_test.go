@@ -0,0 +1,131 @@
+package devicemirror
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+// memoryStore keeps objects in memory.
+type memoryStore struct {
+	objects map[string]*FlightctlDevice
+	applies int
+}
+
+func (s *memoryStore) List(ctx context.Context, namespace string, labels map[string]string) ([]string, error) {
+	var names []string
+	for name, object := range s.objects {
+		matches := object.Namespace == namespace
+		for key, value := range labels {
+			matches = matches && object.Labels[key] == value
+		}
+		if matches {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (s *memoryStore) Apply(ctx context.Context, device *FlightctlDevice) error {
+	s.objects[device.Name] = device
+	s.applies++
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, namespace, name string) error {
+	delete(s.objects, name)
+	return nil
+}
+
+func TestMirrorSync(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("d1", "edge", map[string]string{"region": "galway", flightctl.CapacityCPULabel: "4"})
+	server.AddDevice("d2", "edge", nil)
+	client, err := server.NewClient()
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer client.Close()
+
+	// An object left over from an earlier run of the mirror
+	store := &memoryStore{objects: map[string]*FlightctlDevice{
+		"gone": {ObjectMeta: metav1.ObjectMeta{Name: "gone", Namespace: "devices", Labels: map[string]string{ManagedByLabel: managedBy}}},
+	}}
+	mirror := New(client, store, "devices", "", time.Minute)
+	ctx := context.Background()
+	if err := mirror.Sync(ctx); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	if _, ok := store.objects["gone"]; ok {
+		t.Error("object of a device no longer listed was not deleted")
+	}
+	d1, ok := store.objects["d1"]
+	if !ok {
+		t.Fatalf("no object for d1 in %v", store.objects)
+	}
+	if d1.Spec.Fleet != "edge" || d1.Spec.Labels["region"] != "galway" || d1.Labels[FleetLabel] != "edge" {
+		t.Errorf("d1 = %+v, want fleet edge and its labels", d1)
+	}
+	if cpu := d1.Status.Capacity.Cpu(); cpu.String() != "4" {
+		t.Errorf("d1 CPU capacity = %s, want 4", cpu)
+	}
+	ready := meta.FindStatusCondition(d1.Status.Conditions, ConditionReady)
+	if ready == nil || ready.Status != metav1.ConditionTrue {
+		t.Fatalf("d1 Ready condition = %+v, want True", ready)
+	}
+
+	// Unchanged devices are not written again
+	applies := store.applies
+	if err := mirror.Sync(ctx); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if store.applies != applies {
+		t.Errorf("%d objects written again without changes", store.applies-applies)
+	}
+
+	if err := server.SetDeviceSummary("d1", "Offline"); err != nil {
+		t.Fatal(err)
+	}
+	server.RemoveDevice("d2")
+	if err := mirror.Sync(ctx); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if _, ok := store.objects["d2"]; ok {
+		t.Error("object of removed device d2 was not deleted")
+	}
+	if ready := meta.FindStatusCondition(store.objects["d1"].Status.Conditions, ConditionReady); ready.Status != metav1.ConditionFalse {
+		t.Errorf("d1 Ready condition = %+v after going offline, want False", ready)
+	}
+}
+
+func TestMirrorEndpointNames(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	client, err := server.NewClient()
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer client.Close()
+
+	store := &memoryStore{objects: map[string]*FlightctlDevice{}}
+	if err := New(client, store, "devices", "lab", 0).Sync(context.Background()); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	object, ok := store.objects["lab-d1"]
+	if !ok {
+		t.Fatalf("no object lab-d1 in %v", store.objects)
+	}
+	if object.Labels[EndpointLabel] != "lab" || object.Spec.DeviceID != "d1" {
+		t.Errorf("object = %+v, want device d1 of endpoint lab", object)
+	}
+}
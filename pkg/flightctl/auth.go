@@ -0,0 +1,126 @@
+package flightctl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authentication modes for the FlightCtl API.
+const (
+	// AuthModeOAuth obtains tokens with the OAuth 2.0 client credentials
+	// flow (default).
+	AuthModeOAuth = "oauth"
+	// AuthModeToken sends a static bearer token.
+	AuthModeToken = "token"
+	// AuthModeTokenFile sends the token read from a file, re-read whenever
+	// the file changes (e.g. a projected service account token).
+	AuthModeTokenFile = "token-file"
+	// AuthModeNone sends no bearer token, for deployments that authenticate
+	// the provider by its mutual TLS client certificate.
+	AuthModeNone = "none"
+)
+
+// tokenSource provides the bearer token for API requests.
+type tokenSource interface {
+	getToken(ctx context.Context) (string, error)
+}
+
+// staticTokenSource always returns the same token.
+type staticTokenSource string
+
+func (s staticTokenSource) getToken(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+// fileTokenSource reads the token from a file and re-reads it when the
+// file's modification time or size changes. Kubernetes rotates projected
+// tokens by swapping a symlink, which os.Stat follows.
+type fileTokenSource struct {
+	path string
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+func (s *fileTokenSource) getToken(ctx context.Context) (string, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return "", fmt.Errorf("reading token file: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.token, nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return "", fmt.Errorf("reading token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", s.path)
+	}
+
+	s.token = token
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return s.token, nil
+}
+
+// invalidate forces the file to be re-read on the next request.
+func (s *fileTokenSource) invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+// newTokenSource validates the authentication settings of cfg and returns
+// the matching token source. httpClient is used for OAuth token requests.
+func newTokenSource(cfg Config, httpClient *http.Client) (tokenSource, error) {
+	switch cfg.AuthMode {
+	case "", AuthModeOAuth:
+		if cfg.ClientID == "" {
+			return nil, fmt.Errorf("Flightctl client ID is required")
+		}
+		if cfg.ClientSecret == "" && cfg.RefreshToken == "" {
+			return nil, fmt.Errorf("Flightctl client secret or refresh token is required")
+		}
+		if cfg.TokenURL == "" {
+			return nil, fmt.Errorf("Flightctl token URL is required")
+		}
+		return &tokenManager{
+			clientID:     cfg.ClientID,
+			clientSecret: cfg.ClientSecret,
+			tokenURL:     cfg.TokenURL,
+			httpClient:   httpClient,
+			refreshToken: cfg.RefreshToken,
+		}, nil
+	case AuthModeToken:
+		if cfg.Token == "" {
+			return nil, fmt.Errorf("Flightctl token is required for auth mode %s", AuthModeToken)
+		}
+		return staticTokenSource(cfg.Token), nil
+	case AuthModeTokenFile:
+		if cfg.TokenFile == "" {
+			return nil, fmt.Errorf("Flightctl token file is required for auth mode %s", AuthModeTokenFile)
+		}
+		return &fileTokenSource{path: cfg.TokenFile}, nil
+	case AuthModeNone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown Flightctl auth mode %q (expected %s, %s, %s or %s)",
+			cfg.AuthMode, AuthModeOAuth, AuthModeToken, AuthModeTokenFile, AuthModeNone)
+	}
+}
@@ -0,0 +1,70 @@
+package flightctl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileTokenSourceRereadsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("first\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	src := &fileTokenSource{path: path}
+	token, err := src.getToken(context.Background())
+	if err != nil || token != "first" {
+		t.Fatalf("getToken = %q, %v; want first", token, err)
+	}
+
+	if err := os.WriteFile(path, []byte("second-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure the change is visible even on coarse mtime filesystems
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err = src.getToken(context.Background())
+	if err != nil || token != "second-token" {
+		t.Fatalf("getToken after rotation = %q, %v; want second-token", token, err)
+	}
+}
+
+func TestStaticTokenAuth(t *testing.T) {
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(Config{APIURL: srv.URL, AuthMode: AuthModeToken, Token: "s3cr3t"})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if auth != "Bearer s3cr3t" {
+		t.Errorf("Authorization = %q, want Bearer s3cr3t", auth)
+	}
+}
+
+func TestNewClientRejectsIncompleteAuth(t *testing.T) {
+	for _, cfg := range []Config{
+		{APIURL: "https://api", ClientID: "id"},
+		{APIURL: "https://api", AuthMode: AuthModeToken},
+		{APIURL: "https://api", AuthMode: AuthModeTokenFile},
+		{APIURL: "https://api", AuthMode: "kerberos"},
+	} {
+		if _, err := NewClient(cfg); err == nil {
+			t.Errorf("NewClient(%+v) succeeded, want error", cfg)
+		}
+	}
+}
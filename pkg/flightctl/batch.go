@@ -0,0 +1,145 @@
+package flightctl
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// DefaultBatchWindow is how long the first application change queued for a
+// device waits for others to join it in the same device update.
+const DefaultBatchWindow = 20 * time.Millisecond
+
+// applicationChange is a change to a device's applications waiting to be
+// written.
+type applicationChange struct {
+	ctx    context.Context
+	modify func(device *FlightctlDevice) (bool, error)
+	done   chan error
+}
+
+// deviceQueue holds the changes queued for one device.
+type deviceQueue struct {
+	changes []*applicationChange
+}
+
+// writeBatcher coalesces the application changes made to a device within a
+// short window, and while an update of the device is in flight, into a
+// single read-modify-write of the device, so concurrent pod operations on a
+// device neither race nor each cost a GET and PUT.
+type writeBatcher struct {
+	mu     sync.Mutex
+	window time.Duration
+	queues map[string]*deviceQueue // deviceID -> queue, present while a writer runs
+}
+
+func newWriteBatcher(window time.Duration) *writeBatcher {
+	return &writeBatcher{window: window, queues: make(map[string]*deviceQueue)}
+}
+
+// changeApplications queues a change to a device's applications and waits
+// until it is written. modify is applied to the device in the update with
+// the other changes queued for it and must leave the device unchanged when
+// it returns an error; that error fails only this change. If ctx is
+// cancelled while waiting, the change may still be written.
+func (pm *PodManager) changeApplications(ctx context.Context, deviceID string, modify func(device *FlightctlDevice) (bool, error)) error {
+	change := &applicationChange{ctx: ctx, modify: modify, done: make(chan error, 1)}
+
+	b := pm.batches
+	b.mu.Lock()
+	queue, writing := b.queues[deviceID]
+	if !writing {
+		queue = &deviceQueue{}
+		b.queues[deviceID] = queue
+	}
+	queue.changes = append(queue.changes, change)
+	b.mu.Unlock()
+
+	if !writing {
+		go pm.writeQueue(deviceID, queue)
+	}
+
+	select {
+	case err := <-change.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writeQueue writes the changes queued for a device in batches until the
+// queue stays empty for a window.
+func (pm *PodManager) writeQueue(deviceID string, queue *deviceQueue) {
+	b := pm.batches
+	for {
+		time.Sleep(b.window)
+
+		b.mu.Lock()
+		changes := queue.changes
+		queue.changes = nil
+		if len(changes) == 0 {
+			delete(b.queues, deviceID)
+			b.mu.Unlock()
+			return
+		}
+		b.mu.Unlock()
+
+		pm.writeChanges(deviceID, changes)
+	}
+}
+
+// writeChanges applies a batch of changes to a device in one update and
+// reports each change's result to its caller.
+func (pm *PodManager) writeChanges(deviceID string, changes []*applicationChange) {
+	// The update outlives any single caller, so it is not cancelled with
+	// the context of the change that started it
+	ctx := context.WithoutCancel(changes[0].ctx)
+	if len(changes) > 1 {
+		logger.FromContext(ctx).With("device", deviceID).Info("Writing %d application changes to device %s in one update", len(changes), deviceID)
+	}
+
+	errs := make([]error, len(changes))
+	changed := make([]bool, len(changes))
+	read := false
+	err := pm.updateApplications(ctx, deviceID, func(device *FlightctlDevice) (bool, error) {
+		read = true
+		modified := false
+		for i, change := range changes {
+			changed[i], errs[i] = change.modify(device)
+			modified = modified || changed[i]
+		}
+		return modified, nil
+	})
+
+	for i, change := range changes {
+		// A failed update fails the changes it was to write, and all
+		// changes if the device could not be read
+		if errs[i] == nil && err != nil && (changed[i] || !read) {
+			errs[i] = err
+		}
+		change.done <- errs[i]
+	}
+}
+
+// Flush waits until the application changes queued for all devices are
+// written, or ctx is done.
+func (pm *PodManager) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(pm.batches.window + time.Millisecond)
+	defer ticker.Stop()
+	for {
+		pm.batches.mu.Lock()
+		pending := len(pm.batches.queues)
+		pm.batches.mu.Unlock()
+		if pending == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("flushing updates of %d device(s): %w", pending, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package flightctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// Default circuit breaker values.
+const (
+	DefaultBreakerFailureThreshold = 5
+	DefaultBreakerCooldown         = 5 * time.Second
+	DefaultBreakerMaxCooldown      = 2 * time.Minute
+)
+
+// BreakerPolicy controls the circuit breaker that stops calling the
+// FlightCtl API while it is down. After FailureThreshold consecutive
+// requests failed (once their retries are exhausted), requests fail fast
+// with ErrUnavailable for Cooldown. A single trial request is then let
+// through: if it succeeds the breaker closes, otherwise it opens again for
+// twice as long, up to MaxCooldown.
+type BreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failed requests that
+	// open the breaker; 0 uses DefaultBreakerFailureThreshold and a
+	// negative value disables the breaker.
+	FailureThreshold int
+	// Cooldown is how long the breaker stays open before a trial request.
+	Cooldown time.Duration
+	// MaxCooldown caps the cooldown after failed trials.
+	MaxCooldown time.Duration
+}
+
+// withDefaults fills unset fields with the default policy values.
+func (p BreakerPolicy) withDefaults() BreakerPolicy {
+	if p.FailureThreshold == 0 {
+		p.FailureThreshold = DefaultBreakerFailureThreshold
+	}
+	if p.Cooldown <= 0 {
+		p.Cooldown = DefaultBreakerCooldown
+	}
+	if p.MaxCooldown <= 0 {
+		p.MaxCooldown = DefaultBreakerMaxCooldown
+	}
+	if p.MaxCooldown < p.Cooldown {
+		p.MaxCooldown = p.Cooldown
+	}
+	return p
+}
+
+// breakerTransport wraps an http.RoundTripper with a circuit breaker.
+type breakerTransport struct {
+	base   http.RoundTripper
+	policy BreakerPolicy
+
+	mu        sync.Mutex
+	failures  int       // Consecutive failed requests
+	failingAt time.Time // First failure of the current streak
+	open      bool
+	openUntil time.Time
+	cooldown  time.Duration
+	trial     bool // A trial request is in flight
+}
+
+// RoundTrip implements http.RoundTripper interface.
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.policy.FailureThreshold < 0 {
+		return t.base.RoundTrip(req)
+	}
+	trial, err := t.allow()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	t.record(req.Context(), trial, resp, err)
+	return resp, err
+}
+
+// allow returns an ErrUnavailable error while the breaker is open, and
+// reports whether the request is the trial of a half-open breaker.
+func (t *breakerTransport) allow() (trial bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.open {
+		return false, nil
+	}
+	if now := time.Now(); now.Before(t.openUntil) || t.trial {
+		return false, &FlightctlError{
+			Code:    ErrUnavailable.Code,
+			Message: ErrUnavailable.Message,
+			Details: fmt.Sprintf("FlightCtl API failing since %s, calls paused", t.failingAt.Format(time.RFC3339)),
+		}
+	}
+	t.trial = true
+	return true, nil
+}
+
+// record updates the breaker with the outcome of a request. Requests
+// cancelled by their caller count neither way; those that ran out of their
+// operation timeout count as failures, so a hung API pauses calls too.
+func (t *breakerTransport) record(ctx context.Context, trial bool, resp *http.Response, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if trial {
+		t.trial = false
+	}
+
+	cancelled := err != nil && (ctx.Err() != nil || errors.Is(err, context.Canceled)) && !operationTimedOut(ctx)
+	if cancelled {
+		return
+	}
+	if !failed(resp, err) {
+		if t.open {
+			logger.Info("FlightCtl API recovered after failing since %s, resuming calls", t.failingAt.Format(time.RFC3339))
+		}
+		t.failures = 0
+		t.failingAt = time.Time{}
+		t.open = false
+		t.cooldown = 0
+		return
+	}
+
+	t.failures++
+	if t.failures == 1 {
+		t.failingAt = time.Now()
+	}
+	switch {
+	case trial:
+		t.cooldown = min(2*t.cooldown, t.policy.MaxCooldown)
+		t.openUntil = time.Now().Add(t.cooldown)
+		logger.Debug("FlightCtl API trial request failed, pausing calls for %s", t.cooldown)
+	case !t.open && t.failures >= t.policy.FailureThreshold:
+		t.open = true
+		t.cooldown = t.policy.Cooldown
+		t.openUntil = time.Now().Add(t.cooldown)
+		logger.Warn("FlightCtl API failed %d consecutive requests, pausing calls for %s", t.failures, t.cooldown)
+	}
+}
+
+// unavailableSince returns the start of the failures that opened the
+// breaker, or zero while it is closed.
+func (t *breakerTransport) unavailableSince() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.open {
+		return time.Time{}
+	}
+	return t.failingAt
+}
+
+// CloseIdleConnections closes idle connections of the base transport.
+func (t *breakerTransport) CloseIdleConnections() {
+	closeIdleConnections(t.base)
+}
+
+// failed reports whether a request failed because of the API: a transport
+// error or a 5xx or 429 response.
+func failed(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+}
@@ -0,0 +1,83 @@
+package flightctl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreakerPausesCallsWhileAPIIsDown(t *testing.T) {
+	var calls, down int32 = 0, 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"metadata":{"name":"dev-1"},"spec":{}}`))
+	}))
+	defer srv.Close()
+
+	breaker := &breakerTransport{
+		base:   srv.Client().Transport,
+		policy: BreakerPolicy{FailureThreshold: 2, Cooldown: 20 * time.Millisecond}.withDefaults(),
+	}
+	client := &Client{httpClient: &http.Client{Transport: breaker}, baseURL: srv.URL, breaker: breaker}
+	ctx := context.Background()
+
+	for range 2 {
+		if _, err := client.GetDevice(ctx, "dev-1"); err == nil {
+			t.Fatal("expected an error from the failing API")
+		}
+	}
+	if client.UnavailableSince().IsZero() {
+		t.Fatal("breaker not open after the failure threshold")
+	}
+
+	// Open: calls fail fast without reaching the API
+	_, err := client.GetDevice(ctx, "dev-1")
+	if !errors.Is(err, ErrUnavailable) || calls != 2 {
+		t.Fatalf("open breaker: err=%v after %d calls, want ErrUnavailable after 2", err, calls)
+	}
+
+	// A failed trial keeps it open, for longer
+	time.Sleep(25 * time.Millisecond)
+	if _, err := client.GetDevice(ctx, "dev-1"); err == nil || calls != 3 {
+		t.Fatalf("trial: err=%v after %d calls, want a failure after 3", err, calls)
+	}
+	if _, err := client.GetDevice(ctx, "dev-1"); !errors.Is(err, ErrUnavailable) || calls != 3 {
+		t.Fatalf("after a failed trial: err=%v after %d calls, want ErrUnavailable after 3", err, calls)
+	}
+
+	// A successful trial closes it
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(45 * time.Millisecond)
+	if _, err := client.GetDevice(ctx, "dev-1"); err != nil {
+		t.Fatalf("trial after recovery: %v", err)
+	}
+	if !client.UnavailableSince().IsZero() {
+		t.Error("breaker still open after a successful call")
+	}
+}
+
+func TestBreakerIgnoresClientErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	breaker := &breakerTransport{
+		base:   srv.Client().Transport,
+		policy: BreakerPolicy{FailureThreshold: 1}.withDefaults(),
+	}
+	client := &Client{httpClient: &http.Client{Transport: breaker}, baseURL: srv.URL, breaker: breaker}
+	for range 3 {
+		if _, err := client.GetDevice(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetDevice error = %v, want ErrNotFound", err)
+		}
+	}
+}
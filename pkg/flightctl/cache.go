@@ -0,0 +1,153 @@
+package flightctl
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// DefaultDeviceCacheTTL is how long a device read is served from the cache
+// by default. It is well below the reconcile and disconnect check
+// intervals, so those loops, status queries and node refreshes reading the
+// same device within a cycle share one request.
+const DefaultDeviceCacheTTL = 5 * time.Second
+
+// cachedDevice is a device read kept by the device cache.
+type cachedDevice struct {
+	snapshot models.DeviceStatusSnapshot
+	body     []byte // Device resource as returned by the API
+}
+
+// deviceCache keeps recent device reads for a TTL. Entries are stored as the
+// response body so every hit decodes a copy the caller may modify.
+type deviceCache struct {
+	ttl time.Duration
+
+	mu         sync.Mutex
+	devices    map[string]*cachedDevice // deviceID -> last read
+	generation map[string]uint64        // deviceID -> invalidations, to drop reads racing a write
+	lastPrune  time.Time
+}
+
+func newDeviceCache(ttl time.Duration) *deviceCache {
+	return &deviceCache{
+		ttl:        ttl,
+		devices:    make(map[string]*cachedDevice),
+		generation: make(map[string]uint64),
+	}
+}
+
+// get returns the cached device if it is fresh.
+func (c *deviceCache) get(deviceID string) (*FlightctlDevice, bool) {
+	c.mu.Lock()
+	entry, ok := c.devices[deviceID]
+	c.mu.Unlock()
+	if !ok || entry.snapshot.IsExpired(c.ttl) {
+		return nil, false
+	}
+	var device FlightctlDevice
+	if err := json.Unmarshal(entry.body, &device); err != nil {
+		return nil, false
+	}
+	return &device, true
+}
+
+// start returns the generation a read of the device must be stored with.
+func (c *deviceCache) start(deviceID string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation[deviceID]
+}
+
+// put stores a device read unless the device was written since the read
+// started, and drops expired entries once per TTL.
+func (c *deviceCache) put(deviceID string, generation uint64, device *FlightctlDevice, body []byte) {
+	model := device.ToModel()
+	entry := &cachedDevice{
+		snapshot: models.DeviceStatusSnapshot{
+			DeviceID:        deviceID,
+			Timestamp:       time.Now(),
+			Status:          model.Status,
+			ConnectionState: model.ConnectionState,
+			Allocatable:     model.Allocatable,
+		},
+		body: body,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation[deviceID] != generation {
+		return
+	}
+	c.devices[deviceID] = entry
+
+	if time.Since(c.lastPrune) < c.ttl {
+		return
+	}
+	c.lastPrune = time.Now()
+	for id, cached := range c.devices {
+		if cached.snapshot.IsExpired(c.ttl) {
+			delete(c.devices, id)
+			delete(c.generation, id)
+		}
+	}
+}
+
+// invalidate drops the cached device, and any read of it in flight.
+func (c *deviceCache) invalidate(deviceID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.devices, deviceID)
+	c.generation[deviceID]++
+}
+
+// CachedDevice describes a device read kept by the device cache.
+type CachedDevice struct {
+	DeviceID        string                 `json:"device"`
+	ReadAt          time.Time              `json:"readAt"`
+	Fresh           bool                   `json:"fresh"` // Still served instead of reading the device
+	ConnectionState models.ConnectionState `json:"connectionState,omitempty"`
+}
+
+// entries describes the cached devices, sorted by ID.
+func (c *deviceCache) entries() []CachedDevice {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := make([]CachedDevice, 0, len(c.devices))
+	for deviceID, entry := range c.devices {
+		entries = append(entries, CachedDevice{
+			DeviceID:        deviceID,
+			ReadAt:          entry.snapshot.Timestamp,
+			Fresh:           !entry.snapshot.IsExpired(c.ttl),
+			ConnectionState: entry.snapshot.ConnectionState,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].DeviceID < entries[j].DeviceID })
+	return entries
+}
+
+// CachedDevices describes the device reads the client caches, nil if
+// caching is disabled.
+func (c *Client) CachedDevices() []CachedDevice {
+	if c.cache == nil {
+		return nil
+	}
+	return c.cache.entries()
+}
+
+type bypassCacheKey struct{}
+
+// BypassCache returns a context whose device reads go to the API even if a
+// fresh copy is cached, for reads that must see the latest resourceVersion.
+func BypassCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassCacheKey{}, true)
+}
+
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassCacheKey{}).(bool)
+	return bypass
+}
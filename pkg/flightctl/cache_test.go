@@ -0,0 +1,49 @@
+package flightctl
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDeviceCacheExpires(t *testing.T) {
+	cache := newDeviceCache(time.Minute)
+	device := &FlightctlDevice{Metadata: FlightctlDeviceMetadata{Name: "dev-1"}}
+	body, _ := json.Marshal(device)
+
+	cache.put("dev-1", cache.start("dev-1"), device, body)
+	cached, ok := cache.get("dev-1")
+	if !ok || cached.Metadata.Name != "dev-1" {
+		t.Fatalf("get = %+v, %v; want the cached device", cached, ok)
+	}
+
+	// Callers get their own copy
+	cached.Metadata.Name = "changed"
+	if again, _ := cache.get("dev-1"); again.Metadata.Name != "dev-1" {
+		t.Errorf("cached device modified through a returned copy")
+	}
+
+	cache.devices["dev-1"].snapshot.Timestamp = time.Now().Add(-2 * time.Minute)
+	if _, ok := cache.get("dev-1"); ok {
+		t.Error("expected an expired entry to be a miss")
+	}
+}
+
+func TestDeviceCacheDropsReadsRacingWrites(t *testing.T) {
+	cache := newDeviceCache(time.Minute)
+	device := &FlightctlDevice{Metadata: FlightctlDeviceMetadata{Name: "dev-1"}}
+	body, _ := json.Marshal(device)
+
+	// A read that started before a write may return the old device
+	generation := cache.start("dev-1")
+	cache.invalidate("dev-1")
+	cache.put("dev-1", generation, device, body)
+	if _, ok := cache.get("dev-1"); ok {
+		t.Error("expected a read racing a write not to be cached")
+	}
+
+	cache.put("dev-1", cache.start("dev-1"), device, body)
+	if _, ok := cache.get("dev-1"); !ok {
+		t.Error("expected a read after the write to be cached")
+	}
+}
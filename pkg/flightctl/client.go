@@ -3,142 +3,188 @@ package flightctl
 import (
 	"context"
 	"crypto/tls"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
-	"strings"
-	"sync"
 	"time"
 
+	"golang.org/x/sync/singleflight"
+
 	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/tracing"
 )
 
 // Client wraps the Flightctl HTTP API.
 type Client struct {
-	httpClient   *http.Client
-	baseURL      string
-	tokenManager *tokenManager
+	httpClient  *http.Client
+	baseURL     string
+	tokenSource tokenSource
+
+	// TLS settings for connections outside httpClient (console websockets)
+	tls         *tlsFiles
+	insecureTLS bool
+
+	// Recent device reads, nil if caching is disabled
+	cache *deviceCache
+	// Device reads in flight, shared by concurrent readers
+	deviceReads singleflight.Group
+
+	// Pauses calls while the API is down
+	breaker *breakerTransport
+	// Bounds of each kind of call
+	timeouts OperationTimeouts
+
+	// Records the API traffic, if enabled
+	recorder *recordingTransport
+
+	// Devices written in dry-run mode, nil unless dry-running
+	dryRun *dryRunDevices
 }
 
 // Config holds Flightctl client configuration.
 type Config struct {
-	APIURL       string
+	APIURL string
+
+	// AuthMode selects how requests are authenticated: AuthModeOAuth
+	// (default, uses ClientID, TokenURL and ClientSecret and/or
+	// RefreshToken), AuthModeToken (uses Token), AuthModeTokenFile (uses
+	// TokenFile) or AuthModeNone.
+	AuthMode     string
 	ClientID     string
 	ClientSecret string
 	TokenURL     string
-	InsecureTLS  bool
-	Timeout      time.Duration
+	// RefreshToken is an initial OAuth refresh token, e.g. an offline token
+	// for a public client without a secret.
+	RefreshToken string
+	Token        string
+	TokenFile    string
+
+	// ClientCertFile and ClientKeyFile hold a client certificate for mutual
+	// TLS; CAFile and CAData are PEM bundles used instead of the system roots
+	// to verify the server. The files are reloaded when they change.
+	ClientCertFile string
+	ClientKeyFile  string
+	CAFile         string
+	CAData         []byte
+
+	// InsecureTLS disables server certificate verification. Prefer CAFile
+	// or CAData for servers with a private CA.
+	InsecureTLS bool
+	Timeout     time.Duration
+	Retry       RetryPolicy
+	Breaker     BreakerPolicy
+	// OperationTimeouts bound each call, including its retries.
+	OperationTimeouts OperationTimeouts
+
+	// DeviceCacheTTL is how long device reads are served from a cache
+	// (DefaultDeviceCacheTTL is a good value); 0 disables caching. Writes
+	// to a device invalidate its cached copy.
+	DeviceCacheTTL time.Duration
+
+	// Metrics records the client's requests; nil disables it. It may be
+	// shared by several clients.
+	Metrics *TransportMetrics
+
+	// RecordFile is a file the API traffic is appended to, sanitized, and
+	// ReplayFile a recording the API responses are served from instead of
+	// the API, without authenticating. At most one of them may be set.
+	RecordFile string
+	ReplayFile string
+
+	// DryRun logs device updates as a diff instead of writing them, and
+	// serves the devices as last written; see UpdateDevice.
+	DryRun bool
 }
 
-// tokenManager handles OAuth 2.0 token acquisition and refresh.
-type tokenManager struct {
-	clientID     string
-	clientSecret string
-	tokenURL     string
-	httpClient   *http.Client
-
-	mu          sync.RWMutex
-	accessToken string
-	expiresAt   time.Time
+// authTransport wraps an http.RoundTripper and adds bearer tokens.
+type authTransport struct {
+	base        http.RoundTripper
+	tokenSource tokenSource
 }
 
-// tokenResponse represents the OAuth 2.0 token response.
-type tokenResponse struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
-	ExpiresIn   int    `json:"expires_in"`
-}
-
-// oauth2Transport wraps an http.RoundTripper and adds OAuth 2.0 bearer tokens.
-type oauth2Transport struct {
-	base         http.RoundTripper
-	tokenManager *tokenManager
-}
-
-// RoundTrip implements http.RoundTripper interface.
-func (t *oauth2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
-	// Get a valid token
-	token, err := t.tokenManager.getToken(req.Context())
+// RoundTrip implements http.RoundTripper interface. A request rejected with
+// 401 is retried once with a freshly obtained token, in case the token was
+// revoked or rotated before its expiry.
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.token(req.Context(), "")
 	if err != nil {
-		return nil, fmt.Errorf("getting access token: %w", err)
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(t.authorize(req, req.Body, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || token == "" {
+		return resp, err
 	}
 
-	// Clone the request to avoid modifying the original
-	reqClone := req.Clone(req.Context())
-	reqClone.Header.Set("Authorization", "Bearer "+token)
-
-	// Perform the request
-	return t.base.RoundTrip(reqClone)
-}
+	// Requests with a body can only be repeated if it can be rewound
+	body := req.Body
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return resp, nil
+		}
+		if body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
 
-// getToken returns a valid access token, fetching a new one if necessary.
-func (tm *tokenManager) getToken(ctx context.Context) (string, error) {
-	tm.mu.RLock()
-	if tm.accessToken != "" && time.Now().Before(tm.expiresAt) {
-		token := tm.accessToken
-		tm.mu.RUnlock()
-		return token, nil
+	newToken, err := t.token(req.Context(), token)
+	if err != nil || newToken == token {
+		if body != nil && body != req.Body {
+			body.Close()
+		}
+		return resp, nil
 	}
-	tm.mu.RUnlock()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
 
-	return tm.fetchToken(ctx)
+	logger.FromContext(req.Context()).Debug("%s %s returned 401, retrying with a new access token", req.Method, req.URL.Path)
+	return t.base.RoundTrip(t.authorize(req, body, newToken))
 }
 
-// fetchToken obtains a new access token using client credentials flow.
-func (tm *tokenManager) fetchToken(ctx context.Context) (string, error) {
-	tm.mu.Lock()
-	defer tm.mu.Unlock()
-
-	// Double-check: another goroutine might have fetched the token
-	if tm.accessToken != "" && time.Now().Before(tm.expiresAt) {
-		return tm.accessToken, nil
+// token returns the bearer token for a request, or "" if authentication
+// relies on client certificates. A non-empty rejected token is invalidated
+// first so a new one is obtained.
+func (t *authTransport) token(ctx context.Context, rejected string) (string, error) {
+	if t.tokenSource == nil {
+		return "", nil
 	}
-
-	// Prepare the OAuth 2.0 client credentials request
-	data := url.Values{}
-	data.Set("grant_type", "client_credentials")
-	data.Set("client_id", tm.clientID)
-	data.Set("client_secret", tm.clientSecret)
-
-	req, err := http.NewRequestWithContext(ctx, "POST", tm.tokenURL, strings.NewReader(data.Encode()))
-	if err != nil {
-		return "", fmt.Errorf("creating token request: %w", err)
+	if rejected != "" {
+		inv, ok := t.tokenSource.(interface{ invalidate(token string) })
+		if !ok {
+			return rejected, nil
+		}
+		inv.invalidate(rejected)
 	}
-
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	resp, err := tm.httpClient.Do(req)
+	token, err := t.tokenSource.getToken(ctx)
 	if err != nil {
-		return "", fmt.Errorf("token request failed: %w", err)
+		return "", fmt.Errorf("getting access token: %w", err)
 	}
-	defer resp.Body.Close()
+	return token, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("token request returned status %d: %s", resp.StatusCode, string(body))
+// authorize returns a copy of req with the given body, bearer token and
+// correlation ID, leaving the original unmodified.
+func (t *authTransport) authorize(req *http.Request, body io.ReadCloser, token string) *http.Request {
+	reqClone := req.Clone(req.Context())
+	reqClone.Body = body
+	if token != "" {
+		reqClone.Header.Set("Authorization", "Bearer "+token)
 	}
-
-	var tokenResp tokenResponse
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
-		return "", fmt.Errorf("decoding token response: %w", err)
+	if id := logger.CorrelationID(req.Context()); id != "" {
+		reqClone.Header.Set(logger.CorrelationIDHeader, id)
 	}
+	return reqClone
+}
 
-	if tokenResp.AccessToken == "" {
-		return "", fmt.Errorf("empty access token in response")
-	}
+// CloseIdleConnections closes idle connections of the base transport.
+func (t *authTransport) CloseIdleConnections() {
+	closeIdleConnections(t.base)
+}
 
-	// Store the token with a buffer (subtract 60 seconds for safety)
-	expiresIn := time.Duration(tokenResp.ExpiresIn) * time.Second
-	if expiresIn > 60*time.Second {
-		expiresIn -= 60 * time.Second
+// closeIdleConnections closes the idle connections of rt if it supports it.
+func closeIdleConnections(rt http.RoundTripper) {
+	if c, ok := rt.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
 	}
-
-	tm.accessToken = tokenResp.AccessToken
-	tm.expiresAt = time.Now().Add(expiresIn)
-
-	return tm.accessToken, nil
 }
 
 // NewClient creates a new Flightctl API client.
@@ -146,65 +192,158 @@ func NewClient(cfg Config) (*Client, error) {
 	if cfg.APIURL == "" {
 		return nil, fmt.Errorf("Flightctl API URL is required")
 	}
-	if cfg.ClientID == "" {
-		return nil, fmt.Errorf("Flightctl client ID is required")
-	}
-	if cfg.ClientSecret == "" {
-		return nil, fmt.Errorf("Flightctl client secret is required")
-	}
-	if cfg.TokenURL == "" {
-		return nil, fmt.Errorf("Flightctl token URL is required")
-	}
-
 	if cfg.Timeout == 0 {
 		cfg.Timeout = 30 * time.Second
 	}
+	if cfg.RecordFile != "" && cfg.ReplayFile != "" {
+		return nil, fmt.Errorf("Flightctl traffic cannot be recorded and replayed at once")
+	}
 
-	// Create base transport
-	baseTransport := &http.Transport{}
+	// Create base transport. Idle connections are closed after a while so
+	// reloaded TLS certificates are picked up by new connections.
+	baseTransport := &http.Transport{IdleConnTimeout: 90 * time.Second}
 	if cfg.InsecureTLS {
+		logger.Warn("!!! TLS certificate verification of the FlightCtl API at %s is DISABLED (insecure TLS). "+
+			"Connections can be intercepted and credentials stolen. Configure a CA bundle (FLIGHTCTL_CA_FILE or FLIGHTCTL_CA_DATA) instead. !!!",
+			cfg.APIURL)
+	}
+	certs, err := newTLSFiles(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("configuring Flightctl TLS: %w", err)
+	}
+	if certs != nil {
+		baseTransport.DialTLSContext = certs.dialTLSContext
+	} else if cfg.InsecureTLS {
 		baseTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
 
-	// Create HTTP client for token requests (without OAuth transport)
+	// Create HTTP client for token requests (without the auth transport)
 	tokenHTTPClient := &http.Client{
 		Transport: baseTransport,
 		Timeout:   cfg.Timeout,
 	}
 
-	// Create token manager
-	tm := &tokenManager{
-		clientID:     cfg.ClientID,
-		clientSecret: cfg.ClientSecret,
-		tokenURL:     cfg.TokenURL,
-		httpClient:   tokenHTTPClient,
+	// API requests go to the API, through the recorder if recording, or are
+	// answered from a recording
+	var (
+		apiTransport http.RoundTripper = baseTransport
+		recorder     *recordingTransport
+		ts           tokenSource
+	)
+	switch {
+	case cfg.ReplayFile != "":
+		if apiTransport, err = newReplayTransport(cfg.ReplayFile); err != nil {
+			return nil, err
+		}
+		logger.Warn("Replaying FlightCtl API responses from %s instead of calling %s", cfg.ReplayFile, cfg.APIURL)
+	case cfg.RecordFile != "":
+		if recorder, err = newRecordingTransport(cfg.RecordFile, baseTransport); err != nil {
+			return nil, err
+		}
+		apiTransport = recorder
+		logger.Info("Recording FlightCtl API traffic to %s", cfg.RecordFile)
+	}
+	if cfg.ReplayFile == "" {
+		if ts, err = newTokenSource(cfg, tokenHTTPClient); err != nil {
+			return nil, err
+		}
+	}
+
+	// Wrap transport with the bearer token transport
+	authTrans := &authTransport{
+		base:        apiTransport,
+		tokenSource: ts,
+	}
+
+	// Each attempt is recorded on its own, so slow or failing attempts are
+	// not hidden by a successful retry
+	var attemptTrans http.RoundTripper = authTrans
+	if cfg.Metrics != nil {
+		attemptTrans = &metricsTransport{base: authTrans, metrics: cfg.Metrics}
+	}
+
+	// Retry transient failures outside the auth transport so each attempt
+	// picks up a valid token
+	retryTrans := &retryTransport{
+		base:    attemptTrans,
+		policy:  cfg.Retry.withDefaults(),
+		metrics: cfg.Metrics,
 	}
 
-	// Wrap transport with OAuth2 transport
-	oauth2Trans := &oauth2Transport{
-		base:         baseTransport,
-		tokenManager: tm,
+	// The breaker sees the outcome of a call once its retries are done
+	breakerTrans := &breakerTransport{
+		base:   retryTrans,
+		policy: cfg.Breaker.withDefaults(),
 	}
 
-	return &Client{
+	client := &Client{
 		httpClient: &http.Client{
-			Transport: oauth2Trans,
+			Transport: &tracing.Transport{Base: breakerTrans},
 			Timeout:   cfg.Timeout,
 		},
-		baseURL:      cfg.APIURL,
-		tokenManager: tm,
-	}, nil
+		baseURL:     cfg.APIURL,
+		tokenSource: ts,
+		tls:         certs,
+		insecureTLS: cfg.InsecureTLS,
+		breaker:     breakerTrans,
+		timeouts:    cfg.OperationTimeouts.withDefaults(),
+		recorder:    recorder,
+	}
+	if cfg.DeviceCacheTTL > 0 {
+		client.cache = newDeviceCache(cfg.DeviceCacheTTL)
+	}
+	if cfg.DryRun {
+		client.dryRun = newDryRunDevices()
+		logger.Warn("Dry run: device updates are logged and not written to FlightCtl")
+	}
+	return client, nil
+}
+
+// Close stops background token renewal. The client must not be used
+// afterwards.
+func (c *Client) Close() {
+	if tm, ok := c.tokenSource.(*tokenManager); ok {
+		tm.close()
+	}
+	if c.recorder != nil {
+		if err := c.recorder.close(); err != nil {
+			logger.Warn("Closing FlightCtl recording: %v", err)
+		}
+	}
+}
+
+// UnavailableSince returns since when the API has been failing if calls to
+// it are paused by the circuit breaker, or zero otherwise.
+func (c *Client) UnavailableSince() time.Time {
+	if c.breaker == nil {
+		return time.Time{}
+	}
+	return c.breaker.unavailableSince()
+}
+
+// CheckToken verifies that a valid access token is held or can be obtained.
+func (c *Client) CheckToken(ctx context.Context) error {
+	if c.tokenSource == nil {
+		return nil
+	}
+	if _, err := c.tokenSource.getToken(ctx); err != nil {
+		return fmt.Errorf("obtaining access token: %w", err)
+	}
+	return nil
 }
 
 // Ping checks if the Flightctl API is reachable.
-func (c *Client) Ping(ctx context.Context) error {
+func (c *Client) Ping(ctx context.Context) (err error) {
+	ctx, end := startOperation(ctx, c.timeouts.Read)
+	defer end(&err)
 	logger.Debug("Ping %s/api/v1/fleets", c.baseURL)
-	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/api/v1/fleets", nil)
+	// Health checks report the current state rather than waiting out retries
+	req, err := http.NewRequestWithContext(WithoutRetry(ctx), "GET", c.baseURL+"/api/v1/fleets", nil)
 	if err != nil {
 		return fmt.Errorf("creating ping request: %w", err)
 	}
 
-	// Authorization header is automatically added by oauth2Transport
+	// Authorization header is automatically added by authTransport
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("ping request failed: %w", err)
@@ -212,7 +351,8 @@ func (c *Client) Ping(ctx context.Context) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("ping returned status %d", resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("ping: %w", newHTTPError("GET", "/api/v1/fleets", resp.StatusCode, body))
 	}
 
 	return nil
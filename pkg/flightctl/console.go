@@ -0,0 +1,354 @@
+package flightctl
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// Console sessions run a command on a device through the FlightCtl device
+// console API. The command's standard streams are carried over a websocket
+// with the Kubernetes channel protocol: every message starts with the byte
+// of the stream it belongs to.
+const (
+	ConsoleProtocol = "v5.channel.k8s.io"
+
+	ConsoleStdin  byte = 0
+	ConsoleStdout byte = 1
+	ConsoleStderr byte = 2
+	ConsoleError  byte = 3 // Exit status of the command, as a Status object
+	ConsoleResize byte = 4 // Terminal size changes, as a TerminalSize object
+	ConsoleClose  byte = 255
+)
+
+// ConsolePath returns the API path of a device's console.
+func ConsolePath(deviceID string) string {
+	return "/ws/v1/devices/" + url.PathEscape(deviceID) + "/console"
+}
+
+// TerminalSize is the size of a console session's terminal.
+type TerminalSize struct {
+	Width  uint16 `json:"width"`
+	Height uint16 `json:"height"`
+}
+
+// ConsoleMetadata describes the session requested from the console API.
+type ConsoleMetadata struct {
+	Term              string          `json:"term,omitempty"`
+	InitialDimensions *TerminalSize   `json:"initialDimensions,omitempty"`
+	Command           *ConsoleCommand `json:"command,omitempty"`
+	TTY               bool            `json:"tty"`
+	Protocols         []string        `json:"protocols,omitempty"`
+}
+
+// ConsoleCommand is the command a console session runs instead of a shell.
+type ConsoleCommand struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// ConsoleStreams connects a console session to the caller. Stdin is closed
+// on the device when it reaches EOF; nil streams are not attached.
+type ConsoleStreams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	TTY    bool
+	// Resize delivers terminal size changes while TTY is set
+	Resize <-chan TerminalSize
+}
+
+// consoleStatus is the exit status sent on the error stream.
+type consoleStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// StreamConsole runs command on a device and streams its standard streams
+// until it exits or ctx is done. It returns an error if the command fails.
+func (c *Client) StreamConsole(ctx context.Context, deviceID string, command []string, streams ConsoleStreams) error {
+	log := logger.FromContext(ctx).With("device", deviceID)
+	if len(command) == 0 {
+		return fmt.Errorf("console session on device %s: no command", deviceID)
+	}
+
+	conn, err := c.dialConsole(ctx, deviceID, ConsoleMetadata{
+		Command:   &ConsoleCommand{Command: command[0], Args: command[1:]},
+		TTY:       streams.TTY,
+		Protocols: []string{ConsoleProtocol},
+	})
+	if err != nil {
+		return err
+	}
+
+	session := &consoleSession{conn: conn}
+	defer session.close()
+
+	// Closing the connection ends the read loop below
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.close()
+		case <-stop:
+		}
+	}()
+
+	if streams.Stdin != nil {
+		go func() {
+			if err := session.copyStdin(streams.Stdin); err != nil && !session.closed() {
+				log.Debug("Console stdin of device %s: %v", deviceID, err)
+			}
+		}()
+	}
+	if streams.TTY && streams.Resize != nil {
+		go func() {
+			for {
+				select {
+				case size, ok := <-streams.Resize:
+					if !ok {
+						return
+					}
+					data, _ := json.Marshal(size)
+					if err := session.send(ConsoleResize, data); err != nil {
+						return
+					}
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+
+	var exitErr error
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) || errors.Is(err, io.EOF) {
+				return exitErr
+			}
+			if exitErr != nil {
+				return exitErr
+			}
+			return fmt.Errorf("console session on device %s: %w", deviceID, err)
+		}
+		if len(message) == 0 {
+			continue
+		}
+		data := message[1:]
+		switch message[0] {
+		case ConsoleStdout:
+			if streams.Stdout != nil {
+				if _, err := streams.Stdout.Write(data); err != nil {
+					return fmt.Errorf("writing console output: %w", err)
+				}
+			}
+		case ConsoleStderr:
+			if streams.Stderr != nil {
+				if _, err := streams.Stderr.Write(data); err != nil {
+					return fmt.Errorf("writing console output: %w", err)
+				}
+			}
+		case ConsoleError:
+			exitErr = parseConsoleStatus(data)
+		}
+	}
+}
+
+// dialConsole opens a device console websocket with the client's TLS
+// settings and credentials.
+func (c *Client) dialConsole(ctx context.Context, deviceID string, metadata ConsoleMetadata) (*websocket.Conn, error) {
+	meta, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("encoding console metadata: %w", err)
+	}
+	consoleURL := strings.TrimSuffix(c.baseURL, "/") + ConsolePath(deviceID) + "?metadata=" + url.QueryEscape(string(meta))
+	if strings.HasPrefix(consoleURL, "http") {
+		consoleURL = "ws" + strings.TrimPrefix(consoleURL, "http")
+	}
+
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: c.httpClient.Timeout,
+		Subprotocols:     []string{ConsoleProtocol},
+	}
+	if c.tls != nil {
+		config, err := c.tls.current()
+		if err != nil {
+			return nil, fmt.Errorf("configuring console TLS: %w", err)
+		}
+		dialer.TLSClientConfig = config.Clone()
+	} else if c.insecureTLS {
+		dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	header := http.Header{}
+	if c.tokenSource != nil {
+		token, err := c.tokenSource.getToken(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("getting access token: %w", err)
+		}
+		header.Set("Authorization", "Bearer "+token)
+	}
+	if id := logger.CorrelationID(ctx); id != "" {
+		header.Set(logger.CorrelationIDHeader, id)
+	}
+
+	conn, resp, err := dialer.DialContext(ctx, consoleURL, header)
+	if err != nil {
+		if resp != nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("opening console of device %s: %w", deviceID,
+				newHTTPError("GET", ConsolePath(deviceID), resp.StatusCode, body))
+		}
+		return nil, fmt.Errorf("opening console of device %s: %w", deviceID, err)
+	}
+	return conn, nil
+}
+
+// ContainerCommand returns a console command running podman with subcommand
+// against the device container of a container of application app, followed
+// by args, e.g. podman exec -i <container> ls. Compose containers are found
+// by their compose labels, quadlet containers by their name.
+func ContainerCommand(app, containerName string, subcommand, args []string) []string {
+	service := sanitizeServiceName(containerName)
+	script := fmt.Sprintf(`id=$(podman ps -q --filter label=com.docker.compose.project=%[1]s --filter label=com.docker.compose.service=%[2]s | head -n 1); `+
+		`[ -n "$id" ] || id=%[3]s; exec podman %[4]s "$id"`,
+		shellQuote(app), shellQuote(service), shellQuote(app+"-"+service), shellJoin(subcommand))
+	if len(args) > 0 {
+		script += " " + shellJoin(args)
+	}
+	return []string{"sh", "-c", script}
+}
+
+// LogOptions selects the logs ContainerLogsCommand prints.
+type LogOptions struct {
+	Tail       int       // Last lines only, if positive
+	Since      time.Time // Entries from this time only, if set
+	Timestamps bool      // Prefix entries with their time
+	Follow     bool      // Keep streaming new entries
+	LastBytes  int       // Last bytes only, if positive; not with Follow
+}
+
+// ContainerLogsCommand returns a console command printing the logs of a
+// container of application app with podman logs, stdout and stderr
+// interleaved.
+func ContainerLogsCommand(app, containerName string, opts LogOptions) []string {
+	subcommand := []string{"logs"}
+	if opts.Follow {
+		subcommand = append(subcommand, "--follow")
+	}
+	if opts.Timestamps {
+		subcommand = append(subcommand, "--timestamps")
+	}
+	if opts.Tail > 0 {
+		subcommand = append(subcommand, "--tail", strconv.Itoa(opts.Tail))
+	}
+	if !opts.Since.IsZero() {
+		subcommand = append(subcommand, "--since", opts.Since.UTC().Format(time.RFC3339))
+	}
+	command := ContainerCommand(app, containerName, subcommand, nil)
+	if opts.LastBytes > 0 && !opts.Follow {
+		command[2] = fmt.Sprintf("(%s) 2>&1 | tail -c %d", command[2], opts.LastBytes)
+	}
+	return command
+}
+
+// shellJoin quotes words for a POSIX shell.
+func shellJoin(words []string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = shellQuote(word)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func shellQuote(word string) string {
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// parseConsoleStatus returns the error reported on the error stream, or nil
+// if the command succeeded.
+func parseConsoleStatus(data []byte) error {
+	var status consoleStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		if msg := strings.TrimSpace(string(data)); msg != "" {
+			return fmt.Errorf("console command failed: %s", msg)
+		}
+		return nil
+	}
+	if status.Status == "" || status.Status == "Success" {
+		return nil
+	}
+	return fmt.Errorf("console command failed: %s", status.Message)
+}
+
+// consoleSession serializes writes to a console websocket.
+type consoleSession struct {
+	conn *websocket.Conn
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+	isClosed  atomic.Bool
+}
+
+// send writes a message on a stream.
+func (s *consoleSession) send(stream byte, data []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.isClosed.Load() {
+		return net.ErrClosed
+	}
+	return s.conn.WriteMessage(websocket.BinaryMessage, append([]byte{stream}, data...))
+}
+
+// copyStdin sends r to the command's stdin and closes it at EOF.
+func (s *consoleSession) copyStdin(r io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if sendErr := s.send(ConsoleStdin, buf[:n]); sendErr != nil {
+				return sendErr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return s.send(ConsoleClose, []byte{ConsoleStdin})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *consoleSession) closed() bool {
+	return s.isClosed.Load()
+}
+
+// close closes the connection, which also unblocks pending reads and writes.
+func (s *consoleSession) close() {
+	s.closeOnce.Do(func() {
+		s.isClosed.Store(true)
+		s.conn.Close()
+	})
+}
@@ -0,0 +1,521 @@
+package flightctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/redact"
+)
+
+// GetDevice retrieves the current Device resource from FlightCtl API. With
+// a device cache configured, a read younger than its TTL is returned instead
+// unless ctx bypasses the cache. Concurrent reads of a device share one
+// request, except those bypassing the cache. In dry-run mode, the device is
+// returned as last written.
+func (c *Client) GetDevice(ctx context.Context, deviceID string) (_ *FlightctlDevice, err error) {
+	ctx, end := startOperation(ctx, c.timeouts.Read)
+	defer end(&err)
+
+	var generation uint64
+	bypass := cacheBypassed(ctx)
+	if c.cache != nil && !bypass {
+		if device, ok := c.cache.get(deviceID); ok {
+			if c.dryRun != nil {
+				c.dryRun.overlay(deviceID, device)
+			}
+			return device, nil
+		}
+	}
+	if c.cache != nil {
+		generation = c.cache.start(deviceID)
+	}
+
+	var body []byte
+	if bypass {
+		body, err = c.readDevice(ctx, deviceID)
+	} else {
+		body, err = c.readDeviceShared(ctx, deviceID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var device FlightctlDevice
+	if err := json.Unmarshal(body, &device); err != nil {
+		logger.FromContext(ctx).Error("decoding device: %s", err.Error())
+		return nil, fmt.Errorf("decoding device: %w", err)
+	}
+
+	if c.cache != nil {
+		c.cache.put(deviceID, generation, &device, body)
+	}
+	if c.dryRun != nil {
+		c.dryRun.overlay(deviceID, &device)
+	}
+	return &device, nil
+}
+
+// readDeviceShared reads a device, joining a read of it already in flight.
+// The request runs until done even if the caller that started it gives up,
+// so the others still get its result; each caller only waits as long as its
+// own context allows.
+func (c *Client) readDeviceShared(ctx context.Context, deviceID string) ([]byte, error) {
+	results := c.deviceReads.DoChan(deviceID, func() (interface{}, error) {
+		// Bounded on its own, as it outlives the caller that started it
+		readCtx, end := startOperation(context.WithoutCancel(ctx), c.timeouts.Read)
+		body, err := c.readDevice(readCtx, deviceID)
+		end(&err)
+		return body, err
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-results:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.([]byte), nil
+	}
+}
+
+// readDevice returns the Device resource as read from the API.
+func (c *Client) readDevice(ctx context.Context, deviceID string) ([]byte, error) {
+	url := fmt.Sprintf("%s/api/v1/devices/%s", c.baseURL, deviceID)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating GET request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		logger.FromContext(ctx).Error("GET request failed: %v", err)
+		return nil, fmt.Errorf("GET request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		logger.FromContext(ctx).Error("GET device failed with status %d: %s", resp.StatusCode, redact.Text(string(bodyBytes)))
+		return nil, newHTTPError("GET", "/api/v1/devices/"+deviceID, resp.StatusCode, bodyBytes)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading device: %w", err)
+	}
+	return body, nil
+}
+
+// UpdateDevice updates a Device resource via FlightCtl API (PUT). In dry-run
+// mode the change is logged instead.
+func (c *Client) UpdateDevice(ctx context.Context, deviceID string, device *FlightctlDevice) (err error) {
+	ctx, end := startOperation(ctx, c.timeouts.Write)
+	defer end(&err)
+	if c.dryRun != nil {
+		return c.dryRunUpdate(ctx, deviceID, device)
+	}
+	url := fmt.Sprintf("%s/api/v1/devices/%s", c.baseURL, deviceID)
+
+	body, err := json.Marshal(device)
+	if err != nil {
+		return fmt.Errorf("marshaling device: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating PUT request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	// Whether or not the write succeeds, the cached copy and reads in
+	// flight may be outdated
+	if c.cache != nil {
+		defer c.cache.invalidate(deviceID)
+	}
+	defer c.deviceReads.Forget(deviceID)
+
+	if logger.Enabled(logger.DebugLevel) {
+		logger.FromContext(ctx).Debug("Updating device %s with payload:\n%s", deviceID, redactedDevicePayload(device))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("PUT request failed: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		logger.FromContext(ctx).Error("update device failed with status %d: %s", resp.StatusCode, redact.Text(string(bodyBytes)))
+		return newHTTPError("PUT", "/api/v1/devices/"+deviceID, resp.StatusCode, bodyBytes)
+	}
+
+	logger.FromContext(ctx).Info("Successfully updated device %s", deviceID)
+	return nil
+}
+
+// listPageSize is the number of items requested per page from list APIs.
+const listPageSize = 100
+
+const (
+	// CapacityCPULabel declares a device's total CPU (e.g. "4" or "3500m").
+	CapacityCPULabel = "capacity.flightctl.io/cpu"
+	// CapacityMemoryLabel declares a device's total memory (e.g. "8Gi").
+	CapacityMemoryLabel = "capacity.flightctl.io/memory"
+	// CapacityEphemeralStorageLabel declares the disk space a device has
+	// for containers (e.g. "64Gi"). It overrides the size the agent reports.
+	CapacityEphemeralStorageLabel = "capacity.flightctl.io/ephemeral-storage"
+	// CapacityLabelPrefix prefixes the labels declaring a device's extended
+	// resources, named with their "/" replaced by "_" as label names allow
+	// a single "/": capacity.flightctl.io/nvidia.com_gpu: "2".
+	CapacityLabelPrefix = "capacity.flightctl.io/"
+)
+
+// CordonKey is the device label or annotation that puts a device into
+// maintenance: "true" keeps new pods off it, "drain" also moves its pods to
+// other devices of its fleet. The annotation wins over the label.
+const CordonKey = "vk.flightctl.io/cordoned"
+
+// Values of CordonKey.
+const (
+	CordonValueTrue  = "true"
+	CordonValueDrain = "drain"
+)
+
+// fleetOwnerPrefix is the owner reference prefix FlightCtl uses for fleet membership.
+const fleetOwnerPrefix = "Fleet/"
+
+// ListDevices retrieves devices, optionally filtered by fleet and labels
+// (AND logic). Results are paged with continue tokens until exhausted.
+func (c *Client) ListDevices(ctx context.Context, fleetID string, labels map[string]string) (_ []*models.Device, err error) {
+	ctx, end := startOperation(ctx, c.timeouts.List)
+	defer end(&err)
+
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(listPageSize))
+	if selector := labelSelector(labels); selector != "" {
+		query.Set("labelSelector", selector)
+	}
+	if fleetID != "" {
+		query.Set("fieldSelector", "metadata.owner="+fleetOwnerPrefix+fleetID)
+	}
+
+	var devices []*models.Device
+	for {
+		var page FlightctlDeviceList
+		if err := c.getJSON(ctx, "/api/v1/devices", query, &page); err != nil {
+			return nil, fmt.Errorf("listing devices: %w", err)
+		}
+
+		for i := range page.Items {
+			devices = append(devices, page.Items[i].ToModel())
+		}
+
+		if page.Metadata.Continue == "" {
+			break
+		}
+		query.Set("continue", page.Metadata.Continue)
+	}
+
+	logger.Debug("Listed %d devices (fleet=%q, labels=%v)", len(devices), fleetID, labels)
+	return devices, nil
+}
+
+// getJSON performs a GET request against the API and decodes the JSON response.
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, out interface{}) error {
+	reqURL := c.baseURL + path
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("creating GET request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return newHTTPError("GET", path, resp.StatusCode, bodyBytes)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// labelSelector renders a label map as a FlightCtl label selector (k1=v1,k2=v2).
+func labelSelector(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, key+"="+labels[key])
+	}
+	return strings.Join(parts, ",")
+}
+
+// ToModel converts a FlightCtl Device resource to the provider's device model.
+func (d *FlightctlDevice) ToModel() *models.Device {
+	device := &models.Device{
+		ID:              d.Metadata.Name,
+		Name:            d.Metadata.Name,
+		Labels:          d.Metadata.Labels,
+		Status:          models.DeviceStatus{Phase: models.DeviceUnknown},
+		ConnectionState: models.Unknown,
+	}
+	if strings.HasPrefix(d.Metadata.Owner, fleetOwnerPrefix) {
+		device.FleetID = strings.TrimPrefix(d.Metadata.Owner, fleetOwnerPrefix)
+	}
+	if alias := d.Metadata.Labels["alias"]; alias != "" {
+		device.Name = alias
+	}
+	if d.Metadata.CreationTimestamp != nil {
+		device.CreatedAt = *d.Metadata.CreationTimestamp
+	}
+
+	device.Capacity = capacityFromLabels(d.Metadata.Name, d.Metadata.Labels)
+	device.Allocatable = device.Capacity
+
+	cordon, ok := d.Metadata.Annotations[CordonKey]
+	if !ok {
+		cordon = d.Metadata.Labels[CordonKey]
+	}
+	switch strings.ToLower(cordon) {
+	case CordonValueTrue:
+		device.Cordoned = true
+	case CordonValueDrain:
+		device.Cordoned = true
+		device.Draining = true
+	}
+
+	device.Health = d.health()
+	if d.Status == nil {
+		return device
+	}
+
+	device.SystemInfo = d.systemInfo()
+	if device.Capacity.EphemeralStorage.IsZero() {
+		device.Capacity.EphemeralStorage = d.reportedStorage()
+		device.Allocatable = device.Capacity
+	}
+	if d.Status.LastSeen != nil {
+		device.LastHeartbeat = *d.Status.LastSeen
+		device.UpdatedAt = *d.Status.LastSeen
+	}
+
+	if d.Status.Summary != nil {
+		device.Status.Message = d.Status.Summary.Info
+		device.Status.Reason = d.Status.Summary.Status
+		switch strings.ToLower(d.Status.Summary.Status) {
+		case "online", "degraded":
+			device.Status.Phase = models.DeviceReady
+			device.ConnectionState = models.Connected
+		case "unknown", "":
+			device.Status.Phase = models.DeviceUnknown
+			device.ConnectionState = models.Unknown
+		case "error", "rebooting":
+			device.Status.Phase = models.DeviceNotReady
+			device.ConnectionState = models.Connected
+		default: // poweredoff, offline
+			device.Status.Phase = models.DeviceNotReady
+			device.ConnectionState = models.Disconnected
+		}
+	}
+
+	return device
+}
+
+// capacityFromLabels reads the device capacity declared in its labels.
+// Unparseable values are logged and treated as unknown.
+func capacityFromLabels(deviceID string, labels map[string]string) models.ResourceList {
+	var capacity models.ResourceList
+	if value, ok := labels[CapacityCPULabel]; ok {
+		if q, err := resource.ParseQuantity(value); err == nil {
+			capacity.CPU = q
+		} else {
+			logger.Warn("Device %s has invalid %s label %q: %v", deviceID, CapacityCPULabel, value, err)
+		}
+	}
+	if value, ok := labels[CapacityMemoryLabel]; ok {
+		if q, err := resource.ParseQuantity(value); err == nil {
+			capacity.Memory = q
+		} else {
+			logger.Warn("Device %s has invalid %s label %q: %v", deviceID, CapacityMemoryLabel, value, err)
+		}
+	}
+	if value, ok := labels[CapacityEphemeralStorageLabel]; ok {
+		if q, err := resource.ParseQuantity(value); err == nil {
+			capacity.EphemeralStorage = q
+		} else {
+			logger.Warn("Device %s has invalid %s label %q: %v", deviceID, CapacityEphemeralStorageLabel, value, err)
+		}
+	}
+	for key, value := range labels {
+		name, ok := ExtendedResourceFromLabel(key)
+		if !ok {
+			continue
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil || q.Sign() < 0 {
+			logger.Warn("Device %s has invalid %s label %q: %v", deviceID, key, value, err)
+			continue
+		}
+		if capacity.Extended == nil {
+			capacity.Extended = make(map[corev1.ResourceName]resource.Quantity)
+		}
+		capacity.Extended[name] = q
+	}
+	return capacity
+}
+
+// ExtendedResourceFromLabel returns the extended resource a device capacity
+// label declares, or false for other labels.
+func ExtendedResourceFromLabel(key string) (corev1.ResourceName, bool) {
+	encoded, ok := strings.CutPrefix(key, CapacityLabelPrefix)
+	if !ok {
+		return "", false
+	}
+	domain, name, ok := strings.Cut(encoded, "_")
+	if !ok || domain == "" || name == "" {
+		return "", false
+	}
+	resourceName := corev1.ResourceName(domain + "/" + name)
+	return resourceName, models.IsExtendedResourceName(resourceName)
+}
+
+// FlightctlDevice represents a complete Device resource in Flightctl API format.
+type FlightctlDevice struct {
+	APIVersion string                  `json:"apiVersion"`
+	Kind       string                  `json:"kind"`
+	Metadata   FlightctlDeviceMetadata `json:"metadata"`
+	Spec       FlightctlDeviceSpec     `json:"spec"`
+	Status     *FlightctlDeviceStatus  `json:"status,omitempty"`
+}
+
+// FlightctlDeviceMetadata represents the metadata section of a Device.
+type FlightctlDeviceMetadata struct {
+	Name              string            `json:"name"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	Owner             string            `json:"owner,omitempty"` // e.g. "Fleet/my-fleet"
+	CreationTimestamp *time.Time        `json:"creationTimestamp,omitempty"`
+	// ResourceVersion makes an update fail with a conflict if the device
+	// changed since it was read.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+
+	unknown unknownFields
+}
+
+// FlightctlDeviceSpec represents the spec section of a Device.
+type FlightctlDeviceSpec struct {
+	Systemd      *FlightctlSystemdConfig `json:"systemd,omitempty"`
+	Applications []FlightctlApplication  `json:"applications,omitempty"`
+
+	unknown unknownFields
+}
+
+// FlightctlDeviceStatus represents the status section of a Device.
+type FlightctlDeviceStatus struct {
+	Applications []FlightctlApplicationStatus `json:"applications,omitempty"`
+	Conditions   []FlightctlCondition         `json:"conditions,omitempty"`
+	Summary      *FlightctlDeviceSummary      `json:"summary,omitempty"`
+	Resources    *FlightctlDeviceResources    `json:"resources,omitempty"`
+	SystemInfo   map[string]interface{}       `json:"systemInfo,omitempty"`
+	LastSeen     *time.Time                   `json:"lastSeen,omitempty"`
+	Config       *FlightctlDeviceConfigStatus `json:"config,omitempty"`
+	Updated      *FlightctlDeviceUpdated      `json:"updated,omitempty"`
+}
+
+// FlightctlDeviceConfigStatus reports the rendered spec version the device
+// agent has applied.
+type FlightctlDeviceConfigStatus struct {
+	RenderedVersion string `json:"renderedVersion"`
+}
+
+// FlightctlDeviceUpdated reports whether the device runs its latest spec.
+type FlightctlDeviceUpdated struct {
+	Status string `json:"status"` // UpToDate, OutOfDate, Updating, Unknown
+	Info   string `json:"info,omitempty"`
+}
+
+// FlightctlDeviceResources is the health of the device resources as
+// reported by its agent: Healthy, Warning, Critical, Error or Unknown.
+type FlightctlDeviceResources struct {
+	CPU    string `json:"cpu,omitempty"`
+	Memory string `json:"memory,omitempty"`
+	Disk   string `json:"disk,omitempty"`
+}
+
+// Device condition reporting the progress of a spec update, and its reason
+// once the update failed.
+const (
+	DeviceUpdatingCondition = "Updating"
+	DeviceUpdateErrorReason = "Error"
+)
+
+// FlightctlDeviceSummary is the overall device health reported by FlightCtl.
+type FlightctlDeviceSummary struct {
+	Status string `json:"status"` // Online, Degraded, Error, Rebooting, PoweredOff, Unknown
+	Info   string `json:"info,omitempty"`
+}
+
+// FlightctlDeviceList represents a page of devices returned by the list API.
+type FlightctlDeviceList struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   FlightctlListMeta `json:"metadata"`
+	Items      []FlightctlDevice `json:"items"`
+}
+
+// FlightctlListMeta holds pagination metadata for list responses.
+type FlightctlListMeta struct {
+	Continue           string `json:"continue,omitempty"`
+	RemainingItemCount *int64 `json:"remainingItemCount,omitempty"`
+}
+
+// FlightctlCondition represents a condition in the Device status.
+type FlightctlCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// FlightctlSystemdConfig represents systemd configuration in Device spec.
+type FlightctlSystemdConfig struct {
+	MatchPatterns []string `json:"matchPatterns,omitempty"`
+}
@@ -0,0 +1,105 @@
+package flightctl
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+func TestListDevices_FiltersAndPagination(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RawQuery)
+
+		if got := r.URL.Query().Get("labelSelector"); got != "region=galway,tier=edge" {
+			t.Errorf("Expected labelSelector region=galway,tier=edge, got %q", got)
+		}
+		if got := r.URL.Query().Get("fieldSelector"); got != "metadata.owner=Fleet/factory" {
+			t.Errorf("Expected fleet fieldSelector, got %q", got)
+		}
+
+		list := FlightctlDeviceList{Kind: "DeviceList"}
+		if r.URL.Query().Get("continue") == "" {
+			list.Metadata.Continue = "page2"
+			list.Items = []FlightctlDevice{{
+				Metadata: FlightctlDeviceMetadata{Name: "dev-1", Owner: "Fleet/factory"},
+				Status:   &FlightctlDeviceStatus{Summary: &FlightctlDeviceSummary{Status: "Online"}},
+			}}
+		} else {
+			list.Items = []FlightctlDevice{{
+				Metadata: FlightctlDeviceMetadata{Name: "dev-2", Owner: "Fleet/factory"},
+				Status:   &FlightctlDeviceStatus{Summary: &FlightctlDeviceSummary{Status: "PoweredOff"}},
+			}}
+		}
+		_ = json.NewEncoder(w).Encode(list)
+	}))
+	defer srv.Close()
+
+	c := &Client{httpClient: srv.Client(), baseURL: srv.URL}
+	devices, err := c.ListDevices(context.Background(), "factory", map[string]string{"tier": "edge", "region": "galway"})
+	if err != nil {
+		t.Fatalf("ListDevices returned error: %v", err)
+	}
+
+	if len(requests) != 2 {
+		t.Errorf("Expected 2 paged requests, got %d", len(requests))
+	}
+	if len(devices) != 2 {
+		t.Fatalf("Expected 2 devices, got %d", len(devices))
+	}
+	if devices[0].FleetID != "factory" || !devices[0].IsReady() {
+		t.Errorf("Expected dev-1 in fleet factory and ready, got %+v", devices[0])
+	}
+	if devices[1].ConnectionState != models.Disconnected {
+		t.Errorf("Expected dev-2 to be disconnected, got %s", devices[1].ConnectionState)
+	}
+}
+
+func TestGetDevice_CoalescesConcurrentReads(t *testing.T) {
+	var hits atomic.Int32
+	arrived := make(chan struct{}, 10)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		arrived <- struct{}{}
+		<-release
+		_ = json.NewEncoder(w).Encode(FlightctlDevice{Metadata: FlightctlDeviceMetadata{Name: "dev-1"}})
+	}))
+	defer srv.Close()
+	c := &Client{httpClient: srv.Client(), baseURL: srv.URL}
+
+	read := func(ctx context.Context, wg *sync.WaitGroup) {
+		defer wg.Done()
+		device, err := c.GetDevice(ctx, "dev-1")
+		if err != nil || device.Metadata.Name != "dev-1" {
+			t.Errorf("GetDevice = %+v, %v; want dev-1", device, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go read(context.Background(), &wg)
+	<-arrived
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go read(context.Background(), &wg)
+	}
+	// Cache-bypassing reads get a request of their own
+	wg.Add(1)
+	go read(BypassCache(context.Background()), &wg)
+	<-arrived
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := hits.Load(); got != 2 {
+		t.Errorf("Expected 2 requests for 6 concurrent reads, got %d", got)
+	}
+}
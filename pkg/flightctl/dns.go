@@ -0,0 +1,41 @@
+package flightctl
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// podDNS holds the resolver settings and static host entries of a pod's
+// containers. Cluster DNS is not reachable from devices, so whatever the
+// pod's dnsPolicy the containers use the device's resolvers, overridden by
+// the pod's dnsConfig where it sets any.
+type podDNS struct {
+	nameservers []string
+	searches    []string
+	options     []string // name or name:value
+	hosts       []string // hostname:ip
+}
+
+// dnsOf returns the DNS settings of a pod. Resolver settings cannot be
+// changed on the host network and are left out for such pods.
+func dnsOf(pod *corev1.Pod) podDNS {
+	var dns podDNS
+	if config := pod.Spec.DNSConfig; config != nil && !pod.Spec.HostNetwork {
+		dns.nameservers = config.Nameservers
+		dns.searches = config.Searches
+		for _, option := range config.Options {
+			if option.Value != nil {
+				dns.options = append(dns.options, fmt.Sprintf("%s:%s", option.Name, *option.Value))
+			} else {
+				dns.options = append(dns.options, option.Name)
+			}
+		}
+	}
+	for _, alias := range pod.Spec.HostAliases {
+		for _, hostname := range alias.Hostnames {
+			dns.hosts = append(dns.hosts, fmt.Sprintf("%s:%s", hostname, alias.IP))
+		}
+	}
+	return dns
+}
@@ -0,0 +1,55 @@
+package flightctl
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func dnsTestPod() *corev1.Pod {
+	ndots := "2"
+	pod := statusTestPod()
+	pod.Spec.DNSPolicy = corev1.DNSNone
+	pod.Spec.DNSConfig = &corev1.PodDNSConfig{
+		Nameservers: []string{"10.1.0.53"},
+		Searches:    []string{"plant.example.com"},
+		Options:     []corev1.PodDNSConfigOption{{Name: "ndots", Value: &ndots}, {Name: "edns0"}},
+	}
+	pod.Spec.HostAliases = []corev1.HostAlias{{IP: "192.168.1.10", Hostnames: []string{"plc", "plc.local"}}}
+	return pod
+}
+
+func TestComposeDNS(t *testing.T) {
+	content := convertPodToDockerCompose(dnsTestPod())
+	for _, want := range []string{
+		"    dns:\n      - \"10.1.0.53\"\n",
+		"    dns_search:\n      - \"plant.example.com\"\n",
+		"    dns_opt:\n      - \"ndots:2\"\n      - \"edns0\"\n",
+		"    extra_hosts:\n      - \"plc:192.168.1.10\"\n      - \"plc.local:192.168.1.10\"\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("compose lacks %q:\n%s", want, content)
+		}
+	}
+
+	// Resolver settings do not apply on the host network; host entries do
+	pod := dnsTestPod()
+	pod.Spec.HostNetwork = true
+	content = convertPodToDockerCompose(pod)
+	if strings.Contains(content, "dns") || !strings.Contains(content, "extra_hosts:") {
+		t.Errorf("host network compose = \n%s\nwant extra_hosts only", content)
+	}
+	if features := UnsupportedFeatures(pod); len(features) != 1 || features[0].Field != "spec.dnsConfig" {
+		t.Errorf("features = %v, want spec.dnsConfig", features)
+	}
+}
+
+func TestQuadletDNS(t *testing.T) {
+	unit := quadletPodUnit(dnsTestPod(), "default-web")
+	for _, want := range []string{"DNS=10.1.0.53", "DNSSearch=plant.example.com", "DNSOption=ndots:2", "DNSOption=edns0", "AddHost=plc:192.168.1.10"} {
+		if !strings.Contains(unit, want+"\n") {
+			t.Errorf("pod unit lacks %s:\n%s", want, unit)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package flightctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// In dry-run mode device updates are not written to FlightCtl. The would-be
+// change is logged as a diff of the device's labels, annotations and spec,
+// and the written device is kept in memory: reads of the device return it
+// with the live status, so the provider goes on as if the update had been
+// made while the devices never run anything.
+
+// dryRunDevices holds the devices written in dry-run mode.
+type dryRunDevices struct {
+	mu      sync.Mutex
+	devices map[string]*FlightctlDevice // deviceID -> last written device
+}
+
+func newDryRunDevices() *dryRunDevices {
+	return &dryRunDevices{devices: make(map[string]*FlightctlDevice)}
+}
+
+// overlay replaces the labels, annotations and spec of a device read from
+// the API with those last written to it, if any.
+func (d *dryRunDevices) overlay(deviceID string, device *FlightctlDevice) {
+	d.mu.Lock()
+	written, ok := d.devices[deviceID]
+	d.mu.Unlock()
+	if !ok {
+		return
+	}
+	// Decode a copy, so the caller may modify it
+	var copied FlightctlDevice
+	data, err := json.Marshal(written)
+	if err != nil || json.Unmarshal(data, &copied) != nil {
+		return
+	}
+	device.Metadata.Labels = copied.Metadata.Labels
+	device.Metadata.Annotations = copied.Metadata.Annotations
+	device.Spec = copied.Spec
+}
+
+// dryRunUpdate logs the diff of a device update and keeps the written device
+// instead of writing it to the API.
+func (c *Client) dryRunUpdate(ctx context.Context, deviceID string, device *FlightctlDevice) error {
+	current, err := c.GetDevice(ctx, deviceID)
+	if err != nil {
+		return fmt.Errorf("reading device for the dry run: %w", err)
+	}
+	before, err := dryRunView(current)
+	if err != nil {
+		return err
+	}
+	after, err := dryRunView(device)
+	if err != nil {
+		return err
+	}
+
+	c.dryRun.mu.Lock()
+	c.dryRun.devices[deviceID] = device
+	c.dryRun.mu.Unlock()
+	if c.cache != nil {
+		c.cache.invalidate(deviceID)
+	}
+	c.deviceReads.Forget(deviceID)
+
+	if diff := cmp.Diff(before, after); diff != "" {
+		logger.FromContext(ctx).With("device", deviceID).Info("Dry run: not updating device %s (-current +would-be):\n%s", deviceID, diff)
+	} else {
+		logger.FromContext(ctx).With("device", deviceID).Info("Dry run: not updating device %s, which is unchanged", deviceID)
+	}
+	return nil
+}
+
+// dryRunView returns the parts of a device an update changes, with secrets
+// masked, as generic JSON values to diff.
+func dryRunView(device *FlightctlDevice) (interface{}, error) {
+	redacted := *device
+	redacted.Spec.Applications = make([]FlightctlApplication, len(device.Spec.Applications))
+	for i, app := range device.Spec.Applications {
+		redacted.Spec.Applications[i] = redactedApplication(app, nil)
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"labels":      redacted.Metadata.Labels,
+		"annotations": redacted.Metadata.Annotations,
+		"spec":        &redacted.Spec,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling device for the dry run: %w", err)
+	}
+	var view interface{}
+	if err := json.Unmarshal(data, &view); err != nil {
+		return nil, fmt.Errorf("decoding device for the dry run: %w", err)
+	}
+	return view, nil
+}
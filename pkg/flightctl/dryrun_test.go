@@ -0,0 +1,67 @@
+package flightctl
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+func TestDryRunUpdateDevice(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("dry run sent %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		_, _ = w.Write([]byte(`{"metadata":{"name":"dev-1","resourceVersion":"7"},"spec":{},"status":{"summary":{"status":"Online"}}}`))
+	}))
+	defer srv.Close()
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+
+	client, err := NewClient(Config{APIURL: srv.URL, AuthMode: AuthModeNone, DryRun: true})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	ctx := context.Background()
+	device, err := client.GetDevice(ctx, "dev-1")
+	if err != nil {
+		t.Fatalf("GetDevice: %v", err)
+	}
+	device.Spec.Applications = []FlightctlApplication{{
+		Name:    "web",
+		AppType: "compose",
+		EnvVars: map[string]string{"DB_PASSWORD": "hunter2", "MODE": "prod"},
+	}}
+	if err := client.UpdateDevice(ctx, "dev-1", device); err != nil {
+		t.Fatalf("UpdateDevice: %v", err)
+	}
+
+	logged := logs.String()
+	if !strings.Contains(logged, "Dry run: not updating device dev-1") || !strings.Contains(logged, `"prod"`) {
+		t.Errorf("the would-be change is not logged:\n%s", logged)
+	}
+	if strings.Contains(logged, "hunter2") {
+		t.Errorf("the logged change holds a secret:\n%s", logged)
+	}
+
+	// Reads return the device as written, with the live status
+	device, err = client.GetDevice(ctx, "dev-1")
+	if err != nil {
+		t.Fatalf("GetDevice: %v", err)
+	}
+	if len(device.Spec.Applications) != 1 || device.Spec.Applications[0].EnvVars["DB_PASSWORD"] != "hunter2" {
+		t.Errorf("applications = %+v, want the written application", device.Spec.Applications)
+	}
+	if device.Metadata.ResourceVersion != "7" || device.Status == nil {
+		t.Errorf("device = %+v, want the live metadata and status", device)
+	}
+}
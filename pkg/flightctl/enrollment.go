@@ -0,0 +1,112 @@
+package flightctl
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// ListEnrollmentRequests retrieves the enrollment requests of devices,
+// approved or not.
+func (c *Client) ListEnrollmentRequests(ctx context.Context) (_ []FlightctlEnrollmentRequest, err error) {
+	ctx, end := startOperation(ctx, c.timeouts.List)
+	defer end(&err)
+
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(listPageSize))
+
+	var requests []FlightctlEnrollmentRequest
+	for {
+		var page FlightctlEnrollmentRequestList
+		if err := c.getJSON(ctx, "/api/v1/enrollmentrequests", query, &page); err != nil {
+			return nil, fmt.Errorf("listing enrollment requests: %w", err)
+		}
+		requests = append(requests, page.Items...)
+
+		if page.Metadata.Continue == "" {
+			break
+		}
+		query.Set("continue", page.Metadata.Continue)
+	}
+
+	logger.Debug("Listed %d enrollment requests", len(requests))
+	return requests, nil
+}
+
+// Pending reports whether the request awaits approval: it has been neither
+// approved nor denied.
+func (r *FlightctlEnrollmentRequest) Pending() bool {
+	return r.Status == nil || r.Status.Approval == nil
+}
+
+// Matches reports whether the labels are selected. A selector with invalid
+// requirements selects nothing.
+func (s *FlightctlLabelSelector) Matches(set map[string]string) bool {
+	selector := &metav1.LabelSelector{MatchLabels: s.MatchLabels}
+	for _, req := range s.MatchExpressions {
+		selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      req.Key,
+			Operator: metav1.LabelSelectorOperator(req.Operator),
+			Values:   req.Values,
+		})
+	}
+	parsed, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false
+	}
+	return parsed.Matches(labels.Set(set))
+}
+
+// FlightctlEnrollmentRequest is the request of a device to join FlightCtl,
+// which an operator approves or denies.
+type FlightctlEnrollmentRequest struct {
+	APIVersion string                            `json:"apiVersion"`
+	Kind       string                            `json:"kind"`
+	Metadata   FlightctlEnrollmentRequestMeta    `json:"metadata"`
+	Spec       FlightctlEnrollmentRequestSpec    `json:"spec"`
+	Status     *FlightctlEnrollmentRequestStatus `json:"status,omitempty"`
+}
+
+// FlightctlEnrollmentRequestMeta is the metadata of an enrollment request,
+// named after the device.
+type FlightctlEnrollmentRequestMeta struct {
+	Name              string            `json:"name"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	CreationTimestamp *time.Time        `json:"creationTimestamp,omitempty"`
+}
+
+// FlightctlEnrollmentRequestSpec holds the labels the device asks for.
+type FlightctlEnrollmentRequestSpec struct {
+	CSR    string            `json:"csr,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// FlightctlEnrollmentRequestStatus holds the decision on a request.
+type FlightctlEnrollmentRequestStatus struct {
+	Approval   *FlightctlEnrollmentApproval `json:"approval,omitempty"`
+	Conditions []FlightctlCondition         `json:"conditions,omitempty"`
+}
+
+// FlightctlEnrollmentApproval records who approved or denied a request.
+type FlightctlEnrollmentApproval struct {
+	Approved   bool              `json:"approved"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	ApprovedBy string            `json:"approvedBy,omitempty"`
+	ApprovedAt *time.Time        `json:"approvedAt,omitempty"`
+}
+
+// FlightctlEnrollmentRequestList represents a page of enrollment requests
+// returned by the list API.
+type FlightctlEnrollmentRequestList struct {
+	APIVersion string                       `json:"apiVersion"`
+	Kind       string                       `json:"kind"`
+	Metadata   FlightctlListMeta            `json:"metadata"`
+	Items      []FlightctlEnrollmentRequest `json:"items"`
+}
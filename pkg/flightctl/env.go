@@ -0,0 +1,72 @@
+package flightctl
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// envValue returns the value a container environment variable is set to on
+// the device. Downward API field references are resolved from the pod at
+// translation time; ok is false for variables that cannot be set (other
+// value sources) and, as before, for literal empty values.
+func envValue(pod *corev1.Pod, env corev1.EnvVar) (value string, ok bool) {
+	if env.ValueFrom == nil {
+		return env.Value, env.Value != ""
+	}
+	if env.ValueFrom.FieldRef != nil {
+		return podFieldValue(pod, env.ValueFrom.FieldRef.FieldPath)
+	}
+	return "", false
+}
+
+// podFieldValue resolves a downward API field path of the pod. Status
+// fields (such as status.podIP) are only known on the device and are not
+// supported.
+func podFieldValue(pod *corev1.Pod, fieldPath string) (string, bool) {
+	switch fieldPath {
+	case "metadata.name":
+		return pod.Name, true
+	case "metadata.namespace":
+		return pod.Namespace, true
+	case "metadata.uid":
+		return string(pod.UID), true
+	case "spec.nodeName":
+		return pod.Spec.NodeName, true
+	case "spec.serviceAccountName":
+		return pod.Spec.ServiceAccountName, true
+	}
+	if key, ok := subscript(fieldPath, "metadata.labels"); ok {
+		return pod.Labels[key], true
+	}
+	if key, ok := subscript(fieldPath, "metadata.annotations"); ok {
+		return pod.Annotations[key], true
+	}
+	return "", false
+}
+
+// subscript returns the key of a field path like metadata.labels['app'].
+func subscript(fieldPath, field string) (string, bool) {
+	rest, ok := strings.CutPrefix(fieldPath, field+"[")
+	if !ok || !strings.HasSuffix(rest, "]") {
+		return "", false
+	}
+	key := strings.TrimSuffix(rest, "]")
+	if len(key) < 2 || key[0] != key[len(key)-1] || (key[0] != '\'' && key[0] != '"') {
+		return "", false
+	}
+	return key[1 : len(key)-1], true
+}
+
+// composeEnvEntry returns a compose environment list entry, quoted if it
+// would not be read back as a plain YAML string, e.g. an annotation holding
+// "key: value".
+func composeEnvEntry(name, value string) string {
+	entry := name + "=" + value
+	if strings.Contains(entry, ": ") || strings.Contains(entry, " #") || strings.HasSuffix(entry, ":") ||
+		strings.ContainsAny(entry, "\n\r\t") || strings.TrimSpace(value) != value {
+		return fmt.Sprintf("%q", entry)
+	}
+	return entry
+}
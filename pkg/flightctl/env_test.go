@@ -0,0 +1,88 @@
+package flightctl
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func fieldEnv(name, fieldPath string) corev1.EnvVar {
+	return corev1.EnvVar{Name: name, ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: fieldPath}}}
+}
+
+func downwardAPIPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			UID:         "1234",
+			Labels:      map[string]string{"app": "web"},
+			Annotations: map[string]string{"config": "level: debug"},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: "vk-flightctl",
+			Containers: []corev1.Container{{
+				Name:  "sidecar",
+				Image: "busybox:1.36",
+				Env: []corev1.EnvVar{
+					fieldEnv("POD_NAME", "metadata.name"),
+					fieldEnv("POD_NAMESPACE", "metadata.namespace"),
+					fieldEnv("POD_UID", "metadata.uid"),
+					fieldEnv("NODE_NAME", "spec.nodeName"),
+					fieldEnv("APP", "metadata.labels['app']"),
+					fieldEnv("CONFIG", "metadata.annotations['config']"),
+					fieldEnv("POD_IP", "status.podIP"),
+				},
+			}},
+		},
+	}
+}
+
+func TestEnvValueResolvesDownwardAPIFields(t *testing.T) {
+	pod := downwardAPIPod()
+	want := map[string]string{
+		"POD_NAME":      "web",
+		"POD_NAMESPACE": "default",
+		"POD_UID":       "1234",
+		"NODE_NAME":     "vk-flightctl",
+		"APP":           "web",
+		"CONFIG":        "level: debug",
+	}
+	for _, env := range pod.Spec.Containers[0].Env {
+		value, ok := envValue(pod, env)
+		if expected, resolvable := want[env.Name]; ok != resolvable || value != expected {
+			t.Errorf("envValue(%s) = %q, %v; want %q, %v", env.Name, value, ok, expected, resolvable)
+		}
+	}
+
+	if _, ok := envValue(pod, fieldEnv("BAD", "metadata.labels[app]")); ok {
+		t.Error("unquoted label subscript resolved")
+	}
+}
+
+func TestDownwardAPIEnvInTranslations(t *testing.T) {
+	pod := downwardAPIPod()
+
+	compose := convertPodToDockerCompose(pod)
+	for _, entry := range []string{"- POD_NAME=web\n", "- NODE_NAME=vk-flightctl\n", "- APP=web\n", `- "CONFIG=level: debug"`} {
+		if !strings.Contains(compose, entry) {
+			t.Errorf("compose file missing %q:\n%s", entry, compose)
+		}
+	}
+	if strings.Contains(compose, "POD_IP=") {
+		t.Errorf("compose file sets unresolvable POD_IP:\n%s", compose)
+	}
+
+	units := convertPodToQuadlet(pod, "default-web")
+	if !strings.Contains(units[1].Content, "Environment=POD_UID=1234\n") ||
+		!strings.Contains(units[1].Content, `Environment=CONFIG="level: debug"`) {
+		t.Errorf("quadlet unit missing downward API variables:\n%s", units[1].Content)
+	}
+
+	features := UnsupportedFeatures(pod)
+	if len(features) != 1 || features[0].Field != "spec.containers[sidecar].env[POD_IP].valueFrom" {
+		t.Errorf("unsupported features = %v, want only POD_IP", features)
+	}
+}
@@ -0,0 +1,157 @@
+package flightctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The variables of a container's envFrom sources are delivered in env
+// files next to the application's units, read by the container on start:
+// <container>.env for ConfigMap sources and <container>.secret.env for
+// Secret sources, so that the secret file is redacted from the logs like
+// other secret files. Variables set in env take precedence over them.
+
+// EnvFromAnnotation holds the variables of the containers' envFrom
+// sources, as the provider resolved them before translating the pod: a JSON
+// object mapping container names to their ContainerEnvFrom. It is set by
+// the provider on the copy of the pod it deploys, never by users.
+const EnvFromAnnotation = "flightctl.io/resolved-env-from"
+
+// ContainerEnvFrom holds the resolved envFrom variables of a container,
+// with their prefix, by the kind of object they were read from.
+type ContainerEnvFrom struct {
+	Config  map[string]string `json:"config,omitempty"`
+	Secrets map[string]string `json:"secrets,omitempty"`
+}
+
+// SetEnvFrom stores the resolved envFrom variables of the containers of the
+// pod in its EnvFromAnnotation.
+func SetEnvFrom(pod *corev1.Pod, envFrom map[string]ContainerEnvFrom) error {
+	data, err := json.Marshal(envFrom)
+	if err != nil {
+		return fmt.Errorf("encoding envFrom variables: %w", err)
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[EnvFromAnnotation] = string(data)
+	return nil
+}
+
+// envFromOf returns the resolved envFrom variables of the containers of a
+// pod.
+func envFromOf(pod *corev1.Pod) (map[string]ContainerEnvFrom, error) {
+	value, ok := pod.Annotations[EnvFromAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var envFrom map[string]ContainerEnvFrom
+	if err := json.Unmarshal([]byte(value), &envFrom); err != nil {
+		return nil, fmt.Errorf("pod %s/%s: invalid %s: %w", pod.Namespace, pod.Name, EnvFromAnnotation, err)
+	}
+	return envFrom, nil
+}
+
+// secretEnvFileSuffix ends the names of the env files of envFrom Secrets.
+const secretEnvFileSuffix = ".secret.env"
+
+// envFromFile returns the name of a container's env file in the
+// application, prefixed with prefix.
+func envFromFile(prefix string, container corev1.Container, secrets bool) string {
+	if secrets {
+		return fmt.Sprintf("%s%s%s", prefix, sanitizeServiceName(container.Name), secretEnvFileSuffix)
+	}
+	return fmt.Sprintf("%s%s.env", prefix, sanitizeServiceName(container.Name))
+}
+
+// envFromFileNames returns the env files of a container, named with
+// envFromFile and prefix, in the order they are read.
+func envFromFileNames(envFrom map[string]ContainerEnvFrom, prefix string, container corev1.Container) []string {
+	vars := envFrom[container.Name]
+	var names []string
+	if len(vars.Config) > 0 {
+		names = append(names, envFromFile(prefix, container, false))
+	}
+	if len(vars.Secrets) > 0 {
+		names = append(names, envFromFile(prefix, container, true))
+	}
+	return names
+}
+
+// envFromFiles returns the env files of the containers of a pod, named
+// with envFromFile and prefix, with values written in the format.
+func envFromFiles(pod *corev1.Pod, prefix string, format envFileFormat) ([]InlineContent, error) {
+	envFrom, err := envFromOf(pod)
+	if err != nil {
+		return nil, err
+	}
+	var files []InlineContent
+	for _, container := range podContainers(pod) {
+		vars := envFrom[container.Name]
+		for _, file := range []struct {
+			secrets bool
+			vars    map[string]string
+		}{{false, vars.Config}, {true, vars.Secrets}} {
+			if len(file.vars) == 0 {
+				continue
+			}
+			content, err := envFileContent(file.vars, format)
+			if err != nil {
+				return nil, fmt.Errorf("pod %s/%s: envFrom of container %s: %w", pod.Namespace, pod.Name, container.Name, err)
+			}
+			files = append(files, InlineContent{Path: envFromFile(prefix, container, file.secrets), Content: content})
+		}
+	}
+	return files, nil
+}
+
+// envFileFormat writes the value of a variable in an env file so that the
+// engine reading the file gets it back unchanged.
+type envFileFormat func(value string) string
+
+// composeEnvFileValue single-quotes a value for the env files of compose,
+// which expands variables in unquoted and double-quoted values, and strips
+// quotes and cuts comments from unquoted ones. Single-quoted values are read
+// literally, but for \' and \\ standing for a quote and a backslash.
+func composeEnvFileValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// podmanEnvFileValue writes a value as is for podman's EnvironmentFile,
+// which reads everything after NAME= literally.
+func podmanEnvFileValue(value string) string {
+	return value
+}
+
+// envFileContent renders variables as an env file, one NAME=value line per
+// variable in name order, with values written in the format. Neither
+// format lets values span lines.
+func envFileContent(vars map[string]string, format envFileFormat) (string, error) {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var content strings.Builder
+	for _, name := range names {
+		if name == "" || strings.ContainsAny(name, "=\n\r") {
+			return "", fmt.Errorf("invalid variable name %q", name)
+		}
+		if !EnvFileValue(vars[name]) {
+			return "", fmt.Errorf("value of variable %s spans lines, which env files cannot hold", name)
+		}
+		content.WriteString(fmt.Sprintf("%s=%s\n", name, format(vars[name])))
+	}
+	return content.String(), nil
+}
+
+// EnvFileValue reports whether a variable can be written to an env file:
+// its value is on a single line.
+func EnvFileValue(value string) bool {
+	return !strings.ContainsAny(value, "\n\r")
+}
@@ -0,0 +1,68 @@
+package flightctl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvFromFiles(t *testing.T) {
+	pod := inlinePod(nil)
+	if err := SetEnvFrom(pod, map[string]ContainerEnvFrom{"nginx": {
+		Config:  map[string]string{"MODE": "edge", "LEVEL": "debug"},
+		Secrets: map[string]string{"TOKEN": "t0ken"},
+	}}); err != nil {
+		t.Fatalf("SetEnvFrom: %v", err)
+	}
+
+	files, appType, err := quadletTranslator{}.TranslateApp(pod, "web")
+	if err != nil || appType != AppTypeQuadlet {
+		t.Fatalf("TranslateApp = %s, %v", appType, err)
+	}
+	contents := make(map[string]string)
+	for _, file := range files {
+		contents[file.Path] = file.Content
+	}
+	if unit := contents["web-nginx.container"]; !strings.Contains(unit, "EnvironmentFile=./web-nginx.env\nEnvironmentFile=./web-nginx.secret.env\n") {
+		t.Errorf("container unit does not read the env files:\n%s", unit)
+	}
+	if env := contents["web-nginx.env"]; env != "LEVEL=debug\nMODE=edge\n" {
+		t.Errorf("web-nginx.env = %q, want the ConfigMap variables in name order", env)
+	}
+	if redacted := redactedInline(files, nil); !strings.Contains(redacted[len(redacted)-1].Path, ".secret.env") || redacted[len(redacted)-1].Content != "***" {
+		t.Errorf("redacted secret env file = %+v, want it masked", redacted[len(redacted)-1])
+	}
+
+	if err := SetEnvFrom(pod, map[string]ContainerEnvFrom{"nginx": {Config: map[string]string{"CERT": "a\nb"}}}); err != nil {
+		t.Fatalf("SetEnvFrom: %v", err)
+	}
+	if _, _, err := NewComposeTranslator("").Translate(pod); err == nil {
+		t.Error("Translate with a value spanning lines succeeded, want an error")
+	}
+}
+
+func TestEnvFileValuesAreReadBackUnchanged(t *testing.T) {
+	pod := inlinePod(nil)
+	if err := SetEnvFrom(pod, map[string]ContainerEnvFrom{"nginx": {
+		Secrets: map[string]string{"DSN": `p@$$w'rd\x "q" #1`, "PLAIN": "edge"},
+	}}); err != nil {
+		t.Fatalf("SetEnvFrom: %v", err)
+	}
+
+	files, _, err := NewComposeTranslator("").Translate(pod)
+	if err != nil {
+		t.Fatalf("Translate: %v", err)
+	}
+	want := `DSN='p@$$w\'rd\\x "q" #1'` + "\nPLAIN='edge'\n"
+	if env := files[len(files)-1]; env.Path != "nginx.secret.env" || env.Content != want {
+		t.Errorf("compose env file %s = %q, want %q", env.Path, env.Content, want)
+	}
+
+	files, _, err = quadletTranslator{}.TranslateApp(pod, "web")
+	if err != nil {
+		t.Fatalf("TranslateApp: %v", err)
+	}
+	want = `DSN=p@$$w'rd\x "q" #1` + "\nPLAIN=edge\n"
+	if env := files[len(files)-1]; env.Path != "web-nginx.secret.env" || env.Content != want {
+		t.Errorf("quadlet env file %s = %q, want the values as they are %q", env.Path, env.Content, want)
+	}
+}
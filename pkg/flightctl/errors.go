@@ -0,0 +1,75 @@
+package flightctl
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error types returned by the Flightctl client. Returned errors carry request
+// details, so compare them with errors.Is rather than ==.
+var (
+	// ErrNotFound indicates the requested resource doesn't exist
+	ErrNotFound = &FlightctlError{Code: "NotFound", Message: "Resource not found"}
+
+	// ErrConflict indicates a conflict (e.g., concurrent device update)
+	ErrConflict = &FlightctlError{Code: "Conflict", Message: "Resource conflict"}
+
+	// ErrDeviceOffline indicates the device is not connected to FlightCtl
+	ErrDeviceOffline = &FlightctlError{Code: "DeviceOffline", Message: "Device is offline"}
+
+	// ErrUnauthorized indicates authentication or authorization failed
+	ErrUnauthorized = &FlightctlError{Code: "Unauthorized", Message: "Authentication failed"}
+
+	// ErrUnavailable indicates the FlightCtl API is temporarily unavailable
+	ErrUnavailable = &FlightctlError{Code: "Unavailable", Message: "Service unavailable"}
+
+	// ErrUnmanagedApplication indicates a device application with the pod's
+	// name exists but was not deployed by the provider
+	ErrUnmanagedApplication = &FlightctlError{Code: "UnmanagedApplication", Message: "Application not managed by the provider"}
+)
+
+// FlightctlError represents an error from the Flightctl API.
+type FlightctlError struct {
+	Code       string // Machine-readable error code
+	Message    string // Human-readable message
+	Details    string // Additional details
+	StatusCode int    // HTTP status code, if the error came from an API response
+}
+
+func (e *FlightctlError) Error() string {
+	if e.Details != "" {
+		return e.Code + ": " + e.Message + " (" + e.Details + ")"
+	}
+	return e.Code + ": " + e.Message
+}
+
+// Is reports whether target is a FlightctlError with the same code, so
+// detailed errors match the sentinel values.
+func (e *FlightctlError) Is(target error) bool {
+	t, ok := target.(*FlightctlError)
+	return ok && t.Code == e.Code
+}
+
+// newHTTPError builds a FlightctlError for an unexpected API response status.
+func newHTTPError(method, path string, statusCode int, body []byte) *FlightctlError {
+	code := "HTTPError"
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		code = ErrUnauthorized.Code
+	case http.StatusNotFound:
+		code = ErrNotFound.Code
+	case http.StatusConflict:
+		code = ErrConflict.Code
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		code = ErrUnavailable.Code
+	}
+
+	details := strings.TrimSpace(string(body))
+	return &FlightctlError{
+		Code:       code,
+		Message:    fmt.Sprintf("%s %s failed with status %d", method, path, statusCode),
+		Details:    details,
+		StatusCode: statusCode,
+	}
+}
@@ -0,0 +1,50 @@
+package flightctl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPErrorsMatchSentinels(t *testing.T) {
+	tests := []struct {
+		status int
+		want   error
+	}{
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusConflict, ErrConflict},
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusForbidden, ErrUnauthorized},
+		{http.StatusServiceUnavailable, ErrUnavailable},
+	}
+
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "boom", tt.status)
+		}))
+		c := &Client{httpClient: srv.Client(), baseURL: srv.URL}
+
+		_, err := c.GetDevice(context.Background(), "dev-1")
+		srv.Close()
+
+		if !errors.Is(err, tt.want) {
+			t.Errorf("status %d: expected %v, got %v", tt.status, tt.want, err)
+		}
+		var fcErr *FlightctlError
+		if !errors.As(err, &fcErr) || fcErr.StatusCode != tt.status {
+			t.Errorf("status %d: expected FlightctlError with status code, got %#v", tt.status, err)
+		}
+	}
+}
+
+func TestFlightctlErrorIsDistinct(t *testing.T) {
+	err := newHTTPError("PUT", "/api/v1/devices/dev-1", http.StatusConflict, nil)
+	if errors.Is(err, ErrNotFound) {
+		t.Error("conflict error must not match ErrNotFound")
+	}
+	if errors.Is(newHTTPError("GET", "/", http.StatusInternalServerError, nil), ErrUnavailable) {
+		t.Error("500 must not match ErrUnavailable")
+	}
+}
@@ -0,0 +1,79 @@
+package fake
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+)
+
+const enrollmentRequestsPath = "/api/v1/enrollmentrequests"
+
+// AddEnrollmentRequest adds or replaces the pending enrollment request of a
+// device asking for the labels.
+func (s *Server) AddEnrollmentRequest(name string, labels map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	s.enrollments[name] = &flightctl.FlightctlEnrollmentRequest{
+		APIVersion: "v1alpha1",
+		Kind:       "EnrollmentRequest",
+		Metadata:   flightctl.FlightctlEnrollmentRequestMeta{Name: name, CreationTimestamp: &now},
+		Spec:       flightctl.FlightctlEnrollmentRequestSpec{Labels: labels},
+	}
+}
+
+// ApproveEnrollmentRequest approves, or with approved false denies, an
+// enrollment request.
+func (s *Server) ApproveEnrollmentRequest(name string, approved bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	request, ok := s.enrollments[name]
+	if !ok {
+		return fmt.Errorf("enrollment request %s not found", name)
+	}
+	now := time.Now().UTC()
+	request.Status = &flightctl.FlightctlEnrollmentRequestStatus{
+		Approval: &flightctl.FlightctlEnrollmentApproval{Approved: approved, ApprovedBy: "fake", ApprovedAt: &now},
+	}
+	return nil
+}
+
+// SetFleetSelector sets the label selector of a fleet.
+func (s *Server) SetFleetSelector(name string, matchLabels map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fleet, ok := s.fleets[name]
+	if !ok {
+		return fmt.Errorf("fleet %s not found", name)
+	}
+	fleet.Spec.Selector = &flightctl.FlightctlLabelSelector{MatchLabels: matchLabels}
+	return nil
+}
+
+func (s *Server) handleListEnrollmentRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	var items []flightctl.FlightctlEnrollmentRequest
+	for _, name := range sortedKeys(s.enrollments) {
+		items = append(items, *s.enrollments[name])
+	}
+	s.mu.Unlock()
+
+	page, next, err := s.paginate(len(items), r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, flightctl.FlightctlEnrollmentRequestList{
+		APIVersion: "v1alpha1",
+		Kind:       "EnrollmentRequestList",
+		Metadata:   flightctl.FlightctlListMeta{Continue: next},
+		Items:      items[page[0]:page[1]],
+	})
+}
@@ -0,0 +1,193 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+)
+
+// Fleet templates are rendered to the fleet's devices, replacing their
+// applications as FlightCtl does. A fleet without a batch rollout policy
+// renders a new template version to all its devices at once; one with a
+// policy waits for RolloutBatch to render it batch by batch.
+
+// SetRolloutPolicy sets the rollout policy of a fleet.
+func (s *Server) SetRolloutPolicy(name string, policy *flightctl.FlightctlRolloutPolicy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fleet, ok := s.fleets[name]
+	if !ok {
+		return fmt.Errorf("fleet %s not found", name)
+	}
+	fleet.Spec.RolloutPolicy = policy
+	return nil
+}
+
+// Fleet returns a copy of a fleet.
+func (s *Server) Fleet(name string) (*flightctl.FlightctlFleet, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fleet, ok := s.fleets[name]
+	if !ok {
+		return nil, false
+	}
+	return copyFleet(fleet), true
+}
+
+func (s *Server) handlePutFleet(w http.ResponseWriter, r *http.Request, name string) {
+	var fleet flightctl.FlightctlFleet
+	if err := json.NewDecoder(r.Body).Decode(&fleet); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if fleet.Metadata.Name != name {
+		writeError(w, http.StatusBadRequest, "metadata.name does not match the path")
+		return
+	}
+
+	s.mu.Lock()
+	stored, ok := s.fleets[name]
+	switch {
+	case !ok:
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "fleet "+name+" not found")
+		return
+	case fleet.Metadata.ResourceVersion != "" && fleet.Metadata.ResourceVersion != stored.Metadata.ResourceVersion:
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, "the object has been modified; please apply your changes to the latest version and try again")
+		return
+	}
+	s.replaceFleetSpecLocked(stored, fleet.Spec)
+	updated := s.fleetWithSummary(name)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// replaceFleetSpecLocked stores a fleet spec. A changed template gets a new
+// template version, which is rolled out to the fleet's devices. Caller must
+// hold s.mu.
+func (s *Server) replaceFleetSpecLocked(fleet *flightctl.FlightctlFleet, spec flightctl.FlightctlFleetSpec) {
+	templateChanged := !sameJSON(fleet.Spec.Template, spec.Template)
+	if sameJSON(fleet.Spec, spec) {
+		return
+	}
+	fleet.Spec = spec
+	version, _ := strconv.Atoi(fleet.Metadata.ResourceVersion)
+	fleet.Metadata.ResourceVersion = strconv.Itoa(version + 1)
+	if !templateChanged {
+		return
+	}
+
+	if fleet.Metadata.Annotations == nil {
+		fleet.Metadata.Annotations = make(map[string]string)
+	}
+	templateVersion, _ := strconv.Atoi(fleet.Metadata.Annotations[flightctl.FleetTemplateVersionAnnotation])
+	fleet.Metadata.Annotations[flightctl.FleetTemplateVersionAnnotation] = strconv.Itoa(templateVersion + 1)
+	fleet.Metadata.Annotations[flightctl.FleetDeployingTemplateVersionAnnotation] = strconv.Itoa(templateVersion + 1)
+	delete(fleet.Metadata.Annotations, flightctl.FleetLastBatchReportAnnotation)
+
+	if !fleet.HasBatchRollout() {
+		for _, device := range s.fleetDevicesLocked(fleet.Metadata.Name) {
+			s.renderTemplateLocked(fleet, device)
+		}
+		s.setRolloutLocked(fleet, "Inactive")
+		return
+	}
+	fleet.Metadata.Annotations[flightctl.FleetBatchNumberAnnotation] = "-1"
+	s.setRolloutLocked(fleet, "Waiting")
+}
+
+// RolloutBatch renders the template version being rolled out to the devices
+// of the next batch of a fleet with a batch rollout policy, and reports the
+// batch as successful. The rollout ends once every device of the fleet
+// renders the version.
+func (s *Server) RolloutBatch(name string, devices ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fleet, ok := s.fleets[name]
+	if !ok {
+		return fmt.Errorf("fleet %s not found", name)
+	}
+	batch, _ := strconv.Atoi(fleet.Metadata.Annotations[flightctl.FleetBatchNumberAnnotation])
+	batch++
+	for _, deviceName := range devices {
+		device, ok := s.devices[deviceName]
+		if !ok || device.Metadata.Owner != fleetOwnerPrefix+name {
+			return fmt.Errorf("device %s not found in fleet %s", deviceName, name)
+		}
+		s.renderTemplateLocked(fleet, device)
+	}
+
+	if fleet.Metadata.Annotations == nil {
+		fleet.Metadata.Annotations = make(map[string]string)
+	}
+	fleet.Metadata.Annotations[flightctl.FleetBatchNumberAnnotation] = strconv.Itoa(batch)
+	report, err := json.Marshal(map[string]interface{}{
+		"batchName":         fmt.Sprintf("batch %d", batch+1),
+		"batchNumber":       batch,
+		"successPercentage": 100,
+		"total":             len(devices),
+		"successful":        len(devices),
+	})
+	if err != nil {
+		return err
+	}
+	fleet.Metadata.Annotations[flightctl.FleetLastBatchReportAnnotation] = string(report)
+
+	version := fleet.Metadata.Annotations[flightctl.FleetDeployingTemplateVersionAnnotation]
+	for _, device := range s.fleetDevicesLocked(name) {
+		if device.Metadata.Annotations[renderedTemplateVersionAnnotation] != version {
+			s.setRolloutLocked(fleet, "Active")
+			return nil
+		}
+	}
+	s.setRolloutLocked(fleet, "Inactive")
+	return nil
+}
+
+// renderedTemplateVersionAnnotation is the fleet template version a device
+// renders.
+const renderedTemplateVersionAnnotation = "device-controller/renderedTemplateVersion"
+
+// renderTemplateLocked replaces a device's applications with those of its
+// fleet's template. Caller must hold s.mu.
+func (s *Server) renderTemplateLocked(fleet *flightctl.FlightctlFleet, device *flightctl.FlightctlDevice) {
+	spec := copyDevice(device).Spec
+	spec.Applications = nil
+	if fleet.Spec.Template != nil {
+		spec.Applications = copyFleet(fleet).Spec.Template.Spec.Applications
+	}
+	s.setSpecLocked(device, spec)
+	if device.Metadata.Annotations == nil {
+		device.Metadata.Annotations = make(map[string]string)
+	}
+	device.Metadata.Annotations[renderedTemplateVersionAnnotation] = fleet.Metadata.Annotations[flightctl.FleetDeployingTemplateVersionAnnotation]
+}
+
+// setRolloutLocked sets the fleet's RolloutInProgress condition: true with
+// the reason, or false once the rollout is Inactive. Caller must hold s.mu.
+func (s *Server) setRolloutLocked(fleet *flightctl.FlightctlFleet, reason string) {
+	status := "True"
+	if reason == "Inactive" {
+		status = "False"
+	}
+	if fleet.Status == nil {
+		fleet.Status = &flightctl.FlightctlFleetStatus{}
+	}
+	fleet.Status.Conditions = []flightctl.FlightctlCondition{{Type: flightctl.FleetRolloutInProgress, Status: status, Reason: reason}}
+}
+
+// fleetDevicesLocked returns the devices of a fleet, sorted by name. Caller
+// must hold s.mu.
+func (s *Server) fleetDevicesLocked(name string) []*flightctl.FlightctlDevice {
+	var devices []*flightctl.FlightctlDevice
+	for _, deviceName := range sortedKeys(s.devices) {
+		if device := s.devices[deviceName]; device.Metadata.Owner == fleetOwnerPrefix+name {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
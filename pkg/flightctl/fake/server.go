@@ -0,0 +1,931 @@
+// Package fake provides an in-memory FlightCtl API server for tests and
+// local development. It serves the device, fleet, enrollment request,
+// console and token endpoints used by the flightctl client, simulates
+// application status on devices, and supports latency and failure
+// injection.
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+)
+
+// Credentials accepted by the token endpoint.
+const (
+	ClientID     = "fake-client"
+	ClientSecret = "fake-secret"
+)
+
+const (
+	tokenPath        = "/token"
+	devicesPath      = "/api/v1/devices"
+	fleetsPath       = "/api/v1/fleets"
+	consolePrefix    = "/ws/v1/devices/"
+	fleetOwnerPrefix = "Fleet/"
+	tokenLifetime    = time.Hour
+)
+
+// Failure makes matching requests fail. Method and PathPrefix are optional
+// filters; the failure applies to the next Count matching requests (every
+// matching request if Count is 0).
+type Failure struct {
+	Method     string
+	PathPrefix string
+	// StatusCode is returned with an error body. If 0, the connection is
+	// closed without a response, simulating a network error.
+	StatusCode int
+	Count      int
+}
+
+// Request records a request received by the server.
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+}
+
+// Server is a fake FlightCtl API backed by an httptest.Server.
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	devices     map[string]*flightctl.FlightctlDevice
+	fleets      map[string]*flightctl.FlightctlFleet
+	enrollments map[string]*flightctl.FlightctlEnrollmentRequest
+	tokens      map[string]bool
+	issued      int
+	requireAuth bool
+	latency     time.Duration
+	pageSize    int
+	appStatus   string
+	manual      bool
+	failures    []*Failure
+	requests    []Request
+	console     ConsoleHandler
+}
+
+// ConsoleHandler runs a console command on a device: it reads the command's
+// stdin and writes its output until it returns the command's error. Resize
+// events of a TTY session are delivered on resize.
+type ConsoleHandler func(device string, metadata flightctl.ConsoleMetadata, stdin io.Reader, stdout, stderr io.Writer, resize <-chan flightctl.TerminalSize) error
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithLatency delays every response.
+func WithLatency(d time.Duration) Option {
+	return func(s *Server) { s.latency = d }
+}
+
+// WithPageSize caps list responses at n items, returning a continue token.
+func WithPageSize(n int) Option {
+	return func(s *Server) { s.pageSize = n }
+}
+
+// WithoutAuth accepts API requests without a bearer token.
+func WithoutAuth() Option {
+	return func(s *Server) { s.requireAuth = false }
+}
+
+// WithApplicationStatus sets the status reported for newly deployed
+// applications (default "Running"). Use "" to report no status until
+// SetApplicationStatus is called.
+func WithApplicationStatus(status string) Option {
+	return func(s *Server) { s.appStatus = status }
+}
+
+// WithManualRollout leaves updated device specs rendered but not applied:
+// the device reports the previous rendered version, and the statuses of its
+// applications are not updated, until CompleteRollout is called.
+func WithManualRollout() Option {
+	return func(s *Server) { s.manual = true }
+}
+
+// NewServer starts a fake FlightCtl API. Close it when done.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		devices:     make(map[string]*flightctl.FlightctlDevice),
+		fleets:      make(map[string]*flightctl.FlightctlFleet),
+		enrollments: make(map[string]*flightctl.FlightctlEnrollmentRequest),
+		tokens:      make(map[string]bool),
+		requireAuth: true,
+		appStatus:   "Running",
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(tokenPath, s.handleToken)
+	mux.HandleFunc(devicesPath, s.handleListDevices)
+	mux.HandleFunc(devicesPath+"/", s.handleDevice)
+	mux.HandleFunc(fleetsPath, s.handleListFleets)
+	mux.HandleFunc(fleetsPath+"/", s.handleFleet)
+	mux.HandleFunc(enrollmentRequestsPath, s.handleListEnrollmentRequests)
+	mux.HandleFunc(consolePrefix, s.handleConsole)
+	s.Server = httptest.NewServer(s.middleware(mux))
+	return s
+}
+
+// ClientConfig returns a flightctl client configuration for the server,
+// using client credentials and short retry delays.
+func (s *Server) ClientConfig() flightctl.Config {
+	return flightctl.Config{
+		APIURL:       s.URL,
+		ClientID:     ClientID,
+		ClientSecret: ClientSecret,
+		TokenURL:     s.URL + tokenPath,
+		Retry: flightctl.RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Millisecond,
+			MaxDelay:    10 * time.Millisecond,
+		},
+	}
+}
+
+// NewClient returns a flightctl client connected to the server.
+func (s *Server) NewClient() (*flightctl.Client, error) {
+	return flightctl.NewClient(s.ClientConfig())
+}
+
+// AddFleet adds or replaces a fleet.
+func (s *Server) AddFleet(name string, labels map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	s.fleets[name] = &flightctl.FlightctlFleet{
+		APIVersion: "v1alpha1",
+		Kind:       "Fleet",
+		Metadata:   flightctl.FlightctlFleetMetadata{Name: name, Labels: labels, CreationTimestamp: &now, ResourceVersion: "1"},
+	}
+}
+
+// AddDevice adds or replaces an online device, optionally owned by a fleet.
+func (s *Server) AddDevice(name, fleet string, labels map[string]string) {
+	now := time.Now().UTC()
+	device := flightctl.FlightctlDevice{
+		APIVersion: "v1alpha1",
+		Kind:       "Device",
+		Metadata: flightctl.FlightctlDeviceMetadata{
+			Name:              name,
+			Labels:            labels,
+			Annotations:       map[string]string{flightctl.RenderedVersionAnnotation: "1"},
+			CreationTimestamp: &now,
+			ResourceVersion:   "1",
+		},
+		Status: &flightctl.FlightctlDeviceStatus{
+			Summary:  &flightctl.FlightctlDeviceSummary{Status: "Online"},
+			LastSeen: &now,
+			Config:   &flightctl.FlightctlDeviceConfigStatus{RenderedVersion: "1"},
+			Updated:  &flightctl.FlightctlDeviceUpdated{Status: flightctl.DeviceUpdatedUpToDate},
+		},
+	}
+	if fleet != "" {
+		device.Metadata.Owner = fleetOwnerPrefix + fleet
+	}
+	s.PutDevice(device)
+}
+
+// PutDevice adds or replaces a device resource as given.
+func (s *Server) PutDevice(device flightctl.FlightctlDevice) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices[device.Metadata.Name] = copyDevice(&device)
+}
+
+// RemoveDevice deletes a device, as when it is decommissioned.
+func (s *Server) RemoveDevice(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.devices, name)
+}
+
+// Device returns a copy of a device resource.
+func (s *Server) Device(name string) (*flightctl.FlightctlDevice, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device, ok := s.devices[name]
+	if !ok {
+		return nil, false
+	}
+	return copyDevice(device), true
+}
+
+// SetDeviceSummary sets the device summary status, e.g. "Online" or
+// "Offline".
+func (s *Server) SetDeviceSummary(name, status string) error {
+	return s.updateStatus(name, func(st *flightctl.FlightctlDeviceStatus) {
+		st.Summary = &flightctl.FlightctlDeviceSummary{Status: status}
+	})
+}
+
+// SetApplicationStatus sets the status reported for an application on a
+// device, replacing any previous status with the same name.
+func (s *Server) SetApplicationStatus(device string, app flightctl.FlightctlApplicationStatus) error {
+	return s.updateStatus(device, func(st *flightctl.FlightctlDeviceStatus) {
+		for i := range st.Applications {
+			if st.Applications[i].Name == app.Name {
+				st.Applications[i] = app
+				return
+			}
+		}
+		st.Applications = append(st.Applications, app)
+	})
+}
+
+// RemoveApplicationStatus stops reporting the status of an application on
+// a device, as a device does once it stopped the application, while the
+// application stays in the device spec.
+func (s *Server) RemoveApplicationStatus(device, app string) error {
+	return s.updateStatus(device, func(st *flightctl.FlightctlDeviceStatus) {
+		st.Applications = slices.DeleteFunc(st.Applications, func(status flightctl.FlightctlApplicationStatus) bool {
+			return status.Name == app
+		})
+	})
+}
+
+// SetCustomInfo sets the custom system info a device reports, e.g. its
+// resource usage (see flightctl.CPUUsageInfoKey).
+func (s *Server) SetCustomInfo(name string, info map[string]string) error {
+	return s.updateStatus(name, func(st *flightctl.FlightctlDeviceStatus) {
+		if st.SystemInfo == nil {
+			st.SystemInfo = make(map[string]interface{})
+		}
+		custom := make(map[string]interface{}, len(info))
+		for key, value := range info {
+			custom[key] = value
+		}
+		st.SystemInfo["customInfo"] = custom
+	})
+}
+
+// SetSystemInfo sets system info entries a device reports, e.g.
+// "architecture" or "kernel".
+func (s *Server) SetSystemInfo(name string, info map[string]string) error {
+	return s.updateStatus(name, func(st *flightctl.FlightctlDeviceStatus) {
+		if st.SystemInfo == nil {
+			st.SystemInfo = make(map[string]interface{})
+		}
+		for key, value := range info {
+			st.SystemInfo[key] = value
+		}
+	})
+}
+
+// SetDeviceResources sets the resource health a device reports, e.g.
+// "Healthy" or "Critical".
+func (s *Server) SetDeviceResources(name, cpu, memory, disk string) error {
+	return s.updateStatus(name, func(st *flightctl.FlightctlDeviceStatus) {
+		st.Resources = &flightctl.FlightctlDeviceResources{CPU: cpu, Memory: memory, Disk: disk}
+	})
+}
+
+// SetDeviceCondition sets a device condition, replacing any previous one of
+// the same type.
+func (s *Server) SetDeviceCondition(name string, cond flightctl.FlightctlCondition) error {
+	return s.updateStatus(name, func(st *flightctl.FlightctlDeviceStatus) {
+		for i := range st.Conditions {
+			if st.Conditions[i].Type == cond.Type {
+				st.Conditions[i] = cond
+				return
+			}
+		}
+		st.Conditions = append(st.Conditions, cond)
+	})
+}
+
+func (s *Server) updateStatus(name string, update func(*flightctl.FlightctlDeviceStatus)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device, ok := s.devices[name]
+	if !ok {
+		return fmt.Errorf("device %s not found", name)
+	}
+	if device.Status == nil {
+		device.Status = &flightctl.FlightctlDeviceStatus{}
+	}
+	update(device.Status)
+	return nil
+}
+
+// HandleConsole sets how console commands run on devices. Without a
+// handler console sessions are rejected.
+func (s *Server) HandleConsole(h ConsoleHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.console = h
+}
+
+// InjectFailure makes matching requests fail; see Failure.
+func (s *Server) InjectFailure(f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &f)
+}
+
+// ClearFailures removes all injected failures.
+func (s *Server) ClearFailures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = nil
+}
+
+// RevokeTokens invalidates all issued access tokens, so API requests fail
+// with 401 until a new token is obtained.
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = make(map[string]bool)
+}
+
+// SetLatency changes the delay of every response.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// middleware records requests and applies latency, failure injection and
+// authentication.
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery})
+		latency := s.latency
+		failure := s.matchFailure(r)
+		authorized := !s.requireAuth || r.URL.Path == tokenPath ||
+			s.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		s.mu.Unlock()
+
+		if latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if failure != nil {
+			if failure.StatusCode == 0 {
+				if hj, ok := w.(http.Hijacker); ok {
+					if conn, _, err := hj.Hijack(); err == nil {
+						conn.Close()
+						return
+					}
+				}
+				failure.StatusCode = http.StatusInternalServerError
+			}
+			writeError(w, failure.StatusCode, "injected failure")
+			return
+		}
+		if !authorized {
+			writeError(w, http.StatusUnauthorized, "invalid or missing bearer token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// matchFailure returns the first failure matching r and consumes one use of
+// it. Caller must hold s.mu.
+func (s *Server) matchFailure(r *http.Request) *Failure {
+	for i, f := range s.failures {
+		if f.Method != "" && f.Method != r.Method {
+			continue
+		}
+		if !strings.HasPrefix(r.URL.Path, f.PathPrefix) {
+			continue
+		}
+		match := *f
+		if f.Count > 0 {
+			f.Count--
+			if f.Count == 0 {
+				s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			}
+		}
+		return &match
+	}
+	return nil
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.PostForm.Get("grant_type") {
+	case "client_credentials":
+		if r.PostForm.Get("client_id") != ClientID || r.PostForm.Get("client_secret") != ClientSecret {
+			writeError(w, http.StatusUnauthorized, "invalid client credentials")
+			return
+		}
+	case "refresh_token":
+		if !s.tokens["refresh:"+r.PostForm.Get("refresh_token")] {
+			writeError(w, http.StatusBadRequest, "invalid refresh token")
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported grant type")
+		return
+	}
+
+	s.issued++
+	access := fmt.Sprintf("fake-access-%d", s.issued)
+	refresh := fmt.Sprintf("fake-refresh-%d", s.issued)
+	s.tokens[access] = true
+	s.tokens["refresh:"+refresh] = true
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  access,
+		"token_type":    "Bearer",
+		"expires_in":    int(tokenLifetime.Seconds()),
+		"refresh_token": refresh,
+	})
+}
+
+func (s *Server) handleListDevices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query := r.URL.Query()
+	labels, err := parseSelector(query.Get("labelSelector"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	owner := ""
+	if field := query.Get("fieldSelector"); field != "" {
+		var ok bool
+		if owner, ok = strings.CutPrefix(field, "metadata.owner="); !ok {
+			writeError(w, http.StatusBadRequest, "unsupported field selector "+field)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	var items []flightctl.FlightctlDevice
+	for _, name := range sortedKeys(s.devices) {
+		device := s.devices[name]
+		if owner != "" && device.Metadata.Owner != owner {
+			continue
+		}
+		if !matchLabels(device.Metadata.Labels, labels) {
+			continue
+		}
+		items = append(items, *copyDevice(device))
+	}
+	s.mu.Unlock()
+
+	page, next, err := s.paginate(len(items), query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, flightctl.FlightctlDeviceList{
+		APIVersion: "v1alpha1",
+		Kind:       "DeviceList",
+		Metadata:   flightctl.FlightctlListMeta{Continue: next},
+		Items:      items[page[0]:page[1]],
+	})
+}
+
+func (s *Server) handleDevice(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, devicesPath+"/")
+	switch r.Method {
+	case http.MethodGet:
+		device, ok := s.Device(name)
+		if !ok {
+			writeError(w, http.StatusNotFound, "device "+name+" not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, device)
+	case http.MethodPut:
+		var device flightctl.FlightctlDevice
+		if err := json.NewDecoder(r.Body).Decode(&device); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if device.Metadata.Name != name {
+			writeError(w, http.StatusBadRequest, "metadata.name does not match the path")
+			return
+		}
+		updated, status := s.replaceSpec(name, device.Metadata.ResourceVersion, device.Spec)
+		switch status {
+		case http.StatusNotFound:
+			writeError(w, status, "device "+name+" not found")
+		case http.StatusConflict:
+			writeError(w, status, "the object has been modified; please apply your changes to the latest version and try again")
+		default:
+			writeJSON(w, status, updated)
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// replaceSpec stores a new device spec, keeping the server-side status as
+// FlightCtl does, and returns the HTTP status of the update. An update
+// carrying a stale resourceVersion conflicts. A changed spec gets a new
+// resourceVersion and rendered version, which the device applies
+// immediately unless the rollout is manual.
+func (s *Server) replaceSpec(name, resourceVersion string, spec flightctl.FlightctlDeviceSpec) (*flightctl.FlightctlDevice, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device, ok := s.devices[name]
+	if !ok {
+		return nil, http.StatusNotFound
+	}
+	if resourceVersion != "" && resourceVersion != device.Metadata.ResourceVersion {
+		return nil, http.StatusConflict
+	}
+	s.setSpecLocked(device, spec)
+	return copyDevice(device), http.StatusOK
+}
+
+// setSpecLocked stores a device spec; see replaceSpec. Caller must hold s.mu.
+func (s *Server) setSpecLocked(device *flightctl.FlightctlDevice, spec flightctl.FlightctlDeviceSpec) {
+	if device.Status == nil {
+		device.Status = &flightctl.FlightctlDeviceStatus{}
+	}
+	if sameJSON(device.Spec, spec) {
+		return
+	}
+	device.Spec = spec
+	version, _ := strconv.Atoi(device.Metadata.ResourceVersion)
+	device.Metadata.ResourceVersion = strconv.Itoa(version + 1)
+
+	if version, err := strconv.Atoi(device.RenderedVersion()); err == nil {
+		if device.Metadata.Annotations == nil {
+			device.Metadata.Annotations = make(map[string]string)
+		}
+		device.Metadata.Annotations[flightctl.RenderedVersionAnnotation] = strconv.Itoa(version + 1)
+	}
+	if s.manual {
+		device.Status.Updated = &flightctl.FlightctlDeviceUpdated{Status: "OutOfDate"}
+	} else {
+		s.applySpec(device)
+	}
+}
+
+// CompleteRollout makes a device apply its latest rendered spec; see
+// WithManualRollout.
+func (s *Server) CompleteRollout(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device, ok := s.devices[name]
+	if !ok {
+		return fmt.Errorf("device %s not found", name)
+	}
+	if device.Status == nil {
+		device.Status = &flightctl.FlightctlDeviceStatus{}
+	}
+	s.applySpec(device)
+	return nil
+}
+
+// applySpec reports the device's rendered spec as applied and reconciles
+// the simulated application statuses with it.
+func (s *Server) applySpec(device *flightctl.FlightctlDevice) {
+	if version := device.RenderedVersion(); version != "" {
+		device.Status.Config = &flightctl.FlightctlDeviceConfigStatus{RenderedVersion: version}
+	}
+	device.Status.Updated = &flightctl.FlightctlDeviceUpdated{Status: flightctl.DeviceUpdatedUpToDate}
+
+	existing := make(map[string]flightctl.FlightctlApplicationStatus)
+	for _, st := range device.Status.Applications {
+		existing[st.Name] = st
+	}
+	var statuses []flightctl.FlightctlApplicationStatus
+	for _, app := range device.Spec.Applications {
+		if st, ok := existing[app.Name]; ok {
+			statuses = append(statuses, st)
+		} else if s.appStatus != "" {
+			statuses = append(statuses, flightctl.FlightctlApplicationStatus{Name: app.Name, Status: s.appStatus})
+		}
+	}
+	device.Status.Applications = statuses
+}
+
+func (s *Server) handleConsole(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, consolePrefix), "/console")
+	if !ok || name == "" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	var metadata flightctl.ConsoleMetadata
+	if err := json.Unmarshal([]byte(r.URL.Query().Get("metadata")), &metadata); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid console metadata: "+err.Error())
+		return
+	}
+	s.mu.Lock()
+	_, exists := s.devices[name]
+	handler := s.console
+	s.mu.Unlock()
+	if !exists {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("device %s not found", name))
+		return
+	}
+	if handler == nil {
+		writeError(w, http.StatusServiceUnavailable, "console not available")
+		return
+	}
+
+	upgrader := websocket.Upgrader{Subprotocols: []string{flightctl.ConsoleProtocol}}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var writeMu sync.Mutex
+	send := func(stream byte, data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteMessage(websocket.BinaryMessage, append([]byte{stream}, data...))
+	}
+
+	// Demultiplex stdin and resize events until the client closes stdin
+	// or disconnects
+	stdin, stdinWriter := io.Pipe()
+	resize := make(chan flightctl.TerminalSize, 4)
+	go func() {
+		defer stdinWriter.Close()
+		stdinOpen := true
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if len(message) == 0 {
+				continue
+			}
+			switch message[0] {
+			case flightctl.ConsoleStdin:
+				if stdinOpen {
+					_, _ = stdinWriter.Write(message[1:])
+				}
+			case flightctl.ConsoleResize:
+				var size flightctl.TerminalSize
+				if json.Unmarshal(message[1:], &size) == nil {
+					select {
+					case resize <- size:
+					default:
+					}
+				}
+			case flightctl.ConsoleClose:
+				if len(message) > 1 && message[1] == flightctl.ConsoleStdin {
+					stdinOpen = false
+					stdinWriter.Close()
+				}
+			}
+		}
+	}()
+
+	runErr := handler(name, metadata, stdin, streamWriter{send, flightctl.ConsoleStdout}, streamWriter{send, flightctl.ConsoleStderr}, resize)
+	stdin.Close()
+	status := map[string]string{"status": "Success"}
+	if runErr != nil {
+		status = map[string]string{"status": "Failure", "message": runErr.Error()}
+	}
+	data, _ := json.Marshal(status)
+	_ = send(flightctl.ConsoleError, data)
+
+	writeMu.Lock()
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	writeMu.Unlock()
+}
+
+// streamWriter writes to one stream of a console session.
+type streamWriter struct {
+	send   func(stream byte, data []byte) error
+	stream byte
+}
+
+func (w streamWriter) Write(p []byte) (int, error) {
+	if err := w.send(w.stream, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *Server) handleListFleets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	var items []flightctl.FlightctlFleet
+	for _, name := range sortedKeys(s.fleets) {
+		items = append(items, s.fleetWithSummary(name))
+	}
+	s.mu.Unlock()
+
+	page, next, err := s.paginate(len(items), query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, flightctl.FlightctlFleetList{
+		APIVersion: "v1alpha1",
+		Kind:       "FleetList",
+		Metadata:   flightctl.FlightctlListMeta{Continue: next},
+		Items:      items[page[0]:page[1]],
+	})
+}
+
+func (s *Server) handleFleet(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, fleetsPath+"/")
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		s.handlePutFleet(w, r, name)
+		return
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	_, ok := s.fleets[name]
+	var fleet flightctl.FlightctlFleet
+	if ok {
+		fleet = s.fleetWithSummary(name)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "fleet "+name+" not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, fleet)
+}
+
+// fleetWithSummary returns a copy of the fleet with its device count.
+// Caller must hold s.mu.
+func (s *Server) fleetWithSummary(name string) flightctl.FlightctlFleet {
+	fleet := copyFleet(s.fleets[name])
+	var total int64
+	for _, device := range s.devices {
+		if device.Metadata.Owner == fleetOwnerPrefix+name {
+			total++
+		}
+	}
+	if fleet.Status == nil {
+		fleet.Status = &flightctl.FlightctlFleetStatus{}
+	}
+	fleet.Status.DevicesSummary = &flightctl.FlightctlDevicesSummary{Total: total}
+	return *fleet
+}
+
+// paginate returns the [start, end) range of the page requested by the
+// limit and continue parameters, and the continue token for the next page.
+func (s *Server) paginate(total int, query map[string][]string) ([2]int, string, error) {
+	start := 0
+	if token := first(query["continue"]); token != "" {
+		n, err := strconv.Atoi(token)
+		if err != nil || n < 0 || n > total {
+			return [2]int{}, "", fmt.Errorf("invalid continue token %q", token)
+		}
+		start = n
+	}
+
+	limit := s.pageSize
+	if value := first(query["limit"]); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return [2]int{}, "", fmt.Errorf("invalid limit %q", value)
+		}
+		if limit == 0 || n < limit {
+			limit = n
+		}
+	}
+
+	end := total
+	if limit > 0 && start+limit < total {
+		end = start + limit
+	}
+	next := ""
+	if end < total {
+		next = strconv.Itoa(end)
+	}
+	return [2]int{start, end}, next, nil
+}
+
+// parseSelector parses a k1=v1,k2=v2 label selector.
+func parseSelector(selector string) (map[string]string, error) {
+	if selector == "" {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, part := range strings.Split(selector, ",") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("unsupported label selector %q", selector)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+func matchLabels(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func sameJSON(a, b interface{}) bool {
+	x, err1 := json.Marshal(a)
+	y, err2 := json.Marshal(b)
+	return err1 == nil && err2 == nil && string(x) == string(y)
+}
+
+func copyDevice(device *flightctl.FlightctlDevice) *flightctl.FlightctlDevice {
+	data, err := json.Marshal(device)
+	if err != nil {
+		panic(fmt.Sprintf("copying device: %v", err))
+	}
+	var out flightctl.FlightctlDevice
+	if err := json.Unmarshal(data, &out); err != nil {
+		panic(fmt.Sprintf("copying device: %v", err))
+	}
+	return &out
+}
+
+func copyFleet(fleet *flightctl.FlightctlFleet) *flightctl.FlightctlFleet {
+	data, err := json.Marshal(fleet)
+	if err != nil {
+		panic(fmt.Sprintf("copying fleet: %v", err))
+	}
+	var out flightctl.FlightctlFleet
+	if err := json.Unmarshal(data, &out); err != nil {
+		panic(fmt.Sprintf("copying fleet: %v", err))
+	}
+	return &out
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the FlightCtl Status format.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"apiVersion": "v1alpha1",
+		"kind":       "Status",
+		"code":       status,
+		"message":    message,
+		"reason":     http.StatusText(status),
+		"status":     "Failure",
+	})
+}
@@ -0,0 +1,536 @@
+package fake
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+)
+
+func newTestClient(t *testing.T, s *Server) *flightctl.Client {
+	t.Helper()
+	client, err := s.NewClient()
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func testPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}},
+		},
+	}
+}
+
+func TestPodLifecycle(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddDevice("device-1", "", nil)
+
+	ctx := context.Background()
+	pm := flightctl.NewPodManager(newTestClient(t, s))
+	pod := testPod()
+
+	if err := pm.DeployPod(ctx, pod, "device-1"); err != nil {
+		t.Fatalf("DeployPod: %v", err)
+	}
+	device, _ := s.Device("device-1")
+	if len(device.Spec.Applications) != 1 || device.Spec.Applications[0].Name != "default-web" {
+		t.Fatalf("applications = %+v, want default-web", device.Spec.Applications)
+	}
+	if device.Status == nil || device.Status.Summary == nil || device.Status.Summary.Status != "Online" {
+		t.Errorf("device status was not preserved across the update: %+v", device.Status)
+	}
+
+	status, err := pm.GetPodStatus(ctx, pod, "device-1")
+	if err != nil {
+		t.Fatalf("GetPodStatus: %v", err)
+	}
+	if status.Phase != corev1.PodRunning {
+		t.Errorf("phase = %s, want Running", status.Phase)
+	}
+
+	if err := s.SetDeviceSummary("device-1", "Offline"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pm.GetPodStatus(ctx, pod, "device-1"); !errors.Is(err, flightctl.ErrDeviceOffline) {
+		t.Errorf("GetPodStatus on offline device = %v, want ErrDeviceOffline", err)
+	}
+
+	if err := pm.DeletePod(ctx, pod, "device-1"); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+	device, _ = s.Device("device-1")
+	if len(device.Spec.Applications) != 0 || len(device.Status.Applications) != 0 {
+		t.Errorf("application not removed: spec %+v, status %+v", device.Spec.Applications, device.Status.Applications)
+	}
+}
+
+func TestManualRollout(t *testing.T) {
+	s := NewServer(WithManualRollout())
+	defer s.Close()
+	s.AddDevice("device-1", "", nil)
+
+	ctx := context.Background()
+	pm := flightctl.NewPodManager(newTestClient(t, s))
+	pod := testPod()
+	if err := pm.DeployPod(ctx, pod, "device-1"); err != nil {
+		t.Fatalf("DeployPod: %v", err)
+	}
+
+	status, err := pm.GetPodStatus(ctx, pod, "device-1")
+	if err != nil {
+		t.Fatalf("GetPodStatus: %v", err)
+	}
+	if status.Phase != corev1.PodPending || !hasCondition(status, flightctl.PodRollingOutToDevice) {
+		t.Errorf("status before rollout = %s %+v, want Pending with %s", status.Phase, status.Conditions, flightctl.PodRollingOutToDevice)
+	}
+
+	if err := s.CompleteRollout("device-1"); err != nil {
+		t.Fatal(err)
+	}
+	status, err = pm.GetPodStatus(ctx, pod, "device-1")
+	if err != nil {
+		t.Fatalf("GetPodStatus: %v", err)
+	}
+	if status.Phase != corev1.PodRunning || hasCondition(status, flightctl.PodRollingOutToDevice) {
+		t.Errorf("status after rollout = %s %+v, want Running", status.Phase, status.Conditions)
+	}
+}
+
+func hasCondition(status *corev1.PodStatus, condType corev1.PodConditionType) bool {
+	for _, c := range status.Conditions {
+		if c.Type == condType {
+			return true
+		}
+	}
+	return false
+}
+
+// addSharedDevice adds a device that already runs an application the
+// provider did not deploy.
+func addSharedDevice(s *Server, name, appName string) {
+	s.AddDevice(name, "", nil)
+	device, _ := s.Device(name)
+	device.Spec.Applications = []flightctl.FlightctlApplication{{
+		Name:    appName,
+		AppType: "compose",
+		Inline:  []flightctl.InlineContent{{Path: "docker-compose.yaml", Content: "services: {}\n"}},
+	}}
+	s.PutDevice(*device)
+}
+
+func TestUnmanagedApplicationsArePreserved(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	addSharedDevice(s, "device-1", "fleet-agent")
+
+	ctx := context.Background()
+	pm := flightctl.NewPodManager(newTestClient(t, s))
+	pod := testPod()
+	if err := pm.DeployPod(ctx, pod, "device-1"); err != nil {
+		t.Fatalf("DeployPod: %v", err)
+	}
+	device, _ := s.Device("device-1")
+	if len(device.Spec.Applications) != 2 || device.Spec.Applications[0].Name != "fleet-agent" {
+		t.Fatalf("applications = %+v, want fleet-agent kept before default-web", device.Spec.Applications)
+	}
+	if app := device.Spec.Applications[1]; !app.IsManaged() {
+		t.Errorf("deployed application %s is not tagged as managed: %+v", app.Name, app.EnvVars)
+	}
+
+	if err := pm.DeletePod(ctx, pod, "device-1"); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+	device, _ = s.Device("device-1")
+	if len(device.Spec.Applications) != 1 || device.Spec.Applications[0].Name != "fleet-agent" {
+		t.Errorf("applications = %+v, want only fleet-agent", device.Spec.Applications)
+	}
+}
+
+func TestUnmanagedApplicationWithPodName(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	addSharedDevice(s, "device-1", "default-web")
+
+	ctx := context.Background()
+	pm := flightctl.NewPodManager(newTestClient(t, s))
+	pod := testPod()
+	if err := pm.DeployPod(ctx, pod, "device-1"); !errors.Is(err, flightctl.ErrUnmanagedApplication) {
+		t.Errorf("DeployPod = %v, want ErrUnmanagedApplication", err)
+	}
+	if err := pm.DeletePod(ctx, pod, "device-1"); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+	device, _ := s.Device("device-1")
+	if len(device.Spec.Applications) != 1 || device.Spec.Applications[0].IsManaged() {
+		t.Errorf("applications = %+v, want the unmanaged default-web left in place", device.Spec.Applications)
+	}
+}
+
+func TestConflictingUpdateIsRetried(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddDevice("device-1", "", nil)
+	s.InjectFailure(Failure{Method: http.MethodPut, PathPrefix: "/api/v1/devices/device-1", StatusCode: http.StatusConflict, Count: 1})
+
+	pm := flightctl.NewPodManager(newTestClient(t, s))
+	if err := pm.DeployPod(context.Background(), testPod(), "device-1"); err != nil {
+		t.Fatalf("DeployPod: %v", err)
+	}
+	device, _ := s.Device("device-1")
+	if len(device.Spec.Applications) != 1 {
+		t.Errorf("applications = %+v, want default-web", device.Spec.Applications)
+	}
+}
+
+func TestConcurrentDeploysAreBatched(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	addSharedDevice(s, "device-1", "default-web")
+
+	ctx := context.Background()
+	pm := flightctl.NewPodManager(newTestClient(t, s))
+	names := []string{"web", "api", "db", "cache", "queue", "worker"}
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pod := testPod()
+			pod.Name = name
+			errs[i] = pm.DeployPod(ctx, pod, "device-1")
+		}()
+	}
+	wg.Wait()
+
+	// Only the pod clashing with the unmanaged application fails
+	for i, err := range errs {
+		if names[i] == "web" {
+			if !errors.Is(err, flightctl.ErrUnmanagedApplication) {
+				t.Errorf("DeployPod(web) = %v, want ErrUnmanagedApplication", err)
+			}
+		} else if err != nil {
+			t.Errorf("DeployPod(%s): %v", names[i], err)
+		}
+	}
+	device, _ := s.Device("device-1")
+	if len(device.Spec.Applications) != len(names) {
+		t.Errorf("applications = %d, want %d", len(device.Spec.Applications), len(names))
+	}
+
+	if puts := countRequests(s, http.MethodPut); puts >= len(names)-1 {
+		t.Errorf("PUT requests = %d, want the %d deployments batched into fewer updates", puts, len(names)-1)
+	}
+}
+
+func TestUnchangedPodIsNotRewritten(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddDevice("device-1", "", nil)
+
+	ctx := context.Background()
+	pm := flightctl.NewPodManager(newTestClient(t, s))
+	pod := testPod()
+	if err := pm.DeployPod(ctx, pod, "device-1"); err != nil {
+		t.Fatalf("DeployPod: %v", err)
+	}
+	if err := pm.UpdatePod(ctx, pod.DeepCopy(), "device-1"); err != nil {
+		t.Fatalf("UpdatePod: %v", err)
+	}
+	if puts := countRequests(s, http.MethodPut); puts != 1 {
+		t.Errorf("PUT requests = %d, want 1 (the unchanged update skipped)", puts)
+	}
+
+	updated := pod.DeepCopy()
+	updated.Spec.Containers[0].Image = "nginx:1.26"
+	if err := pm.UpdatePod(ctx, updated, "device-1"); err != nil {
+		t.Fatalf("UpdatePod: %v", err)
+	}
+	if puts := countRequests(s, http.MethodPut); puts != 2 {
+		t.Errorf("PUT requests = %d, want 2 (the changed update written)", puts)
+	}
+}
+
+func TestFlushWaitsForAbandonedUpdates(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddDevice("device-1", "", nil)
+
+	pm := flightctl.NewPodManager(newTestClient(t, s))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// The caller gives up, but the queued change is still written
+	if err := pm.DeployPod(ctx, testPod(), "device-1"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("DeployPod = %v, want context.Canceled", err)
+	}
+
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if err := pm.Flush(flushCtx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if device, _ := s.Device("device-1"); len(device.Spec.Applications) != 1 {
+		t.Errorf("applications = %+v, want default-web written by the flush", device.Spec.Applications)
+	}
+}
+
+func countRequests(s *Server, method string) int {
+	n := 0
+	for _, r := range s.Requests() {
+		if r.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDeployToMissingDevice(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	pm := flightctl.NewPodManager(newTestClient(t, s))
+	if err := pm.DeployPod(context.Background(), testPod(), "missing"); !errors.Is(err, flightctl.ErrNotFound) {
+		t.Errorf("DeployPod = %v, want ErrNotFound", err)
+	}
+}
+
+func TestListDevicesAndFleets(t *testing.T) {
+	s := NewServer(WithPageSize(2))
+	defer s.Close()
+	s.AddFleet("edge", nil)
+	s.AddFleet("lab", nil)
+	s.AddFleet("retail", nil)
+	for _, name := range []string{"d1", "d2", "d3"} {
+		s.AddDevice(name, "edge", map[string]string{"zone": "a"})
+	}
+	s.AddDevice("d4", "lab", map[string]string{"zone": "b"})
+
+	ctx := context.Background()
+	client := newTestClient(t, s)
+
+	devices, err := client.ListDevices(ctx, "edge", nil)
+	if err != nil || len(devices) != 3 {
+		t.Fatalf("ListDevices(edge) = %d devices, %v; want 3", len(devices), err)
+	}
+	devices, err = client.ListDevices(ctx, "", map[string]string{"zone": "b"})
+	if err != nil || len(devices) != 1 || devices[0].ID != "d4" {
+		t.Fatalf("ListDevices(zone=b) = %v, %v; want [d4]", devices, err)
+	}
+
+	fleets, err := client.ListFleets(ctx)
+	if err != nil || len(fleets) != 3 {
+		t.Fatalf("ListFleets = %d fleets, %v; want 3", len(fleets), err)
+	}
+	fleet, err := client.GetFleet(ctx, "edge")
+	if err != nil {
+		t.Fatalf("GetFleet: %v", err)
+	}
+	if fleet.DeviceCount != 3 {
+		t.Errorf("DeviceCount = %d, want 3", fleet.DeviceCount)
+	}
+}
+
+func TestInjectedFailuresAreRetried(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddDevice("device-1", "", nil)
+	s.InjectFailure(Failure{Method: http.MethodGet, PathPrefix: "/api/v1/devices", StatusCode: http.StatusServiceUnavailable, Count: 1})
+	s.InjectFailure(Failure{Method: http.MethodGet, PathPrefix: "/api/v1/devices", Count: 1})
+
+	if _, err := newTestClient(t, s).GetDevice(context.Background(), "device-1"); err != nil {
+		t.Fatalf("GetDevice: %v", err)
+	}
+
+	gets := 0
+	for _, r := range s.Requests() {
+		if r.Method == http.MethodGet {
+			gets++
+		}
+	}
+	if gets != 3 {
+		t.Errorf("GET requests = %d, want 3 (two injected failures and a success)", gets)
+	}
+}
+
+func TestPersistentFailure(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddDevice("device-1", "", nil)
+	s.InjectFailure(Failure{PathPrefix: "/api/v1/devices", StatusCode: http.StatusForbidden})
+
+	client := newTestClient(t, s)
+	if _, err := client.GetDevice(context.Background(), "device-1"); !errors.Is(err, flightctl.ErrUnauthorized) {
+		t.Fatalf("GetDevice = %v, want ErrUnauthorized", err)
+	}
+
+	s.ClearFailures()
+	if _, err := client.GetDevice(context.Background(), "device-1"); err != nil {
+		t.Fatalf("GetDevice after ClearFailures: %v", err)
+	}
+}
+
+func TestRevokedTokenIsRenewed(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddDevice("device-1", "", nil)
+
+	ctx := context.Background()
+	client := newTestClient(t, s)
+	if _, err := client.GetDevice(ctx, "device-1"); err != nil {
+		t.Fatalf("GetDevice: %v", err)
+	}
+
+	s.RevokeTokens()
+	if _, err := client.GetDevice(ctx, "device-1"); err != nil {
+		t.Fatalf("GetDevice after revocation: %v", err)
+	}
+}
+
+func TestUnauthenticatedRequestRejected(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/api/v1/devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	s := NewServer(WithLatency(50*time.Millisecond), WithoutAuth())
+	defer s.Close()
+
+	start := time.Now()
+	resp, err := http.Get(s.URL + "/api/v1/fleets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("response after %s, want at least 50ms", elapsed)
+	}
+}
+
+func TestConsoleStreamsCommandIO(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddDevice("device-1", "", nil)
+
+	var got flightctl.ConsoleMetadata
+	s.HandleConsole(func(device string, metadata flightctl.ConsoleMetadata, stdin io.Reader, stdout, stderr io.Writer, resize <-chan flightctl.TerminalSize) error {
+		got = metadata
+		if _, err := io.Copy(stdout, stdin); err != nil {
+			return err
+		}
+		fmt.Fprint(stderr, "done")
+		return nil
+	})
+	client := newTestClient(t, s)
+
+	var stdout, stderr bytes.Buffer
+	err := client.StreamConsole(context.Background(), "device-1", []string{"cat", "-u"}, flightctl.ConsoleStreams{
+		Stdin:  strings.NewReader("hello device"),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		t.Fatalf("StreamConsole: %v", err)
+	}
+	if stdout.String() != "hello device" || stderr.String() != "done" {
+		t.Errorf("stdout=%q stderr=%q, want the echoed stdin and done", stdout.String(), stderr.String())
+	}
+	if got.Command == nil || got.Command.Command != "cat" || len(got.Command.Args) != 1 || got.TTY {
+		t.Errorf("unexpected console metadata %+v", got)
+	}
+}
+
+func TestConsoleReportsCommandFailure(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddDevice("device-1", "", nil)
+	s.HandleConsole(func(string, flightctl.ConsoleMetadata, io.Reader, io.Writer, io.Writer, <-chan flightctl.TerminalSize) error {
+		return errors.New("command terminated with exit code 2")
+	})
+	client := newTestClient(t, s)
+
+	err := client.StreamConsole(context.Background(), "device-1", []string{"false"}, flightctl.ConsoleStreams{})
+	if err == nil || !strings.Contains(err.Error(), "exit code 2") {
+		t.Errorf("expected the command failure, got %v", err)
+	}
+
+	err = client.StreamConsole(context.Background(), "missing", []string{"true"}, flightctl.ConsoleStreams{})
+	if !errors.Is(err, flightctl.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing device, got %v", err)
+	}
+}
+
+func TestDeviceCacheServesPodStatus(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddDevice("device-1", "", nil)
+
+	cfg := s.ClientConfig()
+	cfg.DeviceCacheTTL = time.Minute
+	client, err := flightctl.NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	pm := flightctl.NewPodManager(client)
+	pod := testPod()
+	if err := pm.DeployPod(ctx, pod, "device-1"); err != nil {
+		t.Fatalf("DeployPod: %v", err)
+	}
+
+	// Repeated status queries within the TTL share one device read
+	gets := countRequests(s, "GET")
+	for range 3 {
+		if status, err := pm.GetPodStatus(ctx, pod, "device-1"); err != nil || status.Phase != corev1.PodRunning {
+			t.Fatalf("GetPodStatus = %v, %v; want Running", status, err)
+		}
+	}
+	if n := countRequests(s, "GET") - gets; n != 1 {
+		t.Errorf("%d device reads for three status queries, want 1", n)
+	}
+
+	// Writing the device drops the cached copy, and updates read the device
+	// afresh rather than from the cache
+	updated := testPod()
+	updated.Spec.Containers[0].Image = "nginx:1.26"
+	if err := pm.UpdatePod(ctx, updated, "device-1"); err != nil {
+		t.Fatalf("UpdatePod: %v", err)
+	}
+	if n := countRequests(s, "PUT"); n != 2 {
+		t.Errorf("%d device writes, want 2 without conflicts", n)
+	}
+	gets = countRequests(s, "GET")
+	if _, err := pm.GetPodStatus(ctx, updated, "device-1"); err != nil {
+		t.Fatalf("GetPodStatus: %v", err)
+	}
+	if n := countRequests(s, "GET") - gets; n != 1 {
+		t.Errorf("%d device reads after an update, want 1", n)
+	}
+}
@@ -0,0 +1,319 @@
+package flightctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/redact"
+)
+
+// A fleet's device template is rendered to its devices by FlightCtl, batch by
+// batch when the fleet has a rollout policy. The fleet controller reports the
+// rollout in these annotations and condition.
+const (
+	// FleetTemplateVersionAnnotation is the fleet's latest template version.
+	FleetTemplateVersionAnnotation = "fleet-controller/templateVersion"
+	// FleetDeployingTemplateVersionAnnotation is the template version being
+	// rolled out.
+	FleetDeployingTemplateVersionAnnotation = "fleet-controller/deployingTemplateVersion"
+	// FleetBatchNumberAnnotation is the batch being rolled out.
+	FleetBatchNumberAnnotation = "fleet-controller/batchNumber"
+	// FleetLastBatchReportAnnotation is the JSON report of the last
+	// completed batch.
+	FleetLastBatchReportAnnotation = "fleet-controller/lastBatchCompletionReport"
+
+	// FleetRolloutInProgress is the fleet condition true while a template
+	// version is rolled out, with reason Active, Waiting or Suspended.
+	FleetRolloutInProgress = "RolloutInProgress"
+
+	// BatchSequenceStrategy rolls a template out to the batches of devices
+	// listed in the policy, then to the remaining devices.
+	BatchSequenceStrategy = "BatchSequence"
+
+	// PodFleetRollout is the pod condition reporting the rollout of a pod
+	// deployed through its fleet's template.
+	PodFleetRollout corev1.PodConditionType = "FleetRollout"
+)
+
+// FlightctlRolloutPolicy controls how changes to a fleet's template are
+// rolled out to its devices.
+type FlightctlRolloutPolicy struct {
+	DeviceSelection      *FlightctlRolloutDeviceSelection `json:"deviceSelection,omitempty"`
+	DisruptionBudget     *FlightctlDisruptionBudget       `json:"disruptionBudget,omitempty"`
+	DefaultUpdateTimeout string                           `json:"defaultUpdateTimeout,omitempty"`
+	// SuccessThreshold is the percentage of a batch's devices that must
+	// update successfully for the rollout to go on, e.g. "90%".
+	SuccessThreshold string `json:"successThreshold,omitempty"`
+}
+
+// FlightctlRolloutDeviceSelection lists the batches of a rollout.
+type FlightctlRolloutDeviceSelection struct {
+	Strategy string                  `json:"strategy"`
+	Sequence []FlightctlRolloutBatch `json:"sequence,omitempty"`
+}
+
+// FlightctlRolloutBatch selects the devices of a rollout batch.
+type FlightctlRolloutBatch struct {
+	Selector *FlightctlLabelSelector `json:"selector,omitempty"`
+	// Limit is a number (2) or percentage ("10%") of devices.
+	Limit            *intstr.IntOrString `json:"limit,omitempty"`
+	SuccessThreshold string              `json:"successThreshold,omitempty"`
+}
+
+// FlightctlDisruptionBudget limits the devices updated at once.
+type FlightctlDisruptionBudget struct {
+	GroupBy        []string `json:"groupBy,omitempty"`
+	MinAvailable   *int     `json:"minAvailable,omitempty"`
+	MaxUnavailable *int     `json:"maxUnavailable,omitempty"`
+}
+
+// FlightctlFleetRolloutState is the rollout section of a fleet's status.
+type FlightctlFleetRolloutState struct {
+	CurrentBatch *int `json:"currentBatch,omitempty"`
+}
+
+// HasBatchRollout reports whether the fleet rolls template changes out in
+// batches.
+func (f *FlightctlFleet) HasBatchRollout() bool {
+	policy := f.Spec.RolloutPolicy
+	return policy != nil && policy.DeviceSelection != nil && policy.DeviceSelection.Strategy == BatchSequenceStrategy
+}
+
+// RolloutState returns the progress of the fleet's template rollout.
+func (f *FlightctlFleet) RolloutState() models.FleetRollout {
+	rollout := models.FleetRollout{
+		TemplateVersion: f.Metadata.Annotations[FleetDeployingTemplateVersionAnnotation],
+		Batch:           -1,
+	}
+	if rollout.TemplateVersion == "" {
+		rollout.TemplateVersion = f.Metadata.Annotations[FleetTemplateVersionAnnotation]
+	}
+	if f.HasBatchRollout() {
+		rollout.Batches = len(f.Spec.RolloutPolicy.DeviceSelection.Sequence)
+	}
+	if batch, err := strconv.Atoi(f.Metadata.Annotations[FleetBatchNumberAnnotation]); err == nil {
+		rollout.Batch = batch
+	} else if f.Status != nil && f.Status.Rollout != nil && f.Status.Rollout.CurrentBatch != nil {
+		rollout.Batch = *f.Status.Rollout.CurrentBatch
+	}
+	if report := f.Metadata.Annotations[FleetLastBatchReportAnnotation]; report != "" {
+		var last models.BatchReport
+		if err := json.Unmarshal([]byte(report), &last); err == nil {
+			rollout.LastBatch = &last
+		}
+	}
+	if f.Status != nil {
+		for _, cond := range f.Status.Conditions {
+			if cond.Type == FleetRolloutInProgress {
+				rollout.InProgress = cond.Status == string(corev1.ConditionTrue)
+				rollout.Reason = cond.Reason
+				rollout.Message = cond.Message
+			}
+		}
+	}
+	return rollout
+}
+
+// GetFleetResource retrieves a Fleet resource by name, with its template and
+// rollout policy.
+func (c *Client) GetFleetResource(ctx context.Context, fleetID string) (_ *FlightctlFleet, err error) {
+	ctx, end := startOperation(ctx, c.timeouts.Read)
+	defer end(&err)
+
+	var fleet FlightctlFleet
+	if err := c.getJSON(ctx, "/api/v1/fleets/"+url.PathEscape(fleetID), nil, &fleet); err != nil {
+		return nil, fmt.Errorf("getting fleet %s: %w", fleetID, err)
+	}
+	return &fleet, nil
+}
+
+// UpdateFleet replaces a Fleet resource (PUT). In dry-run mode the change to
+// its template is logged instead.
+func (c *Client) UpdateFleet(ctx context.Context, fleetID string, fleet *FlightctlFleet) (err error) {
+	ctx, end := startOperation(ctx, c.timeouts.Write)
+	defer end(&err)
+
+	path := "/api/v1/fleets/" + url.PathEscape(fleetID)
+	if c.dryRun != nil {
+		return c.dryRunUpdateFleet(ctx, fleetID, fleet)
+	}
+
+	body, err := json.Marshal(fleet)
+	if err != nil {
+		return fmt.Errorf("marshaling fleet: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating PUT request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("PUT request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		logger.FromContext(ctx).Error("update fleet failed with status %d: %s", resp.StatusCode, redact.Text(string(bodyBytes)))
+		return newHTTPError("PUT", path, resp.StatusCode, bodyBytes)
+	}
+
+	logger.FromContext(ctx).Info("Successfully updated fleet %s", fleetID)
+	return nil
+}
+
+// dryRunUpdateFleet logs the diff of the applications of a fleet template
+// update instead of writing it. Unlike device updates, the written template
+// is not kept: the fleet is read as it is in FlightCtl.
+func (c *Client) dryRunUpdateFleet(ctx context.Context, fleetID string, fleet *FlightctlFleet) error {
+	current, err := c.GetFleetResource(ctx, fleetID)
+	if err != nil {
+		return fmt.Errorf("reading fleet for the dry run: %w", err)
+	}
+	before, err := fleetDryRunView(current)
+	if err != nil {
+		return err
+	}
+	after, err := fleetDryRunView(fleet)
+	if err != nil {
+		return err
+	}
+	log := logger.FromContext(ctx).With("fleet", fleetID)
+	if diff := cmp.Diff(before, after); diff != "" {
+		log.Info("Dry run: not updating the template of fleet %s (-current +would-be):\n%s", fleetID, diff)
+	} else {
+		log.Info("Dry run: not updating the template of fleet %s, which is unchanged", fleetID)
+	}
+	return nil
+}
+
+// fleetDryRunView returns the applications of a fleet's template, with
+// secrets masked, as generic JSON values to diff.
+func fleetDryRunView(fleet *FlightctlFleet) (interface{}, error) {
+	var apps []FlightctlApplication
+	if fleet.Spec.Template != nil {
+		for _, app := range fleet.Spec.Template.Spec.Applications {
+			apps = append(apps, redactedApplication(app, nil))
+		}
+	}
+	data, err := json.Marshal(apps)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling fleet for the dry run: %w", err)
+	}
+	var view interface{}
+	if err := json.Unmarshal(data, &view); err != nil {
+		return nil, fmt.Errorf("decoding fleet for the dry run: %w", err)
+	}
+	return view, nil
+}
+
+// SetFleets sets the fleet API used to deploy pods through fleet templates.
+func (pm *PodManager) SetFleets(fleets FleetManager) {
+	pm.fleets = fleets
+}
+
+// DeployPodToFleet adds the pod's application to the fleet's device
+// template, replacing the one deployed before, so FlightCtl rolls it out to
+// the fleet's devices under the fleet's rollout policy. Like on devices,
+// applications the provider does not manage are left untouched.
+func (pm *PodManager) DeployPodToFleet(ctx context.Context, pod *corev1.Pod, fleetID string) error {
+	log := logger.FromContext(ctx).With("fleet", fleetID)
+	newApp, err := pm.podToFlightctlApplication(pod)
+	if err != nil {
+		return err
+	}
+
+	err = pm.updateFleetTemplate(ctx, fleetID, func(spec *FlightctlDeviceSpec) (bool, error) {
+		apps := spec.Applications
+		i := slices.IndexFunc(apps, func(app FlightctlApplication) bool { return app.Name == newApp.Name })
+		switch {
+		case i < 0:
+			spec.Applications = append(apps, newApp)
+		case !apps[i].IsManaged():
+			return false, fmt.Errorf("application %s in the template of fleet %s: %w", newApp.Name, fleetID, ErrUnmanagedApplication)
+		case newApp.ContentHash() != "" && apps[i].ContentHash() == newApp.ContentHash() && apps[i].runsPod(pod):
+			log.Info("Application %s in the template of fleet %s is unchanged (hash %s), skipping update", newApp.Name, fleetID, newApp.ContentHash())
+			return false, nil
+		default:
+			apps[i] = newApp
+		}
+		log.Info("Rolling out application %s to fleet %s", newApp.Name, fleetID)
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	if pm.translated != nil {
+		pm.translated(ctx, pod, "", newApp)
+	}
+	return nil
+}
+
+// DeletePodFromFleet removes the pod's application from the fleet's device
+// template. It is idempotent.
+func (pm *PodManager) DeletePodFromFleet(ctx context.Context, pod *corev1.Pod, fleetID string) error {
+	log := logger.FromContext(ctx).With("fleet", fleetID)
+	appName := pm.naming.ApplicationName(pod)
+	return pm.updateFleetTemplate(ctx, fleetID, func(spec *FlightctlDeviceSpec) (bool, error) {
+		i := slices.IndexFunc(spec.Applications, func(app FlightctlApplication) bool { return app.Name == appName })
+		switch {
+		case i < 0:
+			log.Info("Application %s not in the template of fleet %s (already deleted)", appName, fleetID)
+			return false, nil
+		case !spec.Applications[i].IsManaged():
+			log.Warn("Application %s in the template of fleet %s is not managed by the provider, leaving it in place", appName, fleetID)
+			return false, nil
+		case !spec.Applications[i].runsPod(pod):
+			log.Info("Application %s in the template of fleet %s runs pod UID %s rather than the deleted %s, leaving it in place", appName, fleetID, spec.Applications[i].PodUID(), pod.UID)
+			return false, nil
+		}
+		spec.Applications = slices.Delete(spec.Applications, i, i+1)
+		log.Info("Removing application %s from the template of fleet %s", appName, fleetID)
+		return true, nil
+	})
+}
+
+// updateFleetTemplate fetches a fleet, lets modify change its template's
+// device spec and writes the fleet back if modify returns true, retrying
+// from a fresh read on conflicts like device updates.
+func (pm *PodManager) updateFleetTemplate(ctx context.Context, fleetID string, modify func(spec *FlightctlDeviceSpec) (bool, error)) error {
+	if pm.fleets == nil {
+		return fmt.Errorf("updating the template of fleet %s: no fleet API", fleetID)
+	}
+	for attempt := 1; ; attempt++ {
+		fleet, err := pm.fleets.GetFleetResource(ctx, fleetID)
+		if err != nil {
+			return err
+		}
+		if fleet.Spec.Template == nil {
+			fleet.Spec.Template = &FlightctlFleetTemplate{}
+		}
+		changed, err := modify(&fleet.Spec.Template.Spec)
+		if err != nil || !changed {
+			return err
+		}
+		fleet.Status = nil
+
+		err = pm.fleets.UpdateFleet(ctx, fleetID, fleet)
+		if !errors.Is(err, ErrConflict) || attempt == deviceUpdateAttempts {
+			return err
+		}
+		logger.FromContext(ctx).Info("Fleet %s changed concurrently, retrying update (attempt %d/%d)", fleetID, attempt+1, deviceUpdateAttempts)
+	}
+}
@@ -0,0 +1,151 @@
+package flightctl
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// ListFleets retrieves all fleets, including their device counts.
+func (c *Client) ListFleets(ctx context.Context) (_ []*models.Fleet, err error) {
+	ctx, end := startOperation(ctx, c.timeouts.List)
+	defer end(&err)
+
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(listPageSize))
+	query.Set("addDevicesSummary", "true")
+
+	var fleets []*models.Fleet
+	for {
+		var page FlightctlFleetList
+		if err := c.getJSON(ctx, "/api/v1/fleets", query, &page); err != nil {
+			return nil, fmt.Errorf("listing fleets: %w", err)
+		}
+
+		for i := range page.Items {
+			fleets = append(fleets, page.Items[i].ToModel())
+		}
+
+		if page.Metadata.Continue == "" {
+			break
+		}
+		query.Set("continue", page.Metadata.Continue)
+	}
+
+	logger.Debug("Listed %d fleets", len(fleets))
+	return fleets, nil
+}
+
+// GetFleet retrieves a specific fleet by name.
+func (c *Client) GetFleet(ctx context.Context, fleetID string) (_ *models.Fleet, err error) {
+	ctx, end := startOperation(ctx, c.timeouts.Read)
+	defer end(&err)
+
+	query := url.Values{}
+	query.Set("addDevicesSummary", "true")
+
+	var fleet FlightctlFleet
+	if err := c.getJSON(ctx, "/api/v1/fleets/"+url.PathEscape(fleetID), query, &fleet); err != nil {
+		return nil, fmt.Errorf("getting fleet %s: %w", fleetID, err)
+	}
+
+	return fleet.ToModel(), nil
+}
+
+// ToModel converts a FlightCtl Fleet resource to the provider's fleet model.
+func (f *FlightctlFleet) ToModel() *models.Fleet {
+	fleet := &models.Fleet{
+		ID:     f.Metadata.Name,
+		Name:   f.Metadata.Name,
+		Labels: f.Metadata.Labels,
+	}
+	if f.Metadata.CreationTimestamp != nil {
+		fleet.CreatedAt = *f.Metadata.CreationTimestamp
+		fleet.UpdatedAt = *f.Metadata.CreationTimestamp
+	}
+	if f.Status != nil && f.Status.DevicesSummary != nil {
+		fleet.DeviceCount = int(f.Status.DevicesSummary.Total)
+	}
+	return fleet
+}
+
+// FlightctlFleet represents a Fleet resource in Flightctl API format.
+type FlightctlFleet struct {
+	APIVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Metadata   FlightctlFleetMetadata `json:"metadata"`
+	Spec       FlightctlFleetSpec     `json:"spec"`
+	Status     *FlightctlFleetStatus  `json:"status,omitempty"`
+}
+
+// FlightctlFleetMetadata represents the metadata section of a Fleet.
+type FlightctlFleetMetadata struct {
+	Name              string            `json:"name"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	CreationTimestamp *time.Time        `json:"creationTimestamp,omitempty"`
+	// ResourceVersion makes an update fail with a conflict if the fleet
+	// changed since it was read.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+
+	unknown unknownFields
+}
+
+// FlightctlFleetSpec represents the spec section of a Fleet.
+type FlightctlFleetSpec struct {
+	Selector      *FlightctlLabelSelector `json:"selector,omitempty"`
+	Template      *FlightctlFleetTemplate `json:"template,omitempty"`
+	RolloutPolicy *FlightctlRolloutPolicy `json:"rolloutPolicy,omitempty"`
+
+	unknown unknownFields
+}
+
+// FlightctlFleetTemplate is the device spec FlightCtl renders to every
+// device of a fleet.
+type FlightctlFleetTemplate struct {
+	Metadata *FlightctlFleetTemplateMetadata `json:"metadata,omitempty"`
+	Spec     FlightctlDeviceSpec             `json:"spec"`
+}
+
+// FlightctlFleetTemplateMetadata holds the labels of a fleet template.
+type FlightctlFleetTemplateMetadata struct {
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// FlightctlLabelSelector selects resources by label.
+type FlightctlLabelSelector struct {
+	MatchLabels      map[string]string                   `json:"matchLabels,omitempty"`
+	MatchExpressions []FlightctlLabelSelectorRequirement `json:"matchExpressions,omitempty"`
+}
+
+// FlightctlLabelSelectorRequirement is a set-based label selector term.
+type FlightctlLabelSelectorRequirement struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values,omitempty"`
+}
+
+// FlightctlFleetStatus represents the status section of a Fleet.
+type FlightctlFleetStatus struct {
+	Conditions     []FlightctlCondition        `json:"conditions,omitempty"`
+	Rollout        *FlightctlFleetRolloutState `json:"rollout,omitempty"`
+	DevicesSummary *FlightctlDevicesSummary    `json:"devicesSummary,omitempty"`
+}
+
+// FlightctlDevicesSummary summarises the devices that belong to a fleet.
+type FlightctlDevicesSummary struct {
+	Total int64 `json:"total"`
+}
+
+// FlightctlFleetList represents a page of fleets returned by the list API.
+type FlightctlFleetList struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   FlightctlListMeta `json:"metadata"`
+	Items      []FlightctlFleet  `json:"items"`
+}
@@ -0,0 +1,116 @@
+package flightctl
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Lifecycle hooks have no counterpart in compose or quadlet applications.
+// Containers with exec or sleep hooks are instead started through a wrapper
+// script, delivered as an inline file next to the application and mounted
+// into the container, which runs the container's command and its hooks: the
+// postStart hook once the command started, and the preStop hook when the
+// container is stopped, before the command is sent SIGTERM. The wrapper
+// needs a shell in the image and the container's command in the pod spec,
+// since the image's entrypoint is not known.
+
+// hooksMountDir is where the wrappers are mounted in containers.
+const hooksMountDir = "/vk-hooks"
+
+// hookCommand returns the shell command running a hook handler, or false
+// for handlers the wrapper cannot run.
+func hookCommand(handler *corev1.LifecycleHandler) (string, bool) {
+	switch {
+	case handler == nil:
+		return "", false
+	case handler.Exec != nil && len(handler.Exec.Command) > 0:
+		return shellJoin(handler.Exec.Command), true
+	case handler.Sleep != nil:
+		return fmt.Sprintf("sleep %d", handler.Sleep.Seconds), true
+	default:
+		return "", false
+	}
+}
+
+// wrapsHooks reports whether a container is started through a hook wrapper.
+func wrapsHooks(container corev1.Container) bool {
+	if container.Lifecycle == nil || len(container.Command) == 0 {
+		return false
+	}
+	_, postStart := hookCommand(container.Lifecycle.PostStart)
+	_, preStop := hookCommand(container.Lifecycle.PreStop)
+	return postStart || preStop
+}
+
+// hookWrapperFile returns the name of a container's wrapper file in the
+// application, prefixed with prefix.
+func hookWrapperFile(prefix string, container corev1.Container) string {
+	return fmt.Sprintf("%s%s-hooks.sh", prefix, sanitizeServiceName(container.Name))
+}
+
+// hookWrapperPath returns where a container's wrapper is mounted.
+func hookWrapperPath(container corev1.Container) string {
+	return fmt.Sprintf("%s/%s.sh", hooksMountDir, sanitizeServiceName(container.Name))
+}
+
+// hookWrappedCommand returns the entrypoint and arguments a container is
+// started with: the wrapper running its command, or its own.
+func hookWrappedCommand(container corev1.Container) (entrypoint, args []string) {
+	if !wrapsHooks(container) {
+		return container.Command, container.Args
+	}
+	args = append(append([]string{}, container.Command...), container.Args...)
+	return []string{"/bin/sh", hookWrapperPath(container)}, args
+}
+
+// hookWrapperFiles returns the wrapper files of the containers of a pod that
+// have hooks, named with hookWrapperFile and prefix.
+func hookWrapperFiles(pod *corev1.Pod, prefix string) []InlineContent {
+	var files []InlineContent
+	for _, container := range podContainers(pod) {
+		if wrapsHooks(container) {
+			files = append(files, InlineContent{
+				Path:    hookWrapperFile(prefix, container),
+				Content: hookWrapper(container),
+			})
+		}
+	}
+	return files
+}
+
+// hookWrapper renders the wrapper script of a container. A failed postStart
+// hook stops the container, as Kubernetes kills it.
+func hookWrapper(container corev1.Container) string {
+	postStart, hasPostStart := hookCommand(container.Lifecycle.PostStart)
+	preStop, hasPreStop := hookCommand(container.Lifecycle.PreStop)
+
+	var script strings.Builder
+	script.WriteString("#!/bin/sh\n")
+	script.WriteString(fmt.Sprintf("# Lifecycle hooks of container %s\n", container.Name))
+	script.WriteString("\"$@\" &\n")
+	script.WriteString("pid=$!\n")
+	script.WriteString("stop() {\n")
+	if hasPreStop {
+		script.WriteString(fmt.Sprintf("  %s || echo \"preStop hook failed\" >&2\n", preStop))
+	}
+	script.WriteString("  kill -TERM \"$pid\" 2>/dev/null\n")
+	script.WriteString("}\n")
+	script.WriteString("trap stop TERM INT\n")
+	if hasPostStart {
+		script.WriteString(fmt.Sprintf("if ! %s; then\n", postStart))
+		script.WriteString("  echo \"postStart hook failed\" >&2\n")
+		script.WriteString("  kill -TERM \"$pid\" 2>/dev/null\n")
+		script.WriteString("fi\n")
+	}
+	// wait returns early when a trapped signal arrives
+	script.WriteString("wait \"$pid\"\n")
+	script.WriteString("status=$?\n")
+	script.WriteString("while kill -0 \"$pid\" 2>/dev/null; do\n")
+	script.WriteString("  wait \"$pid\"\n")
+	script.WriteString("  status=$?\n")
+	script.WriteString("done\n")
+	script.WriteString("exit \"$status\"\n")
+	return script.String()
+}
@@ -0,0 +1,156 @@
+package flightctl
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func hooksTestPod() *corev1.Pod {
+	pod := statusTestPod()
+	pod.Spec.Containers[0].Command = []string{"nginx"}
+	pod.Spec.Containers[0].Args = []string{"-g", "daemon off;"}
+	pod.Spec.Containers[0].Lifecycle = &corev1.Lifecycle{
+		PostStart: &corev1.LifecycleHandler{Exec: &corev1.ExecAction{Command: []string{"sh", "-c", "echo it's up > /tmp/ready"}}},
+		PreStop:   &corev1.LifecycleHandler{Sleep: &corev1.SleepAction{Seconds: 5}},
+	}
+	return pod
+}
+
+func TestComposeHooks(t *testing.T) {
+	files, _, err := composeTranslator{}.Translate(hooksTestPod())
+	if err != nil {
+		t.Fatalf("Translate: %v", err)
+	}
+	if len(files) != 2 || files[1].Path != "nginx-hooks.sh" {
+		t.Fatalf("files = %+v, want the compose file and nginx-hooks.sh", files)
+	}
+	for _, want := range []string{
+		"    entrypoint:\n      - /bin/sh\n      - /vk-hooks/nginx.sh\n",
+		"    command:\n      - nginx\n      - -g\n      - daemon off;\n",
+		"    volumes:\n      - ./nginx-hooks.sh:/vk-hooks/nginx.sh:ro\n",
+	} {
+		if !strings.Contains(files[0].Content, want) {
+			t.Errorf("compose lacks %q:\n%s", want, files[0].Content)
+		}
+	}
+	for _, want := range []string{
+		`if ! 'sh' '-c' 'echo it'\''s up > /tmp/ready'; then`,
+		`  sleep 5 || echo "preStop hook failed" >&2`,
+	} {
+		if !strings.Contains(files[1].Content, want) {
+			t.Errorf("wrapper lacks %q:\n%s", want, files[1].Content)
+		}
+	}
+	if features := UnsupportedFeatures(hooksTestPod()); len(features) != 0 {
+		t.Errorf("features = %v, want none", features)
+	}
+}
+
+func TestQuadletHooks(t *testing.T) {
+	units := convertPodToQuadlet(hooksTestPod(), "default-web")
+	var container string
+	var wrapper bool
+	for _, unit := range units {
+		switch unit.Path {
+		case "default-web-nginx.container":
+			container = unit.Content
+		case "default-web-nginx-hooks.sh":
+			wrapper = true
+		}
+	}
+	if !wrapper {
+		t.Errorf("units = %+v, want default-web-nginx-hooks.sh", units)
+	}
+	for _, want := range []string{
+		"Entrypoint=/bin/sh\n",
+		"Exec=/vk-hooks/nginx.sh nginx -g \"daemon off;\"\n",
+		"Volume=./default-web-nginx-hooks.sh:/vk-hooks/nginx.sh:ro\n",
+	} {
+		if !strings.Contains(container, want) {
+			t.Errorf("container unit lacks %q:\n%s", want, container)
+		}
+	}
+}
+
+func TestUnrunnableHooks(t *testing.T) {
+	pod := hooksTestPod()
+	pod.Spec.Containers[0].Lifecycle.PreStop = &corev1.LifecycleHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/quit", Port: intstr.FromInt(80)}}
+	pod.Spec.Containers[1].Lifecycle = pod.Spec.Containers[0].Lifecycle
+	features := UnsupportedFeatures(pod)
+	var fields []string
+	for _, feature := range features {
+		fields = append(fields, feature.Field)
+	}
+	want := []string{
+		"spec.containers[nginx].lifecycle.preStop",
+		"spec.containers[sidecar].lifecycle.preStop",
+		"spec.containers[sidecar].lifecycle",
+	}
+	if strings.Join(fields, ",") != strings.Join(want, ",") {
+		t.Errorf("features = %v, want %v", fields, want)
+	}
+
+	// Containers without a command keep the image's entrypoint
+	files, _, err := composeTranslator{}.Translate(pod)
+	if err != nil {
+		t.Fatalf("Translate: %v", err)
+	}
+	if len(files) != 2 || strings.Contains(files[0].Content, "/vk-hooks/sidecar.sh") {
+		t.Errorf("files = %+v, want only the nginx container wrapped", files)
+	}
+}
+
+func TestHookWrapperRunsHooks(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell")
+	}
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	container := corev1.Container{
+		Name:    "app",
+		Command: []string{"sleep", "30"},
+		Lifecycle: &corev1.Lifecycle{
+			PostStart: &corev1.LifecycleHandler{Exec: &corev1.ExecAction{Command: []string{"sh", "-c", "echo started >> " + log}}},
+			PreStop:   &corev1.LifecycleHandler{Exec: &corev1.ExecAction{Command: []string{"sh", "-c", "echo stopping >> " + log}}},
+		},
+	}
+	script := filepath.Join(dir, "hooks.sh")
+	if err := os.WriteFile(script, []byte(hookWrapper(container)), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("sh", append([]string{script}, container.Command...)...)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, _ := os.ReadFile(log); string(data) == "started\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			_ = cmd.Process.Kill()
+			t.Fatal("postStart hook did not run")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	_ = cmd.Process.Signal(os.Interrupt)
+	done := make(chan struct{})
+	go func() { _ = cmd.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		_ = cmd.Process.Kill()
+		t.Fatal("wrapper did not stop the command")
+	}
+	if data, _ := os.ReadFile(log); string(data) != "started\nstopping\n" {
+		t.Errorf("log = %q, want the postStart and preStop hooks", data)
+	}
+}
@@ -0,0 +1,74 @@
+package flightctl
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// AppTypeContainer deploys a single-container pod as a FlightCtl container
+// application, which runs the container image as is instead of generated
+// compose or quadlet content. It is selected with the app-type annotation
+// and only accepts pods without settings the image would have to be
+// configured with.
+const AppTypeContainer = "container"
+
+// isContainerAppPod reports whether a pod asks to be deployed as a container
+// application.
+func isContainerAppPod(pod *corev1.Pod) bool {
+	return strings.EqualFold(pod.Annotations[AppTypeAnnotation], AppTypeContainer)
+}
+
+// containerAppImage returns the image a container application runs for the
+// pod, or an error naming the settings that cannot be applied to it.
+func containerAppImage(pod *corev1.Pod) (string, error) {
+	if len(pod.Spec.Containers) != 1 {
+		return "", fmt.Errorf("pod %s/%s has %d containers; %s applications run a single one",
+			pod.Namespace, pod.Name, len(pod.Spec.Containers), AppTypeContainer)
+	}
+	container := pod.Spec.Containers[0]
+
+	var extra []string
+	check := func(set bool, field string) {
+		if set {
+			extra = append(extra, field)
+		}
+	}
+	check(len(pod.Spec.InitContainers) > 0, "initContainers")
+	check(pod.Spec.HostNetwork, "hostNetwork")
+	check(pod.Spec.DNSConfig != nil, "dnsConfig")
+	check(len(pod.Spec.HostAliases) > 0, "hostAliases")
+	check(len(container.Command) > 0, "command")
+	check(len(container.Args) > 0, "args")
+	check(len(container.Env) > 0, "env")
+	check(len(container.EnvFrom) > 0, "envFrom")
+	check(len(container.Ports) > 0, "ports")
+	check(len(container.VolumeMounts) > 0 && !onlyServiceAccountMounts(container.VolumeMounts), "volumeMounts")
+	check(container.SecurityContext != nil, "securityContext")
+	check(container.WorkingDir != "", "workingDir")
+	check(container.TTY || container.Stdin, "tty/stdin")
+	check(container.Lifecycle != nil, "lifecycle")
+	check(len(models.ExtendedRequests(container)) > 0, "extended resources")
+	if len(extra) > 0 {
+		return "", fmt.Errorf("pod %s/%s sets %s; %s applications run the image without configuration",
+			pod.Namespace, pod.Name, strings.Join(extra, ", "), AppTypeContainer)
+	}
+	if container.Image == "" {
+		return "", fmt.Errorf("pod %s/%s has no container image", pod.Namespace, pod.Name)
+	}
+	return container.Image, nil
+}
+
+// onlyServiceAccountMounts reports whether all mounts are of the service
+// account token volume Kubernetes adds to every pod.
+func onlyServiceAccountMounts(mounts []corev1.VolumeMount) bool {
+	for _, mount := range mounts {
+		if !strings.HasPrefix(mount.Name, serviceAccountVolumePrefix) {
+			return false
+		}
+	}
+	return true
+}
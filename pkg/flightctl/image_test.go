@@ -0,0 +1,46 @@
+package flightctl
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestContainerAppRunsImageDirectly(t *testing.T) {
+	pm := NewPodManager(nil)
+	pod := statusTestPod()
+	pod.Spec.Containers = pod.Spec.Containers[:1]
+	pod.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{{Name: "kube-api-access-x7k2p", MountPath: "/var/run/secrets/kubernetes.io/serviceaccount"}}
+	pod.Annotations = map[string]string{AppTypeAnnotation: AppTypeContainer}
+
+	app, err := pm.podToFlightctlApplication(pod)
+	if err != nil {
+		t.Fatalf("podToFlightctlApplication: %v", err)
+	}
+	if app.AppType != AppTypeContainer || app.Image != "nginx:1.25" || len(app.Inline) != 0 || !app.IsManaged() {
+		t.Errorf("application = %+v, want a managed container application running nginx:1.25", app)
+	}
+
+	pod.Spec.Containers[0].Image = "nginx:1.26"
+	if next, _ := pm.podToFlightctlApplication(pod); next.ContentHash() == app.ContentHash() {
+		t.Error("content hash unchanged after an image change")
+	}
+}
+
+func TestContainerAppRejectsConfiguredPods(t *testing.T) {
+	pm := NewPodManager(nil)
+
+	pod := statusTestPod()
+	pod.Annotations = map[string]string{AppTypeAnnotation: AppTypeContainer}
+	if _, err := pm.podToFlightctlApplication(pod); err == nil || !strings.Contains(err.Error(), "2 containers") {
+		t.Errorf("error = %v, want multiple containers rejected", err)
+	}
+
+	pod.Spec.Containers = pod.Spec.Containers[:1]
+	pod.Spec.Containers[0].Args = []string{"-g", "daemon off;"}
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "MODE", Value: "edge"}}
+	if _, err := pm.podToFlightctlApplication(pod); err == nil || !strings.Contains(err.Error(), "args, env") {
+		t.Errorf("error = %v, want args and env named", err)
+	}
+}
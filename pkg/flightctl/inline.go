@@ -0,0 +1,136 @@
+package flightctl
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Compose applications are written to DefaultComposeFile unless the
+// provider or the pod names another compose file. Pods can add files of
+// their own next to the translated ones, such as a .env file, configuration
+// files or entrypoint scripts, with binary content base64-encoded.
+const (
+	// ComposeFileAnnotation names the compose file of a pod's application,
+	// e.g. docker-compose.yaml.
+	ComposeFileAnnotation = "flightctl.io/compose-file"
+	// InlineFilesAnnotation holds a JSON list of files added to a pod's
+	// application, each with a path, content and optional contentEncoding:
+	// [{"path": ".env", "content": "LEVEL=debug"}].
+	InlineFilesAnnotation = "flightctl.io/inline-files"
+
+	// DefaultComposeFile is the compose file of applications by default.
+	DefaultComposeFile = "podman-compose.yaml"
+)
+
+// Encodings of inline content. Content without an encoding is plain text.
+const (
+	ContentEncodingPlain  = "plain"
+	ContentEncodingBase64 = "base64"
+)
+
+// IsBase64 reports whether the content is base64-encoded.
+func (c InlineContent) IsBase64() bool {
+	return c.ContentEncoding == ContentEncodingBase64
+}
+
+// Data returns the content as written to the device, decoding base64.
+func (c InlineContent) Data() ([]byte, error) {
+	if c.IsBase64() {
+		return base64.StdEncoding.DecodeString(c.Content)
+	}
+	return []byte(c.Content), nil
+}
+
+// ValidateComposeFile checks a compose file name: a YAML file at the root
+// of the application, where the device looks for it.
+func ValidateComposeFile(name string) error {
+	if name == "" || strings.Contains(name, "/") || name == "." || name == ".." {
+		return fmt.Errorf("compose file %q must be a file name", name)
+	}
+	if ext := path.Ext(name); ext != ".yaml" && ext != ".yml" {
+		return fmt.Errorf("compose file %q must end in .yaml or .yml", name)
+	}
+	return nil
+}
+
+// composeFile returns the compose file of a pod's application: the one its
+// annotation names, else defaultFile.
+func composeFile(pod *corev1.Pod, defaultFile string) (string, error) {
+	name := strings.TrimSpace(pod.Annotations[ComposeFileAnnotation])
+	if name == "" {
+		return defaultFile, nil
+	}
+	if err := ValidateComposeFile(name); err != nil {
+		return "", fmt.Errorf("pod %s/%s: invalid %s: %w", pod.Namespace, pod.Name, ComposeFileAnnotation, err)
+	}
+	return name, nil
+}
+
+// validInlinePath checks the path of an inline file: relative to the
+// application and within it.
+func validInlinePath(p string) error {
+	switch {
+	case p == "":
+		return fmt.Errorf("path is empty")
+	case path.IsAbs(p):
+		return fmt.Errorf("path %q must be relative to the application", p)
+	case path.Clean(p) != p || p == "." || p == ".." || strings.HasPrefix(p, "../"):
+		return fmt.Errorf("path %q must be a clean path within the application", p)
+	}
+	return nil
+}
+
+// inlineFiles returns the files a pod adds to its application.
+func inlineFiles(pod *corev1.Pod) ([]InlineContent, error) {
+	value := strings.TrimSpace(pod.Annotations[InlineFilesAnnotation])
+	if value == "" {
+		return nil, nil
+	}
+	var files []InlineContent
+	if err := json.Unmarshal([]byte(value), &files); err != nil {
+		return nil, fmt.Errorf("pod %s/%s: invalid %s: %w", pod.Namespace, pod.Name, InlineFilesAnnotation, err)
+	}
+	var paths []string
+	for i := range files {
+		file := &files[i]
+		if err := validInlinePath(file.Path); err != nil {
+			return nil, fmt.Errorf("pod %s/%s: file %d of %s: %w", pod.Namespace, pod.Name, i, InlineFilesAnnotation, err)
+		}
+		if slices.Contains(paths, file.Path) {
+			return nil, fmt.Errorf("pod %s/%s: file %s is listed twice in %s", pod.Namespace, pod.Name, file.Path, InlineFilesAnnotation)
+		}
+		paths = append(paths, file.Path)
+		switch file.ContentEncoding {
+		case "", ContentEncodingPlain:
+		case ContentEncodingBase64:
+			if _, err := file.Data(); err != nil {
+				return nil, fmt.Errorf("pod %s/%s: file %s of %s is not valid base64: %w", pod.Namespace, pod.Name, file.Path, InlineFilesAnnotation, err)
+			}
+		default:
+			return nil, fmt.Errorf("pod %s/%s: file %s of %s has unknown content encoding %q (expected %s or %s)",
+				pod.Namespace, pod.Name, file.Path, InlineFilesAnnotation, file.ContentEncoding, ContentEncodingPlain, ContentEncodingBase64)
+		}
+	}
+	return files, nil
+}
+
+// withInlineFiles adds the files a pod adds to its application to the
+// translated ones. A file cannot replace a translated one.
+func withInlineFiles(pod *corev1.Pod, translated []InlineContent) ([]InlineContent, error) {
+	files, err := inlineFiles(pod)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if slices.ContainsFunc(translated, func(c InlineContent) bool { return c.Path == file.Path }) {
+			return nil, fmt.Errorf("pod %s/%s: file %s of %s would replace a translated file", pod.Namespace, pod.Name, file.Path, InlineFilesAnnotation)
+		}
+	}
+	return append(translated, files...), nil
+}
@@ -0,0 +1,83 @@
+package flightctl
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func inlinePod(annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: annotations},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.21"}}},
+	}
+}
+
+func TestComposeFile(t *testing.T) {
+	registry := NewTranslatorRegistry("")
+	registry.Register(AppTypeCompose, NewComposeTranslator("docker-compose.yml"))
+	pm := NewPodManagerWithTranslators(nil, registry)
+
+	app, err := pm.podToFlightctlApplication(inlinePod(nil))
+	if err != nil {
+		t.Fatalf("podToFlightctlApplication: %v", err)
+	}
+	if app.Inline[0].Path != "docker-compose.yml" {
+		t.Errorf("compose file = %s, want the registered docker-compose.yml", app.Inline[0].Path)
+	}
+
+	app, err = pm.podToFlightctlApplication(inlinePod(map[string]string{ComposeFileAnnotation: "compose.yaml"}))
+	if err != nil {
+		t.Fatalf("podToFlightctlApplication: %v", err)
+	}
+	if app.Inline[0].Path != "compose.yaml" {
+		t.Errorf("compose file = %s, want the pod's compose.yaml", app.Inline[0].Path)
+	}
+
+	for _, name := range []string{"deploy/compose.yaml", "compose.json", ".."} {
+		if err := ValidateComposeFile(name); err == nil {
+			t.Errorf("ValidateComposeFile(%q) succeeded, want an error", name)
+		}
+	}
+}
+
+func TestInlineFiles(t *testing.T) {
+	pm := NewPodManager(nil)
+	pod := inlinePod(map[string]string{InlineFilesAnnotation: `[
+		{"path": ".env", "content": "LEVEL=debug\n"},
+		{"path": "config/logo.png", "content": "iVBORw0KGgo=", "contentEncoding": "base64"}
+	]`})
+
+	app, err := pm.podToFlightctlApplication(pod)
+	if err != nil {
+		t.Fatalf("podToFlightctlApplication: %v", err)
+	}
+	if len(app.Inline) != 3 || app.Inline[1].Path != ".env" || app.Inline[2].Path != "config/logo.png" {
+		t.Fatalf("inline files = %+v, want the compose file, .env and config/logo.png", app.Inline)
+	}
+	data, err := app.Inline[2].Data()
+	if err != nil || string(data) != "\x89PNG\r\n\x1a\n" {
+		t.Errorf("logo data = %q (%v), want the decoded PNG signature", data, err)
+	}
+	if redacted := redactedInline(app.Inline, nil); redacted[2].Content == app.Inline[2].Content || !redacted[2].IsBase64() {
+		t.Errorf("redacted logo = %+v, want base64 content masked", redacted[2])
+	}
+
+	for _, files := range []string{
+		`[{"path": "/etc/passwd", "content": "x"}]`,
+		`[{"path": "../escape", "content": "x"}]`,
+		`[{"path": "a", "content": "x"}, {"path": "a", "content": "y"}]`,
+		`[{"path": "bin", "content": "not base64!", "contentEncoding": "base64"}]`,
+		`[{"path": "gz", "content": "x", "contentEncoding": "gzip"}]`,
+		`{"path": "a"}`,
+	} {
+		if err := ValidatePodAnnotations(inlinePod(map[string]string{InlineFilesAnnotation: files})); err == nil {
+			t.Errorf("ValidatePodAnnotations with files %s succeeded, want an error", files)
+		}
+	}
+	replacing := inlinePod(map[string]string{InlineFilesAnnotation: `[{"path": "podman-compose.yaml", "content": "x"}]`})
+	if _, err := pm.podToFlightctlApplication(replacing); err == nil {
+		t.Error("podToFlightctlApplication with a file replacing the compose file succeeded, want an error")
+	}
+}
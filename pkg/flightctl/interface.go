@@ -0,0 +1,100 @@
+package flightctl
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// FlightctlClient is the interface the provider uses to talk to the FlightCtl
+// API. It follows the contract in specs/001-we-want-to/contracts and is
+// implemented by *Client; tests can substitute their own implementation.
+type FlightctlClient interface {
+	DeviceManager
+	FleetManager
+	ConsoleManager
+	EnrollmentManager
+
+	// Ping checks if the FlightCtl API is reachable.
+	Ping(ctx context.Context) error
+}
+
+// DeviceManager handles edge device operations.
+type DeviceManager interface {
+	// ListDevices retrieves devices, optionally filtered by fleet and/or
+	// labels (AND logic).
+	ListDevices(ctx context.Context, fleetID string, labels map[string]string) ([]*models.Device, error)
+
+	// GetDevice retrieves a Device resource by ID.
+	GetDevice(ctx context.Context, deviceID string) (*FlightctlDevice, error)
+
+	// UpdateDevice replaces a Device resource (PUT).
+	UpdateDevice(ctx context.Context, deviceID string, device *FlightctlDevice) error
+}
+
+// ConsoleManager runs commands on devices.
+type ConsoleManager interface {
+	// StreamConsole runs a command on a device through the FlightCtl
+	// console API, streaming its standard streams until it exits.
+	StreamConsole(ctx context.Context, deviceID string, command []string, streams ConsoleStreams) error
+}
+
+// EnrollmentManager reads the requests of devices to join FlightCtl.
+type EnrollmentManager interface {
+	// ListEnrollmentRequests retrieves all enrollment requests.
+	ListEnrollmentRequests(ctx context.Context) ([]FlightctlEnrollmentRequest, error)
+}
+
+// FleetManager handles fleet operations.
+type FleetManager interface {
+	// ListFleets retrieves all fleets.
+	ListFleets(ctx context.Context) ([]*models.Fleet, error)
+
+	// GetFleet retrieves a specific fleet by ID. Returns an error if the
+	// fleet doesn't exist.
+	GetFleet(ctx context.Context, fleetID string) (*models.Fleet, error)
+
+	// GetFleetResource retrieves a Fleet resource by ID, with its template
+	// and rollout policy.
+	GetFleetResource(ctx context.Context, fleetID string) (*FlightctlFleet, error)
+
+	// UpdateFleet replaces a Fleet resource (PUT).
+	UpdateFleet(ctx context.Context, fleetID string, fleet *FlightctlFleet) error
+}
+
+// WorkloadManager handles the lifecycle of pods deployed as FlightCtl
+// applications. Pods are handled directly rather than through an
+// intermediate workload type (Constitution Principle VII); PodManager is
+// the implementation.
+type WorkloadManager interface {
+	DeployPod(ctx context.Context, pod *corev1.Pod, deviceID string) error
+	UpdatePod(ctx context.Context, pod *corev1.Pod, deviceID string) error
+	DeletePod(ctx context.Context, pod *corev1.Pod, deviceID string) error
+	// RemoveApplication removes an application deployed for the pod UID
+	// uid from a device by name, without its pod.
+	RemoveApplication(ctx context.Context, deviceID, appName string, uid types.UID) error
+	GetPodStatus(ctx context.Context, pod *corev1.Pod, deviceID string) (*corev1.PodStatus, error)
+	// GetPodStatuses retrieves the status of several pods on a device with
+	// a single device read.
+	GetPodStatuses(ctx context.Context, pods []*corev1.Pod, deviceID string) ([]PodStatusResult, error)
+	// ApplicationReported reports whether a device still reports the
+	// status of a pod's application.
+	ApplicationReported(ctx context.Context, pod *corev1.Pod, deviceID string) (bool, error)
+	// Flush waits until queued device updates are written.
+	Flush(ctx context.Context) error
+
+	// DeployPodToFleet adds or updates a pod's application in a fleet's
+	// device template, which FlightCtl rolls out to the fleet's devices.
+	DeployPodToFleet(ctx context.Context, pod *corev1.Pod, fleetID string) error
+	// DeletePodFromFleet removes a pod's application from a fleet's device
+	// template.
+	DeletePodFromFleet(ctx context.Context, pod *corev1.Pod, fleetID string) error
+}
+
+var (
+	_ FlightctlClient = (*Client)(nil)
+	_ WorkloadManager = (*PodManager)(nil)
+)
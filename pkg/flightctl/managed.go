@@ -0,0 +1,104 @@
+package flightctl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Environment variables set on the applications the provider deploys. They
+// mark an application as managed by the provider, so devices shared with
+// fleet templates or manually configured applications are only changed
+// where the provider owns them, and identify the application's pod.
+const (
+	ManagedByEnvVar    = "VK_FLIGHTCTL_MANAGED_BY"
+	PodNamespaceEnvVar = "VK_FLIGHTCTL_POD_NAMESPACE"
+	PodNameEnvVar      = "VK_FLIGHTCTL_POD_NAME"
+
+	// PodUIDEnvVar holds the UID of the application's pod, so a pod
+	// recreated with the same name is told apart from the pod the
+	// application was deployed for. It is left out of the content hash, so
+	// applications deployed before it was recorded are not rewritten.
+	PodUIDEnvVar = "VK_FLIGHTCTL_POD_UID"
+
+	// ContentHashEnvVar holds a hash of the application's definition, so
+	// deploying an unchanged pod again does not update the device.
+	ContentHashEnvVar = "VK_FLIGHTCTL_CONTENT_HASH"
+
+	// ManagedBy is the value of ManagedByEnvVar.
+	ManagedBy = "vk-flightctl-provider"
+)
+
+// managedEnvVars returns the environment variables tagging the application
+// of a pod.
+func managedEnvVars(pod *corev1.Pod) map[string]string {
+	envVars := map[string]string{
+		ManagedByEnvVar:    ManagedBy,
+		PodNamespaceEnvVar: pod.Namespace,
+		PodNameEnvVar:      pod.Name,
+	}
+	if pod.UID != "" {
+		envVars[PodUIDEnvVar] = string(pod.UID)
+	}
+	return envVars
+}
+
+// IsManaged reports whether the provider deployed the application.
+func (a FlightctlApplication) IsManaged() bool {
+	return a.EnvVars[ManagedByEnvVar] == ManagedBy
+}
+
+// PodKey returns the namespace/name of the pod a managed application runs.
+func (a FlightctlApplication) PodKey() (string, bool) {
+	if !a.IsManaged() || a.EnvVars[PodNamespaceEnvVar] == "" || a.EnvVars[PodNameEnvVar] == "" {
+		return "", false
+	}
+	return a.EnvVars[PodNamespaceEnvVar] + "/" + a.EnvVars[PodNameEnvVar], true
+}
+
+// PodUID returns the UID of the pod a managed application runs, if it was
+// recorded.
+func (a FlightctlApplication) PodUID() types.UID {
+	if !a.IsManaged() {
+		return ""
+	}
+	return types.UID(a.EnvVars[PodUIDEnvVar])
+}
+
+// runsPod reports whether a managed application was deployed for the pod
+// rather than an earlier or later pod of the same name. Applications
+// deployed before pod UIDs were recorded are taken to run the pod.
+func (a FlightctlApplication) runsPod(pod *corev1.Pod) bool {
+	uid := a.PodUID()
+	return uid == "" || pod.UID == "" || uid == pod.UID
+}
+
+// ContentHash returns the content hash the application was deployed with.
+func (a FlightctlApplication) ContentHash() string {
+	return a.EnvVars[ContentHashEnvVar]
+}
+
+// computeContentHash hashes the application type, image, inline content or
+// repository reference and environment variables other than the hash itself.
+func (a FlightctlApplication) computeContentHash() string {
+	envVars := maps.Clone(a.EnvVars)
+	delete(envVars, ContentHashEnvVar)
+	delete(envVars, PodUIDEnvVar)
+	// Maps are encoded with sorted keys, so the encoding is stable
+	data, err := json.Marshal(struct {
+		AppType string            `json:"appType"`
+		Image   string            `json:"image,omitempty"`
+		Inline  []InlineContent   `json:"inline"`
+		GitRef  *GitRef           `json:"gitRef,omitempty"`
+		EnvVars map[string]string `json:"envVars"`
+	}{a.AppType, a.Image, a.Inline, a.GitRef, envVars})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,55 @@
+package flightctl
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestContentHash(t *testing.T) {
+	pm := NewPodManager(nil)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}}},
+	}
+
+	app, err := pm.podToFlightctlApplication(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := pm.podToFlightctlApplication(pod.DeepCopy())
+	if app.ContentHash() == "" || app.ContentHash() != again.ContentHash() {
+		t.Errorf("hashes of the same pod = %q and %q, want equal and set", app.ContentHash(), again.ContentHash())
+	}
+
+	changed := pod.DeepCopy()
+	changed.Spec.Containers[0].Image = "nginx:1.26"
+	other, _ := pm.podToFlightctlApplication(changed)
+	if other.ContentHash() == app.ContentHash() {
+		t.Errorf("hash %q unchanged after changing the image", other.ContentHash())
+	}
+
+	// Metadata that does not reach the application does not change the hash
+	labelled := pod.DeepCopy()
+	labelled.Labels = map[string]string{"tier": "frontend"}
+	if same, _ := pm.podToFlightctlApplication(labelled); same.ContentHash() != app.ContentHash() {
+		t.Errorf("hash changed from %q to %q by a pod label", app.ContentHash(), same.ContentHash())
+	}
+
+	// The pod UID is recorded, but a recreated pod has the same content
+	recreated := pod.DeepCopy()
+	recreated.UID = "uid-2"
+	same, _ := pm.podToFlightctlApplication(recreated)
+	if same.ContentHash() != app.ContentHash() || same.PodUID() != "uid-2" {
+		t.Errorf("recreated pod: hash %q (want %q) and UID %q (want uid-2)", same.ContentHash(), app.ContentHash(), same.PodUID())
+	}
+	if !app.runsPod(recreated) {
+		t.Error("application without a recorded UID does not run the recreated pod")
+	}
+	earlier := pod.DeepCopy()
+	earlier.UID = "uid-1"
+	if same.runsPod(earlier) {
+		t.Error("application of pod uid-2 runs pod uid-1")
+	}
+}
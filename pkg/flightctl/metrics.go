@@ -0,0 +1,222 @@
+package flightctl
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// MetricsPath is where TransportMetrics are served.
+const MetricsPath = "/metrics"
+
+// requestDurationBuckets are the upper bounds, in seconds, of the request
+// latency histogram.
+var requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// TransportMetrics records the requests clients make to the FlightCtl API,
+// per method and endpoint: the latency and response code of each attempt,
+// the attempts in flight, and the retries. It may be shared by several
+// clients, and is served in the Prometheus text format.
+type TransportMetrics struct {
+	mu        sync.Mutex
+	endpoints map[endpointKey]*endpointMetrics
+}
+
+type endpointKey struct {
+	method   string
+	endpoint string
+}
+
+type endpointMetrics struct {
+	inFlight int
+	retries  uint64
+	codes    map[string]uint64
+	count    uint64
+	sum      float64
+	buckets  []uint64 // Observations per bucket of requestDurationBuckets
+}
+
+// NewTransportMetrics creates empty transport metrics.
+func NewTransportMetrics() *TransportMetrics {
+	return &TransportMetrics{endpoints: make(map[endpointKey]*endpointMetrics)}
+}
+
+// endpoint returns the metrics of a request's endpoint. m.mu must be held.
+func (m *TransportMetrics) endpoint(req *http.Request) *endpointMetrics {
+	key := endpointKey{method: req.Method, endpoint: endpointTemplate(req.URL.Path)}
+	metrics, ok := m.endpoints[key]
+	if !ok {
+		metrics = &endpointMetrics{codes: make(map[string]uint64), buckets: make([]uint64, len(requestDurationBuckets))}
+		m.endpoints[key] = metrics
+	}
+	return metrics
+}
+
+// start records an attempt in flight and returns the func recording its
+// outcome.
+func (m *TransportMetrics) start(req *http.Request) func(resp *http.Response, err error) {
+	started := time.Now()
+	m.mu.Lock()
+	m.endpoint(req).inFlight++
+	m.mu.Unlock()
+
+	return func(resp *http.Response, err error) {
+		seconds := time.Since(started).Seconds()
+		code := "error"
+		if err == nil {
+			code = strconv.Itoa(resp.StatusCode)
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		metrics := m.endpoint(req)
+		metrics.inFlight--
+		metrics.codes[code]++
+		metrics.count++
+		metrics.sum += seconds
+		if i := sort.SearchFloat64s(requestDurationBuckets, seconds); i < len(metrics.buckets) {
+			metrics.buckets[i]++
+		}
+	}
+}
+
+// retried records a request being retried. m may be nil.
+func (m *TransportMetrics) retried(req *http.Request) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.endpoint(req).retries++
+	m.mu.Unlock()
+}
+
+// MetricFamilies returns the metrics as Prometheus metric families.
+func (m *TransportMetrics) MetricFamilies() []*dto.MetricFamily {
+	duration := newMetricFamily("flightctl_client_request_duration_seconds", "Latency of FlightCtl API requests until the response headers, per attempt", dto.MetricType_HISTOGRAM)
+	requests := newMetricFamily("flightctl_client_requests_total", "FlightCtl API request attempts by response code, or error for transport failures", dto.MetricType_COUNTER)
+	retries := newMetricFamily("flightctl_client_retries_total", "FlightCtl API requests retried after a transient failure", dto.MetricType_COUNTER)
+	inFlight := newMetricFamily("flightctl_client_requests_in_flight", "FlightCtl API request attempts waiting for a response", dto.MetricType_GAUGE)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]endpointKey, 0, len(m.endpoints))
+	for key := range m.endpoints {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].endpoint != keys[j].endpoint {
+			return keys[i].endpoint < keys[j].endpoint
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	for _, key := range keys {
+		metrics := m.endpoints[key]
+		labels := []*dto.LabelPair{labelPair("endpoint", key.endpoint), labelPair("method", key.method)}
+
+		histogram := &dto.Histogram{SampleCount: uint64Ptr(metrics.count), SampleSum: float64Ptr(metrics.sum)}
+		var cumulative uint64
+		for i, bound := range requestDurationBuckets {
+			cumulative += metrics.buckets[i]
+			histogram.Bucket = append(histogram.Bucket, &dto.Bucket{CumulativeCount: uint64Ptr(cumulative), UpperBound: float64Ptr(bound)})
+		}
+		duration.Metric = append(duration.Metric, &dto.Metric{Label: labels, Histogram: histogram})
+
+		codes := make([]string, 0, len(metrics.codes))
+		for code := range metrics.codes {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			requests.Metric = append(requests.Metric, &dto.Metric{
+				Label:   append([]*dto.LabelPair{labelPair("code", code)}, labels...),
+				Counter: &dto.Counter{Value: float64Ptr(float64(metrics.codes[code]))},
+			})
+		}
+		retries.Metric = append(retries.Metric, &dto.Metric{Label: labels, Counter: &dto.Counter{Value: float64Ptr(float64(metrics.retries))}})
+		inFlight.Metric = append(inFlight.Metric, &dto.Metric{Label: labels, Gauge: &dto.Gauge{Value: float64Ptr(float64(metrics.inFlight))}})
+	}
+
+	// The text exposition format has no empty families
+	var families []*dto.MetricFamily
+	for _, family := range []*dto.MetricFamily{duration, requests, retries, inFlight} {
+		if len(family.Metric) > 0 {
+			families = append(families, family)
+		}
+	}
+	return families
+}
+
+// ServeHTTP serves the metrics in the format negotiated with the scraper.
+func (m *TransportMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format := expfmt.Negotiate(r.Header)
+	w.Header().Set("Content-Type", string(format))
+	enc := expfmt.NewEncoder(w, format)
+	for _, family := range m.MetricFamilies() {
+		if err := enc.Encode(family); err != nil {
+			return
+		}
+	}
+}
+
+// endpointTemplate replaces the resource names in an API path with {name},
+// e.g. /api/v1/devices/{name}/status, to keep the endpoint label bounded.
+// Collections and names alternate after the version segment.
+func endpointTemplate(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	version := -1
+	for i, segment := range segments {
+		if segment == "v1" || strings.HasPrefix(segment, "v1alpha") || strings.HasPrefix(segment, "v1beta") {
+			version = i
+			break
+		}
+	}
+	if version < 0 {
+		return "/" + strings.Join(segments, "/")
+	}
+	for i := version + 2; i < len(segments); i += 2 {
+		segments[i] = "{name}"
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// metricsTransport records each request passing through it.
+type metricsTransport struct {
+	base    http.RoundTripper
+	metrics *TransportMetrics
+}
+
+// RoundTrip implements http.RoundTripper interface.
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	done := t.metrics.start(req)
+	resp, err := t.base.RoundTrip(req)
+	done(resp, err)
+	return resp, err
+}
+
+// CloseIdleConnections closes idle connections of the base transport.
+func (t *metricsTransport) CloseIdleConnections() {
+	closeIdleConnections(t.base)
+}
+
+func newMetricFamily(name, help string, metricType dto.MetricType) *dto.MetricFamily {
+	return &dto.MetricFamily{Name: &name, Help: &help, Type: &metricType}
+}
+
+func labelPair(name, value string) *dto.LabelPair {
+	return &dto.LabelPair{Name: &name, Value: &value}
+}
+
+func uint64Ptr(v uint64) *uint64 { return &v }
+
+func float64Ptr(v float64) *float64 { return &v }
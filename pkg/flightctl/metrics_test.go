@@ -0,0 +1,67 @@
+package flightctl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransportMetrics(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"metadata":{"name":"dev-1"},"spec":{}}`))
+	}))
+	defer srv.Close()
+
+	metrics := NewTransportMetrics()
+	c := &Client{
+		httpClient: &http.Client{Transport: &retryTransport{
+			base:    &metricsTransport{base: srv.Client().Transport, metrics: metrics},
+			policy:  RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond},
+			metrics: metrics,
+		}},
+		baseURL: srv.URL,
+	}
+	if _, err := c.GetDevice(context.Background(), "dev-1"); err != nil {
+		t.Fatalf("GetDevice: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, MetricsPath, nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`flightctl_client_requests_total{code="503",endpoint="/api/v1/devices/{name}",method="GET"} 1`,
+		`flightctl_client_requests_total{code="200",endpoint="/api/v1/devices/{name}",method="GET"} 1`,
+		`flightctl_client_retries_total{endpoint="/api/v1/devices/{name}",method="GET"} 1`,
+		`flightctl_client_requests_in_flight{endpoint="/api/v1/devices/{name}",method="GET"} 0`,
+		`flightctl_client_request_duration_seconds_count{endpoint="/api/v1/devices/{name}",method="GET"} 2`,
+		`flightctl_client_request_duration_seconds_bucket{endpoint="/api/v1/devices/{name}",method="GET",le="+Inf"} 2`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics miss %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestEndpointTemplate(t *testing.T) {
+	for path, want := range map[string]string{
+		"/api/v1/devices":                               "/api/v1/devices",
+		"/api/v1/devices/dev-1":                         "/api/v1/devices/{name}",
+		"/api/v1/devices/dev-1/status":                  "/api/v1/devices/{name}/status",
+		"/api/v1/fleets/f1/templateversions/v2":         "/api/v1/fleets/{name}/templateversions/{name}",
+		"/prefix/api/v1/enrollmentrequests/er/approval": "/prefix/api/v1/enrollmentrequests/{name}/approval",
+		"/healthz": "/healthz",
+	} {
+		if got := endpointTemplate(path); got != want {
+			t.Errorf("endpointTemplate(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package flightctl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AppNaming selects how the FlightCtl application of a pod is named.
+type AppNaming string
+
+const (
+	// AppNamingPodName names applications <namespace>-<name>, the default.
+	// Names can collide, e.g. pod b-c of namespace a and pod c of namespace
+	// a-b, and long pod names exceed the name limits of FlightCtl.
+	AppNamingPodName AppNaming = "pod-name"
+
+	// AppNamingHashed names applications after their pod, sanitized and
+	// truncated, followed by a short hash of the pod's namespace, name and
+	// UID. Names are valid DNS labels and never collide, even with the
+	// application of an earlier pod of the same name. The pod's UID is
+	// recorded in the application's PodUIDEnvVar.
+	AppNamingHashed AppNaming = "hashed"
+)
+
+// AppNamings are the supported application naming strategies.
+var AppNamings = []AppNaming{AppNamingPodName, AppNamingHashed}
+
+const (
+	// maxAppNameLength is the length of a DNS label, which FlightCtl
+	// application names, compose projects and quadlet unit names all accept.
+	maxAppNameLength = 63
+
+	// appNameHashLength is the number of hex digits of the pod hash in
+	// hashed application names.
+	appNameHashLength = 10
+)
+
+// Validate returns an error if the naming strategy is not supported. The
+// empty strategy is the default.
+func (n AppNaming) Validate() error {
+	if n == "" {
+		return nil
+	}
+	for _, naming := range AppNamings {
+		if n == naming {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown application naming %q (expected %s or %s)", n, AppNamingPodName, AppNamingHashed)
+}
+
+// ApplicationName returns the FlightCtl application name of a pod.
+func (n AppNaming) ApplicationName(pod *corev1.Pod) string {
+	if n == AppNamingHashed {
+		return hashedApplicationName(pod)
+	}
+	return applicationName(pod)
+}
+
+// applicationName returns the <namespace>-<name> application name of a pod.
+func applicationName(pod *corev1.Pod) string {
+	return fmt.Sprintf("%s-%s", pod.Namespace, pod.Name)
+}
+
+// hashedApplicationName returns the application name of a pod with the
+// hashed naming strategy.
+func hashedApplicationName(pod *corev1.Pod) string {
+	sum := sha256.Sum256([]byte(pod.Namespace + "/" + pod.Name + "/" + string(pod.UID)))
+	hash := hex.EncodeToString(sum[:])[:appNameHashLength]
+
+	prefix := sanitizeAppName(pod.Namespace + "-" + pod.Name)
+	if limit := maxAppNameLength - appNameHashLength - 1; len(prefix) > limit {
+		prefix = strings.TrimRight(prefix[:limit], "-")
+	}
+	if prefix == "" {
+		return hash
+	}
+	return prefix + "-" + hash
+}
+
+// sanitizeAppName lowercases a name and replaces the characters not allowed
+// in a DNS label with hyphens, without leading or trailing ones.
+func sanitizeAppName(name string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, name)
+	return strings.Trim(sanitized, "-")
+}
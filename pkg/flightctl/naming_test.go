@@ -0,0 +1,80 @@
+package flightctl
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestHashedApplicationName(t *testing.T) {
+	pod := func(namespace, name string, uid types.UID) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, UID: uid}}
+	}
+	long := strings.Repeat("web.frontend-", 20)
+
+	names := map[string]*corev1.Pod{}
+	for _, p := range []*corev1.Pod{
+		pod("a", "b-c", "uid-1"),
+		pod("a-b", "c", "uid-1"),
+		pod("a", "b-c", "uid-2"),
+		pod("default", long+"1", "uid-1"),
+		pod("default", long+"2", "uid-1"),
+		pod("Default", "--", ""),
+	} {
+		name := AppNamingHashed.ApplicationName(p)
+		if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+			t.Errorf("name %q of pod %s/%s is not a DNS label: %v", name, p.Namespace, p.Name, errs)
+		}
+		if other, ok := names[name]; ok {
+			t.Errorf("pods %s/%s and %s/%s are both named %q", other.Namespace, other.Name, p.Namespace, p.Name, name)
+		}
+		names[name] = p
+	}
+
+	if name := AppNamingHashed.ApplicationName(pod("default", "web", "uid-1")); !strings.HasPrefix(name, "default-web-") {
+		t.Errorf("name = %q, want it to start with the pod's namespace and name", name)
+	}
+	if a, b := AppNamingHashed.ApplicationName(pod("default", "web", "uid-1")), AppNamingHashed.ApplicationName(pod("default", "web", "uid-1")); a != b {
+		t.Errorf("names of the same pod differ: %q and %q", a, b)
+	}
+	if name := AppNaming("").ApplicationName(pod("default", "web", "uid-1")); name != "default-web" {
+		t.Errorf("default naming = %q, want default-web", name)
+	}
+}
+
+func TestPodToFlightctlApplication_HashedNaming(t *testing.T) {
+	pm := NewPodManager(nil)
+	pm.SetAppNaming(AppNamingHashed)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			UID:         "uid-1",
+			Annotations: map[string]string{AppTypeAnnotation: "quadlet"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.21"}},
+		},
+	}
+
+	app, err := pm.podToFlightctlApplication(pod)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := hashedApplicationName(pod)
+	if app.Name != want {
+		t.Errorf("Expected application name %s, got %s", want, app.Name)
+	}
+	if key, ok := app.PodKey(); !ok || key != "default/web" || app.PodUID() != "uid-1" {
+		t.Errorf("Expected pod default/web with UID uid-1 recorded, got %q (%v) and %q", key, ok, app.PodUID())
+	}
+	for _, unit := range app.Inline {
+		if !strings.HasPrefix(unit.Path, want) {
+			t.Errorf("Expected quadlet unit %s to be named after the application", unit.Path)
+		}
+	}
+}
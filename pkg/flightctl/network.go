@@ -0,0 +1,59 @@
+package flightctl
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// NetworksAnnotation lists, comma-separated, the shared networks a pod joins
+// on its device, besides the network of its own containers. Pods on the same
+// device that join a network reach each other's containers by the names
+// <pod>-<container>, and the first container of a pod also by <pod>.
+const NetworksAnnotation = "flightctl.io/networks"
+
+// podNetwork is the compose network of a pod's own containers, standing in
+// for the pod's network namespace.
+const podNetwork = "pod"
+
+// sharedNetworkPrefix prefixes the device network names of shared networks,
+// keeping them apart from networks the device defines itself.
+const sharedNetworkPrefix = "vk-"
+
+// sharedNetworks returns the shared networks a pod joins, or an error for an
+// invalid network name or a pod on the host network.
+func sharedNetworks(pod *corev1.Pod) ([]string, error) {
+	var networks []string
+	for _, name := range strings.Split(pod.Annotations[NetworksAnnotation], ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || slices.Contains(networks, name) {
+			continue
+		}
+		if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+			return nil, fmt.Errorf("pod %s/%s: invalid network %q in %s: %s",
+				pod.Namespace, pod.Name, name, NetworksAnnotation, strings.Join(errs, "; "))
+		}
+		if name == podNetwork {
+			return nil, fmt.Errorf("pod %s/%s: network name %q in %s is reserved for the pod's own network",
+				pod.Namespace, pod.Name, name, NetworksAnnotation)
+		}
+		networks = append(networks, name)
+	}
+	if len(networks) > 0 && pod.Spec.HostNetwork {
+		return nil, fmt.Errorf("pod %s/%s uses the host network and cannot join %s", pod.Namespace, pod.Name, strings.Join(networks, ", "))
+	}
+	return networks, nil
+}
+
+// networkAliases returns the names other pods on a shared network reach a
+// container by.
+func networkAliases(pod *corev1.Pod, index int) []string {
+	aliases := []string{fmt.Sprintf("%s-%s", pod.Name, sanitizeServiceName(pod.Spec.Containers[index].Name))}
+	if index == 0 {
+		aliases = append(aliases, pod.Name)
+	}
+	return aliases
+}
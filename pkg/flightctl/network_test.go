@@ -0,0 +1,83 @@
+package flightctl
+
+import (
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestComposeNetworks(t *testing.T) {
+	pod := statusTestPod()
+	pod.Annotations = map[string]string{NetworksAnnotation: "backend, cache,backend"}
+
+	content := convertPodToDockerCompose(pod)
+	var compose struct {
+		Services map[string]struct {
+			Networks map[string]*struct {
+				Aliases []string `json:"aliases"`
+			} `json:"networks"`
+		} `json:"services"`
+		Networks map[string]*struct {
+			Name string `json:"name"`
+		} `json:"networks"`
+	}
+	if err := yaml.Unmarshal([]byte(content), &compose); err != nil {
+		t.Fatalf("parsing compose: %v\n%s", err, content)
+	}
+
+	nginx := compose.Services["nginx"].Networks
+	if _, ok := nginx[podNetwork]; !ok || len(nginx) != 3 {
+		t.Errorf("nginx networks = %v, want pod, backend and cache", nginx)
+	}
+	if aliases := nginx["backend"].Aliases; strings.Join(aliases, ",") != "web-nginx,web" {
+		t.Errorf("nginx aliases = %v, want web-nginx and web", aliases)
+	}
+	if aliases := compose.Services["sidecar"].Networks["cache"].Aliases; strings.Join(aliases, ",") != "web-sidecar" {
+		t.Errorf("sidecar aliases = %v, want web-sidecar", aliases)
+	}
+	if network := compose.Networks["backend"]; network == nil || network.Name != "vk-backend" {
+		t.Errorf("backend network = %+v, want named vk-backend", network)
+	}
+
+	pod.Annotations = nil
+	pod.Spec.HostNetwork = true
+	if content := convertPodToDockerCompose(pod); strings.Contains(content, "networks:") {
+		t.Errorf("host network pod has networks:\n%s", content)
+	}
+}
+
+func TestQuadletNetworks(t *testing.T) {
+	pod := statusTestPod()
+	pod.Annotations = map[string]string{NetworksAnnotation: "backend"}
+
+	units := convertPodToQuadlet(pod, "default-web")
+	last := units[len(units)-1]
+	if last.Path != "default-web-backend.network" || !strings.Contains(last.Content, "NetworkName=vk-backend") {
+		t.Errorf("network unit = %+v, want default-web-backend.network naming vk-backend", last)
+	}
+	podUnit := units[0].Content
+	for _, line := range []string{"Network=default-web-backend.network", "NetworkAlias=web", "NetworkAlias=web-sidecar"} {
+		if !strings.Contains(podUnit, line+"\n") {
+			t.Errorf("pod unit lacks %s:\n%s", line, podUnit)
+		}
+	}
+}
+
+func TestInvalidNetworksRejected(t *testing.T) {
+	pm := NewPodManager(nil)
+	for _, networks := range []string{"Not_Valid", podNetwork} {
+		pod := statusTestPod()
+		pod.Annotations = map[string]string{NetworksAnnotation: networks}
+		if _, err := pm.podToFlightctlApplication(pod); err == nil {
+			t.Errorf("expected an error for networks %q", networks)
+		}
+	}
+
+	pod := statusTestPod()
+	pod.Spec.HostNetwork = true
+	pod.Annotations = map[string]string{NetworksAnnotation: "backend", AppTypeAnnotation: AppTypeQuadlet}
+	if _, err := pm.podToFlightctlApplication(pod); err == nil {
+		t.Error("expected an error for a host network pod joining a network")
+	}
+}
@@ -0,0 +1,256 @@
+package flightctl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+const (
+	// tokenExpiryBuffer is subtracted from the token lifetime so a token is
+	// never used right at its expiry.
+	tokenExpiryBuffer = 60 * time.Second
+	// tokenRenewFraction is the fraction of the token lifetime after which
+	// it is renewed in the background.
+	tokenRenewFraction = 0.75
+	// tokenRenewRetryDelay is the delay before retrying a failed background
+	// renewal.
+	tokenRenewRetryDelay = 30 * time.Second
+)
+
+// tokenManager handles OAuth 2.0 token acquisition and refresh. Tokens are
+// obtained with the client credentials grant, or the refresh token grant
+// when the server issued (or the configuration holds) a refresh token, and
+// are renewed in the background before they expire.
+type tokenManager struct {
+	clientID     string
+	clientSecret string
+	tokenURL     string
+	httpClient   *http.Client
+
+	// fetchMu serializes token requests; mu guards the fields below and is
+	// never held during a request, so readers are not blocked by a renewal.
+	fetchMu sync.Mutex
+
+	mu               sync.RWMutex
+	accessToken      string
+	expiresAt        time.Time
+	refreshToken     string
+	refreshExpiresAt time.Time // zero if the refresh token does not expire
+	renewTimer       *time.Timer
+	closed           bool
+}
+
+// tokenResponse represents the OAuth 2.0 token response.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int    `json:"refresh_expires_in"`
+}
+
+// getToken returns a valid access token, fetching a new one if necessary.
+func (tm *tokenManager) getToken(ctx context.Context) (string, error) {
+	if token, ok := tm.validToken(); ok {
+		return token, nil
+	}
+	return tm.fetchToken(ctx)
+}
+
+// validToken returns the current access token if it has not expired.
+func (tm *tokenManager) validToken() (string, bool) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	if tm.accessToken != "" && time.Now().Before(tm.expiresAt) {
+		return tm.accessToken, true
+	}
+	return "", false
+}
+
+// invalidate discards token if it is still the current access token, so the
+// next getToken fetches a new one. Concurrent requests rejected with the same
+// token therefore trigger a single refresh.
+func (tm *tokenManager) invalidate(token string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if tm.accessToken == token {
+		tm.accessToken = ""
+	}
+}
+
+// fetchToken obtains a new access token unless another goroutine already
+// did.
+func (tm *tokenManager) fetchToken(ctx context.Context) (string, error) {
+	tm.fetchMu.Lock()
+	defer tm.fetchMu.Unlock()
+
+	// Double-check: another goroutine might have fetched the token
+	if token, ok := tm.validToken(); ok {
+		return token, nil
+	}
+	return tm.renew(ctx)
+}
+
+// renew requests a new token, preferring the refresh token grant and
+// falling back to client credentials. Caller must hold tm.fetchMu.
+func (tm *tokenManager) renew(ctx context.Context) (string, error) {
+	tm.mu.RLock()
+	refreshToken := tm.refreshToken
+	if !tm.refreshExpiresAt.IsZero() && time.Now().After(tm.refreshExpiresAt) {
+		refreshToken = ""
+	}
+	tm.mu.RUnlock()
+
+	var (
+		resp *tokenResponse
+		err  error
+	)
+	if refreshToken != "" {
+		resp, err = tm.requestToken(ctx, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {refreshToken},
+		})
+		if err != nil {
+			logger.FromContext(ctx).Warn("Refreshing FlightCtl access token failed: %v", err)
+			// A rejected refresh token (as opposed to an unreachable server)
+			// will not work on a later attempt either
+			var fe *FlightctlError
+			if errors.As(err, &fe) && fe.StatusCode < http.StatusInternalServerError {
+				tm.mu.Lock()
+				if tm.refreshToken == refreshToken {
+					tm.refreshToken = ""
+				}
+				tm.mu.Unlock()
+			}
+		}
+	}
+	if resp == nil {
+		if tm.clientSecret == "" {
+			if err == nil {
+				err = fmt.Errorf("refresh token missing or expired")
+			}
+			return "", fmt.Errorf("no client secret to request a new token: %w", err)
+		}
+		resp, err = tm.requestToken(ctx, url.Values{"grant_type": {"client_credentials"}})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	now := time.Now()
+	lifetime := time.Duration(resp.ExpiresIn) * time.Second
+	expiresIn := lifetime
+	if expiresIn > tokenExpiryBuffer {
+		expiresIn -= tokenExpiryBuffer
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.accessToken = resp.AccessToken
+	tm.expiresAt = now.Add(expiresIn)
+	if resp.RefreshToken != "" {
+		tm.refreshToken = resp.RefreshToken
+		tm.refreshExpiresAt = time.Time{}
+		if resp.RefreshExpiresIn > 0 {
+			tm.refreshExpiresAt = now.Add(time.Duration(resp.RefreshExpiresIn) * time.Second)
+		}
+	}
+	if lifetime > 0 {
+		tm.scheduleRenewLocked(time.Duration(float64(lifetime) * tokenRenewFraction))
+	}
+	return tm.accessToken, nil
+}
+
+// requestToken posts a token request with the given grant parameters.
+func (tm *tokenManager) requestToken(ctx context.Context, data url.Values) (*tokenResponse, error) {
+	data.Set("client_id", tm.clientID)
+	if tm.clientSecret != "" {
+		data.Set("client_secret", tm.clientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tm.tokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("creating token request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := tm.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("token request: %w", newHTTPError("POST", tm.tokenURL, resp.StatusCode, body))
+	}
+
+	var tokenResp tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return nil, fmt.Errorf("decoding token response: %w", err)
+	}
+
+	if tokenResp.AccessToken == "" {
+		return nil, fmt.Errorf("empty access token in response")
+	}
+	return &tokenResp, nil
+}
+
+// scheduleRenewLocked renews the token in the background after delay, so
+// requests don't wait for a token fetch. Caller must hold tm.mu.
+func (tm *tokenManager) scheduleRenewLocked(delay time.Duration) {
+	if tm.closed {
+		return
+	}
+	if tm.renewTimer != nil {
+		tm.renewTimer.Stop()
+	}
+	tm.renewTimer = time.AfterFunc(delay, tm.renewInBackground)
+}
+
+func (tm *tokenManager) renewInBackground() {
+	ctx, cancel := context.WithTimeout(context.Background(), tm.httpClient.Timeout+time.Second)
+	defer cancel()
+
+	tm.fetchMu.Lock()
+	defer tm.fetchMu.Unlock()
+
+	tm.mu.RLock()
+	closed := tm.closed
+	tm.mu.RUnlock()
+	if closed {
+		return
+	}
+
+	if _, err := tm.renew(ctx); err != nil {
+		logger.Warn("Background renewal of FlightCtl access token failed, retrying in %s: %v", tokenRenewRetryDelay, err)
+		tm.mu.Lock()
+		if time.Until(tm.expiresAt) > tokenRenewRetryDelay {
+			tm.scheduleRenewLocked(tokenRenewRetryDelay)
+		}
+		tm.mu.Unlock()
+		return
+	}
+	logger.Debug("Renewed FlightCtl access token ahead of expiry")
+}
+
+// close stops background renewal.
+func (tm *tokenManager) close() {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.closed = true
+	if tm.renewTimer != nil {
+		tm.renewTimer.Stop()
+	}
+}
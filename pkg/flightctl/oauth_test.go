@@ -0,0 +1,148 @@
+package flightctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTokenServer issues numbered access tokens and records the grants used.
+type fakeTokenServer struct {
+	mu        sync.Mutex
+	issued    int
+	grants    []string
+	expiresIn int
+}
+
+func (s *fakeTokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	grant := r.PostForm.Get("grant_type")
+	s.grants = append(s.grants, grant)
+	if grant == "refresh_token" && r.PostForm.Get("refresh_token") != fmt.Sprintf("refresh-%d", s.issued) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.issued++
+	_ = json.NewEncoder(w).Encode(tokenResponse{
+		AccessToken:  fmt.Sprintf("access-%d", s.issued),
+		ExpiresIn:    s.expiresIn,
+		RefreshToken: fmt.Sprintf("refresh-%d", s.issued),
+	})
+}
+
+func (s *fakeTokenServer) grantLog() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.grants...)
+}
+
+func newTestTokenManager(srv *httptest.Server) *tokenManager {
+	return &tokenManager{
+		clientID:     "vk",
+		clientSecret: "secret",
+		tokenURL:     srv.URL,
+		httpClient:   srv.Client(),
+	}
+}
+
+func TestTokenManagerUsesRefreshToken(t *testing.T) {
+	ts := &fakeTokenServer{expiresIn: 3600}
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	tm := newTestTokenManager(srv)
+	defer tm.close()
+
+	token, err := tm.getToken(context.Background())
+	if err != nil || token != "access-1" {
+		t.Fatalf("getToken = %q, %v; want access-1", token, err)
+	}
+
+	tm.invalidate("access-1")
+	token, err = tm.getToken(context.Background())
+	if err != nil || token != "access-2" {
+		t.Fatalf("getToken after invalidate = %q, %v; want access-2", token, err)
+	}
+
+	grants := ts.grantLog()
+	if len(grants) != 2 || grants[0] != "client_credentials" || grants[1] != "refresh_token" {
+		t.Errorf("grants = %v, want [client_credentials refresh_token]", grants)
+	}
+}
+
+func TestTokenManagerFallsBackToClientCredentials(t *testing.T) {
+	ts := &fakeTokenServer{expiresIn: 3600}
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	tm := newTestTokenManager(srv)
+	tm.refreshToken = "revoked"
+	defer tm.close()
+
+	token, err := tm.getToken(context.Background())
+	if err != nil || token != "access-1" {
+		t.Fatalf("getToken = %q, %v; want access-1", token, err)
+	}
+	grants := ts.grantLog()
+	if len(grants) != 2 || grants[0] != "refresh_token" || grants[1] != "client_credentials" {
+		t.Errorf("grants = %v, want [refresh_token client_credentials]", grants)
+	}
+}
+
+func TestTokenManagerRenewsInBackground(t *testing.T) {
+	ts := &fakeTokenServer{expiresIn: 1}
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	tm := newTestTokenManager(srv)
+	defer tm.close()
+
+	if _, err := tm.getToken(context.Background()); err != nil {
+		t.Fatalf("getToken: %v", err)
+	}
+
+	// A 1s token is renewed after 750ms without any request
+	deadline := time.Now().Add(5 * time.Second)
+	for len(ts.grantLog()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("token was not renewed in the background")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestRetryOnceOnUnauthorized(t *testing.T) {
+	ts := &fakeTokenServer{expiresIn: 3600}
+	tokenSrv := httptest.NewServer(ts)
+	defer tokenSrv.Close()
+
+	var auths []string
+	apiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths = append(auths, r.Header.Get("Authorization"))
+		// The first token was revoked server-side
+		if r.Header.Get("Authorization") == "Bearer access-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer apiSrv.Close()
+
+	client, err := NewClient(Config{APIURL: apiSrv.URL, ClientID: "vk", ClientSecret: "secret", TokenURL: tokenSrv.URL})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if len(auths) != 2 || auths[1] != "Bearer access-2" {
+		t.Errorf("Authorization headers = %v, want a retry with access-2", auths)
+	}
+}
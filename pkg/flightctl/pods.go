@@ -1,316 +1,371 @@
 package flightctl
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// AppTypeAnnotation selects the FlightCtl application type for a pod.
+	AppTypeAnnotation = "flightctl.io/app-type"
+
+	// AppTypeCompose deploys the pod as a podman-compose application.
+	AppTypeCompose = "compose"
+	// AppTypeQuadlet deploys the pod as systemd quadlet units.
+	AppTypeQuadlet = "quadlet"
 )
 
 // PodManager handles pod deployment operations via Flightctl API.
 // Works directly with v1.Pod objects (no intermediate Workload abstraction).
 type PodManager struct {
-	client *Client
+	devices     DeviceManager
+	translators *TranslatorRegistry
+	rollouts    *rolloutTracker
+	batches     *writeBatcher
+	translated  TranslationObserver
+	fleets      FleetManager
+	naming      AppNaming
+}
+
+// TranslationObserver is called with the application a pod was translated
+// to, once the device runs it or is updated to, with the pod as deployed.
+// deviceID is empty for applications deployed through a fleet template.
+type TranslationObserver func(ctx context.Context, pod *corev1.Pod, deviceID string, app FlightctlApplication)
+
+// NewPodManager creates a new pod manager using compose as the default app type.
+func NewPodManager(devices DeviceManager) *PodManager {
+	return NewPodManagerWithTranslators(devices, NewTranslatorRegistry(AppTypeCompose))
+}
+
+// NewPodManagerWithTranslators creates a pod manager that uses the given
+// translator registry to convert pods into FlightCtl applications.
+func NewPodManagerWithTranslators(devices DeviceManager, translators *TranslatorRegistry) *PodManager {
+	return &PodManager{
+		devices:     devices,
+		translators: translators,
+		rollouts:    newRolloutTracker(),
+		batches:     newWriteBatcher(DefaultBatchWindow),
+	}
+}
+
+// Translators returns the registry used to translate pods, so additional
+// formats can be registered.
+func (pm *PodManager) Translators() *TranslatorRegistry {
+	return pm.translators
 }
 
-// NewPodManager creates a new pod manager.
-func NewPodManager(client *Client) *PodManager {
-	return &PodManager{client: client}
+// SetAppNaming sets how the applications of pods are named. It must be set
+// before pods are deployed.
+func (pm *PodManager) SetAppNaming(naming AppNaming) {
+	pm.naming = naming
+}
+
+// SetTranslationObserver sets the func called with the application of each
+// deployed pod. It must be set before pods are deployed.
+func (pm *PodManager) SetTranslationObserver(observer TranslationObserver) {
+	pm.translated = observer
 }
 
 // DeployPod deploys a Kubernetes pod to a Flightctl device.
-// Fetches the existing Device, adds the pod as a new application, and updates the Device.
+// Fetches the existing Device, adds the pod as a new application (or replaces
+// the application it deployed before unless its content hash is unchanged),
+// and updates the Device together with
+// the other pod operations queued for it. Applications the provider does not
+// manage are left untouched; deploying over one with the pod's application
+// name fails with ErrUnmanagedApplication.
 func (pm *PodManager) DeployPod(ctx context.Context, pod *corev1.Pod, deviceID string) error {
-	logger.Info("PodManager.DeployPod() for pod %s on device %s", pod.Name, deviceID)
-
-	// Step 1: Get the existing Device resource
-	logger.Debug("Retrieve Device info from flightctl")
-	device, err := pm.getDevice(ctx, deviceID)
+	ctx, span := pm.startSpan(ctx, "PodManager.DeployPod", pod, deviceID)
+	defer span.End()
+	log := logger.FromContext(ctx).With("device", deviceID)
+	log.Info("PodManager.DeployPod() for pod %s on device %s", pod.Name, deviceID)
+
+	// Step 1: Convert pod to Flightctl Application
+	log.Debug("Converting Pod to FlightCTL App Spec")
+	newApp, err := pm.podToFlightctlApplication(pod)
 	if err != nil {
-		logger.Error("getting device %s: %s", deviceID, err.Error())
-		return fmt.Errorf("getting device %s: %w", deviceID, err)
+		return err
 	}
 
-	// Step 2: Convert pod to Flightctl Application
-	logger.Debug("Converting Pod to FlightCTL App Spec")
-	newApp := pm.podToFlightctlApplication(pod)
+	// Step 2: Add the application to the device spec, replacing the one
+	// deployed before in place (update scenario)
+	var baseline string
+	var track bool
+	err = pm.changeApplications(ctx, deviceID, func(device *FlightctlDevice) (bool, error) {
+		apps := device.Spec.Applications
+		i := slices.IndexFunc(apps, func(app FlightctlApplication) bool { return app.Name == newApp.Name })
+		switch {
+		case i < 0:
+			device.Spec.Applications = append(apps, newApp)
+		case !apps[i].IsManaged():
+			return false, fmt.Errorf("application %s on device %s: %w", newApp.Name, deviceID, ErrUnmanagedApplication)
+		case newApp.ContentHash() != "" && apps[i].ContentHash() == newApp.ContentHash() && apps[i].runsPod(pod):
+			// Rewriting an identical application would only restart it;
+			// one deployed for an earlier pod of the same name is replaced
+			log.Info("Application %s on device %s is unchanged (hash %s), skipping update", newApp.Name, deviceID, newApp.ContentHash())
+			track = false
+			return false, nil
+		default:
+			apps[i] = newApp
+		}
+		log.Info("Updated device with %d applications", len(device.Spec.Applications))
 
-	// Step 3: Check if application already exists and remove it (update scenario)
-	existingApps := make([]FlightctlApplication, 0, len(device.Spec.Applications))
-	for _, app := range device.Spec.Applications {
-		if app.Name != newApp.Name {
-			existingApps = append(existingApps, app)
+		baseline = device.RenderedVersion()
+		if baseline == "" {
+			baseline = device.AppliedVersion()
 		}
+		track = device.reportsRenderedVersions()
+		return true, nil
+	})
+	if err != nil {
+		return err
 	}
 
-	// Step 4: Add the new application
-	existingApps = append(existingApps, newApp)
-	device.Spec.Applications = existingApps
-	device.Status = nil
-
-	logger.Info("Updated device with %d applications", len(device.Spec.Applications))
-
-	// Step 5: Update the Device resource
-	return pm.updateDevice(ctx, deviceID, device)
+	// Step 3: Follow the rollout until the device applies the new spec
+	if track {
+		pm.rollouts.start(deviceID, newApp.Name, baseline)
+	}
+	if pm.translated != nil {
+		pm.translated(ctx, pod, deviceID, newApp)
+	}
+	return nil
 }
 
-// UpdatePod updates a pod on a device (simple replace strategy).
+// UpdatePod updates a pod on a device by replacing its application in
+// place. The device is not updated if the application's content hash is
+// unchanged.
 func (pm *PodManager) UpdatePod(ctx context.Context, pod *corev1.Pod, deviceID string) error {
-	// Simple replace: delete then deploy
-	logger.Info("PodManager.UpdatePod() for pod %s on device %s", pod.Name, deviceID)
-	_ = pm.DeletePod(ctx, pod, deviceID) // Ignore error if not exists
+	ctx, span := pm.startSpan(ctx, "PodManager.UpdatePod", pod, deviceID)
+	defer span.End()
+	log := logger.FromContext(ctx).With("device", deviceID)
+	log.Info("PodManager.UpdatePod() for pod %s on device %s", pod.Name, deviceID)
 	return pm.DeployPod(ctx, pod, deviceID)
 }
 
 // DeletePod removes a pod from a device by removing its application from the Device spec.
 // This operation is idempotent - if the application doesn't exist, no error is returned.
+// An application with the pod's name that the provider does not manage is
+// left in place.
 func (pm *PodManager) DeletePod(ctx context.Context, pod *corev1.Pod, deviceID string) error {
-	logger.Info("PodManager.DeletePod() for pod %s on device %s", pod.Name, deviceID)
-
-	// Step 1: Get the existing Device resource
-	device, err := pm.getDevice(ctx, deviceID)
-	if err != nil {
-		return fmt.Errorf("getting device %s: %w", deviceID, err)
-	}
-
-	// Step 2: Generate the application name that would have been created
-	appName := fmt.Sprintf("%s-%s", pod.Namespace, pod.Name)
-
-	// Step 3: Filter out the application to delete
-	updatedApps := make([]FlightctlApplication, 0, len(device.Spec.Applications))
-	found := false
-	for _, app := range device.Spec.Applications {
-		if app.Name != appName {
-			updatedApps = append(updatedApps, app)
-		} else {
-			found = true
+	ctx, span := pm.startSpan(ctx, "PodManager.DeletePod", pod, deviceID)
+	defer span.End()
+	log := logger.FromContext(ctx).With("device", deviceID)
+	log.Info("PodManager.DeletePod() for pod %s on device %s", pod.Name, deviceID)
+
+	// Step 1: Generate the application name that would have been created
+	appName := pm.naming.ApplicationName(pod)
+	pm.rollouts.forget(deviceID, appName)
+
+	// Step 2: Filter out the application to delete
+	return pm.changeApplications(ctx, deviceID, func(device *FlightctlDevice) (bool, error) {
+		apps := device.Spec.Applications
+		i := slices.IndexFunc(apps, func(app FlightctlApplication) bool { return app.Name == appName })
+
+		// If application wasn't found, that's OK (idempotent)
+		if i < 0 {
+			log.Info("Application %s not found on device %s (already deleted)", appName, deviceID)
+			return false, nil
 		}
-	}
-
-	// If application wasn't found, that's OK (idempotent)
-	if !found {
-		logger.Info("Application %s not found on device %s (already deleted)", appName, deviceID)
-		return nil
-	}
-
-	// Step 4: Update the device with the filtered application list
-	device.Spec.Applications = updatedApps
-	device.Status = nil
-	logger.Info("Removing application %s from device %s (%d applications remaining)", appName, deviceID, len(updatedApps))
-
-	return pm.updateDevice(ctx, deviceID, device)
-}
-
-// GetPodStatus retrieves pod status from Flightctl Device resource and maps to v1.PodStatus.
-func (pm *PodManager) GetPodStatus(ctx context.Context, pod *corev1.Pod, deviceID string) (*corev1.PodStatus, error) {
-	appName := fmt.Sprintf("%s-%s", pod.Namespace, pod.Name)
-
-	// Get the Device resource
-	device, err := pm.getDevice(ctx, deviceID)
-	if err != nil {
-		return nil, fmt.Errorf("getting device %s: %w", deviceID, err)
-	}
-
-	// Check if the application exists in the Device spec
-	appExists := false
-	for _, app := range device.Spec.Applications {
-		if app.Name == appName {
-			appExists = true
-			break
+		if !apps[i].IsManaged() {
+			log.Warn("Application %s on device %s is not managed by the provider, leaving it in place", appName, deviceID)
+			return false, nil
 		}
-	}
-
-	if !appExists {
-		return nil, fmt.Errorf("application %s not found on device %s", appName, deviceID)
-	}
-
-	// Check Device status for actual runtime status
-	if device.Status != nil && len(device.Status.Applications) > 0 {
-		for _, appStatus := range device.Status.Applications {
-			if appStatus.Name == appName {
-				// Found runtime status - map to Kubernetes pod status
-				return pm.mapFlightctlStatusToPodStatus(&appStatus), nil
-			}
+		if !apps[i].runsPod(pod) {
+			log.Info("Application %s on device %s runs pod UID %s rather than the deleted %s, leaving it in place", appName, deviceID, apps[i].PodUID(), pod.UID)
+			return false, nil
 		}
-	}
 
-	// No runtime status available yet - application is in spec but not yet running
-	// Return Pending status
-	return &corev1.PodStatus{
-		Phase: corev1.PodPending,
-		Conditions: []corev1.PodCondition{
-			{
-				Type:   corev1.PodScheduled,
-				Status: corev1.ConditionTrue,
-				Reason: "ApplicationDeployed",
-			},
-		},
-	}, nil
+		device.Spec.Applications = slices.Delete(apps, i, i+1)
+		log.Info("Removing application %s from device %s (%d applications remaining)", appName, deviceID, len(device.Spec.Applications))
+		return true, nil
+	})
 }
 
-// mapFlightctlStatusToPodStatus maps FlightCtl application status to Kubernetes pod status.
-func (pm *PodManager) mapFlightctlStatusToPodStatus(appStatus *FlightctlApplicationStatus) *corev1.PodStatus {
-	var phase corev1.PodPhase
-	var conditions []corev1.PodCondition
+// RemoveApplication removes an application the provider deployed from a
+// device by name, e.g. one whose pod no longer exists. The application is
+// left in place unless it is managed and was deployed for the pod UID uid,
+// so one redeployed for another pod since it was read is kept.
+func (pm *PodManager) RemoveApplication(ctx context.Context, deviceID, appName string, uid types.UID) error {
+	log := logger.FromContext(ctx).With("device", deviceID)
+	pm.rollouts.forget(deviceID, appName)
+	return pm.changeApplications(ctx, deviceID, func(device *FlightctlDevice) (bool, error) {
+		apps := device.Spec.Applications
+		i := slices.IndexFunc(apps, func(app FlightctlApplication) bool { return app.Name == appName })
+		if i < 0 || !apps[i].IsManaged() || apps[i].PodUID() != uid {
+			return false, nil
+		}
+		device.Spec.Applications = slices.Delete(apps, i, i+1)
+		log.Info("Removing application %s from device %s (%d applications remaining)", appName, deviceID, len(device.Spec.Applications))
+		return true, nil
+	})
+}
 
-	// Map FlightCtl status to Kubernetes phase
-	// Common FlightCtl statuses: running, pending, starting, failed, stopped, completed, error
-	switch strings.ToLower(appStatus.Status) {
-	case "running":
-		phase = corev1.PodRunning
-		conditions = []corev1.PodCondition{
-			{
-				Type:               corev1.PodReady,
-				Status:             corev1.ConditionTrue,
-				LastTransitionTime: metav1.Now(),
-				Reason:             "ApplicationRunning",
-				Message:            appStatus.Summary,
-			},
-		}
-
-	case "pending", "starting":
-		phase = corev1.PodPending
-		conditions = []corev1.PodCondition{
-			{
-				Type:               corev1.PodScheduled,
-				Status:             corev1.ConditionTrue,
-				LastTransitionTime: metav1.Now(),
-				Reason:             "ApplicationStarting",
-				Message:            appStatus.Summary,
-			},
-		}
-
-	case "failed", "error":
-		phase = corev1.PodFailed
-		conditions = []corev1.PodCondition{
-			{
-				Type:               corev1.PodReady,
-				Status:             corev1.ConditionFalse,
-				LastTransitionTime: metav1.Now(),
-				Reason:             "ApplicationFailed",
-				Message:            appStatus.Summary,
-			},
+// deviceUpdateAttempts bounds the read-modify-write attempts of a device
+// update that keeps conflicting with concurrent updates.
+const deviceUpdateAttempts = 3
+
+// updateApplications fetches a device, lets modify change its applications
+// and writes it back if modify returns true. The update carries the
+// resourceVersion that was read, so a concurrent change to the device makes
+// it fail with a conflict instead of being overwritten; it is then retried
+// from a fresh read. Reads bypass the device cache, whose copy could only
+// cause conflicts.
+func (pm *PodManager) updateApplications(ctx context.Context, deviceID string, modify func(device *FlightctlDevice) (bool, error)) error {
+	log := logger.FromContext(ctx).With("device", deviceID)
+	for attempt := 1; ; attempt++ {
+		log.Debug("Retrieve Device info from flightctl")
+		device, err := pm.devices.GetDevice(BypassCache(ctx), deviceID)
+		if err != nil {
+			log.Error("getting device %s: %s", deviceID, err.Error())
+			return fmt.Errorf("getting device %s: %w", deviceID, err)
 		}
 
-	case "completed", "succeeded":
-		phase = corev1.PodSucceeded
-		conditions = []corev1.PodCondition{
-			{
-				Type:               corev1.PodReady,
-				Status:             corev1.ConditionFalse,
-				LastTransitionTime: metav1.Now(),
-				Reason:             "ApplicationCompleted",
-				Message:            appStatus.Summary,
-			},
-		}
-
-	case "stopped":
-		// Stopped but not failed - map to Succeeded
-		phase = corev1.PodSucceeded
-		conditions = []corev1.PodCondition{
-			{
-				Type:               corev1.PodReady,
-				Status:             corev1.ConditionFalse,
-				LastTransitionTime: metav1.Now(),
-				Reason:             "ApplicationStopped",
-				Message:            appStatus.Summary,
-			},
+		changed, err := modify(device)
+		if err != nil || !changed {
+			return err
 		}
+		device.Status = nil
 
-	default:
-		// Unknown status - default to Pending
-		phase = corev1.PodPending
-		conditions = []corev1.PodCondition{
-			{
-				Type:               corev1.PodScheduled,
-				Status:             corev1.ConditionTrue,
-				LastTransitionTime: metav1.Now(),
-				Reason:             "UnknownStatus",
-				Message:            fmt.Sprintf("Unknown application status: %s - %s", appStatus.Status, appStatus.Summary),
-			},
+		err = pm.devices.UpdateDevice(ctx, deviceID, device)
+		if !errors.Is(err, ErrConflict) || attempt == deviceUpdateAttempts {
+			return err
 		}
+		log.Info("Device %s changed concurrently, retrying update (attempt %d/%d)", deviceID, attempt+1, deviceUpdateAttempts)
 	}
+}
 
-	return &corev1.PodStatus{
-		Phase:      phase,
-		Conditions: conditions,
-		Message:    appStatus.Summary,
+// startSpan starts a span for a pod operation on a device and
+// attaches the device ID and application name to the spans of the HTTP calls
+// made with the returned context.
+func (pm *PodManager) startSpan(ctx context.Context, name string, pod *corev1.Pod, deviceID string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		tracing.DeviceIDKey.String(deviceID),
+		tracing.AppNameKey.String(pm.naming.ApplicationName(pod)),
 	}
+	ctx = tracing.WithAttributes(ctx, attrs...)
+	return tracing.Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
 }
 
-// getDevice retrieves the current Device resource from FlightCtl API.
-func (pm *PodManager) getDevice(ctx context.Context, deviceID string) (*FlightctlDevice, error) {
-	url := fmt.Sprintf("%s/api/v1/devices/%s", pm.client.baseURL, deviceID)
+// GetPodStatus retrieves pod status from Flightctl Device resource and maps to v1.PodStatus.
+func (pm *PodManager) GetPodStatus(ctx context.Context, pod *corev1.Pod, deviceID string) (*corev1.PodStatus, error) {
+	ctx, span := pm.startSpan(ctx, "PodManager.GetPodStatus", pod, deviceID)
+	defer span.End()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	// Get the Device resource
+	device, err := pm.devices.GetDevice(ctx, deviceID)
 	if err != nil {
-		return nil, fmt.Errorf("creating GET request: %w", err)
+		return nil, fmt.Errorf("getting device %s: %w", deviceID, err)
 	}
+	status, _, err := pm.podStatusOnDevice(pod, device, deviceID)
+	return status, err
+}
 
-	req.Header.Set("Accept", "application/json")
+// ApplicationReported reports whether the device still reports the status
+// of the pod's application, e.g. while stopping it after it was removed from
+// the device spec.
+func (pm *PodManager) ApplicationReported(ctx context.Context, pod *corev1.Pod, deviceID string) (bool, error) {
+	ctx, span := pm.startSpan(ctx, "PodManager.ApplicationReported", pod, deviceID)
+	defer span.End()
 
-	resp, err := pm.client.httpClient.Do(req)
+	device, err := pm.devices.GetDevice(ctx, deviceID)
 	if err != nil {
-		logger.Error("GET request failed: %v", err)
-		return nil, fmt.Errorf("GET request failed: %w", err)
+		return false, fmt.Errorf("getting device %s: %w", deviceID, err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		logger.Error("GET device failed with status %d: %s", resp.StatusCode, string(bodyBytes))
-		return nil, fmt.Errorf("GET device failed with status %d: %s", resp.StatusCode, string(bodyBytes))
-	}
-
-	var device FlightctlDevice
-	if err := json.NewDecoder(resp.Body).Decode(&device); err != nil {
-		logger.Error("decoding device: %s", err.Error())
-		return nil, fmt.Errorf("decoding device: %w", err)
+	if device.Status == nil {
+		return false, nil
 	}
-
-	return &device, nil
+	appName := pm.naming.ApplicationName(pod)
+	return slices.ContainsFunc(device.Status.Applications, func(app FlightctlApplicationStatus) bool {
+		return app.Name == appName
+	}), nil
 }
 
-// updateDevice updates a Device resource via FlightCtl API (PUT).
-func (pm *PodManager) updateDevice(ctx context.Context, deviceID string, device *FlightctlDevice) error {
-	url := fmt.Sprintf("%s/api/v1/devices/%s", pm.client.baseURL, deviceID)
+// PodStatusResult is the status of one pod read by GetPodStatuses.
+type PodStatusResult struct {
+	Status *corev1.PodStatus
+	Err    error
+	// NotReported is set when the device does not report the status of
+	// the pod's application, e.g. once it stopped it; Status is then the
+	// status of a pod waiting to start.
+	NotReported bool
+}
 
-	body, err := json.Marshal(device)
+// GetPodStatuses retrieves the status of several pods on a device with a
+// single read of the Device resource. The results are in the order of pods;
+// an error is returned instead if the device cannot be read.
+func (pm *PodManager) GetPodStatuses(ctx context.Context, pods []*corev1.Pod, deviceID string) ([]PodStatusResult, error) {
+	ctx = tracing.WithAttributes(ctx, tracing.DeviceIDKey.String(deviceID))
+	ctx, span := tracing.Tracer().Start(ctx, "PodManager.GetPodStatuses", trace.WithAttributes(
+		tracing.DeviceIDKey.String(deviceID),
+		attribute.Int("pods", len(pods)),
+	))
+	defer span.End()
+
+	device, err := pm.devices.GetDevice(ctx, deviceID)
 	if err != nil {
-		return fmt.Errorf("marshaling device: %w", err)
+		return nil, fmt.Errorf("getting device %s: %w", deviceID, err)
 	}
-
-	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewReader(body))
-	if err != nil {
-		return fmt.Errorf("creating PUT request: %w", err)
+	results := make([]PodStatusResult, len(pods))
+	for i, pod := range pods {
+		status, reported, err := pm.podStatusOnDevice(pod, device, deviceID)
+		results[i] = PodStatusResult{Status: status, Err: err, NotReported: err == nil && !reported}
 	}
+	return results, nil
+}
 
-	req.Header.Set("Content-Type", "application/json")
+// podStatusOnDevice maps the status a device reports for a pod's
+// application to a pod status. reported is false if the device reports no
+// status for the application, which is then waiting to start.
+func (pm *PodManager) podStatusOnDevice(pod *corev1.Pod, device *FlightctlDevice, deviceID string) (_ *corev1.PodStatus, reported bool, _ error) {
+	appName := pm.naming.ApplicationName(pod)
 
-	logger.Debug("Updating device %s with payload:\n%s", deviceID, string(body))
+	// Check if the application exists in the Device spec
+	i := slices.IndexFunc(device.Spec.Applications, func(app FlightctlApplication) bool { return app.Name == appName })
+	if i < 0 {
+		return nil, false, fmt.Errorf("application %s on device %s: %w", appName, deviceID, ErrNotFound)
+	}
 
-	resp, err := pm.client.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("PUT request failed: %w", err)
+	// The reported status of an application deployed for another pod of
+	// the same name is not the pod's, which waits for its own deployment
+	if !device.Spec.Applications[i].runsPod(pod) {
+		return pendingPodStatus(pod, device.DeviceIP()), true, nil
 	}
 
-	defer resp.Body.Close()
+	// The last reported application status is stale while the device is offline
+	if device.ToModel().ConnectionState == models.Disconnected {
+		return nil, false, fmt.Errorf("device %s: %w", deviceID, ErrDeviceOffline)
+	}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		logger.Error("update device failed with status %d: %s", resp.StatusCode, string(bodyBytes))
-		return fmt.Errorf("update device failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	// The reported application status is stale until the device applies
+	// the spec the pod was deployed with
+	if baseline, ok := pm.rollouts.pending(device, appName); ok {
+		return rollingOutPodStatus(pod, device, baseline), true, nil
 	}
 
-	logger.Info("Successfully updated device %s", deviceID)
-	return nil
+	// Check Device status for actual runtime status
+	hostIP := device.DeviceIP()
+	if device.Status != nil {
+		for _, appStatus := range device.Status.Applications {
+			if appStatus.Name == appName {
+				// Found runtime status - map to Kubernetes pod status
+				return pm.mapFlightctlStatusToPodStatus(pod, &appStatus, hostIP), true, nil
+			}
+		}
+	}
+
+	// No runtime status available yet - application is in spec but not yet running
+	return pendingPodStatus(pod, hostIP), false, nil
 }
 
 // convertPodToDockerCompose converts a Kubernetes Pod to Docker Compose YAML format.
@@ -320,41 +375,62 @@ func convertPodToDockerCompose(pod *corev1.Pod) string {
 		return ""
 	}
 
+	// Invalid networks are rejected by the translator
+	networks, _ := sharedNetworks(pod)
+	envFrom, _ := envFromOf(pod)
+	dns := dnsOf(pod)
+
 	var compose strings.Builder
 	//compose.WriteString("content: |\n")
 	compose.WriteString(" version: '3.8'\n")
 	compose.WriteString(" services:\n")
 
-	// Convert each container to a service
-	for _, container := range pod.Spec.Containers {
+	// Convert each container to a service, init containers first
+	for _, service := range podServices(pod) {
+		container := service.container
 		compose.WriteString(fmt.Sprintf("  %s:\n", sanitizeServiceName(container.Name)))
 
 		// Image
 		compose.WriteString(fmt.Sprintf("    image: %s\n", container.Image))
+		if policy := pullPolicy(container.ImagePullPolicy); policy != "" {
+			compose.WriteString(fmt.Sprintf("    pull_policy: %s\n", policy))
+		}
 
-		// Command (entrypoint in Docker Compose)
-		if len(container.Command) > 0 {
+		// Command (entrypoint in Docker Compose); containers with lifecycle
+		// hooks run it through their hook wrapper
+		entrypoint, args := hookWrappedCommand(container)
+		if len(entrypoint) > 0 {
 			compose.WriteString("    entrypoint:\n")
-			for _, cmd := range container.Command {
+			for _, cmd := range entrypoint {
 				compose.WriteString(fmt.Sprintf("      - %s\n", cmd))
 			}
 		}
 
 		// Args (command in Docker Compose)
-		if len(container.Args) > 0 {
+		if len(args) > 0 {
 			compose.WriteString("    command:\n")
-			for _, arg := range container.Args {
+			for _, arg := range args {
 				compose.WriteString(fmt.Sprintf("      - %s\n", arg))
 			}
 		}
+		if wrapsHooks(container) {
+			compose.WriteString("    volumes:\n")
+			compose.WriteString(fmt.Sprintf("      - ./%s:%s:ro\n", hookWrapperFile("", container), hookWrapperPath(container)))
+		}
 
-		// Environment variables
+		// Environment variables: the envFrom env files, overridden by env
+		if files := envFromFileNames(envFrom, "", container); len(files) > 0 {
+			compose.WriteString("    env_file:\n")
+			for _, file := range files {
+				compose.WriteString(fmt.Sprintf("      - ./%s\n", file))
+			}
+		}
 		if len(container.Env) > 0 {
 			compose.WriteString("    environment:\n")
 			for _, env := range container.Env {
-				if env.Value != "" {
-					// Direct value
-					compose.WriteString(fmt.Sprintf("      - %s=%s\n", env.Name, env.Value))
+				if value, ok := envValue(pod, env); ok {
+					// Direct value or downward API field
+					compose.WriteString(fmt.Sprintf("      - %s\n", composeEnvEntry(env.Name, value)))
 				} else if env.ValueFrom != nil {
 					// For now, we'll add a placeholder comment for complex env sources
 					compose.WriteString(fmt.Sprintf("      # %s: (from secret/configmap)\n", env.Name))
@@ -362,13 +438,46 @@ func convertPodToDockerCompose(pod *corev1.Pod) string {
 			}
 		}
 
-		// Ports
-		if len(container.Ports) > 0 {
+		// Ports; on the host network containers bind the device ports directly
+		if pod.Spec.HostNetwork {
+			compose.WriteString("    network_mode: host\n")
+		} else if len(container.Ports) > 0 {
 			compose.WriteString("    ports:\n")
 			for _, port := range container.Ports {
 				if port.ContainerPort > 0 {
-					// Map container port to same host port
-					compose.WriteString(fmt.Sprintf("      - \"%d:%d\"\n", port.ContainerPort, port.ContainerPort))
+					compose.WriteString(fmt.Sprintf("      - \"%d:%d\"\n", publishedPort(port), port.ContainerPort))
+				}
+			}
+		}
+
+		// Networks: the pod's own, which its containers share, and the
+		// shared networks it joins
+		if !pod.Spec.HostNetwork {
+			compose.WriteString("    networks:\n")
+			compose.WriteString(fmt.Sprintf("      %s: {}\n", podNetwork))
+			for _, network := range networks {
+				compose.WriteString(fmt.Sprintf("      %s:\n", network))
+				compose.WriteString("        aliases:\n")
+				for _, alias := range service.aliases {
+					compose.WriteString(fmt.Sprintf("          - %s\n", alias))
+				}
+			}
+		}
+
+		// Resolver settings and /etc/hosts entries
+		for _, list := range []struct {
+			key    string
+			values []string
+		}{
+			{"dns", dns.nameservers},
+			{"dns_search", dns.searches},
+			{"dns_opt", dns.options},
+			{"extra_hosts", dns.hosts},
+		} {
+			if len(list.values) > 0 {
+				compose.WriteString(fmt.Sprintf("    %s:\n", list.key))
+				for _, value := range list.values {
+					compose.WriteString(fmt.Sprintf("      - \"%s\"\n", value))
 				}
 			}
 		}
@@ -415,11 +524,66 @@ func convertPodToDockerCompose(pod *corev1.Pod) string {
 		// 	}
 		// }
 
-		// Restart policy
+		// Security context
+		security := securityOf(pod, container)
+		if user := security.user(); user != "" {
+			compose.WriteString(fmt.Sprintf("    user: \"%s\"\n", user))
+		}
+		if security.privileged {
+			compose.WriteString("    privileged: true\n")
+		}
+		if security.readOnly {
+			compose.WriteString("    read_only: true\n")
+		}
+		if len(security.capAdd) > 0 {
+			compose.WriteString("    cap_add:\n")
+			for _, capability := range security.capAdd {
+				compose.WriteString(fmt.Sprintf("      - %s\n", capability))
+			}
+		}
+		if len(security.capDrop) > 0 {
+			compose.WriteString("    cap_drop:\n")
+			for _, capability := range security.capDrop {
+				compose.WriteString(fmt.Sprintf("      - %s\n", capability))
+			}
+		}
+
+		// Extended resources, e.g. GPUs
+		if devices := cdiDevices(container); len(devices) > 0 {
+			compose.WriteString("    devices:\n")
+			for _, device := range devices {
+				compose.WriteString(fmt.Sprintf("      - \"%s\"\n", device))
+			}
+		}
+
+		// Terminal and stdin, for kubectl attach
+		if container.TTY {
+			compose.WriteString("    tty: true\n")
+		}
+		if container.Stdin {
+			compose.WriteString("    stdin_open: true\n")
+		}
+
+		// Start order and the sidecar health check it waits for
+		if len(service.dependsOn) > 0 {
+			compose.WriteString("    depends_on:\n")
+			for _, dependency := range service.dependsOn {
+				compose.WriteString(fmt.Sprintf("      %s:\n", sanitizeServiceName(dependency.container)))
+				compose.WriteString(fmt.Sprintf("        condition: %s\n", dependency.condition))
+			}
+		}
+		if check, ok := startupCheck(container); ok {
+			compose.WriteString(composeHealthCheck(check))
+		}
+
+		// Restart policy; init containers are retried until they succeed
+		// unless the pod is never restarted, and sidecars always restart
 		restartPolicy := "unless-stopped"
-		if pod.Spec.RestartPolicy == corev1.RestartPolicyNever {
+		switch {
+		case service.sidecar:
+		case pod.Spec.RestartPolicy == corev1.RestartPolicyNever:
 			restartPolicy = "no"
-		} else if pod.Spec.RestartPolicy == corev1.RestartPolicyOnFailure {
+		case pod.Spec.RestartPolicy == corev1.RestartPolicyOnFailure || service.init:
 			restartPolicy = "on-failure"
 		}
 		compose.WriteString(fmt.Sprintf("    restart: %s\n", restartPolicy))
@@ -427,6 +591,17 @@ func convertPodToDockerCompose(pod *corev1.Pod) string {
 		compose.WriteString("\n")
 	}
 
+	// Shared networks have fixed names, so that the applications of all pods
+	// joining one use the same device network
+	if !pod.Spec.HostNetwork {
+		compose.WriteString(" networks:\n")
+		compose.WriteString(fmt.Sprintf("  %s: {}\n", podNetwork))
+		for _, network := range networks {
+			compose.WriteString(fmt.Sprintf("  %s:\n", network))
+			compose.WriteString(fmt.Sprintf("    name: %s%s\n", sharedNetworkPrefix, network))
+		}
+	}
+
 	// Define volumes section if there are any volumes
 	// if len(pod.Spec.Volumes) > 0 {
 	// 	compose.WriteString("volumes:\n")
@@ -455,51 +630,119 @@ func convertPodToDockerCompose(pod *corev1.Pod) string {
 	return compose.String()
 }
 
+// pullPolicy returns the compose pull_policy, which quadlet's Pull= shares,
+// for an imagePullPolicy, or "" if it is unset.
+func pullPolicy(policy corev1.PullPolicy) string {
+	switch policy {
+	case corev1.PullAlways:
+		return "always"
+	case corev1.PullIfNotPresent:
+		return "missing"
+	case corev1.PullNever:
+		return "never"
+	default:
+		return ""
+	}
+}
+
 // sanitizeServiceName converts a Kubernetes container name to a valid Docker Compose service name.
 func sanitizeServiceName(name string) string {
 	// Docker Compose service names should be lowercase alphanumeric with underscores/hyphens
 	return strings.ReplaceAll(strings.ToLower(name), ".", "-")
 }
 
+// publishedPort returns the device port a container port is published on:
+// its hostPort, or the container port itself if none is set.
+func publishedPort(port corev1.ContainerPort) int32 {
+	if port.HostPort > 0 {
+		return port.HostPort
+	}
+	return port.ContainerPort
+}
+
 // sanitizeVolumeName converts a Kubernetes volume name to a valid Docker Compose volume name.
 func sanitizeVolumeName(name string) string {
 	return strings.ReplaceAll(strings.ToLower(name), ".", "-")
 }
 
-// podToFlightctlApplication converts a Kubernetes pod to a FlightCtl Application.
-// Uses the first container's image and creates an application entry.
-func (pm *PodManager) podToFlightctlApplication(pod *corev1.Pod) FlightctlApplication {
-	// Use pod name as application name
-	appName := fmt.Sprintf("%s-%s", pod.Namespace, pod.Name)
-
-	// Use the first container's image (most pods have a single primary container)
-	//image := "docker.io/library/busybox:latest" // default fallback
-	//if len(pod.Spec.Containers) > 0 {
-	//	image = pod.Spec.Containers[0].Image
-	//}
+// podToFlightctlApplication converts a Kubernetes pod to a FlightCtl Application
+// using the translator selected by the pod's flightctl.io/app-type annotation.
+func (pm *PodManager) podToFlightctlApplication(pod *corev1.Pod) (FlightctlApplication, error) {
+	appName := pm.naming.ApplicationName(pod)
 
-	//TODO: STEP2: Convert the pod to a docker compose format and add inline
-	logger.Debug("Creating Inline Content Section")
-	var inlineContent InlineContent
-	var inlineContentArray []InlineContent
+	// Content kept in a repository is referenced rather than translated
+	ref, err := repoRef(pod)
+	if err != nil {
+		return FlightctlApplication{}, err
+	}
+	if ref != nil {
+		appType, err := pm.translators.AppType(pod)
+		if err != nil {
+			return FlightctlApplication{}, fmt.Errorf("selecting app type for pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+		app := FlightctlApplication{
+			Name:    appName,
+			AppType: appType,
+			GitRef:  ref,
+			EnvVars: managedEnvVars(pod),
+		}
+		app.EnvVars[ContentHashEnvVar] = app.computeContentHash()
+		return app, nil
+	}
 
-	inlineContent.Content = convertPodToDockerCompose(pod)
-	inlineContent.Path = "podman-compose.yaml"
-	inlineContentArray = append(inlineContentArray, inlineContent)
+	// Container applications run the image without generated content
+	if isContainerAppPod(pod) {
+		image, err := containerAppImage(pod)
+		if err != nil {
+			return FlightctlApplication{}, err
+		}
+		app := FlightctlApplication{
+			Name:    appName,
+			AppType: AppTypeContainer,
+			Image:   image,
+			EnvVars: managedEnvVars(pod),
+		}
+		app.EnvVars[ContentHashEnvVar] = app.computeContentHash()
+		return app, nil
+	}
 
-	jsonBytes, err := json.MarshalIndent(inlineContent, "", "  ")
+	translator, err := pm.translators.ForPod(pod)
 	if err != nil {
-		logger.Error("Error marshaling: %v", err)
+		return FlightctlApplication{}, fmt.Errorf("selecting translator for pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+
+	logger.Debug("Creating Inline Content Section")
+	var inlineContentArray []InlineContent
+	var appType string
+	if named, ok := translator.(AppPodTranslator); ok {
+		inlineContentArray, appType, err = named.TranslateApp(pod, appName)
 	} else {
-		logger.Debug("PodToCompose:\n%s", string(jsonBytes))
+		inlineContentArray, appType, err = translator.Translate(pod)
+	}
+	if err != nil {
+		return FlightctlApplication{}, fmt.Errorf("translating pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+	if inlineContentArray, err = withInlineFiles(pod, inlineContentArray); err != nil {
+		return FlightctlApplication{}, err
 	}
 
-	return FlightctlApplication{
-		Name: appName,
-		//Image:   image,
-		AppType: "compose", // Default to compose type
+	if logger.Enabled(logger.DebugLevel) {
+		jsonBytes, err := json.MarshalIndent(redactedInline(inlineContentArray, resolvedSecretEnv(pod)), "", "  ")
+		if err != nil {
+			logger.Error("Error marshaling: %v", err)
+		} else {
+			logger.Debug("PodTo%s:\n%s", appType, string(jsonBytes))
+		}
+	}
+
+	app := FlightctlApplication{
+		Name:    appName,
+		AppType: appType,
 		Inline:  inlineContentArray,
+		EnvVars: managedEnvVars(pod),
 	}
+	app.EnvVars[ContentHashEnvVar] = app.computeContentHash()
+	return app, nil
 }
 
 // flightctlStatusToPodStatus maps Flightctl status to Kubernetes pod status.
@@ -520,64 +763,47 @@ func (pm *PodManager) flightctlStatusToPodStatus(status *FlightctlWorkloadStatus
 	}
 }
 
-// FlightctlDevice represents a complete Device resource in Flightctl API format.
-type FlightctlDevice struct {
-	APIVersion string                  `json:"apiVersion"`
-	Kind       string                  `json:"kind"`
-	Metadata   FlightctlDeviceMetadata `json:"metadata"`
-	Spec       FlightctlDeviceSpec     `json:"spec"`
-	Status     *FlightctlDeviceStatus  `json:"status,omitempty"`
-}
-
-// FlightctlDeviceMetadata represents the metadata section of a Device.
-type FlightctlDeviceMetadata struct {
-	Name   string            `json:"name"`
-	Labels map[string]string `json:"labels,omitempty"`
-}
-
-// FlightctlDeviceSpec represents the spec section of a Device.
-type FlightctlDeviceSpec struct {
-	Systemd      *FlightctlSystemdConfig `json:"systemd,omitempty"`
-	Applications []FlightctlApplication  `json:"applications,omitempty"`
-}
-
-// FlightctlDeviceStatus represents the status section of a Device.
-type FlightctlDeviceStatus struct {
-	Applications []FlightctlApplicationStatus `json:"applications,omitempty"`
-	Conditions   []FlightctlCondition         `json:"conditions,omitempty"`
-}
-
 // FlightctlApplicationStatus represents the runtime status of an application on a device.
 type FlightctlApplicationStatus struct {
-	Name    string `json:"name"`              // Application name
-	Status  string `json:"status"`            // running, pending, failed, stopped, starting, etc.
-	Summary string `json:"summary,omitempty"` // Human-readable summary
-}
-
-// FlightctlCondition represents a condition in the Device status.
-type FlightctlCondition struct {
-	Type    string `json:"type"`
-	Status  string `json:"status"`
-	Reason  string `json:"reason,omitempty"`
-	Message string `json:"message,omitempty"`
+	Name     string `json:"name"`               // Application name
+	Status   string `json:"status"`             // running, pending, failed, stopped, starting, etc.
+	Ready    string `json:"ready,omitempty"`    // Ready containers, e.g. "1/2"
+	Restarts int    `json:"restarts,omitempty"` // Container restarts across the application
+	Summary  string `json:"summary,omitempty"`  // Human-readable summary
+
+	// Containers is the per-container status, if the agent reports it
+	Containers []FlightctlContainerStatus `json:"containers,omitempty"`
 }
 
-// FlightctlSystemdConfig represents systemd configuration in Device spec.
-type FlightctlSystemdConfig struct {
-	MatchPatterns []string `json:"matchPatterns,omitempty"`
+// FlightctlContainerStatus represents the status of one container of an
+// application, as reported by agents that report per-container status.
+type FlightctlContainerStatus struct {
+	Name      string `json:"name"`                // Container or compose service name
+	Status    string `json:"status,omitempty"`    // running, exited, created, etc.
+	ExitCode  *int32 `json:"exitCode,omitempty"`  // Exit code of the last run
+	Restarts  int    `json:"restarts,omitempty"`  // Restarts of the container
+	OOMKilled bool   `json:"oomKilled,omitempty"` // Last run was killed for running out of memory
+	Reason    string `json:"reason,omitempty"`    // Reason of the last exit, e.g. OOMKilled
 }
 
 // FlightctlApplication represents an application in the Device applications list.
 type FlightctlApplication struct {
-	Name string `json:"name"`
-	//Image   string          `json:"image"`
-	AppType string          `json:"appType"` // "compose", "pod", etc.
-	Inline  []InlineContent `json:"inline"`
+	Name    string            `json:"name"`
+	Image   string            `json:"image,omitempty"` // Image run by container applications
+	AppType string            `json:"appType"`         // "compose", "pod", etc.
+	Inline  []InlineContent   `json:"inline,omitempty"`
+	GitRef  *GitRef           `json:"gitRef,omitempty"` // Content in a FlightCtl Repository, instead of inline
+	EnvVars map[string]string `json:"envVars,omitempty"`
+
+	// JSON the application was read from, written back if unchanged
+	raw     []byte
+	decoded []byte
 }
 
 type InlineContent struct {
-	Path    string `json:"path"`
-	Content string `json:"content"`
+	Path            string `json:"path"`
+	Content         string `json:"content"`
+	ContentEncoding string `json:"contentEncoding,omitempty"` // ContentEncodingBase64 for binary content
 }
 
 // FlightctlWorkload represents a workload in Flightctl format (deprecated, use Device+Applications instead).
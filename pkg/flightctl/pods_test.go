@@ -1,6 +1,7 @@
 package flightctl
 
 import (
+	"strings"
 	"testing"
 
 	corev1 "k8s.io/api/core/v1"
@@ -192,3 +193,58 @@ func indexOfString(haystack, needle string) int {
 	}
 	return -1
 }
+
+func TestConvertPodToDockerCompose_Terminal(t *testing.T) {
+	// Containers kubectl attach can use need a terminal and open stdin
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "shell", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "shell", Image: "busybox:1.36", TTY: true, Stdin: true},
+				{Name: "app", Image: "myapp:v1.0"},
+			},
+		},
+	}
+
+	composeYAML := convertPodToDockerCompose(pod)
+	if strings.Count(composeYAML, "tty: true") != 1 || strings.Count(composeYAML, "stdin_open: true") != 1 {
+		t.Errorf("expected tty and stdin_open on the shell service only:\n%s", composeYAML)
+	}
+
+	quadlet := quadletContainerUnit(pod, podServices(pod)[0], "default-shell", "default-shell.pod")
+	if !strings.Contains(quadlet, "PodmanArgs=--tty --interactive\n") {
+		t.Errorf("expected podman terminal args in:\n%s", quadlet)
+	}
+}
+
+func TestConvertPodToDockerCompose_HostPorts(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  "nginx",
+				Image: "nginx:1.25",
+				Ports: []corev1.ContainerPort{{ContainerPort: 80, HostPort: 8080}, {ContainerPort: 443}},
+			}},
+		},
+	}
+
+	composeYAML := convertPodToDockerCompose(pod)
+	if !strings.Contains(composeYAML, "- \"8080:80\"\n") || !strings.Contains(composeYAML, "- \"443:443\"\n") {
+		t.Errorf("expected ports published under their host ports:\n%s", composeYAML)
+	}
+	quadlet := quadletPodUnit(pod, "default-web")
+	if !strings.Contains(quadlet, "PublishPort=8080:80\n") || !strings.Contains(quadlet, "PublishPort=443:443\n") {
+		t.Errorf("expected ports published under their host ports:\n%s", quadlet)
+	}
+
+	pod.Spec.HostNetwork = true
+	composeYAML = convertPodToDockerCompose(pod)
+	if !strings.Contains(composeYAML, "    network_mode: host\n") || strings.Contains(composeYAML, "ports:") {
+		t.Errorf("expected a host network service without published ports:\n%s", composeYAML)
+	}
+	quadlet = quadletPodUnit(pod, "default-web")
+	if !strings.Contains(quadlet, "Network=host\n") || strings.Contains(quadlet, "PublishPort") {
+		t.Errorf("expected a host network pod without published ports:\n%s", quadlet)
+	}
+}
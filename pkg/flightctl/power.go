@@ -0,0 +1,61 @@
+package flightctl
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// Battery-powered and thermally constrained devices report their power and
+// thermal state in custom system info (see CPUUsageInfoKey), or declare it
+// with device labels kept up to date by other tooling. Reported info wins
+// over labels.
+const (
+	// BatteryInfoKey reports the device's battery charge in percent, e.g.
+	// "80" or "80%".
+	BatteryInfoKey = "battery"
+	// TemperatureInfoKey reports the device temperature in degrees
+	// Celsius, e.g. "45" or "45C".
+	TemperatureInfoKey = "temperature"
+
+	// BatteryLabel declares the device's battery charge like
+	// BatteryInfoKey. Devices reporting neither are assumed to be mains
+	// powered.
+	BatteryLabel = "battery"
+	// TemperatureLabel declares the device temperature like
+	// TemperatureInfoKey.
+	TemperatureLabel = "temperature"
+)
+
+// powerReading returns the value of a power or thermal reading, from the
+// device's custom system info or else its label, or nil if it has neither.
+func (d *FlightctlDevice) powerReading(infoKey, label string) *float64 {
+	if d.Status != nil {
+		info, _ := d.Status.SystemInfo[customInfoKey].(map[string]interface{})
+		if value, ok := info[infoKey].(string); ok && value != "" {
+			if reading, ok := parseReading(value); ok {
+				return &reading
+			}
+			logger.Warn("Device %s reports invalid %s %q", d.Metadata.Name, infoKey, value)
+		}
+	}
+	if value, ok := d.Metadata.Labels[label]; ok {
+		if reading, ok := parseReading(value); ok {
+			return &reading
+		}
+		logger.Warn("Device %s has invalid %s label %q", d.Metadata.Name, label, value)
+	}
+	return nil
+}
+
+// parseReading parses a numeric reading, ignoring a trailing unit such as
+// "%" or "C".
+func parseReading(value string) (float64, bool) {
+	value = strings.TrimRight(strings.TrimSpace(value), "%Cc° ")
+	reading, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+	return reading, true
+}
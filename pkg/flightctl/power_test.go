@@ -0,0 +1,24 @@
+package flightctl
+
+import "testing"
+
+func TestDevicePowerState(t *testing.T) {
+	device := &FlightctlDevice{
+		Metadata: FlightctlDeviceMetadata{Name: "d1", Labels: map[string]string{BatteryLabel: "90%", TemperatureLabel: "35C"}},
+		Status: &FlightctlDeviceStatus{SystemInfo: map[string]interface{}{
+			customInfoKey: map[string]interface{}{BatteryInfoKey: "15", TemperatureInfoKey: "hot"},
+		}},
+	}
+	health := device.ToModel().Health
+	if health.Battery == nil || *health.Battery != 15 {
+		t.Errorf("battery = %v, want the reported 15%%", health.Battery)
+	}
+	if health.Temperature == nil || *health.Temperature != 35 {
+		t.Errorf("temperature = %v, want the labelled 35°C instead of the invalid report", health.Temperature)
+	}
+
+	health = (&FlightctlDevice{Metadata: FlightctlDeviceMetadata{Name: "d2"}}).ToModel().Health
+	if health.Battery != nil || health.Temperature != nil {
+		t.Errorf("battery = %v, temperature = %v, want neither for a device reporting none", health.Battery, health.Temperature)
+	}
+}
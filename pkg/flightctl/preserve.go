@@ -0,0 +1,154 @@
+package flightctl
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Devices and fleet templates are updated by read-modify-write, so the parts
+// of a device or fleet the client does not model (other spec sections, server
+// metadata, applications configured by fleet templates or by hand) must be
+// written back exactly as they were read.
+
+// unknownFields holds the JSON fields of an object the client does not model.
+type unknownFields map[string]json.RawMessage
+
+// collectUnknownFields returns the fields of the JSON object data that are
+// not in known.
+func collectUnknownFields(data []byte, known ...string) (unknownFields, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for _, name := range known {
+		delete(fields, name)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return unknownFields(fields), nil
+}
+
+// marshal encodes v, a struct with the known fields, and adds the unknown
+// fields to the resulting object.
+func (u unknownFields) marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(u) == 0 {
+		return data, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range u {
+		if _, ok := fields[name]; !ok {
+			fields[name] = value
+		}
+	}
+	return json.Marshal(fields)
+}
+
+type plainDeviceMetadata FlightctlDeviceMetadata
+
+// UnmarshalJSON keeps the metadata fields the client does not model.
+func (m *FlightctlDeviceMetadata) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*plainDeviceMetadata)(m)); err != nil {
+		return err
+	}
+	unknown, err := collectUnknownFields(data, "name", "labels", "annotations", "owner", "creationTimestamp", "resourceVersion")
+	m.unknown = unknown
+	return err
+}
+
+// MarshalJSON writes the metadata back with the fields the client does not
+// model.
+func (m FlightctlDeviceMetadata) MarshalJSON() ([]byte, error) {
+	return m.unknown.marshal(plainDeviceMetadata(m))
+}
+
+type plainDeviceSpec FlightctlDeviceSpec
+
+// UnmarshalJSON keeps the spec sections the client does not model.
+func (s *FlightctlDeviceSpec) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*plainDeviceSpec)(s)); err != nil {
+		return err
+	}
+	unknown, err := collectUnknownFields(data, "systemd", "applications")
+	s.unknown = unknown
+	return err
+}
+
+// MarshalJSON writes the spec back with the sections the client does not
+// model.
+func (s FlightctlDeviceSpec) MarshalJSON() ([]byte, error) {
+	return s.unknown.marshal(plainDeviceSpec(s))
+}
+
+type plainFleetMetadata FlightctlFleetMetadata
+
+// UnmarshalJSON keeps the metadata fields the client does not model.
+func (m *FlightctlFleetMetadata) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*plainFleetMetadata)(m)); err != nil {
+		return err
+	}
+	unknown, err := collectUnknownFields(data, "name", "labels", "annotations", "creationTimestamp", "resourceVersion")
+	m.unknown = unknown
+	return err
+}
+
+// MarshalJSON writes the metadata back with the fields the client does not
+// model.
+func (m FlightctlFleetMetadata) MarshalJSON() ([]byte, error) {
+	return m.unknown.marshal(plainFleetMetadata(m))
+}
+
+type plainFleetSpec FlightctlFleetSpec
+
+// UnmarshalJSON keeps the spec sections the client does not model.
+func (s *FlightctlFleetSpec) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*plainFleetSpec)(s)); err != nil {
+		return err
+	}
+	unknown, err := collectUnknownFields(data, "selector", "template", "rolloutPolicy")
+	s.unknown = unknown
+	return err
+}
+
+// MarshalJSON writes the spec back with the sections the client does not
+// model.
+func (s FlightctlFleetSpec) MarshalJSON() ([]byte, error) {
+	return s.unknown.marshal(plainFleetSpec(s))
+}
+
+type plainApplication FlightctlApplication
+
+// UnmarshalJSON keeps the application's JSON, so it is written back as read
+// (field order and values intact; encoding/json only drops insignificant
+// whitespace) unless it was changed.
+func (a *FlightctlApplication) UnmarshalJSON(data []byte) error {
+	var plain plainApplication
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return err
+	}
+	decoded, err := json.Marshal(plain)
+	if err != nil {
+		return err
+	}
+	*a = FlightctlApplication(plain)
+	a.raw = append(json.RawMessage(nil), data...)
+	a.decoded = decoded
+	return nil
+}
+
+// MarshalJSON returns the JSON the application was read from if it is
+// unchanged.
+func (a FlightctlApplication) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(plainApplication(a))
+	if err != nil {
+		return nil, err
+	}
+	if a.raw != nil && bytes.Equal(data, a.decoded) {
+		return a.raw, nil
+	}
+	return data, nil
+}
@@ -0,0 +1,58 @@
+package flightctl
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const sharedDeviceJSON = `{
+  "apiVersion": "v1alpha1",
+  "kind": "Device",
+  "metadata": {"name": "device-1", "resourceVersion": "7", "generation": 3, "owner": "Fleet/edge"},
+  "spec": {
+    "os": {"image": "quay.io/edge/os:1.2"},
+    "config": [{"name": "motd", "inline": [{"path": "/etc/motd", "content": "hi"}]}],
+    "applications": [
+      {"name":"fleet-agent",  "appType":"compose", "image": "quay.io/edge/agent:1", "volumes": [{"name": "data"}]},
+      {"name": "default-web", "appType": "compose", "inline": [{"path": "podman-compose.yaml", "content": "old"}],
+       "envVars": {"VK_FLIGHTCTL_MANAGED_BY": "vk-flightctl-provider"}}
+    ]
+  }
+}`
+
+func TestDeviceRoundTripPreservesUnmodelledFields(t *testing.T) {
+	var device FlightctlDevice
+	if err := json.Unmarshal([]byte(sharedDeviceJSON), &device); err != nil {
+		t.Fatal(err)
+	}
+	if device.Metadata.ResourceVersion != "7" {
+		t.Errorf("resourceVersion = %q, want 7", device.Metadata.ResourceVersion)
+	}
+	if device.Spec.Applications[0].IsManaged() || !device.Spec.Applications[1].IsManaged() {
+		t.Errorf("managed = %v/%v, want false/true",
+			device.Spec.Applications[0].IsManaged(), device.Spec.Applications[1].IsManaged())
+	}
+
+	device.Spec.Applications[1].Inline[0].Content = "new"
+	data, err := json.Marshal(&device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+
+	for _, want := range []string{
+		`{"name":"fleet-agent","appType":"compose","image":"quay.io/edge/agent:1","volumes":[{"name":"data"}]}`,
+		`"os":{"image":"quay.io/edge/os:1.2"}`,
+		`"generation":3`,
+		`"resourceVersion":"7"`,
+		`"content":"new"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("marshaled device does not contain %s:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `"old"`) {
+		t.Errorf("changed application written back with its old content:\n%s", out)
+	}
+}
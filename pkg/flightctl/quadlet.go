@@ -0,0 +1,318 @@
+package flightctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// convertPodToQuadlet converts a Kubernetes Pod to systemd quadlet units.
+// A single .pod unit groups the containers (and owns the published ports),
+// and one .container unit is emitted per pod container and init container,
+// plus a .network unit per shared network the pod joins.
+func convertPodToQuadlet(pod *corev1.Pod, appName string) []InlineContent {
+	if pod == nil || len(pod.Spec.Containers) == 0 {
+		return nil
+	}
+
+	podUnit := appName + ".pod"
+	units := []InlineContent{
+		{
+			Path:    podUnit,
+			Content: quadletPodUnit(pod, appName),
+		},
+	}
+
+	for _, service := range podServices(pod) {
+		units = append(units, InlineContent{
+			Path:    fmt.Sprintf("%s-%s.container", appName, sanitizeServiceName(service.container.Name)),
+			Content: quadletContainerUnit(pod, service, appName, podUnit),
+		})
+	}
+
+	// Env files and hook wrappers are delivered next to the units that
+	// read them; invalid env files are rejected by the translator
+	envFiles, _ := envFromFiles(pod, appName+"-", podmanEnvFileValue)
+	units = append(units, envFiles...)
+	units = append(units, hookWrapperFiles(pod, appName+"-")...)
+
+	// Each application defines the shared networks it uses; units of
+	// several applications naming the same network create it once
+	networks, _ := sharedNetworks(pod)
+	for _, network := range networks {
+		units = append(units, InlineContent{
+			Path:    quadletNetworkUnit(appName, network),
+			Content: fmt.Sprintf("[Network]\nNetworkName=%s%s\n", sharedNetworkPrefix, network),
+		})
+	}
+
+	return units
+}
+
+// quadletNetworkUnit returns the name of an application's unit for a shared
+// network.
+func quadletNetworkUnit(appName, network string) string {
+	return fmt.Sprintf("%s-%s.network", appName, network)
+}
+
+// quadletPodUnit renders the .pod unit for a pod.
+func quadletPodUnit(pod *corev1.Pod, appName string) string {
+	var unit strings.Builder
+	unit.WriteString("[Unit]\n")
+	unit.WriteString(fmt.Sprintf("Description=Pod %s/%s\n", pod.Namespace, pod.Name))
+	unit.WriteString("\n[Pod]\n")
+	unit.WriteString(fmt.Sprintf("PodName=%s\n", appName))
+
+	// Resolver settings and /etc/hosts entries are shared by the containers
+	dns := dnsOf(pod)
+	for _, host := range dns.hosts {
+		unit.WriteString(fmt.Sprintf("AddHost=%s\n", host))
+	}
+
+	// In quadlet, the network and published ports belong to the pod rather
+	// than to member containers
+	if pod.Spec.HostNetwork {
+		unit.WriteString("Network=host\n")
+		return unit.String()
+	}
+	for _, container := range podContainers(pod) {
+		for _, port := range container.Ports {
+			if port.ContainerPort > 0 {
+				unit.WriteString(fmt.Sprintf("PublishPort=%d:%d\n", publishedPort(port), port.ContainerPort))
+			}
+		}
+	}
+	for _, nameserver := range dns.nameservers {
+		unit.WriteString(fmt.Sprintf("DNS=%s\n", nameserver))
+	}
+	for _, search := range dns.searches {
+		unit.WriteString(fmt.Sprintf("DNSSearch=%s\n", search))
+	}
+	for _, option := range dns.options {
+		unit.WriteString(fmt.Sprintf("DNSOption=%s\n", option))
+	}
+
+	// Joining shared networks replaces the pod's default network; its
+	// containers still share the pod's network namespace
+	networks, _ := sharedNetworks(pod)
+	for _, network := range networks {
+		unit.WriteString(fmt.Sprintf("Network=%s\n", quadletNetworkUnit(appName, network)))
+	}
+	if len(networks) > 0 {
+		for _, service := range podServices(pod) {
+			for _, alias := range service.aliases {
+				unit.WriteString(fmt.Sprintf("NetworkAlias=%s\n", alias))
+			}
+		}
+	}
+
+	return unit.String()
+}
+
+// quadletContainerUnit renders the .container unit for a single container.
+// Units start after the init containers before them, requiring those that
+// run to completion; init container units are oneshot services, which
+// systemd considers started once they complete.
+func quadletContainerUnit(pod *corev1.Pod, service podService, appName, podUnit string) string {
+	container := service.container
+	var unit strings.Builder
+	unit.WriteString("[Unit]\n")
+	unit.WriteString(fmt.Sprintf("Description=Container %s of pod %s/%s\n", container.Name, pod.Namespace, pod.Name))
+	for _, dependency := range service.dependsOn {
+		dependencyUnit := fmt.Sprintf("%s-%s.service", appName, sanitizeServiceName(dependency.container))
+		if dependency.condition == conditionCompleted {
+			unit.WriteString(fmt.Sprintf("Requires=%s\n", dependencyUnit))
+		} else {
+			unit.WriteString(fmt.Sprintf("Wants=%s\n", dependencyUnit))
+		}
+		unit.WriteString(fmt.Sprintf("After=%s\n", dependencyUnit))
+	}
+
+	unit.WriteString("\n[Container]\n")
+	unit.WriteString(fmt.Sprintf("ContainerName=%s-%s\n", appName, sanitizeServiceName(container.Name)))
+	unit.WriteString(fmt.Sprintf("Image=%s\n", container.Image))
+	if policy := pullPolicy(container.ImagePullPolicy); policy != "" {
+		unit.WriteString(fmt.Sprintf("Pull=%s\n", policy))
+	}
+	unit.WriteString(fmt.Sprintf("Pod=%s\n", podUnit))
+
+	// Command (entrypoint override); containers with lifecycle hooks run it
+	// through their hook wrapper, which is given as the first argument
+	entrypoint, args := hookWrappedCommand(container)
+	if wrapsHooks(container) {
+		entrypoint, args = entrypoint[:1], append(entrypoint[1:], args...)
+	}
+	if len(entrypoint) > 0 {
+		unit.WriteString(fmt.Sprintf("Entrypoint=%s\n", quadletEntrypoint(entrypoint)))
+	}
+
+	// Args
+	if len(args) > 0 {
+		unit.WriteString(fmt.Sprintf("Exec=%s\n", quoteQuadletArgs(args)))
+	}
+	if wrapsHooks(container) {
+		// Relative sources resolve from the directory of the unit
+		unit.WriteString(fmt.Sprintf("Volume=./%s:%s:ro\n", hookWrapperFile(appName+"-", container), hookWrapperPath(container)))
+	}
+
+	// Environment variables: the envFrom env files, overridden by direct
+	// values and downward API fields
+	envFrom, _ := envFromOf(pod)
+	for _, file := range envFromFileNames(envFrom, appName+"-", container) {
+		unit.WriteString(fmt.Sprintf("EnvironmentFile=./%s\n", file))
+	}
+	for _, env := range container.Env {
+		if value, ok := envValue(pod, env); ok {
+			unit.WriteString(fmt.Sprintf("Environment=%s=%s\n", env.Name, quoteQuadletValue(value)))
+		}
+	}
+
+	// Security context
+	security := securityOf(pod, container)
+	if security.runAsUser != nil {
+		unit.WriteString(fmt.Sprintf("User=%d\n", *security.runAsUser))
+		if security.runAsGroup != nil {
+			unit.WriteString(fmt.Sprintf("Group=%d\n", *security.runAsGroup))
+		}
+	}
+	if security.readOnly {
+		unit.WriteString("ReadOnly=true\n")
+	}
+	if len(security.capAdd) > 0 {
+		unit.WriteString(fmt.Sprintf("AddCapability=%s\n", strings.Join(security.capAdd, " ")))
+	}
+	if len(security.capDrop) > 0 {
+		unit.WriteString(fmt.Sprintf("DropCapability=%s\n", strings.Join(security.capDrop, " ")))
+	}
+	for _, device := range cdiDevices(container) {
+		unit.WriteString(fmt.Sprintf("AddDevice=%s\n", device))
+	}
+
+	// Terminal and stdin, for kubectl attach, and privileged mode
+	var opts []string
+	if container.TTY {
+		opts = append(opts, "--tty")
+	}
+	if container.Stdin {
+		opts = append(opts, "--interactive")
+	}
+	if security.privileged {
+		opts = append(opts, "--privileged")
+	}
+	if len(opts) > 0 {
+		unit.WriteString(fmt.Sprintf("PodmanArgs=%s\n", strings.Join(opts, " ")))
+	}
+
+	// A sidecar's unit is started once its startup check passes
+	if check, ok := startupCheck(container); ok {
+		unit.WriteString(fmt.Sprintf("HealthCmd=%s\n", quoteQuadletArgs(check.command)))
+		for _, setting := range []struct {
+			key     string
+			seconds int32
+		}{
+			{"HealthInterval", check.interval},
+			{"HealthTimeout", check.timeout},
+			{"HealthStartPeriod", check.startPeriod},
+		} {
+			if setting.seconds > 0 {
+				unit.WriteString(fmt.Sprintf("%s=%ds\n", setting.key, setting.seconds))
+			}
+		}
+		if check.retries > 0 {
+			unit.WriteString(fmt.Sprintf("HealthRetries=%d\n", check.retries))
+		}
+		unit.WriteString("Notify=healthy\n")
+	}
+
+	unit.WriteString("\n[Service]\n")
+	switch {
+	case service.init:
+		unit.WriteString("Type=oneshot\n")
+		unit.WriteString("RemainAfterExit=yes\n")
+		restart := "on-failure"
+		if pod.Spec.RestartPolicy == corev1.RestartPolicyNever {
+			restart = "no"
+		}
+		unit.WriteString(fmt.Sprintf("Restart=%s\n", restart))
+	case service.sidecar:
+		unit.WriteString("Restart=always\n")
+	default:
+		unit.WriteString(fmt.Sprintf("Restart=%s\n", quadletRestartPolicy(pod.Spec.RestartPolicy)))
+	}
+
+	unit.WriteString("\n[Install]\n")
+	unit.WriteString("WantedBy=default.target\n")
+
+	return unit.String()
+}
+
+// quadletRestartPolicy maps a pod restart policy to a systemd Restart= value.
+func quadletRestartPolicy(policy corev1.RestartPolicy) string {
+	switch policy {
+	case corev1.RestartPolicyNever:
+		return "no"
+	case corev1.RestartPolicyOnFailure:
+		return "on-failure"
+	default:
+		return "always"
+	}
+}
+
+// quadletEntrypoint renders an Entrypoint= value. Quadlet hands it to
+// podman --entrypoint unchanged, which takes a command with arguments as a
+// JSON array.
+func quadletEntrypoint(entrypoint []string) string {
+	if len(entrypoint) == 1 {
+		return entrypoint[0]
+	}
+	data, _ := json.Marshal(entrypoint)
+	return string(data)
+}
+
+// quoteQuadletArgs joins arguments, quoting any that need it.
+func quoteQuadletArgs(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, quoteQuadletValue(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// quoteQuadletValue quotes a value for a systemd unit file if required:
+// values that are empty or contain whitespace, quotes, backslashes or
+// control characters are double-quoted with the C-style escapes systemd
+// understands. Percent signs are doubled so systemd does not expand them as
+// specifiers.
+func quoteQuadletValue(value string) string {
+	value = strings.ReplaceAll(value, "%", "%%")
+	if value != "" && !strings.ContainsFunc(value, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune(`"'\`, r)
+	}) {
+		return value
+	}
+
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for _, r := range value {
+		switch {
+		case r == '"' || r == '\\':
+			quoted.WriteByte('\\')
+			quoted.WriteRune(r)
+		case r == '\n':
+			quoted.WriteString(`\n`)
+		case r == '\t':
+			quoted.WriteString(`\t`)
+		case r == '\r':
+			quoted.WriteString(`\r`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&quoted, `\x%02x`, r)
+		default:
+			quoted.WriteRune(r)
+		}
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
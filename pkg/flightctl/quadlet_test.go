@@ -0,0 +1,90 @@
+package flightctl
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// quadletContainerUnitFor renders a pod with a single container and returns
+// its .container unit.
+func quadletContainerUnitFor(t *testing.T, container corev1.Container) string {
+	t.Helper()
+	container.Name, container.Image = "app", "app:1"
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{container}},
+	}
+	for _, unit := range convertPodToQuadlet(pod, "default-web") {
+		if strings.HasSuffix(unit.Path, ".container") {
+			return unit.Content
+		}
+	}
+	t.Fatal("no container unit rendered")
+	return ""
+}
+
+func TestQuadletEntrypoint(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		command []string
+		want    string
+	}{
+		{"single", []string{"/bin/sh"}, "Entrypoint=/bin/sh\n"},
+		{"with arguments", []string{"sh", "-c"}, `Entrypoint=["sh","-c"]` + "\n"},
+		{"argument with spaces", []string{"/entry", "a b"}, `Entrypoint=["/entry","a b"]` + "\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			unit := quadletContainerUnitFor(t, corev1.Container{Command: tc.command})
+			if !strings.Contains(unit, tc.want) {
+				t.Errorf("unit does not contain %q:\n%s", tc.want, unit)
+			}
+		})
+	}
+}
+
+func TestQuadletExecQuoting(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"plain", []string{"-g", "daemon"}, "Exec=-g daemon\n"},
+		{"spaces", []string{"-g", "daemon off;"}, `Exec=-g "daemon off;"` + "\n"},
+		{"quotes and backslashes", []string{`say "hi"`, `C:\dir`}, `Exec="say \"hi\"" "C:\\dir"` + "\n"},
+		{"empty argument", []string{"", "x"}, `Exec="" x` + "\n"},
+		{"percent", []string{"100%"}, "Exec=100%%\n"},
+		{"control characters", []string{"a\x01b"}, `Exec="a\x01b"` + "\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			unit := quadletContainerUnitFor(t, corev1.Container{Args: tc.args})
+			if !strings.Contains(unit, tc.want) {
+				t.Errorf("unit does not contain %q:\n%s", tc.want, unit)
+			}
+		})
+	}
+}
+
+func TestQuadletEnvironmentQuoting(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"plain", "edge", "Environment=MODE=edge\n"},
+		{"spaces", "level: debug", `Environment=MODE="level: debug"` + "\n"},
+		{"newline and tab", "a\nb\tc", `Environment=MODE="a\nb\tc"` + "\n"},
+		{"non-ASCII", "café au lait", `Environment=MODE="café au lait"` + "\n"},
+		{"single quote", "it's", `Environment=MODE="it's"` + "\n"},
+		{"percent", "50%", "Environment=MODE=50%%\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			unit := quadletContainerUnitFor(t, corev1.Container{Env: []corev1.EnvVar{{Name: "MODE", Value: tc.value}}})
+			if !strings.Contains(unit, tc.want) {
+				t.Errorf("unit does not contain %q:\n%s", tc.want, unit)
+			}
+		})
+	}
+}
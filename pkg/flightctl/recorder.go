@@ -0,0 +1,263 @@
+package flightctl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/redact"
+)
+
+// FlightCtl API traffic can be recorded to a file and replayed from it, so
+// a bug report can carry the exchanges that led to it, and tests can run
+// against captured API behavior. A recording holds one JSON exchange per
+// line. Credentials are never recorded: the requests' headers and the token
+// requests are left out, and secret env values, inline files and fields are
+// masked in the bodies like in the logs. Console sessions are not recorded.
+
+// recordedExchange is a request and the response it got.
+type recordedExchange struct {
+	Time            time.Time         `json:"time"`
+	Method          string            `json:"method"`
+	URL             string            `json:"url"` // Path and query
+	RequestBody     string            `json:"requestBody,omitempty"`
+	StatusCode      int               `json:"status,omitempty"`
+	Header          map[string]string `json:"header,omitempty"`
+	ResponseBody    string            `json:"responseBody,omitempty"`
+	Error           string            `json:"error,omitempty"` // Transport error instead of a response
+	DurationSeconds float64           `json:"durationSeconds"`
+}
+
+// recordedHeaders are the response headers kept in a recording.
+var recordedHeaders = []string{"Content-Type", "Retry-After"}
+
+// recordingTransport appends the exchanges passing through it to a file.
+type recordingTransport struct {
+	base http.RoundTripper
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newRecordingTransport(path string, base http.RoundTripper) (*recordingTransport, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening FlightCtl recording: %w", err)
+	}
+	return &recordingTransport{base: base, file: file}, nil
+}
+
+// RoundTrip implements http.RoundTripper interface.
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	exchange := recordedExchange{Time: time.Now(), Method: req.Method, URL: req.URL.RequestURI()}
+	if req.Body != nil && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(body)
+			body.Close()
+			exchange.RequestBody = sanitizeRecordedBody(data)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		exchange.Error = err.Error()
+	} else {
+		data, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		body := io.Reader(bytes.NewReader(data))
+		if readErr != nil {
+			body = io.MultiReader(body, errReader{readErr})
+		}
+		resp.Body = io.NopCloser(body)
+		exchange.StatusCode = resp.StatusCode
+		exchange.ResponseBody = sanitizeRecordedBody(data)
+		for _, name := range recordedHeaders {
+			if value := resp.Header.Get(name); value != "" {
+				if exchange.Header == nil {
+					exchange.Header = make(map[string]string)
+				}
+				exchange.Header[name] = value
+			}
+		}
+	}
+	exchange.DurationSeconds = time.Since(exchange.Time).Seconds()
+	t.write(exchange)
+	return resp, err
+}
+
+func (t *recordingTransport) write(exchange recordedExchange) {
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file == nil {
+		return
+	}
+	_, _ = t.file.Write(append(line, '\n'))
+}
+
+// close stops recording.
+func (t *recordingTransport) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	t.file = nil
+	return err
+}
+
+// CloseIdleConnections closes idle connections of the base transport.
+func (t *recordingTransport) CloseIdleConnections() {
+	closeIdleConnections(t.base)
+}
+
+// errReader returns its error once the recorded part of a body was read.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// replayTransport answers requests from a recording. The exchanges of a
+// method and URL are replayed in the recorded order, and the last one is
+// repeated once they are used up, so polling continues past the end of the
+// recording. Requests that were not recorded fail.
+type replayTransport struct {
+	mu        sync.Mutex
+	exchanges map[string][]recordedExchange // method and URL -> exchanges left
+}
+
+func newReplayTransport(path string) (*replayTransport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening FlightCtl recording: %w", err)
+	}
+	defer file.Close()
+
+	t := &replayTransport{exchanges: make(map[string][]recordedExchange)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var exchange recordedExchange
+		if err := json.Unmarshal(scanner.Bytes(), &exchange); err != nil {
+			return nil, fmt.Errorf("parsing FlightCtl recording %s line %d: %w", path, line, err)
+		}
+		key := exchange.Method + " " + exchange.URL
+		t.exchanges[key] = append(t.exchanges[key], exchange)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading FlightCtl recording %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// RoundTrip implements http.RoundTripper interface.
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_, _ = io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+
+	key := req.Method + " " + req.URL.RequestURI()
+	t.mu.Lock()
+	exchanges := t.exchanges[key]
+	if len(exchanges) == 0 {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("no recorded response for %s", key)
+	}
+	exchange := exchanges[0]
+	if len(exchanges) > 1 {
+		t.exchanges[key] = exchanges[1:]
+	}
+	t.mu.Unlock()
+
+	if exchange.Error != "" {
+		return nil, fmt.Errorf("recorded error: %s", exchange.Error)
+	}
+	header := make(http.Header)
+	for name, value := range exchange.Header {
+		header.Set(name, value)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.StatusCode, http.StatusText(exchange.StatusCode)),
+		StatusCode:    exchange.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(exchange.ResponseBody)),
+		ContentLength: int64(len(exchange.ResponseBody)),
+		Request:       req,
+	}, nil
+}
+
+// sanitizeRecordedBody masks the secrets in a JSON body: secret env values
+// and inline files of applications, and string fields with a secret name,
+// such as a repository's password. Bodies that are not JSON are recorded
+// as they are.
+func sanitizeRecordedBody(data []byte) string {
+	// Numbers are kept as they are, rather than converted to float64
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var body interface{}
+	if err := dec.Decode(&body); err != nil || dec.More() {
+		return string(data)
+	}
+	sanitized, err := json.Marshal(sanitizeRecordedValue(body))
+	if err != nil {
+		return string(data)
+	}
+	return string(sanitized)
+}
+
+func sanitizeRecordedValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for name, field := range value {
+			switch field := field.(type) {
+			case string:
+				if redact.SecretEnv(name) {
+					value[name] = redact.Mask
+				}
+			case map[string]interface{}:
+				if name == "envVars" {
+					for env, envValue := range field {
+						if s, ok := envValue.(string); ok {
+							field[env] = redact.Env(env, s)
+						}
+					}
+					continue
+				}
+				sanitizeRecordedValue(field)
+			default:
+				sanitizeRecordedValue(field)
+			}
+		}
+		// Inline file of an application
+		if path, ok := value["path"].(string); ok {
+			if content, ok := value["content"].(string); ok {
+				value["content"] = redact.Text(content)
+				if redact.SecretFile(path) || value["contentEncoding"] == ContentEncodingBase64 {
+					value["content"] = redact.Mask
+				}
+			}
+		}
+	case []interface{}:
+		for _, item := range value {
+			sanitizeRecordedValue(item)
+		}
+	}
+	return value
+}
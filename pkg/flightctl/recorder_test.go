@@ -0,0 +1,86 @@
+package flightctl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/devices/dev-1" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"metadata":{"name":"dev-1","generation":12345678901234567},"spec":{"applications":[{
+			"name":"web","appType":"compose","envVars":{"DB_PASSWORD":"hunter2","MODE":"prod"},
+			"inline":[{"path":"secrets/db.env","content":"x"},{"path":"compose.yaml","content":"API_TOKEN=abc\nPORT=80\n"}]}]}}`))
+	}))
+	defer srv.Close()
+	recording := filepath.Join(t.TempDir(), "flightctl.jsonl")
+	ctx := context.Background()
+
+	client, err := NewClient(Config{APIURL: srv.URL, AuthMode: AuthModeToken, Token: "s3cr3t-token", RecordFile: recording})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	device, err := client.GetDevice(ctx, "dev-1")
+	if err != nil {
+		t.Fatalf("GetDevice: %v", err)
+	}
+	if device.Spec.Applications[0].EnvVars["DB_PASSWORD"] != "hunter2" {
+		t.Error("recording changed the response seen by the client")
+	}
+	if _, err := client.GetDevice(ctx, "dev-2"); err == nil {
+		t.Error("expected an error reading an unknown device")
+	}
+	client.Close()
+
+	data, err := os.ReadFile(recording)
+	if err != nil {
+		t.Fatalf("reading recording: %v", err)
+	}
+	for _, secret := range []string{"s3cr3t-token", "hunter2", "abc"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("recording contains %q:\n%s", secret, data)
+		}
+	}
+	for _, kept := range []string{"prod", "PORT=80", "12345678901234567"} {
+		if !strings.Contains(string(data), kept) {
+			t.Errorf("recording misses %q:\n%s", kept, data)
+		}
+	}
+
+	// The replay serves the recorded responses, without a server or
+	// credentials, and repeats the last one
+	replay, err := NewClient(Config{APIURL: "http://flightctl.invalid", ReplayFile: recording})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer replay.Close()
+	for i := 0; i < 2; i++ {
+		device, err := replay.GetDevice(ctx, "dev-1")
+		if err != nil {
+			t.Fatalf("replayed GetDevice: %v", err)
+		}
+		if device.Metadata.Name != "dev-1" || device.Spec.Applications[0].EnvVars["MODE"] != "prod" {
+			t.Errorf("replayed device = %+v", device)
+		}
+	}
+	if _, err := replay.GetDevice(ctx, "dev-2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("replayed unknown device: err = %v, want not found", err)
+	}
+	if _, err := replay.GetDevice(WithoutRetry(ctx), "dev-3"); err == nil || !strings.Contains(err.Error(), "no recorded response") {
+		t.Errorf("unrecorded request: err = %v", err)
+	}
+
+	if _, err := NewClient(Config{APIURL: srv.URL, RecordFile: recording, ReplayFile: recording}); err == nil {
+		t.Error("expected an error recording and replaying at once")
+	}
+}
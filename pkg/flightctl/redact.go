@@ -0,0 +1,110 @@
+package flightctl
+
+import (
+	"encoding/json"
+	"maps"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/redact"
+)
+
+// SecretEnvAnnotation lists the env variables of the pod's containers that
+// the provider set from Secret keys, comma-separated, so that their values
+// are masked wherever the application is shown, whatever their name. It is
+// set by the provider on the copy of the pod it deploys, never by users.
+const SecretEnvAnnotation = "flightctl.io/resolved-secret-env"
+
+// SetSecretEnv lists the variables set from Secret keys in the pod's
+// SecretEnvAnnotation.
+func SetSecretEnv(pod *corev1.Pod, names []string) {
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[SecretEnvAnnotation] = strings.Join(names, ",")
+}
+
+// resolvedSecretEnv returns the variables of the pod's containers the
+// provider resolved from Secrets: those set from Secret keys and those of
+// envFrom Secrets.
+func resolvedSecretEnv(pod *corev1.Pod) map[string]bool {
+	names := make(map[string]bool)
+	for _, name := range strings.Split(pod.Annotations[SecretEnvAnnotation], ",") {
+		if name != "" {
+			names[name] = true
+		}
+	}
+	// An invalid annotation fails the translation, before anything is shown
+	envFrom, _ := envFromOf(pod)
+	for _, vars := range envFrom {
+		for name := range vars.Secrets {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// redactedInline returns a copy of inline content for logging: files with a
+// secret path, env files of envFrom Secrets and base64-encoded files are
+// masked entirely, and the values of secret env variables and of those in
+// names set in the other files are masked.
+func redactedInline(inline []InlineContent, names map[string]bool) []InlineContent {
+	if inline == nil {
+		return nil
+	}
+	out := make([]InlineContent, len(inline))
+	for i, content := range inline {
+		out[i] = InlineContent{Path: content.Path, Content: redact.TextWith(content.Content, names), ContentEncoding: content.ContentEncoding}
+		if redact.SecretFile(content.Path) || strings.HasSuffix(content.Path, secretEnvFileSuffix) || content.IsBase64() {
+			out[i].Content = redact.Mask
+		}
+	}
+	return out
+}
+
+// redactedApplication returns a copy of the application for logging, with
+// the values of secret env variables and of those in names, and inline
+// contents, masked.
+func redactedApplication(app FlightctlApplication, names map[string]bool) FlightctlApplication {
+	out := app
+	out.Inline = redactedInline(app.Inline, names)
+	if app.EnvVars != nil {
+		out.EnvVars = maps.Clone(app.EnvVars)
+		for name, value := range out.EnvVars {
+			if names[name] && value != "" {
+				value = redact.Mask
+			}
+			out.EnvVars[name] = redact.Env(name, value)
+		}
+	}
+	return out
+}
+
+// Redacted returns a copy of the application with its secrets masked like
+// in the logs, to show to users.
+func (a FlightctlApplication) Redacted() FlightctlApplication {
+	return redactedApplication(a, nil)
+}
+
+// RedactedFor returns a copy of the application translated from the pod
+// with its secrets masked like Redacted, and also every value the provider
+// resolved from the pod's Secrets, whatever the names of its variables.
+func (a FlightctlApplication) RedactedFor(pod *corev1.Pod) FlightctlApplication {
+	return redactedApplication(a, resolvedSecretEnv(pod))
+}
+
+// redactedDevicePayload returns the device JSON for logging, with the
+// applications redacted.
+func redactedDevicePayload(device *FlightctlDevice) string {
+	redacted := *device
+	redacted.Spec.Applications = make([]FlightctlApplication, len(device.Spec.Applications))
+	for i, app := range device.Spec.Applications {
+		redacted.Spec.Applications[i] = redactedApplication(app, nil)
+	}
+	data, err := json.Marshal(&redacted)
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+	return string(data)
+}
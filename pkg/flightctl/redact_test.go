@@ -0,0 +1,65 @@
+package flightctl
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+func TestUpdateDeviceRedactsLoggedPayload(t *testing.T) {
+	var sent []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := new(bytes.Buffer)
+		_, _ = buf.ReadFrom(r.Body)
+		sent = buf.Bytes()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	logger.SetLevel(logger.DebugLevel)
+	defer func() {
+		logger.SetLevel(logger.InfoLevel)
+		logger.SetOutput(os.Stdout)
+	}()
+
+	device := &FlightctlDevice{
+		Metadata: FlightctlDeviceMetadata{Name: "dev-1"},
+		Spec: FlightctlDeviceSpec{Applications: []FlightctlApplication{{
+			Name:    "web",
+			AppType: "compose",
+			Inline: []InlineContent{
+				{Path: "docker-compose.yaml", Content: "services:\n  web:\n    environment:\n      - DB_PASSWORD=hunter2\n"},
+				{Path: "secrets/tls.key", Content: "PRIVATE KEY MATERIAL"},
+			},
+			EnvVars: map[string]string{"API_TOKEN": "abc123", "MODE": "prod"},
+		}}},
+	}
+
+	c := &Client{httpClient: srv.Client(), baseURL: srv.URL}
+	if err := c.UpdateDevice(context.Background(), "dev-1", device); err != nil {
+		t.Fatalf("UpdateDevice: %v", err)
+	}
+
+	for _, secret := range []string{"hunter2", "PRIVATE KEY MATERIAL", "abc123"} {
+		if strings.Contains(logs.String(), secret) {
+			t.Errorf("log contains secret %q:\n%s", secret, logs.String())
+		}
+		if !bytes.Contains(sent, []byte(secret)) {
+			t.Errorf("payload sent to FlightCtl is missing %q", secret)
+		}
+	}
+	if !strings.Contains(logs.String(), "MODE") || !strings.Contains(logs.String(), "prod") {
+		t.Errorf("expected non-secret env vars in the log:\n%s", logs.String())
+	}
+	if device.Spec.Applications[0].EnvVars["API_TOKEN"] != "abc123" {
+		t.Error("redaction modified the device")
+	}
+}
@@ -0,0 +1,59 @@
+package flightctl
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Pods can deploy application content kept in a FlightCtl Repository instead
+// of the content translated from their containers. The device reads the
+// content from the repository at the given revision; the provider only
+// manages the application entry, and the pod's containers describe the
+// status it reports.
+const (
+	// RepoAnnotation names the FlightCtl Repository holding the content.
+	RepoAnnotation = "flightctl.io/repo"
+	// RepoPathAnnotation is the path of the content in the repository.
+	RepoPathAnnotation = "flightctl.io/path"
+	// RepoRevisionAnnotation is the branch, tag or commit to deploy
+	// (default DefaultRepoRevision).
+	RepoRevisionAnnotation = "flightctl.io/revision"
+
+	// DefaultRepoRevision is deployed when a pod sets no revision.
+	DefaultRepoRevision = "main"
+)
+
+// GitRef references application content in a FlightCtl Repository.
+type GitRef struct {
+	Repository     string `json:"repository"`
+	TargetRevision string `json:"targetRevision"`
+	Path           string `json:"path"`
+}
+
+// IsRepoPod reports whether a pod deploys its content from a repository.
+func IsRepoPod(pod *corev1.Pod) bool {
+	return pod.Annotations[RepoAnnotation] != ""
+}
+
+// repoRef returns the repository content a pod deploys, or nil for pods
+// translated from their containers.
+func repoRef(pod *corev1.Pod) (*GitRef, error) {
+	if !IsRepoPod(pod) {
+		return nil, nil
+	}
+	path := strings.TrimSpace(pod.Annotations[RepoPathAnnotation])
+	if path == "" {
+		return nil, fmt.Errorf("pod %s/%s sets %s without %s", pod.Namespace, pod.Name, RepoAnnotation, RepoPathAnnotation)
+	}
+	revision := strings.TrimSpace(pod.Annotations[RepoRevisionAnnotation])
+	if revision == "" {
+		revision = DefaultRepoRevision
+	}
+	return &GitRef{
+		Repository:     pod.Annotations[RepoAnnotation],
+		TargetRevision: revision,
+		Path:           path,
+	}, nil
+}
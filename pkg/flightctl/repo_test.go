@@ -0,0 +1,51 @@
+package flightctl
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRepoPodReferencesRepositoryContent(t *testing.T) {
+	pm := NewPodManager(nil)
+	pod := statusTestPod()
+	pod.Annotations = map[string]string{
+		RepoAnnotation:     "edge-apps",
+		RepoPathAnnotation: "apps/web",
+		AppTypeAnnotation:  AppTypeQuadlet,
+	}
+
+	app, err := pm.podToFlightctlApplication(pod)
+	if err != nil {
+		t.Fatalf("podToFlightctlApplication: %v", err)
+	}
+	want := GitRef{Repository: "edge-apps", TargetRevision: DefaultRepoRevision, Path: "apps/web"}
+	if app.GitRef == nil || *app.GitRef != want {
+		t.Errorf("gitRef = %+v, want %+v", app.GitRef, want)
+	}
+	if app.AppType != AppTypeQuadlet || len(app.Inline) != 0 || !app.IsManaged() {
+		t.Errorf("application = %+v, want a managed quadlet application without inline content", app)
+	}
+	data, err := json.Marshal(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), `"inline"`) {
+		t.Errorf("repository application carries inline content: %s", data)
+	}
+
+	// A new revision is a new definition
+	pod.Annotations[RepoRevisionAnnotation] = "v2"
+	if next, _ := pm.podToFlightctlApplication(pod); next.ContentHash() == app.ContentHash() {
+		t.Error("content hash unchanged after a revision change")
+	}
+
+	if features := UnsupportedFeatures(pod); len(features) != 0 {
+		t.Errorf("repository pod has unsupported features %v", features)
+	}
+
+	delete(pod.Annotations, RepoPathAnnotation)
+	if _, err := pm.podToFlightctlApplication(pod); err == nil || !strings.Contains(err.Error(), RepoPathAnnotation) {
+		t.Errorf("error = %v, want the missing path named", err)
+	}
+}
@@ -0,0 +1,23 @@
+package flightctl
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// cdiDevices returns the CDI devices exposing the extended resources a
+// container requests: nvidia.com/gpu is exposed as nvidia.com/gpu=all,
+// which the device's container engine resolves from the CDI specs of its
+// drivers. Requests only count during device selection; the container gets
+// every device of the class.
+func cdiDevices(container corev1.Container) []string {
+	var devices []string
+	for name := range models.ExtendedRequests(container) {
+		devices = append(devices, string(name)+"=all")
+	}
+	sort.Strings(devices)
+	return devices
+}
@@ -0,0 +1,49 @@
+package flightctl
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestExtendedResourceCapacityLabels(t *testing.T) {
+	capacity := capacityFromLabels("d1", map[string]string{
+		CapacityLabelPrefix + "nvidia.com_gpu":             "2",
+		CapacityLabelPrefix + "device.flightctl.io_camera": "1",
+		CapacityLabelPrefix + "kubernetes.io_gpu":          "1",
+		CapacityLabelPrefix + "example.com_broken":         "lots",
+	})
+	if len(capacity.Extended) != 2 {
+		t.Fatalf("extended capacity = %v, want the GPUs and the camera", capacity.Extended)
+	}
+	if gpus := capacity.Extended["nvidia.com/gpu"]; gpus.String() != "2" {
+		t.Errorf("GPUs = %s, want 2", gpus.String())
+	}
+}
+
+func TestExtendedResourceDevices(t *testing.T) {
+	pod := statusTestPod()
+	pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{
+		"nvidia.com/gpu":             resource.MustParse("1"),
+		"device.flightctl.io/camera": resource.MustParse("1"),
+	}
+	content := convertPodToDockerCompose(pod)
+	if want := "    devices:\n      - \"device.flightctl.io/camera=all\"\n      - \"nvidia.com/gpu=all\"\n"; !strings.Contains(content, want) {
+		t.Errorf("compose lacks %q:\n%s", want, content)
+	}
+	if strings.Count(content, "devices:") != 1 {
+		t.Errorf("compose exposes devices to the sidecar:\n%s", content)
+	}
+	unit := quadletContainerUnit(pod, podServices(pod)[0], "default-web", "default-web.pod")
+	if !strings.Contains(unit, "AddDevice=nvidia.com/gpu=all\n") {
+		t.Errorf("container unit lacks the GPU:\n%s", unit)
+	}
+
+	// Extended resources are requested through limits, which are not
+	// reported for them
+	if features := UnsupportedFeatures(pod); len(features) != 0 {
+		t.Errorf("features = %v, want none", features)
+	}
+}
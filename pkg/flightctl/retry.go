@@ -0,0 +1,191 @@
+package flightctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// Default retry policy values.
+const (
+	DefaultRetryMaxAttempts = 4
+	DefaultRetryBaseDelay   = 200 * time.Millisecond
+	DefaultRetryMaxDelay    = 5 * time.Second
+)
+
+// RetryPolicy controls how failed FlightCtl API calls are retried. Network
+// errors, 5xx responses and 429 responses are retried with exponential
+// backoff and jitter; a Retry-After header on 429/503 responses overrides
+// the computed delay (capped at MaxDelay).
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	// 1 disables retries; 0 uses DefaultRetryMaxAttempts.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubled per attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts.
+	MaxDelay time.Duration
+}
+
+// withDefaults fills unset fields with the default policy values.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryMaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryBaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryMaxDelay
+	}
+	if p.MaxDelay < p.BaseDelay {
+		p.MaxDelay = p.BaseDelay
+	}
+	return p
+}
+
+// backoff returns the delay before retry number attempt (1-based), using
+// "equal jitter": half the exponential delay plus a random half.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+type noRetryKey struct{}
+
+// WithoutRetry returns a context that disables retries for requests made
+// with it. Use it for calls that must not be repeated.
+func WithoutRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetryKey{}, true)
+}
+
+// retryDisabled reports whether retries were disabled for the request.
+func retryDisabled(req *http.Request) bool {
+	disabled, _ := req.Context().Value(noRetryKey{}).(bool)
+	return disabled
+}
+
+// retryTransport wraps an http.RoundTripper and retries transient failures
+// of idempotent requests.
+type retryTransport struct {
+	base    http.RoundTripper
+	policy  RetryPolicy
+	metrics *TransportMetrics // Counts retries; may be nil
+}
+
+// RoundTrip implements http.RoundTripper interface.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isIdempotent(req.Method) || retryDisabled(req) || (req.Body != nil && req.GetBody == nil) {
+		return t.base.RoundTrip(req)
+	}
+
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("rewinding request body: %w", err)
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		if attempt >= t.policy.MaxAttempts || !shouldRetry(req.Context(), resp, err) {
+			return resp, err
+		}
+
+		delay := t.policy.backoff(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp); ok {
+				delay = after
+				if delay > t.policy.MaxDelay {
+					delay = t.policy.MaxDelay
+				}
+			}
+			logger.FromContext(req.Context()).Debug("%s %s returned status %d, retrying in %s (attempt %d/%d)",
+				req.Method, req.URL.Path, resp.StatusCode, delay, attempt+1, t.policy.MaxAttempts)
+			// Drain so the connection can be reused
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			logger.FromContext(req.Context()).Debug("%s %s failed: %v, retrying in %s (attempt %d/%d)",
+				req.Method, req.URL.Path, err, delay, attempt+1, t.policy.MaxAttempts)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		t.metrics.retried(req)
+	}
+}
+
+// CloseIdleConnections closes idle connections of the base transport.
+func (t *retryTransport) CloseIdleConnections() {
+	closeIdleConnections(t.base)
+}
+
+// isIdempotent reports whether requests with the method may be repeated.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// shouldRetry reports whether a response or transport error is transient.
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses the Retry-After header of a 429 or 503 response, given
+// either as seconds or as an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
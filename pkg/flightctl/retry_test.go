@@ -0,0 +1,95 @@
+package flightctl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newRetryTestClient(srv *httptest.Server) *Client {
+	return &Client{
+		httpClient: &http.Client{Transport: &retryTransport{
+			base:   srv.Client().Transport,
+			policy: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond},
+		}},
+		baseURL: srv.URL,
+	}
+}
+
+func TestRetryTransientErrors(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			_, _ = w.Write([]byte(`{"metadata":{"name":"dev-1"},"spec":{}}`))
+		}
+	}))
+	defer srv.Close()
+
+	device, err := newRetryTestClient(srv).GetDevice(context.Background(), "dev-1")
+	if err != nil {
+		t.Fatalf("GetDevice returned error: %v", err)
+	}
+	if device.Metadata.Name != "dev-1" || calls != 3 {
+		t.Errorf("expected success on third attempt, got device %q after %d calls", device.Metadata.Name, calls)
+	}
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	_, err := newRetryTestClient(srv).GetDevice(context.Background(), "dev-1")
+	if err == nil || calls != 3 {
+		t.Errorf("expected failure after 3 attempts, got err=%v after %d calls", err, calls)
+	}
+}
+
+func TestRetryOptOut(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	c := newRetryTestClient(srv)
+
+	if _, err := c.GetDevice(WithoutRetry(context.Background()), "dev-1"); err == nil {
+		t.Fatal("expected error")
+	}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, nil)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if calls != 2 {
+		t.Errorf("expected opted-out and non-idempotent requests to be sent once each, got %d calls", calls)
+	}
+}
+
+func TestRetryBackoffBounds(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}.withDefaults()
+	for attempt := 1; attempt <= 10; attempt++ {
+		d := policy.backoff(attempt)
+		if d > policy.MaxDelay {
+			t.Errorf("attempt %d: delay %s exceeds cap %s", attempt, d, policy.MaxDelay)
+		}
+		if attempt == 1 && (d < 50*time.Millisecond || d > 100*time.Millisecond) {
+			t.Errorf("attempt 1: delay %s outside [50ms, 100ms]", d)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package flightctl
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FlightCtl renders a device's spec asynchronously after it is updated and
+// the agent applies the rendered version some time later, so a successful
+// PUT does not mean the application runs.
+const (
+	// RenderedVersionAnnotation is set by the FlightCtl device controller to
+	// the version of the device's latest rendered spec.
+	RenderedVersionAnnotation = "device-controller/renderedVersion"
+
+	// DeviceUpdatedUpToDate is the updated status of a device running its
+	// latest rendered spec.
+	DeviceUpdatedUpToDate = "UpToDate"
+
+	// PodRollingOutToDevice is the pod condition reported while the device
+	// has not applied the spec the pod was deployed with.
+	PodRollingOutToDevice corev1.PodConditionType = "RollingOutToDevice"
+)
+
+// RenderedVersion returns the version of the device's latest rendered spec.
+func (d *FlightctlDevice) RenderedVersion() string {
+	return d.Metadata.Annotations[RenderedVersionAnnotation]
+}
+
+// AppliedVersion returns the rendered spec version the device agent reports
+// as applied.
+func (d *FlightctlDevice) AppliedVersion() string {
+	if d.Status == nil || d.Status.Config == nil {
+		return ""
+	}
+	return d.Status.Config.RenderedVersion
+}
+
+// reportsRenderedVersions reports whether the server tracks rendered
+// versions for the device; rollouts can only be followed if it does.
+func (d *FlightctlDevice) reportsRenderedVersions() bool {
+	return d.RenderedVersion() != "" || d.AppliedVersion() != ""
+}
+
+// rolledOutSince reports whether the device applied a spec rendered after
+// baseline, the rendered version current when it was updated.
+func (d *FlightctlDevice) rolledOutSince(baseline string) bool {
+	if versionAfter(d.AppliedVersion(), baseline) {
+		return true
+	}
+	return d.Status != nil && d.Status.Updated != nil && d.Status.Updated.Status == DeviceUpdatedUpToDate &&
+		versionAfter(d.RenderedVersion(), baseline) && d.AppliedVersion() == d.RenderedVersion()
+}
+
+// versionAfter reports whether rendered version v is newer than baseline.
+// Versions are increasing integers; other values are compared for equality.
+func versionAfter(v, baseline string) bool {
+	if v == "" {
+		return false
+	}
+	n, err1 := strconv.ParseInt(v, 10, 64)
+	base, err2 := strconv.ParseInt(baseline, 10, 64)
+	if err1 != nil || err2 != nil {
+		return v != baseline
+	}
+	return n > base
+}
+
+// rolloutTracker remembers, per device application, the rendered version
+// current before the application was deployed, until the device reports a
+// newer version applied.
+type rolloutTracker struct {
+	mu        sync.Mutex
+	baselines map[string]string // deviceID/appName -> rendered version
+}
+
+func newRolloutTracker() *rolloutTracker {
+	return &rolloutTracker{baselines: make(map[string]string)}
+}
+
+func rolloutKey(deviceID, appName string) string {
+	return deviceID + "/" + appName
+}
+
+// start begins tracking the rollout of an application deployed to a device
+// whose spec was at the given rendered version.
+func (t *rolloutTracker) start(deviceID, appName, baseline string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.baselines[rolloutKey(deviceID, appName)] = baseline
+}
+
+// forget stops tracking an application.
+func (t *rolloutTracker) forget(deviceID, appName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.baselines, rolloutKey(deviceID, appName))
+}
+
+// pending returns the baseline of an application whose rollout the device
+// has not completed yet, forgetting applications that rolled out.
+func (t *rolloutTracker) pending(device *FlightctlDevice, appName string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := rolloutKey(device.Metadata.Name, appName)
+	baseline, ok := t.baselines[key]
+	if !ok {
+		return "", false
+	}
+	if device.rolledOutSince(baseline) {
+		delete(t.baselines, key)
+		return "", false
+	}
+	return baseline, true
+}
+
+// rollingOutPodStatus returns the Pending status of a pod whose application
+// the device has not applied yet.
+func rollingOutPodStatus(pod *corev1.Pod, device *FlightctlDevice, baseline string) *corev1.PodStatus {
+	status := pendingPodStatus(pod, device.DeviceIP())
+
+	updated := "Unknown"
+	if device.Status != nil && device.Status.Updated != nil {
+		updated = device.Status.Updated.Status
+	}
+	status.Conditions = append(status.Conditions, corev1.PodCondition{
+		Type:               PodRollingOutToDevice,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: metav1.Now(),
+		Reason:             "WaitingForRenderedVersion",
+		Message: fmt.Sprintf("Waiting for device %s to apply a spec newer than version %s (rendered %s, applied %s, %s)",
+			device.Metadata.Name, baseline, device.RenderedVersion(), device.AppliedVersion(), updated),
+	})
+	return status
+}
@@ -0,0 +1,46 @@
+package flightctl
+
+import "testing"
+
+func TestVersionAfter(t *testing.T) {
+	tests := []struct {
+		v, baseline string
+		want        bool
+	}{
+		{"5", "4", true},
+		{"4", "4", false},
+		{"10", "9", true},
+		{"3", "4", false},
+		{"", "4", false},
+		{"1", "", true},
+		{"abc", "abd", true},
+		{"abc", "abc", false},
+	}
+	for _, tt := range tests {
+		if got := versionAfter(tt.v, tt.baseline); got != tt.want {
+			t.Errorf("versionAfter(%q, %q) = %v, want %v", tt.v, tt.baseline, got, tt.want)
+		}
+	}
+}
+
+func TestRolledOutSince(t *testing.T) {
+	device := func(rendered, applied, updated string) *FlightctlDevice {
+		return &FlightctlDevice{
+			Metadata: FlightctlDeviceMetadata{Annotations: map[string]string{RenderedVersionAnnotation: rendered}},
+			Status: &FlightctlDeviceStatus{
+				Config:  &FlightctlDeviceConfigStatus{RenderedVersion: applied},
+				Updated: &FlightctlDeviceUpdated{Status: updated},
+			},
+		}
+	}
+
+	if device("4", "4", DeviceUpdatedUpToDate).rolledOutSince("4") {
+		t.Error("not yet rendered spec reported as rolled out")
+	}
+	if device("5", "4", "OutOfDate").rolledOutSince("4") {
+		t.Error("rendered but not applied spec reported as rolled out")
+	}
+	if !device("5", "5", DeviceUpdatedUpToDate).rolledOutSince("4") {
+		t.Error("applied spec not reported as rolled out")
+	}
+}
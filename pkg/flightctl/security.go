@@ -0,0 +1,72 @@
+package flightctl
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// containerSecurity holds the security settings a container runs with on the
+// device: those of its security context, with the user and group defaulting
+// to the pod's.
+type containerSecurity struct {
+	runAsUser  *int64
+	runAsGroup *int64
+	capAdd     []string
+	capDrop    []string
+	privileged bool
+	readOnly   bool
+}
+
+// securityOf returns the security settings of a container of the pod.
+func securityOf(pod *corev1.Pod, container corev1.Container) containerSecurity {
+	var security containerSecurity
+	if psc := pod.Spec.SecurityContext; psc != nil {
+		security.runAsUser = psc.RunAsUser
+		security.runAsGroup = psc.RunAsGroup
+	}
+	sc := container.SecurityContext
+	if sc == nil {
+		return security
+	}
+	if sc.RunAsUser != nil {
+		security.runAsUser = sc.RunAsUser
+	}
+	if sc.RunAsGroup != nil {
+		security.runAsGroup = sc.RunAsGroup
+	}
+	if sc.Capabilities != nil {
+		for _, capability := range sc.Capabilities.Add {
+			security.capAdd = append(security.capAdd, string(capability))
+		}
+		for _, capability := range sc.Capabilities.Drop {
+			security.capDrop = append(security.capDrop, string(capability))
+		}
+	}
+	security.privileged = sc.Privileged != nil && *sc.Privileged
+	security.readOnly = sc.ReadOnlyRootFilesystem != nil && *sc.ReadOnlyRootFilesystem
+	return security
+}
+
+// user returns the compose user setting, uid or uid:gid, or "" to keep the
+// image's user. A group alone cannot be set.
+func (s containerSecurity) user() string {
+	if s.runAsUser == nil {
+		return ""
+	}
+	user := strconv.FormatInt(*s.runAsUser, 10)
+	if s.runAsGroup != nil {
+		user += ":" + strconv.FormatInt(*s.runAsGroup, 10)
+	}
+	return user
+}
+
+// PodPrivileged reports whether any container of the pod runs privileged.
+func PodPrivileged(pod *corev1.Pod) bool {
+	for _, container := range podContainers(pod) {
+		if securityOf(pod, container).privileged {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package flightctl
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func securityTestPod() *corev1.Pod {
+	uid, gid, containerUID := int64(1000), int64(2000), int64(1001)
+	privileged, readOnly := true, true
+	pod := statusTestPod()
+	pod.Spec.SecurityContext = &corev1.PodSecurityContext{RunAsUser: &uid, RunAsGroup: &gid}
+	pod.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{
+		RunAsUser:              &containerUID,
+		Privileged:             &privileged,
+		ReadOnlyRootFilesystem: &readOnly,
+		Capabilities: &corev1.Capabilities{
+			Add:  []corev1.Capability{"NET_ADMIN", "SYS_TIME"},
+			Drop: []corev1.Capability{"ALL"},
+		},
+	}
+	return pod
+}
+
+func TestConvertPodSecurityContextToCompose(t *testing.T) {
+	composeYAML := convertPodToDockerCompose(securityTestPod())
+	nginx, sidecar, _ := strings.Cut(composeYAML, "  sidecar:\n")
+
+	for _, want := range []string{
+		"    user: \"1001:2000\"\n",
+		"    privileged: true\n",
+		"    read_only: true\n",
+		"    cap_add:\n      - NET_ADMIN\n      - SYS_TIME\n",
+		"    cap_drop:\n      - ALL\n",
+	} {
+		if !strings.Contains(nginx, want) {
+			t.Errorf("nginx service lacks %q:\n%s", want, nginx)
+		}
+	}
+	// The sidecar only inherits the pod's user and group
+	if !strings.Contains(sidecar, "    user: \"1000:2000\"\n") {
+		t.Errorf("sidecar service lacks the pod user:\n%s", sidecar)
+	}
+	for _, unwanted := range []string{"privileged", "read_only", "cap_add", "cap_drop"} {
+		if strings.Contains(sidecar, unwanted) {
+			t.Errorf("sidecar service has %s:\n%s", unwanted, sidecar)
+		}
+	}
+}
+
+func TestConvertPodSecurityContextToQuadlet(t *testing.T) {
+	pod := securityTestPod()
+	pod.Spec.Containers[0].TTY = true
+	unit := quadletContainerUnit(pod, podServices(pod)[0], "default-web", "default-web.pod")
+
+	for _, want := range []string{
+		"User=1001\n",
+		"Group=2000\n",
+		"ReadOnly=true\n",
+		"AddCapability=NET_ADMIN SYS_TIME\n",
+		"DropCapability=ALL\n",
+		"PodmanArgs=--tty --privileged\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("container unit lacks %q:\n%s", want, unit)
+		}
+	}
+}
+
+func TestSecurityContextGroupWithoutUserIsUnsupported(t *testing.T) {
+	gid := int64(2000)
+	pod := statusTestPod()
+	pod.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{RunAsGroup: &gid}
+
+	features := UnsupportedFeatures(pod)
+	if len(features) != 1 || features[0].Field != "spec.containers[nginx].securityContext.runAsGroup" {
+		t.Errorf("unsupported features = %v, want the group without a user", features)
+	}
+	if strings.Contains(convertPodToDockerCompose(pod), "user:") {
+		t.Error("compose service sets a user for a group alone")
+	}
+	if features := UnsupportedFeatures(securityTestPod()); len(features) != 0 {
+		t.Errorf("translated security contexts reported unsupported: %v", features)
+	}
+}
@@ -0,0 +1,136 @@
+package flightctl
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Init containers are translated like the pod's containers and ordered
+// before them, approximating how the kubelet starts a pod: an init
+// container runs to completion before the next container starts, while a
+// sidecar (an init container with restartPolicy Always) keeps running and
+// only has to be started, or healthy if it has an exec startupProbe. Each
+// container depends on all the init containers before it, so that compose
+// and systemd also stop the containers in reverse order, sidecars last.
+
+// Start conditions of a dependency, as compose names them.
+const (
+	conditionStarted   = "service_started"
+	conditionHealthy   = "service_healthy"
+	conditionCompleted = "service_completed_successfully"
+)
+
+// podService is a container of a pod as it is rendered, with the
+// containers that must have started or completed before it.
+type podService struct {
+	container corev1.Container
+	init      bool     // Init container run to completion
+	sidecar   bool     // Init container kept running
+	aliases   []string // Names on shared networks
+	dependsOn []serviceDependency
+}
+
+// serviceDependency is a container another one waits for, and what it
+// waits for.
+type serviceDependency struct {
+	container string
+	condition string
+}
+
+// podServices returns the init containers and containers of a pod in start
+// order.
+func podServices(pod *corev1.Pod) []podService {
+	var services []podService
+	var before []serviceDependency
+	for _, container := range pod.Spec.InitContainers {
+		service := podService{
+			container: container,
+			aliases:   []string{fmt.Sprintf("%s-%s", pod.Name, sanitizeServiceName(container.Name))},
+			dependsOn: before,
+		}
+		condition := conditionCompleted
+		if isSidecar(container) {
+			service.sidecar = true
+			condition = conditionStarted
+			if _, ok := startupCheck(container); ok {
+				condition = conditionHealthy
+			}
+		} else {
+			service.init = true
+		}
+		services = append(services, service)
+		before = append(slices.Clip(before), serviceDependency{container: container.Name, condition: condition})
+	}
+	for i, container := range pod.Spec.Containers {
+		services = append(services, podService{container: container, aliases: networkAliases(pod, i), dependsOn: before})
+	}
+	return services
+}
+
+// podContainers returns the init containers and containers of a pod.
+func podContainers(pod *corev1.Pod) []corev1.Container {
+	return append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+}
+
+// isSidecar reports whether an init container keeps running alongside the
+// pod's containers.
+func isSidecar(container corev1.Container) bool {
+	return container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways
+}
+
+// healthCheck is a command run in a container to tell whether it started.
+type healthCheck struct {
+	command     []string
+	interval    int32 // Seconds, 0 for the engine default
+	timeout     int32
+	retries     int32
+	startPeriod int32
+}
+
+// startupCheck returns the health check of a sidecar's exec startupProbe,
+// the only probes the translation runs: other containers do not gate the
+// start of any container, and the other handlers need tools the image may
+// not have.
+func startupCheck(container corev1.Container) (healthCheck, bool) {
+	probe := container.StartupProbe
+	if !isSidecar(container) || probe == nil || probe.Exec == nil || len(probe.Exec.Command) == 0 {
+		return healthCheck{}, false
+	}
+	return healthCheck{
+		command:     probe.Exec.Command,
+		interval:    probe.PeriodSeconds,
+		timeout:     probe.TimeoutSeconds,
+		retries:     probe.FailureThreshold,
+		startPeriod: probe.InitialDelaySeconds,
+	}, true
+}
+
+// composeHealthCheck renders a compose service healthcheck.
+func composeHealthCheck(check healthCheck) string {
+	var out strings.Builder
+	out.WriteString("    healthcheck:\n")
+	out.WriteString("      test:\n")
+	out.WriteString("        - CMD\n")
+	for _, arg := range check.command {
+		out.WriteString(fmt.Sprintf("        - %q\n", arg))
+	}
+	for _, setting := range []struct {
+		key     string
+		seconds int32
+	}{
+		{"interval", check.interval},
+		{"timeout", check.timeout},
+		{"start_period", check.startPeriod},
+	} {
+		if setting.seconds > 0 {
+			out.WriteString(fmt.Sprintf("      %s: %ds\n", setting.key, setting.seconds))
+		}
+	}
+	if check.retries > 0 {
+		out.WriteString(fmt.Sprintf("      retries: %d\n", check.retries))
+	}
+	return out.String()
+}
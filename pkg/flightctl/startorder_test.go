@@ -0,0 +1,86 @@
+package flightctl
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+func sidecarPod() *corev1.Pod {
+	always := corev1.ContainerRestartPolicyAlways
+	cpu := func(value string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(value)}}
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				{Name: "migrate", Image: "migrate:1", Resources: cpu("800m")},
+				{Name: "proxy", Image: "proxy:1", RestartPolicy: &always, Resources: cpu("200m"),
+					StartupProbe: &corev1.Probe{
+						ProbeHandler:     corev1.ProbeHandler{Exec: &corev1.ExecAction{Command: []string{"test", "-f", "/tmp/ready"}}},
+						PeriodSeconds:    5,
+						FailureThreshold: 10,
+					}},
+			},
+			Containers: []corev1.Container{{Name: "app", Image: "app:1", Resources: cpu("500m")}},
+		},
+	}
+}
+
+func TestComposeStartOrder(t *testing.T) {
+	compose := convertPodToDockerCompose(sidecarPod())
+	for _, want := range []string{
+		"  migrate:\n    image: migrate:1\n",
+		"  proxy:\n    image: proxy:1\n",
+		"    depends_on:\n      migrate:\n        condition: service_completed_successfully\n      proxy:\n        condition: service_healthy\n",
+		"    healthcheck:\n      test:\n        - CMD\n        - \"test\"\n        - \"-f\"\n        - \"/tmp/ready\"\n      interval: 5s\n      retries: 10\n",
+	} {
+		if !strings.Contains(compose, want) {
+			t.Errorf("compose file lacks %q:\n%s", want, compose)
+		}
+	}
+	// The init container is retried until it succeeds; the sidecar and
+	// the app container keep running
+	if got := strings.Count(compose, "restart: on-failure"); got != 1 {
+		t.Errorf("on-failure restarts = %d, want the init container's only:\n%s", got, compose)
+	}
+
+	if features := UnsupportedFeatures(sidecarPod()); len(features) != 0 {
+		t.Errorf("unsupported features = %v, want none", features)
+	}
+	// 500m for the app and 200m for the sidecar, less than the 800m the
+	// init container needs
+	if requests := models.PodRequests(sidecarPod()); requests.CPU.MilliValue() != 800 {
+		t.Errorf("CPU requests = %s, want 800m", requests.CPU.String())
+	}
+}
+
+func TestQuadletStartOrder(t *testing.T) {
+	units := make(map[string]string)
+	for _, unit := range convertPodToQuadlet(sidecarPod(), "default-web") {
+		units[unit.Path] = unit.Content
+	}
+	for path, wants := range map[string][]string{
+		"default-web-migrate.container": {"Type=oneshot\nRemainAfterExit=yes\nRestart=on-failure\n"},
+		"default-web-proxy.container": {
+			"Requires=default-web-migrate.service\nAfter=default-web-migrate.service\n",
+			"HealthCmd=test -f /tmp/ready\nHealthInterval=5s\nHealthRetries=10\nNotify=healthy\n",
+			"Restart=always\n",
+		},
+		"default-web-app.container": {
+			"Requires=default-web-migrate.service\nAfter=default-web-migrate.service\nWants=default-web-proxy.service\nAfter=default-web-proxy.service\n",
+		},
+	} {
+		for _, want := range wants {
+			if !strings.Contains(units[path], want) {
+				t.Errorf("%s lacks %q:\n%s", path, want, units[path])
+			}
+		}
+	}
+}
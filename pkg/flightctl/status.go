@@ -0,0 +1,405 @@
+package flightctl
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// System info keys reported by the FlightCtl agent that carry the device's
+// default network address.
+var deviceIPSystemInfoKeys = []string{"netIPDefault", "defaultIPAddress", "ipAddress"}
+
+// System info keys carrying the device's other addresses.
+var deviceIPv6SystemInfoKeys = []string{"netIPv6Default", "defaultIPv6Address"}
+
+// deviceIPs returns the valid, distinct addresses the device reported, the
+// default one first.
+func (d *FlightctlDevice) deviceIPs() []string {
+	if d == nil || d.Status == nil {
+		return nil
+	}
+	var ips []string
+	for _, key := range slices.Concat(deviceIPSystemInfoKeys, deviceIPv6SystemInfoKeys) {
+		value, _ := d.Status.SystemInfo[key].(string)
+		ip := net.ParseIP(strings.TrimSpace(value))
+		if ip == nil || slices.Contains(ips, ip.String()) {
+			continue
+		}
+		ips = append(ips, ip.String())
+	}
+	return ips
+}
+
+// DeviceIP returns the device's default IP address from its reported system
+// info, or an empty string if the agent has not reported one.
+func (d *FlightctlDevice) DeviceIP() string {
+	if d == nil || d.Status == nil {
+		return ""
+	}
+	for _, key := range deviceIPSystemInfoKeys {
+		if ip, ok := d.Status.SystemInfo[key].(string); ok && ip != "" {
+			return ip
+		}
+	}
+	return ""
+}
+
+// architectures maps the machine names reported by uname to the GOARCH
+// names used by the kubernetes.io/arch label and image manifests.
+var architectures = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"armv7l":  "arm",
+	"armv6l":  "arm",
+	"i686":    "386",
+	"i386":    "386",
+}
+
+// systemInfo returns the platform the device agent reported.
+func (d *FlightctlDevice) systemInfo() models.SystemInfo {
+	if d == nil || d.Status == nil {
+		return models.SystemInfo{}
+	}
+	get := func(key string) string {
+		value, _ := d.Status.SystemInfo[key].(string)
+		return strings.TrimSpace(value)
+	}
+
+	info := models.SystemInfo{
+		OperatingSystem: strings.ToLower(get("operatingSystem")),
+		Architecture:    strings.ToLower(get("architecture")),
+		KernelVersion:   get("kernel"),
+		AgentVersion:    get("agentVersion"),
+		BootID:          get("bootID"),
+		IPAddresses:     d.deviceIPs(),
+	}
+	if arch, ok := architectures[info.Architecture]; ok {
+		info.Architecture = arch
+	}
+	info.OSImage = strings.TrimSpace(get("distroName") + " " + get("distroVersion"))
+	return info
+}
+
+// health returns the resource pressure, update failure and power state the
+// device agent reported. The power state can also be declared with labels.
+func (d *FlightctlDevice) health() models.DeviceHealth {
+	var health models.DeviceHealth
+	if d == nil {
+		return health
+	}
+	health.Battery = d.powerReading(BatteryInfoKey, BatteryLabel)
+	health.Temperature = d.powerReading(TemperatureInfoKey, TemperatureLabel)
+	if d.Status == nil {
+		return health
+	}
+	if r := d.Status.Resources; r != nil {
+		health.MemoryPressure = strings.EqualFold(r.Memory, "Critical")
+		health.DiskPressure = strings.EqualFold(r.Disk, "Critical")
+	}
+	for _, cond := range d.Status.Conditions {
+		if cond.Type == DeviceUpdatingCondition && cond.Reason == DeviceUpdateErrorReason {
+			health.UpdateError = cond.Message
+			if health.UpdateError == "" {
+				health.UpdateError = "update failed"
+			}
+		}
+	}
+	return health
+}
+
+// parseReadyCount parses a FlightCtl "ready/total" string such as "1/2".
+func parseReadyCount(ready string) (int, int, bool) {
+	parts := strings.SplitN(ready, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	n, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+	total, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err1 != nil || err2 != nil || n < 0 || total < 0 {
+		return 0, 0, false
+	}
+	return n, total, true
+}
+
+// pendingPodStatus returns the status of a pod whose application is in the
+// device spec but has not reported runtime status yet.
+func pendingPodStatus(pod *corev1.Pod, hostIP string) *corev1.PodStatus {
+	now := metav1.Now()
+	status := &corev1.PodStatus{
+		Phase:  corev1.PodPending,
+		HostIP: hostIP,
+		Conditions: []corev1.PodCondition{
+			{
+				Type:               corev1.PodScheduled,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: now,
+				Reason:             "ApplicationDeployed",
+			},
+		},
+	}
+	for _, container := range pod.Spec.Containers {
+		status.ContainerStatuses = append(status.ContainerStatuses, corev1.ContainerStatus{
+			Name:  container.Name,
+			Image: container.Image,
+			State: corev1.ContainerState{
+				Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"},
+			},
+		})
+	}
+	return status
+}
+
+// exitCodePattern finds the exit code in an application summary, e.g.
+// "Exited (137)", "exited with code 1" or "exit status 2".
+var exitCodePattern = regexp.MustCompile(`(?i)\bexit(?:ed)?\s*(?:with\s+)?(?:code|status)?\s*\(?(\d+)\)?`)
+
+// summaryExitCode returns the exit code reported in an application summary.
+// FlightCtl does not report exit codes separately, so a failed application
+// without one is given exit code 1.
+func summaryExitCode(summary string) (int32, bool) {
+	m := exitCodePattern.FindStringSubmatch(summary)
+	if m == nil {
+		return 0, false
+	}
+	code, err := strconv.ParseInt(m[1], 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return int32(code), true
+}
+
+// oomPattern finds an out-of-memory kill in an application summary or exit
+// reason, e.g. "OOMKilled" or "killed: out of memory".
+var oomPattern = regexp.MustCompile(`(?i)\boom[ -]?kill|\bout of memory\b`)
+
+// oomExitCode is the exit code of a container killed with SIGKILL, which the
+// kernel OOM killer sends.
+const oomExitCode = 137
+
+// containerStatus returns the status the agent reported for a container of
+// the pod, if any. Reported names are matched against the container name
+// and the device container names derived from it, e.g. <app>-<container> of
+// quadlets and <project>-<service>-1 of compose.
+func (appStatus *FlightctlApplicationStatus) containerStatus(container string) *FlightctlContainerStatus {
+	service := sanitizeServiceName(container)
+	for i := range appStatus.Containers {
+		if name := appStatus.Containers[i].Name; name == container || name == service {
+			return &appStatus.Containers[i]
+		}
+	}
+	for i := range appStatus.Containers {
+		name := appStatus.Containers[i].Name
+		if n := strings.LastIndex(name, "-"); n >= 0 {
+			if _, err := strconv.Atoi(name[n+1:]); err == nil {
+				name = name[:n]
+			}
+		}
+		if name == service || strings.HasSuffix(name, "-"+service) {
+			return &appStatus.Containers[i]
+		}
+	}
+	return nil
+}
+
+// exited reports whether the container is no longer running.
+func (c *FlightctlContainerStatus) exited() bool {
+	switch strings.ToLower(c.Status) {
+	case "exited", "stopped", "dead", "failed", "error", "completed":
+		return true
+	}
+	return false
+}
+
+// termination returns the exit code and reason of the container's last run,
+// if the agent reported one.
+func (c *FlightctlContainerStatus) termination() (int32, string, bool) {
+	oomKilled := c.OOMKilled || oomPattern.MatchString(c.Reason)
+	switch {
+	case oomKilled && c.ExitCode != nil && *c.ExitCode != 0:
+		return *c.ExitCode, "OOMKilled", true
+	case oomKilled:
+		return oomExitCode, "OOMKilled", true
+	case c.ExitCode == nil:
+		return 0, "", false
+	case *c.ExitCode == 0:
+		return 0, "Completed", true
+	default:
+		return *c.ExitCode, "Error", true
+	}
+}
+
+// failedTermination returns the terminated state of a container of a failed
+// application. The container's own exit code and reason are used when the
+// agent reports them, otherwise they are taken from the application summary.
+func failedTermination(appStatus *FlightctlApplicationStatus, reported *FlightctlContainerStatus) (int32, string) {
+	if reported != nil {
+		if code, reason, ok := reported.termination(); ok && code != 0 {
+			return code, reason
+		}
+	}
+	exitCode, ok := summaryExitCode(appStatus.Summary)
+	if oomPattern.MatchString(appStatus.Summary) {
+		if !ok || exitCode == 0 {
+			exitCode = oomExitCode
+		}
+		return exitCode, "OOMKilled"
+	}
+	if !ok || exitCode == 0 {
+		exitCode = 1
+	}
+	return exitCode, "Error"
+}
+
+// mapFlightctlStatusToPodStatus maps FlightCtl application status to
+// Kubernetes pod status. Per-container state is derived from the
+// application status and its ready count, since FlightCtl reports status
+// per application rather than per container, refined by the per-container
+// exit codes, restarts and OOM kills of agents that report them. hostIP is the device address,
+// which is also used as the pod IP because applications share the device
+// network.
+func (pm *PodManager) mapFlightctlStatusToPodStatus(pod *corev1.Pod, appStatus *FlightctlApplicationStatus, hostIP string) *corev1.PodStatus {
+	var phase corev1.PodPhase
+	var reason string
+
+	// Map FlightCtl status to Kubernetes phase
+	// Common FlightCtl statuses: Preparing, Starting, Running, Error, Completed, Unknown
+	switch strings.ToLower(appStatus.Status) {
+	case "running":
+		phase, reason = corev1.PodRunning, "ApplicationRunning"
+	case "pending", "preparing", "starting":
+		phase, reason = corev1.PodPending, "ApplicationStarting"
+	case "failed", "error":
+		phase, reason = corev1.PodFailed, "ApplicationFailed"
+	case "completed", "succeeded":
+		phase, reason = corev1.PodSucceeded, "ApplicationCompleted"
+	case "stopped":
+		// Stopped with a non-zero exit code failed, otherwise it completed
+		if code, ok := summaryExitCode(appStatus.Summary); ok && code != 0 {
+			phase, reason = corev1.PodFailed, "ApplicationFailed"
+		} else {
+			phase, reason = corev1.PodSucceeded, "ApplicationStopped"
+		}
+	default:
+		// Unknown status - default to Pending
+		phase, reason = corev1.PodPending, "UnknownStatus"
+	}
+
+	message := appStatus.Summary
+	if reason == "UnknownStatus" {
+		message = fmt.Sprintf("Unknown application status: %s - %s", appStatus.Status, appStatus.Summary)
+	}
+
+	startTime := pod.CreationTimestamp
+	if pod.Status.StartTime != nil {
+		startTime = *pod.Status.StartTime
+	}
+
+	// Without a ready count, a running application is assumed fully ready
+	readyCount, total, ok := parseReadyCount(appStatus.Ready)
+	if !ok {
+		total = len(pod.Spec.Containers)
+		readyCount = 0
+		if phase == corev1.PodRunning {
+			readyCount = total
+		}
+	}
+	allReady := phase == corev1.PodRunning && total > 0 && readyCount >= total
+
+	now := metav1.Now()
+	containerStatuses := make([]corev1.ContainerStatus, 0, len(pod.Spec.Containers))
+	for i, container := range pod.Spec.Containers {
+		cs := corev1.ContainerStatus{
+			Name:         container.Name,
+			Image:        container.Image,
+			RestartCount: int32(appStatus.Restarts),
+		}
+		reported := appStatus.containerStatus(container.Name)
+		if reported != nil {
+			cs.RestartCount = int32(reported.Restarts)
+			if code, reason, ok := reported.termination(); ok && reported.Restarts > 0 {
+				cs.LastTerminationState.Terminated = &corev1.ContainerStateTerminated{
+					ExitCode: code,
+					Reason:   reason,
+				}
+			}
+		}
+
+		switch phase {
+		case corev1.PodRunning:
+			if reported != nil && reported.exited() {
+				code, reason, _ := reported.termination()
+				if reason == "" {
+					reason = "Completed"
+				}
+				started := false
+				cs.Started = &started
+				cs.State.Terminated = &corev1.ContainerStateTerminated{
+					ExitCode:   code,
+					Reason:     reason,
+					FinishedAt: now,
+				}
+				break
+			}
+			cs.Ready = allReady || i < readyCount
+			started := cs.Ready
+			cs.Started = &started
+			cs.State.Running = &corev1.ContainerStateRunning{StartedAt: startTime}
+		case corev1.PodSucceeded:
+			cs.State.Terminated = &corev1.ContainerStateTerminated{
+				Reason:     "Completed",
+				StartedAt:  startTime,
+				FinishedAt: now,
+			}
+		case corev1.PodFailed:
+			exitCode, reason := failedTermination(appStatus, reported)
+			cs.State.Terminated = &corev1.ContainerStateTerminated{
+				ExitCode:   exitCode,
+				Reason:     reason,
+				Message:    appStatus.Summary,
+				StartedAt:  startTime,
+				FinishedAt: now,
+			}
+		default:
+			cs.State.Waiting = &corev1.ContainerStateWaiting{Reason: "ContainerCreating", Message: appStatus.Summary}
+		}
+		containerStatuses = append(containerStatuses, cs)
+	}
+
+	initialized := corev1.ConditionTrue
+	if phase == corev1.PodPending {
+		initialized = corev1.ConditionFalse
+	}
+	ready := corev1.ConditionFalse
+	if allReady {
+		ready = corev1.ConditionTrue
+	}
+
+	status := &corev1.PodStatus{
+		Phase:             phase,
+		Message:           message,
+		HostIP:            hostIP,
+		PodIP:             hostIP,
+		StartTime:         &startTime,
+		ContainerStatuses: containerStatuses,
+		Conditions: []corev1.PodCondition{
+			{Type: corev1.PodScheduled, Status: corev1.ConditionTrue, LastTransitionTime: now},
+			{Type: corev1.PodInitialized, Status: initialized, LastTransitionTime: now, Reason: reason},
+			{Type: corev1.ContainersReady, Status: ready, LastTransitionTime: now, Reason: reason, Message: appStatus.Summary},
+			{Type: corev1.PodReady, Status: ready, LastTransitionTime: now, Reason: reason, Message: appStatus.Summary},
+		},
+	}
+	if hostIP != "" {
+		status.HostIPs = []corev1.HostIP{{IP: hostIP}}
+		status.PodIPs = []corev1.PodIP{{IP: hostIP}}
+	}
+	return status
+}
@@ -0,0 +1,210 @@
+package flightctl
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func statusTestPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "nginx", Image: "nginx:1.25"},
+				{Name: "sidecar", Image: "busybox:1.36"},
+			},
+		},
+	}
+}
+
+func conditionStatus(status *corev1.PodStatus, condType corev1.PodConditionType) corev1.ConditionStatus {
+	for _, c := range status.Conditions {
+		if c.Type == condType {
+			return c.Status
+		}
+	}
+	return ""
+}
+
+func TestMapFlightctlStatusRunning(t *testing.T) {
+	pm := &PodManager{}
+	status := pm.mapFlightctlStatusToPodStatus(statusTestPod(),
+		&FlightctlApplicationStatus{Name: "default-web", Status: "Running", Ready: "2/2", Restarts: 3}, "10.0.0.5")
+
+	if status.Phase != corev1.PodRunning {
+		t.Fatalf("expected Running, got %s", status.Phase)
+	}
+	if status.PodIP != "10.0.0.5" || status.HostIP != "10.0.0.5" {
+		t.Errorf("expected device IP on pod, got podIP=%q hostIP=%q", status.PodIP, status.HostIP)
+	}
+	if status.StartTime == nil {
+		t.Error("expected StartTime to be set")
+	}
+	for _, condType := range []corev1.PodConditionType{corev1.PodScheduled, corev1.PodInitialized, corev1.ContainersReady, corev1.PodReady} {
+		if got := conditionStatus(status, condType); got != corev1.ConditionTrue {
+			t.Errorf("condition %s = %q, want True", condType, got)
+		}
+	}
+	if len(status.ContainerStatuses) != 2 {
+		t.Fatalf("expected 2 container statuses, got %d", len(status.ContainerStatuses))
+	}
+	for _, cs := range status.ContainerStatuses {
+		if !cs.Ready || cs.State.Running == nil || cs.RestartCount != 3 {
+			t.Errorf("unexpected container status %+v", cs)
+		}
+	}
+	if status.ContainerStatuses[1].Image != "busybox:1.36" {
+		t.Errorf("expected image from pod spec, got %q", status.ContainerStatuses[1].Image)
+	}
+}
+
+func TestMapFlightctlStatusPartiallyReady(t *testing.T) {
+	pm := &PodManager{}
+	status := pm.mapFlightctlStatusToPodStatus(statusTestPod(),
+		&FlightctlApplicationStatus{Status: "Running", Ready: "1/2"}, "")
+
+	if got := conditionStatus(status, corev1.PodReady); got != corev1.ConditionFalse {
+		t.Errorf("Ready = %q, want False", got)
+	}
+	if !status.ContainerStatuses[0].Ready || status.ContainerStatuses[1].Ready {
+		t.Errorf("expected only the first container ready, got %+v", status.ContainerStatuses)
+	}
+}
+
+func TestMapFlightctlStatusError(t *testing.T) {
+	pm := &PodManager{}
+	status := pm.mapFlightctlStatusToPodStatus(statusTestPod(),
+		&FlightctlApplicationStatus{Status: "Error", Summary: "exited"}, "")
+
+	if status.Phase != corev1.PodFailed {
+		t.Fatalf("expected Failed, got %s", status.Phase)
+	}
+	term := status.ContainerStatuses[0].State.Terminated
+	if term == nil || term.Reason != "Error" {
+		t.Errorf("expected terminated container state, got %+v", status.ContainerStatuses[0].State)
+	}
+}
+
+func TestDeviceIP(t *testing.T) {
+	device := &FlightctlDevice{Status: &FlightctlDeviceStatus{
+		SystemInfo: map[string]interface{}{"netIPDefault": "192.168.1.20", "architecture": "arm64"},
+	}}
+	if ip := device.DeviceIP(); ip != "192.168.1.20" {
+		t.Errorf("DeviceIP() = %q", ip)
+	}
+	if ip := (&FlightctlDevice{}).DeviceIP(); ip != "" {
+		t.Errorf("expected empty IP without status, got %q", ip)
+	}
+}
+
+func TestDeviceSystemInfo(t *testing.T) {
+	device := &FlightctlDevice{Status: &FlightctlDeviceStatus{
+		SystemInfo: map[string]interface{}{
+			"operatingSystem": "linux",
+			"architecture":    "aarch64",
+			"kernel":          "5.14.0-427.el9.aarch64",
+			"distroName":      "Red Hat Enterprise Linux",
+			"distroVersion":   "9.4",
+			"agentVersion":    "v0.6.0",
+			"bootID":          "f0c1",
+		},
+	}}
+	info := device.ToModel().SystemInfo
+	if info.OperatingSystem != "linux" || info.Architecture != "arm64" {
+		t.Errorf("platform = %s/%s, want linux/arm64", info.OperatingSystem, info.Architecture)
+	}
+	if info.OSImage != "Red Hat Enterprise Linux 9.4" || info.KernelVersion != "5.14.0-427.el9.aarch64" {
+		t.Errorf("OS image %q, kernel %q", info.OSImage, info.KernelVersion)
+	}
+	if info.AgentVersion != "v0.6.0" || info.BootID != "f0c1" {
+		t.Errorf("agent version %q, boot ID %q", info.AgentVersion, info.BootID)
+	}
+}
+
+func TestDeviceIPs(t *testing.T) {
+	device := &FlightctlDevice{Status: &FlightctlDeviceStatus{
+		SystemInfo: map[string]interface{}{
+			"netIPDefault":       "192.168.1.20",
+			"ipAddress":          "192.168.1.20",
+			"netIPv6Default":     "fd00::20",
+			"defaultIPv6Address": "not an address",
+		},
+	}}
+	ips := device.ToModel().SystemInfo.IPAddresses
+	if len(ips) != 2 || ips[0] != "192.168.1.20" || ips[1] != "fd00::20" {
+		t.Errorf("IP addresses = %v, want the default address then the IPv6 one", ips)
+	}
+}
+
+func TestMapFlightctlStatusExitCodes(t *testing.T) {
+	pm := &PodManager{}
+	for _, tc := range []struct {
+		status, summary string
+		phase           corev1.PodPhase
+		exitCode        int32
+		reason          string
+	}{
+		{"Completed", "", corev1.PodSucceeded, 0, "Completed"},
+		{"Stopped", "Exited (0)", corev1.PodSucceeded, 0, "Completed"},
+		{"Stopped", "Exited (2)", corev1.PodFailed, 2, "Error"},
+		{"Error", "container job exited with code 3", corev1.PodFailed, 3, "Error"},
+		{"Error", "crashed", corev1.PodFailed, 1, "Error"},
+		{"Error", "container nginx OOMKilled", corev1.PodFailed, 137, "OOMKilled"},
+		{"Stopped", "Exited (137): out of memory", corev1.PodFailed, 137, "OOMKilled"},
+	} {
+		status := pm.mapFlightctlStatusToPodStatus(statusTestPod(),
+			&FlightctlApplicationStatus{Status: tc.status, Summary: tc.summary}, "")
+		if status.Phase != tc.phase {
+			t.Errorf("%s %q: phase = %s, want %s", tc.status, tc.summary, status.Phase, tc.phase)
+			continue
+		}
+		term := status.ContainerStatuses[0].State.Terminated
+		if term == nil || term.ExitCode != tc.exitCode || term.Reason != tc.reason || term.FinishedAt.IsZero() {
+			t.Errorf("%s %q: terminated state = %+v, want exit code %d and reason %s",
+				tc.status, tc.summary, term, tc.exitCode, tc.reason)
+		}
+	}
+}
+
+func TestMapFlightctlStatusContainerStatuses(t *testing.T) {
+	pm := &PodManager{}
+	code := func(c int32) *int32 { return &c }
+
+	status := pm.mapFlightctlStatusToPodStatus(statusTestPod(), &FlightctlApplicationStatus{
+		Status:   "Error",
+		Summary:  "crashed",
+		Restarts: 5,
+		Containers: []FlightctlContainerStatus{
+			{Name: "default-web-nginx-1", Status: "exited", ExitCode: code(137), Restarts: 4, OOMKilled: true},
+			{Name: "sidecar", Status: "exited", ExitCode: code(2), Restarts: 1},
+		},
+	}, "")
+	nginx, sidecar := status.ContainerStatuses[0], status.ContainerStatuses[1]
+	if term := nginx.State.Terminated; term == nil || term.ExitCode != 137 || term.Reason != "OOMKilled" || nginx.RestartCount != 4 {
+		t.Errorf("nginx: state = %+v, restarts = %d, want OOMKilled with exit code 137 and 4 restarts", term, nginx.RestartCount)
+	}
+	if term := sidecar.State.Terminated; term == nil || term.ExitCode != 2 || term.Reason != "Error" || sidecar.RestartCount != 1 {
+		t.Errorf("sidecar: state = %+v, restarts = %d, want Error with exit code 2 and 1 restart", term, sidecar.RestartCount)
+	}
+
+	status = pm.mapFlightctlStatusToPodStatus(statusTestPod(), &FlightctlApplicationStatus{
+		Status: "Running",
+		Ready:  "1/2",
+		Containers: []FlightctlContainerStatus{
+			{Name: "nginx", Status: "running", ExitCode: code(137), Restarts: 2, Reason: "OOMKilled"},
+			{Name: "sidecar", Status: "exited", ExitCode: code(0)},
+		},
+	}, "")
+	nginx, sidecar = status.ContainerStatuses[0], status.ContainerStatuses[1]
+	if nginx.State.Running == nil || !nginx.Ready || nginx.RestartCount != 2 {
+		t.Errorf("nginx: state = %+v, ready = %v, restarts = %d, want running and ready with 2 restarts", nginx.State, nginx.Ready, nginx.RestartCount)
+	}
+	if last := nginx.LastTerminationState.Terminated; last == nil || last.ExitCode != 137 || last.Reason != "OOMKilled" {
+		t.Errorf("nginx: last termination = %+v, want OOMKilled with exit code 137", last)
+	}
+	if term := sidecar.State.Terminated; term == nil || term.ExitCode != 0 || term.Reason != "Completed" || sidecar.Ready {
+		t.Errorf("sidecar: state = %+v, ready = %v, want completed and not ready", term, sidecar.Ready)
+	}
+}
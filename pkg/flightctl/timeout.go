@@ -0,0 +1,75 @@
+package flightctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// OperationTimeouts bound the calls of the client by kind of operation. A
+// bound covers the whole call: its retries, the backoff between them and,
+// for lists, every page. A zero timeout takes its default, and a negative
+// one leaves the calls of its kind bounded by their caller's context alone.
+// Console sessions are never bounded.
+type OperationTimeouts struct {
+	// Read bounds getting a device or fleet, and pings.
+	Read time.Duration
+	// List bounds listing devices, fleets and enrollment requests.
+	List time.Duration
+	// Write bounds updating a device or fleet.
+	Write time.Duration
+}
+
+// DefaultOperationTimeouts are the operation timeouts used for those not
+// configured.
+var DefaultOperationTimeouts = OperationTimeouts{
+	Read:  time.Minute,
+	List:  2 * time.Minute,
+	Write: time.Minute,
+}
+
+// withDefaults fills in the timeouts not configured.
+func (t OperationTimeouts) withDefaults() OperationTimeouts {
+	if t.Read == 0 {
+		t.Read = DefaultOperationTimeouts.Read
+	}
+	if t.List == 0 {
+		t.List = DefaultOperationTimeouts.List
+	}
+	if t.Write == 0 {
+		t.Write = DefaultOperationTimeouts.Write
+	}
+	return t
+}
+
+// ErrTimeout indicates a call ran out of its operation timeout. Unlike calls
+// given up by their caller, these count as failures of the API for the
+// circuit breaker.
+var ErrTimeout = &FlightctlError{Code: "Timeout", Message: "FlightCtl API call timed out"}
+
+// startOperation bounds a call by the timeout of its kind of operation. The
+// returned function ends the call: it releases the deadline and turns an
+// error caused by it into an ErrTimeout error.
+func startOperation(ctx context.Context, timeout time.Duration) (context.Context, func(err *error)) {
+	if timeout <= 0 {
+		return ctx, func(*error) {}
+	}
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, ErrTimeout)
+	return ctx, func(err *error) {
+		defer cancel()
+		if *err != nil && operationTimedOut(ctx) && !errors.Is(*err, ErrTimeout) {
+			*err = &FlightctlError{
+				Code:    ErrTimeout.Code,
+				Message: ErrTimeout.Message,
+				Details: fmt.Sprintf("no result within %s: %v", timeout, *err),
+			}
+		}
+	}
+}
+
+// operationTimedOut reports whether ctx expired because of the operation
+// timeout rather than its caller.
+func operationTimedOut(ctx context.Context) bool {
+	return ctx.Err() != nil && errors.Is(context.Cause(ctx), ErrTimeout)
+}
@@ -0,0 +1,65 @@
+package flightctl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOperationTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A hung API
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	breaker := &breakerTransport{
+		base:   srv.Client().Transport,
+		policy: BreakerPolicy{FailureThreshold: 1, Cooldown: time.Minute}.withDefaults(),
+	}
+	client := &Client{
+		httpClient: &http.Client{Transport: breaker},
+		baseURL:    srv.URL,
+		breaker:    breaker,
+		timeouts:   OperationTimeouts{Read: 20 * time.Millisecond, List: -1, Write: -1},
+	}
+
+	// Giving up before the operation timeout is the caller's choice
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := client.GetFleet(ctx, "edge")
+	if errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetFleet with a shorter caller deadline: err=%v, want the caller's deadline", err)
+	}
+	if !client.UnavailableSince().IsZero() {
+		t.Fatal("call given up by its caller counted as an API failure")
+	}
+
+	start := time.Now()
+	_, err = client.GetFleet(context.Background(), "edge")
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("GetFleet of a hung API: err=%v, want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GetFleet returned after %s, want about the 20ms read timeout", elapsed)
+	}
+	if client.UnavailableSince().IsZero() {
+		t.Error("timed out call not counted as an API failure")
+	}
+}
+
+func TestOperationTimeoutsDefaults(t *testing.T) {
+	timeouts := OperationTimeouts{List: -1, Write: time.Second}.withDefaults()
+	want := OperationTimeouts{Read: DefaultOperationTimeouts.Read, List: -1, Write: time.Second}
+	if timeouts != want {
+		t.Errorf("withDefaults() = %+v, want %+v", timeouts, want)
+	}
+}
@@ -0,0 +1,149 @@
+package flightctl
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// tlsFiles builds the TLS configuration for FlightCtl connections from a
+// client certificate, key and CA bundle on disk, plus an optional inline CA
+// bundle. The files are checked on every new connection and reloaded when
+// they change, so rotated certificates are used without a restart.
+// Established connections keep the configuration they were opened with.
+type tlsFiles struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	caData   []byte
+	insecure bool
+
+	mu     sync.Mutex
+	stamp  string
+	config *tls.Config
+}
+
+// newTLSFiles returns nil if no certificates or CA bundles are configured.
+func newTLSFiles(cfg Config) (*tlsFiles, error) {
+	if cfg.ClientCertFile == "" && cfg.ClientKeyFile == "" && cfg.CAFile == "" && len(cfg.CAData) == 0 {
+		return nil, nil
+	}
+	if (cfg.ClientCertFile == "") != (cfg.ClientKeyFile == "") {
+		return nil, fmt.Errorf("Flightctl client certificate and key must be set together")
+	}
+
+	f := &tlsFiles{
+		certFile: cfg.ClientCertFile,
+		keyFile:  cfg.ClientKeyFile,
+		caFile:   cfg.CAFile,
+		caData:   cfg.CAData,
+		insecure: cfg.InsecureTLS,
+	}
+	// Fail fast on unreadable or invalid files
+	if _, err := f.current(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// current returns the TLS configuration, reloading it if any file changed.
+// If a reload fails (e.g. the files are being rotated) the previous
+// configuration is kept.
+func (f *tlsFiles) current() (*tls.Config, error) {
+	stamp, err := f.fileStamp()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err == nil && stamp == f.stamp && f.config != nil {
+		return f.config, nil
+	}
+	config, loadErr := f.load()
+	if err == nil {
+		err = loadErr
+	}
+	if err != nil {
+		if f.config != nil {
+			logger.Warn("Reloading FlightCtl TLS files failed, keeping previous certificates: %v", err)
+			return f.config, nil
+		}
+		return nil, err
+	}
+
+	if f.config != nil {
+		logger.Info("Reloaded FlightCtl TLS certificates")
+	}
+	f.stamp = stamp
+	f.config = config
+	return config, nil
+}
+
+// fileStamp summarizes the modification time and size of the files.
+func (f *tlsFiles) fileStamp() (string, error) {
+	var b strings.Builder
+	for _, path := range []string{f.certFile, f.keyFile, f.caFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", fmt.Errorf("reading TLS file: %w", err)
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", path, info.ModTime().UnixNano(), info.Size())
+	}
+	return b.String(), nil
+}
+
+func (f *tlsFiles) load() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: f.insecure,
+	}
+
+	if f.caFile != "" || len(f.caData) > 0 {
+		pool := x509.NewCertPool()
+		if f.caFile != "" {
+			pem, err := os.ReadFile(f.caFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading CA bundle: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA bundle %s", f.caFile)
+			}
+		}
+		if len(f.caData) > 0 && !pool.AppendCertsFromPEM(f.caData) {
+			return nil, fmt.Errorf("no certificates found in inline CA bundle")
+		}
+		config.RootCAs = pool
+	}
+
+	if f.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// dialTLSContext dials a TLS connection using the current configuration.
+func (f *tlsFiles) dialTLSContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	config, err := f.current()
+	if err != nil {
+		return nil, err
+	}
+	config = config.Clone()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		config.ServerName = host
+	}
+	dialer := &tls.Dialer{Config: config}
+	return dialer.DialContext(ctx, network, addr)
+}
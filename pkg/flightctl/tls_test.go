@@ -0,0 +1,180 @@
+package flightctl
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert issues a certificate signed by parent, or a self-signed CA if
+// parent is nil.
+func newTestCert(t *testing.T, cn string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMutualTLSClientCertificate(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil, 0)
+	serverCert := newTestCert(t, "server", ca, x509.ExtKeyUsageServerAuth)
+	clientCert := newTestCert(t, "provider", ca, x509.ExtKeyUsageClientAuth)
+
+	var seenCN string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenCN = r.TLS.PeerCertificates[0].Subject.CommonName
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.cert.Raw}, PrivateKey: serverCert.key}},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	writeFile(t, certFile, clientCert.certPEM)
+	writeFile(t, keyFile, clientCert.keyPEM)
+	writeFile(t, caFile, ca.certPEM)
+
+	client, err := NewClient(Config{
+		APIURL:         srv.URL,
+		AuthMode:       AuthModeNone,
+		ClientCertFile: certFile,
+		ClientKeyFile:  keyFile,
+		CAFile:         caFile,
+	})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if seenCN != "provider" {
+		t.Errorf("server saw client certificate %q, want provider", seenCN)
+	}
+
+	// Rotate the client certificate; new connections use it
+	rotated := newTestCert(t, "provider-rotated", ca, x509.ExtKeyUsageClientAuth)
+	writeFile(t, certFile, rotated.certPEM)
+	writeFile(t, keyFile, rotated.keyPEM)
+	future := time.Now().Add(time.Minute)
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.Chtimes(path, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client.httpClient.CloseIdleConnections()
+
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping after rotation: %v", err)
+	}
+	if seenCN != "provider-rotated" {
+		t.Errorf("server saw client certificate %q after rotation, want provider-rotated", seenCN)
+	}
+}
+
+func TestInlineCABundle(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil, 0)
+	serverCert := newTestCert(t, "server", ca, x509.ExtKeyUsageServerAuth)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.cert.Raw}, PrivateKey: serverCert.key}},
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	untrusted, err := NewClient(Config{APIURL: srv.URL, AuthMode: AuthModeToken, Token: "t"})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if err := untrusted.Ping(context.Background()); err == nil {
+		t.Fatal("expected verification to fail without the CA bundle")
+	}
+
+	client, err := NewClient(Config{APIURL: srv.URL, AuthMode: AuthModeToken, Token: "t", CAData: ca.certPEM})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping with inline CA bundle: %v", err)
+	}
+}
+
+func TestNewClientRejectsInvalidCABundle(t *testing.T) {
+	_, err := NewClient(Config{APIURL: "https://api", AuthMode: AuthModeToken, Token: "t", CAData: []byte("not a certificate")})
+	if err == nil {
+		t.Fatal("expected an error for an invalid CA bundle")
+	}
+}
+
+func TestNewClientRejectsCertWithoutKey(t *testing.T) {
+	_, err := NewClient(Config{APIURL: "https://api", AuthMode: AuthModeNone, ClientCertFile: "tls.crt"})
+	if err == nil {
+		t.Fatal("expected an error for a client certificate without a key")
+	}
+}
@@ -0,0 +1,168 @@
+package flightctl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodTranslator converts a Kubernetes pod into the inline content of a
+// FlightCtl application. Implementations return the files to place on the
+// device together with the FlightCtl appType they target.
+type PodTranslator interface {
+	Translate(pod *corev1.Pod) ([]InlineContent, string, error)
+}
+
+// AppPodTranslator is implemented by translators whose content refers to
+// the name of the application, such as the quadlet unit names. The pod
+// manager calls TranslateApp with the name it chose for the application
+// instead of Translate.
+type AppPodTranslator interface {
+	TranslateApp(pod *corev1.Pod, appName string) ([]InlineContent, string, error)
+}
+
+// PodTranslatorFunc adapts a plain function to the PodTranslator interface.
+type PodTranslatorFunc func(pod *corev1.Pod) ([]InlineContent, string, error)
+
+// Translate implements PodTranslator.
+func (f PodTranslatorFunc) Translate(pod *corev1.Pod) ([]InlineContent, string, error) {
+	return f(pod)
+}
+
+// composeTranslator renders a pod as a single compose file, named file
+// unless the pod names another.
+type composeTranslator struct {
+	file string
+}
+
+// NewComposeTranslator returns the compose translator writing compose files
+// named file (DefaultComposeFile if empty), e.g. to register it with
+// another default compose file.
+func NewComposeTranslator(file string) PodTranslator {
+	if file == "" {
+		file = DefaultComposeFile
+	}
+	return composeTranslator{file: file}
+}
+
+// Translate implements PodTranslator.
+func (t composeTranslator) Translate(pod *corev1.Pod) ([]InlineContent, string, error) {
+	if _, err := sharedNetworks(pod); err != nil {
+		return nil, "", err
+	}
+	file, err := composeFile(pod, t.file)
+	if err != nil {
+		return nil, "", err
+	}
+	envFiles, err := envFromFiles(pod, "", composeEnvFileValue)
+	if err != nil {
+		return nil, "", err
+	}
+	content := convertPodToDockerCompose(pod)
+	if content == "" {
+		return nil, "", fmt.Errorf("pod %s/%s has no containers to translate", pod.Namespace, pod.Name)
+	}
+	files := []InlineContent{{Path: file, Content: content}}
+	files = append(files, envFiles...)
+	return append(files, hookWrapperFiles(pod, "")...), AppTypeCompose, nil
+}
+
+// quadletTranslator renders a pod as systemd quadlet units.
+type quadletTranslator struct{}
+
+// Translate implements PodTranslator.
+func (t quadletTranslator) Translate(pod *corev1.Pod) ([]InlineContent, string, error) {
+	return t.TranslateApp(pod, applicationName(pod))
+}
+
+// TranslateApp implements AppPodTranslator.
+func (quadletTranslator) TranslateApp(pod *corev1.Pod, appName string) ([]InlineContent, string, error) {
+	if _, err := sharedNetworks(pod); err != nil {
+		return nil, "", err
+	}
+	if _, err := envFromFiles(pod, "", podmanEnvFileValue); err != nil {
+		return nil, "", err
+	}
+	units := convertPodToQuadlet(pod, appName)
+	if len(units) == 0 {
+		return nil, "", fmt.Errorf("pod %s/%s has no containers to translate", pod.Namespace, pod.Name)
+	}
+	return units, AppTypeQuadlet, nil
+}
+
+// TranslatorRegistry holds the available pod translators keyed by the
+// value of the flightctl.io/app-type annotation.
+type TranslatorRegistry struct {
+	mu          sync.RWMutex
+	translators map[string]PodTranslator
+	defaultType string
+}
+
+// NewTranslatorRegistry creates a registry with the built-in compose and
+// quadlet translators. Pods without an app-type annotation use defaultType
+// (compose if empty).
+func NewTranslatorRegistry(defaultType string) *TranslatorRegistry {
+	if defaultType == "" {
+		defaultType = AppTypeCompose
+	}
+
+	r := &TranslatorRegistry{
+		translators: make(map[string]PodTranslator),
+		defaultType: strings.ToLower(defaultType),
+	}
+	r.Register(AppTypeCompose, NewComposeTranslator(""))
+	r.Register(AppTypeQuadlet, quadletTranslator{})
+	return r
+}
+
+// Register adds or replaces the translator for an app type.
+func (r *TranslatorRegistry) Register(appType string, translator PodTranslator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.translators[strings.ToLower(appType)] = translator
+}
+
+// Types returns the registered app types in sorted order.
+func (r *TranslatorRegistry) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	types := make([]string, 0, len(r.translators))
+	for appType := range r.translators {
+		types = append(types, appType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// ForPod returns the translator selected by the pod's annotation, or the
+// registry default when the annotation is absent.
+func (r *TranslatorRegistry) ForPod(pod *corev1.Pod) (PodTranslator, error) {
+	translator, _, err := r.lookup(pod)
+	return translator, err
+}
+
+// AppType returns the app type selected by the pod's annotation, or the
+// registry default when the annotation is absent.
+func (r *TranslatorRegistry) AppType(pod *corev1.Pod) (string, error) {
+	_, appType, err := r.lookup(pod)
+	return appType, err
+}
+
+func (r *TranslatorRegistry) lookup(pod *corev1.Pod) (PodTranslator, string, error) {
+	appType := r.defaultType
+	if requested, ok := pod.Annotations[AppTypeAnnotation]; ok && requested != "" {
+		appType = strings.ToLower(requested)
+	}
+
+	r.mu.RLock()
+	translator, ok := r.translators[appType]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported app type %q (supported: %s)", appType, strings.Join(r.Types(), ", "))
+	}
+	return translator, appType, nil
+}
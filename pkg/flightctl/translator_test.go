@@ -0,0 +1,114 @@
+package flightctl
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConvertPodToQuadlet(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyOnFailure,
+			Containers: []corev1.Container{
+				{
+					Name:  "nginx",
+					Image: "nginx:1.21",
+					Args:  []string{"-g", "daemon off;"},
+					Ports: []corev1.ContainerPort{{ContainerPort: 80}},
+					Env:   []corev1.EnvVar{{Name: "MODE", Value: "edge"}},
+				},
+			},
+		},
+	}
+
+	units := convertPodToQuadlet(pod, "default-web")
+	if len(units) != 2 {
+		t.Fatalf("Expected 2 units (pod + container), got %d", len(units))
+	}
+
+	if units[0].Path != "default-web.pod" {
+		t.Errorf("Expected pod unit path default-web.pod, got %s", units[0].Path)
+	}
+	if !strings.Contains(units[0].Content, "PublishPort=80:80") {
+		t.Errorf("Expected pod unit to publish port 80, got:\n%s", units[0].Content)
+	}
+
+	if units[1].Path != "default-web-nginx.container" {
+		t.Errorf("Expected container unit path default-web-nginx.container, got %s", units[1].Path)
+	}
+	expectedStrings := []string{
+		"Image=nginx:1.21",
+		"Pod=default-web.pod",
+		`Exec=-g "daemon off;"`,
+		"Environment=MODE=edge",
+		"Restart=on-failure",
+	}
+	for _, expected := range expectedStrings {
+		if !strings.Contains(units[1].Content, expected) {
+			t.Errorf("Expected container unit to contain '%s', got:\n%s", expected, units[1].Content)
+		}
+	}
+}
+
+func TestPodToFlightctlApplication_AppTypeAnnotation(t *testing.T) {
+	pm := NewPodManager(nil)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			Annotations: map[string]string{AppTypeAnnotation: "quadlet"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.21"}},
+		},
+	}
+
+	app, err := pm.podToFlightctlApplication(pod)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if app.AppType != AppTypeQuadlet {
+		t.Errorf("Expected appType %s, got %s", AppTypeQuadlet, app.AppType)
+	}
+
+	delete(pod.Annotations, AppTypeAnnotation)
+	app, err = pm.podToFlightctlApplication(pod)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if app.AppType != AppTypeCompose {
+		t.Errorf("Expected default appType %s, got %s", AppTypeCompose, app.AppType)
+	}
+	if len(app.Inline) != 1 || app.Inline[0].Path != "podman-compose.yaml" {
+		t.Errorf("Expected single podman-compose.yaml inline file, got %+v", app.Inline)
+	}
+}
+
+func TestTranslatorRegistry_UnknownAppType(t *testing.T) {
+	registry := NewTranslatorRegistry("")
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			Annotations: map[string]string{AppTypeAnnotation: "helm"},
+		},
+	}
+
+	if _, err := registry.ForPod(pod); err == nil {
+		t.Error("Expected error for unregistered app type")
+	}
+
+	registry.Register("helm", PodTranslatorFunc(func(pod *corev1.Pod) ([]InlineContent, string, error) {
+		return []InlineContent{{Path: "Chart.yaml"}}, "helm", nil
+	}))
+	if _, err := registry.ForPod(pod); err != nil {
+		t.Errorf("Expected registered translator to be found, got %v", err)
+	}
+}
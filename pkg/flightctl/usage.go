@@ -0,0 +1,129 @@
+package flightctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// FlightCtl reports device resource health but not usage or disk size, so
+// they are read from custom system info: the agent runs the executables in
+// /usr/lib/flightctl/custom-info.d and reports their output under
+// status.systemInfo.customInfo, keyed by executable name.
+const (
+	// customInfoKey holds the custom system info in the device status.
+	customInfoKey = "customInfo"
+
+	// CPUUsageInfoKey reports the CPU cores in use on the device, as a
+	// quantity such as "1500m".
+	CPUUsageInfoKey = "cpuUsage"
+	// MemoryUsageInfoKey reports the memory in use (working set) on the
+	// device, as a quantity such as "3Gi".
+	MemoryUsageInfoKey = "memoryUsage"
+	// ContainerUsageInfoKey reports per-container usage as a JSON array of
+	// ContainerUsage.
+	ContainerUsageInfoKey = "containerUsage"
+	// EphemeralStorageInfoKey reports the disk space the device has for
+	// containers, as a quantity such as "64Gi".
+	EphemeralStorageInfoKey = "ephemeralStorage"
+)
+
+// DeviceUsage is the resource usage a device reports.
+type DeviceUsage struct {
+	// Time the usage was reported (the device's last status update).
+	Time time.Time
+	// CPU and Memory are nil when the device does not report them.
+	CPU        *resource.Quantity
+	Memory     *resource.Quantity
+	Containers []ContainerUsage
+}
+
+// ContainerUsage is the resource usage of a container of an application.
+type ContainerUsage struct {
+	// Application is the FlightCtl application name.
+	Application string `json:"app"`
+	// Container is the container (compose service) name.
+	Container string            `json:"container"`
+	CPU       resource.Quantity `json:"cpu"`
+	Memory    resource.Quantity `json:"memory"`
+}
+
+// Usage returns the resource usage the device reports in its custom system
+// info, or false if it reports none.
+func (d *FlightctlDevice) Usage() (*DeviceUsage, bool, error) {
+	if d == nil || d.Status == nil {
+		return nil, false, nil
+	}
+	info, ok := d.Status.SystemInfo[customInfoKey].(map[string]interface{})
+	if !ok {
+		return nil, false, nil
+	}
+
+	usage := &DeviceUsage{Time: time.Now()}
+	if d.Status.LastSeen != nil {
+		usage.Time = *d.Status.LastSeen
+	}
+	found := false
+	for key, target := range map[string]**resource.Quantity{CPUUsageInfoKey: &usage.CPU, MemoryUsageInfoKey: &usage.Memory} {
+		value, ok := info[key].(string)
+		if !ok || value == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, false, fmt.Errorf("parsing %s %q of device %s: %w", key, value, d.Metadata.Name, err)
+		}
+		*target = &q
+		found = true
+	}
+	if value, ok := info[ContainerUsageInfoKey].(string); ok && value != "" {
+		if err := json.Unmarshal([]byte(value), &usage.Containers); err != nil {
+			return nil, false, fmt.Errorf("parsing %s of device %s: %w", ContainerUsageInfoKey, d.Metadata.Name, err)
+		}
+		found = true
+	}
+	if !found {
+		return nil, false, nil
+	}
+	return usage, true, nil
+}
+
+// PodUsage returns the usage of the containers of a pod's application,
+// named appName, keyed by pod container name.
+func (u *DeviceUsage) PodUsage(pod *corev1.Pod, appName string) map[string]ContainerUsage {
+	containers := make(map[string]ContainerUsage)
+	for _, container := range pod.Spec.Containers {
+		service := sanitizeServiceName(container.Name)
+		for _, c := range u.Containers {
+			if c.Application == appName && c.Container == service {
+				containers[container.Name] = c
+				break
+			}
+		}
+	}
+	return containers
+}
+
+// reportedStorage returns the disk space for containers the device reports
+// in its custom system info, or zero if it reports none.
+func (d *FlightctlDevice) reportedStorage() resource.Quantity {
+	if d == nil || d.Status == nil {
+		return resource.Quantity{}
+	}
+	info, _ := d.Status.SystemInfo[customInfoKey].(map[string]interface{})
+	value, ok := info[EphemeralStorageInfoKey].(string)
+	if !ok || value == "" {
+		return resource.Quantity{}
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil || q.Sign() < 0 {
+		logger.Warn("Device %s reports invalid %s %q: %v", d.Metadata.Name, EphemeralStorageInfoKey, value, err)
+		return resource.Quantity{}
+	}
+	return q
+}
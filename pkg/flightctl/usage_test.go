@@ -0,0 +1,85 @@
+package flightctl
+
+import (
+	"testing"
+	"time"
+)
+
+func usageTestDevice(customInfo map[string]interface{}) *FlightctlDevice {
+	lastSeen := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &FlightctlDevice{
+		Metadata: FlightctlDeviceMetadata{Name: "device-1"},
+		Status: &FlightctlDeviceStatus{
+			SystemInfo: map[string]interface{}{"architecture": "arm64", "customInfo": customInfo},
+			LastSeen:   &lastSeen,
+		},
+	}
+}
+
+func TestDeviceUsage(t *testing.T) {
+	device := usageTestDevice(map[string]interface{}{
+		"cpuUsage":       "1500m",
+		"memoryUsage":    "2Gi",
+		"containerUsage": `[{"app":"default-web","container":"nginx","cpu":"250m","memory":"64Mi"},{"app":"other-app","container":"nginx","cpu":"1","memory":"1Gi"}]`,
+	})
+
+	usage, ok, err := device.Usage()
+	if err != nil || !ok {
+		t.Fatalf("Usage() = %v, %v", ok, err)
+	}
+	if usage.CPU.MilliValue() != 1500 || usage.Memory.Value() != 2<<30 {
+		t.Errorf("unexpected device usage cpu=%s memory=%s", usage.CPU, usage.Memory)
+	}
+	if !usage.Time.Equal(*device.Status.LastSeen) {
+		t.Errorf("expected usage time from lastSeen, got %s", usage.Time)
+	}
+
+	containers := usage.PodUsage(statusTestPod(), "default-web")
+	if len(containers) != 1 {
+		t.Fatalf("expected usage of one container of the pod, got %+v", containers)
+	}
+	if nginx := containers["nginx"]; nginx.CPU.MilliValue() != 250 || nginx.Memory.Value() != 64<<20 {
+		t.Errorf("unexpected nginx usage %+v", nginx)
+	}
+}
+
+func TestDeviceUsageNotReported(t *testing.T) {
+	for name, device := range map[string]*FlightctlDevice{
+		"no status":      {},
+		"no custom info": {Status: &FlightctlDeviceStatus{SystemInfo: map[string]interface{}{"architecture": "arm64"}}},
+		"other info":     usageTestDevice(map[string]interface{}{"site": "north"}),
+	} {
+		if _, ok, err := device.Usage(); ok || err != nil {
+			t.Errorf("%s: Usage() = %v, %v, want no usage", name, ok, err)
+		}
+	}
+}
+
+func TestDeviceUsageInvalid(t *testing.T) {
+	for _, info := range []map[string]interface{}{
+		{"cpuUsage": "lots"},
+		{"containerUsage": "not json"},
+	} {
+		if _, _, err := usageTestDevice(info).Usage(); err == nil {
+			t.Errorf("expected an error for %v", info)
+		}
+	}
+}
+
+func TestDeviceStorageCapacity(t *testing.T) {
+	device := usageTestDevice(map[string]interface{}{"ephemeralStorage": "64Gi"})
+	if storage := device.ToModel().Capacity.EphemeralStorage; storage.String() != "64Gi" {
+		t.Errorf("reported storage = %s, want 64Gi", storage.String())
+	}
+
+	// The capacity label overrides the reported size
+	device.Metadata.Labels = map[string]string{CapacityEphemeralStorageLabel: "32Gi"}
+	if storage := device.ToModel().Capacity.EphemeralStorage; storage.String() != "32Gi" {
+		t.Errorf("labelled storage = %s, want 32Gi", storage.String())
+	}
+
+	invalid := usageTestDevice(map[string]interface{}{"ephemeralStorage": "plenty"})
+	if storage := invalid.ToModel().Capacity.EphemeralStorage; !storage.IsZero() {
+		t.Errorf("invalid storage = %s, want unknown", storage.String())
+	}
+}
@@ -0,0 +1,158 @@
+package flightctl
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// serviceAccountVolumePrefix names the projected service account token
+// volume the API server adds to every pod. It is not needed on devices, so
+// it is dropped without a warning.
+const serviceAccountVolumePrefix = "kube-api-access-"
+
+// UnsupportedFeature is a pod feature that the translation to a FlightCtl
+// application drops.
+type UnsupportedFeature struct {
+	Field   string // Path of the pod field, e.g. spec.hostPID
+	Message string
+}
+
+func (f UnsupportedFeature) String() string {
+	return f.Field + ": " + f.Message
+}
+
+// ValidatePodAnnotations checks the flightctl.io annotations the translation
+// of a pod reads: its repository content, shared networks, compose file and
+// inline files.
+func ValidatePodAnnotations(pod *corev1.Pod) error {
+	if _, err := repoRef(pod); err != nil {
+		return err
+	}
+	if _, err := composeFile(pod, DefaultComposeFile); err != nil {
+		return err
+	}
+	if _, err := inlineFiles(pod); err != nil {
+		return err
+	}
+	_, err := sharedNetworks(pod)
+	return err
+}
+
+// UnsupportedFeatures returns the features of a pod that do not survive the
+// translation to a FlightCtl application, in field order. Pods deploying
+// repository content are not translated and have none.
+func UnsupportedFeatures(pod *corev1.Pod) []UnsupportedFeature {
+	if IsRepoPod(pod) {
+		// The content comes from the repository, not from the pod spec
+		return nil
+	}
+	var features []UnsupportedFeature
+	add := func(field, format string, args ...interface{}) {
+		features = append(features, UnsupportedFeature{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	spec := &pod.Spec
+	if spec.HostPID {
+		add("spec.hostPID", "containers do not share the device process namespace")
+	}
+	if spec.HostIPC {
+		add("spec.hostIPC", "containers do not share the device IPC namespace")
+	}
+	if spec.HostNetwork && spec.DNSConfig != nil {
+		add("spec.dnsConfig", "containers on the host network use the device's resolver settings")
+	}
+	if len(spec.ImagePullSecrets) > 0 {
+		add("spec.imagePullSecrets", "images are pulled with the device's registry credentials")
+	}
+	for _, volume := range spec.Volumes {
+		if strings.HasPrefix(volume.Name, serviceAccountVolumePrefix) {
+			continue
+		}
+		add(fmt.Sprintf("spec.volumes[%s]", volume.Name), "volumes are not created on the device")
+	}
+
+	for _, service := range podServices(pod) {
+		container := service.container
+		path := fmt.Sprintf("spec.containers[%s]", container.Name)
+		if service.init || service.sidecar {
+			path = fmt.Sprintf("spec.initContainers[%s]", container.Name)
+		}
+		for _, env := range container.Env {
+			if _, ok := envValue(pod, env); !ok && env.ValueFrom != nil {
+				add(fmt.Sprintf("%s.env[%s].valueFrom", path, env.Name), "the variable is not set")
+			}
+		}
+		if len(container.EnvFrom) > 0 {
+			add(path+".envFrom", "the variables are not set")
+		}
+		for _, mount := range container.VolumeMounts {
+			if strings.HasPrefix(mount.Name, serviceAccountVolumePrefix) {
+				continue
+			}
+			add(fmt.Sprintf("%s.volumeMounts[%s]", path, mount.Name), "the volume is not mounted")
+		}
+		for _, port := range container.Ports {
+			if !spec.HostNetwork && port.Protocol != "" && port.Protocol != corev1.ProtocolTCP {
+				add(fmt.Sprintf("%s.ports[%d]", path, port.ContainerPort), "only TCP ports are published, not %s", port.Protocol)
+			}
+		}
+		if container.WorkingDir != "" {
+			add(path+".workingDir", "the image's working directory is used")
+		}
+		if limitsCPUOrMemory(container) {
+			add(path+".resources.limits", "limits are not enforced on the device")
+		}
+		for _, probe := range []struct {
+			field string
+			probe *corev1.Probe
+		}{
+			{"livenessProbe", container.LivenessProbe},
+			{"readinessProbe", container.ReadinessProbe},
+			{"startupProbe", container.StartupProbe},
+		} {
+			if _, checked := startupCheck(container); probe.field == "startupProbe" && checked {
+				// Gates the start of the containers after the sidecar
+				continue
+			}
+			if probe.probe != nil {
+				add(path+"."+probe.field, "probes are not run; readiness follows the application status")
+			}
+		}
+		if lifecycle := container.Lifecycle; lifecycle != nil {
+			for _, hook := range []struct {
+				field   string
+				handler *corev1.LifecycleHandler
+			}{
+				{"postStart", lifecycle.PostStart},
+				{"preStop", lifecycle.PreStop},
+			} {
+				if _, ok := hookCommand(hook.handler); hook.handler != nil && !ok {
+					add(path+".lifecycle."+hook.field, "only exec and sleep hooks are run")
+				}
+			}
+			if len(container.Command) == 0 && (lifecycle.PostStart != nil || lifecycle.PreStop != nil) {
+				add(path+".lifecycle", "hooks are only run for containers that set their command")
+			}
+		}
+		if security := securityOf(pod, container); security.runAsGroup != nil && security.runAsUser == nil {
+			add(path+".securityContext.runAsGroup", "the group is only applied together with runAsUser")
+		}
+	}
+
+	return features
+}
+
+// limitsCPUOrMemory reports whether a container has limits other than on
+// extended resources, which are requested through their limits.
+func limitsCPUOrMemory(container corev1.Container) bool {
+	for name := range container.Resources.Limits {
+		if !models.IsExtendedResourceName(name) {
+			return true
+		}
+	}
+	return false
+}
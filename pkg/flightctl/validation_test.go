@@ -0,0 +1,50 @@
+package flightctl
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestUnsupportedFeatures(t *testing.T) {
+	pod := statusTestPod()
+	pod.Spec.HostPID = true
+	pod.Spec.Volumes = []corev1.Volume{
+		{Name: "kube-api-access-x7k2p"},
+		{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}},
+	}
+	pod.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{
+		{Name: "kube-api-access-x7k2p", MountPath: "/var/run/secrets/kubernetes.io/serviceaccount"},
+		{Name: "data", MountPath: "/data"},
+	}
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{
+		{Name: "MODE", Value: "edge"},
+		{Name: "TOKEN", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{Key: "token"}}},
+	}
+	pod.Spec.Containers[0].Ports = []corev1.ContainerPort{{ContainerPort: 53, Protocol: corev1.ProtocolUDP}}
+	pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")}
+	pod.Spec.Containers[0].ReadinessProbe = &corev1.Probe{}
+
+	var fields []string
+	for _, feature := range UnsupportedFeatures(pod) {
+		fields = append(fields, feature.Field)
+	}
+	want := []string{
+		"spec.hostPID",
+		"spec.volumes[data]",
+		"spec.containers[nginx].env[TOKEN].valueFrom",
+		"spec.containers[nginx].volumeMounts[data]",
+		"spec.containers[nginx].ports[53]",
+		"spec.containers[nginx].resources.limits",
+		"spec.containers[nginx].readinessProbe",
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("unsupported fields = %v, want %v", fields, want)
+	}
+
+	if features := UnsupportedFeatures(statusTestPod()); len(features) != 0 {
+		t.Errorf("plain pod has unsupported features %v", features)
+	}
+}
@@ -0,0 +1,145 @@
+// Package health serves the liveness and readiness endpoints used by the
+// Deployment's probes.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// DefaultCheckTimeout bounds how long a single readiness check may take.
+const DefaultCheckTimeout = 5 * time.Second
+
+// Check reports an error if a dependency is not ready.
+type Check func(ctx context.Context) error
+
+// Server serves /healthz (process alive) and /readyz (all readiness checks
+// pass). Once shutdown starts, /readyz fails so the pod is removed from
+// service before the process exits.
+type Server struct {
+	server       *http.Server
+	mux          *http.ServeMux
+	checkTimeout time.Duration
+	shuttingDown atomic.Bool
+
+	mu     sync.RWMutex
+	checks map[string]Check
+}
+
+// NewServer creates a health server listening on addr.
+func NewServer(addr string) *Server {
+	s := &Server{
+		checkTimeout: DefaultCheckTimeout,
+		checks:       make(map[string]Check),
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/healthz", s.handleHealthz)
+	s.mux.HandleFunc("/readyz", s.handleReadyz)
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return s
+}
+
+// AddReadinessCheck registers a named readiness check.
+func (s *Server) AddReadinessCheck(name string, check Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks[name] = check
+}
+
+// Handle serves an additional endpoint, such as a debug handler. It must be
+// called before Start.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Start serves the endpoints in the background.
+func (s *Server) Start() {
+	go func() {
+		logger.Info("Health endpoints listening on %s", s.server.Addr)
+		if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Health server failed: %v", err)
+		}
+	}()
+}
+
+// SetShuttingDown makes /readyz fail from now on.
+func (s *Server) SetShuttingDown() {
+	s.shuttingDown.Store(true)
+}
+
+// Shutdown marks the server as shutting down and stops it.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.SetShuttingDown()
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if s.shuttingDown.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	failures := s.runChecks(r.Context())
+	if len(failures) > 0 {
+		logger.Debug("Readiness check failed: %s", strings.Join(failures, "; "))
+		http.Error(w, strings.Join(failures, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// runChecks runs all readiness checks concurrently and returns a sorted
+// description of each failure.
+func (s *Server) runChecks(ctx context.Context) []string {
+	s.mu.RLock()
+	checks := make(map[string]Check, len(s.checks))
+	for name, check := range s.checks {
+		checks[name] = check
+	}
+	s.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, s.checkTimeout)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		failures []string
+		wg       sync.WaitGroup
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			if err := check(ctx); err != nil {
+				mu.Lock()
+				failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+				mu.Unlock()
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	sort.Strings(failures)
+	return failures
+}
@@ -0,0 +1,58 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	s := NewServer(":0")
+	s.AddReadinessCheck("ok", func(ctx context.Context) error { return nil })
+
+	rec := httptest.NewRecorder()
+	s.handleReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with passing checks, got %d", rec.Code)
+	}
+
+	s.AddReadinessCheck("flightctl-api", func(ctx context.Context) error { return errors.New("unreachable") })
+	rec = httptest.NewRecorder()
+	s.handleReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "flightctl-api: unreachable") {
+		t.Errorf("expected 503 naming the failed check, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestShuttingDown(t *testing.T) {
+	s := NewServer(":0")
+	s.SetShuttingDown()
+
+	rec := httptest.NewRecorder()
+	s.handleReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected readyz to fail during shutdown, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected healthz to stay up during shutdown, got %d", rec.Code)
+	}
+}
+
+func TestHandleServesExtraEndpoint(t *testing.T) {
+	s := NewServer(":0")
+	s.Handle("/debug/test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/test", nil))
+	if rec.Code != http.StatusTeapot {
+		t.Errorf("expected the registered handler, got %d", rec.Code)
+	}
+}
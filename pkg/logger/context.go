@@ -0,0 +1,96 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// CorrelationIDField is the field name used for correlation IDs in log output.
+const CorrelationIDField = "correlation_id"
+
+// CorrelationIDHeader carries the correlation ID on outgoing HTTP requests.
+const CorrelationIDHeader = "X-Correlation-ID"
+
+type correlationIDKey struct{}
+
+// NewCorrelationID returns a random correlation ID.
+func NewCorrelationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithCorrelationID returns a context carrying the correlation ID.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// EnsureCorrelationID returns ctx unchanged if it already carries a
+// correlation ID, or a context with a new one otherwise.
+func EnsureCorrelationID(ctx context.Context) context.Context {
+	if CorrelationID(ctx) != "" {
+		return ctx
+	}
+	return WithCorrelationID(ctx, NewCorrelationID())
+}
+
+// CorrelationID returns the correlation ID carried by ctx, if any.
+func CorrelationID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// Entry is a logger with key/value fields attached to every message.
+type Entry struct {
+	fields []interface{}
+}
+
+// With returns a logger that adds the given key/value pairs to every message.
+func With(keysAndValues ...interface{}) *Entry {
+	return (&Entry{}).With(keysAndValues...)
+}
+
+// FromContext returns a logger carrying the correlation ID of ctx, if any.
+func FromContext(ctx context.Context) *Entry {
+	if id := CorrelationID(ctx); id != "" {
+		return With(CorrelationIDField, id)
+	}
+	return &Entry{}
+}
+
+// With returns a copy of the logger with additional key/value pairs.
+func (e *Entry) With(keysAndValues ...interface{}) *Entry {
+	if len(keysAndValues)%2 != 0 {
+		keysAndValues = append(keysAndValues, "(MISSING)")
+	}
+	fields := make([]interface{}, 0, len(e.fields)+len(keysAndValues))
+	fields = append(fields, e.fields...)
+	fields = append(fields, keysAndValues...)
+	return &Entry{fields: fields}
+}
+
+// Debug logs a debug message
+func (e *Entry) Debug(format string, v ...interface{}) {
+	logf(DebugLevel, e.fields, format, v...)
+}
+
+// Info logs an informational message
+func (e *Entry) Info(format string, v ...interface{}) {
+	logf(InfoLevel, e.fields, format, v...)
+}
+
+// Warn logs a warning message
+func (e *Entry) Warn(format string, v ...interface{}) {
+	logf(WarnLevel, e.fields, format, v...)
+}
+
+// Error logs an error message
+func (e *Entry) Error(format string, v ...interface{}) {
+	logf(ErrorLevel, e.fields, format, v...)
+}
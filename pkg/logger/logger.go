@@ -1,9 +1,14 @@
 package logger
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
 	"strings"
+	"sync"
 )
 
 // LogLevel represents the severity of a log message
@@ -16,11 +21,97 @@ const (
 	ErrorLevel
 )
 
+// Output formats
+const (
+	// FormatText prints "<timestamp> [LEVEL] message key=value ..." lines.
+	FormatText = "text"
+	// FormatJSON prints one JSON object per line with the fields as keys.
+	FormatJSON = "json"
+)
+
 var (
 	currentLevel = InfoLevel
+
+	mu           sync.Mutex
+	outputFormat = FormatText
 	logger       = log.New(os.Stdout, "", log.LstdFlags)
+	jsonLogger   = newJSONLogger(os.Stdout)
 )
 
+func newJSONLogger(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// SetFormat sets the output format (text or json)
+func SetFormat(f string) {
+	mu.Lock()
+	defer mu.Unlock()
+	switch strings.ToLower(f) {
+	case FormatJSON:
+		outputFormat = FormatJSON
+	case FormatText, "":
+		outputFormat = FormatText
+	default:
+		outputFormat = FormatText
+		logger.Printf("[WARN] Unknown log format %s, using text", f)
+	}
+}
+
+// SetOutput redirects log output, e.g. for tests
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	logger = log.New(w, "", log.LstdFlags)
+	jsonLogger = newJSONLogger(w)
+}
+
+var levelNames = map[LogLevel]string{
+	DebugLevel: "DEBUG",
+	InfoLevel:  "INFO",
+	WarnLevel:  "WARN",
+	ErrorLevel: "ERROR",
+}
+
+var slogLevels = map[LogLevel]slog.Level{
+	DebugLevel: slog.LevelDebug,
+	InfoLevel:  slog.LevelInfo,
+	WarnLevel:  slog.LevelWarn,
+	ErrorLevel: slog.LevelError,
+}
+
+// output writes a message with its key/value fields in the configured format
+func output(level LogLevel, msg string, fields []interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if outputFormat == FormatJSON {
+		jsonLogger.Log(context.Background(), slogLevels[level], msg, fields...)
+		return
+	}
+
+	var line strings.Builder
+	line.WriteString("[" + levelNames[level] + "] " + msg)
+	for i := 0; i+1 < len(fields); i += 2 {
+		fmt.Fprintf(&line, " %v=%v", fields[i], quoteValue(fields[i+1]))
+	}
+	logger.Print(line.String())
+}
+
+// quoteValue quotes text field values containing spaces
+func quoteValue(v interface{}) interface{} {
+	if s, ok := v.(string); ok && strings.ContainsAny(s, " \t\"") {
+		return fmt.Sprintf("%q", s)
+	}
+	return v
+}
+
+// logf formats and writes a message if level is enabled
+func logf(level LogLevel, fields []interface{}, format string, v ...interface{}) {
+	if currentLevel <= level {
+		output(level, fmt.Sprintf(format, v...), fields)
+	}
+}
+
 // SetLevel sets the minimum log level that will be printed
 func SetLevel(level LogLevel) {
 	currentLevel = level
@@ -45,35 +136,33 @@ func SetLevelFromString(level string) {
 
 // Debug logs a debug message
 func Debug(format string, v ...interface{}) {
-	if currentLevel <= DebugLevel {
-		logger.Printf("[DEBUG] "+format, v...)
-	}
+	logf(DebugLevel, nil, format, v...)
 }
 
 // Info logs an informational message
 func Info(format string, v ...interface{}) {
-	if currentLevel <= InfoLevel {
-		logger.Printf("[INFO] "+format, v...)
-	}
+	logf(InfoLevel, nil, format, v...)
 }
 
 // Warn logs a warning message
 func Warn(format string, v ...interface{}) {
-	if currentLevel <= WarnLevel {
-		logger.Printf("[WARN] "+format, v...)
-	}
+	logf(WarnLevel, nil, format, v...)
 }
 
 // Error logs an error message
 func Error(format string, v ...interface{}) {
-	if currentLevel <= ErrorLevel {
-		logger.Printf("[ERROR] "+format, v...)
-	}
+	logf(ErrorLevel, nil, format, v...)
 }
 
 // Fatal logs a fatal error and exits
 func Fatal(format string, v ...interface{}) {
-	logger.Printf("[FATAL] "+format, v...)
+	mu.Lock()
+	if outputFormat == FormatJSON {
+		jsonLogger.Log(context.Background(), slog.LevelError+4, fmt.Sprintf(format, v...))
+	} else {
+		logger.Printf("[FATAL] "+format, v...)
+	}
+	mu.Unlock()
 	os.Exit(1)
 }
 
@@ -100,14 +189,14 @@ func Errorf(format string, v ...interface{}) {
 // Print logs at info level (for compatibility)
 func Print(v ...interface{}) {
 	if currentLevel <= InfoLevel {
-		logger.Print(append([]interface{}{"[INFO] "}, v...)...)
+		output(InfoLevel, fmt.Sprint(v...), nil)
 	}
 }
 
 // Println logs at info level (for compatibility)
 func Println(v ...interface{}) {
 	if currentLevel <= InfoLevel {
-		logger.Println(append([]interface{}{"[INFO]"}, v...)...)
+		output(InfoLevel, strings.TrimSuffix(fmt.Sprintln(v...), "\n"), nil)
 	}
 }
 
@@ -146,6 +235,12 @@ func (l *PrefixLogger) Fatal(format string, v ...interface{}) {
 	Fatal(l.prefix+format, v...)
 }
 
+// Enabled reports whether messages at level are printed, so expensive
+// debug output can be skipped
+func Enabled(level LogLevel) bool {
+	return currentLevel <= level
+}
+
 // GetLevel returns the current log level as a string
 func GetLevel() string {
 	switch currentLevel {
@@ -167,4 +262,7 @@ func init() {
 	if level := os.Getenv("LOG_LEVEL"); level != "" {
 		SetLevelFromString(level)
 	}
+	if f := os.Getenv("LOG_FORMAT"); f != "" {
+		SetFormat(f)
+	}
 }
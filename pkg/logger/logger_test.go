@@ -0,0 +1,58 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestJSONOutputWithCorrelationID(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	SetFormat(FormatJSON)
+	defer func() {
+		SetFormat(FormatText)
+		SetOutput(os.Stdout)
+	}()
+
+	ctx := WithCorrelationID(context.Background(), "abc123")
+	FromContext(ctx).With("pod", "default/web").Info("Deploying pod %s", "web")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("output is not JSON: %v (%q)", err, buf.String())
+	}
+	if entry["msg"] != "Deploying pod web" || entry["level"] != "INFO" {
+		t.Errorf("unexpected message/level: %v", entry)
+	}
+	if entry[CorrelationIDField] != "abc123" || entry["pod"] != "default/web" {
+		t.Errorf("expected correlation ID and pod fields, got %v", entry)
+	}
+}
+
+func TestTextOutputFields(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stdout)
+
+	With("device", "dev-1", "summary", "two words").Warn("Device %s offline", "dev-1")
+
+	line := buf.String()
+	if !strings.Contains(line, `[WARN] Device dev-1 offline device=dev-1 summary="two words"`) {
+		t.Errorf("unexpected text output %q", line)
+	}
+}
+
+func TestEnsureCorrelationID(t *testing.T) {
+	ctx := EnsureCorrelationID(context.Background())
+	id := CorrelationID(ctx)
+	if id == "" {
+		t.Fatal("expected a correlation ID")
+	}
+	if CorrelationID(EnsureCorrelationID(ctx)) != id {
+		t.Error("expected existing correlation ID to be kept")
+	}
+}
@@ -1,8 +1,12 @@
 package models
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 )
 
@@ -14,24 +18,53 @@ type Device struct {
 	FleetID string
 	Labels  map[string]string
 
+	// Platform reported by the device agent
+	SystemInfo SystemInfo
+
 	// Capacity
 	Capacity    ResourceList
 	Allocatable ResourceList
 
 	// Status
 	Status          DeviceStatus
+	Health          DeviceHealth
 	LastHeartbeat   time.Time
 	ConnectionState ConnectionState
 
+	// Maintenance
+	Cordoned bool // No new pods are placed on the device
+	Draining bool // Pods are moved off the device; implies Cordoned
+
 	// Metadata
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
-// ResourceList represents CPU and memory resources.
+// SystemInfo describes a device's platform. Empty fields were not reported.
+type SystemInfo struct {
+	OperatingSystem string // As GOOS, e.g. linux
+	Architecture    string // As GOARCH, e.g. amd64 or arm64
+	OSImage         string // Distribution name and version
+	KernelVersion   string
+	AgentVersion    string
+	BootID          string
+	IPAddresses     []string // Default address first
+}
+
+// ResourceList represents CPU, memory, ephemeral storage and extended
+// resources.
 type ResourceList struct {
 	CPU    resource.Quantity
 	Memory resource.Quantity
+
+	// Disk space for container images, writable layers and logs. Like
+	// extended resources, it is never overcommitted.
+	EphemeralStorage resource.Quantity
+
+	// Extended resources by name, e.g. nvidia.com/gpu. Unlike CPU and
+	// memory, they are never overcommitted, and a device that does not
+	// declare one has none of it.
+	Extended map[corev1.ResourceName]resource.Quantity
 }
 
 // DeviceStatus represents the current state of a device.
@@ -41,6 +74,18 @@ type DeviceStatus struct {
 	Reason  string
 }
 
+// DeviceHealth reports the problems a device agent raised.
+type DeviceHealth struct {
+	MemoryPressure bool   // Memory usage is critical
+	DiskPressure   bool   // Disk usage is critical
+	UpdateError    string // Why the last update failed; empty unless it did
+
+	// Battery charge in percent; nil for mains powered devices
+	Battery *float64
+	// Temperature in degrees Celsius; nil if not reported
+	Temperature *float64
+}
+
 // DevicePhase represents the phase of a device.
 type DevicePhase string
 
@@ -72,5 +117,142 @@ func (d *Device) HasSufficientResources(cpu, memory resource.Quantity) bool {
 	cpuAvail.Sub(cpu)
 	memAvail.Sub(memory)
 
-	return !cpuAvail.IsZero() && cpuAvail.Sign() >= 0 && !memAvail.IsZero() && memAvail.Sign() >= 0
+	return cpuAvail.Sign() >= 0 && memAvail.Sign() >= 0
+}
+
+// HasSufficientStorage checks if the device has enough allocatable ephemeral
+// storage for the given request. Devices that don't report their storage
+// are assumed to fit.
+func (d *Device) HasSufficientStorage(storage resource.Quantity) bool {
+	if d.Capacity.EphemeralStorage.IsZero() || storage.Sign() <= 0 {
+		return true
+	}
+	available := d.Allocatable.EphemeralStorage.DeepCopy()
+	available.Sub(storage)
+	return available.Sign() >= 0
+}
+
+// HasExtendedResources checks if the device has enough allocatable extended
+// resources for the given requests, whether or not it reports its CPU and
+// memory capacity.
+func (d *Device) HasExtendedResources(requests map[corev1.ResourceName]resource.Quantity) bool {
+	for name, requested := range requests {
+		if requested.Sign() <= 0 {
+			continue
+		}
+		if available, ok := d.Allocatable.Extended[name]; !ok || available.Cmp(requested) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// HasCapacityInfo returns true if the device reports its CPU or memory capacity.
+// Devices without capacity information are not filtered by resource requests.
+func (d *Device) HasCapacityInfo() bool {
+	return !d.Capacity.CPU.IsZero() || !d.Capacity.Memory.IsZero()
+}
+
+// Add returns the sum of two resource lists.
+func (r ResourceList) Add(other ResourceList) ResourceList {
+	sum := r.copy()
+	sum.CPU.Add(other.CPU)
+	sum.Memory.Add(other.Memory)
+	sum.EphemeralStorage.Add(other.EphemeralStorage)
+	for name, q := range other.Extended {
+		total := sum.Extended[name]
+		total.Add(q)
+		sum.setExtended(name, total)
+	}
+	return sum
+}
+
+// Sub returns r minus other.
+func (r ResourceList) Sub(other ResourceList) ResourceList {
+	diff := r.copy()
+	diff.CPU.Sub(other.CPU)
+	diff.Memory.Sub(other.Memory)
+	diff.EphemeralStorage.Sub(other.EphemeralStorage)
+	for name, q := range other.Extended {
+		left := diff.Extended[name]
+		left.Sub(q)
+		diff.setExtended(name, left)
+	}
+	return diff
+}
+
+// String describes the resources of the list, e.g. "cpu=500m memory=1Gi
+// nvidia.com/gpu=1", leaving out ephemeral storage and extended resources
+// that are not set.
+func (r ResourceList) String() string {
+	parts := []string{fmt.Sprintf("cpu=%s", r.CPU.String()), fmt.Sprintf("memory=%s", r.Memory.String())}
+	if !r.EphemeralStorage.IsZero() {
+		parts = append(parts, fmt.Sprintf("ephemeral-storage=%s", r.EphemeralStorage.String()))
+	}
+	names := make([]string, 0, len(r.Extended))
+	for name := range r.Extended {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		q := r.Extended[corev1.ResourceName(name)]
+		parts = append(parts, fmt.Sprintf("%s=%s", name, q.String()))
+	}
+	return strings.Join(parts, " ")
+}
+
+// ToCore returns the resources of the list that are set as a Kubernetes
+// resource list.
+func (r ResourceList) ToCore() corev1.ResourceList {
+	list := make(corev1.ResourceList)
+	set := func(name corev1.ResourceName, q resource.Quantity) {
+		if !q.IsZero() {
+			list[name] = q.DeepCopy()
+		}
+	}
+	set(corev1.ResourceCPU, r.CPU)
+	set(corev1.ResourceMemory, r.Memory)
+	set(corev1.ResourceEphemeralStorage, r.EphemeralStorage)
+	for name, q := range r.Extended {
+		set(name, q)
+	}
+	return list
+}
+
+func (r ResourceList) copy() ResourceList {
+	copied := ResourceList{CPU: r.CPU.DeepCopy(), Memory: r.Memory.DeepCopy(), EphemeralStorage: r.EphemeralStorage.DeepCopy()}
+	if len(r.Extended) > 0 {
+		copied.Extended = make(map[corev1.ResourceName]resource.Quantity, len(r.Extended))
+		for name, q := range r.Extended {
+			copied.Extended[name] = q.DeepCopy()
+		}
+	}
+	return copied
+}
+
+func (r *ResourceList) setExtended(name corev1.ResourceName, q resource.Quantity) {
+	if r.Extended == nil {
+		r.Extended = make(map[corev1.ResourceName]resource.Quantity)
+	}
+	r.Extended[name] = q
+}
+
+// OvercommitRatio scales the capacity of devices into the resources pods
+// may request on them: 2 lets pods request twice the CPU or memory a device
+// has. Zero ratios count as 1.
+type OvercommitRatio struct {
+	CPU    float64
+	Memory float64
+}
+
+// Apply returns capacity scaled by the ratios.
+func (o OvercommitRatio) Apply(capacity ResourceList) ResourceList {
+	scaled := capacity.copy()
+	if o.CPU > 0 && o.CPU != 1 {
+		scaled.CPU = *resource.NewMilliQuantity(int64(float64(capacity.CPU.MilliValue())*o.CPU), capacity.CPU.Format)
+	}
+	if o.Memory > 0 && o.Memory != 1 {
+		scaled.Memory = *resource.NewQuantity(int64(float64(capacity.Memory.Value())*o.Memory), capacity.Memory.Format)
+	}
+	return scaled
 }
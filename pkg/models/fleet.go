@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // Fleet represents a logical grouping of edge devices.
 type Fleet struct {
@@ -35,3 +38,42 @@ type ErrInvalidFleet string
 func (e ErrInvalidFleet) Error() string {
 	return "invalid fleet: " + string(e)
 }
+
+// FleetRollout is the progress of the rollout of a fleet's device template
+// to its devices, batch by batch under the fleet's rollout policy.
+type FleetRollout struct {
+	TemplateVersion string
+	// Batch is the batch being rolled out: -1 before the first batch of
+	// the policy, then its index, and the number of batches for the final
+	// batch of the remaining devices.
+	Batch   int
+	Batches int
+	// InProgress is set while devices are being updated or wait for the
+	// next batch to be approved, with the reason FlightCtl reports.
+	InProgress bool
+	Reason     string
+	Message    string
+	LastBatch  *BatchReport
+}
+
+// BatchReport is the outcome of the last completed rollout batch.
+type BatchReport struct {
+	Name              string `json:"batchName"`
+	Number            int    `json:"batchNumber"`
+	SuccessPercentage int    `json:"successPercentage"`
+	Total             int    `json:"total"`
+	Successful        int    `json:"successful"`
+	Failed            int    `json:"failed"`
+	TimedOut          int    `json:"timedOut"`
+}
+
+// BatchName names the batch being rolled out.
+func (r FleetRollout) BatchName() string {
+	switch {
+	case r.Batch < 0:
+		return "preliminary batch"
+	case r.Batch >= r.Batches:
+		return "final batch"
+	}
+	return fmt.Sprintf("batch %d of %d", r.Batch+1, r.Batches)
+}
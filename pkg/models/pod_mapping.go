@@ -15,9 +15,115 @@ type PodDeviceMapping struct {
 	Namespace  string            // Pod namespace (extracted for convenience)
 	Name       string            // Pod name (extracted for convenience)
 	PodUID     types.UID         // Kubernetes pod UID for uniqueness
-	DeviceID   string            // Target device ID
+	DeviceID   string            // Target device ID (empty for spread pods)
 	DeployedAt time.Time         // When the pod was deployed
+	Requests   ResourceList      // Summed resource requests of the pod
+	Pod        *corev1.Pod       // Last deployed pod spec (used for rescheduling)
 	Status     *corev1.PodStatus // Cached pod status (nil if not yet fetched)
+	InFlight   bool              // The pod is being deployed to or removed from its devices
+
+	// Spread pods run as an application on several devices at once
+	SpreadDevices []string // Devices running the pod
+	SpreadQuorum  int      // Devices that must be ready for the pod to be Ready
+	RolloutFleet  string   // Fleet whose template deploys the pod to SpreadDevices (empty if written to each device)
+
+	// Deployment rollback
+	ReadyDeadline time.Time   // When the deployed application must run by (zero once it has)
+	PreviousPod   *corev1.Pod // Spec restored if an update misses its deadline (nil for a new pod)
+	RolledBack    bool        // The deployment was rolled back, or failed, and the pod failed
+
+	// Completion of pods that do not restart
+	CompletedAt time.Time // When the pod succeeded or failed for good (zero while it runs)
+	AppRemoved  bool      // The completed pod's application was removed from its devices
+
+	// Deletion
+	TerminationDeadline time.Time // When the deleted pod's devices must have stopped it by (zero unless terminating)
+
+	// Deployment to an offline device
+	AwaitingDeviceUntil time.Time // When the held deployment fails unless the device reconnected (zero unless held)
+
+	// Phase transitions seen, oldest first, up to MaxPhaseHistory
+	PhaseHistory []PhaseTransition
+
+	// Status diffing
+	statusHash   uint64            // Hash of the status last set with SetStatus
+	hashedStatus *corev1.PodStatus // Status the hash is of (another one replaced it if it is not Status)
+}
+
+// SetStatus caches a status read from the pod's devices, unless it has the
+// hash of the cached one: the cached status, with its timestamps, is then
+// kept. It reports whether the cached status changed.
+func (m *PodDeviceMapping) SetStatus(status *corev1.PodStatus, hash uint64) bool {
+	if m.Status != nil && m.Status == m.hashedStatus && m.statusHash == hash {
+		return false
+	}
+	m.Status, m.hashedStatus, m.statusHash = status, status, hash
+	return true
+}
+
+// MaxPhaseHistory is how many phase transitions are kept for a pod.
+const MaxPhaseHistory = 10
+
+// PhaseTransition is a change of a pod's phase.
+type PhaseTransition struct {
+	Time   time.Time       `json:"time"`
+	From   corev1.PodPhase `json:"from,omitempty"`
+	To     corev1.PodPhase `json:"to"`
+	Reason string          `json:"reason,omitempty"`
+}
+
+// RecordPhaseTransition adds a transition to the pod's phase history,
+// dropping the oldest one beyond MaxPhaseHistory.
+func (m *PodDeviceMapping) RecordPhaseTransition(transition PhaseTransition) {
+	if len(m.PhaseHistory) >= MaxPhaseHistory {
+		m.PhaseHistory = append(m.PhaseHistory[:0:0], m.PhaseHistory[len(m.PhaseHistory)-MaxPhaseHistory+1:]...)
+	}
+	m.PhaseHistory = append(m.PhaseHistory, transition)
+}
+
+// TerminatedSince returns when the pod entered its Succeeded or Failed
+// phase, as recorded in its phase history, and whether it is in one.
+func (m *PodDeviceMapping) TerminatedSince() (time.Time, bool) {
+	if m.Status == nil || (m.Status.Phase != corev1.PodSucceeded && m.Status.Phase != corev1.PodFailed) {
+		return time.Time{}, false
+	}
+	for i := len(m.PhaseHistory) - 1; i >= 0; i-- {
+		if transition := m.PhaseHistory[i]; transition.To == m.Status.Phase {
+			return transition.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// IsCompleted reports whether the pod is done running and keeps its final
+// status.
+func (m *PodDeviceMapping) IsCompleted() bool {
+	return !m.CompletedAt.IsZero()
+}
+
+// IsTerminating reports whether the pod was deleted and its devices are
+// stopping it.
+func (m *PodDeviceMapping) IsTerminating() bool {
+	return !m.TerminationDeadline.IsZero()
+}
+
+// IsAwaitingDevice reports whether the pod's deployment is held until its
+// offline device reconnects.
+func (m *PodDeviceMapping) IsAwaitingDevice() bool {
+	return !m.AwaitingDeviceUntil.IsZero()
+}
+
+// Devices returns the devices the pod is deployed to.
+func (m *PodDeviceMapping) Devices() []string {
+	if len(m.SpreadDevices) > 0 {
+		return m.SpreadDevices
+	}
+	return []string{m.DeviceID}
+}
+
+// IsSpread reports whether the pod is deployed to several devices.
+func (m *PodDeviceMapping) IsSpread() bool {
+	return len(m.SpreadDevices) > 0
 }
 
 // NewPodDeviceMapping creates a new mapping.
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// HostPort is a port a pod binds on its device.
+type HostPort struct {
+	Port     int32
+	Protocol corev1.Protocol
+}
+
+func (hp HostPort) String() string {
+	return fmt.Sprintf("%d/%s", hp.Port, hp.Protocol)
+}
+
+// PodHostPorts returns the device ports a pod binds: the container ports
+// themselves on the host network, otherwise the ports its container ports are
+// published on (the hostPort, or the container port if none is set).
+func PodHostPorts(pod *corev1.Pod) []HostPort {
+	if pod == nil {
+		return nil
+	}
+	var ports []HostPort
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.ContainerPort <= 0 {
+				continue
+			}
+			hostPort := HostPort{Port: port.ContainerPort, Protocol: port.Protocol}
+			if !pod.Spec.HostNetwork {
+				// Ports are only published as TCP
+				hostPort.Protocol = corev1.ProtocolTCP
+				if port.HostPort > 0 {
+					hostPort.Port = port.HostPort
+				}
+			}
+			if hostPort.Protocol == "" {
+				hostPort.Protocol = corev1.ProtocolTCP
+			}
+			ports = append(ports, hostPort)
+		}
+	}
+	return ports
+}
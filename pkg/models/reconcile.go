@@ -8,15 +8,17 @@ import (
 
 // ReconciliationRecord tracks reconciliation attempts for debugging.
 type ReconciliationRecord struct {
-	Timestamp       time.Time
-	PodKey          string // namespace/name
-	Operation       ReconcileOperation
-	DesiredState    corev1.PodPhase
-	ActualState     corev1.PodPhase
-	Action          ReconcileAction
-	Result          ReconcileResult
-	ErrorMessage    string
-	DurationSeconds float64
+	Timestamp       time.Time          `json:"timestamp"`
+	PodKey          string             `json:"pod"` // namespace/name
+	DeviceID        string             `json:"device,omitempty"`
+	Operation       ReconcileOperation `json:"operation"`
+	DesiredState    corev1.PodPhase    `json:"desiredState,omitempty"`
+	ActualState     corev1.PodPhase    `json:"actualState,omitempty"`
+	Action          ReconcileAction    `json:"action"`
+	Result          ReconcileResult    `json:"result"`
+	Message         string             `json:"message,omitempty"` // What was done, or why
+	ErrorMessage    string             `json:"error,omitempty"`
+	DurationSeconds float64            `json:"durationSeconds"`
 }
 
 // ReconcileOperation represents the type of reconciliation operation.
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// PodRequests returns the effective CPU, memory, ephemeral storage and
+// extended resource requests of a pod, following the Kubernetes scheduler rules: the sum of the app
+// containers and sidecars (init containers with restartPolicy Always), or
+// the largest init container together with the sidecars started before it
+// if that is bigger. Limits are used when requests are unset.
+func PodRequests(pod *corev1.Pod) ResourceList {
+	var total ResourceList
+	for _, container := range pod.Spec.Containers {
+		total = total.Add(containerRequests(container))
+	}
+
+	var sidecars, init ResourceList
+	for _, container := range pod.Spec.InitContainers {
+		requests := containerRequests(container)
+		if container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+			sidecars = sidecars.Add(requests)
+			total = total.Add(requests)
+			init = init.max(sidecars)
+		} else {
+			init = init.max(sidecars.Add(requests))
+		}
+	}
+
+	return total.max(init)
+}
+
+// max returns the larger of each resource of two lists.
+func (r ResourceList) max(other ResourceList) ResourceList {
+	out := r.copy()
+	if other.CPU.Cmp(out.CPU) > 0 {
+		out.CPU = other.CPU
+	}
+	if other.Memory.Cmp(out.Memory) > 0 {
+		out.Memory = other.Memory
+	}
+	if other.EphemeralStorage.Cmp(out.EphemeralStorage) > 0 {
+		out.EphemeralStorage = other.EphemeralStorage
+	}
+	for name, q := range other.Extended {
+		if q.Cmp(out.Extended[name]) > 0 {
+			out.setExtended(name, q)
+		}
+	}
+	return out
+}
+
+// containerRequests returns a container's CPU, memory, ephemeral storage and
+// extended resource requests.
+func containerRequests(container corev1.Container) ResourceList {
+	var requests ResourceList
+	if cpu, ok := container.Resources.Requests[corev1.ResourceCPU]; ok {
+		requests.CPU = cpu.DeepCopy()
+	} else if cpu, ok := container.Resources.Limits[corev1.ResourceCPU]; ok {
+		requests.CPU = cpu.DeepCopy()
+	}
+	if mem, ok := container.Resources.Requests[corev1.ResourceMemory]; ok {
+		requests.Memory = mem.DeepCopy()
+	} else if mem, ok := container.Resources.Limits[corev1.ResourceMemory]; ok {
+		requests.Memory = mem.DeepCopy()
+	}
+	if storage, ok := container.Resources.Requests[corev1.ResourceEphemeralStorage]; ok {
+		requests.EphemeralStorage = storage.DeepCopy()
+	} else if storage, ok := container.Resources.Limits[corev1.ResourceEphemeralStorage]; ok {
+		requests.EphemeralStorage = storage.DeepCopy()
+	}
+	for name, q := range ExtendedRequests(container) {
+		requests.setExtended(name, q)
+	}
+	return requests
+}
+
+// ExtendedRequests returns the extended resources a container requests, such
+// as nvidia.com/gpu. Kubernetes requires their requests to equal their
+// limits, so either is used.
+func ExtendedRequests(container corev1.Container) map[corev1.ResourceName]resource.Quantity {
+	var extended map[corev1.ResourceName]resource.Quantity
+	for _, list := range []corev1.ResourceList{container.Resources.Limits, container.Resources.Requests} {
+		for name, q := range list {
+			if !IsExtendedResourceName(name) || q.Sign() <= 0 {
+				continue
+			}
+			if extended == nil {
+				extended = make(map[corev1.ResourceName]resource.Quantity)
+			}
+			extended[name] = q.DeepCopy()
+		}
+	}
+	return extended
+}
+
+// IsExtendedResourceName reports whether a resource is an extended resource:
+// a name qualified with a domain outside kubernetes.io, other than the
+// requests. prefix of quotas.
+func IsExtendedResourceName(name corev1.ResourceName) bool {
+	domain, _, qualified := strings.Cut(string(name), "/")
+	if !qualified || strings.HasPrefix(string(name), corev1.DefaultResourceRequestsPrefix) {
+		return false
+	}
+	return domain != "kubernetes.io" && !strings.HasSuffix(domain, ".kubernetes.io")
+}
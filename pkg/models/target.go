@@ -2,7 +2,9 @@ package models
 
 import (
 	"fmt"
+	"math/rand/v2"
 	"sort"
+	"time"
 )
 
 // DeploymentTarget represents the specification for targeting devices.
@@ -10,14 +12,70 @@ type DeploymentTarget struct {
 	FleetID   *string           // If set, target devices in this fleet
 	Selectors map[string]string // Label selectors (AND logic)
 	DeviceID  *string           // If set, target specific device (overrides other fields)
+	Requests  *ResourceList     // If set, devices without enough allocatable resources are skipped
+
+	Strategy PlacementStrategy    // How to pick among the suitable devices (default PlacementMostFree)
+	LastUsed map[string]time.Time // Latest placement per device, for PlacementLeastRecentlyUsed
+}
+
+// PlacementStrategy decides which of the devices that can run a pod it is
+// placed on.
+type PlacementStrategy string
+
+const (
+	// PlacementMostFree picks the device with the most free CPU, then the
+	// fewest pods.
+	PlacementMostFree PlacementStrategy = "most-free"
+	// PlacementBinPack picks the device with the least free CPU that fits,
+	// filling devices up before using others. Devices without capacity
+	// information come last.
+	PlacementBinPack PlacementStrategy = "bin-pack"
+	// PlacementSpread picks the device running the fewest pods, then the one
+	// with the most free CPU.
+	PlacementSpread PlacementStrategy = "spread"
+	// PlacementRandom picks a device at random.
+	PlacementRandom PlacementStrategy = "random"
+	// PlacementLeastRecentlyUsed picks the device that received a pod
+	// longest ago, or never.
+	PlacementLeastRecentlyUsed PlacementStrategy = "least-recently-used"
+)
+
+// PlacementStrategies lists the supported strategies.
+var PlacementStrategies = []PlacementStrategy{
+	PlacementMostFree, PlacementBinPack, PlacementSpread, PlacementRandom, PlacementLeastRecentlyUsed,
+}
+
+// ParsePlacementStrategy returns the named strategy; "" is PlacementMostFree.
+func ParsePlacementStrategy(name string) (PlacementStrategy, error) {
+	if name == "" {
+		return PlacementMostFree, nil
+	}
+	for _, strategy := range PlacementStrategies {
+		if string(strategy) == name {
+			return strategy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown placement strategy %q (expected one of %v)", name, PlacementStrategies)
+}
+
+// InsufficientResourcesError is returned when matching devices exist but none
+// has enough free capacity for the requested resources.
+type InsufficientResourcesError struct {
+	Requested  ResourceList
+	Candidates int // Ready devices that matched the target but lacked capacity
+}
+
+func (e *InsufficientResourcesError) Error() string {
+	return fmt.Sprintf("InsufficientResources: none of %d candidate device(s) has %s available",
+		e.Candidates, e.Requested)
 }
 
 // SelectDevice selects the best device from the candidate list.
 // Algorithm:
 // 1. Build candidate list (fleet + label filters)
-// 2. Filter by ConnectionState=Connected and sufficient resources
-// 3. Sort by available resources (descending)
-// 4. Tie-break by fewest existing pods
+// 2. Filter by ConnectionState=Connected, not cordoned, no disk pressure and
+// sufficient resources
+// 3. Pick one according to the placement strategy
 func (dt *DeploymentTarget) SelectDevice(devices []*Device, podsByDevice map[string]int) (*Device, error) {
 	if dt.DeviceID != nil {
 		// Direct device targeting
@@ -26,6 +84,9 @@ func (dt *DeploymentTarget) SelectDevice(devices []*Device, podsByDevice map[str
 				if !d.IsReady() {
 					return nil, fmt.Errorf("device %s is not ready", *dt.DeviceID)
 				}
+				if d.Cordoned {
+					return nil, fmt.Errorf("device %s is cordoned", *dt.DeviceID)
+				}
 				return d, nil
 			}
 		}
@@ -34,38 +95,104 @@ func (dt *DeploymentTarget) SelectDevice(devices []*Device, podsByDevice map[str
 
 	// Build candidate list
 	var candidates []*Device
+	insufficient, diskPressure := 0, 0
 	for _, d := range devices {
 		if !dt.matchesDevice(d) {
 			continue
 		}
-		if !d.IsReady() {
+		if !d.IsReady() || d.Cordoned {
+			continue
+		}
+		// As Kubernetes taints nodes under disk pressure, devices running
+		// out of disk get no new pods
+		if d.Health.DiskPressure {
+			diskPressure++
+			continue
+		}
+		if !dt.fits(d) {
+			insufficient++
 			continue
 		}
 		candidates = append(candidates, d)
 	}
 
 	if len(candidates) == 0 {
+		if insufficient > 0 {
+			return nil, &InsufficientResourcesError{Requested: *dt.Requests, Candidates: insufficient}
+		}
+		if diskPressure > 0 {
+			return nil, fmt.Errorf("no suitable device found: %d device(s) under disk pressure", diskPressure)
+		}
 		return nil, fmt.Errorf("no suitable device found")
 	}
 
-	// Sort by available resources, then by pod count
-	sort.Slice(candidates, func(i, j int) bool {
-		// Compare available CPU
-		cpuI := candidates[i].Allocatable.CPU.Value()
-		cpuJ := candidates[j].Allocatable.CPU.Value()
-		if cpuI != cpuJ {
-			return cpuI > cpuJ
-		}
+	if dt.Strategy == PlacementRandom {
+		return candidates[rand.IntN(len(candidates))], nil
+	}
 
-		// Tie-break by pod count
-		podsI := podsByDevice[candidates[i].ID]
-		podsJ := podsByDevice[candidates[j].ID]
-		return podsI < podsJ
+	preferred := dt.preferred(podsByDevice)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return preferred(candidates[i], candidates[j])
 	})
 
 	return candidates[0], nil
 }
 
+// preferred returns whether the strategy prefers device a over b.
+func (dt *DeploymentTarget) preferred(podsByDevice map[string]int) func(a, b *Device) bool {
+	freeCPU := func(d *Device) int64 { return d.Allocatable.CPU.MilliValue() }
+	switch dt.Strategy {
+	case PlacementBinPack:
+		return func(a, b *Device) bool {
+			if a.HasCapacityInfo() != b.HasCapacityInfo() {
+				return a.HasCapacityInfo()
+			}
+			if freeCPU(a) != freeCPU(b) {
+				return freeCPU(a) < freeCPU(b)
+			}
+			return podsByDevice[a.ID] > podsByDevice[b.ID]
+		}
+	case PlacementSpread:
+		return func(a, b *Device) bool {
+			if podsByDevice[a.ID] != podsByDevice[b.ID] {
+				return podsByDevice[a.ID] < podsByDevice[b.ID]
+			}
+			return freeCPU(a) > freeCPU(b)
+		}
+	case PlacementLeastRecentlyUsed:
+		return func(a, b *Device) bool {
+			if lastA, lastB := dt.LastUsed[a.ID], dt.LastUsed[b.ID]; !lastA.Equal(lastB) {
+				return lastA.Before(lastB)
+			}
+			return podsByDevice[a.ID] < podsByDevice[b.ID]
+		}
+	default:
+		return func(a, b *Device) bool {
+			if freeCPU(a) != freeCPU(b) {
+				return freeCPU(a) > freeCPU(b)
+			}
+			return podsByDevice[a.ID] < podsByDevice[b.ID]
+		}
+	}
+}
+
+// fits checks if the device has room for the target's resource requests.
+// Devices that don't report capacity are assumed to fit their CPU, memory
+// and storage requests, but only devices declaring extended resources fit
+// requests for them.
+func (dt *DeploymentTarget) fits(d *Device) bool {
+	if dt.Requests == nil {
+		return true
+	}
+	if !d.HasExtendedResources(dt.Requests.Extended) || !d.HasSufficientStorage(dt.Requests.EphemeralStorage) {
+		return false
+	}
+	if !d.HasCapacityInfo() {
+		return true
+	}
+	return d.HasSufficientResources(dt.Requests.CPU, dt.Requests.Memory)
+}
+
 // matchesDevice checks if a device matches the target criteria.
 func (dt *DeploymentTarget) matchesDevice(d *Device) bool {
 	// Check fleet
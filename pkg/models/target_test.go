@@ -0,0 +1,147 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func readyDevice(id, cpu, memory string) *Device {
+	capacity := ResourceList{CPU: resource.MustParse(cpu), Memory: resource.MustParse(memory)}
+	return &Device{
+		ID:              id,
+		FleetID:         "fleet-a",
+		Capacity:        capacity,
+		Allocatable:     capacity,
+		Status:          DeviceStatus{Phase: DeviceReady},
+		ConnectionState: Connected,
+	}
+}
+
+func TestSelectDevice_SkipsDevicesWithoutCapacity(t *testing.T) {
+	fleet := "fleet-a"
+	requests := ResourceList{CPU: resource.MustParse("1500m"), Memory: resource.MustParse("1Gi")}
+	target := &DeploymentTarget{FleetID: &fleet, Requests: &requests}
+
+	devices := []*Device{
+		readyDevice("small", "1", "4Gi"),
+		readyDevice("exact", "1500m", "1Gi"),
+	}
+
+	device, err := target.SelectDevice(devices, nil)
+	if err != nil {
+		t.Fatalf("SelectDevice returned error: %v", err)
+	}
+	if device.ID != "exact" {
+		t.Errorf("Expected device 'exact' (fits exactly), got %s", device.ID)
+	}
+}
+
+func TestSelectDevice_InsufficientResources(t *testing.T) {
+	fleet := "fleet-a"
+	requests := ResourceList{CPU: resource.MustParse("8"), Memory: resource.MustParse("1Gi")}
+	target := &DeploymentTarget{FleetID: &fleet, Requests: &requests}
+
+	_, err := target.SelectDevice([]*Device{readyDevice("small", "2", "4Gi")}, nil)
+
+	var insufficient *InsufficientResourcesError
+	if !errors.As(err, &insufficient) {
+		t.Fatalf("Expected InsufficientResourcesError, got %v", err)
+	}
+	if insufficient.Candidates != 1 {
+		t.Errorf("Expected 1 rejected candidate, got %d", insufficient.Candidates)
+	}
+}
+
+func TestSelectDevice_UnknownCapacityNotFiltered(t *testing.T) {
+	fleet := "fleet-a"
+	requests := ResourceList{CPU: resource.MustParse("8")}
+	target := &DeploymentTarget{FleetID: &fleet, Requests: &requests}
+
+	device := &Device{ID: "unlabelled", FleetID: fleet, Status: DeviceStatus{Phase: DeviceReady}, ConnectionState: Connected}
+	if _, err := target.SelectDevice([]*Device{device}, nil); err != nil {
+		t.Errorf("Expected device without capacity info to be selectable, got %v", err)
+	}
+}
+
+func TestSelectDevice_PlacementStrategies(t *testing.T) {
+	fleet := "fleet-a"
+	devices := func() []*Device {
+		return []*Device{
+			readyDevice("large", "4", "8Gi"),
+			readyDevice("small", "1", "2Gi"),
+			readyDevice("busy", "2", "4Gi"),
+		}
+	}
+	pods := map[string]int{"large": 2, "small": 1, "busy": 3}
+	lastUsed := map[string]time.Time{
+		"large": time.Now(),
+		"small": time.Now().Add(-time.Minute),
+		"busy":  time.Now().Add(-time.Hour),
+	}
+
+	for strategy, want := range map[PlacementStrategy]string{
+		"":                         "large",
+		PlacementMostFree:          "large",
+		PlacementBinPack:           "small",
+		PlacementSpread:            "small",
+		PlacementLeastRecentlyUsed: "busy",
+	} {
+		target := &DeploymentTarget{FleetID: &fleet, Strategy: strategy, LastUsed: lastUsed}
+		device, err := target.SelectDevice(devices(), pods)
+		if err != nil {
+			t.Fatalf("%q: SelectDevice returned error: %v", strategy, err)
+		}
+		if device.ID != want {
+			t.Errorf("%q: selected %s, want %s", strategy, device.ID, want)
+		}
+	}
+
+	target := &DeploymentTarget{FleetID: &fleet, Strategy: PlacementRandom}
+	if _, err := target.SelectDevice(devices(), pods); err != nil {
+		t.Errorf("random: SelectDevice returned error: %v", err)
+	}
+}
+
+func TestParsePlacementStrategy(t *testing.T) {
+	if strategy, err := ParsePlacementStrategy(""); err != nil || strategy != PlacementMostFree {
+		t.Errorf("ParsePlacementStrategy(\"\") = %q, %v; want %q", strategy, err, PlacementMostFree)
+	}
+	if strategy, err := ParsePlacementStrategy("bin-pack"); err != nil || strategy != PlacementBinPack {
+		t.Errorf("ParsePlacementStrategy(bin-pack) = %q, %v", strategy, err)
+	}
+	if _, err := ParsePlacementStrategy("fastest"); err == nil {
+		t.Error("expected an error for an unknown strategy")
+	}
+}
+
+func TestSelectDevice_EphemeralStorageAndDiskPressure(t *testing.T) {
+	fleet := "fleet-a"
+	requests := ResourceList{CPU: resource.MustParse("1"), EphemeralStorage: resource.MustParse("10Gi")}
+	target := &DeploymentTarget{FleetID: &fleet, Requests: &requests}
+
+	full := readyDevice("full", "4", "8Gi")
+	full.Capacity.EphemeralStorage = resource.MustParse("64Gi")
+	full.Allocatable.EphemeralStorage = resource.MustParse("5Gi")
+	pressured := readyDevice("pressured", "4", "8Gi")
+	pressured.Health.DiskPressure = true
+	unknown := readyDevice("unknown", "2", "8Gi")
+
+	device, err := target.SelectDevice([]*Device{full, pressured, unknown}, nil)
+	if err != nil {
+		t.Fatalf("SelectDevice returned error: %v", err)
+	}
+	if device.ID != "unknown" {
+		t.Errorf("Expected the device with unknown storage, got %s", device.ID)
+	}
+
+	var insufficient *InsufficientResourcesError
+	if _, err := target.SelectDevice([]*Device{full}, nil); !errors.As(err, &insufficient) {
+		t.Errorf("Expected InsufficientResourcesError for a full disk, got %v", err)
+	}
+	if _, err := target.SelectDevice([]*Device{pressured}, nil); err == nil || errors.As(err, &insufficient) {
+		t.Errorf("Expected a disk pressure error, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// TargetsPod reports whether a pod is meant for the provider's nodes: it
+// tolerates one of their taints, or sets flightctl.io annotations or
+// nodeSelector entries. Admission checks skip other pods.
+func (p *Provider) TargetsPod(pod *corev1.Pod) bool {
+	for _, taint := range p.nodeTaints {
+		for _, toleration := range pod.Spec.Tolerations {
+			if toleration.ToleratesTaint(&taint) {
+				return true
+			}
+		}
+	}
+	for key := range pod.Annotations {
+		if strings.HasPrefix(key, deviceSelectorPrefix) {
+			return true
+		}
+	}
+	for key := range pod.Spec.NodeSelector {
+		if strings.HasPrefix(key, deviceSelectorPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// AdmitPod checks a pod before it is created, so pods the provider could
+// never deploy are rejected rather than left Pending: malformed flightctl.io
+// annotations, unknown or inaccessible devices and fleets, privileged pods
+// in denied namespaces and, in strict mode, unsupported features. It returns
+// warnings for the features a permissive provider drops, and for the
+// devices and fleets that could not be looked up, and an error listing the
+// problems found.
+func (p *Provider) AdmitPod(ctx context.Context, pod *corev1.Pod) ([]string, error) {
+	var warnings []string
+	problems := p.podAnnotationProblems(pod)
+
+	if deviceID := pod.Annotations[deviceIDAnnotation]; deviceID != "" {
+		switch raw, err := p.flightctl.GetDevice(ctx, deviceID); {
+		case errors.Is(err, flightctl.ErrNotFound):
+			problems = append(problems, fmt.Sprintf("unknown device %s", deviceID))
+		case err != nil:
+			warnings = append(warnings, fmt.Sprintf("device %s could not be checked: %v", deviceID, err))
+		default:
+			problems = append(problems, p.deviceAdmissionProblems(pod, raw.ToModel())...)
+		}
+	}
+	if fleetID := pod.Annotations[fleetIDAnnotation]; fleetID != "" {
+		switch _, err := p.flightctl.GetFleet(ctx, fleetID); {
+		case p.fleetID != "" && fleetID != p.fleetID:
+			problems = append(problems, fmt.Sprintf("pod requests fleet %s but node %s represents fleet %s", fleetID, p.nodeName, p.fleetID))
+		case errors.Is(err, flightctl.ErrNotFound):
+			problems = append(problems, fmt.Sprintf("unknown fleet %s", fleetID))
+		case err != nil:
+			warnings = append(warnings, fmt.Sprintf("fleet %s could not be checked: %v", fleetID, err))
+		}
+	}
+
+	if flightctl.PodPrivileged(pod) && p.privilegedDenied(pod.Namespace) {
+		problems = append(problems, fmt.Sprintf("privileged containers are not allowed in namespace %s", pod.Namespace))
+	}
+	for _, feature := range flightctl.UnsupportedFeatures(pod) {
+		if p.podValidation == PodValidationStrict {
+			problems = append(problems, "unsupported "+feature.String())
+		} else {
+			warnings = append(warnings, "dropped on the device: "+feature.String())
+		}
+	}
+
+	if len(problems) > 0 {
+		return warnings, fmt.Errorf("pod cannot run on FlightCtl devices: %s", strings.Join(problems, "; "))
+	}
+	return warnings, nil
+}
+
+// podAnnotationProblems describes the malformed flightctl.io annotations of
+// a pod.
+func (p *Provider) podAnnotationProblems(pod *corev1.Pod) []string {
+	var problems []string
+	if mode, ok := pod.Annotations[SpreadAnnotation]; ok && mode != SpreadAllDevicesInFleet {
+		problems = append(problems, fmt.Sprintf("unsupported %s annotation %q (expected %s)", SpreadAnnotation, mode, SpreadAllDevicesInFleet))
+	}
+	if quorum, ok := pod.Annotations[SpreadQuorumAnnotation]; ok {
+		if !isSpreadPod(pod) {
+			problems = append(problems, fmt.Sprintf("%s annotation without %s", SpreadQuorumAnnotation, SpreadAnnotation))
+		} else if _, err := spreadQuorum(quorum, math.MaxInt32); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if strategy, ok := pod.Annotations[placementStrategyAnnotation]; ok {
+		if _, err := models.ParsePlacementStrategy(strategy); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid %s annotation: %v", placementStrategyAnnotation, err))
+		}
+	}
+	if _, err := p.translators.AppType(pod); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if err := flightctl.ValidatePodAnnotations(pod); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
+
+// deviceAdmissionProblems describes why the pod may not use the device its
+// device-id annotation names.
+func (p *Provider) deviceAdmissionProblems(pod *corev1.Pod, device *models.Device) []string {
+	var problems []string
+	switch {
+	case p.deviceID != "" && device.ID != p.deviceID:
+		problems = append(problems, fmt.Sprintf("pod requests device %s but node %s represents device %s", device.ID, p.nodeName, p.deviceID))
+	case p.fleetID != "" && device.FleetID != p.fleetID:
+		problems = append(problems, fmt.Sprintf("device %s is not in fleet %s of node %s", device.ID, p.fleetID, p.nodeName))
+	}
+	if p.deviceAccess != nil {
+		if err := p.deviceAccess.Allows(pod.Namespace, device); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems
+}
@@ -0,0 +1,73 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func admissionPod(annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: annotations},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx:1.25"}}},
+	}
+}
+
+func TestAdmitPod(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddFleet("lab", nil)
+	server.AddDevice("d1", "edge", nil)
+	server.AddDevice("d2", "lab", nil)
+	p := newTestProvider(t, server, Config{NodeName: "fleet-edge", FleetID: "edge"})
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantErr     string
+	}{
+		{name: "no annotations"},
+		{name: "device in the fleet", annotations: map[string]string{deviceIDAnnotation: "d1"}},
+		{name: "unknown device", annotations: map[string]string{deviceIDAnnotation: "d9"}, wantErr: "unknown device d9"},
+		{name: "device of another fleet", annotations: map[string]string{deviceIDAnnotation: "d2"}, wantErr: "not in fleet edge"},
+		{name: "other fleet", annotations: map[string]string{fleetIDAnnotation: "lab"}, wantErr: "represents fleet edge"},
+		{name: "bad spread mode", annotations: map[string]string{SpreadAnnotation: "some"}, wantErr: "unsupported flightctl.io/spread"},
+		{name: "quorum without spread", annotations: map[string]string{SpreadQuorumAnnotation: "2"}, wantErr: "without flightctl.io/spread"},
+		{name: "bad placement strategy", annotations: map[string]string{placementStrategyAnnotation: "cheapest"}, wantErr: "invalid flightctl.io/placement-strategy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.AdmitPod(context.Background(), admissionPod(tt.annotations))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("AdmitPod: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("AdmitPod error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTargetsPod(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	p := newTestProvider(t, server, Config{NodeName: "fleet-edge", FleetID: "edge"})
+
+	if p.TargetsPod(admissionPod(nil)) {
+		t.Error("pod without flightctl.io annotations or tolerations is targeted")
+	}
+	if !p.TargetsPod(admissionPod(map[string]string{deviceIDAnnotation: "d1"})) {
+		t.Error("pod with a flightctl.io annotation is not targeted")
+	}
+	pod := admissionPod(nil)
+	pod.Spec.Tolerations = []corev1.Toleration{{Operator: corev1.TolerationOpExists}}
+	if len(p.nodeTaints) > 0 && !p.TargetsPod(pod) {
+		t.Error("pod tolerating the node taints is not targeted")
+	}
+}
@@ -0,0 +1,238 @@
+package provider
+
+import (
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// DeviceTopologyKey is the topology key of pod affinity terms that place
+// pods relative to each other on FlightCtl devices, which all sit behind the
+// same virtual node: a pod with a required anti-affinity term keyed on it is
+// not placed on a device running a matching pod, and one with an affinity
+// term only on a device running a matching pod. Preferred terms pick among
+// the devices the pod fits on. Terms with other topology keys are left to
+// the scheduler.
+const DeviceTopologyKey = "flightctl.io/device"
+
+// devicePodsLocked indexes the pods placed on each device, other than
+// completed ones and the pod with podKey. Caller must hold p.mu.
+func (p *Provider) devicePodsLocked(podKey string) map[string][]*corev1.Pod {
+	placed := make(map[string][]*corev1.Pod)
+	for _, mapping := range p.podMappings {
+		if mapping.PodKey == podKey || mapping.IsCompleted() || mapping.Pod == nil {
+			continue
+		}
+		for _, deviceID := range mapping.Devices() {
+			placed[deviceID] = append(placed[deviceID], mapping.Pod)
+		}
+	}
+	return placed
+}
+
+// deviceAffinityConflictLocked returns an error if placing the pod on the
+// device breaks a required affinity or anti-affinity term keyed on
+// DeviceTopologyKey, of the pod or of a pod on the device. Caller must hold
+// p.mu.
+func (p *Provider) deviceAffinityConflictLocked(pod *corev1.Pod, deviceID string) error {
+	if !hasDeviceAffinity(pod) && !p.anyDeviceAntiAffinityLocked() {
+		return nil
+	}
+	return deviceAffinityConflict(pod, deviceID, p.devicePodsLocked(fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)))
+}
+
+// withDeviceAffinityLocked returns the devices the pod may be placed on
+// under its affinity terms and those of the pods already placed, narrowed to
+// the devices scoring best on its preferred terms. Caller must hold p.mu.
+func (p *Provider) withDeviceAffinityLocked(pod *corev1.Pod, devices []*models.Device) ([]*models.Device, error) {
+	if !hasDeviceAffinity(pod) && !p.anyDeviceAntiAffinityLocked() {
+		return devices, nil
+	}
+	placed := p.devicePodsLocked(fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+
+	var allowed []*models.Device
+	var lastErr error
+	for _, device := range devices {
+		if err := deviceAffinityConflict(pod, device.ID, placed); err != nil {
+			lastErr = err
+			continue
+		}
+		allowed = append(allowed, device)
+	}
+	if len(allowed) == 0 && lastErr != nil {
+		return nil, fmt.Errorf("pod affinity rules out all %d devices: %w", len(devices), lastErr)
+	}
+
+	// Keep the devices with the best preferred-term score
+	var best []*models.Device
+	bestScore := 0
+	for _, device := range allowed {
+		score := preferredDeviceScore(pod, placed[device.ID])
+		switch {
+		case len(best) == 0 || score > bestScore:
+			best, bestScore = []*models.Device{device}, score
+		case score == bestScore:
+			best = append(best, device)
+		}
+	}
+	return best, nil
+}
+
+// anyDeviceAntiAffinityLocked reports whether a placed pod has a required
+// anti-affinity term keyed on DeviceTopologyKey, which other pods must
+// respect too. Caller must hold p.mu.
+func (p *Provider) anyDeviceAntiAffinityLocked() bool {
+	for _, mapping := range p.podMappings {
+		if mapping.Pod != nil && !mapping.IsCompleted() {
+			if _, anti := requiredDeviceTerms(mapping.Pod); len(anti) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deviceAffinityConflict checks the required terms of the pod and of the
+// pods placed on the device.
+func deviceAffinityConflict(pod *corev1.Pod, deviceID string, placed map[string][]*corev1.Pod) error {
+	affinity, anti := requiredDeviceTerms(pod)
+	for _, term := range anti {
+		for _, other := range placed[deviceID] {
+			if termMatches(pod, term, other) {
+				return fmt.Errorf("anti-affinity with pod %s/%s on device %s", other.Namespace, other.Name, deviceID)
+			}
+		}
+	}
+	for _, other := range placed[deviceID] {
+		_, otherAnti := requiredDeviceTerms(other)
+		for _, term := range otherAnti {
+			if termMatches(other, term, pod) {
+				return fmt.Errorf("pod %s/%s on device %s has anti-affinity with the pod", other.Namespace, other.Name, deviceID)
+			}
+		}
+	}
+	for _, term := range affinity {
+		if slices.ContainsFunc(placed[deviceID], func(other *corev1.Pod) bool { return termMatches(pod, term, other) }) {
+			continue
+		}
+		// Like in the scheduler, the first pod of a group matching its
+		// own term may go anywhere
+		if termMatches(pod, term, pod) && !anyPlacedPodMatches(pod, term, placed) {
+			continue
+		}
+		return fmt.Errorf("no pod matching the affinity term %s on device %s", metav1.FormatLabelSelector(term.LabelSelector), deviceID)
+	}
+	return nil
+}
+
+// preferredDeviceScore sums the weights of the pod's preferred affinity
+// terms matched by a pod on the device, minus those of its preferred
+// anti-affinity terms.
+func preferredDeviceScore(pod *corev1.Pod, onDevice []*corev1.Pod) int {
+	score := 0
+	affinity, anti := preferredDeviceTerms(pod)
+	for _, weighted := range affinity {
+		if slices.ContainsFunc(onDevice, func(other *corev1.Pod) bool { return termMatches(pod, weighted.PodAffinityTerm, other) }) {
+			score += int(weighted.Weight)
+		}
+	}
+	for _, weighted := range anti {
+		if slices.ContainsFunc(onDevice, func(other *corev1.Pod) bool { return termMatches(pod, weighted.PodAffinityTerm, other) }) {
+			score -= int(weighted.Weight)
+		}
+	}
+	return score
+}
+
+func anyPlacedPodMatches(pod *corev1.Pod, term corev1.PodAffinityTerm, placed map[string][]*corev1.Pod) bool {
+	for _, pods := range placed {
+		if slices.ContainsFunc(pods, func(other *corev1.Pod) bool { return termMatches(pod, term, other) }) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDeviceAffinity reports whether the pod has affinity or anti-affinity
+// terms keyed on DeviceTopologyKey.
+func hasDeviceAffinity(pod *corev1.Pod) bool {
+	affinity, anti := requiredDeviceTerms(pod)
+	preferred, preferredAnti := preferredDeviceTerms(pod)
+	return len(affinity)+len(anti)+len(preferred)+len(preferredAnti) > 0
+}
+
+// requiredDeviceTerms returns the pod's required affinity and anti-affinity
+// terms keyed on DeviceTopologyKey.
+func requiredDeviceTerms(pod *corev1.Pod) (affinity, anti []corev1.PodAffinityTerm) {
+	if pod.Spec.Affinity == nil {
+		return nil, nil
+	}
+	if a := pod.Spec.Affinity.PodAffinity; a != nil {
+		affinity = deviceTerms(a.RequiredDuringSchedulingIgnoredDuringExecution)
+	}
+	if a := pod.Spec.Affinity.PodAntiAffinity; a != nil {
+		anti = deviceTerms(a.RequiredDuringSchedulingIgnoredDuringExecution)
+	}
+	return affinity, anti
+}
+
+// preferredDeviceTerms returns the pod's preferred affinity and
+// anti-affinity terms keyed on DeviceTopologyKey.
+func preferredDeviceTerms(pod *corev1.Pod) (affinity, anti []corev1.WeightedPodAffinityTerm) {
+	if pod.Spec.Affinity == nil {
+		return nil, nil
+	}
+	keyed := func(terms []corev1.WeightedPodAffinityTerm) []corev1.WeightedPodAffinityTerm {
+		var out []corev1.WeightedPodAffinityTerm
+		for _, term := range terms {
+			if term.PodAffinityTerm.TopologyKey == DeviceTopologyKey {
+				out = append(out, term)
+			}
+		}
+		return out
+	}
+	if a := pod.Spec.Affinity.PodAffinity; a != nil {
+		affinity = keyed(a.PreferredDuringSchedulingIgnoredDuringExecution)
+	}
+	if a := pod.Spec.Affinity.PodAntiAffinity; a != nil {
+		anti = keyed(a.PreferredDuringSchedulingIgnoredDuringExecution)
+	}
+	return affinity, anti
+}
+
+func deviceTerms(terms []corev1.PodAffinityTerm) []corev1.PodAffinityTerm {
+	var out []corev1.PodAffinityTerm
+	for _, term := range terms {
+		if term.TopologyKey == DeviceTopologyKey {
+			out = append(out, term)
+		}
+	}
+	return out
+}
+
+// termMatches reports whether another pod matches a term of the pod: it is
+// in one of the term's namespaces and carries the labels it selects. A term
+// without namespaces applies to the pod's own namespace, and an empty
+// namespace selector to all namespaces; other namespace selectors cannot be
+// evaluated without the namespaces' labels, and only the listed namespaces
+// are matched.
+func termMatches(pod *corev1.Pod, term corev1.PodAffinityTerm, other *corev1.Pod) bool {
+	switch {
+	case slices.Contains(term.Namespaces, other.Namespace):
+	case term.NamespaceSelector != nil && len(term.NamespaceSelector.MatchLabels) == 0 && len(term.NamespaceSelector.MatchExpressions) == 0:
+	case len(term.Namespaces) == 0 && term.NamespaceSelector == nil && other.Namespace == pod.Namespace:
+	default:
+		return false
+	}
+	// A missing label selector matches no pods
+	selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(other.Labels))
+}
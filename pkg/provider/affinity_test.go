@@ -0,0 +1,128 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func affinityPod(name, app string, affinity *corev1.Affinity) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name), Labels: map[string]string{"app": app}},
+		Spec: corev1.PodSpec{
+			Affinity:   affinity,
+			Containers: []corev1.Container{{Name: "app", Image: "app:1"}},
+		},
+	}
+}
+
+func deviceTerm(app string) corev1.PodAffinityTerm {
+	return corev1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}},
+		TopologyKey:   DeviceTopologyKey,
+	}
+}
+
+func (p *Provider) placedDevice(t *testing.T, podKey string) string {
+	t.Helper()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	mapping, ok := p.podMappings[podKey]
+	if !ok {
+		t.Fatalf("pod %s not tracked", podKey)
+	}
+	return mapping.DeviceID
+}
+
+func TestDeviceAntiAffinitySpreadsPods(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("d1", "edge", nil)
+	server.AddDevice("d2", "edge", nil)
+	p := newTestProvider(t, server, Config{NodeName: "fleet-edge", FleetID: "edge"})
+	ctx := context.Background()
+
+	anti := &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{deviceTerm("db")},
+	}}
+	if err := p.CreatePod(ctx, affinityPod("db-1", "db", anti)); err != nil {
+		t.Fatalf("CreatePod db-1: %v", err)
+	}
+	if err := p.CreatePod(ctx, affinityPod("db-2", "db", anti)); err != nil {
+		t.Fatalf("CreatePod db-2: %v", err)
+	}
+	if first, second := p.placedDevice(t, "default/db-1"), p.placedDevice(t, "default/db-2"); first == second {
+		t.Errorf("db-1 and db-2 both placed on %s", first)
+	}
+
+	err := p.CreatePod(ctx, affinityPod("db-3", "db", anti))
+	if err == nil || !strings.Contains(err.Error(), "anti-affinity") {
+		t.Errorf("CreatePod db-3 error = %v, want every device ruled out by anti-affinity", err)
+	}
+}
+
+func TestDeviceAffinityColocatesPods(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("d1", "edge", nil)
+	server.AddDevice("d2", "edge", nil)
+	p := newTestProvider(t, server, Config{NodeName: "fleet-edge", FleetID: "edge"})
+	ctx := context.Background()
+
+	web := affinityPod("web", "web", nil)
+	web.Annotations = map[string]string{deviceIDAnnotation: "d2"}
+	if err := p.CreatePod(ctx, web); err != nil {
+		t.Fatalf("CreatePod web: %v", err)
+	}
+
+	sidecar := affinityPod("sidecar", "sidecar", &corev1.Affinity{PodAffinity: &corev1.PodAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{deviceTerm("web")},
+	}})
+	if err := p.CreatePod(ctx, sidecar); err != nil {
+		t.Fatalf("CreatePod sidecar: %v", err)
+	}
+	if deviceID := p.placedDevice(t, "default/sidecar"); deviceID != "d2" {
+		t.Errorf("sidecar placed on %s, want d2 next to web", deviceID)
+	}
+
+	// A pod pinned away from its partner breaks its affinity
+	pinned := affinityPod("pinned", "sidecar", sidecar.Spec.Affinity)
+	pinned.Annotations = map[string]string{deviceIDAnnotation: "d1"}
+	if err := p.CreatePod(ctx, pinned); err == nil {
+		t.Error("CreatePod of a pod pinned away from its affinity partner succeeded")
+	}
+}
+
+func TestPreferredDeviceAffinity(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("d1", "edge", nil)
+	server.AddDevice("d2", "edge", nil)
+	p := newTestProvider(t, server, Config{NodeName: "fleet-edge", FleetID: "edge"})
+	ctx := context.Background()
+
+	cache := affinityPod("cache", "cache", nil)
+	cache.Annotations = map[string]string{deviceIDAnnotation: "d1"}
+	if err := p.CreatePod(ctx, cache); err != nil {
+		t.Fatalf("CreatePod cache: %v", err)
+	}
+	// The default placement would pick d2, which runs no pod yet
+	web := affinityPod("web", "web", &corev1.Affinity{PodAffinity: &corev1.PodAffinity{
+		PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{Weight: 50, PodAffinityTerm: deviceTerm("cache")}},
+	}})
+	if err := p.CreatePod(ctx, web); err != nil {
+		t.Fatalf("CreatePod web: %v", err)
+	}
+	if deviceID := p.placedDevice(t, "default/web"); deviceID != "d1" {
+		t.Errorf("web placed on %s, want d1 next to the preferred cache", deviceID)
+	}
+}
@@ -0,0 +1,68 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// reconcileRecord starts an audit record of an action taken for a pod on
+// its devices.
+func reconcileRecord(podKey string, operation models.ReconcileOperation, action models.ReconcileAction, devices ...string) models.ReconciliationRecord {
+	record := models.ReconciliationRecord{
+		PodKey:    podKey,
+		DeviceID:  strings.Join(devices, ","),
+		Operation: operation,
+		Action:    action,
+	}
+	if operation != models.ReconcileDelete {
+		record.DesiredState = corev1.PodRunning
+	}
+	return record
+}
+
+// recordReconcile completes a record with its result and adds it to the
+// audit trail, if one is configured.
+func (p *Provider) recordReconcile(record models.ReconciliationRecord, started time.Time, err error) {
+	if p.auditTrail == nil {
+		return
+	}
+	record.Timestamp = time.Now()
+	record.DurationSeconds = record.Timestamp.Sub(started).Seconds()
+	record.Result = models.ResultSuccess
+	if err != nil {
+		record.Result = models.ResultFailed
+		record.ErrorMessage = err.Error()
+	}
+	p.auditTrail.Record(record)
+}
+
+// recordPhaseChange records a change of a pod's phase seen in its status,
+// in the pod's phase history and the audit trail.
+func (p *Provider) recordPhaseChange(mapping *models.PodDeviceMapping, previous, status *corev1.PodStatus) {
+	if previous != nil && previous.Phase == status.Phase {
+		return
+	}
+	transition := models.PhaseTransition{Time: time.Now(), To: status.Phase, Reason: status.Reason}
+	if previous != nil {
+		transition.From = previous.Phase
+	}
+	p.mu.Lock()
+	mapping.RecordPhaseTransition(transition)
+	p.mu.Unlock()
+
+	record := reconcileRecord(mapping.PodKey, models.ReconcileStatus, models.ActionNone, mapping.Devices()...)
+	record.ActualState = status.Phase
+	record.Message = fmt.Sprintf("phase %s", status.Phase)
+	if previous != nil {
+		record.Message = fmt.Sprintf("phase %s -> %s", previous.Phase, status.Phase)
+	}
+	if status.Reason != "" {
+		record.Message += " (" + status.Reason + ")"
+	}
+	p.recordReconcile(record, time.Now(), nil)
+}
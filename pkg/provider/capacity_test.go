@@ -0,0 +1,233 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+func cpuPod(name, cpu string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:  "app",
+			Image: "app:1",
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse(cpu),
+			}},
+		}}},
+	}
+}
+
+func TestOvercommitRatioApply(t *testing.T) {
+	capacity := models.ResourceList{CPU: resource.MustParse("2"), Memory: resource.MustParse("4Gi")}
+
+	scaled := models.OvercommitRatio{CPU: 1.5, Memory: 2}.Apply(capacity)
+	if scaled.CPU.String() != "3" || scaled.Memory.String() != "8Gi" {
+		t.Errorf("scaled = %s/%s, want 3/8Gi", scaled.CPU.String(), scaled.Memory.String())
+	}
+	unscaled := models.OvercommitRatio{}.Apply(capacity)
+	if unscaled.CPU.String() != "2" || unscaled.Memory.String() != "4Gi" {
+		t.Errorf("zero ratios = %s/%s, want the capacity", unscaled.CPU.String(), unscaled.Memory.String())
+	}
+}
+
+func TestPlacementHonorsOvercommitRatio(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("factory-a", nil)
+	server.AddDevice("d1", "factory-a", map[string]string{
+		flightctl.CapacityCPULabel:    "2",
+		flightctl.CapacityMemoryLabel: "4Gi",
+	})
+	p := newTestProvider(t, server, Config{NodeName: "fleet-factory-a", FleetID: "factory-a", CPUOvercommitRatio: 2})
+	ctx := context.Background()
+
+	// 2 CPUs overcommitted twice fit two pods requesting 1.5 each
+	for _, name := range []string{"a", "b"} {
+		if err := p.CreatePod(ctx, cpuPod(name, "1500m")); err != nil {
+			t.Fatalf("CreatePod %s: %v", name, err)
+		}
+	}
+	var insufficient *models.InsufficientResourcesError
+	if err := p.CreatePod(ctx, cpuPod("c", "1500m")); !errors.As(err, &insufficient) {
+		t.Errorf("CreatePod c error = %v, want insufficient resources", err)
+	}
+	pinned := cpuPod("d", "1500m")
+	pinned.Annotations = map[string]string{"flightctl.io/device-id": "d1"}
+	if err := p.CreatePod(ctx, pinned); !errors.As(err, &insufficient) {
+		t.Errorf("CreatePod d pinned to a full device error = %v, want insufficient resources", err)
+	}
+	if err := p.CreatePod(ctx, cpuPod("e", "1")); err != nil {
+		t.Errorf("CreatePod e within the remaining capacity: %v", err)
+	}
+
+	fleet, err := p.flightctl.GetFleet(ctx, "factory-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := p.flightctl.ListDevices(ctx, "factory-a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetFleet(fleet, devices)
+	node, err := p.GetNode(ctx)
+	if err != nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	if cpu := node.Status.Capacity.Cpu().String(); cpu != "2" {
+		t.Errorf("CPU capacity = %s, want 2", cpu)
+	}
+	if cpu := node.Status.Allocatable.Cpu().String(); cpu != "4" {
+		t.Errorf("CPU allocatable = %s, want 4", cpu)
+	}
+	if mem := node.Status.Allocatable.Memory().String(); mem != "4Gi" {
+		t.Errorf("memory allocatable = %s, want 4Gi", mem)
+	}
+}
+
+func TestPlacementStrategyAnnotation(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("factory-a", nil)
+	server.AddDevice("large", "factory-a", map[string]string{flightctl.CapacityCPULabel: "4"})
+	server.AddDevice("small", "factory-a", map[string]string{flightctl.CapacityCPULabel: "2"})
+	p := newTestProvider(t, server, Config{NodeName: "fleet-factory-a", FleetID: "factory-a"})
+	ctx := context.Background()
+
+	if err := p.CreatePod(ctx, cpuPod("default", "1")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if apps := fetchDevice(t, server, "large").Spec.Applications; len(apps) != 1 {
+		t.Errorf("applications on large = %+v, want the pod placed on the most free device", apps)
+	}
+
+	packed := cpuPod("packed", "1")
+	packed.Annotations = map[string]string{placementStrategyAnnotation: string(models.PlacementBinPack)}
+	if err := p.CreatePod(ctx, packed); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if apps := fetchDevice(t, server, "small").Spec.Applications; len(apps) != 1 {
+		t.Errorf("applications on small = %+v, want the bin-packed pod", apps)
+	}
+
+	invalid := cpuPod("invalid", "1")
+	invalid.Annotations = map[string]string{placementStrategyAnnotation: "fastest"}
+	if err := p.CreatePod(ctx, invalid); err == nil {
+		t.Error("expected an error for an unknown placement strategy")
+	}
+}
+
+func TestExtendedResourcePlacement(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("factory-a", nil)
+	server.AddDevice("gpu", "factory-a", map[string]string{
+		flightctl.CapacityCPULabel:                       "2",
+		flightctl.CapacityLabelPrefix + "nvidia.com_gpu": "1",
+	})
+	server.AddDevice("plain", "factory-a", map[string]string{flightctl.CapacityCPULabel: "8"})
+	p := newTestProvider(t, server, Config{NodeName: "fleet-factory-a", FleetID: "factory-a"})
+	ctx := context.Background()
+
+	gpuPod := func(name string) *corev1.Pod {
+		pod := cpuPod(name, "1")
+		pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{"nvidia.com/gpu": resource.MustParse("1")}
+		return pod
+	}
+
+	// The GPU device is picked although the other has more free CPU
+	if err := p.CreatePod(ctx, gpuPod("inference")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if content := deployedContent(t, server, "gpu"); !strings.Contains(content, "      - \"nvidia.com/gpu=all\"\n") {
+		t.Errorf("content does not expose the GPU:\n%s", content)
+	}
+	var insufficient *models.InsufficientResourcesError
+	if err := p.CreatePod(ctx, gpuPod("training")); !errors.As(err, &insufficient) {
+		t.Errorf("CreatePod of a second GPU pod error = %v, want insufficient resources", err)
+	}
+
+	fleet, err := p.flightctl.GetFleet(ctx, "factory-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := p.flightctl.ListDevices(ctx, "factory-a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetFleet(fleet, devices)
+	node, err := p.GetNode(ctx)
+	if err != nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	if gpus := node.Status.Allocatable["nvidia.com/gpu"]; gpus.String() != "1" {
+		t.Errorf("GPU allocatable = %s, want 1", gpus.String())
+	}
+}
+
+func TestEphemeralStoragePlacement(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("factory-a", nil)
+	server.AddDevice("small-disk", "factory-a", nil)
+	server.AddDevice("pressured", "factory-a", map[string]string{flightctl.CapacityEphemeralStorageLabel: "500Gi"})
+	if err := server.SetCustomInfo("small-disk", map[string]string{flightctl.EphemeralStorageInfoKey: "20Gi"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.SetDeviceResources("pressured", "Healthy", "Healthy", "Critical"); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProvider(t, server, Config{NodeName: "fleet-factory-a", FleetID: "factory-a"})
+	ctx := context.Background()
+
+	storagePod := func(name string) *corev1.Pod {
+		pod := cpuPod(name, "100m")
+		pod.Spec.Containers[0].Resources.Requests[corev1.ResourceEphemeralStorage] = resource.MustParse("15Gi")
+		return pod
+	}
+
+	// The device under disk pressure gets no pods, whatever its free space
+	if err := p.CreatePod(ctx, storagePod("cache")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if apps := fetchDevice(t, server, "small-disk").Spec.Applications; len(apps) != 1 {
+		t.Errorf("applications on small-disk = %+v, want the pod", apps)
+	}
+	var insufficient *models.InsufficientResourcesError
+	if err := p.CreatePod(ctx, storagePod("cache-2")); !errors.As(err, &insufficient) {
+		t.Errorf("CreatePod of a pod the disk cannot fit error = %v, want insufficient resources", err)
+	}
+	pinned := cpuPod("pinned", "100m")
+	pinned.Annotations = map[string]string{"flightctl.io/device-id": "pressured"}
+	if err := p.CreatePod(ctx, pinned); err == nil {
+		t.Error("CreatePod pinned to a device under disk pressure: expected an error")
+	}
+
+	fleet, err := p.flightctl.GetFleet(ctx, "factory-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := p.flightctl.ListDevices(ctx, "factory-a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetFleet(fleet, devices)
+	node, err := p.GetNode(ctx)
+	if err != nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	if storage := node.Status.Capacity.StorageEphemeral().String(); storage != "520Gi" {
+		t.Errorf("ephemeral storage capacity = %s, want 520Gi", storage)
+	}
+}
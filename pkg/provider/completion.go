@@ -0,0 +1,104 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// Pods that do not restart, such as those of Jobs, complete: a pod with
+// RestartPolicy Never once it succeeded or failed, and one with OnFailure
+// once it succeeded (a failed container is restarted by the device). A
+// completed pod keeps its final status and is no longer reconciled or
+// counted against its devices' capacity. Its application stays on the
+// devices, so its logs can be read, for the completed pod retention and is
+// then removed; the pod itself is tracked until it is deleted.
+
+// DefaultCompletedPodRetention is how long the applications of completed
+// pods are kept on their devices.
+const DefaultCompletedPodRetention = time.Hour
+
+// podCompleted reports whether a pod with status is done running.
+func podCompleted(pod *corev1.Pod, status *corev1.PodStatus) bool {
+	if pod == nil || status == nil {
+		return false
+	}
+	switch pod.Spec.RestartPolicy {
+	case corev1.RestartPolicyNever:
+		return status.Phase == corev1.PodSucceeded || status.Phase == corev1.PodFailed
+	case corev1.RestartPolicyOnFailure:
+		return status.Phase == corev1.PodSucceeded
+	default:
+		return false
+	}
+}
+
+// markCompleted records that a pod completed with its last status read.
+// Caller must hold p.mu.
+func (p *Provider) markCompleted(mapping *models.PodDeviceMapping) {
+	if mapping.IsCompleted() || !podCompleted(mapping.Pod, mapping.Status) {
+		return
+	}
+	mapping.CompletedAt = time.Now()
+	mapping.ReadyDeadline = time.Time{}
+	mapping.PreviousPod = nil
+	logger.Info("Pod %s completed with phase %s", mapping.PodKey, mapping.Status.Phase)
+}
+
+// cleanupCompletedPods removes the applications of pods completed longer
+// than the retention ago from their devices.
+func (p *Provider) cleanupCompletedPods(ctx context.Context) {
+	if p.completedPodRetention <= 0 {
+		return
+	}
+
+	var due []*models.PodDeviceMapping
+	p.mu.Lock()
+	for _, mapping := range p.podMappings {
+		if mapping.IsCompleted() && !mapping.AppRemoved && !mapping.InFlight &&
+			time.Since(mapping.CompletedAt) >= p.completedPodRetention {
+			mapping.InFlight = true
+			due = append(due, mapping)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, mapping := range due {
+		started := time.Now()
+		pod := podForMapping(mapping)
+		devices := mapping.Devices()
+		var cleanupErr error
+		if mapping.RolloutFleet != "" {
+			cleanupErr = p.podManager.DeletePodFromFleet(ctx, pod, mapping.RolloutFleet)
+		} else {
+			for _, deviceID := range devices {
+				err := p.podManager.DeletePod(ctx, pod, deviceID)
+				if err != nil && !errors.Is(err, flightctl.ErrNotFound) {
+					cleanupErr = errors.Join(cleanupErr, fmt.Errorf("device %s: %w", deviceID, err))
+				}
+			}
+		}
+
+		record := reconcileRecord(mapping.PodKey, models.ReconcileUpdate, models.ActionRemove, devices...)
+		record.ActualState = mapping.Status.Phase
+		record.Message = fmt.Sprintf("removed the application of the pod completed at %s", mapping.CompletedAt.Format(time.RFC3339))
+		p.recordReconcile(record, started, cleanupErr)
+
+		p.mu.Lock()
+		mapping.InFlight = false
+		mapping.AppRemoved = cleanupErr == nil
+		p.mu.Unlock()
+		if cleanupErr != nil {
+			logger.Error("Removing the application of completed pod %s: %v", mapping.PodKey, cleanupErr)
+		} else {
+			logger.Info("Removed the application of completed pod %s from %v", mapping.PodKey, devices)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func jobPod(restartPolicy corev1.RestartPolicy) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "default", UID: "job-uid"},
+		Spec: corev1.PodSpec{
+			RestartPolicy: restartPolicy,
+			Containers:    []corev1.Container{{Name: "work", Image: "busybox:1.36"}},
+		},
+	}
+}
+
+func TestFailedJobPodCompletesAndIsCleanedUp(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	stopReconcileWorkers(p)
+
+	ctx := context.Background()
+	if err := p.CreatePod(ctx, jobPod(corev1.RestartPolicyNever)); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if err := server.SetApplicationStatus("device-1", flightctl.FlightctlApplicationStatus{
+		Name: "default-job", Status: "Stopped", Summary: "Exited (2)",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	p.reconcilePodStatus(ctx)
+
+	status, err := p.GetPodStatus(ctx, "default", "job")
+	if err != nil {
+		t.Fatalf("GetPodStatus: %v", err)
+	}
+	if status.Phase != corev1.PodFailed {
+		t.Fatalf("phase = %s, want Failed", status.Phase)
+	}
+	if term := status.ContainerStatuses[0].State.Terminated; term == nil || term.ExitCode != 2 {
+		t.Errorf("container state = %+v, want terminated with exit code 2", status.ContainerStatuses[0].State)
+	}
+
+	// A completed pod is no longer reconciled
+	if devices := p.reconcileDevices(); len(devices) != 0 {
+		t.Errorf("reconciled devices = %v, want none", devices)
+	}
+	if err := server.SetApplicationStatus("device-1", flightctl.FlightctlApplicationStatus{Name: "default-job", Status: "Running"}); err != nil {
+		t.Fatal(err)
+	}
+	p.reconcilePodStatus(ctx)
+	if status, _ := p.GetPodStatus(ctx, "default", "job"); status.Phase != corev1.PodFailed {
+		t.Errorf("phase after completion = %s, want Failed kept", status.Phase)
+	}
+
+	// Its application is kept for the retention, then removed
+	p.cleanupCompletedPods(ctx)
+	if apps := fetchDevice(t, server, "device-1").Spec.Applications; len(apps) != 1 {
+		t.Fatalf("applications = %+v, want the completed pod's kept during the retention", apps)
+	}
+	p.completedPodRetention = time.Nanosecond
+	p.cleanupCompletedPods(ctx)
+	if apps := fetchDevice(t, server, "device-1").Spec.Applications; len(apps) != 0 {
+		t.Errorf("applications = %+v, want none after the retention", apps)
+	}
+	if status, err := p.GetPodStatus(ctx, "default", "job"); err != nil || status.Phase != corev1.PodFailed {
+		t.Errorf("GetPodStatus after cleanup = %v, %v; want the Failed status kept", status, err)
+	}
+}
+
+func TestPodCompleted(t *testing.T) {
+	for _, tc := range []struct {
+		restartPolicy corev1.RestartPolicy
+		phase         corev1.PodPhase
+		want          bool
+	}{
+		{corev1.RestartPolicyNever, corev1.PodSucceeded, true},
+		{corev1.RestartPolicyNever, corev1.PodFailed, true},
+		{corev1.RestartPolicyNever, corev1.PodRunning, false},
+		{corev1.RestartPolicyOnFailure, corev1.PodSucceeded, true},
+		{corev1.RestartPolicyOnFailure, corev1.PodFailed, false},
+		{corev1.RestartPolicyAlways, corev1.PodSucceeded, false},
+	} {
+		if got := podCompleted(jobPod(tc.restartPolicy), &corev1.PodStatus{Phase: tc.phase}); got != tc.want {
+			t.Errorf("podCompleted(%s, %s) = %v, want %v", tc.restartPolicy, tc.phase, got, tc.want)
+		}
+	}
+}
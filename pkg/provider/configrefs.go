@@ -0,0 +1,343 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// Environment variables set from ConfigMap and Secret keys, and those of
+// the ConfigMaps and Secrets containers read whole with envFrom, are
+// resolved when a pod is deployed, so their values end up in the
+// application's inline content. When a referenced ConfigMap or Secret changes, the pods
+// using it are updated, which redeploys their application if the resolved
+// content changed.
+
+// RedeployOnConfigChangeAnnotation set to "false" keeps a pod's application
+// unchanged when a ConfigMap or Secret it uses changes; the new values are
+// only deployed with the next update of the pod.
+const RedeployOnConfigChangeAnnotation = "flightctl.io/redeploy-on-config-change"
+
+// Kinds of the objects pods read configuration from.
+const (
+	configMapKind = "ConfigMap"
+	secretKind    = "Secret"
+)
+
+// SetConfigListers sets the listers the ConfigMaps and Secrets referenced by
+// pods are read from. Without them, such variables are not set.
+func (p *Provider) SetConfigListers(configMaps corev1listers.ConfigMapLister, secrets corev1listers.SecretLister) {
+	p.configMaps = configMaps
+	p.secrets = secrets
+}
+
+// resolveConfigRefs returns a copy of the pod with the environment variables
+// set from ConfigMap and Secret keys replaced by their values, and the
+// envFrom sources of its containers replaced by their variables in the
+// flightctl.EnvFromAnnotation. The variables set from Secret keys are
+// listed in the flightctl.SecretEnvAnnotation. Optional references to
+// missing objects or keys are dropped; other missing ones are an error. The
+// pod itself is returned if it has no references to resolve.
+func (p *Provider) resolveConfigRefs(pod *corev1.Pod) (*corev1.Pod, error) {
+	_, envFromSet := pod.Annotations[flightctl.EnvFromAnnotation]
+	_, secretEnvSet := pod.Annotations[flightctl.SecretEnvAnnotation]
+	if envFromSet || secretEnvSet {
+		// Only resolved variables are delivered
+		pod = pod.DeepCopy()
+		delete(pod.Annotations, flightctl.EnvFromAnnotation)
+		delete(pod.Annotations, flightctl.SecretEnvAnnotation)
+	}
+	if p.configMaps == nil || p.secrets == nil || !usesConfigRefs(pod) {
+		return pod, nil
+	}
+
+	resolved := pod.DeepCopy()
+	var secretEnv []string
+	for _, container := range podContainers(resolved) {
+		env := container.Env[:0]
+		for _, variable := range container.Env {
+			value, ok, err := p.configRefValue(pod.Namespace, variable.ValueFrom)
+			if err != nil {
+				return nil, fmt.Errorf("resolving variable %s of container %s: %w", variable.Name, container.Name, err)
+			}
+			if ok {
+				if variable.ValueFrom.SecretKeyRef != nil {
+					secretEnv = append(secretEnv, variable.Name)
+				}
+				variable = corev1.EnvVar{Name: variable.Name, Value: value}
+			} else if variable.ValueFrom != nil && (variable.ValueFrom.ConfigMapKeyRef != nil || variable.ValueFrom.SecretKeyRef != nil) {
+				// Optional and missing
+				continue
+			}
+			env = append(env, variable)
+		}
+		container.Env = env
+	}
+	if len(secretEnv) > 0 {
+		flightctl.SetSecretEnv(resolved, secretEnv)
+	}
+
+	envFrom := make(map[string]flightctl.ContainerEnvFrom)
+	for _, container := range podContainers(resolved) {
+		if len(container.EnvFrom) == 0 {
+			continue
+		}
+		vars, skipped, err := p.envFromValues(pod.Namespace, container.EnvFrom)
+		if err != nil {
+			return nil, fmt.Errorf("resolving envFrom of container %s: %w", container.Name, err)
+		}
+		if len(skipped) > 0 {
+			p.recordEvent(pod, corev1.EventTypeWarning, "InvalidEnvironmentVariableNames",
+				"Keys from envFrom of container %s were skipped: %s", container.Name, strings.Join(skipped, ", "))
+		}
+		envFrom[container.Name] = vars
+		container.EnvFrom = nil
+	}
+	if len(envFrom) > 0 {
+		if err := flightctl.SetEnvFrom(resolved, envFrom); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
+
+// podContainers returns the init containers and containers of a pod, which
+// are all deployed.
+func podContainers(pod *corev1.Pod) []*corev1.Container {
+	containers := make([]*corev1.Container, 0, len(pod.Spec.InitContainers)+len(pod.Spec.Containers))
+	for i := range pod.Spec.InitContainers {
+		containers = append(containers, &pod.Spec.InitContainers[i])
+	}
+	for i := range pod.Spec.Containers {
+		containers = append(containers, &pod.Spec.Containers[i])
+	}
+	return containers
+}
+
+// envFromValues returns the variables of a container's envFrom sources,
+// named with their prefix. Like in Kubernetes, a variable set by several
+// sources takes the value of the last one. Keys that are not valid variable
+// names, and those whose value spans lines, which env files cannot hold,
+// are skipped and returned in skipped.
+func (p *Provider) envFromValues(namespace string, sources []corev1.EnvFromSource) (vars flightctl.ContainerEnvFrom, skipped []string, err error) {
+	vars = flightctl.ContainerEnvFrom{Config: make(map[string]string), Secrets: make(map[string]string)}
+	set := func(prefix, key, value string, secret bool) {
+		name := prefix + key
+		if len(validation.IsEnvVarName(name)) > 0 || !flightctl.EnvFileValue(value) {
+			skipped = append(skipped, name)
+			return
+		}
+		if secret {
+			vars.Secrets[name] = value
+			delete(vars.Config, name)
+		} else {
+			vars.Config[name] = value
+			delete(vars.Secrets, name)
+		}
+	}
+
+	for _, source := range sources {
+		switch {
+		case source.ConfigMapRef != nil:
+			ref := source.ConfigMapRef
+			configMap, err := p.configMaps.ConfigMaps(namespace).Get(ref.Name)
+			if err != nil {
+				if _, _, err := missingRef(err, ref.Optional, "configmap %s/%s", namespace, ref.Name); err != nil {
+					return vars, nil, err
+				}
+				continue
+			}
+			for key, value := range configMap.Data {
+				set(source.Prefix, key, value, false)
+			}
+		case source.SecretRef != nil:
+			ref := source.SecretRef
+			secret, err := p.secrets.Secrets(namespace).Get(ref.Name)
+			if err != nil {
+				if _, _, err := missingRef(err, ref.Optional, "secret %s/%s", namespace, ref.Name); err != nil {
+					return vars, nil, err
+				}
+				continue
+			}
+			for key, value := range secret.Data {
+				set(source.Prefix, key, string(value), true)
+			}
+		}
+	}
+	sort.Strings(skipped)
+	return vars, skipped, nil
+}
+
+// configRefValue returns the value of a ConfigMap or Secret key reference.
+// ok is false for other sources and for optional references that are
+// missing.
+func (p *Provider) configRefValue(namespace string, source *corev1.EnvVarSource) (value string, ok bool, err error) {
+	switch {
+	case source == nil:
+		return "", false, nil
+	case source.ConfigMapKeyRef != nil:
+		ref := source.ConfigMapKeyRef
+		configMap, err := p.configMaps.ConfigMaps(namespace).Get(ref.Name)
+		if err != nil {
+			return missingRef(err, ref.Optional, "configmap %s/%s", namespace, ref.Name)
+		}
+		value, found := configMap.Data[ref.Key]
+		if !found {
+			return missingRef(nil, ref.Optional, "key %s of configmap %s/%s", ref.Key, namespace, ref.Name)
+		}
+		return value, true, nil
+	case source.SecretKeyRef != nil:
+		ref := source.SecretKeyRef
+		secret, err := p.secrets.Secrets(namespace).Get(ref.Name)
+		if err != nil {
+			return missingRef(err, ref.Optional, "secret %s/%s", namespace, ref.Name)
+		}
+		value, found := secret.Data[ref.Key]
+		if !found {
+			return missingRef(nil, ref.Optional, "key %s of secret %s/%s", ref.Key, namespace, ref.Name)
+		}
+		return string(value), true, nil
+	default:
+		return "", false, nil
+	}
+}
+
+// missingRef handles a reference to a missing object (err is a not found
+// error) or key (err is nil): optional references are skipped, other ones
+// and lookup failures are an error.
+func missingRef(err error, optional *bool, format string, args ...interface{}) (string, bool, error) {
+	what := fmt.Sprintf(format, args...)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", false, fmt.Errorf("getting %s: %w", what, err)
+	}
+	if optional != nil && *optional {
+		return "", false, nil
+	}
+	return "", false, fmt.Errorf("%s not found", what)
+}
+
+// usesConfigRefs reports whether a pod sets variables from ConfigMap or
+// Secret keys, or from whole ConfigMaps or Secrets.
+func usesConfigRefs(pod *corev1.Pod) bool {
+	for _, container := range podContainers(pod) {
+		if len(container.EnvFrom) > 0 {
+			return true
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && (env.ValueFrom.ConfigMapKeyRef != nil || env.ValueFrom.SecretKeyRef != nil) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// podUsesConfig reports whether a pod reads the ConfigMap or Secret, or a
+// key of it.
+func podUsesConfig(pod *corev1.Pod, kind, namespace, name string) bool {
+	if pod == nil || pod.Namespace != namespace {
+		return false
+	}
+	for _, container := range podContainers(pod) {
+		for _, source := range container.EnvFrom {
+			if ref := source.ConfigMapRef; kind == configMapKind && ref != nil && ref.Name == name {
+				return true
+			}
+			if ref := source.SecretRef; kind == secretKind && ref != nil && ref.Name == name {
+				return true
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if ref := env.ValueFrom.ConfigMapKeyRef; kind == configMapKind && ref != nil && ref.Name == name {
+				return true
+			}
+			if ref := env.ValueFrom.SecretKeyRef; kind == secretKind && ref != nil && ref.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ConfigMapChanged updates the pods using a ConfigMap that was added,
+// changed or deleted.
+func (p *Provider) ConfigMapChanged(ctx context.Context, namespace, name string) {
+	p.configChanged(ctx, configMapKind, namespace, name)
+}
+
+// SecretChanged updates the pods using a Secret that was added, changed or
+// deleted.
+func (p *Provider) SecretChanged(ctx context.Context, namespace, name string) {
+	p.configChanged(ctx, secretKind, namespace, name)
+}
+
+// configChanged updates the running pods using a ConfigMap or Secret, other
+// than those that opted out. Updating a pod whose resolved application is
+// unchanged does not touch its devices.
+func (p *Provider) configChanged(ctx context.Context, kind, namespace, name string) {
+	var pods []*corev1.Pod
+	p.mu.RLock()
+	for _, mapping := range p.podMappings {
+		if mapping.InFlight || mapping.IsCompleted() || mapping.IsTerminating() ||
+			!podUsesConfig(mapping.Pod, kind, namespace, name) ||
+			mapping.Pod.Annotations[RedeployOnConfigChangeAnnotation] == "false" {
+			continue
+		}
+		pods = append(pods, mapping.Pod.DeepCopy())
+	}
+	p.mu.RUnlock()
+
+	ctx = logger.EnsureCorrelationID(ctx)
+	log := logger.FromContext(ctx)
+	for _, pod := range pods {
+		log.Info("%s %s/%s changed, updating pod %s/%s", kind, namespace, name, pod.Namespace, pod.Name)
+		if err := p.UpdatePod(ctx, pod); err != nil {
+			log.Error("Updating pod %s/%s after %s %s/%s changed: %v", pod.Namespace, pod.Name, kind, namespace, name, err)
+			p.recordEvent(pod, corev1.EventTypeWarning, "ConfigRedeployFailed",
+				"Redeploying after %s %s changed: %v", kind, name, err)
+		}
+	}
+}
+
+// configResolvingManager resolves the ConfigMap and Secret references of
+// pods before they are deployed, whichever path deploys them.
+type configResolvingManager struct {
+	flightctl.WorkloadManager
+	resolve func(pod *corev1.Pod) (*corev1.Pod, error)
+}
+
+func (m configResolvingManager) DeployPod(ctx context.Context, pod *corev1.Pod, deviceID string) error {
+	resolved, err := m.resolve(pod)
+	if err != nil {
+		return err
+	}
+	return m.WorkloadManager.DeployPod(ctx, resolved, deviceID)
+}
+
+func (m configResolvingManager) UpdatePod(ctx context.Context, pod *corev1.Pod, deviceID string) error {
+	resolved, err := m.resolve(pod)
+	if err != nil {
+		return err
+	}
+	return m.WorkloadManager.UpdatePod(ctx, resolved, deviceID)
+}
+
+func (m configResolvingManager) DeployPodToFleet(ctx context.Context, pod *corev1.Pod, fleetID string) error {
+	resolved, err := m.resolve(pod)
+	if err != nil {
+		return err
+	}
+	return m.WorkloadManager.DeployPodToFleet(ctx, resolved, fleetID)
+}
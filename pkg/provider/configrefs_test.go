@@ -0,0 +1,189 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+// configStores returns the stores behind the provider's ConfigMap and
+// Secret listers.
+func configStores(p *Provider) (configMaps, secrets cache.Indexer) {
+	configMaps = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	secrets = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	p.SetConfigListers(corev1listers.NewConfigMapLister(configMaps), corev1listers.NewSecretLister(secrets))
+	return configMaps, secrets
+}
+
+func configRefPod() *corev1.Pod {
+	optional := true
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid-web"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:  "app",
+			Image: "app:1",
+			Env: []corev1.EnvVar{
+				{Name: "MODE", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}, Key: "mode"}}},
+				{Name: "DB_PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "db"}, Key: "password"}}},
+				{Name: "EXTRA", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "extra"}, Key: "extra", Optional: &optional}}},
+			},
+		}}},
+	}
+}
+
+func deployedContent(t *testing.T, server *fake.Server, deviceID string) string {
+	t.Helper()
+	apps := fetchDevice(t, server, deviceID).Spec.Applications
+	if len(apps) != 1 || len(apps[0].Inline) == 0 {
+		t.Fatalf("applications = %+v, want one", apps)
+	}
+	return apps[0].Inline[0].Content
+}
+
+func TestConfigRefsAreResolvedAndRedeployedOnChange(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1"})
+	configMaps, secrets := configStores(p)
+	ctx := context.Background()
+
+	settings := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "default", ResourceVersion: "1"},
+		Data: map[string]string{"mode": "edge"}}
+	_ = configMaps.Add(settings)
+	_ = secrets.Add(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+		Data: map[string][]byte{"password": []byte("s3cret")}})
+
+	if err := p.CreatePod(ctx, configRefPod()); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	content := deployedContent(t, server, "d1")
+	if !strings.Contains(content, "MODE=edge") || !strings.Contains(content, "DB_PASSWORD=s3cret") {
+		t.Errorf("content lacks the resolved variables:\n%s", content)
+	}
+	if strings.Contains(content, "EXTRA") {
+		t.Errorf("content sets the missing optional variable:\n%s", content)
+	}
+
+	// Other ConfigMaps do not affect the pod
+	p.ConfigMapChanged(ctx, "default", "unrelated")
+
+	updated := settings.DeepCopy()
+	updated.Data["mode"] = "factory"
+	_ = configMaps.Update(updated)
+	p.ConfigMapChanged(ctx, "default", "settings")
+	if content := deployedContent(t, server, "d1"); !strings.Contains(content, "MODE=factory") {
+		t.Errorf("content not redeployed with the new ConfigMap value:\n%s", content)
+	}
+}
+
+func TestConfigChangeOptOut(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1"})
+	configMaps, secrets := configStores(p)
+	ctx := context.Background()
+
+	_ = configMaps.Add(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "default"},
+		Data: map[string]string{"mode": "edge"}})
+	_ = secrets.Add(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+		Data: map[string][]byte{"password": []byte("s3cret")}})
+
+	pod := configRefPod()
+	pod.Annotations = map[string]string{RedeployOnConfigChangeAnnotation: "false"}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+
+	_ = configMaps.Update(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "default"},
+		Data: map[string]string{"mode": "factory"}})
+	p.ConfigMapChanged(ctx, "default", "settings")
+	if content := deployedContent(t, server, "d1"); !strings.Contains(content, "MODE=edge") {
+		t.Errorf("opted-out pod was redeployed:\n%s", content)
+	}
+}
+
+func TestMissingConfigRefRejectsPod(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1"})
+	configMaps, _ := configStores(p)
+	_ = configMaps.Add(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "default"},
+		Data: map[string]string{"mode": "edge"}})
+
+	err := p.CreatePod(context.Background(), configRefPod())
+	if err == nil || !strings.Contains(err.Error(), "secret default/db not found") {
+		t.Fatalf("CreatePod error = %v, want the missing secret named", err)
+	}
+	if apps := fetchDevice(t, server, "d1").Spec.Applications; len(apps) != 0 {
+		t.Errorf("applications = %+v, want none", apps)
+	}
+}
+
+func TestEnvFromIsResolvedIntoEnvFiles(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1"})
+	configMaps, secrets := configStores(p)
+	ctx := context.Background()
+
+	settings := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "default"},
+		Data: map[string]string{"mode": "edge", "user": "config"}}
+	_ = configMaps.Add(settings)
+	_ = secrets.Add(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+		Data: map[string][]byte{"APP_user": []byte("secret"), "password": []byte("s3cret"), "cert": []byte("line1\nline2")}})
+
+	optional := true
+	pod := cpuPod("web", "100m")
+	// Users cannot set the resolved variables themselves
+	pod.Annotations = map[string]string{flightctl.EnvFromAnnotation: `{"app": {"config": {"INJECTED": "x"}}}`}
+	pod.Spec.Containers[0].EnvFrom = []corev1.EnvFromSource{
+		{Prefix: "APP_", ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}}},
+		{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "db"}}},
+		{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "extra"}, Optional: &optional}},
+	}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+
+	files := func() map[string]string {
+		files := make(map[string]string)
+		for _, content := range fetchDevice(t, server, "d1").Spec.Applications[0].Inline {
+			files[content.Path] = content.Content
+		}
+		return files
+	}
+	deployed := files()
+	if compose := deployed["podman-compose.yaml"]; !strings.Contains(compose, "env_file:\n      - ./app.env\n      - ./app.secret.env\n") {
+		t.Errorf("compose file does not read the env files:\n%s", compose)
+	}
+	// The Secret source comes last and sets APP_user; cert spans lines
+	if env := deployed["app.env"]; env != "APP_mode='edge'\n" {
+		t.Errorf("app.env = %q, want the prefixed ConfigMap variables", env)
+	}
+	if env := deployed["app.secret.env"]; env != "APP_user='secret'\npassword='s3cret'\n" {
+		t.Errorf("app.secret.env = %q, want the Secret variables", env)
+	}
+
+	updated := settings.DeepCopy()
+	updated.Data["mode"] = "factory"
+	_ = configMaps.Update(updated)
+	p.ConfigMapChanged(ctx, "default", "settings")
+	if env := files()["app.env"]; env != "APP_mode='factory'\n" {
+		t.Errorf("app.env = %q, want it redeployed with the new ConfigMap value", env)
+	}
+}
@@ -0,0 +1,202 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	"go.opentelemetry.io/otel/attribute"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/tracing"
+)
+
+// Streaming requests (exec, attach, port forwarding) run commands on the
+// pod's device through the FlightCtl device console, so they work for
+// devices behind NAT that only connect out to FlightCtl. The session ends
+// when the command exits or the API server connection drops, which cancels
+// the request context.
+
+// portForwardScript connects the console session's stdio to a port on the
+// device. Container ports are published on the device's host with the same
+// number, so the port is reached on the loopback address.
+const portForwardScript = `if command -v socat >/dev/null 2>&1; then exec socat - TCP:127.0.0.1:%[1]d; else exec nc 127.0.0.1 %[1]d; fi`
+
+// maxConsoleErrorOutput bounds the stderr kept to explain a failed session.
+const maxConsoleErrorOutput = 4096
+
+// PortForward forwards a local port to a port on the pod, through a console
+// session on its device running socat (or nc, if socat is missing). Only
+// ports the pod's containers declare are published on the device and can be
+// forwarded. A pod spread over several devices is reached on its first.
+func (p *Provider) PortForward(ctx context.Context, namespace, pod string, port int32, stream io.ReadWriteCloser) error {
+	ctx, span := tracing.Tracer().Start(ctx, "Provider.PortForward")
+	defer span.End()
+	span.SetAttributes(attribute.String("pod", namespace+"/"+pod), attribute.Int("port", int(port)))
+	defer stream.Close()
+
+	mapping, deviceID, err := p.consoleTarget(namespace, pod)
+	if err != nil {
+		return err
+	}
+	if !declaresPort(mapping, port) {
+		return fmt.Errorf("port %d is not a container port of pod %s/%s, so it is not published on device %s", port, namespace, pod, deviceID)
+	}
+
+	logger.FromContext(ctx).With("device", deviceID).Info("Forwarding port %d of pod %s/%s on device %s", port, namespace, pod, deviceID)
+	stderr := &limitedBuffer{limit: maxConsoleErrorOutput}
+	err = p.flightctl.StreamConsole(ctx, deviceID, []string{"sh", "-c", fmt.Sprintf(portForwardScript, port)}, flightctl.ConsoleStreams{
+		Stdin:  stream,
+		Stdout: stream,
+		Stderr: stderr,
+	})
+	if err != nil && ctx.Err() == nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return fmt.Errorf("forwarding port %d of pod %s/%s: %w", port, namespace, pod, err)
+	}
+	return nil
+}
+
+// RunInContainer executes a command in a container in the pod, through
+// podman exec in a console session on its device.
+func (p *Provider) RunInContainer(ctx context.Context, namespace, podName, containerName string, cmd []string, attach api.AttachIO) error {
+	ctx, span := tracing.Tracer().Start(ctx, "Provider.RunInContainer")
+	defer span.End()
+	span.SetAttributes(attribute.String("pod", namespace+"/"+podName), attribute.String("container", containerName))
+
+	subcommand := []string{"exec"}
+	if attach.Stdin() != nil {
+		subcommand = append(subcommand, "--interactive")
+	}
+	if attach.TTY() {
+		subcommand = append(subcommand, "--tty")
+	}
+	if err := p.streamToContainer(ctx, namespace, podName, containerName, subcommand, cmd, attach); err != nil {
+		return fmt.Errorf("executing in container %s of pod %s/%s: %w", containerName, namespace, podName, err)
+	}
+	return nil
+}
+
+// AttachToContainer attaches to the executing process of a container in the
+// pod, through podman attach in a console session on its device.
+func (p *Provider) AttachToContainer(ctx context.Context, namespace, podName, containerName string, attach api.AttachIO) error {
+	ctx, span := tracing.Tracer().Start(ctx, "Provider.AttachToContainer")
+	defer span.End()
+	span.SetAttributes(attribute.String("pod", namespace+"/"+podName), attribute.String("container", containerName))
+
+	subcommand := []string{"attach"}
+	if attach.Stdin() == nil {
+		subcommand = append(subcommand, "--no-stdin")
+	}
+	if err := p.streamToContainer(ctx, namespace, podName, containerName, subcommand, nil, attach); err != nil {
+		return fmt.Errorf("attaching to container %s of pod %s/%s: %w", containerName, namespace, podName, err)
+	}
+	return nil
+}
+
+// streamToContainer runs podman subcommand against a container of the pod,
+// connecting the session to the request's streams and terminal size.
+func (p *Provider) streamToContainer(ctx context.Context, namespace, podName, containerName string, subcommand, args []string, attach api.AttachIO) error {
+	mapping, deviceID, err := p.consoleTarget(namespace, podName)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(mapping.Pod.Spec.Containers, func(c corev1.Container) bool { return c.Name == containerName }) {
+		return fmt.Errorf("container %s not found", containerName)
+	}
+
+	streams := flightctl.ConsoleStreams{TTY: attach.TTY()}
+	// Unset streams are left nil rather than set to typed nil interfaces
+	if stdin := attach.Stdin(); stdin != nil {
+		streams.Stdin = stdin
+	}
+	if stdout := attach.Stdout(); stdout != nil {
+		streams.Stdout = stdout
+	}
+	if stderr := attach.Stderr(); stderr != nil {
+		streams.Stderr = stderr
+	}
+	if attach.TTY() && attach.Resize() != nil {
+		resize := make(chan flightctl.TerminalSize)
+		streams.Resize = resize
+		go func() {
+			for {
+				select {
+				case size, ok := <-attach.Resize():
+					if !ok {
+						return
+					}
+					select {
+					case resize <- flightctl.TerminalSize{Width: size.Width, Height: size.Height}:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	logger.FromContext(ctx).With("device", deviceID).Info("Running podman %s for container %s of pod %s/%s on device %s",
+		subcommand[0], containerName, namespace, podName, deviceID)
+	err = p.flightctl.StreamConsole(ctx, deviceID, flightctl.ContainerCommand(p.appNaming.ApplicationName(mapping.Pod), containerName, subcommand, args), streams)
+	if ctx.Err() != nil {
+		// The client went away; the console session was closed with it
+		return nil
+	}
+	return err
+}
+
+// consoleTarget returns the mapping of a pod and the device to open a
+// console session on.
+func (p *Provider) consoleTarget(namespace, pod string) (*models.PodDeviceMapping, string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	mapping, ok := p.podMappings[namespace+"/"+pod]
+	if !ok || mapping.Pod == nil {
+		return nil, "", fmt.Errorf("pod %s/%s not found", namespace, pod)
+	}
+	if mapping.InFlight {
+		return nil, "", fmt.Errorf("pod %s/%s is being deployed or deleted", namespace, pod)
+	}
+	deviceID := mapping.Devices()[0]
+	if _, disconnected := p.disconnects[deviceID]; disconnected {
+		return nil, "", fmt.Errorf("device %s of pod %s/%s: %w", deviceID, namespace, pod, flightctl.ErrDeviceOffline)
+	}
+	return mapping, deviceID, nil
+}
+
+// declaresPort reports whether a container of the pod declares port.
+func declaresPort(mapping *models.PodDeviceMapping, port int32) bool {
+	for _, container := range mapping.Pod.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			if containerPort.ContainerPort == port {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// limitedBuffer keeps the first limit bytes written to it.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,215 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func TestPortForwardThroughDeviceConsole(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+
+	// The device side echoes what is sent to the forwarded port
+	var command string
+	server.HandleConsole(func(device string, metadata flightctl.ConsoleMetadata, stdin io.Reader, stdout, stderr io.Writer, resize <-chan flightctl.TerminalSize) error {
+		command = strings.Join(metadata.Command.Args, " ")
+		_, err := io.Copy(stdout, stdin)
+		return err
+	})
+
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	ctx := context.Background()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name: "nginx", Image: "nginx:1.25",
+			Ports: []corev1.ContainerPort{{ContainerPort: 8080}},
+		}}},
+	}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+
+	local, remote := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- p.PortForward(ctx, "default", "web", 8080, remote) }()
+
+	if _, err := local.Write([]byte("ping")); err != nil {
+		t.Fatalf("writing to the forwarded port: %v", err)
+	}
+	buf := make([]byte, 4)
+	local.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(local, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("read %q, %v from the forwarded port, want ping", buf, err)
+	}
+	local.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("PortForward: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("PortForward did not return after the stream closed")
+	}
+	if !strings.Contains(command, "TCP:127.0.0.1:8080") {
+		t.Errorf("console command %q does not connect to port 8080", command)
+	}
+
+	// Ports the pod does not declare are not published on the device
+	local, remote = net.Pipe()
+	defer local.Close()
+	if err := p.PortForward(ctx, "default", "web", 9090, remote); err == nil {
+		t.Error("expected an error forwarding an undeclared port")
+	}
+	if err := p.PortForward(ctx, "default", "missing", 8080, remote); err == nil {
+		t.Error("expected an error forwarding to an unknown pod")
+	}
+}
+
+// testAttachIO is an api.AttachIO over pipes.
+type testAttachIO struct {
+	stdin  io.Reader
+	stdout io.WriteCloser
+	tty    bool
+	resize chan api.TermSize
+}
+
+func (a *testAttachIO) Stdin() io.Reader            { return a.stdin }
+func (a *testAttachIO) Stdout() io.WriteCloser      { return a.stdout }
+func (a *testAttachIO) Stderr() io.WriteCloser      { return nil }
+func (a *testAttachIO) TTY() bool                   { return a.tty }
+func (a *testAttachIO) Resize() <-chan api.TermSize { return a.resize }
+
+func TestAttachToContainerThroughDeviceConsole(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+
+	// The device side reports the first terminal size, then echoes stdin
+	var command string
+	var tty bool
+	sizes := make(chan flightctl.TerminalSize, 1)
+	server.HandleConsole(func(device string, metadata flightctl.ConsoleMetadata, stdin io.Reader, stdout, stderr io.Writer, resize <-chan flightctl.TerminalSize) error {
+		command = strings.Join(metadata.Command.Args, " ")
+		tty = metadata.TTY
+		sizes <- <-resize
+		_, err := io.Copy(stdout, stdin)
+		return err
+	})
+
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	ctx := context.Background()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "shell", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "Busybox", Image: "busybox:1.36", TTY: true, Stdin: true}}},
+	}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+	attach := &testAttachIO{stdin: stdinReader, stdout: stdoutWriter, tty: true, resize: make(chan api.TermSize, 1)}
+	attach.resize <- api.TermSize{Width: 120, Height: 40}
+	done := make(chan error, 1)
+	go func() { done <- p.AttachToContainer(ctx, "default", "shell", "Busybox", attach) }()
+
+	select {
+	case size := <-sizes:
+		if size.Width != 120 || size.Height != 40 {
+			t.Errorf("device got terminal size %+v, want 120x40", size)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("terminal size was not delivered to the device")
+	}
+	go stdinWriter.Write([]byte("ls\n"))
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(stdoutReader, buf); err != nil || string(buf) != "ls\n" {
+		t.Fatalf("read %q, %v from the attached container, want the echoed input", buf, err)
+	}
+	stdinWriter.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("AttachToContainer: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("AttachToContainer did not return after stdin closed")
+	}
+	if !tty {
+		t.Error("expected a TTY console session")
+	}
+	for _, want := range []string{"podman 'attach' \"$id\"", "com.docker.compose.project='default-shell'", "com.docker.compose.service='busybox'"} {
+		if !strings.Contains(command, want) {
+			t.Errorf("console command %q does not contain %q", command, want)
+		}
+	}
+
+	if err := p.AttachToContainer(ctx, "default", "shell", "missing", attach); err == nil {
+		t.Error("expected an error attaching to an unknown container")
+	}
+}
+
+func TestRunInContainerEndsWithTheRequest(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+
+	// The device side runs until the session is closed
+	var command string
+	server.HandleConsole(func(device string, metadata flightctl.ConsoleMetadata, stdin io.Reader, stdout, stderr io.Writer, resize <-chan flightctl.TerminalSize) error {
+		command = strings.Join(metadata.Command.Args, " ")
+		io.WriteString(stdout, "started\n")
+		_, err := io.Copy(io.Discard, stdin)
+		return err
+	})
+
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}}},
+	}
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+
+	// Dropping the API server connection cancels the request
+	ctx, cancel := context.WithCancel(context.Background())
+	stdinReader, stdinWriter := io.Pipe()
+	defer stdinWriter.Close()
+	stdoutReader, stdoutWriter := io.Pipe()
+	attach := &testAttachIO{stdin: stdinReader, stdout: stdoutWriter}
+	done := make(chan error, 1)
+	go func() {
+		done <- p.RunInContainer(ctx, "default", "web", "nginx", []string{"sh", "-c", "echo it's here"}, attach)
+	}()
+
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(stdoutReader, buf); err != nil || string(buf) != "started\n" {
+		t.Fatalf("read %q, %v from the exec session", buf, err)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("RunInContainer after the request ended: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunInContainer did not return after the request was cancelled")
+	}
+	if want := `podman 'exec' '--interactive' "$id" 'sh' '-c' 'echo it'\''s here'`; !strings.Contains(command, want) {
+		t.Errorf("console command %q does not contain %q", command, want)
+	}
+}
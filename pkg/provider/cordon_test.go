@@ -0,0 +1,81 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+// cordonDevice sets the cordon annotation of a device on the server.
+func cordonDevice(t *testing.T, server *fake.Server, deviceID, value string) {
+	t.Helper()
+	device := fetchDevice(t, server, deviceID)
+	device.Metadata.Annotations[flightctl.CordonKey] = value
+	server.PutDevice(*device)
+}
+
+func TestCordonedDeviceReceivesNoNewPods(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("factory-a", nil)
+	server.AddDevice("d1", "factory-a", map[string]string{flightctl.CapacityCPULabel: "4"})
+	server.AddDevice("d2", "factory-a", map[string]string{flightctl.CapacityCPULabel: "2"})
+	cordonDevice(t, server, "d1", flightctl.CordonValueTrue)
+	p := newTestProvider(t, server, Config{NodeName: "fleet-factory-a", FleetID: "factory-a"})
+	ctx := context.Background()
+
+	if err := p.CreatePod(ctx, cpuPod("web", "1")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if apps := fetchDevice(t, server, "d2").Spec.Applications; len(apps) != 1 {
+		t.Errorf("applications on d2 = %+v, want the pod placed off the cordoned device", apps)
+	}
+
+	pinned := cpuPod("pinned", "1")
+	pinned.Annotations = map[string]string{deviceIDAnnotation: "d1"}
+	if err := p.CreatePod(ctx, pinned); err == nil || !strings.Contains(err.Error(), "cordoned") {
+		t.Errorf("CreatePod pinned to a cordoned device error = %v, want it rejected", err)
+	}
+}
+
+func TestDrainedDeviceMovesPods(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("factory-a", nil)
+	server.AddDevice("d1", "factory-a", map[string]string{flightctl.CapacityCPULabel: "4"})
+	server.AddDevice("d2", "factory-a", map[string]string{flightctl.CapacityCPULabel: "2"})
+	p := newTestProvider(t, server, Config{NodeName: "fleet-factory-a", FleetID: "factory-a"})
+	ctx := context.Background()
+
+	if err := p.CreatePod(ctx, cpuPod("web", "1")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if apps := fetchDevice(t, server, "d1").Spec.Applications; len(apps) != 1 {
+		t.Fatalf("applications on d1 = %+v, want the pod", apps)
+	}
+
+	// Cordoning alone leaves the pod in place
+	cordonDevice(t, server, "d1", flightctl.CordonValueTrue)
+	p.checkDeviceConnectivity(ctx)
+	if apps := fetchDevice(t, server, "d1").Spec.Applications; len(apps) != 1 {
+		t.Fatalf("applications on cordoned d1 = %+v, want the pod kept", apps)
+	}
+
+	cordonDevice(t, server, "d1", flightctl.CordonValueDrain)
+	p.checkDeviceConnectivity(ctx)
+	if apps := fetchDevice(t, server, "d1").Spec.Applications; len(apps) != 0 {
+		t.Errorf("applications on drained d1 = %+v, want none", apps)
+	}
+	if apps := fetchDevice(t, server, "d2").Spec.Applications; len(apps) != 1 {
+		t.Errorf("applications on d2 = %+v, want the moved pod", apps)
+	}
+	p.mu.RLock()
+	deviceID := p.podMappings["default/web"].DeviceID
+	p.mu.RUnlock()
+	if deviceID != "d2" {
+		t.Errorf("pod mapped to %s, want d2", deviceID)
+	}
+}
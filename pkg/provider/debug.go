@@ -0,0 +1,244 @@
+package provider
+
+import (
+	"slices"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// DebugState is a snapshot of the provider's in-memory state, for operators
+// diagnosing memory growth or stuck pods. Pod specs are left out.
+type DebugState struct {
+	NodeName string `json:"node"`
+	DeviceID string `json:"device,omitempty"`
+	FleetID  string `json:"fleet,omitempty"`
+
+	// Devices the node represents, in the single device and fleet modes
+	Devices []DebugDevice `json:"devices,omitempty"`
+	// Devices considered disconnected, and when their pods time out
+	Disconnects []DebugDisconnect `json:"disconnects,omitempty"`
+
+	Pods []DebugPod `json:"pods"`
+	// Deleted pods whose final status is still served
+	RetainedPods []DebugRetainedPod `json:"retainedPods,omitempty"`
+
+	// Pod operations waiting for each device, and devices waiting for a
+	// status reconcile
+	PendingPodOperations map[string]int `json:"pendingPodOperations,omitempty"`
+	ReconcileQueueLength int            `json:"reconcileQueueLength"`
+
+	DeviceCache []flightctl.CachedDevice        `json:"deviceCache,omitempty"`
+	LogCache    []DebugCachedLogs               `json:"logCache,omitempty"`
+	ImagePins   map[types.UID]map[string]string `json:"imagePins,omitempty"`
+}
+
+// DebugDevice is a device of the node.
+type DebugDevice struct {
+	ID              string                 `json:"id"`
+	Phase           models.DevicePhase     `json:"phase,omitempty"`
+	ConnectionState models.ConnectionState `json:"connectionState,omitempty"`
+}
+
+// DebugDisconnect is a device considered disconnected.
+type DebugDisconnect struct {
+	DeviceID       string    `json:"device"`
+	DisconnectedAt time.Time `json:"disconnectedAt"`
+	TimeoutAt      time.Time `json:"timeoutAt"`
+	AffectedPods   []string  `json:"affectedPods,omitempty"`
+}
+
+// DebugPod is a tracked pod.
+type DebugPod struct {
+	PodKey              string          `json:"pod"`
+	UID                 types.UID       `json:"uid"`
+	Devices             []string        `json:"devices"`
+	Phase               corev1.PodPhase `json:"phase,omitempty"`
+	DeployedAt          time.Time       `json:"deployedAt"`
+	InFlight            bool            `json:"inFlight,omitempty"`
+	RolledBack          bool            `json:"rolledBack,omitempty"`
+	ReadyDeadline       *time.Time      `json:"readyDeadline,omitempty"`
+	CompletedAt         *time.Time      `json:"completedAt,omitempty"`
+	AppRemoved          bool            `json:"appRemoved,omitempty"`
+	TerminationDeadline *time.Time      `json:"terminationDeadline,omitempty"`
+	AwaitingDeviceUntil *time.Time      `json:"awaitingDeviceUntil,omitempty"`
+	// Last phase transitions, oldest first
+	PhaseHistory []models.PhaseTransition `json:"phaseHistory,omitempty"`
+}
+
+// DebugRetainedPod is a deleted pod whose final status is still served.
+type DebugRetainedPod struct {
+	PodKey       string                   `json:"pod"`
+	UID          types.UID                `json:"uid"`
+	Phase        corev1.PodPhase          `json:"phase"`
+	DeletedAt    time.Time                `json:"deletedAt"`
+	PhaseHistory []models.PhaseTransition `json:"phaseHistory,omitempty"`
+}
+
+// DebugCachedLogs are the cached logs of a container.
+type DebugCachedLogs struct {
+	PodKey    string    `json:"pod"`
+	Container string    `json:"container"`
+	DeviceID  string    `json:"device"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	Bytes     int       `json:"bytes"`
+}
+
+// cachedDeviceLister is implemented by FlightCtl clients caching device
+// reads.
+type cachedDeviceLister interface {
+	CachedDevices() []flightctl.CachedDevice
+}
+
+// DebugState returns a snapshot of the provider's state.
+func (p *Provider) DebugState() DebugState {
+	p.mu.RLock()
+	state := DebugState{
+		NodeName:             p.nodeName,
+		DeviceID:             p.deviceID,
+		FleetID:              p.fleetID,
+		Pods:                 make([]DebugPod, 0, len(p.podMappings)),
+		ReconcileQueueLength: p.reconcileQueue.Len(),
+	}
+	// Pinned nodes only see their own devices; a pinned device not read yet
+	// is known by its ID
+	pinned := p.deviceID != "" || p.fleetID != ""
+	devices := p.fleetDevices
+	if p.device != nil {
+		devices = []*models.Device{p.device}
+	} else if p.deviceID != "" {
+		devices = []*models.Device{{ID: p.deviceID}}
+	}
+	for _, device := range devices {
+		state.Devices = append(state.Devices, DebugDevice{ID: device.ID, Phase: device.Status.Phase, ConnectionState: device.ConnectionState})
+	}
+	for _, tracker := range p.disconnects {
+		state.Disconnects = append(state.Disconnects, DebugDisconnect{
+			DeviceID:       tracker.DeviceID,
+			DisconnectedAt: tracker.DisconnectedAt,
+			TimeoutAt:      tracker.TimeoutAt,
+			AffectedPods:   append([]string(nil), tracker.AffectedPods...),
+		})
+	}
+	for _, mapping := range p.podMappings {
+		pod := DebugPod{
+			PodKey:              mapping.PodKey,
+			UID:                 mapping.PodUID,
+			Devices:             append([]string(nil), mapping.Devices()...),
+			DeployedAt:          mapping.DeployedAt,
+			InFlight:            mapping.InFlight,
+			RolledBack:          mapping.RolledBack,
+			ReadyDeadline:       timeOrNil(mapping.ReadyDeadline),
+			CompletedAt:         timeOrNil(mapping.CompletedAt),
+			AppRemoved:          mapping.AppRemoved,
+			TerminationDeadline: timeOrNil(mapping.TerminationDeadline),
+			AwaitingDeviceUntil: timeOrNil(mapping.AwaitingDeviceUntil),
+			PhaseHistory:        slices.Clone(mapping.PhaseHistory),
+		}
+		if mapping.Status != nil {
+			pod.Phase = mapping.Status.Phase
+		}
+		state.Pods = append(state.Pods, pod)
+	}
+	for podKey, retained := range p.retainedPods {
+		state.RetainedPods = append(state.RetainedPods, DebugRetainedPod{
+			PodKey:       podKey,
+			UID:          retained.uid,
+			Phase:        retained.status.Phase,
+			DeletedAt:    retained.deletedAt,
+			PhaseHistory: slices.Clone(retained.history),
+		})
+	}
+	p.mu.RUnlock()
+
+	sort.Slice(state.Disconnects, func(i, j int) bool { return state.Disconnects[i].DeviceID < state.Disconnects[j].DeviceID })
+	sort.Slice(state.Pods, func(i, j int) bool { return state.Pods[i].PodKey < state.Pods[j].PodKey })
+	sort.Slice(state.RetainedPods, func(i, j int) bool { return state.RetainedPods[i].PodKey < state.RetainedPods[j].PodKey })
+
+	if p.podQueue != nil {
+		p.podQueue.mu.Lock()
+		for deviceID, ops := range p.podQueue.pending {
+			if state.PendingPodOperations == nil {
+				state.PendingPodOperations = make(map[string]int)
+			}
+			state.PendingPodOperations[deviceID] = len(ops)
+		}
+		p.podQueue.mu.Unlock()
+	}
+
+	// A client shared by several nodes caches the devices of all of them
+	if lister, ok := p.flightctl.(cachedDeviceLister); ok {
+		for _, cached := range lister.CachedDevices() {
+			if !pinned || containsDevice(devices, cached.DeviceID) {
+				state.DeviceCache = append(state.DeviceCache, cached)
+			}
+		}
+	}
+	if p.logCache != nil {
+		state.LogCache = p.logCache.describe()
+	}
+	if p.imagePins != nil {
+		state.ImagePins = p.imagePins.snapshot()
+	}
+	return state
+}
+
+// describe lists the cached logs, sorted by pod and container.
+func (c *logCache) describe() []DebugCachedLogs {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var logs []DebugCachedLogs
+	for podKey, containers := range c.entries {
+		for container, cached := range containers {
+			logs = append(logs, DebugCachedLogs{
+				PodKey:    podKey,
+				Container: container,
+				DeviceID:  cached.deviceID,
+				FetchedAt: cached.fetchedAt,
+				Bytes:     len(cached.data),
+			})
+		}
+	}
+	sort.Slice(logs, func(i, j int) bool {
+		if logs[i].PodKey != logs[j].PodKey {
+			return logs[i].PodKey < logs[j].PodKey
+		}
+		return logs[i].Container < logs[j].Container
+	})
+	return logs
+}
+
+// snapshot copies the digests the images of each pod were pinned to.
+func (p *imagePins) snapshot() map[types.UID]map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pins := make(map[types.UID]map[string]string, len(p.pods))
+	for uid, digests := range p.pods {
+		pins[uid] = make(map[string]string, len(digests))
+		for image, digest := range digests {
+			pins[uid][image] = digest
+		}
+	}
+	return pins
+}
+
+func containsDevice(devices []*models.Device, deviceID string) bool {
+	for _, device := range devices {
+		if device.ID == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
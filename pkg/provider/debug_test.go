@@ -0,0 +1,42 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func TestDebugState(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	server.AddDevice("d2", "", nil)
+
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1"}, func(cfg *flightctl.Config) {
+		cfg.DeviceCacheTTL = flightctl.DefaultDeviceCacheTTL
+	})
+	ctx := context.Background()
+	if err := p.CreatePod(ctx, cpuPod("web", "100m")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	// Reads of other devices are cached by the client, but not the node's
+	for _, id := range []string{"d1", "d2"} {
+		if _, err := p.flightctl.GetDevice(ctx, id); err != nil {
+			t.Fatalf("GetDevice %s: %v", id, err)
+		}
+	}
+
+	state := p.DebugState()
+	if state.NodeName != "d1" || len(state.Devices) != 1 || state.Devices[0].ID != "d1" {
+		t.Errorf("node and devices = %s %+v, want device d1", state.NodeName, state.Devices)
+	}
+	if len(state.Pods) != 1 || state.Pods[0].PodKey != "default/web" || state.Pods[0].UID != "uid-web" ||
+		len(state.Pods[0].Devices) != 1 || state.Pods[0].Devices[0] != "d1" {
+		t.Errorf("pods = %+v, want default/web on d1", state.Pods)
+	}
+	if len(state.DeviceCache) != 1 || state.DeviceCache[0].DeviceID != "d1" {
+		t.Errorf("device cache = %+v, want only d1", state.DeviceCache)
+	}
+}
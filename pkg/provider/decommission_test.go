@@ -0,0 +1,112 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+// mappedDevice returns the device a tracked pod is mapped to and its phase.
+func mappedDevice(t *testing.T, p *Provider, podKey string) (string, corev1.PodPhase) {
+	t.Helper()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	mapping, ok := p.podMappings[podKey]
+	if !ok {
+		t.Fatalf("pod %s not tracked", podKey)
+	}
+	var phase corev1.PodPhase
+	if mapping.Status != nil {
+		phase = mapping.Status.Phase
+	}
+	return mapping.DeviceID, phase
+}
+
+func TestRemovedDevicePodsAreRescheduled(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("factory-a", nil)
+	server.AddDevice("d1", "factory-a", nil)
+	p := newTestProvider(t, server, Config{NodeName: "fleet-factory-a", FleetID: "factory-a"})
+	ctx := context.Background()
+
+	if err := p.CreatePod(ctx, cpuPod("web", "1")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	server.AddDevice("d2", "factory-a", nil)
+	server.RemoveDevice("d1")
+	p.checkDeviceConnectivity(ctx)
+
+	if deviceID, _ := mappedDevice(t, p, "default/web"); deviceID != "d2" {
+		t.Errorf("pod mapped to %s, want d2", deviceID)
+	}
+	if apps := fetchDevice(t, server, "d2").Spec.Applications; len(apps) != 1 {
+		t.Errorf("applications on d2 = %+v, want the moved pod", apps)
+	}
+}
+
+func TestRemovedDeviceFailsPodsWithoutReplacement(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("factory-a", nil)
+	server.AddDevice("d1", "factory-a", nil)
+	p := newTestProvider(t, server, Config{NodeName: "fleet-factory-a", FleetID: "factory-a"})
+	ctx := context.Background()
+
+	if err := p.CreatePod(ctx, cpuPod("web", "1")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	server.RemoveDevice("d1")
+	p.checkDeviceConnectivity(ctx)
+
+	if _, phase := mappedDevice(t, p, "default/web"); phase != corev1.PodFailed {
+		t.Errorf("phase = %s, want Failed", phase)
+	}
+	pod, err := p.GetPodStatus(ctx, "default", "web")
+	if err != nil {
+		t.Fatalf("GetPodStatus: %v", err)
+	}
+	if pod.Reason != "DeviceRemoved" {
+		t.Errorf("reason = %q, want DeviceRemoved", pod.Reason)
+	}
+
+	// Deleting the failed pod cleans up its mapping
+	if err := p.DeletePod(ctx, cpuPod("web", "1")); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+	p.mu.RLock()
+	_, tracked := p.podMappings["default/web"]
+	p.mu.RUnlock()
+	if tracked {
+		t.Error("pod still tracked after deletion")
+	}
+}
+
+func TestDeviceLeavingFleetMovesPods(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("factory-a", nil)
+	server.AddFleet("factory-b", nil)
+	server.AddDevice("d1", "factory-a", nil)
+	p := newTestProvider(t, server, Config{NodeName: "fleet-factory-a", FleetID: "factory-a"})
+	ctx := context.Background()
+
+	if err := p.CreatePod(ctx, cpuPod("web", "1")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	server.AddDevice("d2", "factory-a", nil)
+	device := fetchDevice(t, server, "d1")
+	device.Metadata.Owner = "Fleet/factory-b"
+	server.PutDevice(*device)
+	p.checkDeviceConnectivity(ctx)
+
+	if deviceID, _ := mappedDevice(t, p, "default/web"); deviceID != "d2" {
+		t.Errorf("pod mapped to %s, want d2", deviceID)
+	}
+	if apps := fetchDevice(t, server, "d1").Spec.Applications; len(apps) != 0 {
+		t.Errorf("applications on d1 = %+v, want none", apps)
+	}
+}
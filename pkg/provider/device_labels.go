@@ -0,0 +1,173 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// Device labels are also copied onto nodes as they are, so pods can select
+// nodes by the labels operators set on their devices: a device's node
+// mirrors all of its labels, while the single node and fleet nodes take the
+// labels with an allow-listed prefix (Config.DeviceLabelPrefixes) that all
+// their devices setting them agree on. The single node lists the devices
+// again every device refresh interval. Labels of the kubernetes.io and
+// k8s.io domains, which Kubernetes reserves, and of the provider's
+// flightctl.io/ prefix are never taken from devices; other labels the node
+// already has are kept or replaced as the conflict policy says.
+
+// Device label conflict policies: what happens to a device label whose key
+// the node already has, set by the provider or Config.NodeLabels.
+const (
+	// DeviceLabelConflictKeepNode keeps the node's label.
+	DeviceLabelConflictKeepNode = "keep-node"
+	// DeviceLabelConflictPreferDevice replaces it with the device's.
+	DeviceLabelConflictPreferDevice = "prefer-device"
+)
+
+// reservedLabelDomains are the label domains, with their subdomains, that
+// device labels cannot set.
+var reservedLabelDomains = []string{"kubernetes.io", "k8s.io"}
+
+// reservedNodeLabel reports whether a node label may not be set from a
+// device label.
+func reservedNodeLabel(key string) bool {
+	if strings.HasPrefix(key, deviceSelectorPrefix) {
+		return true
+	}
+	domain, _, ok := strings.Cut(key, "/")
+	if !ok {
+		return false
+	}
+	for _, reserved := range reservedLabelDomains {
+		if domain == reserved || strings.HasSuffix(domain, "."+reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDeviceLabelPrefixes checks the prefixes of the device labels
+// copied onto the single and fleet nodes.
+func validateDeviceLabelPrefixes(prefixes []string) error {
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			return fmt.Errorf("device label prefixes must not be empty")
+		}
+		if reservedNodeLabel(prefix) {
+			return fmt.Errorf("device label prefix %q is reserved", prefix)
+		}
+	}
+	return nil
+}
+
+// hasDeviceLabelPrefix reports whether a device label is copied onto the
+// single and fleet nodes.
+func (p *Provider) hasDeviceLabelPrefix(key string) bool {
+	for _, prefix := range p.deviceLabelPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sharedDeviceLabels returns the device labels that selected allows and on
+// whose value all the devices setting them agree.
+func sharedDeviceLabels(devices []*models.Device, selected func(key string) bool) map[string]string {
+	labels := make(map[string]string)
+	conflicting := make(map[string]bool)
+	for _, device := range devices {
+		for key, value := range device.Labels {
+			if conflicting[key] || !selected(key) {
+				continue
+			}
+			if current, ok := labels[key]; ok && current != value {
+				delete(labels, key)
+				conflicting[key] = true
+				continue
+			}
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// nodeDeviceLabels returns the device labels copied onto the node.
+func (p *Provider) nodeDeviceLabels() map[string]string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	switch {
+	case p.deviceID != "":
+		if p.device == nil {
+			return nil
+		}
+		return sharedDeviceLabels([]*models.Device{p.device}, func(string) bool { return true })
+	case p.fleetID != "":
+		return sharedDeviceLabels(p.fleetDevices, p.hasDeviceLabelPrefix)
+	default:
+		return p.singleNodeDeviceLabels
+	}
+}
+
+// applyDeviceLabels copies the device labels onto the node, after its own
+// and the configured labels were set.
+func (p *Provider) applyDeviceLabels(node *corev1.Node) {
+	for key, value := range p.nodeDeviceLabels() {
+		if reservedNodeLabel(key) || len(validation.IsQualifiedName(key)) > 0 || len(validation.IsValidLabelValue(value)) > 0 {
+			continue
+		}
+		if _, set := node.Labels[key]; set && p.deviceLabelConflicts != DeviceLabelConflictPreferDevice {
+			logger.Debug("Keeping label %s of node %s over the device label", key, p.nodeName)
+			continue
+		}
+		node.Labels[key] = value
+	}
+}
+
+// deviceLabelLoop refreshes the device labels of the single node every
+// refresh interval, until the provider is shut down.
+func (p *Provider) deviceLabelLoop() {
+	ticker := time.NewTicker(p.deviceLabelInterval)
+	defer ticker.Stop()
+
+	p.refreshDeviceLabels(p.reconcileCtx)
+	for {
+		select {
+		case <-p.reconcileCtx.Done():
+			return
+		case <-ticker.C:
+			p.refreshDeviceLabels(p.reconcileCtx)
+		}
+	}
+}
+
+// refreshDeviceLabels lists the devices and pushes the node status when the
+// labels copied onto the single node changed.
+func (p *Provider) refreshDeviceLabels(ctx context.Context) {
+	devices, err := p.flightctl.ListDevices(ctx, "", nil)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Warn("Listing devices to refresh the labels of node %s: %v", p.nodeName, err)
+		}
+		return
+	}
+	labels := sharedDeviceLabels(devices, p.hasDeviceLabelPrefix)
+
+	p.mu.Lock()
+	changed := !maps.Equal(p.singleNodeDeviceLabels, labels)
+	p.singleNodeDeviceLabels = labels
+	p.mu.Unlock()
+	if changed {
+		logger.Info("Device labels of node %s changed: %v", p.nodeName, labels)
+		p.pushNodeStatus()
+	}
+}
@@ -0,0 +1,81 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func TestDeviceNodeMirrorsDeviceLabels(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "edge", map[string]string{
+		"site":                                  "galway",
+		"type":                                  "sensor",
+		corev1.LabelHostname:                    "spoofed",
+		"node-role.kubernetes.io/control-plane": "",
+	})
+
+	for policy, wantType := range map[string]string{
+		DeviceLabelConflictKeepNode:     "virtual-kubelet",
+		DeviceLabelConflictPreferDevice: "sensor",
+	} {
+		p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+		p.deviceLabelConflicts = policy
+		p.SetDevice(fetchDevice(t, server, "device-1").ToModel())
+		node, err := p.GetNode(context.Background())
+		if err != nil {
+			t.Fatalf("GetNode: %v", err)
+		}
+		if node.Labels["site"] != "galway" || node.Labels["flightctl.io/site"] != "galway" {
+			t.Errorf("%s: labels = %v, want site mirrored as is and under flightctl.io/", policy, node.Labels)
+		}
+		if node.Labels["type"] != wantType {
+			t.Errorf("%s: type label = %q, want %q", policy, node.Labels["type"], wantType)
+		}
+		if _, ok := node.Labels["node-role.kubernetes.io/control-plane"]; ok || node.Labels[corev1.LabelHostname] != "device-1" {
+			t.Errorf("%s: labels = %v, want the kubernetes.io labels left to the node", policy, node.Labels)
+		}
+	}
+}
+
+func TestSingleNodeSharesAllowedDeviceLabels(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", map[string]string{"example.com/site": "galway", "example.com/rack": "1", "other": "x"})
+	server.AddDevice("d2", "", map[string]string{"example.com/site": "galway", "example.com/rack": "2"})
+
+	p := newTestProvider(t, server, Config{NodeName: "edge", DeviceLabelPrefixes: []string{"example.com/"}})
+	nodeLabels := func() map[string]string {
+		p.refreshDeviceLabels(context.Background())
+		node, err := p.GetNode(context.Background())
+		if err != nil {
+			t.Fatalf("GetNode: %v", err)
+		}
+		return node.Labels
+	}
+
+	labels := nodeLabels()
+	if labels["example.com/site"] != "galway" {
+		t.Errorf("labels = %v, want the shared site", labels)
+	}
+	if _, ok := labels["example.com/rack"]; ok {
+		t.Errorf("labels = %v, want the racks the devices disagree on left out", labels)
+	}
+	if _, ok := labels["other"]; ok {
+		t.Errorf("labels = %v, want labels without an allowed prefix left out", labels)
+	}
+
+	// A device moving to another site removes the label on the next refresh
+	server.AddDevice("d3", "", map[string]string{"example.com/site": "cork"})
+	if _, ok := nodeLabels()["example.com/site"]; ok {
+		t.Errorf("labels = %v, want the site the devices no longer share removed", p.nodeDeviceLabels())
+	}
+
+	if _, err := NewProviderWithClient(Config{NodeName: "edge", DeviceLabelPrefixes: []string{"kubernetes.io/"}}, p.flightctl); err == nil {
+		t.Error("NewProviderWithClient with a reserved device label prefix succeeded, want an error")
+	}
+}
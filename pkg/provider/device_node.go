@@ -0,0 +1,252 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// Node labels identifying the device or fleet a virtual node represents.
+const (
+	DeviceIDLabel = "flightctl.io/device-id"
+	FleetIDLabel  = "flightctl.io/fleet-id"
+)
+
+// SetDevice updates the device a device-pinned provider represents and
+// pushes the resulting node status (labels, capacity, readiness) to the node
+// controller if it changed. It is called by the node discovery controller
+// on every poll.
+func (p *Provider) SetDevice(device *models.Device) {
+	if p.deviceID == "" || device == nil || device.ID != p.deviceID {
+		return
+	}
+
+	p.mu.Lock()
+	changed := !reflect.DeepEqual(p.device, device)
+	p.device = device
+	syncCordon := p.nodeCordoned == nil || *p.nodeCordoned != device.Cordoned
+	p.mu.Unlock()
+
+	if changed {
+		p.pushNodeStatus()
+	}
+	if syncCordon {
+		p.syncNodeCordon(context.Background(), device.Cordoned)
+	}
+}
+
+// cordonedForDeviceAnnotation marks a node the provider cordoned because its
+// device was cordoned, so it is uncordoned with the device while nodes
+// cordoned by an administrator are left alone.
+const cordonedForDeviceAnnotation = "flightctl.io/cordoned-for-device"
+
+// syncNodeCordon makes the node unschedulable while its device is cordoned
+// for maintenance, and schedulable again afterwards, if a Kubernetes client
+// is configured. Failures are logged and retried on the next SetDevice.
+func (p *Provider) syncNodeCordon(ctx context.Context, cordoned bool) {
+	if p.kubeClient == nil {
+		return
+	}
+	node, err := p.kubeClient.CoreV1().Nodes().Get(ctx, p.nodeName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return // Not registered yet
+	}
+	if err != nil {
+		logger.Warn("Getting node %s to sync the cordon of device %s: %v", p.nodeName, p.deviceID, err)
+		return
+	}
+
+	var patch string
+	switch {
+	case cordoned && !node.Spec.Unschedulable:
+		patch = `{"metadata":{"annotations":{"` + cordonedForDeviceAnnotation + `":"true"}},"spec":{"unschedulable":true}}`
+	case !cordoned && node.Annotations[cordonedForDeviceAnnotation] != "":
+		patch = `{"metadata":{"annotations":{"` + cordonedForDeviceAnnotation + `":null}},"spec":{"unschedulable":false}}`
+	}
+	if patch != "" {
+		if _, err := p.kubeClient.CoreV1().Nodes().Patch(ctx, p.nodeName, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+			logger.Warn("Syncing the cordon of node %s with device %s: %v", p.nodeName, p.deviceID, err)
+			return
+		}
+		logger.Info("Set node %s unschedulable=%t to match device %s", p.nodeName, cordoned, p.deviceID)
+	}
+
+	p.mu.Lock()
+	p.nodeCordoned = &cordoned
+	p.mu.Unlock()
+}
+
+// pushNodeStatus sends the current node to the node controller, if it
+// registered a callback with NotifyNodeStatus.
+func (p *Provider) pushNodeStatus() {
+	p.mu.RLock()
+	callback := p.nodeCallback
+	p.mu.RUnlock()
+	if callback == nil {
+		return
+	}
+
+	node, err := p.GetNode(context.Background())
+	if err != nil {
+		logger.Error("Error getting node status: %v", err)
+		return
+	}
+	callback(node)
+}
+
+// applyDevice describes the pinned device on the node: its identity,
+// labels and topology, the capacity it declares and its readiness. Device labels are
+// added under the flightctl.io/ prefix, so the nodeSelector entries that
+// select devices also select the node of a matching device.
+func (p *Provider) applyDevice(node *corev1.Node) {
+	p.mu.RLock()
+	device := p.device
+	p.mu.RUnlock()
+
+	node.Labels[DeviceIDLabel] = p.deviceID
+	if len(validation.IsValidLabelValue(p.deviceID)) == 0 {
+		node.Labels[DeviceTopologyKey] = p.deviceID
+	}
+	if device == nil {
+		return
+	}
+
+	if device.FleetID != "" && len(validation.IsValidLabelValue(device.FleetID)) == 0 {
+		node.Labels[FleetIDLabel] = device.FleetID
+	}
+	for key, value := range device.Labels {
+		label := deviceSelectorPrefix + key
+		if len(validation.IsQualifiedName(label)) > 0 || len(validation.IsValidLabelValue(value)) > 0 {
+			logger.Debug("Skipping device %s label %s=%s: not a valid node label", p.deviceID, key, value)
+			continue
+		}
+		if _, reserved := node.Labels[label]; reserved {
+			continue
+		}
+		node.Labels[label] = value
+	}
+	p.applyTopology(node, []*models.Device{device})
+
+	applySystemInfo(node, device.SystemInfo)
+	setCapacity(node, device.Capacity, p.overcommit)
+
+	switch {
+	case !device.IsReady():
+		reason := "DeviceNotReady"
+		if device.ConnectionState == models.Disconnected {
+			reason = "DeviceDisconnected"
+		}
+		setNodeNotReady(node, reason, fmt.Sprintf("Device %s is %s (%s)", p.deviceID, device.Status.Phase, device.ConnectionState))
+	case device.Health.UpdateError != "":
+		setNodeNotReady(node, "DeviceUpdateFailed",
+			fmt.Sprintf("Device %s failed to apply its spec: %s", p.deviceID, device.Health.UpdateError))
+	}
+	applyPressure(node, device.Health.MemoryPressure, device.Health.DiskPressure,
+		fmt.Sprintf("Device %s reports", p.deviceID))
+	p.power.apply(node, []*models.Device{device}, fmt.Sprintf("Device %s reports", p.deviceID))
+}
+
+// applyPressure turns the node's MemoryPressure and DiskPressure conditions
+// true for the resources its device(s) report as critical. The message is
+// prefixed with subject.
+func applyPressure(node *corev1.Node, memory, disk bool, subject string) {
+	if memory {
+		setNodeCondition(node, corev1.NodeMemoryPressure, corev1.ConditionTrue, "DeviceMemoryCritical",
+			subject+" critical memory usage")
+	}
+	if disk {
+		setNodeCondition(node, corev1.NodeDiskPressure, corev1.ConditionTrue, "DeviceDiskCritical",
+			subject+" critical disk usage")
+	}
+}
+
+// applySystemInfo reports the known fields of a device platform in the node
+// system info, its OS and architecture in the well-known labels used by
+// nodeAffinity and multi-arch image selection, and its addresses as the
+// node's.
+func applySystemInfo(node *corev1.Node, info models.SystemInfo) {
+	for _, ip := range info.IPAddresses {
+		node.Status.Addresses = append(node.Status.Addresses, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: ip})
+	}
+	nodeInfo := &node.Status.NodeInfo
+	for label, value := range map[string]string{
+		corev1.LabelOSStable:   info.OperatingSystem,
+		corev1.LabelArchStable: info.Architecture,
+	} {
+		if value != "" && len(validation.IsValidLabelValue(value)) == 0 {
+			node.Labels[label] = value
+		}
+	}
+	if info.OperatingSystem != "" {
+		nodeInfo.OperatingSystem = info.OperatingSystem
+	}
+	if info.Architecture != "" {
+		nodeInfo.Architecture = info.Architecture
+	}
+	if info.OSImage != "" {
+		nodeInfo.OSImage = info.OSImage
+	}
+	if info.KernelVersion != "" {
+		nodeInfo.KernelVersion = info.KernelVersion
+	}
+	if info.AgentVersion != "" {
+		nodeInfo.ContainerRuntimeVersion = "flightctl-agent://" + info.AgentVersion
+	}
+	if info.BootID != "" {
+		nodeInfo.BootID = info.BootID
+	}
+}
+
+// setCapacity sets the node's CPU, memory, ephemeral storage and extended
+// resource capacity to the known (non-zero) values of capacity, and its
+// allocatable to them scaled by the overcommit ratio, which storage and
+// extended resources are not.
+func setCapacity(node *corev1.Node, capacity models.ResourceList, overcommit models.OvercommitRatio) {
+	allocatable := overcommit.Apply(capacity)
+	for name, quantities := range map[corev1.ResourceName][2]resource.Quantity{
+		corev1.ResourceCPU:              {capacity.CPU, allocatable.CPU},
+		corev1.ResourceMemory:           {capacity.Memory, allocatable.Memory},
+		corev1.ResourceEphemeralStorage: {capacity.EphemeralStorage, allocatable.EphemeralStorage},
+	} {
+		if quantities[0].IsZero() {
+			continue
+		}
+		node.Status.Capacity[name] = quantities[0].DeepCopy()
+		node.Status.Allocatable[name] = quantities[1].DeepCopy()
+	}
+	for name, q := range capacity.Extended {
+		if q.IsZero() {
+			continue
+		}
+		node.Status.Capacity[name] = q.DeepCopy()
+		node.Status.Allocatable[name] = q.DeepCopy()
+	}
+}
+
+// setNodeNotReady turns the node's Ready condition false.
+func setNodeNotReady(node *corev1.Node, reason, message string) {
+	setNodeCondition(node, corev1.NodeReady, corev1.ConditionFalse, reason, message)
+}
+
+// setNodeCondition sets the status of one of the node's conditions.
+func setNodeCondition(node *corev1.Node, condType corev1.NodeConditionType, status corev1.ConditionStatus, reason, message string) {
+	for i := range node.Status.Conditions {
+		cond := &node.Status.Conditions[i]
+		if cond.Type != condType {
+			continue
+		}
+		cond.Status = status
+		cond.Reason = reason
+		cond.Message = message
+	}
+}
@@ -0,0 +1,278 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func fetchDevice(t *testing.T, server *fake.Server, deviceID string) *flightctl.FlightctlDevice {
+	t.Helper()
+	device, ok := server.Device(deviceID)
+	if !ok {
+		t.Fatalf("device %s not found", deviceID)
+	}
+	return device
+}
+
+func TestDeviceNodeDescribesDevice(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "edge", map[string]string{
+		"region":                      "galway",
+		flightctl.CapacityCPULabel:    "2",
+		flightctl.CapacityMemoryLabel: "4Gi",
+		"invalid label":               "x",
+	})
+
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	var notified []*corev1.Node
+	p.NotifyNodeStatus(context.Background(), func(node *corev1.Node) { notified = append(notified, node) })
+	p.SetDevice(fetchDevice(t, server, "device-1").ToModel())
+
+	node, err := p.GetNode(context.Background())
+	if err != nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	for key, want := range map[string]string{
+		DeviceIDLabel:         "device-1",
+		FleetIDLabel:          "edge",
+		"flightctl.io/region": "galway",
+	} {
+		if got := node.Labels[key]; got != want {
+			t.Errorf("label %s = %q, want %q", key, got, want)
+		}
+	}
+	if _, ok := node.Labels["flightctl.io/invalid label"]; ok {
+		t.Error("invalid device label was copied to the node")
+	}
+	if cpu := node.Status.Capacity.Cpu().String(); cpu != "2" {
+		t.Errorf("CPU capacity = %s, want 2", cpu)
+	}
+	if mem := node.Status.Allocatable.Memory().String(); mem != "4Gi" {
+		t.Errorf("memory allocatable = %s, want 4Gi", mem)
+	}
+
+	// Going offline marks the node NotReady through the status callback
+	if err := server.SetDeviceSummary("device-1", "Offline"); err != nil {
+		t.Fatal(err)
+	}
+	p.SetDevice(fetchDevice(t, server, "device-1").ToModel())
+	if len(notified) != 3 {
+		t.Fatalf("node status notified %d times, want 3", len(notified))
+	}
+	for _, cond := range notified[2].Status.Conditions {
+		if cond.Type == corev1.NodeReady && (cond.Status != corev1.ConditionFalse || cond.Reason != "DeviceDisconnected") {
+			t.Errorf("Ready condition = %s/%s, want False/DeviceDisconnected", cond.Status, cond.Reason)
+		}
+	}
+}
+
+func TestDeviceNodeDeploysToItsDevice(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+	server.AddDevice("device-2", "", nil)
+
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	ctx := context.Background()
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			NodeSelector: map[string]string{"flightctl.io/region": "galway"},
+			Containers:   []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}},
+		},
+	}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if apps := fetchDevice(t, server, "device-1").Spec.Applications; len(apps) != 1 {
+		t.Errorf("applications on device-1 = %+v, want one", apps)
+	}
+
+	other := pod.DeepCopy()
+	other.Name = "other"
+	other.Annotations = map[string]string{"flightctl.io/device-id": "device-2"}
+	if err := p.CreatePod(ctx, other); err == nil {
+		t.Error("expected an error for a pod annotated with another device")
+	}
+}
+
+func TestConcurrentPodsShareDeviceUpdates(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+
+	ctx := context.Background()
+	const pods = 8
+	errs := make(chan error, pods)
+	for i := 0; i < pods; i++ {
+		go func() {
+			errs <- p.CreatePod(ctx, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("web-%d", i), Namespace: "default"},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}}},
+			})
+		}()
+	}
+	for i := 0; i < pods; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("CreatePod: %v", err)
+		}
+	}
+
+	if apps := fetchDevice(t, server, "device-1").Spec.Applications; len(apps) != pods {
+		t.Errorf("applications = %d, want %d", len(apps), pods)
+	}
+	puts := 0
+	for _, r := range server.Requests() {
+		if r.Method == http.MethodPut {
+			puts++
+		}
+	}
+	if puts >= pods {
+		t.Errorf("PUT requests = %d, want fewer than one per pod", puts)
+	}
+}
+
+func TestDeviceNodeReportsSystemInfo(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "edge", nil)
+	if err := server.SetSystemInfo("device-1", map[string]string{
+		"operatingSystem": "linux",
+		"architecture":    "arm64",
+		"kernel":          "6.1.0",
+		"distroName":      "Fedora",
+		"distroVersion":   "40",
+		"agentVersion":    "v0.6.0",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	p.SetDevice(fetchDevice(t, server, "device-1").ToModel())
+
+	node, err := p.GetNode(context.Background())
+	if err != nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	if node.Labels[corev1.LabelArchStable] != "arm64" || node.Labels[corev1.LabelOSStable] != "linux" {
+		t.Errorf("labels = %v, want arm64/linux", node.Labels)
+	}
+	want := corev1.NodeSystemInfo{
+		KubeletVersion:          "vk-flightctl-v1.0.0",
+		OperatingSystem:         "linux",
+		Architecture:            "arm64",
+		OSImage:                 "Fedora 40",
+		KernelVersion:           "6.1.0",
+		ContainerRuntimeVersion: "flightctl-agent://v0.6.0",
+	}
+	if node.Status.NodeInfo != want {
+		t.Errorf("node info = %+v, want %+v", node.Status.NodeInfo, want)
+	}
+}
+
+func TestDeviceNodeConditionsFollowDeviceHealth(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "edge", nil)
+	if err := server.SetDeviceResources("device-1", "Healthy", "Critical", "Critical"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.SetDeviceCondition("device-1", flightctl.FlightctlCondition{
+		Type:    flightctl.DeviceUpdatingCondition,
+		Status:  "False",
+		Reason:  flightctl.DeviceUpdateErrorReason,
+		Message: "pulling image app:2: manifest unknown",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	p.SetDevice(fetchDevice(t, server, "device-1").ToModel())
+
+	node, err := p.GetNode(context.Background())
+	if err != nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	want := map[corev1.NodeConditionType]string{
+		corev1.NodeReady:          "DeviceUpdateFailed",
+		corev1.NodeMemoryPressure: "DeviceMemoryCritical",
+		corev1.NodeDiskPressure:   "DeviceDiskCritical",
+	}
+	for _, cond := range node.Status.Conditions {
+		reason, ok := want[cond.Type]
+		if !ok {
+			continue
+		}
+		wantStatus := corev1.ConditionTrue
+		if cond.Type == corev1.NodeReady {
+			wantStatus = corev1.ConditionFalse
+		}
+		if cond.Status != wantStatus || cond.Reason != reason {
+			t.Errorf("condition %s = %s (%s), want %s (%s)", cond.Type, cond.Status, cond.Reason, wantStatus, reason)
+		}
+		delete(want, cond.Type)
+	}
+	if len(want) > 0 {
+		t.Errorf("missing node conditions %v", want)
+	}
+
+	// Recovering clears the conditions
+	if err := server.SetDeviceResources("device-1", "Healthy", "Healthy", "Warning"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.SetDeviceCondition("device-1", flightctl.FlightctlCondition{
+		Type: flightctl.DeviceUpdatingCondition, Status: "False", Reason: "Updated",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	p.SetDevice(fetchDevice(t, server, "device-1").ToModel())
+	node, err = p.GetNode(context.Background())
+	if err != nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	for _, cond := range node.Status.Conditions {
+		healthy := cond.Status == corev1.ConditionFalse
+		if cond.Type == corev1.NodeReady {
+			healthy = cond.Status == corev1.ConditionTrue
+		}
+		if !healthy {
+			t.Errorf("condition %s = %s (%s) after the device recovered", cond.Type, cond.Status, cond.Reason)
+		}
+	}
+}
+
+func TestNodeHeartbeat(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	p := newTestProvider(t, server, Config{NodeName: "node", NodeHeartbeatInterval: time.Second})
+
+	// The unchanged node is pushed again every heartbeat
+	pushed := make(chan *corev1.Node, 10)
+	p.NotifyNodeStatus(context.Background(), func(node *corev1.Node) {
+		select {
+		case pushed <- node:
+		default:
+		}
+	})
+	<-pushed
+	select {
+	case node := <-pushed:
+		if node.Name != "node" {
+			t.Errorf("heartbeat pushed node %s", node.Name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("node status not pushed on the heartbeat")
+	}
+}
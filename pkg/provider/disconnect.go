@@ -0,0 +1,442 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// Actions taken when a device stays disconnected past its reconnect timeout.
+const (
+	// DisconnectActionReschedule moves the affected pods to another ready
+	// device in the same fleet, failing them if none is available.
+	DisconnectActionReschedule = "reschedule"
+	// DisconnectActionFail marks the affected pods Failed.
+	DisconnectActionFail = "fail"
+)
+
+const defaultDeviceReconnectTimeout = 5 * time.Minute
+
+// disconnectLoop periodically checks the connectivity of devices that host
+// pods and drives the disconnection timeout state machine.
+func (p *Provider) disconnectLoop() {
+	ticker := time.NewTicker(p.Tunables().DisconnectCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.reconcileCtx.Done():
+			logger.Info("Device disconnection monitor stopped")
+			return
+		case <-p.disconnectIntervalChanged:
+			ticker.Reset(p.Tunables().DisconnectCheckInterval)
+		case <-ticker.C:
+			p.checkDeviceConnectivity(p.reconcileCtx)
+		}
+	}
+}
+
+// checkDeviceConnectivity starts, cancels, or fires disconnection timeouts
+// for every device that currently hosts pods, and moves the pods off devices
+// being drained, removed from FlightCtl or moved out of their fleet. Spread
+// pods are skipped: their status already accounts for offline devices
+// through the quorum. So are completed pods, and deleted pods waiting for
+// their device to stop them.
+func (p *Provider) checkDeviceConnectivity(ctx context.Context) {
+	if !p.apiUnavailableSince().IsZero() {
+		// Devices cannot be told apart from the API being down
+		return
+	}
+	p.deployAwaitingPods(ctx)
+
+	p.mu.RLock()
+	podsByDevice := make(map[string][]string)
+	for key, mapping := range p.podMappings {
+		if mapping.IsSpread() || mapping.IsCompleted() || mapping.IsTerminating() || mapping.IsAwaitingDevice() {
+			continue
+		}
+		podsByDevice[mapping.DeviceID] = append(podsByDevice[mapping.DeviceID], key)
+	}
+	p.mu.RUnlock()
+
+	for deviceID, podKeys := range podsByDevice {
+		raw, err := p.flightctl.GetDevice(ctx, deviceID)
+		if errors.Is(err, flightctl.ErrNotFound) {
+			logger.Warn("Device %s was removed, %d pod(s) affected", deviceID, len(podKeys))
+			p.stopDisconnectTracking(deviceID)
+			p.evacuatePods(ctx, deviceID, podKeys, "removed", "DeviceRemoved",
+				fmt.Sprintf("Device %s was removed from FlightCtl", deviceID))
+			continue
+		}
+		if err != nil {
+			logger.Warn("Connectivity check for device %s failed: %v", deviceID, err)
+			continue
+		}
+		device := raw.ToModel()
+
+		if left := p.podsOutsideFleet(device, podKeys); len(left) > 0 {
+			logger.Warn("Device %s left the fleet of %d pod(s), now in fleet %q", deviceID, len(left), device.FleetID)
+			p.evacuatePods(ctx, deviceID, left, "reassigned", "DeviceLeftFleet",
+				fmt.Sprintf("Device %s left the fleet the pod was placed in", deviceID))
+			if podKeys = slices.DeleteFunc(podKeys, func(key string) bool { return slices.Contains(left, key) }); len(podKeys) == 0 {
+				continue
+			}
+		}
+
+		p.mu.Lock()
+		tracker, tracking := p.disconnects[deviceID]
+		p.mu.Unlock()
+
+		switch {
+		case device.ConnectionState == models.Disconnected && !tracking:
+			p.startDisconnectTimeout(deviceID, podKeys)
+		case device.ConnectionState != models.Disconnected && tracking:
+			p.cancelDisconnectTimeout(tracker)
+		case tracking && tracker.IsExpired():
+			p.handleDisconnectTimeout(ctx, device, tracker, podKeys)
+		case tracking:
+			// Pods placed on the device since it disconnected
+			p.mu.Lock()
+			p.markDisconnectedLocked(tracker, podKeys)
+			p.mu.Unlock()
+		}
+
+		if device.Draining && !tracking {
+			p.drainDevice(ctx, device, podKeys)
+		}
+	}
+}
+
+// podsOutsideFleet returns the pods of a device that target a fleet the
+// device no longer belongs to.
+func (p *Provider) podsOutsideFleet(device *models.Device, podKeys []string) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var left []string
+	for _, key := range podKeys {
+		mapping, ok := p.podMappings[key]
+		if !ok || mapping.Pod == nil {
+			continue
+		}
+		if fleetID := p.targetFleet(mapping.Pod); fleetID != "" && fleetID != device.FleetID {
+			left = append(left, key)
+		}
+	}
+	return left
+}
+
+// evacuatePods applies the disconnect action right away to pods whose
+// device is gone for them: they are moved to another device of the fleet
+// they were placed in, or failed with reason and message. why describes the
+// old device in events, e.g. "removed".
+func (p *Provider) evacuatePods(ctx context.Context, deviceID string, podKeys []string, why, reason, message string) {
+	for _, key := range podKeys {
+		p.mu.RLock()
+		mapping, ok := p.podMappings[key]
+		var fleetID string
+		if ok && mapping.Pod != nil {
+			fleetID = p.placementFleet(mapping.Pod)
+		}
+		p.mu.RUnlock()
+		if !ok {
+			continue
+		}
+
+		if p.disconnectAction == DisconnectActionReschedule && p.deviceID == "" && fleetID != "" {
+			started := time.Now()
+			err := p.reschedulePod(ctx, key, &models.Device{ID: deviceID, FleetID: fleetID}, why)
+			if err == nil {
+				continue
+			}
+			logger.Error("Rescheduling pod %s off %s device %s: %v", key, why, deviceID, err)
+			record := reconcileRecord(key, models.ReconcileUpdate, models.ActionDeploy, deviceID)
+			record.Message = fmt.Sprintf("rescheduling off %s device", why)
+			p.recordReconcile(record, started, err)
+		}
+		p.failPod(key, reason, message)
+	}
+}
+
+// stopDisconnectTracking stops the disconnection timeout of a device, if
+// one is running.
+func (p *Provider) stopDisconnectTracking(deviceID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if tracker, ok := p.disconnects[deviceID]; ok {
+		tracker.Cancel()
+		delete(p.disconnects, deviceID)
+	}
+}
+
+// drainDevice moves the pods off a device cordoned for maintenance with
+// CordonValueDrain. Pods without a replacement device stay and are retried
+// on the next check. A device-pinned node cannot move its pods; they are
+// left to be evicted by draining the node.
+func (p *Provider) drainDevice(ctx context.Context, device *models.Device, podKeys []string) {
+	if p.deviceID != "" {
+		return
+	}
+	logger.Info("Device %s is being drained, moving %d pod(s)", device.ID, len(podKeys))
+	for _, key := range podKeys {
+		if err := p.reschedulePod(ctx, key, device, "drained"); err != nil {
+			logger.Warn("Moving pod %s off drained device %s: %v", key, device.ID, err)
+		}
+	}
+}
+
+// startDisconnectTimeout marks the device's pods NotReady and starts tracking
+// the reconnect timeout.
+func (p *Provider) startDisconnectTimeout(deviceID string, podKeys []string) {
+	timeout := p.Tunables().DeviceReconnectTimeout
+	tracker, err := models.NewTimeoutTracker(deviceID, timeout, nil)
+	if err != nil {
+		logger.Error("Creating timeout tracker for device %s: %v", deviceID, err)
+		return
+	}
+
+	logger.Warn("Device %s disconnected, %d pod(s) affected; waiting %s for reconnection",
+		deviceID, len(podKeys), timeout)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.disconnects[deviceID] = tracker
+	p.markDisconnectedLocked(tracker, podKeys)
+}
+
+// markDisconnectedLocked marks the pods of a disconnected device NotReady
+// and adds them to its tracker, skipping those it already affects. Caller
+// must hold p.mu.
+func (p *Provider) markDisconnectedLocked(tracker *models.TimeoutTracker, podKeys []string) {
+	deviceID, timeout := tracker.DeviceID, tracker.TimeoutDuration
+	for _, key := range podKeys {
+		mapping, ok := p.podMappings[key]
+		if !ok || slices.Contains(tracker.AffectedPods, key) {
+			continue
+		}
+		tracker.AffectedPods = append(tracker.AffectedPods, key)
+		mapping.Status = notReadyStatus(mapping.Status, "DeviceDisconnected",
+			fmt.Sprintf("Device %s disconnected at %s", deviceID, tracker.DisconnectedAt.Format(time.RFC3339)))
+		p.recordPodEvent(mapping, corev1.EventTypeWarning, "DeviceDisconnected",
+			"Device %s disconnected; action %q will be applied if it does not reconnect by %s",
+			deviceID, p.disconnectAction, tracker.TimeoutAt.Format(time.RFC3339))
+		record := reconcileRecord(key, models.ReconcileStatus, models.ActionNone, deviceID)
+		record.ActualState = mapping.Status.Phase
+		record.Message = fmt.Sprintf("device disconnected, waiting %s for reconnection", timeout)
+		p.recordReconcile(record, time.Now(), nil)
+	}
+}
+
+// cancelDisconnectTimeout stops tracking a device that has reconnected. Pod
+// statuses are refreshed by the regular status reconciliation.
+func (p *Provider) cancelDisconnectTimeout(tracker *models.TimeoutTracker) {
+	logger.Info("Device %s reconnected after %s", tracker.DeviceID, time.Since(tracker.DisconnectedAt).Round(time.Second))
+	tracker.Cancel()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.disconnects, tracker.DeviceID)
+
+	for _, key := range tracker.AffectedPods {
+		if mapping, ok := p.podMappings[key]; ok {
+			p.recordPodEvent(mapping, corev1.EventTypeNormal, "DeviceReconnected",
+				"Device %s reconnected", tracker.DeviceID)
+			record := reconcileRecord(key, models.ReconcileStatus, models.ActionNone, tracker.DeviceID)
+			record.Message = "device reconnected"
+			p.recordReconcile(record, time.Now(), nil)
+		}
+	}
+}
+
+// handleDisconnectTimeout fails or reschedules the pods of a device whose
+// reconnect timeout has expired. podKeys are the pods on the device now,
+// which includes any placed there after it disconnected.
+func (p *Provider) handleDisconnectTimeout(ctx context.Context, device *models.Device, tracker *models.TimeoutTracker, podKeys []string) {
+	logger.Warn("Device %s did not reconnect within %s, applying %q to %d pod(s)",
+		device.ID, tracker.TimeoutDuration, p.disconnectAction, len(podKeys))
+
+	for _, key := range podKeys {
+		// A device-pinned node cannot move pods to another device; failing
+		// them lets their controllers recreate them on another node
+		if p.disconnectAction == DisconnectActionReschedule && p.deviceID == "" {
+			started := time.Now()
+			err := p.reschedulePod(ctx, key, device, "disconnected")
+			if err == nil {
+				continue
+			}
+			logger.Error("Rescheduling pod %s off device %s: %v", key, device.ID, err)
+			record := reconcileRecord(key, models.ReconcileUpdate, models.ActionDeploy, device.ID)
+			record.Message = "rescheduling off disconnected device"
+			p.recordReconcile(record, started, err)
+		}
+		p.failPod(key, "DeviceTimeout",
+			fmt.Sprintf("Device %s did not reconnect within %s", device.ID, tracker.TimeoutDuration))
+	}
+
+	p.mu.Lock()
+	delete(p.disconnects, device.ID)
+	p.mu.Unlock()
+}
+
+// reschedulePod deploys a pod to another ready device in the fleet of its
+// current device and removes it from the old device spec. why describes the
+// old device in events, e.g. "disconnected".
+func (p *Provider) reschedulePod(ctx context.Context, podKey string, from *models.Device, why string) error {
+	started := time.Now()
+	p.mu.RLock()
+	mapping, ok := p.podMappings[podKey]
+	if !ok || mapping.DeviceID != from.ID {
+		p.mu.RUnlock()
+		return nil // deleted or already moved
+	}
+	pod := mapping.Pod
+	p.mu.RUnlock()
+
+	if pod == nil {
+		return fmt.Errorf("pod spec not tracked")
+	}
+	if from.FleetID == "" {
+		return fmt.Errorf("device %s does not belong to a fleet", from.ID)
+	}
+
+	devices, err := p.flightctl.ListDevices(ctx, from.FleetID, nil)
+	if err != nil {
+		return fmt.Errorf("listing devices in fleet %s: %w", from.FleetID, err)
+	}
+	candidates := make([]*models.Device, 0, len(devices))
+	for _, d := range p.deviceAccess.allowedDevices(pod.Namespace, devices) {
+		if d.ID != from.ID {
+			candidates = append(candidates, d)
+		}
+	}
+
+	requests := models.PodRequests(pod)
+	fleetID := from.FleetID
+	target := &models.DeploymentTarget{FleetID: &fleetID, Requests: &requests}
+
+	p.mu.RLock()
+	p.applyAllocationsLocked(candidates)
+	candidates, err = p.withDeviceAffinityLocked(pod, candidates)
+	if err == nil {
+		candidates, err = p.withTopologySpreadLocked(pod, candidates)
+	}
+	if err == nil {
+		err = p.setPlacementLocked(target, pod)
+	}
+	var next *models.Device
+	if err == nil {
+		next, err = target.SelectDevice(candidates, p.podsByDeviceLocked())
+	}
+	p.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("no replacement device in fleet %s: %w", fleetID, err)
+	}
+
+	if err := p.podManager.DeployPod(ctx, pod, next.ID); err != nil {
+		return fmt.Errorf("deploying to device %s: %w", next.ID, err)
+	}
+
+	// An offline device's spec is stored by FlightCtl and will be applied
+	// when it reconnects, so remove the stale application now.
+	if err := p.podManager.DeletePod(ctx, pod, from.ID); err != nil {
+		logger.Warn("Removing pod %s from %s device %s: %v", podKey, why, from.ID, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if mapping, ok := p.podMappings[podKey]; ok {
+		mapping.DeviceID = next.ID
+		mapping.DeployedAt = time.Now()
+		p.startReadyDeadline(mapping, nil)
+		mapping.Status = &corev1.PodStatus{
+			Phase: corev1.PodPending,
+			Conditions: []corev1.PodCondition{
+				{
+					Type:               corev1.PodScheduled,
+					Status:             corev1.ConditionTrue,
+					LastTransitionTime: metav1.Now(),
+					Reason:             "Rescheduled",
+					Message:            fmt.Sprintf("Pod rescheduled from %s device %s to %s", why, from.ID, next.ID),
+				},
+			},
+		}
+		p.recordPodEvent(mapping, corev1.EventTypeNormal, "Rescheduled",
+			"Pod rescheduled from %s device %s to device %s", why, from.ID, next.ID)
+		record := reconcileRecord(podKey, models.ReconcileUpdate, models.ActionDeploy, next.ID)
+		record.ActualState = corev1.PodPending
+		record.Message = fmt.Sprintf("rescheduled from %s device %s", why, from.ID)
+		p.recordReconcile(record, started, nil)
+	}
+
+	logger.Info("Rescheduled pod %s from device %s to %s", podKey, from.ID, next.ID)
+	return nil
+}
+
+// failPod marks a tracked pod as Failed with the given reason.
+func (p *Provider) failPod(podKey, reason, message string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	mapping, ok := p.podMappings[podKey]
+	if !ok {
+		return
+	}
+	transition := models.PhaseTransition{Time: time.Now(), To: corev1.PodFailed, Reason: reason}
+	if mapping.Status != nil {
+		transition.From = mapping.Status.Phase
+	}
+	mapping.RecordPhaseTransition(transition)
+	mapping.Status = &corev1.PodStatus{
+		Phase:   corev1.PodFailed,
+		Reason:  reason,
+		Message: message,
+		Conditions: []corev1.PodCondition{
+			{
+				Type:               corev1.PodReady,
+				Status:             corev1.ConditionFalse,
+				LastTransitionTime: metav1.Now(),
+				Reason:             reason,
+				Message:            message,
+			},
+		},
+	}
+	p.recordPodEvent(mapping, corev1.EventTypeWarning, reason, "%s", message)
+
+	record := reconcileRecord(podKey, models.ReconcileStatus, models.ActionNone, mapping.Devices()...)
+	record.ActualState = corev1.PodFailed
+	record.Message = "pod failed"
+	p.recordReconcile(record, time.Now(), fmt.Errorf("%s: %s", reason, message))
+}
+
+// notReadyStatus returns a copy of status with the Ready condition set to False.
+func notReadyStatus(status *corev1.PodStatus, reason, message string) *corev1.PodStatus {
+	updated := &corev1.PodStatus{Phase: corev1.PodRunning}
+	if status != nil {
+		updated = status.DeepCopy()
+	}
+
+	ready := corev1.PodCondition{
+		Type:               corev1.PodReady,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+
+	for i := range updated.Conditions {
+		if updated.Conditions[i].Type == corev1.PodReady {
+			updated.Conditions[i] = ready
+			return updated
+		}
+	}
+	updated.Conditions = append(updated.Conditions, ready)
+	return updated
+}
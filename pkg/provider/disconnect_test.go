@@ -0,0 +1,151 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// trackPod records a pod as placed on a device.
+func trackPod(p *Provider, name, deviceID string) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	mapping := models.NewPodDeviceMapping(pod.Namespace, pod.Name, pod.UID, deviceID)
+	mapping.Pod = pod
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.podMappings[mapping.PodKey] = mapping
+}
+
+// podStatus returns the tracked status of a pod in the default namespace.
+func podStatus(t *testing.T, p *Provider, name string) *corev1.PodStatus {
+	t.Helper()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	mapping, ok := p.podMappings["default/"+name]
+	if !ok {
+		t.Fatalf("pod %s is not tracked", name)
+	}
+	return mapping.Status.DeepCopy()
+}
+
+// podReady returns the status of the Ready condition of a tracked pod.
+func podReady(t *testing.T, p *Provider, name string) (corev1.ConditionStatus, string) {
+	t.Helper()
+	if status := podStatus(t, p, name); status != nil {
+		for _, cond := range status.Conditions {
+			if cond.Type == corev1.PodReady {
+				return cond.Status, cond.Reason
+			}
+		}
+	}
+	return "", ""
+}
+
+// expireDisconnect moves the reconnect deadline of a device into the past.
+func expireDisconnect(t *testing.T, p *Provider, deviceID string) {
+	t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tracker, ok := p.disconnects[deviceID]
+	if !ok {
+		t.Fatalf("device %s is not tracked as disconnected", deviceID)
+	}
+	tracker.TimeoutAt = time.Now().Add(-time.Second)
+}
+
+func tracksDisconnect(p *Provider, deviceID string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.disconnects[deviceID]
+	return ok
+}
+
+func TestDisconnectMarksPodsNotReadyUntilReconnect(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "vk-test", DisconnectAction: DisconnectActionFail})
+	ctx := context.Background()
+	trackPod(p, "web", "d1")
+
+	if err := server.SetDeviceSummary("d1", "Offline"); err != nil {
+		t.Fatalf("SetDeviceSummary: %v", err)
+	}
+	p.checkDeviceConnectivity(ctx)
+	if !tracksDisconnect(p, "d1") {
+		t.Fatal("disconnected device is not tracked")
+	}
+	if status, reason := podReady(t, p, "web"); status != corev1.ConditionFalse || reason != "DeviceDisconnected" {
+		t.Errorf("Ready = %s (%s), want False (DeviceDisconnected)", status, reason)
+	}
+
+	if err := server.SetDeviceSummary("d1", "Online"); err != nil {
+		t.Fatalf("SetDeviceSummary: %v", err)
+	}
+	p.checkDeviceConnectivity(ctx)
+	if tracksDisconnect(p, "d1") {
+		t.Error("reconnected device is still tracked as disconnected")
+	}
+	if phase := podStatus(t, p, "web").Phase; phase == corev1.PodFailed {
+		t.Error("pod failed after its device reconnected")
+	}
+}
+
+func TestDisconnectTimeoutFailsPods(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "vk-test", DisconnectAction: DisconnectActionFail})
+	ctx := context.Background()
+	trackPod(p, "web", "d1")
+
+	if err := server.SetDeviceSummary("d1", "Offline"); err != nil {
+		t.Fatalf("SetDeviceSummary: %v", err)
+	}
+	p.checkDeviceConnectivity(ctx)
+
+	// A pod placed on the device after it disconnected is affected too
+	trackPod(p, "late", "d1")
+	p.checkDeviceConnectivity(ctx)
+	if status, reason := podReady(t, p, "late"); status != corev1.ConditionFalse || reason != "DeviceDisconnected" {
+		t.Errorf("late pod Ready = %s (%s), want False (DeviceDisconnected)", status, reason)
+	}
+
+	expireDisconnect(t, p, "d1")
+	p.checkDeviceConnectivity(ctx)
+	for _, name := range []string{"web", "late"} {
+		if status := podStatus(t, p, name); status.Phase != corev1.PodFailed || status.Reason != "DeviceTimeout" {
+			t.Errorf("%s: status = %s (%s), want Failed (DeviceTimeout)", name, status.Phase, status.Reason)
+		}
+	}
+	if tracksDisconnect(p, "d1") {
+		t.Error("device is still tracked as disconnected after its timeout")
+	}
+}
+
+func TestDisconnectTimeoutFailsPodsWithoutReplacementDevice(t *testing.T) {
+	// d1 belongs to no fleet, so there is no device to move its pod to
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "vk-test", DisconnectAction: DisconnectActionReschedule})
+	ctx := context.Background()
+	trackPod(p, "web", "d1")
+
+	if err := server.SetDeviceSummary("d1", "Offline"); err != nil {
+		t.Fatalf("SetDeviceSummary: %v", err)
+	}
+	p.checkDeviceConnectivity(ctx)
+	expireDisconnect(t, p, "d1")
+	p.checkDeviceConnectivity(ctx)
+
+	if status := podStatus(t, p, "web"); status.Phase != corev1.PodFailed || status.Reason != "DeviceTimeout" {
+		t.Errorf("status = %s (%s), want Failed (DeviceTimeout)", status.Phase, status.Reason)
+	}
+}
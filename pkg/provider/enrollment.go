@@ -0,0 +1,148 @@
+package provider
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// Devices only join FlightCtl, and add capacity to the node, once their
+// enrollment request is approved. The provider counts the requests still
+// awaiting approval, so operators notice devices that have not joined yet:
+// the node carries the count in an annotation, the kubelet metrics export
+// it and, if enabled, an event is emitted for each new request. A fleet's
+// node only counts the requests whose labels the fleet selects; a device's
+// node counts none.
+
+// PendingEnrollmentsAnnotation is set on the node to the number of
+// enrollment requests awaiting approval.
+const PendingEnrollmentsAnnotation = "flightctl.io/pending-enrollments"
+
+// DefaultEnrollmentCheckInterval is how often enrollment requests are
+// listed.
+const DefaultEnrollmentCheckInterval = time.Minute
+
+// enrollmentLoop counts the pending enrollment requests every check
+// interval, until the provider is shut down.
+func (p *Provider) enrollmentLoop() {
+	ticker := time.NewTicker(p.enrollmentCheckInterval)
+	defer ticker.Stop()
+
+	p.checkEnrollments(p.reconcileCtx)
+	for {
+		select {
+		case <-p.reconcileCtx.Done():
+			return
+		case <-ticker.C:
+			p.checkEnrollments(p.reconcileCtx)
+		}
+	}
+}
+
+// checkEnrollments lists the pending enrollment requests, reports the new
+// ones and pushes the node status when they changed.
+func (p *Provider) checkEnrollments(ctx context.Context) {
+	pending, err := p.pendingEnrollments(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Warn("Checking pending enrollment requests for node %s: %v", p.nodeName, err)
+		}
+		return
+	}
+
+	p.mu.Lock()
+	previous, checked := p.enrollmentsPending, p.enrollmentsChecked
+	p.enrollmentsPending, p.enrollmentsChecked = pending, true
+	p.mu.Unlock()
+	if checked && slices.Equal(previous, pending) {
+		return
+	}
+
+	if len(pending) > 0 {
+		logger.Info("%d device enrollment requests awaiting approval for node %s: %v", len(pending), p.nodeName, pending)
+	}
+	if p.enrollmentEvents {
+		for _, name := range pending {
+			if _, seen := slices.BinarySearch(previous, name); !seen {
+				p.recordNodeEvent(corev1.EventTypeWarning, "EnrollmentPending",
+					"Device enrollment request %s is awaiting approval in FlightCtl", name)
+			}
+		}
+	}
+	p.pushNodeStatus()
+}
+
+// pendingEnrollments returns the sorted names of the pending enrollment
+// requests counted for the node.
+func (p *Provider) pendingEnrollments(ctx context.Context) ([]string, error) {
+	requests, err := p.flightctl.ListEnrollmentRequests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	selects := func(map[string]string) bool { return true }
+	if p.fleetID != "" {
+		fleet, err := p.flightctl.GetFleetResource(ctx, p.fleetID)
+		if err != nil {
+			return nil, err
+		}
+		// A fleet without a selector has no devices
+		selector := fleet.Spec.Selector
+		selects = func(labels map[string]string) bool { return selector != nil && selector.Matches(labels) }
+	}
+
+	pending := []string{}
+	for i := range requests {
+		if request := &requests[i]; request.Pending() && selects(request.Spec.Labels) {
+			pending = append(pending, request.Metadata.Name)
+		}
+	}
+	sort.Strings(pending)
+	return pending, nil
+}
+
+// applyEnrollments sets the pending enrollment count on the node, once
+// enrollment requests were checked.
+func (p *Provider) applyEnrollments(node *corev1.Node) {
+	p.mu.RLock()
+	pending, checked := len(p.enrollmentsPending), p.enrollmentsChecked
+	p.mu.RUnlock()
+	if !checked {
+		return
+	}
+	if node.Annotations == nil {
+		node.Annotations = make(map[string]string)
+	}
+	node.Annotations[PendingEnrollmentsAnnotation] = strconv.Itoa(pending)
+}
+
+// enrollmentMetricFamilies returns the pending enrollment count, once
+// enrollment requests were checked.
+func (p *Provider) enrollmentMetricFamilies() []*dto.MetricFamily {
+	p.mu.RLock()
+	pending, checked := len(p.enrollmentsPending), p.enrollmentsChecked
+	p.mu.RUnlock()
+	if !checked {
+		return nil
+	}
+	family := newMetricFamily("pending_enrollment_requests", "Device enrollment requests awaiting approval in FlightCtl", dto.MetricType_GAUGE)
+	addSample(family, float64(pending), time.Time{})
+	return []*dto.MetricFamily{family}
+}
+
+// recordNodeEvent emits an event for the node, if an event recorder is
+// configured.
+func (p *Provider) recordNodeEvent(eventType, reason, messageFmt string, args ...interface{}) {
+	if p.eventRecorder == nil {
+		return
+	}
+	node := &corev1.ObjectReference{Kind: "Node", Name: p.nodeName, UID: types.UID(p.nodeName)}
+	p.eventRecorder.Eventf(node, eventType, reason, messageFmt, args...)
+}
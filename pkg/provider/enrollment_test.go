@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func TestPendingEnrollments(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddEnrollmentRequest("d1", nil)
+	server.AddEnrollmentRequest("d2", nil)
+	server.AddEnrollmentRequest("d3", nil)
+	if err := server.ApproveEnrollmentRequest("d3", true); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProvider(t, server, Config{NodeName: "vk-test", EnrollmentCheckInterval: -1, EnrollmentEvents: true})
+	recorder := record.NewFakeRecorder(10)
+	p.SetEventRecorder(recorder)
+	ctx := context.Background()
+
+	pendingAnnotation := func() string {
+		t.Helper()
+		node, err := p.GetNode(ctx)
+		if err != nil {
+			t.Fatalf("GetNode: %v", err)
+		}
+		return node.Annotations[PendingEnrollmentsAnnotation]
+	}
+	if value := pendingAnnotation(); value != "" {
+		t.Errorf("annotation = %q before the first check, want none", value)
+	}
+
+	p.checkEnrollments(ctx)
+	if value := pendingAnnotation(); value != "2" {
+		t.Errorf("annotation = %q, want 2 pending requests", value)
+	}
+	if len(recorder.Events) != 2 {
+		t.Errorf("%d events, want one for each pending request", len(recorder.Events))
+	}
+	for range len(recorder.Events) {
+		if event := <-recorder.Events; !strings.Contains(event, "EnrollmentPending") {
+			t.Errorf("event = %q, want EnrollmentPending", event)
+		}
+	}
+
+	if err := server.ApproveEnrollmentRequest("d1", false); err != nil {
+		t.Fatal(err)
+	}
+	server.AddEnrollmentRequest("d4", nil)
+	p.checkEnrollments(ctx)
+	if value := pendingAnnotation(); value != "2" {
+		t.Errorf("annotation = %q after denying d1 and adding d4, want 2", value)
+	}
+	if len(recorder.Events) != 1 {
+		t.Fatalf("%d events, want one for the new request", len(recorder.Events))
+	}
+	if event := <-recorder.Events; !strings.Contains(event, "d4") {
+		t.Errorf("event = %q, want one for d4", event)
+	}
+
+	families := p.enrollmentMetricFamilies()
+	if len(families) != 1 || families[0].Metric[0].GetGauge().GetValue() != 2 {
+		t.Errorf("metric families = %v, want 2 pending requests", families)
+	}
+}
+
+func TestPendingEnrollmentsOfFleet(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddEnrollmentRequest("d1", map[string]string{"site": "galway"})
+	server.AddEnrollmentRequest("d2", map[string]string{"site": "cork"})
+	p := newTestProvider(t, server, Config{NodeName: "fleet-edge", FleetID: "edge"})
+	ctx := context.Background()
+
+	// A fleet without a selector has no devices to wait for
+	pending, err := p.pendingEnrollments(ctx)
+	if err != nil {
+		t.Fatalf("pendingEnrollments: %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("pending = %v, want none without a fleet selector", pending)
+	}
+
+	if err := server.SetFleetSelector("edge", map[string]string{"site": "galway"}); err != nil {
+		t.Fatal(err)
+	}
+	pending, err = p.pendingEnrollments(ctx)
+	if err != nil {
+		t.Fatalf("pendingEnrollments: %v", err)
+	}
+	if len(pending) != 1 || pending[0] != "d1" {
+		t.Errorf("pending = %v, want d1 selected by the fleet", pending)
+	}
+}
@@ -0,0 +1,128 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// SetFleet updates the fleet a fleet-pinned provider represents, with its
+// current devices, and pushes the resulting node status (aggregate capacity,
+// readiness) to the node controller if it changed. It is called by the node
+// discovery controller on every poll.
+func (p *Provider) SetFleet(fleet *models.Fleet, devices []*models.Device) {
+	if p.fleetID == "" || fleet == nil || fleet.ID != p.fleetID {
+		return
+	}
+
+	p.mu.Lock()
+	changed := !reflect.DeepEqual(p.fleet, fleet) || !reflect.DeepEqual(p.fleetDevices, devices)
+	p.fleet = fleet
+	p.fleetDevices = devices
+	p.mu.Unlock()
+
+	if changed {
+		p.pushNodeStatus()
+	}
+}
+
+// applyFleet describes the pinned fleet on the node: the summed capacity its
+// devices declare, the topology they share, and readiness while at least one
+// device is ready.
+func (p *Provider) applyFleet(node *corev1.Node) {
+	p.mu.RLock()
+	fleet, devices := p.fleet, p.fleetDevices
+	p.mu.RUnlock()
+
+	if len(validation.IsValidLabelValue(p.fleetID)) == 0 {
+		node.Labels[FleetIDLabel] = p.fleetID
+	}
+	if fleet == nil {
+		return
+	}
+
+	// Devices that failed to apply their spec do not count as ready, and
+	// the node is under pressure only if every ready device is
+	var capacity models.ResourceList
+	var readyDevices []*models.Device
+	memoryPressure, diskPressure := 0, 0
+	for _, device := range devices {
+		capacity = capacity.Add(device.Capacity)
+		if !device.IsReady() || device.Health.UpdateError != "" {
+			continue
+		}
+		readyDevices = append(readyDevices, device)
+		if device.Health.MemoryPressure {
+			memoryPressure++
+		}
+		if device.Health.DiskPressure {
+			diskPressure++
+		}
+	}
+	setCapacity(node, capacity, p.overcommit)
+	applySystemInfo(node, commonSystemInfo(devices))
+	p.applyTopology(node, devices)
+
+	ready := len(readyDevices)
+	if ready == 0 {
+		setNodeNotReady(node, "NoReadyDevices",
+			fmt.Sprintf("None of the %d device(s) in fleet %s is ready", len(devices), p.fleetID))
+		return
+	}
+	applyPressure(node, memoryPressure == ready, diskPressure == ready,
+		fmt.Sprintf("Every ready device in fleet %s reports", p.fleetID))
+	p.power.apply(node, readyDevices, fmt.Sprintf("Every ready device in fleet %s reports", p.fleetID))
+}
+
+// commonSystemInfo returns the platform fields shared by all devices, so a
+// fleet node only reports an architecture or OS every device has.
+func commonSystemInfo(devices []*models.Device) models.SystemInfo {
+	if len(devices) == 0 {
+		return models.SystemInfo{}
+	}
+	common := devices[0].SystemInfo
+	common.BootID = ""
+	common.IPAddresses = nil
+	for _, device := range devices[1:] {
+		info := device.SystemInfo
+		for _, field := range []struct{ common, other *string }{
+			{&common.OperatingSystem, &info.OperatingSystem},
+			{&common.Architecture, &info.Architecture},
+			{&common.OSImage, &info.OSImage},
+			{&common.KernelVersion, &info.KernelVersion},
+			{&common.AgentVersion, &info.AgentVersion},
+		} {
+			if *field.common != *field.other {
+				*field.common = ""
+			}
+		}
+	}
+	return common
+}
+
+// fetchFleetPlacement reads what placing a pod on a fleet-pinned node
+// needs: the device the pod names, which must be in the node's fleet, or
+// the fleet's devices matching the pod's device labels.
+func (p *Provider) fetchFleetPlacement(ctx context.Context, pod *corev1.Pod) (*devicePlacement, error) {
+	if fleetID := pod.Annotations[fleetIDAnnotation]; fleetID != "" && fleetID != p.fleetID {
+		return nil, fmt.Errorf("pod requests fleet %s but node %s represents fleet %s", fleetID, p.nodeName, p.fleetID)
+	}
+
+	if deviceID := pod.Annotations[deviceIDAnnotation]; deviceID != "" {
+		placement, err := p.fetchPinnedDevice(ctx, pod, deviceID, true)
+		if err != nil {
+			return nil, err
+		}
+		if placement.device.FleetID != p.fleetID {
+			return nil, fmt.Errorf("device %s is not in fleet %s", deviceID, p.fleetID)
+		}
+		return placement, nil
+	}
+
+	return p.fetchCandidates(ctx, pod, p.fleetID, deviceSelectorsFromNodeSelector(pod.Spec.NodeSelector))
+}
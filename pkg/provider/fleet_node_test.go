@@ -0,0 +1,109 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+func TestFleetNodeReportsAggregateCapacity(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("factory-a", nil)
+	for _, id := range []string{"d1", "d2"} {
+		server.AddDevice(id, "factory-a", map[string]string{
+			flightctl.CapacityCPULabel:    "2",
+			flightctl.CapacityMemoryLabel: "4Gi",
+		})
+	}
+
+	p := newTestProvider(t, server, Config{NodeName: "fleet-factory-a", FleetID: "factory-a"})
+	ctx := context.Background()
+	fleet, err := p.flightctl.GetFleet(ctx, "factory-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := p.flightctl.ListDevices(ctx, "factory-a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetFleet(fleet, devices)
+
+	node, err := p.GetNode(ctx)
+	if err != nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	if node.Labels[FleetIDLabel] != "factory-a" {
+		t.Errorf("fleet label = %q, want factory-a", node.Labels[FleetIDLabel])
+	}
+	if cpu := node.Status.Capacity.Cpu().String(); cpu != "4" {
+		t.Errorf("CPU capacity = %s, want 4", cpu)
+	}
+	if mem := node.Status.Capacity.Memory().String(); mem != "8Gi" {
+		t.Errorf("memory capacity = %s, want 8Gi", mem)
+	}
+
+	// No ready device makes the node NotReady
+	for _, device := range devices {
+		device.Status.Phase = models.DeviceNotReady
+	}
+	p.SetFleet(fleet, devices)
+	node, _ = p.GetNode(ctx)
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady && cond.Status != corev1.ConditionFalse {
+			t.Errorf("Ready = %s with no ready devices, want False", cond.Status)
+		}
+	}
+}
+
+func TestFleetNodeDeploysWithinFleet(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("factory-a", nil)
+	server.AddFleet("factory-b", nil)
+	server.AddDevice("a1", "factory-a", nil)
+	server.AddDevice("b1", "factory-b", nil)
+
+	p := newTestProvider(t, server, Config{NodeName: "fleet-factory-a", FleetID: "factory-a"})
+	ctx := context.Background()
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}}},
+	}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if apps := fetchDevice(t, server, "a1").Spec.Applications; len(apps) != 1 {
+		t.Errorf("applications on a1 = %+v, want one", apps)
+	}
+
+	other := pod.DeepCopy()
+	other.Name = "other"
+	other.Annotations = map[string]string{"flightctl.io/device-id": "b1"}
+	if err := p.CreatePod(ctx, other); err == nil {
+		t.Error("expected an error for a device outside the fleet")
+	}
+}
+
+func TestFleetNodeReportsCommonArchitecture(t *testing.T) {
+	devices := []*models.Device{
+		{ID: "d1", SystemInfo: models.SystemInfo{OperatingSystem: "linux", Architecture: "arm64", KernelVersion: "6.1"}},
+		{ID: "d2", SystemInfo: models.SystemInfo{OperatingSystem: "linux", Architecture: "arm64", KernelVersion: "6.6"}},
+	}
+	info := commonSystemInfo(devices)
+	if info.Architecture != "arm64" || info.OperatingSystem != "linux" || info.KernelVersion != "" {
+		t.Errorf("common system info = %+v, want linux/arm64 without a kernel", info)
+	}
+
+	devices = append(devices, &models.Device{ID: "d3", SystemInfo: models.SystemInfo{OperatingSystem: "linux", Architecture: "amd64"}})
+	if info := commonSystemInfo(devices); info.Architecture != "" {
+		t.Errorf("mixed fleet architecture = %q, want none", info.Architecture)
+	}
+}
@@ -0,0 +1,175 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// Spread pods targeting a whole fleet with a batch rollout policy are
+// deployed through the fleet's device template rather than written to each
+// device, so FlightCtl rolls them out batch by batch, holding back the next
+// batch until the success threshold of the last one is met. The pod's
+// FleetRollout condition follows the batches.
+
+// errAwaitingRollout is the status of a fleet-rolled-out pod on a device
+// its fleet's rollout did not reach yet.
+var errAwaitingRollout = errors.New("awaiting rollout")
+
+// createFleetRolloutPod deploys a spread pod to the template of a fleet with
+// a batch rollout policy, covering all the fleet's devices. The caller
+// tracks the returned mapping.
+func (p *Provider) createFleetRolloutPod(ctx context.Context, pod *corev1.Pod, fleet *flightctl.FlightctlFleet, devices []*models.Device) (*models.PodDeviceMapping, error) {
+	fleetID := fleet.Metadata.Name
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no devices in fleet %s", fleetID)
+	}
+	deviceIDs := make([]string, 0, len(devices))
+	for _, device := range devices {
+		deviceIDs = append(deviceIDs, device.ID)
+	}
+	quorum, err := spreadQuorum(pod.Annotations[SpreadQuorumAnnotation], len(deviceIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	logger.FromContext(ctx).Info("Rolling out pod %s/%s to the %d device(s) of fleet %s by its rollout policy (quorum %d)",
+		pod.Namespace, pod.Name, len(deviceIDs), fleetID, quorum)
+	if err := p.podManager.DeployPodToFleet(ctx, pod, fleetID); err != nil {
+		return nil, fmt.Errorf("rolling out to fleet %s: %w", fleetID, err)
+	}
+
+	mapping := models.NewPodDeviceMapping(pod.Namespace, pod.Name, pod.UID, "")
+	mapping.SpreadDevices = deviceIDs
+	mapping.SpreadQuorum = quorum
+	mapping.RolloutFleet = fleetID
+	message := fmt.Sprintf("Pod rolled out to the %d device(s) of FlightCtl fleet %s by its rollout policy", len(deviceIDs), fleetID)
+	mapping.Status = &corev1.PodStatus{
+		Phase: corev1.PodPending,
+		Conditions: []corev1.PodCondition{
+			{
+				Type:               corev1.PodScheduled,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.Now(),
+				Reason:             "Scheduled",
+				Message:            message,
+			},
+			{
+				Type:               flightctl.PodFleetRollout,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.Now(),
+				Reason:             "Waiting",
+				Message:            fmt.Sprintf("Waiting for fleet %s to start the rollout", fleetID),
+			},
+		},
+	}
+	return mapping, nil
+}
+
+// refreshFleetRollouts reads the rollout progress and devices of the fleets
+// of fleet-rolled-out pods. The pods cover the fleet's current devices, and
+// get an event when the rollout moves to another batch.
+func (p *Provider) refreshFleetRollouts(ctx context.Context) {
+	p.mu.Lock()
+	var fleetIDs []string
+	for _, mapping := range p.podMappings {
+		if mapping.RolloutFleet != "" && !mapping.IsCompleted() && !slices.Contains(fleetIDs, mapping.RolloutFleet) {
+			fleetIDs = append(fleetIDs, mapping.RolloutFleet)
+		}
+	}
+	for fleetID := range p.fleetRollouts {
+		if !slices.Contains(fleetIDs, fleetID) {
+			delete(p.fleetRollouts, fleetID)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, fleetID := range fleetIDs {
+		fleet, err := p.flightctl.GetFleetResource(ctx, fleetID)
+		if err != nil {
+			logger.Warn("Failed to get the rollout of fleet %s: %v", fleetID, err)
+			continue
+		}
+		devices, err := p.flightctl.ListDevices(ctx, fleetID, nil)
+		if err != nil {
+			logger.Warn("Failed to list the devices of fleet %s: %v", fleetID, err)
+			continue
+		}
+		deviceIDs := make([]string, 0, len(devices))
+		for _, device := range devices {
+			deviceIDs = append(deviceIDs, device.ID)
+		}
+		rollout := fleet.RolloutState()
+
+		p.mu.Lock()
+		previous, seen := p.fleetRollouts[fleetID]
+		p.fleetRollouts[fleetID] = rollout
+		var mappings []*models.PodDeviceMapping
+		for _, mapping := range p.podMappings {
+			if mapping.RolloutFleet != fleetID || mapping.IsCompleted() {
+				continue
+			}
+			mappings = append(mappings, mapping)
+			if len(deviceIDs) == 0 {
+				continue
+			}
+			mapping.SpreadDevices = deviceIDs
+			if mapping.Pod != nil {
+				if quorum, err := spreadQuorum(mapping.Pod.Annotations[SpreadQuorumAnnotation], len(deviceIDs)); err == nil {
+					mapping.SpreadQuorum = quorum
+				}
+			}
+		}
+		p.mu.Unlock()
+
+		if seen && rollout.InProgress && (previous.Batch != rollout.Batch || previous.TemplateVersion != rollout.TemplateVersion) {
+			for _, mapping := range mappings {
+				p.recordPodEvent(mapping, corev1.EventTypeNormal, "FleetRolloutBatch", "Fleet %s is rolling out the %s", fleetID, rollout.BatchName())
+			}
+		}
+	}
+}
+
+// withFleetRolloutLocked sets the FleetRollout condition of a fleet-rolled-out
+// pod's status from its fleet's rollout: true while the rollout is in
+// progress, with FlightCtl's reason and the batch, false once it is done.
+// Caller must hold p.mu.
+func (p *Provider) withFleetRolloutLocked(mapping *models.PodDeviceMapping, status *corev1.PodStatus) *corev1.PodStatus {
+	// Carry the condition over from the cached status, keeping its
+	// transition time, and until the rollout is read
+	isRollout := func(cond corev1.PodCondition) bool { return cond.Type == flightctl.PodFleetRollout }
+	if mapping.Status != nil && !slices.ContainsFunc(status.Conditions, isRollout) {
+		if i := slices.IndexFunc(mapping.Status.Conditions, isRollout); i >= 0 {
+			status.Conditions = append(status.Conditions, mapping.Status.Conditions[i])
+		}
+	}
+	rollout, ok := p.fleetRollouts[mapping.RolloutFleet]
+	if !ok {
+		return status
+	}
+	if !rollout.InProgress {
+		return withPodCondition(status, flightctl.PodFleetRollout, corev1.ConditionFalse, "RolloutComplete",
+			fmt.Sprintf("Fleet %s rolled out template version %s", mapping.RolloutFleet, rollout.TemplateVersion))
+	}
+
+	message := fmt.Sprintf("Fleet %s is rolling out template version %s: %s", mapping.RolloutFleet, rollout.TemplateVersion, rollout.BatchName())
+	if last := rollout.LastBatch; last != nil {
+		message += fmt.Sprintf("; last batch %d/%d devices succeeded (%d%%)", last.Successful, last.Total, last.SuccessPercentage)
+	}
+	if rollout.Message != "" {
+		message += "; " + rollout.Message
+	}
+	reason := rollout.Reason
+	if reason == "" {
+		reason = "Active"
+	}
+	return withPodCondition(status, flightctl.PodFleetRollout, corev1.ConditionTrue, reason, message)
+}
@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func rolloutCondition(t *testing.T, p *Provider) corev1.PodCondition {
+	t.Helper()
+	status, err := p.GetPodStatus(context.Background(), "default", "agent")
+	if err != nil {
+		t.Fatalf("GetPodStatus: %v", err)
+	}
+	for _, cond := range status.Conditions {
+		if cond.Type == flightctl.PodFleetRollout {
+			return cond
+		}
+	}
+	t.Fatalf("no %s condition in %+v", flightctl.PodFleetRollout, status.Conditions)
+	return corev1.PodCondition{}
+}
+
+func TestSpreadPodFleetRollout(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	for _, id := range []string{"d1", "d2", "d3"} {
+		server.AddDevice(id, "edge", nil)
+	}
+	if err := server.SetRolloutPolicy("edge", &flightctl.FlightctlRolloutPolicy{
+		DeviceSelection: &flightctl.FlightctlRolloutDeviceSelection{
+			Strategy: flightctl.BatchSequenceStrategy,
+			Sequence: []flightctl.FlightctlRolloutBatch{{}, {}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProvider(t, server, Config{NodeName: "fleet-edge", FleetID: "edge"})
+	ctx := context.Background()
+
+	pod := spreadPod("")
+	pod.Annotations[fleetIDAnnotation] = "edge"
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	fleet, _ := server.Fleet("edge")
+	if fleet.Spec.Template == nil || len(fleet.Spec.Template.Spec.Applications) != 1 {
+		t.Fatalf("fleet template = %+v, want the pod's application", fleet.Spec.Template)
+	}
+	for _, id := range []string{"d1", "d2", "d3"} {
+		if apps := fetchDevice(t, server, id).Spec.Applications; len(apps) != 0 {
+			t.Errorf("applications on %s before the rollout = %+v, want none", id, apps)
+		}
+	}
+
+	if err := server.RolloutBatch("edge", "d1"); err != nil {
+		t.Fatal(err)
+	}
+	p.reconcilePodStatus(ctx)
+	if cond := rolloutCondition(t, p); cond.Status != corev1.ConditionTrue || cond.Reason != "Active" {
+		t.Errorf("rollout condition = %s/%s, want True/Active (%s)", cond.Status, cond.Reason, cond.Message)
+	}
+	status, _ := p.GetPodStatus(ctx, "default", "agent")
+	if isPodReady(status) {
+		t.Errorf("pod ready with one of three devices rolled out (%s)", status.Message)
+	}
+
+	if err := server.RolloutBatch("edge", "d2", "d3"); err != nil {
+		t.Fatal(err)
+	}
+	p.reconcilePodStatus(ctx)
+	if cond := rolloutCondition(t, p); cond.Status != corev1.ConditionFalse || cond.Reason != "RolloutComplete" {
+		t.Errorf("rollout condition = %s/%s, want False/RolloutComplete (%s)", cond.Status, cond.Reason, cond.Message)
+	}
+	status, _ = p.GetPodStatus(ctx, "default", "agent")
+	if status.Phase != corev1.PodRunning || !isPodReady(status) {
+		t.Errorf("status = %s ready=%v, want Running and Ready (%s)", status.Phase, isPodReady(status), status.Message)
+	}
+
+	if err := p.DeletePod(ctx, pod); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+	fleet, _ = server.Fleet("edge")
+	if fleet.Spec.Template != nil && len(fleet.Spec.Template.Spec.Applications) != 0 {
+		t.Errorf("fleet template after delete = %+v, want no applications", fleet.Spec.Template.Spec.Applications)
+	}
+}
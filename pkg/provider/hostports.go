@@ -0,0 +1,53 @@
+package provider
+
+import (
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// hostPortConflictLocked returns an error if another pod placed on the device
+// binds one of the pod's host ports. Completed pods, whose containers have
+// exited, no longer hold their ports. Caller must hold p.mu.
+func (p *Provider) hostPortConflictLocked(pod *corev1.Pod, deviceID string) error {
+	wanted := models.PodHostPorts(pod)
+	if len(wanted) == 0 {
+		return nil
+	}
+	podKey := fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
+
+	for _, mapping := range p.podMappings {
+		if mapping.PodKey == podKey || mapping.IsCompleted() || !slices.Contains(mapping.Devices(), deviceID) {
+			continue
+		}
+		for _, used := range models.PodHostPorts(mapping.Pod) {
+			for _, port := range wanted {
+				if port == used {
+					return fmt.Errorf("host port %s is already used by pod %s on device %s", port, mapping.PodKey, deviceID)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// withoutHostPortConflictsLocked returns the devices on which none of the
+// pod's host ports is in use. Caller must hold p.mu.
+func (p *Provider) withoutHostPortConflictsLocked(pod *corev1.Pod, devices []*models.Device) ([]*models.Device, error) {
+	var free []*models.Device
+	var lastErr error
+	for _, device := range devices {
+		if err := p.hostPortConflictLocked(pod, device.ID); err != nil {
+			lastErr = err
+			continue
+		}
+		free = append(free, device)
+	}
+	if len(free) == 0 && lastErr != nil {
+		return nil, fmt.Errorf("host ports in use on all %d devices: %w", len(devices), lastErr)
+	}
+	return free, nil
+}
@@ -0,0 +1,82 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func hostPortPod(name string, containerPort, hostPort int32) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  "app",
+				Image: "app:1",
+				Ports: []corev1.ContainerPort{{ContainerPort: containerPort, HostPort: hostPort}},
+			}},
+		},
+	}
+}
+
+func TestCreatePodRejectsHostPortConflict(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1"})
+	ctx := context.Background()
+
+	if err := p.CreatePod(ctx, hostPortPod("web", 80, 8080)); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	err := p.CreatePod(ctx, hostPortPod("api", 8080, 0))
+	if err == nil || !strings.Contains(err.Error(), "8080/TCP") || !strings.Contains(err.Error(), "default/web") {
+		t.Fatalf("CreatePod error = %v, want a host port conflict with default/web", err)
+	}
+	hostNetwork := hostPortPod("proxy", 8080, 0)
+	hostNetwork.Spec.HostNetwork = true
+	if err := p.CreatePod(ctx, hostNetwork); err == nil {
+		t.Error("CreatePod of a host network pod binding a published port succeeded")
+	}
+	if err := p.CreatePod(ctx, hostPortPod("api", 8080, 9090)); err != nil {
+		t.Errorf("CreatePod on a free host port: %v", err)
+	}
+	if apps := fetchDevice(t, server, "d1").Spec.Applications; len(apps) != 2 {
+		t.Errorf("applications = %+v, want web and api", apps)
+	}
+}
+
+func TestSelectDeviceSkipsHostPortConflicts(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("d1", "edge", nil)
+	server.AddDevice("d2", "edge", nil)
+	p := newTestProvider(t, server, Config{NodeName: "fleet-edge", FleetID: "edge"})
+	ctx := context.Background()
+
+	first := hostPortPod("web-1", 80, 8080)
+	first.Annotations = map[string]string{deviceIDAnnotation: "d1"}
+	if err := p.CreatePod(ctx, first); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if err := p.CreatePod(ctx, hostPortPod("web-2", 80, 8080)); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	p.mu.RLock()
+	deviceID := p.podMappings["default/web-2"].DeviceID
+	p.mu.RUnlock()
+	if deviceID != "d2" {
+		t.Errorf("web-2 placed on %s, want d2 where port 8080 is free", deviceID)
+	}
+
+	if err := p.CreatePod(ctx, hostPortPod("web-3", 80, 8080)); err == nil {
+		t.Error("CreatePod succeeded with port 8080 in use on every device")
+	}
+}
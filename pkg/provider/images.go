@@ -0,0 +1,161 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/registry"
+)
+
+// DigestResolver resolves an image reference to the digest its registry
+// serves it under.
+type DigestResolver interface {
+	Resolve(ctx context.Context, image string) (string, error)
+}
+
+// imagePins remembers the digests the images of each pod were pinned to
+// when it was first deployed, so that redeploying it, to the same or another
+// device, deploys the same images even if their tags were pushed again.
+type imagePins struct {
+	resolver DigestResolver
+
+	mu   sync.Mutex
+	pods map[types.UID]map[string]string // Image reference to digest
+}
+
+func newImagePins(resolver DigestResolver) *imagePins {
+	return &imagePins{resolver: resolver, pods: make(map[types.UID]map[string]string)}
+}
+
+// pin returns the pod with its container images pinned to their digests.
+// Images that cannot be resolved are deployed by tag, with a warning.
+// Pods deploying repository content do not use their container images.
+func (pins *imagePins) pin(ctx context.Context, pod *corev1.Pod) *corev1.Pod {
+	if flightctl.IsRepoPod(pod) {
+		return pod
+	}
+	var pinned *corev1.Pod
+	for i, container := range pod.Spec.Containers {
+		digest, ok := pins.digest(ctx, pod, container.Image)
+		if !ok || strings.Contains(container.Image, "@") {
+			continue
+		}
+		if pinned == nil {
+			pinned = pod.DeepCopy()
+		}
+		pinned.Spec.Containers[i].Image = container.Image + "@" + digest
+	}
+	if pinned == nil {
+		return pod
+	}
+	return pinned
+}
+
+// digest returns the digest a pod's image is pinned to, resolving it on
+// first use.
+func (pins *imagePins) digest(ctx context.Context, pod *corev1.Pod, image string) (string, bool) {
+	pins.mu.Lock()
+	digest, ok := pins.pods[pod.UID][image]
+	pins.mu.Unlock()
+	if ok {
+		return digest, true
+	}
+
+	digest, err := pins.resolver.Resolve(ctx, image)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Deploying pod %s/%s with image %s unpinned: %v", pod.Namespace, pod.Name, image, err)
+		return "", false
+	}
+	pins.mu.Lock()
+	defer pins.mu.Unlock()
+	if pins.pods[pod.UID] == nil {
+		pins.pods[pod.UID] = make(map[string]string)
+	}
+	// A concurrent deployment of the pod may have pinned it first
+	if pinned, ok := pins.pods[pod.UID][image]; ok {
+		return pinned, true
+	}
+	pins.pods[pod.UID][image] = digest
+	return digest, true
+}
+
+// setImageIDs sets the image ID of the containers whose image is pinned, in
+// the repository@digest form Kubernetes reports.
+func (pins *imagePins) setImageIDs(pod *corev1.Pod, status *corev1.PodStatus) {
+	if status == nil {
+		return
+	}
+	pins.mu.Lock()
+	digests := pins.pods[pod.UID]
+	pins.mu.Unlock()
+	if len(digests) == 0 {
+		return
+	}
+	images := make(map[string]string, len(pod.Spec.Containers))
+	for _, container := range pod.Spec.Containers {
+		images[container.Name] = container.Image
+	}
+	for i := range status.ContainerStatuses {
+		cs := &status.ContainerStatuses[i]
+		image := images[cs.Name]
+		digest, ok := digests[image]
+		if !ok {
+			continue
+		}
+		if ref, err := registry.ParseReference(image); err == nil {
+			cs.ImageID = ref.Name() + "@" + digest
+		}
+	}
+}
+
+// forget drops the digests of a pod that is no longer tracked.
+func (pins *imagePins) forget(uid types.UID) {
+	if pins == nil {
+		return
+	}
+	pins.mu.Lock()
+	defer pins.mu.Unlock()
+	delete(pins.pods, uid)
+}
+
+// imagePinningManager pins the images of pods before they are deployed,
+// whichever path deploys them, and reports the pinned digests in their
+// container statuses.
+type imagePinningManager struct {
+	flightctl.WorkloadManager
+	pins *imagePins
+}
+
+func (m imagePinningManager) DeployPod(ctx context.Context, pod *corev1.Pod, deviceID string) error {
+	return m.WorkloadManager.DeployPod(ctx, m.pins.pin(ctx, pod), deviceID)
+}
+
+func (m imagePinningManager) UpdatePod(ctx context.Context, pod *corev1.Pod, deviceID string) error {
+	return m.WorkloadManager.UpdatePod(ctx, m.pins.pin(ctx, pod), deviceID)
+}
+
+func (m imagePinningManager) DeployPodToFleet(ctx context.Context, pod *corev1.Pod, fleetID string) error {
+	return m.WorkloadManager.DeployPodToFleet(ctx, m.pins.pin(ctx, pod), fleetID)
+}
+
+func (m imagePinningManager) GetPodStatus(ctx context.Context, pod *corev1.Pod, deviceID string) (*corev1.PodStatus, error) {
+	status, err := m.WorkloadManager.GetPodStatus(ctx, pod, deviceID)
+	m.pins.setImageIDs(pod, status)
+	return status, err
+}
+
+func (m imagePinningManager) GetPodStatuses(ctx context.Context, pods []*corev1.Pod, deviceID string) ([]flightctl.PodStatusResult, error) {
+	results, err := m.WorkloadManager.GetPodStatuses(ctx, pods, deviceID)
+	for i := range results {
+		if i < len(pods) {
+			m.pins.setImageIDs(pods[i], results[i].Status)
+		}
+	}
+	return results, err
+}
@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+// countingResolver returns a new digest for every resolution, as if the
+// tag were pushed again each time.
+type countingResolver struct {
+	mu    sync.Mutex
+	calls int
+	fail  bool
+}
+
+func (r *countingResolver) Resolve(_ context.Context, image string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail {
+		return "", errors.New("registry unreachable")
+	}
+	r.calls++
+	return fmt.Sprintf("sha256:v%d", r.calls), nil
+}
+
+func TestImagesArePinnedToDigests(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	resolver := &countingResolver{}
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1", ImageDigestResolver: resolver})
+	ctx := context.Background()
+
+	pod := cpuPod("web", "100m")
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if content := deployedContent(t, server, "d1"); !strings.Contains(content, "image: app:1@sha256:v1\n") {
+		t.Errorf("content does not pin the image:\n%s", content)
+	}
+
+	// Redeploying keeps the digest the pod was first deployed with
+	updated := pod.DeepCopy()
+	updated.Labels = map[string]string{"version": "2"}
+	updated.Spec.Containers[0].Env = append(updated.Spec.Containers[0].Env, corev1.EnvVar{Name: "MODE", Value: "edge"})
+	if err := p.UpdatePod(ctx, updated); err != nil {
+		t.Fatalf("UpdatePod: %v", err)
+	}
+	content := deployedContent(t, server, "d1")
+	if !strings.Contains(content, "MODE=edge") || !strings.Contains(content, "@sha256:v1\n") || resolver.calls != 1 {
+		t.Errorf("redeployed with %d resolutions:\n%s", resolver.calls, content)
+	}
+
+	status, err := p.podManager.GetPodStatus(ctx, updated, "d1")
+	if err != nil {
+		t.Fatalf("GetPodStatus: %v", err)
+	}
+	if len(status.ContainerStatuses) != 1 || status.ContainerStatuses[0].ImageID != "docker.io/library/app@sha256:v1" {
+		t.Errorf("container statuses = %+v, want image ID docker.io/library/app@sha256:v1", status.ContainerStatuses)
+	}
+
+	// Images that cannot be resolved are deployed by tag
+	resolver.fail = true
+	if err := p.CreatePod(ctx, cpuPod("other", "100m")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	for _, app := range fetchDevice(t, server, "d1").Spec.Applications {
+		if app.Name == "default-other" && !strings.Contains(app.Inline[0].Content, "image: app:1\n") {
+			t.Errorf("content of an unresolved image:\n%s", app.Inline[0].Content)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	"go.opentelemetry.io/otel/attribute"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/tracing"
+)
+
+// Container logs are read with podman logs in a console session on the
+// pod's device. With a log cache, the last bytes of the logs of each running
+// container are also fetched while its device is reconciled, at most every
+// logCacheRefreshInterval, and served, marked as cached, when the device
+// cannot be reached.
+
+// logCacheRefreshInterval is how often the cached logs of a container are
+// refreshed.
+const logCacheRefreshInterval = time.Minute
+
+// logCache keeps the last bytes of the logs of the containers of tracked
+// pods, keyed by pod key and container name.
+type logCache struct {
+	size int // Bytes kept per container
+
+	mu      sync.Mutex
+	entries map[string]map[string]*cachedLogs
+}
+
+// cachedLogs are the logs of a container as last fetched from its device.
+type cachedLogs struct {
+	data      []byte
+	deviceID  string
+	fetchedAt time.Time
+}
+
+func newLogCache(size int) *logCache {
+	return &logCache{size: size, entries: make(map[string]map[string]*cachedLogs)}
+}
+
+// due returns the containers of a pod whose cached logs are to be refreshed.
+func (c *logCache) due(podKey string, containers []string, now time.Time) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var due []string
+	for _, container := range containers {
+		if cached := c.entries[podKey][container]; cached == nil || now.Sub(cached.fetchedAt) >= logCacheRefreshInterval {
+			due = append(due, container)
+		}
+	}
+	return due
+}
+
+func (c *logCache) store(podKey, container string, logs *cachedLogs) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries[podKey] == nil {
+		c.entries[podKey] = make(map[string]*cachedLogs)
+	}
+	c.entries[podKey][container] = logs
+}
+
+func (c *logCache) get(podKey, container string) (*cachedLogs, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.entries[podKey][container]
+	return cached, ok
+}
+
+// forget drops the cached logs of a pod that is no longer tracked.
+func (c *logCache) forget(podKey string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, podKey)
+}
+
+// refreshLogCache fetches the logs of the running containers of the pods on
+// a device whose cached logs are due. Pods spread over several devices are
+// cached from their first device, whose logs they serve.
+func (p *Provider) refreshLogCache(ctx context.Context, deviceID string, mappings []*models.PodDeviceMapping) {
+	if p.logCache == nil {
+		return
+	}
+	now := time.Now()
+	for _, mapping := range mappings {
+		p.mu.RLock()
+		podKey, pod, status := mapping.PodKey, mapping.Pod, mapping.Status
+		first := mapping.Devices()[0]
+		p.mu.RUnlock()
+		if pod == nil || status == nil || first != deviceID {
+			continue
+		}
+		var running []string
+		for _, cs := range status.ContainerStatuses {
+			if cs.State.Running != nil {
+				running = append(running, cs.Name)
+			}
+		}
+		for _, container := range p.logCache.due(podKey, running, now) {
+			if ctx.Err() != nil {
+				return
+			}
+			var stdout bytes.Buffer
+			command := flightctl.ContainerLogsCommand(p.appNaming.ApplicationName(pod), container, flightctl.LogOptions{Timestamps: true, LastBytes: p.logCache.size})
+			if err := p.flightctl.StreamConsole(ctx, deviceID, command, flightctl.ConsoleStreams{Stdout: &stdout}); err != nil {
+				logger.FromContext(ctx).Debug("Caching logs of container %s of pod %s failed: %v", container, podKey, err)
+				continue
+			}
+			data := stdout.Bytes()
+			if len(data) > p.logCache.size {
+				data = data[len(data)-p.logCache.size:]
+			}
+			p.logCache.store(podKey, container, &cachedLogs{data: data, deviceID: deviceID, fetchedAt: now})
+		}
+	}
+}
+
+// GetContainerLogs returns the logs of a container, read with podman logs
+// in a console session on its device. If the device cannot be reached, the
+// cached logs of the container are returned instead, if there are any.
+func (p *Provider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts api.ContainerLogOpts) (io.ReadCloser, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Provider.GetContainerLogs")
+	defer span.End()
+	span.SetAttributes(attribute.String("pod", namespace+"/"+podName), attribute.String("container", containerName))
+	podKey := namespace + "/" + podName
+
+	if opts.Previous {
+		return nil, fmt.Errorf("logs of previous instances of container %s of pod %s are not kept on devices", containerName, podKey)
+	}
+	mapping, deviceID, err := p.consoleTarget(namespace, podName)
+	if errors.Is(err, flightctl.ErrDeviceOffline) {
+		if cached, ok := p.cachedContainerLogs(podKey, containerName, opts, "device is offline"); ok {
+			return cached, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !slices.ContainsFunc(mapping.Pod.Spec.Containers, func(c corev1.Container) bool { return c.Name == containerName }) {
+		return nil, fmt.Errorf("container %s not found in pod %s", containerName, podKey)
+	}
+
+	logOpts := flightctl.LogOptions{Tail: opts.Tail, Timestamps: opts.Timestamps, Follow: opts.Follow, Since: opts.SinceTime}
+	if opts.SinceSeconds > 0 {
+		logOpts.Since = time.Now().Add(-time.Duration(opts.SinceSeconds) * time.Second)
+	}
+	command := flightctl.ContainerLogsCommand(p.appNaming.ApplicationName(mapping.Pod), containerName, logOpts)
+
+	// The logs are returned once the device starts sending them, so that a
+	// device that cannot be reached is served from the cache
+	reader, writer := io.Pipe()
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	output := &startWriter{Writer: writer, started: started}
+	go func() {
+		err := p.flightctl.StreamConsole(ctx, deviceID, command, flightctl.ConsoleStreams{Stdout: output, Stderr: output})
+		done <- err
+		writer.CloseWithError(err)
+	}()
+	select {
+	case <-started:
+	case err := <-done:
+		if err != nil && ctx.Err() == nil {
+			reader.Close()
+			if cached, ok := p.cachedContainerLogs(podKey, containerName, opts, err.Error()); ok {
+				return cached, nil
+			}
+			return nil, fmt.Errorf("reading logs of container %s of pod %s: %w", containerName, podKey, err)
+		}
+	case <-ctx.Done():
+		reader.Close()
+		return nil, ctx.Err()
+	}
+
+	logger.FromContext(ctx).With("device", deviceID).Debug("Streaming logs of container %s of pod %s from device %s", containerName, podKey, deviceID)
+	if opts.LimitBytes > 0 {
+		return readCloser{Reader: io.LimitReader(reader, int64(opts.LimitBytes)), Closer: reader}, nil
+	}
+	return reader, nil
+}
+
+// cachedContainerLogs returns the cached logs of a container, selected by
+// the tail and size options and preceded by a line saying they are cached
+// and why, or false if there are none.
+func (p *Provider) cachedContainerLogs(podKey, containerName string, opts api.ContainerLogOpts, reason string) (io.ReadCloser, bool) {
+	if p.logCache == nil {
+		return nil, false
+	}
+	cached, ok := p.logCache.get(podKey, containerName)
+	if !ok {
+		return nil, false
+	}
+	data := cached.data
+	if opts.Tail > 0 {
+		lines := strings.SplitAfter(string(data), "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		if len(lines) > opts.Tail {
+			data = []byte(strings.Join(lines[len(lines)-opts.Tail:], ""))
+		}
+	}
+	header := fmt.Sprintf("### Cached logs of container %s fetched from device %s at %s (%s)\n",
+		containerName, cached.deviceID, cached.fetchedAt.UTC().Format(time.RFC3339), reason)
+	var logs io.Reader = io.MultiReader(strings.NewReader(header), bytes.NewReader(data))
+	if opts.LimitBytes > 0 {
+		logs = io.LimitReader(logs, int64(opts.LimitBytes))
+	}
+	return io.NopCloser(logs), true
+}
+
+// startWriter closes started on the first write.
+type startWriter struct {
+	io.Writer
+	started chan struct{}
+	once    sync.Once
+}
+
+func (w *startWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.started) })
+	return w.Writer.Write(p)
+}
+
+// readCloser closes Closer when closed, rather than Reader.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+func readLogs(t *testing.T, p *Provider, container string, opts api.ContainerLogOpts) string {
+	t.Helper()
+	logs, err := p.GetContainerLogs(context.Background(), "default", "web", container, opts)
+	if err != nil {
+		t.Fatalf("GetContainerLogs: %v", err)
+	}
+	defer logs.Close()
+	data, err := io.ReadAll(logs)
+	if err != nil {
+		t.Fatalf("reading logs: %v", err)
+	}
+	return string(data)
+}
+
+func TestContainerLogsFromDeviceAndCache(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+
+	// The device side prints three log lines, or fails while offline
+	var mu sync.Mutex
+	var command string
+	offline := false
+	setOffline := func(v bool) { mu.Lock(); offline = v; mu.Unlock() }
+	lastCommand := func() string { mu.Lock(); defer mu.Unlock(); return command }
+	server.HandleConsole(func(device string, metadata flightctl.ConsoleMetadata, stdin io.Reader, stdout, stderr io.Writer, resize <-chan flightctl.TerminalSize) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if offline {
+			return errors.New("device unreachable")
+		}
+		command = strings.Join(metadata.Command.Args, " ")
+		_, err := io.WriteString(stdout, "one\ntwo\nthree\n")
+		return err
+	})
+
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1", LogCacheSize: 1024})
+	ctx := context.Background()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}}},
+	}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+
+	if got := readLogs(t, p, "nginx", api.ContainerLogOpts{Tail: 2}); got != "one\ntwo\nthree\n" {
+		t.Errorf("logs = %q, want the device's output", got)
+	}
+	if command := lastCommand(); !strings.Contains(command, "podman 'logs' '--tail' '2'") {
+		t.Errorf("console command %q does not read the last 2 lines with podman logs", command)
+	}
+	if _, err := p.GetContainerLogs(ctx, "default", "web", "missing", api.ContainerLogOpts{}); err == nil {
+		t.Error("expected an error reading the logs of an unknown container")
+	}
+
+	// Without cached logs, a failing device is an error
+	setOffline(true)
+	if _, err := p.GetContainerLogs(ctx, "default", "web", "nginx", api.ContainerLogOpts{}); err == nil {
+		t.Error("expected an error reading logs from an unreachable device without a cache")
+	}
+
+	// Reconciling a running container caches its logs
+	setOffline(false)
+	p.mu.Lock()
+	mapping := p.podMappings["default/web"]
+	mapping.Status = &corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+		Name: "nginx", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+	}}}
+	p.mu.Unlock()
+	p.refreshLogCache(ctx, "device-1", []*models.PodDeviceMapping{mapping})
+	if command := lastCommand(); !strings.Contains(command, "tail -c 1024") {
+		t.Errorf("console command %q does not keep the last 1024 bytes", command)
+	}
+
+	setOffline(true)
+	got := readLogs(t, p, "nginx", api.ContainerLogOpts{Tail: 1})
+	header, logs, _ := strings.Cut(got, "\n")
+	if !strings.HasPrefix(header, "### Cached logs of container nginx fetched from device device-1") || !strings.Contains(header, "device unreachable") {
+		t.Errorf("cached logs header = %q", header)
+	}
+	if logs != "three\n" {
+		t.Errorf("cached logs = %q, want the last line", logs)
+	}
+
+	// A disconnected device is served from the cache without a session
+	p.mu.Lock()
+	p.disconnects["device-1"] = &models.TimeoutTracker{}
+	p.mu.Unlock()
+	if got := readLogs(t, p, "nginx", api.ContainerLogOpts{}); !strings.Contains(got, "(device is offline)") || !strings.HasSuffix(got, "one\ntwo\nthree\n") {
+		t.Errorf("logs of a disconnected device = %q, want the cached logs", got)
+	}
+
+	// Deleting the pod drops its cached logs
+	p.mu.Lock()
+	delete(p.disconnects, "device-1")
+	p.mu.Unlock()
+	if err := p.DeletePod(ctx, pod); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+	if _, ok := p.logCache.get("default/web", "nginx"); ok {
+		t.Error("cached logs kept after the pod was deleted")
+	}
+}
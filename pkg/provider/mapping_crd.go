@@ -0,0 +1,337 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// Each pod the provider deploys can be recorded in a PodDeviceMapping
+// custom resource (deploy/crd.yaml) of the same name in the pod's namespace,
+// owned by the pod: the devices it runs on, its application and content
+// hash, and its rollout state. Operators see where pods run with kubectl get
+// pdm, other tools can watch them, and a restarted provider places a
+// re-created pod back on the device its record names instead of selecting
+// one again.
+
+// PodDeviceMappingResource is the resource of PodDeviceMapping records.
+var PodDeviceMappingResource = schema.GroupVersionResource{Group: "flightctl.io", Version: "v1alpha1", Resource: "poddevicemappings"}
+
+// PodDeviceMappingKind is the kind of PodDeviceMapping records.
+const PodDeviceMappingKind = "PodDeviceMapping"
+
+// MappingNodeLabel labels a PodDeviceMapping with the node of its pod.
+const MappingNodeLabel = "flightctl.io/node"
+
+// mappingFieldManager owns the fields of PodDeviceMapping records the
+// provider applies.
+const mappingFieldManager = "vk-flightctl-provider"
+
+// Rollout states of PodDeviceMapping records.
+const (
+	MappingAwaitingDevice = "AwaitingDevice" // The application is written once the pod's offline device reconnects
+	MappingDeploying      = "Deploying"      // The application is being written to the pod's device
+	MappingProgressing    = "Progressing"    // The application was written and must run by its deadline
+	MappingDeployed       = "Deployed"       // The application was written, and ran once if it had a deadline
+	MappingRolledBack     = "RolledBack"     // The deployment was rolled back, or failed
+	MappingCompleted      = "Completed"      // The pod succeeded or failed for good
+	MappingTerminating    = "Terminating"    // The pod was deleted and its devices are stopping it
+)
+
+// PodDeviceMappingSpec is where a pod was deployed.
+type PodDeviceMappingSpec struct {
+	NodeName string    `json:"nodeName"`
+	PodName  string    `json:"podName"`
+	PodUID   types.UID `json:"podUID"`
+	// DeviceID is the device of a pod deployed to a single device.
+	DeviceID string `json:"deviceID,omitempty"`
+	// Devices are the devices a spread pod runs on.
+	Devices []string `json:"devices,omitempty"`
+	// SpreadQuorum is how many of Devices must run a spread pod.
+	SpreadQuorum int `json:"spreadQuorum,omitempty"`
+	// RolloutFleet is the fleet whose template deploys a spread pod.
+	RolloutFleet string `json:"rolloutFleet,omitempty"`
+	// AppName and ContentHash identify the application the pod was
+	// translated to, as found in the device specs.
+	AppName     string      `json:"appName,omitempty"`
+	ContentHash string      `json:"contentHash,omitempty"`
+	DeployedAt  metav1.Time `json:"deployedAt"`
+}
+
+// PodDeviceMappingStatus is how the deployment of a pod went.
+type PodDeviceMappingStatus struct {
+	// RolloutState is one of the Mapping* states.
+	RolloutState string          `json:"rolloutState"`
+	Phase        corev1.PodPhase `json:"phase,omitempty"`
+	Ready        bool            `json:"ready"`
+	Reason       string          `json:"reason,omitempty"`
+	Message      string          `json:"message,omitempty"`
+	// FleetRollout is the batch the pod's fleet is rolling out, while a
+	// fleet rollout is in progress.
+	FleetRollout string `json:"fleetRollout,omitempty"`
+}
+
+// PodDeviceMapping is the record of a pod deployed by the provider.
+type PodDeviceMapping struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PodDeviceMappingSpec   `json:"spec"`
+	Status PodDeviceMappingStatus `json:"status,omitempty"`
+}
+
+// MappingStore keeps the PodDeviceMapping records of pods.
+type MappingStore interface {
+	// Get returns the record of a pod, or nil if it has none.
+	Get(ctx context.Context, namespace, name string) (*PodDeviceMapping, error)
+	// Apply creates or updates a record.
+	Apply(ctx context.Context, record *PodDeviceMapping) error
+	// Delete removes the record of a pod, if it has one.
+	Delete(ctx context.Context, namespace, name string) error
+}
+
+// mappingCRStore keeps records as PodDeviceMapping custom resources.
+type mappingCRStore struct {
+	client dynamic.Interface
+}
+
+// NewMappingCRStore returns a store keeping records as PodDeviceMapping
+// custom resources; the CRD must be installed.
+func NewMappingCRStore(client dynamic.Interface) MappingStore {
+	return mappingCRStore{client: client}
+}
+
+func (s mappingCRStore) Get(ctx context.Context, namespace, name string) (*PodDeviceMapping, error) {
+	obj, err := s.client.Resource(PodDeviceMappingResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	record := &PodDeviceMapping{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, record); err != nil {
+		return nil, fmt.Errorf("decoding PodDeviceMapping %s/%s: %w", namespace, name, err)
+	}
+	return record, nil
+}
+
+func (s mappingCRStore) Apply(ctx context.Context, record *PodDeviceMapping) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(record)
+	if err != nil {
+		return fmt.Errorf("encoding PodDeviceMapping %s/%s: %w", record.Namespace, record.Name, err)
+	}
+	obj := &unstructured.Unstructured{Object: content}
+	unstructured.RemoveNestedField(obj.Object, "metadata", "creationTimestamp")
+	_, err = s.client.Resource(PodDeviceMappingResource).Namespace(record.Namespace).Apply(ctx, record.Name, obj,
+		metav1.ApplyOptions{FieldManager: mappingFieldManager, Force: true})
+	return err
+}
+
+func (s mappingCRStore) Delete(ctx context.Context, namespace, name string) error {
+	err := s.client.Resource(PodDeviceMappingResource).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// deployedApp is the application a pod was last translated to.
+type deployedApp struct {
+	uid         types.UID
+	name        string
+	contentHash string
+}
+
+// mappingRecords tracks the PodDeviceMapping records written for the pods,
+// so only changed ones are written again.
+type mappingRecords struct {
+	store MappingStore
+
+	mu      sync.Mutex
+	apps    map[string]deployedApp       // podKey -> application
+	written map[string]*PodDeviceMapping // podKey -> record last written
+}
+
+func newMappingRecords(store MappingStore) *mappingRecords {
+	return &mappingRecords{
+		store:   store,
+		apps:    make(map[string]deployedApp),
+		written: make(map[string]*PodDeviceMapping),
+	}
+}
+
+// observeTranslation notes the application a pod was translated to for its
+// record, and shows it on the pod.
+func (p *Provider) observeTranslation(ctx context.Context, pod *corev1.Pod, deviceID string, app flightctl.FlightctlApplication) {
+	if r := p.mappingRecords; r != nil {
+		r.mu.Lock()
+		r.apps[pod.Namespace+"/"+pod.Name] = deployedApp{uid: pod.UID, name: app.Name, contentHash: app.ContentHash()}
+		r.mu.Unlock()
+	}
+	p.previewTranslation(ctx, pod, deviceID, app)
+}
+
+// recordedDevice returns the device the record of a pod places it on, if
+// it was recorded by this node for the same pod, or "" otherwise.
+func (p *Provider) recordedDevice(ctx context.Context, pod *corev1.Pod) string {
+	if p.mappingRecords == nil {
+		return ""
+	}
+	record, err := p.mappingRecords.store.Get(ctx, pod.Namespace, pod.Name)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Failed to get the PodDeviceMapping of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return ""
+	}
+	if record == nil || record.Spec.PodUID != pod.UID || record.Spec.NodeName != p.nodeName {
+		return ""
+	}
+	return record.Spec.DeviceID
+}
+
+// syncMappingRecords writes the records of the tracked pods that changed
+// since they were last written, and deletes those of pods no longer
+// tracked. Failures are logged and retried on the next sync.
+func (p *Provider) syncMappingRecords(ctx context.Context) {
+	r := p.mappingRecords
+	if r == nil {
+		return
+	}
+	log := logger.FromContext(ctx)
+
+	r.mu.Lock()
+	p.mu.RLock()
+	records := make(map[string]*PodDeviceMapping, len(p.podMappings))
+	for podKey, mapping := range p.podMappings {
+		app := r.apps[podKey]
+		if app.uid != mapping.PodUID {
+			app = deployedApp{}
+		}
+		records[podKey] = p.mappingRecordLocked(mapping, app)
+	}
+	p.mu.RUnlock()
+	var changed []*PodDeviceMapping
+	for podKey, record := range records {
+		if written, ok := r.written[podKey]; !ok || !reflect.DeepEqual(written, record) {
+			changed = append(changed, record)
+		}
+	}
+	var removed []string
+	for podKey := range r.written {
+		if _, ok := records[podKey]; !ok {
+			removed = append(removed, podKey)
+		}
+	}
+	for podKey, app := range r.apps {
+		if record, ok := records[podKey]; !ok || record.Spec.PodUID != app.uid {
+			delete(r.apps, podKey)
+		}
+	}
+	r.mu.Unlock()
+	slices.Sort(removed)
+
+	for _, record := range changed {
+		podKey := record.Namespace + "/" + record.Name
+		if err := r.store.Apply(ctx, record); err != nil {
+			log.Warn("Failed to write the PodDeviceMapping of pod %s: %v", podKey, err)
+			continue
+		}
+		r.mu.Lock()
+		r.written[podKey] = record
+		r.mu.Unlock()
+	}
+	for _, podKey := range removed {
+		r.mu.Lock()
+		record := r.written[podKey]
+		r.mu.Unlock()
+		if err := r.store.Delete(ctx, record.Namespace, record.Name); err != nil {
+			log.Warn("Failed to delete the PodDeviceMapping of pod %s: %v", podKey, err)
+			continue
+		}
+		r.mu.Lock()
+		if r.written[podKey] == record {
+			delete(r.written, podKey)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// mappingRecordLocked returns the record of a tracked pod. Caller must hold
+// p.mu.
+func (p *Provider) mappingRecordLocked(mapping *models.PodDeviceMapping, app deployedApp) *PodDeviceMapping {
+	record := &PodDeviceMapping{
+		TypeMeta: metav1.TypeMeta{APIVersion: PodDeviceMappingResource.GroupVersion().String(), Kind: PodDeviceMappingKind},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mapping.Name,
+			Namespace: mapping.Namespace,
+			Labels:    map[string]string{MappingNodeLabel: p.nodeName},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       mapping.Name,
+				UID:        mapping.PodUID,
+			}},
+		},
+		Spec: PodDeviceMappingSpec{
+			NodeName:     p.nodeName,
+			PodName:      mapping.Name,
+			PodUID:       mapping.PodUID,
+			SpreadQuorum: mapping.SpreadQuorum,
+			RolloutFleet: mapping.RolloutFleet,
+			AppName:      app.name,
+			ContentHash:  app.contentHash,
+			DeployedAt:   metav1.NewTime(mapping.DeployedAt.Truncate(time.Second)),
+		},
+		Status: PodDeviceMappingStatus{RolloutState: mappingRolloutState(mapping)},
+	}
+	if mapping.IsSpread() {
+		record.Spec.Devices = slices.Clone(mapping.SpreadDevices)
+	} else {
+		record.Spec.DeviceID = mapping.DeviceID
+	}
+	if status := mapping.Status; status != nil {
+		record.Status.Phase = status.Phase
+		record.Status.Ready = isPodReady(status)
+		record.Status.Reason = status.Reason
+		record.Status.Message = status.Message
+	}
+	if rollout, ok := p.fleetRollouts[mapping.RolloutFleet]; ok && mapping.RolloutFleet != "" && rollout.InProgress {
+		record.Status.FleetRollout = rollout.BatchName()
+	}
+	return record
+}
+
+// mappingRolloutState returns the rollout state of a tracked pod.
+func mappingRolloutState(mapping *models.PodDeviceMapping) string {
+	switch {
+	case mapping.IsTerminating():
+		return MappingTerminating
+	case mapping.IsAwaitingDevice():
+		return MappingAwaitingDevice
+	case mapping.InFlight:
+		return MappingDeploying
+	case mapping.RolledBack:
+		return MappingRolledBack
+	case mapping.IsCompleted():
+		return MappingCompleted
+	case !mapping.ReadyDeadline.IsZero():
+		return MappingProgressing
+	}
+	return MappingDeployed
+}
@@ -0,0 +1,126 @@
+package provider
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+// memoryMappingStore keeps records in memory.
+type memoryMappingStore struct {
+	mu      sync.Mutex
+	records map[string]*PodDeviceMapping
+}
+
+func newMemoryMappingStore() *memoryMappingStore {
+	return &memoryMappingStore{records: make(map[string]*PodDeviceMapping)}
+}
+
+func (s *memoryMappingStore) Get(ctx context.Context, namespace, name string) (*PodDeviceMapping, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[namespace+"/"+name], nil
+}
+
+func (s *memoryMappingStore) Apply(ctx context.Context, record *PodDeviceMapping) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.Namespace+"/"+record.Name] = record
+	return nil
+}
+
+func (s *memoryMappingStore) Delete(ctx context.Context, namespace, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, namespace+"/"+name)
+	return nil
+}
+
+func mappingPod(uid types.UID) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: uid},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx:1.25"}}},
+	}
+}
+
+func TestPodDeviceMappingRecords(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("d1", "edge", nil)
+	store := newMemoryMappingStore()
+	p := newTestProvider(t, server, Config{NodeName: "vk-test", DefaultFleet: "edge", MappingStore: store})
+	ctx := context.Background()
+
+	pod := mappingPod("uid-1")
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	record, _ := store.Get(ctx, "default", "web")
+	if record == nil {
+		t.Fatal("no PodDeviceMapping written for the pod")
+	}
+	if record.Spec.DeviceID != "d1" || record.Spec.PodUID != "uid-1" || record.Spec.NodeName != "vk-test" {
+		t.Errorf("spec = %+v, want pod uid-1 on device d1 of node vk-test", record.Spec)
+	}
+	if record.Spec.AppName == "" || record.Spec.ContentHash == "" {
+		t.Errorf("spec = %+v, want the application name and content hash", record.Spec)
+	}
+	if owners := record.OwnerReferences; len(owners) != 1 || owners[0].UID != "uid-1" {
+		t.Errorf("owner references = %+v, want the pod", owners)
+	}
+
+	p.reconcilePodStatus(ctx)
+	record, _ = store.Get(ctx, "default", "web")
+	if record.Status.Phase != corev1.PodRunning || !record.Status.Ready {
+		t.Errorf("status = %+v, want Running and Ready", record.Status)
+	}
+
+	if err := p.DeletePod(ctx, pod); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+	p.reconcilePodStatus(ctx)
+	if record, _ := store.Get(ctx, "default", "web"); record != nil {
+		t.Errorf("record of the deleted pod = %+v, want none", record)
+	}
+}
+
+func TestPodDeviceMappingRestoresPlacement(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("d1", "edge", nil)
+	server.AddDevice("d2", "edge", nil)
+	store := newMemoryMappingStore()
+	ctx := context.Background()
+
+	if err := newTestProvider(t, server, Config{NodeName: "vk-test", DefaultFleet: "edge", MappingStore: store}).CreatePod(ctx, mappingPod("uid-1")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	record, _ := store.Get(ctx, "default", "web")
+	if record == nil {
+		t.Fatal("no PodDeviceMapping written for the pod")
+	}
+	// Whichever device was picked, record the other one
+	other := "d1"
+	if record.Spec.DeviceID == "d1" {
+		other = "d2"
+	}
+	record.Spec.DeviceID = other
+
+	// A restarted provider deploys the same pod to its recorded device
+	p := newTestProvider(t, server, Config{NodeName: "vk-test", DefaultFleet: "edge", MappingStore: store})
+	if err := p.CreatePod(ctx, mappingPod("uid-1")); err != nil {
+		t.Fatalf("CreatePod after restart: %v", err)
+	}
+	if deviceID := p.placedDevice(t, "default/web"); deviceID != other {
+		t.Errorf("pod placed on %s after restart, want recorded device %s", deviceID, other)
+	}
+
+}
@@ -0,0 +1,103 @@
+package provider
+
+import (
+	"context"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/tracing"
+)
+
+// GetMetricsResource gets the metrics for the node, including running pods,
+// in the metric families of the kubelet /metrics/resource endpoint, from the
+// same device-reported usage as GetStatsSummary.
+func (p *Provider) GetMetricsResource(ctx context.Context) ([]*dto.MetricFamily, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Provider.GetMetricsResource")
+	defer span.End()
+
+	node, pods, failed := p.collectUsage(ctx)
+	span.SetAttributes(attribute.Int("pods", len(pods)))
+
+	nodeCPU := newMetricFamily("node_cpu_usage_seconds_total", "Cumulative cpu time consumed by the node in core-seconds", dto.MetricType_COUNTER)
+	nodeMemory := newMetricFamily("node_memory_working_set_bytes", "Current working set of the node in bytes", dto.MetricType_GAUGE)
+	podCPU := newMetricFamily("pod_cpu_usage_seconds_total", "Cumulative cpu time consumed by the pod in core-seconds", dto.MetricType_COUNTER)
+	podMemory := newMetricFamily("pod_memory_working_set_bytes", "Current working set of the pod in bytes", dto.MetricType_GAUGE)
+	containerCPU := newMetricFamily("container_cpu_usage_seconds_total", "Cumulative cpu time consumed by the container in core-seconds", dto.MetricType_COUNTER)
+	containerMemory := newMetricFamily("container_memory_working_set_bytes", "Current working set of the container in bytes", dto.MetricType_GAUGE)
+	containerStart := newMetricFamily("container_start_time_seconds", "Start time of the container since unix epoch in seconds", dto.MetricType_GAUGE)
+	scrapeError := newMetricFamily("scrape_error", "1 if there was an error while getting container metrics, 0 otherwise", dto.MetricType_GAUGE)
+
+	addUsageSamples(nodeCPU, nodeMemory, &node)
+	for _, usage := range pods {
+		podLabels := []string{"namespace", usage.pod.Namespace, "pod", usage.pod.Name}
+		var total usageTotal
+		for _, container := range usage.pod.Spec.Containers {
+			containerUsage, ok := usage.containers[container.Name]
+			if !ok {
+				continue
+			}
+			total.merge(containerUsage)
+			labels := append([]string{"container", container.Name}, podLabels...)
+			addUsageSamples(containerCPU, containerMemory, containerUsage, labels...)
+			addSample(containerStart, float64(usage.startTime.Unix()), time.Time{}, labels...)
+		}
+		addUsageSamples(podCPU, podMemory, &total, podLabels...)
+	}
+	errorValue := 0.0
+	if failed > 0 {
+		errorValue = 1
+	}
+	addSample(scrapeError, errorValue, time.Time{})
+
+	all := []*dto.MetricFamily{containerCPU, containerMemory, containerStart}
+	all = append(all, p.quotaMetricFamilies()...)
+	all = append(all, p.enrollmentMetricFamilies()...)
+	all = append(all, nodeCPU, nodeMemory, podCPU, podMemory, scrapeError)
+
+	// The text exposition format has no empty families
+	var families []*dto.MetricFamily
+	for _, family := range all {
+		if len(family.Metric) > 0 {
+			families = append(families, family)
+		}
+	}
+	return families, nil
+}
+
+// addUsageSamples adds the cumulative CPU time and the working set of usage,
+// where known, to the cpu and memory families.
+func addUsageSamples(cpu, memory *dto.MetricFamily, usage *usageTotal, labels ...string) {
+	if usage.cpu != nil {
+		addSample(cpu, usage.cpuSeconds, usage.time, labels...)
+	}
+	if usage.memory != nil {
+		addSample(memory, usage.memory.AsApproximateFloat64(), usage.time, labels...)
+	}
+}
+
+func newMetricFamily(name, help string, metricType dto.MetricType) *dto.MetricFamily {
+	return &dto.MetricFamily{Name: &name, Help: &help, Type: &metricType}
+}
+
+// addSample adds a sample with the given label name/value pairs, stamped
+// with t unless it is zero.
+func addSample(family *dto.MetricFamily, value float64, t time.Time, labels ...string) {
+	metric := &dto.Metric{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		name, labelValue := labels[i], labels[i+1]
+		metric.Label = append(metric.Label, &dto.LabelPair{Name: &name, Value: &labelValue})
+	}
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		metric.Counter = &dto.Counter{Value: &value}
+	default:
+		metric.Gauge = &dto.Gauge{Value: &value}
+	}
+	if !t.IsZero() {
+		ms := t.UnixMilli()
+		metric.TimestampMs = &ms
+	}
+	family.Metric = append(family.Metric, metric)
+}
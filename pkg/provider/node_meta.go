@@ -0,0 +1,147 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// DefaultNodeTaint keeps pods off the virtual node unless they tolerate it.
+var DefaultNodeTaint = corev1.Taint{Key: "vkubelet-flightctl", Value: "true", Effect: corev1.TaintEffectNoSchedule}
+
+// Placeholders of the providerID format.
+const (
+	providerIDNode   = "{node}"
+	providerIDDevice = "{device}"
+	providerIDFleet  = "{fleet}"
+)
+
+// ParseNodeTaints parses a comma-separated list of taints in the kubectl
+// form key[=value]:Effect. An empty list or "none" means no taints.
+func ParseNodeTaints(spec string) ([]corev1.Taint, error) {
+	spec = strings.TrimSpace(spec)
+	taints := []corev1.Taint{}
+	if spec == "" || spec == "none" {
+		return taints, nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		keyValue, effect, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("taint %q: missing effect, expected key[=value]:Effect", item)
+		}
+		key, value, _ := strings.Cut(keyValue, "=")
+		taint := corev1.Taint{Key: key, Value: value, Effect: corev1.TaintEffect(effect)}
+		if err := validateTaint(taint); err != nil {
+			return nil, fmt.Errorf("taint %q: %w", item, err)
+		}
+		taints = append(taints, taint)
+	}
+	return taints, nil
+}
+
+func validateTaint(taint corev1.Taint) error {
+	if errs := validation.IsQualifiedName(taint.Key); len(errs) > 0 {
+		return fmt.Errorf("invalid key: %s", strings.Join(errs, "; "))
+	}
+	if errs := validation.IsValidLabelValue(taint.Value); len(errs) > 0 {
+		return fmt.Errorf("invalid value: %s", strings.Join(errs, "; "))
+	}
+	switch taint.Effect {
+	case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		return nil
+	}
+	return fmt.Errorf("effect must be NoSchedule, PreferNoSchedule or NoExecute")
+}
+
+// ParseNodeExtendedResources parses the extended resources the single
+// virtual node advertises, as resource name to quantity pairs, e.g.
+// nvidia.com/gpu=4.
+func ParseNodeExtendedResources(spec map[string]string) (map[corev1.ResourceName]resource.Quantity, error) {
+	if len(spec) == 0 {
+		return nil, nil
+	}
+	resources := make(map[corev1.ResourceName]resource.Quantity, len(spec))
+	for name, value := range spec {
+		if !models.IsExtendedResourceName(corev1.ResourceName(name)) {
+			return nil, fmt.Errorf("node resource %q: not an extended resource name, e.g. nvidia.com/gpu", name)
+		}
+		quantity, err := resource.ParseQuantity(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("node resource %q quantity %q: %w", name, value, err)
+		}
+		if quantity.Sign() < 0 {
+			return nil, fmt.Errorf("node resource %q quantity %q: must not be negative", name, value)
+		}
+		resources[corev1.ResourceName(name)] = quantity
+	}
+	return resources, nil
+}
+
+// validateNodeMetadata checks the extra node labels, annotations and taints.
+func validateNodeMetadata(labels, annotations map[string]string, taints []corev1.Taint) error {
+	for key, value := range labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("node label %q: %s", key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("node label %q value %q: %s", key, value, strings.Join(errs, "; "))
+		}
+	}
+	for key := range annotations {
+		if errs := validation.IsQualifiedName(strings.ToLower(key)); len(errs) > 0 {
+			return fmt.Errorf("node annotation %q: %s", key, strings.Join(errs, "; "))
+		}
+	}
+	for _, taint := range taints {
+		if err := validateTaint(taint); err != nil {
+			return fmt.Errorf("node taint %q: %w", taint.ToString(), err)
+		}
+	}
+	return nil
+}
+
+// applyNodeMetadata adds the configured labels, annotations, taints and
+// providerID to the node, after the device or fleet it represents was
+// described on it.
+func (p *Provider) applyNodeMetadata(node *corev1.Node) {
+	for key, value := range p.nodeLabels {
+		node.Labels[key] = value
+	}
+	if len(p.nodeAnnotations) > 0 {
+		if node.Annotations == nil {
+			node.Annotations = make(map[string]string, len(p.nodeAnnotations))
+		}
+		for key, value := range p.nodeAnnotations {
+			node.Annotations[key] = value
+		}
+	}
+
+	node.Spec.Taints = []corev1.Taint{DefaultNodeTaint}
+	if p.nodeTaints != nil {
+		node.Spec.Taints = append([]corev1.Taint(nil), p.nodeTaints...)
+	}
+
+	if p.providerIDFormat != "" {
+		node.Spec.ProviderID = strings.NewReplacer(
+			providerIDNode, node.Name,
+			providerIDDevice, node.Labels[DeviceIDLabel],
+			providerIDFleet, node.Labels[FleetIDLabel],
+		).Replace(p.providerIDFormat)
+	}
+}
+
+// applyNodeResources advertises the configured extended resources on the
+// single node, which does not represent particular devices. Pods requesting
+// them are still placed on devices declaring them.
+func (p *Provider) applyNodeResources(node *corev1.Node) {
+	for name, quantity := range p.nodeResources {
+		node.Status.Capacity[name] = quantity.DeepCopy()
+		node.Status.Allocatable[name] = quantity.DeepCopy()
+	}
+}
@@ -0,0 +1,158 @@
+package provider
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func TestParseNodeTaints(t *testing.T) {
+	taints, err := ParseNodeTaints("dedicated=edge:NoSchedule, example.com/gpu:PreferNoSchedule")
+	if err != nil {
+		t.Fatalf("ParseNodeTaints: %v", err)
+	}
+	want := []corev1.Taint{
+		{Key: "dedicated", Value: "edge", Effect: corev1.TaintEffectNoSchedule},
+		{Key: "example.com/gpu", Effect: corev1.TaintEffectPreferNoSchedule},
+	}
+	if !reflect.DeepEqual(taints, want) {
+		t.Errorf("taints = %+v, want %+v", taints, want)
+	}
+
+	for _, spec := range []string{"", "none"} {
+		taints, err := ParseNodeTaints(spec)
+		if err != nil || taints == nil || len(taints) != 0 {
+			t.Errorf("ParseNodeTaints(%q) = %v, %v; want an empty list", spec, taints, err)
+		}
+	}
+	for _, spec := range []string{"dedicated=edge", "dedicated=edge:Sometimes", "bad key=x:NoSchedule"} {
+		if _, err := ParseNodeTaints(spec); err == nil {
+			t.Errorf("ParseNodeTaints(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestNodeMetadataIsConfigurable(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "edge", nil)
+
+	p := newTestProvider(t, server, Config{
+		NodeName:         "device-1",
+		DeviceID:         "device-1",
+		NodeLabels:       map[string]string{"node.example.com/pool": "edge"},
+		NodeAnnotations:  map[string]string{"cluster-autoscaler.kubernetes.io/scale-down-disabled": "true"},
+		NodeTaints:       []corev1.Taint{},
+		ProviderIDFormat: "flightctl://{fleet}/{device}",
+	})
+	p.SetDevice(fetchDevice(t, server, "device-1").ToModel())
+
+	node, err := p.GetNode(context.Background())
+	if err != nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	if node.Labels["node.example.com/pool"] != "edge" {
+		t.Errorf("labels = %v, want the configured label", node.Labels)
+	}
+	if node.Annotations["cluster-autoscaler.kubernetes.io/scale-down-disabled"] != "true" {
+		t.Errorf("annotations = %v, want the configured annotation", node.Annotations)
+	}
+	if len(node.Spec.Taints) != 0 {
+		t.Errorf("taints = %v, want none", node.Spec.Taints)
+	}
+	if node.Spec.ProviderID != "flightctl://edge/device-1" {
+		t.Errorf("providerID = %q, want flightctl://edge/device-1", node.Spec.ProviderID)
+	}
+}
+
+func TestNodeKeepsDefaultTaint(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+
+	node, err := p.GetNode(context.Background())
+	if err != nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	if !reflect.DeepEqual(node.Spec.Taints, []corev1.Taint{DefaultNodeTaint}) {
+		t.Errorf("taints = %v, want the default taint", node.Spec.Taints)
+	}
+	if node.Spec.ProviderID != "" {
+		t.Errorf("providerID = %q, want unset", node.Spec.ProviderID)
+	}
+}
+
+func TestConfigRejectsInvalidNodeMetadata(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"label":  {NodeName: "n", NodeLabels: map[string]string{"bad key": "x"}},
+		"value":  {NodeName: "n", NodeLabels: map[string]string{"ok": "bad value"}},
+		"taint":  {NodeName: "n", NodeTaints: []corev1.Taint{{Key: "k", Effect: "Sometimes"}}},
+		"annot.": {NodeName: "n", NodeAnnotations: map[string]string{"/x": "y"}},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: Validate succeeded, want an error", name)
+		}
+	}
+}
+
+func TestNodeAddressesAndKubeletEndpoint(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "edge", nil)
+	if err := server.SetSystemInfo("device-1", map[string]string{"netIPDefault": "192.168.1.20"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The single node is reached at the provider's address
+	p := newTestProvider(t, server, Config{NodeName: "vk", NodeIP: "10.128.0.15", KubeletPort: 10250})
+	node, err := p.GetNode(context.Background())
+	if err != nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	want := []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.128.0.15"}}
+	if !reflect.DeepEqual(node.Status.Addresses, want) {
+		t.Errorf("addresses = %v, want %v", node.Status.Addresses, want)
+	}
+	if port := node.Status.DaemonEndpoints.KubeletEndpoint.Port; port != 10250 {
+		t.Errorf("kubelet endpoint port = %d, want 10250", port)
+	}
+
+	// A device node reports the device's address
+	d := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1", NodeIP: "10.128.0.15"})
+	d.SetDevice(fetchDevice(t, server, "device-1").ToModel())
+	node, err = d.GetNode(context.Background())
+	if err != nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	want = []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "192.168.1.20"}}
+	if !reflect.DeepEqual(node.Status.Addresses, want) {
+		t.Errorf("device node addresses = %v, want %v", node.Status.Addresses, want)
+	}
+}
+
+func TestNodeExtendedResources(t *testing.T) {
+	resources, err := ParseNodeExtendedResources(map[string]string{"nvidia.com/gpu": "4"})
+	if err != nil {
+		t.Fatalf("ParseNodeExtendedResources: %v", err)
+	}
+	for _, invalid := range []map[string]string{{"cpu": "4"}, {"nvidia.com/gpu": "many"}, {"nvidia.com/gpu": "-1"}} {
+		if _, err := ParseNodeExtendedResources(invalid); err == nil {
+			t.Errorf("ParseNodeExtendedResources(%v): expected an error", invalid)
+		}
+	}
+
+	server := fake.NewServer()
+	defer server.Close()
+	p := newTestProvider(t, server, Config{NodeName: "vk", NodeExtendedResources: resources})
+	node, err := p.GetNode(context.Background())
+	if err != nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	if gpus := node.Status.Allocatable["nvidia.com/gpu"]; gpus.String() != "4" {
+		t.Errorf("GPU allocatable = %s, want 4", gpus.String())
+	}
+}
@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// With an offline deployment TTL, a pod whose device is offline when it is
+// created is held instead of written to the device: it stays Pending with
+// the AwaitingDeviceConnection reason until the device reconnects, and is
+// then deployed by the disconnection monitor. A pod whose device does not
+// reconnect within the TTL of the pod's creation fails, so the device never
+// starts a stale application. The deadline is taken from the pod, so it
+// holds across provider restarts, where the pod is held again on its
+// recorded device.
+
+// awaitingDeviceReason is the reason of the status of held pods.
+const awaitingDeviceReason = "AwaitingDeviceConnection"
+
+// offlineDeadline returns when the held deployment of a pod to the device
+// fails, or zero if the pod is deployed now: the offline deployment TTL is
+// unset, or the device, as read when placing the pod, is not known to be
+// offline.
+func (p *Provider) offlineDeadline(pod *corev1.Pod, device *models.Device) time.Time {
+	if p.offlineDeploymentTTL <= 0 || device == nil || device.ConnectionState != models.Disconnected {
+		return time.Time{}
+	}
+	created := pod.CreationTimestamp.Time
+	if created.IsZero() {
+		created = time.Now()
+	}
+	return created.Add(p.offlineDeploymentTTL)
+}
+
+// awaitingDeviceStatus returns the status of a pod held until its device
+// reconnects.
+func awaitingDeviceStatus(deviceID string, until time.Time) *corev1.PodStatus {
+	message := fmt.Sprintf("Device %s is offline; the pod is deployed when it reconnects, unless it stays offline past %s",
+		deviceID, until.Format(time.RFC3339))
+	return &corev1.PodStatus{
+		Phase:   corev1.PodPending,
+		Reason:  awaitingDeviceReason,
+		Message: message,
+		Conditions: []corev1.PodCondition{
+			{
+				Type:               corev1.PodScheduled,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.Now(),
+				Reason:             "Scheduled",
+				Message:            fmt.Sprintf("Pod scheduled to FlightCtl device %s", deviceID),
+			},
+			{
+				Type:               corev1.PodReady,
+				Status:             corev1.ConditionFalse,
+				LastTransitionTime: metav1.Now(),
+				Reason:             awaitingDeviceReason,
+				Message:            message,
+			},
+		},
+	}
+}
+
+// deployAwaitingPods deploys the held pods of the devices that reconnected,
+// and fails those of devices that were removed or did not reconnect in time.
+func (p *Provider) deployAwaitingPods(ctx context.Context) {
+	p.mu.RLock()
+	held := make(map[string][]*models.PodDeviceMapping)
+	for _, mapping := range p.podMappings {
+		if mapping.IsAwaitingDevice() {
+			held[mapping.DeviceID] = append(held[mapping.DeviceID], mapping)
+		}
+	}
+	p.mu.RUnlock()
+
+	for deviceID, mappings := range held {
+		raw, err := p.flightctl.GetDevice(ctx, deviceID)
+		removed := errors.Is(err, flightctl.ErrNotFound)
+		if err != nil && !removed {
+			logger.Warn("Checking device %s of %d held pod(s) failed: %v", deviceID, len(mappings), err)
+			continue
+		}
+		for _, mapping := range mappings {
+			switch {
+			case removed:
+				p.failHeldPod(mapping, "DeviceRemoved", fmt.Sprintf("Device %s was removed from FlightCtl", deviceID))
+			case raw.ToModel().ConnectionState != models.Disconnected:
+				p.deployHeldPod(ctx, mapping)
+			case time.Now().After(mapping.AwaitingDeviceUntil):
+				p.failHeldPod(mapping, "DeviceConnectionTimeout",
+					fmt.Sprintf("Device %s did not reconnect by %s", deviceID, mapping.AwaitingDeviceUntil.Format(time.RFC3339)))
+			}
+		}
+	}
+}
+
+// deployHeldPod writes a held pod's application to its reconnected device,
+// in the background.
+func (p *Provider) deployHeldPod(ctx context.Context, mapping *models.PodDeviceMapping) {
+	deviceID := mapping.DeviceID
+	p.mu.Lock()
+	if p.podMappings[mapping.PodKey] != mapping || !mapping.IsAwaitingDevice() {
+		p.mu.Unlock()
+		return
+	}
+	mapping.AwaitingDeviceUntil = time.Time{}
+	mapping.InFlight = true
+	mapping.DeployedAt = time.Now()
+	mapping.Status = scheduledPodStatus(deviceID)
+	pod := mapping.Pod.DeepCopy()
+	p.recordPodEvent(mapping, corev1.EventTypeNormal, "DeviceReconnected", "Device %s reconnected, deploying the pod", deviceID)
+	p.mu.Unlock()
+
+	ctx = logger.EnsureCorrelationID(ctx)
+	logger.FromContext(ctx).With("pod", mapping.PodKey).Info("Device %s reconnected, deploying held pod %s", deviceID, mapping.PodKey)
+	started := time.Now()
+	p.podQueue.enqueue(ctx, deviceID, &podOperation{
+		description: fmt.Sprintf("deploying pod %s to device %s", mapping.PodKey, deviceID),
+		pod:         mapping.PodKey,
+		run: func(ctx context.Context) error {
+			return p.podManager.DeployPod(ctx, pod, deviceID)
+		},
+		done: func(err error, background bool) error {
+			return p.deployedPod(ctx, mapping, deviceID, started, err, background)
+		},
+	})
+}
+
+// failHeldPod fails a held pod, which is never deployed.
+func (p *Provider) failHeldPod(mapping *models.PodDeviceMapping, reason, message string) {
+	p.mu.Lock()
+	if p.podMappings[mapping.PodKey] != mapping || !mapping.IsAwaitingDevice() {
+		p.mu.Unlock()
+		return
+	}
+	mapping.AwaitingDeviceUntil = time.Time{}
+	mapping.RolledBack = true
+	p.mu.Unlock()
+	logger.Warn("Failing held pod %s: %s", mapping.PodKey, message)
+	p.failPod(mapping.PodKey, reason, message)
+}
@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func TestOfflineDeviceHoldsPodUntilReconnect(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	if err := server.SetDeviceSummary("d1", "Offline"); err != nil {
+		t.Fatalf("SetDeviceSummary: %v", err)
+	}
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1"})
+	p.offlineDeploymentTTL = time.Hour
+	ctx := context.Background()
+
+	if err := p.CreatePod(ctx, cpuPod("web", "100m")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if apps := fetchDevice(t, server, "d1").Spec.Applications; len(apps) != 0 {
+		t.Errorf("applications on the offline device = %+v, want none", apps)
+	}
+	status, err := p.GetPodStatus(ctx, "default", "web")
+	if err != nil {
+		t.Fatalf("GetPodStatus: %v", err)
+	}
+	if status.Phase != corev1.PodPending || status.Reason != awaitingDeviceReason {
+		t.Errorf("status = %s (%s), want Pending (%s)", status.Phase, status.Reason, awaitingDeviceReason)
+	}
+
+	// Still offline, the pod stays held
+	p.checkDeviceConnectivity(ctx)
+	if apps := fetchDevice(t, server, "d1").Spec.Applications; len(apps) != 0 {
+		t.Errorf("applications on the offline device = %+v, want none", apps)
+	}
+
+	if err := server.SetDeviceSummary("d1", "Online"); err != nil {
+		t.Fatalf("SetDeviceSummary: %v", err)
+	}
+	p.checkDeviceConnectivity(ctx)
+	waitFor(t, "the held pod to be deployed", func() bool {
+		return len(fetchDevice(t, server, "d1").Spec.Applications) == 1
+	})
+	waitFor(t, "the pod to be tracked as deployed", func() bool {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		mapping := p.podMappings["default/web"]
+		return mapping != nil && !mapping.InFlight && !mapping.IsAwaitingDevice()
+	})
+}
+
+func TestOfflineDeploymentTTLFailsHeldPod(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	if err := server.SetDeviceSummary("d1", "Offline"); err != nil {
+		t.Fatalf("SetDeviceSummary: %v", err)
+	}
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1"})
+	p.offlineDeploymentTTL = time.Hour
+	ctx := context.Background()
+
+	// The TTL counts from the pod's creation, so it has run out already
+	stale := cpuPod("stale", "100m")
+	stale.CreationTimestamp = metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	if err := p.CreatePod(ctx, stale); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	p.checkDeviceConnectivity(ctx)
+
+	status, err := p.GetPodStatus(ctx, "default", "stale")
+	if err != nil {
+		t.Fatalf("GetPodStatus: %v", err)
+	}
+	if status.Phase != corev1.PodFailed || status.Reason != "DeviceConnectionTimeout" {
+		t.Errorf("status = %s (%s), want Failed (DeviceConnectionTimeout)", status.Phase, status.Reason)
+	}
+
+	// A held pod deleted before its device reconnects is never deployed
+	if err := p.CreatePod(ctx, cpuPod("web", "100m")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if err := p.DeletePod(ctx, cpuPod("web", "100m")); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+	if err := server.SetDeviceSummary("d1", "Online"); err != nil {
+		t.Fatalf("SetDeviceSummary: %v", err)
+	}
+	p.checkDeviceConnectivity(ctx)
+	if apps := fetchDevice(t, server, "d1").Spec.Applications; len(apps) != 0 {
+		t.Errorf("applications on the device = %+v, want none", apps)
+	}
+}
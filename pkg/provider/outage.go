@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// While the FlightCtl API is down its client's circuit breaker pauses calls
+// to it. The provider then stops reconciling, serves the statuses it last
+// read marked as stale, and turns the node NotReady once the outage lasts
+// longer than the API outage node timeout. Everything resumes when the
+// breaker closes again.
+
+// DefaultAPIOutageNodeTimeout is how long the FlightCtl API may be
+// unavailable before the node turns NotReady.
+const DefaultAPIOutageNodeTimeout = 2 * time.Minute
+
+// StatusStaleReason is the reason of the Ready condition of pods whose
+// status could not be read because the FlightCtl API is unavailable.
+const StatusStaleReason = "StatusStale"
+
+// apiUnavailableSince returns since when the FlightCtl API has been failing
+// if the client paused calls to it, or zero otherwise.
+func (p *Provider) apiUnavailableSince() time.Time {
+	if reporter, ok := p.flightctl.(interface{ UnavailableSince() time.Time }); ok {
+		return reporter.UnavailableSince()
+	}
+	return time.Time{}
+}
+
+// checkAPIOutage reports whether the FlightCtl API is unavailable, and
+// pushes the node status when the node turns NotReady or Ready again
+// because of it.
+func (p *Provider) checkAPIOutage() bool {
+	since := p.apiUnavailableSince()
+	outage := !since.IsZero() && time.Since(since) >= p.apiOutageNodeTimeout
+
+	p.mu.Lock()
+	changed := outage != p.nodeAPIOutage
+	p.nodeAPIOutage = outage
+	p.mu.Unlock()
+
+	if changed {
+		if outage {
+			logger.Warn("FlightCtl API unavailable since %s, marking node %s NotReady", since.Format(time.RFC3339), p.nodeName)
+		} else {
+			logger.Info("FlightCtl API available again, node %s is Ready", p.nodeName)
+		}
+		p.pushNodeStatus()
+	}
+	return !since.IsZero()
+}
+
+// applyAPIOutage turns the node NotReady while the FlightCtl API has been
+// unavailable for longer than the API outage node timeout.
+func (p *Provider) applyAPIOutage(node *corev1.Node) {
+	since := p.apiUnavailableSince()
+	if since.IsZero() || time.Since(since) < p.apiOutageNodeTimeout {
+		return
+	}
+	setNodeNotReady(node, "FlightctlAPIUnavailable",
+		fmt.Sprintf("FlightCtl API unavailable since %s", since.Format(time.RFC3339)))
+}
+
+// staleIfUnavailable returns status with its Ready condition Unknown if the
+// FlightCtl API has been unavailable since the given time (non-zero), as
+// the status may no longer be current.
+func staleIfUnavailable(status corev1.PodStatus, since time.Time) corev1.PodStatus {
+	if since.IsZero() {
+		return status
+	}
+	stale := status.DeepCopy()
+	message := fmt.Sprintf("Status may be stale: FlightCtl API unavailable since %s", since.Format(time.RFC3339))
+	for i := range stale.Conditions {
+		if cond := &stale.Conditions[i]; cond.Type == corev1.PodReady {
+			cond.Status = corev1.ConditionUnknown
+			cond.Reason = StatusStaleReason
+			cond.Message = message
+			return *stale
+		}
+	}
+	stale.Conditions = append(stale.Conditions, corev1.PodCondition{
+		Type:               corev1.PodReady,
+		Status:             corev1.ConditionUnknown,
+		LastTransitionTime: metav1.NewTime(since),
+		Reason:             StatusStaleReason,
+		Message:            message,
+	})
+	return *stale
+}
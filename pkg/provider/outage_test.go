@@ -0,0 +1,89 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+// outageClient reports the FlightCtl API as unavailable since a given time.
+type outageClient struct {
+	flightctl.FlightctlClient
+	since time.Time
+}
+
+func (c outageClient) UnavailableSince() time.Time { return c.since }
+
+func nodeReady(t *testing.T, p *Provider) corev1.ConditionStatus {
+	t.Helper()
+	node, err := p.GetNode(context.Background())
+	if err != nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			return cond.Status
+		}
+	}
+	t.Fatal("node has no Ready condition")
+	return ""
+}
+
+func TestAPIOutageMarksStatusStaleAndNodeNotReady(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1"})
+	ctx := context.Background()
+	if err := p.CreatePod(ctx, cpuPod("web", "100m")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	var pushed []*corev1.Node
+	p.NotifyNodeStatus(ctx, func(node *corev1.Node) { pushed = append(pushed, node) })
+	client := p.flightctl
+
+	// A short outage marks statuses stale but keeps the node Ready
+	p.flightctl = outageClient{FlightctlClient: client, since: time.Now()}
+	if !p.checkAPIOutage() {
+		t.Error("checkAPIOutage = false during an outage")
+	}
+	status, err := p.GetPodStatus(ctx, "default", "web")
+	if err != nil {
+		t.Fatalf("GetPodStatus: %v", err)
+	}
+	var ready *corev1.PodCondition
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == corev1.PodReady {
+			ready = &status.Conditions[i]
+		}
+	}
+	if ready == nil || ready.Status != corev1.ConditionUnknown || ready.Reason != StatusStaleReason {
+		t.Errorf("Ready condition = %+v, want Unknown with reason %s", ready, StatusStaleReason)
+	}
+	if got := nodeReady(t, p); got != corev1.ConditionTrue {
+		t.Errorf("node Ready = %s during a short outage, want True", got)
+	}
+
+	// A long one turns the node NotReady, and recovery makes it Ready again
+	pushes := len(pushed)
+	p.flightctl = outageClient{FlightctlClient: client, since: time.Now().Add(-DefaultAPIOutageNodeTimeout)}
+	p.checkAPIOutage()
+	if got := nodeReady(t, p); got != corev1.ConditionFalse {
+		t.Errorf("node Ready = %s after the outage timeout, want False", got)
+	}
+	p.flightctl = client
+	if p.checkAPIOutage() {
+		t.Error("checkAPIOutage = true after recovery")
+	}
+	if got := nodeReady(t, p); got != corev1.ConditionTrue {
+		t.Errorf("node Ready = %s after recovery, want True", got)
+	}
+	if len(pushed) != pushes+2 {
+		t.Errorf("node status pushed %d times, want 2 (NotReady and Ready)", len(pushed)-pushes)
+	}
+}
@@ -0,0 +1,249 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// Pod operations write to devices through a queue keyed by device. Workers
+// take the devices with pending operations and run all operations of a
+// device at once, so they are written in a single device update, while a
+// slow device only holds up the worker running its own operations. The
+// operations of one pod run one after the other in the order they were
+// queued, and an operation to be retried holds back those queued after it,
+// so e.g. a deletion never overtakes the deployment it undoes.
+//
+// The callbacks of the node controller wait for the first attempt of their
+// operation for at most podOperationWait, and return its error if it failed
+// so the node controller retries the callback. Operations still running by
+// then continue in the background and the callback succeeds: failures on
+// transient errors are retried with a per-device exponential backoff, under
+// a rate limit shared by all devices, and other failures are reported on the
+// pod. Operations of pods spread over several devices are keyed by their
+// first device.
+
+// DefaultPodWorkers is how many devices' pod operations run concurrently.
+const DefaultPodWorkers = 8
+
+// podOperationWait is how long callbacks wait for their operation.
+const podOperationWait = 5 * time.Second
+
+// Backoff and rate limit of retried pod operations.
+const (
+	podRetryBaseDelay = time.Second
+	podRetryMaxDelay  = 2 * time.Minute
+	podRetryRate      = 10 // Retries per second, over all devices
+	podRetryBurst     = 100
+)
+
+// podOperation is a change to the applications of a pod's devices.
+type podOperation struct {
+	// description names the operation in logs, e.g. "deploying pod x to
+	// device y".
+	description string
+	// pod is the key of the pod the operation changes.
+	pod string
+	// run makes the change. It is run again while it fails on transient
+	// errors once its caller stopped waiting.
+	run func(ctx context.Context) error
+	// done is called once with the result of the operation: the first
+	// attempt's if the caller still waits for it, else the last. background
+	// is set once the caller stopped waiting. The caller gets done's error.
+	done func(err error, background bool) error
+
+	ctx context.Context
+
+	mu         sync.Mutex
+	result     chan error // Result of the first attempt, while the caller waits
+	background bool
+}
+
+// podQueue holds the pod operations waiting for their device.
+type podQueue struct {
+	queue workqueue.RateLimitingInterface // Devices with pending operations
+	wait  time.Duration
+
+	mu      sync.Mutex
+	pending map[string][]*podOperation // deviceID -> operations
+}
+
+func newPodQueue() *podQueue {
+	return &podQueue{
+		queue: workqueue.NewRateLimitingQueue(workqueue.NewMaxOfRateLimiter(
+			workqueue.NewItemExponentialFailureRateLimiter(podRetryBaseDelay, podRetryMaxDelay),
+			&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(podRetryRate, podRetryBurst)},
+		)),
+		wait:    podOperationWait,
+		pending: make(map[string][]*podOperation),
+	}
+}
+
+// run queues an operation on a device and waits for its first attempt, for
+// at most the queue's wait or until ctx is done. It returns the error of
+// op.done if the attempt finished by then, else nil, and the operation
+// continues in the background.
+func (q *podQueue) run(ctx context.Context, deviceID string, op *podOperation) error {
+	// The operation outlives the callback, but keeps its logging and
+	// tracing context
+	op.ctx = context.WithoutCancel(ctx)
+	op.result = make(chan error, 1)
+	q.add(deviceID, op)
+
+	timer := time.NewTimer(q.wait)
+	defer timer.Stop()
+	select {
+	case err := <-op.result:
+		return op.done(err, false)
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	op.mu.Lock()
+	select {
+	case err := <-op.result:
+		op.mu.Unlock()
+		return op.done(err, false)
+	default:
+	}
+	op.background = true
+	op.mu.Unlock()
+	logger.FromContext(ctx).With("device", deviceID).Info("Still %s, continuing in the background", op.description)
+	return nil
+}
+
+// enqueue queues an operation on a device without waiting for it: it runs
+// in the background, as if its caller had stopped waiting.
+func (q *podQueue) enqueue(ctx context.Context, deviceID string, op *podOperation) {
+	op.ctx = context.WithoutCancel(ctx)
+	op.background = true
+	q.add(deviceID, op)
+}
+
+func (q *podQueue) add(deviceID string, ops ...*podOperation) {
+	q.mu.Lock()
+	q.pending[deviceID] = append(q.pending[deviceID], ops...)
+	q.mu.Unlock()
+	q.queue.Add(deviceID)
+}
+
+// take returns and clears the operations pending for a device.
+func (q *podQueue) take(deviceID string) []*podOperation {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ops := q.pending[deviceID]
+	delete(q.pending, deviceID)
+	return ops
+}
+
+// podWorker runs the operations of queued devices until the queue is shut
+// down.
+func (p *Provider) podWorker() {
+	q := p.podQueue
+	for {
+		item, shutdown := q.queue.Get()
+		if shutdown {
+			return
+		}
+		deviceID := item.(string)
+
+		// Operations of different pods run concurrently, those of a pod in
+		// order
+		var pods []string
+		ops := make(map[string][]*podOperation)
+		for _, op := range q.take(deviceID) {
+			if _, ok := ops[op.pod]; !ok {
+				pods = append(pods, op.pod)
+			}
+			ops[op.pod] = append(ops[op.pod], op)
+		}
+		var wg sync.WaitGroup
+		held := make([][]*podOperation, len(pods))
+		for i, pod := range pods {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j, op := range ops[pod] {
+					if err := op.run(op.ctx); q.finish(deviceID, op, err) {
+						held[i] = ops[pod][j:]
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		var retry []*podOperation
+		for _, ops := range held {
+			retry = append(retry, ops...)
+		}
+		if len(retry) > 0 {
+			q.mu.Lock()
+			q.pending[deviceID] = append(retry, q.pending[deviceID]...)
+			q.mu.Unlock()
+			q.queue.AddRateLimited(deviceID)
+		} else {
+			q.queue.Forget(deviceID)
+		}
+		q.queue.Done(deviceID)
+	}
+}
+
+// finish hands the result of an attempt to the operation's caller if it
+// still waits, and otherwise to its done func unless the attempt is to be
+// retried, which it reports.
+func (q *podQueue) finish(deviceID string, op *podOperation, err error) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	if !op.background {
+		op.result <- err
+		return false
+	}
+	if err != nil && retryablePodError(err) {
+		logger.FromContext(op.ctx).With("device", deviceID).Warn("Failed %s, retrying: %v", op.description, err)
+		return true
+	}
+	if err := op.done(err, true); err != nil {
+		logger.FromContext(op.ctx).With("device", deviceID).Error("Failed %s: %v", op.description, err)
+	}
+	return false
+}
+
+// shutdown stops the workers once the operations they are running are done.
+// Operations waiting to be retried are dropped; the device specs still hold
+// the state of their pods.
+func (q *podQueue) shutdown() {
+	q.queue.ShutDownWithDrain()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for deviceID, ops := range q.pending {
+		logger.Warn("Dropping %d pod operation(s) for device %s on shutdown", len(ops), deviceID)
+	}
+}
+
+// retryablePodError reports whether a pod operation failed on an error that
+// may go away: FlightCtl or the device being unavailable, a conflicting
+// device update, or a network failure.
+func retryablePodError(err error) bool {
+	var fcErr *flightctl.FlightctlError
+	if errors.As(err, &fcErr) {
+		switch {
+		case errors.Is(fcErr, flightctl.ErrUnavailable), errors.Is(fcErr, flightctl.ErrConflict),
+			errors.Is(fcErr, flightctl.ErrDeviceOffline):
+			return true
+		default:
+			return fcErr.StatusCode == http.StatusTooManyRequests || fcErr.StatusCode >= http.StatusInternalServerError
+		}
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
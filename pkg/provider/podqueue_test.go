@@ -0,0 +1,163 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func TestSlowPodOperationsContinueInBackground(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1"})
+	p.podQueue.wait = time.Millisecond
+	ctx := context.Background()
+
+	// The first deployment attempt fails on conflicts, after the callback
+	// stopped waiting, and is retried
+	server.InjectFailure(fake.Failure{Method: http.MethodPut, PathPrefix: "/api/v1/devices/d1", StatusCode: http.StatusConflict, Count: 3})
+	if err := p.CreatePod(ctx, cpuPod("web", "100m")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	waitFor(t, "the retried deployment", func() bool {
+		return len(fetchDevice(t, server, "d1").Spec.Applications) == 1
+	})
+	waitFor(t, "the pod to be tracked as deployed", func() bool {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		mapping := p.podMappings["default/web"]
+		return mapping != nil && !mapping.InFlight
+	})
+
+	// A deployment failing on an error that is not transient fails the pod
+	server.InjectFailure(fake.Failure{Method: http.MethodPut, PathPrefix: "/api/v1/devices/d1", StatusCode: http.StatusForbidden, Count: 1})
+	if err := p.CreatePod(ctx, cpuPod("api", "100m")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	var status *corev1.PodStatus
+	waitFor(t, "the pod to fail", func() bool {
+		var err error
+		status, err = p.GetPodStatus(ctx, "default", "api")
+		return err == nil && status.Phase == corev1.PodFailed
+	})
+	if status.Reason != "DeploymentFailed" {
+		t.Errorf("reason = %q, want DeploymentFailed", status.Reason)
+	}
+
+	// Deleting the pods in the background removes them once done
+	for _, name := range []string{"web", "api"} {
+		if err := p.DeletePod(ctx, cpuPod(name, "100m")); err != nil {
+			t.Fatalf("DeletePod %s: %v", name, err)
+		}
+	}
+	waitFor(t, "the pods to be removed", func() bool {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return len(p.podMappings) == 0
+	})
+	if apps := fetchDevice(t, server, "d1").Spec.Applications; len(apps) != 0 {
+		t.Errorf("applications left on the device: %+v", apps)
+	}
+}
+
+func TestPodOperationsRunInQueuedOrder(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1"})
+	p.podQueue.wait = time.Millisecond
+	ctx := context.Background()
+
+	// The deployment fails in the background and waits to be retried when
+	// the pod is deleted, so both run in the same round of the device
+	server.InjectFailure(fake.Failure{Method: http.MethodPut, PathPrefix: "/api/v1/devices/d1", StatusCode: http.StatusConflict, Count: 3})
+	if err := p.CreatePod(ctx, cpuPod("web", "100m")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	waitFor(t, "the deployment to be queued for a retry", func() bool {
+		p.podQueue.mu.Lock()
+		defer p.podQueue.mu.Unlock()
+		return len(p.podQueue.pending["d1"]) == 1
+	})
+	if err := p.DeletePod(ctx, cpuPod("web", "100m")); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+
+	waitFor(t, "the pod to be removed", func() bool {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return len(p.podMappings) == 0
+	})
+	if apps := fetchDevice(t, server, "d1").Spec.Applications; len(apps) != 0 {
+		t.Errorf("applications left on the device for the deleted pod: %+v", apps)
+	}
+}
+
+func TestCreatePodReadsFlightCtlWithoutTheLock(t *testing.T) {
+	// Placing the pod reads the fleet and its devices, each taking 300ms
+	server := fake.NewServer(fake.WithLatency(300 * time.Millisecond))
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("d1", "edge", nil)
+
+	p := newTestProvider(t, server, Config{NodeName: "vk-test", DefaultFleet: "edge"})
+	p.podQueue.wait = time.Millisecond
+
+	created := make(chan error, 1)
+	go func() { created <- p.CreatePod(context.Background(), cpuPod("web", "100m")) }()
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	p.mu.Lock()
+	waited := time.Since(start)
+	p.mu.Unlock()
+	if waited > 100*time.Millisecond {
+		t.Errorf("took the lock after %s while CreatePod read FlightCtl, want it free", waited)
+	}
+	if err := <-created; err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+}
+
+func TestFailedPodOperationIsReturnedWhileWaited(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1"})
+
+	server.InjectFailure(fake.Failure{Method: http.MethodPut, PathPrefix: "/api/v1/devices/d1", StatusCode: http.StatusForbidden, Count: 1})
+	if err := p.CreatePod(context.Background(), cpuPod("web", "100m")); err == nil {
+		t.Fatal("expected the deployment error")
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if _, ok := p.podMappings["default/web"]; ok {
+		t.Error("pod whose deployment failed is still tracked")
+	}
+}
+
+func TestRetryablePodError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("deploying: %w", flightctl.ErrUnavailable), true},
+		{flightctl.ErrConflict, true},
+		{&flightctl.FlightctlError{Code: "HTTPError", StatusCode: http.StatusTooManyRequests}, true},
+		{&flightctl.FlightctlError{Code: "HTTPError", StatusCode: http.StatusBadRequest}, false},
+		{flightctl.ErrUnauthorized, false},
+		{flightctl.ErrUnmanagedApplication, false},
+		{fmt.Errorf("translating pod"), false},
+	} {
+		if got := retryablePodError(tc.err); got != tc.want {
+			t.Errorf("retryablePodError(%v) = %t, want %t", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// ignorePowerLimitsAnnotation lets a pod be placed on devices beyond the
+// battery and temperature limits, e.g. a pod that shuts a hot device's
+// workloads down.
+const ignorePowerLimitsAnnotation = "flightctl.io/ignore-power-limits"
+
+// Node conditions reporting devices beyond the power limits.
+const (
+	NodeBatteryLow      corev1.NodeConditionType = "DeviceBatteryLow"
+	NodeThermalPressure corev1.NodeConditionType = "DeviceThermalPressure"
+)
+
+// powerLimits keep new pods off devices whose battery charge is below
+// minBattery percent, or whose temperature is above maxTemperature degrees
+// Celsius. A zero limit is not checked.
+type powerLimits struct {
+	minBattery     float64
+	maxTemperature float64
+}
+
+func (l powerLimits) enabled() bool {
+	return l.minBattery > 0 || l.maxTemperature > 0
+}
+
+// batteryLow reports whether the device's battery charge is below the limit.
+func (l powerLimits) batteryLow(device *models.Device) bool {
+	return l.minBattery > 0 && device.Health.Battery != nil && *device.Health.Battery < l.minBattery
+}
+
+// overheating reports whether the device's temperature is above the limit.
+func (l powerLimits) overheating(device *models.Device) bool {
+	return l.maxTemperature > 0 && device.Health.Temperature != nil && *device.Health.Temperature > l.maxTemperature
+}
+
+// check returns an error if the pod may not be placed on the device under
+// the limits.
+func (l powerLimits) check(pod *corev1.Pod, device *models.Device) error {
+	if strings.EqualFold(pod.Annotations[ignorePowerLimitsAnnotation], "true") {
+		return nil
+	}
+	if l.batteryLow(device) {
+		return fmt.Errorf("device battery at %g%%, below %g%%", *device.Health.Battery, l.minBattery)
+	}
+	if l.overheating(device) {
+		return fmt.Errorf("device temperature at %g°C, above %g°C", *device.Health.Temperature, l.maxTemperature)
+	}
+	return nil
+}
+
+// Name and Score make the limits a DeviceScorer vetoing the devices beyond
+// them, with no score of their own.
+func (l powerLimits) Name() string { return "power-limits" }
+
+func (l powerLimits) Score(device *models.Device, pod *corev1.Pod, _ map[string][]*corev1.Pod) (int, error) {
+	return 0, l.check(pod, device)
+}
+
+// apply adds the node's DeviceBatteryLow and DeviceThermalPressure
+// conditions for the limits that are checked: true if every one of the
+// devices is beyond the limit. The message is prefixed with subject.
+func (l powerLimits) apply(node *corev1.Node, devices []*models.Device, subject string) {
+	if !l.enabled() || len(devices) == 0 {
+		return
+	}
+	every := func(beyond func(*models.Device) bool) bool {
+		for _, device := range devices {
+			if !beyond(device) {
+				return false
+			}
+		}
+		return true
+	}
+	addCondition := func(condType corev1.NodeConditionType, enabled, beyond bool, reason, message string) {
+		if !enabled {
+			return
+		}
+		status := corev1.ConditionFalse
+		if beyond {
+			status = corev1.ConditionTrue
+		} else {
+			reason, message = "DeviceWithinLimits", ""
+		}
+		node.Status.Conditions = append(node.Status.Conditions, corev1.NodeCondition{
+			Type:               condType,
+			Status:             status,
+			LastHeartbeatTime:  metav1.Now(),
+			LastTransitionTime: metav1.Now(),
+			Reason:             reason,
+			Message:            message,
+		})
+	}
+	addCondition(NodeBatteryLow, l.minBattery > 0, every(l.batteryLow), "DeviceBatteryLow",
+		fmt.Sprintf("%s a battery charge below %g%%", subject, l.minBattery))
+	addCondition(NodeThermalPressure, l.maxTemperature > 0, every(l.overheating), "DeviceOverheating",
+		fmt.Sprintf("%s a temperature above %g°C", subject, l.maxTemperature))
+}
@@ -0,0 +1,72 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func TestPowerLimitsKeepPodsOffDevices(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("drained", "edge", map[string]string{flightctl.BatteryLabel: "10"})
+	server.AddDevice("hot", "edge", map[string]string{flightctl.TemperatureLabel: "85"})
+	server.AddDevice("mains", "edge", nil)
+	p := newTestProvider(t, server, Config{NodeName: "fleet-edge", FleetID: "edge"})
+	p.power = powerLimits{minBattery: 20, maxTemperature: 70}
+	ctx := context.Background()
+
+	for _, name := range []string{"a", "b"} {
+		if err := p.CreatePod(ctx, cpuPod(name, "100m")); err != nil {
+			t.Fatalf("CreatePod %s: %v", name, err)
+		}
+		if device := p.placedDevice(t, "default/"+name); device != "mains" {
+			t.Errorf("pod %s placed on %s, want the device within the power limits", name, device)
+		}
+	}
+
+	pinned := cpuPod("pinned", "100m")
+	pinned.Annotations = map[string]string{deviceIDAnnotation: "hot"}
+	if err := p.CreatePod(ctx, pinned); err == nil || !strings.Contains(err.Error(), "temperature") {
+		t.Errorf("CreatePod of a pod pinned to the hot device error = %v, want the temperature limit", err)
+	}
+	pinned.Annotations[ignorePowerLimitsAnnotation] = "true"
+	if err := p.CreatePod(ctx, pinned); err != nil {
+		t.Errorf("CreatePod of a pod ignoring the power limits: %v", err)
+	}
+}
+
+func TestDeviceNodePowerConditions(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "edge", map[string]string{flightctl.BatteryLabel: "12%", flightctl.TemperatureLabel: "40"})
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	p.power = powerLimits{minBattery: 20, maxTemperature: 70}
+	p.SetDevice(fetchDevice(t, server, "device-1").ToModel())
+
+	node, err := p.GetNode(context.Background())
+	if err != nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	want := map[corev1.NodeConditionType]corev1.ConditionStatus{
+		NodeBatteryLow:      corev1.ConditionTrue,
+		NodeThermalPressure: corev1.ConditionFalse,
+	}
+	for _, cond := range node.Status.Conditions {
+		if status, ok := want[cond.Type]; ok {
+			if cond.Status != status {
+				t.Errorf("condition %s = %s (%s), want %s", cond.Type, cond.Status, cond.Reason, status)
+			}
+			delete(want, cond.Type)
+		}
+	}
+	if len(want) > 0 {
+		t.Errorf("missing node conditions %v", want)
+	}
+}
@@ -0,0 +1,188 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// Translation preview modes: how the application a pod was translated to is
+// shown on the pod, so users can see what was sent to its device without
+// access to FlightCtl. Secrets are masked like in the logs, and so are all
+// values resolved from the pod's Secrets.
+const (
+	// TranslationPreviewNone shows nothing.
+	TranslationPreviewNone = "none"
+	// TranslationPreviewAnnotations (default) sets the
+	// TranslationHashAnnotation and a truncated
+	// TranslationPreviewAnnotation.
+	TranslationPreviewAnnotations = "annotations"
+	// TranslationPreviewConfigMap also writes the full content to a
+	// ConfigMap owned by the pod, named in the
+	// TranslationConfigMapAnnotation.
+	TranslationPreviewConfigMap = "configmap"
+)
+
+const (
+	// TranslationHashAnnotation is the content hash of the pod's
+	// application, as found in its VK_FLIGHTCTL_CONTENT_HASH env var on the
+	// device.
+	TranslationHashAnnotation = "flightctl.io/translation-hash"
+	// TranslationPreviewAnnotation is the start of the pod's application:
+	// its inline files (e.g. the compose file or quadlet units), image or
+	// repository.
+	TranslationPreviewAnnotation = "flightctl.io/translation-preview"
+	// TranslationConfigMapAnnotation names the ConfigMap holding the full
+	// application of the pod.
+	TranslationConfigMapAnnotation = "flightctl.io/translation-configmap"
+
+	// translationPreviewLimit is how many bytes of the application the
+	// preview annotation holds.
+	translationPreviewLimit = 2048
+	// translationConfigMapSuffix is appended to the pod name to name its
+	// ConfigMap.
+	translationConfigMapSuffix = "-flightctl-app"
+)
+
+// previewTranslation shows the application a pod was translated to on the
+// pod. It is called once the application was written to a device, or found
+// unchanged on it. Failures are only logged.
+func (p *Provider) previewTranslation(ctx context.Context, pod *corev1.Pod, deviceID string, app flightctl.FlightctlApplication) {
+	if p.translationPreview == TranslationPreviewNone || p.kubeClient == nil {
+		return
+	}
+	app = app.RedactedFor(pod)
+	content := renderApplication(app)
+	annotations := map[string]string{
+		TranslationHashAnnotation:    app.ContentHash(),
+		TranslationPreviewAnnotation: truncatePreview(content, translationPreviewLimit),
+	}
+	if p.translationPreview == TranslationPreviewConfigMap {
+		name, err := p.writeTranslationConfigMap(ctx, pod, deviceID, app, content)
+		if err != nil {
+			logger.FromContext(ctx).Warn("Writing the application of pod %s/%s to a ConfigMap: %v", pod.Namespace, pod.Name, err)
+		} else {
+			annotations[TranslationConfigMapAnnotation] = name
+		}
+	}
+	p.annotatePod(ctx, pod, annotations)
+}
+
+// writeTranslationConfigMap creates or updates the ConfigMap holding the
+// application of a pod, and returns its name. The ConfigMap is owned by the
+// pod, so it is deleted with it. Inline files are stored under their paths,
+// other applications as a whole.
+func (p *Provider) writeTranslationConfigMap(ctx context.Context, pod *corev1.Pod, deviceID string, app flightctl.FlightctlApplication, content string) (string, error) {
+	name := translationConfigMapName(pod.Name)
+	data := make(map[string]string, len(app.Inline))
+	for _, file := range app.Inline {
+		data[configMapKey(file.Path)] = file.Content
+	}
+	if len(data) == 0 {
+		data["application"] = content
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: pod.Namespace,
+			Annotations: map[string]string{
+				TranslationHashAnnotation:   app.ContentHash(),
+				deviceIDAnnotation:          deviceID,
+				flightctl.AppTypeAnnotation: app.AppType,
+			},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       pod.Name,
+				UID:        pod.UID,
+			}},
+		},
+		Data: data,
+	}
+
+	configMaps := p.kubeClient.CoreV1().ConfigMaps(pod.Namespace)
+	_, err := configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// renderApplication returns the application as text: its inline files
+// under their paths, or the image or repository it runs, followed by its
+// env vars.
+func renderApplication(app flightctl.FlightctlApplication) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# application %s (%s)\n", app.Name, app.AppType)
+	if app.Image != "" {
+		fmt.Fprintf(&b, "image: %s\n", app.Image)
+	}
+	if app.GitRef != nil {
+		fmt.Fprintf(&b, "repository: %s\nrevision: %s\npath: %s\n", app.GitRef.Repository, app.GitRef.TargetRevision, app.GitRef.Path)
+	}
+	for _, file := range app.Inline {
+		fmt.Fprintf(&b, "# %s\n%s", file.Path, file.Content)
+		if !strings.HasSuffix(file.Content, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	if len(app.EnvVars) > 0 {
+		names := make([]string, 0, len(app.EnvVars))
+		for name := range app.EnvVars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		b.WriteString("# env\n")
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s=%s\n", name, app.EnvVars[name])
+		}
+	}
+	return b.String()
+}
+
+// truncatePreview cuts text to at most limit bytes, at a rune boundary, and
+// notes how long it was.
+func truncatePreview(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s\n... (truncated, %d bytes in total)", text[:cut], len(text))
+}
+
+// translationConfigMapName returns the name of the ConfigMap holding the
+// application of a pod, within the 253 characters of an object name.
+func translationConfigMapName(podName string) string {
+	if max := 253 - len(translationConfigMapSuffix); len(podName) > max {
+		podName = strings.TrimRight(podName[:max], "-.")
+	}
+	return podName + translationConfigMapSuffix
+}
+
+var invalidConfigMapKeyChars = regexp.MustCompile(`[^-._a-zA-Z0-9]+`)
+
+// configMapKey turns a file path into a valid ConfigMap key, e.g.
+// /etc/app/config.yaml into etc_app_config.yaml.
+func configMapKey(path string) string {
+	key := invalidConfigMapKeyChars.ReplaceAllString(strings.TrimLeft(path, "/"), "_")
+	if key == "" || key == "." || key == ".." {
+		key = "content"
+	}
+	return key
+}
@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/redact"
+)
+
+func TestRenderApplication(t *testing.T) {
+	app := flightctl.FlightctlApplication{
+		Name:    "default-web",
+		AppType: flightctl.AppTypeCompose,
+		Inline: []flightctl.InlineContent{
+			{Path: "docker-compose.yaml", Content: "services:\n  app:\n    environment:\n      DB_PASSWORD: hunter2\n"},
+		},
+		EnvVars: map[string]string{"API_TOKEN": "t0ken", "MODE": "edge"},
+	}.Redacted()
+
+	got := renderApplication(app)
+	for _, want := range []string{
+		"# application default-web (compose)\n",
+		"# docker-compose.yaml\nservices:\n",
+		"MODE=edge\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("rendered application misses %q:\n%s", want, got)
+		}
+	}
+	for _, secret := range []string{"hunter2", "t0ken"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("rendered application shows the secret %q:\n%s", secret, got)
+		}
+	}
+}
+
+func TestPreviewMasksValuesResolvedFromSecrets(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1"})
+	_, secrets := configStores(p)
+	_ = secrets.Add(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+		Data: map[string][]byte{"url": []byte("postgres://admin:hunter2@db"), "host": []byte("db.internal")}})
+	// Secret env files are masked even when no file pattern matches them
+	if err := redact.SetFilePatterns("none"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = redact.SetFilePatterns(redact.DefaultFilePatterns) }()
+
+	// Neither variable name matches an env pattern
+	pod := cpuPod("web", "100m")
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{
+		{Name: "MODE", Value: "edge"},
+		{Name: "DB_URL", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "db"}, Key: "url"}}},
+	}
+	pod.Spec.Containers[0].EnvFrom = []corev1.EnvFromSource{
+		{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "db"}}},
+	}
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	resolved, err := p.resolveConfigRefs(pod)
+	if err != nil {
+		t.Fatalf("resolveConfigRefs: %v", err)
+	}
+
+	got := renderApplication(fetchDevice(t, server, "d1").Spec.Applications[0].RedactedFor(resolved))
+	if !strings.Contains(got, "MODE=edge") || !strings.Contains(got, "DB_URL=***") {
+		t.Errorf("preview does not show MODE and mask DB_URL:\n%s", got)
+	}
+	for _, secret := range []string{"hunter2", "db.internal"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("preview shows the Secret value %q:\n%s", secret, got)
+		}
+	}
+}
+
+func TestTruncatePreview(t *testing.T) {
+	if got := truncatePreview("short", 10); got != "short" {
+		t.Errorf("truncatePreview(short) = %q, want it unchanged", got)
+	}
+	// The cut must not split the two-byte é
+	got := truncatePreview("abcé"+strings.Repeat("x", 10), 4)
+	if want := "abc\n... (truncated, 15 bytes in total)"; got != want {
+		t.Errorf("truncatePreview = %q, want %q", got, want)
+	}
+}
+
+func TestTranslationConfigMapNaming(t *testing.T) {
+	if got := translationConfigMapName("web"); got != "web-flightctl-app" {
+		t.Errorf("translationConfigMapName(web) = %q", got)
+	}
+	if got := translationConfigMapName(strings.Repeat("a", 253)); len(got) > 253 {
+		t.Errorf("translationConfigMapName of a long pod name has %d characters", len(got))
+	}
+	for path, want := range map[string]string{
+		"docker-compose.yaml":      "docker-compose.yaml",
+		"/etc/containers/app.kube": "etc_containers_app.kube",
+		"/":                        "content",
+	} {
+		if got := configMapKey(path); got != want {
+			t.Errorf("configMapKey(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -2,36 +2,153 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
+	"net"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
-	dto "github.com/prometheus/client_model/go"
-	"github.com/virtual-kubelet/virtual-kubelet/node/api"
-	"github.com/virtual-kubelet/virtual-kubelet/node/api/statsv1alpha1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 
+	"github.com/raycarroll/vk-flightctl-provider/pkg/audit"
 	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
 	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
 	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/tracing"
 )
 
 // Provider implements the Virtual Kubelet provider interface.
 type Provider struct {
 	nodeName   string
-	flightctl  *flightctl.Client
-	podManager *flightctl.PodManager
+	flightctl  flightctl.FlightctlClient
+	podManager flightctl.WorkloadManager
+	// Application types pods are translated to
+	translators *flightctl.TranslatorRegistry
+	// How the applications of pods are named
+	appNaming flightctl.AppNaming
 
 	// Pod tracking
-	podMappings map[string]*models.PodDeviceMapping // podKey -> mapping
-	mu          sync.RWMutex
+	podMappings  map[string]*models.PodDeviceMapping // podKey -> mapping
+	retainedPods map[string]*retainedPod             // podKey -> last state of deleted pods
+	mu           sync.RWMutex
 
 	// Status reconciliation
-	reconcileCtx    context.Context
-	reconcileCancel context.CancelFunc
+	reconcileCtx     context.Context
+	reconcileCancel  context.CancelFunc
+	reconcileQueue   workqueue.RateLimitingInterface // Devices whose pods' status is due
+	reconcileCycle   reconcileCycle                  // Devices of the last reconcile interval not done yet
+	reconcileWorkers int
+	spreadStatuses   map[string]map[string]spreadDeviceStatus // podKey -> deviceID -> status of spread pods
+	fleetRollouts    map[string]models.FleetRollout           // fleetID -> rollout of fleet-rolled-out pods
+	loops            sync.WaitGroup                           // Background loops, done once they stopped
+
+	// Pod operations waiting for their device
+	podQueue   *podQueue
+	podWorkers int
+
+	// How often the devices of a terminating pod are read
+	terminationPollInterval time.Duration
+	// How long the applications of completed pods are kept
+	completedPodRetention time.Duration
+	// How long the final statuses of terminated pods are kept
+	terminatedPodRetention time.Duration
+	// How often the node status is pushed, and the node's informers resync
+	nodeHeartbeatInterval time.Duration
+	informerResyncPeriod  time.Duration
+
+	// Runtime settings, reloadable via UpdateTunables
+	tunables                  Tunables
+	reconcileIntervalChanged  chan struct{}
+	disconnectIntervalChanged chan struct{}
+
+	// Node and placement settings
+	nodeLabels       map[string]string
+	nodeAnnotations  map[string]string
+	nodeTaints       []corev1.Taint
+	nodeResources    map[corev1.ResourceName]resource.Quantity
+	topologyLabels   map[string]string
+	providerIDFormat string
+	nodeIP           string
+	kubeletPort      int32
+	defaultFleet     string
+
+	// Device labels copied onto the node
+	deviceLabelPrefixes    []string
+	deviceLabelConflicts   string
+	deviceLabelInterval    time.Duration // Zero if the single node does not list them
+	singleNodeDeviceLabels map[string]string
+
+	// Pinned modes: the node represents a single device or fleet
+	deviceID     string
+	device       *models.Device
+	fleetID      string
+	fleet        *models.Fleet
+	fleetDevices []*models.Device
+	nodeCallback func(*corev1.Node)
+	nodeCordoned *bool
+
+	// Device disconnection handling
+	disconnects          map[string]*models.TimeoutTracker // deviceID -> tracker
+	disconnectAction     string
+	offlineDeploymentTTL time.Duration
+
+	eventRecorder record.EventRecorder
+	kubeClient    kubernetes.Interface
+	podValidation string
+	// How translated applications are shown on pods
+	translationPreview string
+	auditTrail         *audit.Trail
+	dryRun             bool
+	// PodDeviceMapping records of the pods, nil if not written
+	mappingRecords *mappingRecords
+	// Orphaned applications found on startup are kept on their devices
+	keepOrphanedApps bool
+
+	// Namespaces whose pods may not run privileged containers
+	denyPrivileged []string
+	// Devices the pods of each namespace may run on, nil if unrestricted
+	deviceAccess DeviceAccessPolicy
+	// Resources the pods of each namespace may request on the node
+	quotas map[string]NamespaceQuota
+	// Digests the images of pods were pinned to, nil if images are
+	// deployed by tag
+	imagePins *imagePins
+	// Recent logs of the containers of tracked pods, nil if not cached
+	logCache *logCache
+
+	// Scales device capacity into the resources pods may request
+	overcommit models.OvercommitRatio
+	placement  models.PlacementStrategy
+	// Scorers picking among the devices a pod fits on, nil if unused
+	scorers []WeightedScorer
+	power   powerLimits
+
+	// FlightCtl API outage handling
+	apiOutageNodeTimeout time.Duration
+	nodeAPIOutage        bool // The node was last reported NotReady for an outage
+
+	// Enrollment requests awaiting approval, sorted by name
+	enrollmentCheckInterval time.Duration // Zero if not checked
+	enrollmentEvents        bool
+	enrollmentsPending      []string
+	enrollmentsChecked      bool
+
+	// Sources of the ConfigMap and Secret keys pods refer to
+	configMaps corev1listers.ConfigMapLister
+	secrets    corev1listers.SecretLister
+
+	// Cumulative CPU time of the node and pods, for stats and metrics
+	cpuTime *cpuTimeCounters
 }
 
 // Config holds provider configuration.
@@ -42,6 +159,450 @@ type Config struct {
 	FlightctlClientSecret string
 	FlightctlTokenURL     string
 	FlightctlInsecureTLS  bool
+
+	// FlightctlAuthMode selects oauth (default), token, token-file or none
+	// authentication; see flightctl.Config.
+	FlightctlAuthMode     string
+	FlightctlRefreshToken string
+	FlightctlToken        string
+	FlightctlTokenFile    string
+
+	// Mutual TLS client certificate and CA bundle files, reloaded on change.
+	FlightctlClientCertFile string
+	FlightctlClientKeyFile  string
+	FlightctlCAFile         string
+	// FlightctlCAData is an inline PEM CA bundle, combined with FlightctlCAFile.
+	FlightctlCAData []byte
+
+	// FlightctlRetry controls retries of transient FlightCtl API failures.
+	FlightctlRetry flightctl.RetryPolicy
+	// FlightctlBreaker controls pausing FlightCtl API calls while it is
+	// down.
+	FlightctlBreaker flightctl.BreakerPolicy
+	// FlightctlTimeouts bound each FlightCtl API call by kind of operation,
+	// so a hung call fails instead of blocking reconciliation.
+	FlightctlTimeouts flightctl.OperationTimeouts
+	// FlightctlDeviceCacheTTL is how long device reads, and the pod status
+	// read from them, are cached; 0 disables the cache.
+	FlightctlDeviceCacheTTL time.Duration
+	// FlightctlRecordFile records the FlightCtl API traffic to a file, and
+	// FlightctlReplayFile serves the API responses from such a recording;
+	// see flightctl.Config.
+	FlightctlRecordFile string
+	FlightctlReplayFile string
+
+	// DryRun translates, places and validates pods as usual, but device
+	// updates are logged as a diff instead of written to FlightCtl (see
+	// flightctl.Config.DryRun) and pods get a DryRun event. The pods never
+	// start, and are not rolled back.
+	DryRun bool
+
+	// DefaultAppType is the FlightCtl application type used for pods without
+	// a flightctl.io/app-type annotation (defaults to compose).
+	DefaultAppType string
+
+	// ComposeFile names the compose file of compose applications (defaults
+	// to flightctl.DefaultComposeFile). Pods can name another with the
+	// flightctl.io/compose-file annotation.
+	ComposeFile string
+
+	// AppNaming selects how the FlightCtl applications of pods are named
+	// (defaults to flightctl.AppNamingPodName).
+	AppNaming flightctl.AppNaming
+
+	// DeviceReconnectTimeout is how long pods on a disconnected device stay
+	// NotReady before DisconnectAction is applied (1m-30m, default 5m).
+	DeviceReconnectTimeout time.Duration
+
+	// DisconnectAction is "reschedule" (default) or "fail".
+	DisconnectAction string
+
+	// OfflineDeploymentTTL holds pods whose device is offline when they are
+	// created until the device reconnects, instead of writing them to the
+	// device right away; a pod whose device does not reconnect within the
+	// TTL of the pod's creation fails. 0 disables holding.
+	OfflineDeploymentTTL time.Duration
+
+	// ReconcileInterval is how often pod status is polled from FlightCtl
+	// (default 15s).
+	ReconcileInterval time.Duration
+	// ReconcileJitter spreads the status refreshes of devices over up to
+	// this long after each interval (less than the interval, default a
+	// tenth of it).
+	ReconcileJitter time.Duration
+	// ReconcileWorkers is how many devices are refreshed concurrently
+	// (default 4).
+	ReconcileWorkers int
+	// PodWorkers is how many devices' pod operations run concurrently
+	// (default 8).
+	PodWorkers int
+	// DisconnectCheckInterval is how often device connectivity is checked
+	// (default 30s).
+	DisconnectCheckInterval time.Duration
+	// DeploymentReadyTimeout is how long a created or updated pod's
+	// application has to start running before it is rolled back (at least
+	// 1m, default 10m).
+	DeploymentReadyTimeout time.Duration
+	// DeviceRefreshInterval is how often the devices or fleets that get a
+	// node of their own are listed, and their nodes refreshed, and how often
+	// the single node lists the devices to refresh its device labels (at
+	// least 1s, default 30s).
+	DeviceRefreshInterval time.Duration
+	// InformerResyncPeriod is how often the pod informers of the node
+	// resync (at least 1s, default 30s).
+	InformerResyncPeriod time.Duration
+	// NodeHeartbeatInterval is how often the node status is pushed to the
+	// node controller, refreshing the heartbeat time of its conditions (at
+	// least 1s, default 1m).
+	NodeHeartbeatInterval time.Duration
+
+	// NodeLabels are added to the virtual node's labels.
+	NodeLabels map[string]string
+	// NodeAnnotations are added to the virtual node's annotations.
+	NodeAnnotations map[string]string
+	// NodeTaints replace the virtual node's taints; nil keeps
+	// DefaultNodeTaint and an empty list leaves the node untainted.
+	NodeTaints []corev1.Taint
+	// NodeExtendedResources are advertised as the capacity of the single
+	// virtual node, so the scheduler places pods requesting them on it (see
+	// ParseNodeExtendedResources). Nodes representing a device or fleet
+	// advertise those their devices declare instead.
+	NodeExtendedResources map[corev1.ResourceName]resource.Quantity
+	// TopologyLabels maps topology keys, set on the nodes of devices and of
+	// fleets whose devices share the value, to the device labels they are
+	// read from. Pod topology spread constraints keyed on them are applied
+	// across devices. Nil uses DefaultTopologyLabels.
+	TopologyLabels map[string]string
+	// DeviceLabelPrefixes selects the device labels copied as they are onto
+	// the single node and fleet nodes: those whose key starts with one of
+	// the prefixes and that all devices setting them agree on. Device nodes
+	// copy all the labels of their device.
+	DeviceLabelPrefixes []string
+	// DeviceLabelConflicts is what happens to device labels the node
+	// already has: DeviceLabelConflictKeepNode (default) or
+	// DeviceLabelConflictPreferDevice. Labels of the kubernetes.io and
+	// k8s.io domains are never taken from devices.
+	DeviceLabelConflicts string
+	// NodeIP is the address the provider serves the kubelet API on, usually
+	// its pod IP. It is the node's InternalIP unless the node represents a
+	// device, whose addresses are reported instead.
+	NodeIP string
+	// KubeletPort is the port of the kubelet API served for the node,
+	// reported in its daemon endpoints so the API server can reach it for
+	// logs and exec. Zero if the kubelet API is not served.
+	KubeletPort int32
+	// ProviderIDFormat sets the node's spec.providerID, with {node},
+	// {device} and {fleet} replaced by the node name and the device and
+	// fleet it represents (empty if none). Empty leaves it unset.
+	ProviderIDFormat string
+	// DefaultFleet is the fleet used for pods without a device, fleet or
+	// device label selector. Empty keeps the built-in default device.
+	DefaultFleet string
+
+	// DeviceID pins the provider to a single device: every pod on the node
+	// is deployed to it and the node reports the device's labels, capacity
+	// and readiness (see SetDevice). Used to run one node per device.
+	DeviceID string
+	// PodValidation is how pods using features that are dropped in the
+	// translation to a FlightCtl application are handled:
+	// PodValidationPermissive (default) or PodValidationStrict.
+	PodValidation string
+	// TranslationPreview is how the application each pod was translated to
+	// is shown on the pod: TranslationPreviewAnnotations (default),
+	// TranslationPreviewConfigMap or TranslationPreviewNone.
+	TranslationPreview string
+	// DenyPrivilegedNamespaces lists the namespaces whose pods may not run
+	// privileged containers; "*" matches all namespaces.
+	DenyPrivilegedNamespaces []string
+	// DeviceAccessPolicy limits the devices the pods of each namespace may
+	// be deployed to; nil allows all devices.
+	DeviceAccessPolicy DeviceAccessPolicy
+	// NamespaceQuotas limit the resources the pods of each namespace may
+	// request on the node, keyed by namespace or AnyNamespace for the
+	// quota of each namespace without its own (see ParseNamespaceQuotas).
+	NamespaceQuotas map[string]NamespaceQuota
+	// ImageDigestResolver, if set, resolves the image tags of pods to
+	// digests when they are first deployed, and the pods are deployed with
+	// their images pinned to them.
+	ImageDigestResolver DigestResolver
+	// LogCacheSize is how many bytes of the recent logs of each running
+	// container are cached, to serve them while their device cannot be
+	// reached; 0 disables the cache.
+	LogCacheSize int
+
+	// CPUOvercommitRatio and MemoryOvercommitRatio scale the capacity of
+	// devices into the resources pods may request on them (default 1, no
+	// overcommit).
+	CPUOvercommitRatio    float64
+	MemoryOvercommitRatio float64
+	// PlacementStrategy picks the device among those a pod fits on (see
+	// models.PlacementStrategies; default models.PlacementMostFree). Pods
+	// can override it with the flightctl.io/placement-strategy annotation.
+	PlacementStrategy string
+	// DeviceScorers score the devices a pod fits on, and the pod is placed
+	// on the one with the highest weighted score, the placement strategy
+	// breaking ties. A scorer can veto a device. Pods pinned to a device
+	// are not scored. See ParseDeviceScorers for the built-in scorers.
+	DeviceScorers []WeightedScorer
+	// MinDeviceBattery is the battery charge in percent below which a
+	// device gets no new pods, and MaxDeviceTemperature the temperature in
+	// degrees Celsius above which it gets none (see
+	// flightctl.BatteryInfoKey and flightctl.TemperatureInfoKey); 0 does not
+	// check them. Pods can ignore the limits with the
+	// flightctl.io/ignore-power-limits annotation.
+	MinDeviceBattery     float64
+	MaxDeviceTemperature float64
+
+	// APIOutageNodeTimeout is how long FlightCtl API calls may be paused
+	// by the circuit breaker before the node turns NotReady (default
+	// DefaultAPIOutageNodeTimeout).
+	APIOutageNodeTimeout time.Duration
+
+	// EnrollmentCheckInterval is how often the enrollment requests
+	// awaiting approval are counted (default
+	// DefaultEnrollmentCheckInterval); a negative value disables the
+	// check. Device nodes do not check them.
+	EnrollmentCheckInterval time.Duration
+	// EnrollmentEvents emits an event on the node for each new enrollment
+	// request awaiting approval.
+	EnrollmentEvents bool
+
+	// CompletedPodRetention is how long the applications of completed pods
+	// stay on their devices (default DefaultCompletedPodRetention); a
+	// negative value keeps them until the pod is deleted.
+	CompletedPodRetention time.Duration
+
+	// TerminatedPodRetention is how long a pod keeps its Succeeded or
+	// Failed status once its device stops reporting its application, and
+	// how long the last status of a deleted pod is still served (default
+	// DefaultTerminatedPodRetention); a negative value disables both.
+	TerminatedPodRetention time.Duration
+
+	// AuditTrail records the actions taken for pods, for operators to
+	// inspect; nil disables it. It may be shared by several providers.
+	AuditTrail *audit.Trail
+
+	// MappingStore, if set, keeps a PodDeviceMapping record of each pod,
+	// which also places pods re-created after a restart back on their
+	// device.
+	MappingStore MappingStore
+
+	// KeepOrphanedApps leaves the applications ReconcileStartup finds
+	// without a pod on their devices, instead of removing them.
+	KeepOrphanedApps bool
+
+	// FlightctlMetrics records the requests made to the FlightCtl API, for
+	// operators to scrape; nil disables it. It may be shared by several
+	// providers.
+	FlightctlMetrics *flightctl.TransportMetrics
+
+	// FleetID pins the provider to a single fleet: pods on the node are
+	// placed on devices of the fleet and the node reports the fleet's
+	// aggregate capacity (see SetFleet). Used to run one node per fleet.
+	FleetID string
+}
+
+// Tunables returns the runtime-adjustable part of the configuration.
+func (cfg Config) Tunables() Tunables {
+	return Tunables{
+		ReconcileInterval:       cfg.ReconcileInterval,
+		ReconcileJitter:         cfg.ReconcileJitter,
+		DisconnectCheckInterval: cfg.DisconnectCheckInterval,
+		DeviceReconnectTimeout:  cfg.DeviceReconnectTimeout,
+		DeploymentReadyTimeout:  cfg.DeploymentReadyTimeout,
+	}
+}
+
+// Validate fills in defaults and checks the configuration.
+func (cfg *Config) Validate() error {
+	if cfg.NodeName == "" {
+		return fmt.Errorf("node name is required")
+	}
+
+	tunables := cfg.Tunables()
+	if err := tunables.Validate(); err != nil {
+		return err
+	}
+	cfg.ReconcileInterval = tunables.ReconcileInterval
+	cfg.ReconcileJitter = tunables.ReconcileJitter
+	cfg.DisconnectCheckInterval = tunables.DisconnectCheckInterval
+	cfg.DeviceReconnectTimeout = tunables.DeviceReconnectTimeout
+	cfg.DeploymentReadyTimeout = tunables.DeploymentReadyTimeout
+
+	if cfg.ReconcileWorkers == 0 {
+		cfg.ReconcileWorkers = DefaultReconcileWorkers
+	}
+	if cfg.ReconcileWorkers < 0 {
+		return fmt.Errorf("reconcile workers must be positive, got %d", cfg.ReconcileWorkers)
+	}
+	if cfg.PodWorkers == 0 {
+		cfg.PodWorkers = DefaultPodWorkers
+	}
+	if cfg.PodWorkers < 0 {
+		return fmt.Errorf("pod workers must be positive, got %d", cfg.PodWorkers)
+	}
+
+	if cfg.DeviceRefreshInterval == 0 {
+		cfg.DeviceRefreshInterval = DefaultDeviceRefreshInterval
+	}
+	if cfg.DeviceRefreshInterval < time.Second {
+		return fmt.Errorf("device refresh interval must be at least 1s, got %s", cfg.DeviceRefreshInterval)
+	}
+	if cfg.InformerResyncPeriod == 0 {
+		cfg.InformerResyncPeriod = DefaultInformerResyncPeriod
+	}
+	if cfg.InformerResyncPeriod < time.Second {
+		return fmt.Errorf("informer resync period must be at least 1s, got %s", cfg.InformerResyncPeriod)
+	}
+	if cfg.NodeHeartbeatInterval == 0 {
+		cfg.NodeHeartbeatInterval = DefaultNodeHeartbeatInterval
+	}
+	if cfg.NodeHeartbeatInterval < time.Second {
+		return fmt.Errorf("node heartbeat interval must be at least 1s, got %s", cfg.NodeHeartbeatInterval)
+	}
+
+	if err := validateNodeMetadata(cfg.NodeLabels, cfg.NodeAnnotations, cfg.NodeTaints); err != nil {
+		return err
+	}
+	if cfg.TopologyLabels == nil {
+		cfg.TopologyLabels = DefaultTopologyLabels
+	}
+	if err := validateTopologyLabels(cfg.TopologyLabels); err != nil {
+		return err
+	}
+	if err := validateDeviceLabelPrefixes(cfg.DeviceLabelPrefixes); err != nil {
+		return err
+	}
+	switch cfg.DeviceLabelConflicts {
+	case "":
+		cfg.DeviceLabelConflicts = DeviceLabelConflictKeepNode
+	case DeviceLabelConflictKeepNode, DeviceLabelConflictPreferDevice:
+	default:
+		return fmt.Errorf("unknown device label conflict policy %q (expected %s or %s)",
+			cfg.DeviceLabelConflicts, DeviceLabelConflictKeepNode, DeviceLabelConflictPreferDevice)
+	}
+	if cfg.NodeIP != "" && net.ParseIP(cfg.NodeIP) == nil {
+		return fmt.Errorf("invalid node IP %q", cfg.NodeIP)
+	}
+	if cfg.KubeletPort < 0 {
+		return fmt.Errorf("invalid kubelet port %d", cfg.KubeletPort)
+	}
+
+	if cfg.DeviceID != "" && cfg.FleetID != "" {
+		return fmt.Errorf("a provider cannot be pinned to both a device and a fleet")
+	}
+
+	switch cfg.PodValidation {
+	case "":
+		cfg.PodValidation = PodValidationPermissive
+	case PodValidationPermissive, PodValidationStrict:
+	default:
+		return fmt.Errorf("unknown pod validation mode %q (expected %s or %s)",
+			cfg.PodValidation, PodValidationPermissive, PodValidationStrict)
+	}
+	switch cfg.TranslationPreview {
+	case "":
+		cfg.TranslationPreview = TranslationPreviewAnnotations
+	case TranslationPreviewNone, TranslationPreviewAnnotations, TranslationPreviewConfigMap:
+	default:
+		return fmt.Errorf("unknown translation preview mode %q (expected %s, %s or %s)",
+			cfg.TranslationPreview, TranslationPreviewNone, TranslationPreviewAnnotations, TranslationPreviewConfigMap)
+	}
+
+	if cfg.CPUOvercommitRatio == 0 {
+		cfg.CPUOvercommitRatio = 1
+	}
+	if cfg.MemoryOvercommitRatio == 0 {
+		cfg.MemoryOvercommitRatio = 1
+	}
+	if cfg.CPUOvercommitRatio < 0 || cfg.MemoryOvercommitRatio < 0 {
+		return fmt.Errorf("overcommit ratios must be positive, got cpu=%g memory=%g", cfg.CPUOvercommitRatio, cfg.MemoryOvercommitRatio)
+	}
+	strategy, err := models.ParsePlacementStrategy(cfg.PlacementStrategy)
+	if err != nil {
+		return err
+	}
+	cfg.PlacementStrategy = string(strategy)
+	if cfg.MinDeviceBattery < 0 || cfg.MinDeviceBattery > 100 {
+		return fmt.Errorf("minimum device battery must be between 0 and 100%%, got %g", cfg.MinDeviceBattery)
+	}
+	if cfg.OfflineDeploymentTTL < 0 {
+		return fmt.Errorf("offline deployment TTL must not be negative, got %s", cfg.OfflineDeploymentTTL)
+	}
+	if cfg.MaxDeviceTemperature < 0 {
+		return fmt.Errorf("maximum device temperature must not be negative, got %g", cfg.MaxDeviceTemperature)
+	}
+
+	if cfg.CompletedPodRetention == 0 {
+		cfg.CompletedPodRetention = DefaultCompletedPodRetention
+	}
+	if cfg.TerminatedPodRetention == 0 {
+		cfg.TerminatedPodRetention = DefaultTerminatedPodRetention
+	}
+	if cfg.APIOutageNodeTimeout == 0 {
+		cfg.APIOutageNodeTimeout = DefaultAPIOutageNodeTimeout
+	}
+	if cfg.APIOutageNodeTimeout < 0 {
+		return fmt.Errorf("API outage node timeout must be positive, got %s", cfg.APIOutageNodeTimeout)
+	}
+	if cfg.EnrollmentCheckInterval == 0 {
+		cfg.EnrollmentCheckInterval = DefaultEnrollmentCheckInterval
+	}
+
+	switch cfg.DisconnectAction {
+	case "":
+		cfg.DisconnectAction = DisconnectActionReschedule
+	case DisconnectActionReschedule, DisconnectActionFail:
+	default:
+		return fmt.Errorf("unknown disconnect action %q (expected %s or %s)",
+			cfg.DisconnectAction, DisconnectActionReschedule, DisconnectActionFail)
+	}
+
+	if err := cfg.AppNaming.Validate(); err != nil {
+		return err
+	}
+
+	if cfg.DefaultAppType != "" {
+		appTypes := flightctl.NewTranslatorRegistry("").Types()
+		if !slices.Contains(appTypes, strings.ToLower(cfg.DefaultAppType)) {
+			return fmt.Errorf("unsupported default app type %q (supported: %s)",
+				cfg.DefaultAppType, strings.Join(appTypes, ", "))
+		}
+	}
+	if cfg.ComposeFile != "" {
+		if err := flightctl.ValidateComposeFile(cfg.ComposeFile); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FlightctlConfig returns the FlightCtl client configuration.
+func (cfg Config) FlightctlConfig() flightctl.Config {
+	return flightctl.Config{
+		APIURL:            cfg.FlightctlAPIURL,
+		AuthMode:          cfg.FlightctlAuthMode,
+		ClientID:          cfg.FlightctlClientID,
+		ClientSecret:      cfg.FlightctlClientSecret,
+		TokenURL:          cfg.FlightctlTokenURL,
+		RefreshToken:      cfg.FlightctlRefreshToken,
+		Token:             cfg.FlightctlToken,
+		TokenFile:         cfg.FlightctlTokenFile,
+		ClientCertFile:    cfg.FlightctlClientCertFile,
+		ClientKeyFile:     cfg.FlightctlClientKeyFile,
+		CAFile:            cfg.FlightctlCAFile,
+		CAData:            cfg.FlightctlCAData,
+		InsecureTLS:       cfg.FlightctlInsecureTLS,
+		Retry:             cfg.FlightctlRetry,
+		Breaker:           cfg.FlightctlBreaker,
+		OperationTimeouts: cfg.FlightctlTimeouts,
+		DeviceCacheTTL:    cfg.FlightctlDeviceCacheTTL,
+		Metrics:           cfg.FlightctlMetrics,
+		RecordFile:        cfg.FlightctlRecordFile,
+		ReplayFile:        cfg.FlightctlReplayFile,
+		DryRun:            cfg.DryRun,
+	}
 }
 
 // NewProvider creates a new Virtual Kubelet provider.
@@ -51,153 +612,738 @@ func NewProvider(cfg Config) (*Provider, error) {
 	}
 
 	// Create Flightctl client
-	client, err := flightctl.NewClient(flightctl.Config{
-		APIURL:       cfg.FlightctlAPIURL,
-		ClientID:     cfg.FlightctlClientID,
-		ClientSecret: cfg.FlightctlClientSecret,
-		TokenURL:     cfg.FlightctlTokenURL,
-		InsecureTLS:  cfg.FlightctlInsecureTLS,
-	})
+	client, err := flightctl.NewClient(cfg.FlightctlConfig())
 	if err != nil {
 		return nil, fmt.Errorf("creating Flightctl client: %w", err)
 	}
 
+	return NewProviderWithClient(cfg, client)
+}
+
+// NewProviderWithClient creates a provider backed by an existing FlightCtl
+// client implementation. This allows tests to supply a mock client.
+func NewProviderWithClient(cfg Config, client flightctl.FlightctlClient) (*Provider, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
 	// Create reconciliation context
 	reconcileCtx, reconcileCancel := context.WithCancel(context.Background())
 
 	p := &Provider{
-		nodeName:        cfg.NodeName,
-		flightctl:       client,
-		podManager:      flightctl.NewPodManager(client),
-		podMappings:     make(map[string]*models.PodDeviceMapping),
-		reconcileCtx:    reconcileCtx,
-		reconcileCancel: reconcileCancel,
+		nodeName:         cfg.NodeName,
+		flightctl:        client,
+		podMappings:      make(map[string]*models.PodDeviceMapping),
+		retainedPods:     make(map[string]*retainedPod),
+		reconcileCtx:     reconcileCtx,
+		reconcileCancel:  reconcileCancel,
+		reconcileQueue:   newReconcileQueue(),
+		reconcileWorkers: cfg.ReconcileWorkers,
+		spreadStatuses:   make(map[string]map[string]spreadDeviceStatus),
+		fleetRollouts:    make(map[string]models.FleetRollout),
+		podQueue:         newPodQueue(),
+		podWorkers:       cfg.PodWorkers,
+
+		terminationPollInterval: defaultTerminationPollInterval,
+		completedPodRetention:   cfg.CompletedPodRetention,
+		terminatedPodRetention:  cfg.TerminatedPodRetention,
+		nodeHeartbeatInterval:   cfg.NodeHeartbeatInterval,
+		informerResyncPeriod:    cfg.InformerResyncPeriod,
+
+		tunables:                  cfg.Tunables(),
+		reconcileIntervalChanged:  make(chan struct{}, 1),
+		disconnectIntervalChanged: make(chan struct{}, 1),
+
+		nodeLabels:       cfg.NodeLabels,
+		nodeAnnotations:  cfg.NodeAnnotations,
+		nodeTaints:       cfg.NodeTaints,
+		nodeResources:    cfg.NodeExtendedResources,
+		topologyLabels:   cfg.TopologyLabels,
+		providerIDFormat: cfg.ProviderIDFormat,
+		nodeIP:           cfg.NodeIP,
+		kubeletPort:      cfg.KubeletPort,
+		defaultFleet:     cfg.DefaultFleet,
+		deviceID:         cfg.DeviceID,
+		fleetID:          cfg.FleetID,
+
+		deviceLabelPrefixes:  cfg.DeviceLabelPrefixes,
+		deviceLabelConflicts: cfg.DeviceLabelConflicts,
+
+		disconnects:          make(map[string]*models.TimeoutTracker),
+		disconnectAction:     cfg.DisconnectAction,
+		offlineDeploymentTTL: cfg.OfflineDeploymentTTL,
+
+		cpuTime:          newCPUTimeCounters(),
+		auditTrail:       cfg.AuditTrail,
+		dryRun:           cfg.DryRun,
+		keepOrphanedApps: cfg.KeepOrphanedApps,
+		podValidation:    cfg.PodValidation,
+
+		translationPreview: cfg.TranslationPreview,
+
+		denyPrivileged: cfg.DenyPrivilegedNamespaces,
+		deviceAccess:   cfg.DeviceAccessPolicy,
+		quotas:         cfg.NamespaceQuotas,
+		overcommit:     models.OvercommitRatio{CPU: cfg.CPUOvercommitRatio, Memory: cfg.MemoryOvercommitRatio},
+		placement:      models.PlacementStrategy(cfg.PlacementStrategy),
+		scorers:        cfg.DeviceScorers,
+		power:          powerLimits{minBattery: cfg.MinDeviceBattery, maxTemperature: cfg.MaxDeviceTemperature},
+
+		apiOutageNodeTimeout: cfg.APIOutageNodeTimeout,
+
+		enrollmentEvents: cfg.EnrollmentEvents,
+
+		appNaming: cfg.AppNaming,
+	}
+	if cfg.EnrollmentCheckInterval > 0 && cfg.DeviceID == "" {
+		p.enrollmentCheckInterval = cfg.EnrollmentCheckInterval
+	}
+	if len(cfg.DeviceLabelPrefixes) > 0 && cfg.DeviceID == "" && cfg.FleetID == "" {
+		p.deviceLabelInterval = cfg.DeviceRefreshInterval
 	}
 
-	// Start background status reconciliation loop
-	go p.syncPodStatusLoop()
+	p.translators = flightctl.NewTranslatorRegistry(cfg.DefaultAppType)
+	if cfg.ComposeFile != "" {
+		p.translators.Register(flightctl.AppTypeCompose, flightctl.NewComposeTranslator(cfg.ComposeFile))
+	}
+	podManager := flightctl.NewPodManagerWithTranslators(client, p.translators)
+	if cfg.MappingStore != nil {
+		p.mappingRecords = newMappingRecords(cfg.MappingStore)
+	}
+	podManager.SetAppNaming(cfg.AppNaming)
+	podManager.SetTranslationObserver(p.observeTranslation)
+	podManager.SetFleets(client)
+	p.podManager = configResolvingManager{WorkloadManager: podManager, resolve: p.resolveConfigRefs}
+	if cfg.ImageDigestResolver != nil {
+		p.imagePins = newImagePins(cfg.ImageDigestResolver)
+		p.podManager = imagePinningManager{WorkloadManager: p.podManager, pins: p.imagePins}
+	}
+	if cfg.LogCacheSize > 0 {
+		p.logCache = newLogCache(cfg.LogCacheSize)
+	}
+
+	// Start background status reconciliation loop and its workers
+	p.loops.Add(3 + p.reconcileWorkers)
+	go func() {
+		defer p.loops.Done()
+		p.syncPodStatusLoop()
+	}()
+	for range p.reconcileWorkers {
+		go func() {
+			defer p.loops.Done()
+			p.reconcileWorker(reconcileCtx)
+		}()
+	}
+
+	// Start device disconnection monitor
+	go func() {
+		defer p.loops.Done()
+		p.disconnectLoop()
+	}()
+
+	// Start node heartbeats
+	go func() {
+		defer p.loops.Done()
+		p.nodeHeartbeatLoop()
+	}()
+
+	// Start counting pending enrollment requests
+	if p.enrollmentCheckInterval > 0 {
+		p.loops.Add(1)
+		go func() {
+			defer p.loops.Done()
+			p.enrollmentLoop()
+		}()
+	}
+
+	// Start refreshing the device labels of the single node
+	if p.deviceLabelInterval > 0 {
+		p.loops.Add(1)
+		go func() {
+			defer p.loops.Done()
+			p.deviceLabelLoop()
+		}()
+	}
+
+	// Start the pod operation workers, stopped with the other loops
+	p.loops.Add(1 + p.podWorkers)
+	go func() {
+		defer p.loops.Done()
+		<-reconcileCtx.Done()
+		p.podQueue.shutdown()
+	}()
+	for range p.podWorkers {
+		go func() {
+			defer p.loops.Done()
+			p.podWorker()
+		}()
+	}
 
 	return p, nil
 }
 
-// syncPodStatusLoop runs a background goroutine that periodically reconciles pod status with FlightCtl.
-func (p *Provider) syncPodStatusLoop() {
-	ticker := time.NewTicker(15 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-p.reconcileCtx.Done():
-			logger.Info("Status reconciliation loop stopped")
-			return
-		case <-ticker.C:
-			p.reconcilePodStatus()
-		}
+// startPodSpan starts a span for a pod operation and attaches the pod's
+// identity to the spans of the FlightCtl calls it makes.
+func startPodSpan(ctx context.Context, name string, pod *corev1.Pod) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		tracing.PodNamespaceKey.String(pod.Namespace),
+		tracing.PodNameKey.String(pod.Name),
 	}
+	ctx = tracing.WithAttributes(ctx, attrs...)
+	return tracing.Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
 }
 
-// reconcilePodStatus fetches current status from FlightCtl for all tracked pods and updates cache.
-func (p *Provider) reconcilePodStatus() {
-	p.mu.RLock()
-	// Create a snapshot of mappings to avoid holding lock during API calls
-	mappings := make([]*models.PodDeviceMapping, 0, len(p.podMappings))
-	for _, mapping := range p.podMappings {
-		mappings = append(mappings, mapping)
+// podForMapping returns the pod used to query status for a mapping. The
+// tracked spec is preferred so container statuses can be reported; only
+// identity metadata is available for pods adopted without one.
+func podForMapping(mapping *models.PodDeviceMapping) *corev1.Pod {
+	if mapping.Pod != nil {
+		return mapping.Pod
 	}
-	p.mu.RUnlock()
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: mapping.Namespace,
+			Name:      mapping.Name,
+			UID:       mapping.PodUID,
+		},
+	}
+}
 
-	// Query status for each pod
-	for _, mapping := range mappings {
-		pod := &corev1.Pod{
-			ObjectMeta: metav1.ObjectMeta{
-				Namespace: mapping.Namespace,
-				Name:      mapping.Name,
-				UID:       mapping.PodUID,
-			},
-		}
+// SetEventRecorder sets the recorder used to emit pod events for transitions
+// the provider initiates itself (disconnections, rescheduling, ...).
+func (p *Provider) SetEventRecorder(recorder record.EventRecorder) {
+	p.eventRecorder = recorder
+}
 
-		status, err := p.podManager.GetPodStatus(context.Background(), pod, mapping.DeviceID)
-		if err != nil {
-			logger.Error("Failed to get status for pod %s/%s: %v", mapping.Namespace, mapping.Name, err)
-			continue
-		}
+// recordPodEvent emits an event for the pod tracked by mapping, if an event
+// recorder is configured.
+func (p *Provider) recordPodEvent(mapping *models.PodDeviceMapping, eventType, reason, messageFmt string, args ...interface{}) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: mapping.Namespace,
+			Name:      mapping.Name,
+			UID:       mapping.PodUID,
+		},
+	}
+	p.recordEvent(pod, eventType, reason, messageFmt, args...)
+}
 
-		// Update cached status
-		p.mu.Lock()
-		if cachedMapping, exists := p.podMappings[mapping.PodKey]; exists {
-			cachedMapping.Status = status
-		}
-		p.mu.Unlock()
+// recordDryRun emits a DryRun event for a pod operation that changed its
+// devices' specs only in memory, in a dry run.
+func (p *Provider) recordDryRun(mapping *models.PodDeviceMapping, operation string, devices []string) {
+	if p.dryRun {
+		p.recordPodEvent(mapping, corev1.EventTypeNormal, "DryRun", "Dry run: %s devices %v was logged, not written to FlightCtl", operation, devices)
 	}
 }
 
-// Shutdown gracefully stops the provider and background goroutines.
+// Shutdown stops the provider and background goroutines without waiting
+// for them; see Drain.
 func (p *Provider) Shutdown() {
 	if p.reconcileCancel != nil {
 		p.reconcileCancel()
 	}
+	if c, ok := p.flightctl.(interface{ Close() }); ok {
+		c.Close()
+	}
+}
+
+// Drain stops the provider gracefully: the background loops are stopped and
+// waited for, and the device updates still queued are written before the
+// FlightCtl client is closed.
+// Pod state lives in the device specs, so once they are written nothing is
+// lost on exit. The provider is shut down even if ctx expires first.
+func (p *Provider) Drain(ctx context.Context) error {
+	defer p.Shutdown()
+	p.reconcileCancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		p.loops.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for reconciliation to stop: %w", ctx.Err())
+	}
+
+	return p.podManager.Flush(ctx)
 }
 
-// selectDeviceForPod determines which FlightCtl device to deploy a pod to.
-// Checks pod annotations for device/fleet selection:
-// - flightctl.io/device-id: specific device ID
-// - flightctl.io/fleet-id: fleet ID (TODO: implement fleet selection)
-// Falls back to default device if no annotations present.
-func (p *Provider) selectDeviceForPod(pod *corev1.Pod) (string, error) {
-	const (
-		deviceIDAnnotation = "flightctl.io/device-id"
-		fleetIDAnnotation  = "flightctl.io/fleet-id"
-		defaultDeviceID    = "d1k9ppdrurp23cfmj554f4rtt4f8uvo9mba4sp3in9arhli44ot0"
-	)
+// Pod annotations selecting the target device or fleet, and how a device
+// is picked from a fleet.
+const (
+	deviceIDAnnotation          = "flightctl.io/device-id"
+	fleetIDAnnotation           = "flightctl.io/fleet-id"
+	placementStrategyAnnotation = "flightctl.io/placement-strategy"
+)
+
+// deviceSelectorPrefix marks pod nodeSelector entries that select devices by label,
+// e.g. "flightctl.io/region: galway" selects devices labelled region=galway.
+const deviceSelectorPrefix = "flightctl.io/"
+
+// devicePlacement is what placing a pod reads from FlightCtl, fetched
+// before p.mu is taken so that the lock is not held across FlightCtl
+// requests: the device the pod is pinned to, or the candidate devices of
+// the fleet and device labels it targets.
+type devicePlacement struct {
+	deviceID string         // Device the pod is pinned to, empty if placed by target
+	device   *models.Device // The pinned device, nil if it was not read
+	checkFit bool           // The pinned device must fit the pod
+
+	target     *models.DeploymentTarget
+	candidates []*models.Device // Devices of the target the pod's namespace may use
+	scope      string           // The target, as named in logs and errors
+}
+
+// fetchPlacement reads what placing a pod needs, from pod annotations and
+// nodeSelector:
+// - flightctl.io/device-id annotation: specific device ID
+// - flightctl.io/fleet-id annotation: fleet ID (best ready device in the fleet is chosen)
+// - flightctl.io/<label> nodeSelector entries: device label selectors
+// Falls back to the default fleet, or the default device, if none are present.
+// A pod recorded on a device before the provider restarted stays on it. A
+// device-pinned provider always uses its own device; a fleet-pinned one
+// only considers devices of its fleet. Whichever way the device is chosen,
+// the device access policy must allow the pod's namespace to use it.
+func (p *Provider) fetchPlacement(ctx context.Context, pod *corev1.Pod, recorded string) (*devicePlacement, error) {
+	const defaultDeviceID = "d1k9ppdrurp23cfmj554f4rtt4f8uvo9mba4sp3in9arhli44ot0"
+
+	switch {
+	case recorded != "":
+		return p.fetchPinnedDevice(ctx, pod, recorded, false)
+	case p.deviceID != "":
+		if deviceID := pod.Annotations[deviceIDAnnotation]; deviceID != "" && deviceID != p.deviceID {
+			return nil, fmt.Errorf("pod requests device %s but node %s represents device %s", deviceID, p.nodeName, p.deviceID)
+		}
+		return p.fetchPinnedDevice(ctx, pod, p.deviceID, false)
+	case p.fleetID != "":
+		return p.fetchFleetPlacement(ctx, pod)
+	}
 
 	// Check for direct device ID annotation
 	if deviceID, ok := pod.Annotations[deviceIDAnnotation]; ok && deviceID != "" {
-		logger.Info("Pod %s/%s has device-id annotation: %s", pod.Namespace, pod.Name, deviceID)
-		return deviceID, nil
+		logger.FromContext(ctx).Info("Pod %s/%s has device-id annotation: %s", pod.Namespace, pod.Name, deviceID)
+		return p.fetchPinnedDevice(ctx, pod, deviceID, true)
 	}
 
-	// Check for fleet ID annotation
-	if fleetID, ok := pod.Annotations[fleetIDAnnotation]; ok && fleetID != "" {
-		logger.Info("Pod %s/%s has fleet-id annotation: %s", pod.Namespace, pod.Name, fleetID)
-		// TODO: Implement fleet selection - query FlightCtl API for devices in fleet
-		// For now, return error to indicate this is not yet implemented
-		return "", fmt.Errorf("fleet-based device selection not yet implemented (fleet: %s)", fleetID)
+	fleetID := pod.Annotations[fleetIDAnnotation]
+	selectors := deviceSelectorsFromNodeSelector(pod.Spec.NodeSelector)
+
+	if fleetID != "" || len(selectors) > 0 {
+		logger.FromContext(ctx).Info("Pod %s/%s targets fleet=%q device labels=%v", pod.Namespace, pod.Name, fleetID, selectors)
+		return p.fetchCandidates(ctx, pod, fleetID, selectors)
+	}
+
+	if p.defaultFleet != "" {
+		logger.FromContext(ctx).Info("Pod %s/%s has no device/fleet annotations, using default fleet: %s",
+			pod.Namespace, pod.Name, p.defaultFleet)
+		return p.fetchCandidates(ctx, pod, p.defaultFleet, nil)
 	}
 
 	// No annotations - use default device
-	logger.Info("Pod %s/%s has no device/fleet annotations, using default device: %s",
+	logger.FromContext(ctx).Info("Pod %s/%s has no device/fleet annotations, using default device: %s",
 		pod.Namespace, pod.Name, defaultDeviceID)
-	return defaultDeviceID, nil
+	return p.fetchPinnedDevice(ctx, pod, defaultDeviceID, false)
+}
+
+// fetchPinnedDevice reads the device a pod is pinned to, if its fit, the
+// access policy or the offline deployment TTL need it, and checks that the
+// access policy allows the pod's namespace to use it.
+func (p *Provider) fetchPinnedDevice(ctx context.Context, pod *corev1.Pod, deviceID string, checkFit bool) (*devicePlacement, error) {
+	placement := &devicePlacement{deviceID: deviceID, checkFit: checkFit}
+	if !checkFit && p.deviceAccess == nil && p.offlineDeploymentTTL <= 0 {
+		return placement, nil
+	}
+	raw, err := p.flightctl.GetDevice(ctx, deviceID)
+	if err != nil {
+		if checkFit || p.deviceAccess != nil {
+			return nil, fmt.Errorf("getting device %s: %w", deviceID, err)
+		}
+		// The device is then not known to be offline
+		return placement, nil
+	}
+	placement.device = raw.ToModel()
+	if p.deviceAccess != nil {
+		if err := p.deviceAccess.Allows(pod.Namespace, placement.device); err != nil {
+			return nil, err
+		}
+	}
+	return placement, nil
+}
+
+// fetchCandidates reads the devices matching the optional fleet and label
+// selectors that the pod's namespace may use. If a fleet is given it is
+// validated first.
+func (p *Provider) fetchCandidates(ctx context.Context, pod *corev1.Pod, fleetID string, selectors map[string]string) (*devicePlacement, error) {
+	target := &models.DeploymentTarget{Selectors: selectors}
+	scope := fmt.Sprintf("device labels %v", selectors)
+
+	if fleetID != "" {
+		fleet, err := p.flightctl.GetFleet(ctx, fleetID)
+		if errors.Is(err, flightctl.ErrNotFound) {
+			return nil, fmt.Errorf("invalid flightctl.io/fleet-id annotation: fleet %s not found", fleetID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("validating flightctl.io/fleet-id annotation: %w", err)
+		}
+		fleetID = fleet.ID
+		target.FleetID = &fleetID
+		scope = fmt.Sprintf("fleet %s", fleetID)
+		if len(selectors) > 0 {
+			scope = fmt.Sprintf("fleet %s with device labels %v", fleetID, selectors)
+		}
+	}
+
+	devices, err := p.flightctl.ListDevices(ctx, fleetID, selectors)
+	if err != nil {
+		return nil, fmt.Errorf("listing devices in %s: %w", scope, err)
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no devices found in %s", scope)
+	}
+	if devices = p.deviceAccess.allowedDevices(pod.Namespace, devices); len(devices) == 0 {
+		return nil, fmt.Errorf("no devices in %s that namespace %s may use", scope, pod.Namespace)
+	}
+	return &devicePlacement{target: target, candidates: devices, scope: scope}, nil
+}
+
+// placeLocked picks the device for a pod from what fetchPlacement read: the
+// pinned device, if it fits the pod, or the best ready candidate that can
+// fit the pod's resource requests. It returns the device as read, nil if
+// the pinned device was not. Caller must hold p.mu.
+func (p *Provider) placeLocked(ctx context.Context, pod *corev1.Pod, placement *devicePlacement) (string, *models.Device, error) {
+	if placement.deviceID != "" {
+		if placement.checkFit {
+			if err := p.checkPlacementLocked(pod, placement.device); err != nil {
+				return "", nil, fmt.Errorf("device %s: %w", placement.deviceID, err)
+			}
+		}
+		return placement.deviceID, placement.device, nil
+	}
+
+	devices, scope := placement.candidates, placement.scope
+	var err error
+	if devices, err = p.withoutHostPortConflictsLocked(pod, devices); err != nil {
+		return "", nil, fmt.Errorf("selecting device in %s: %w", scope, err)
+	}
+	if devices, err = p.withDeviceAffinityLocked(pod, devices); err != nil {
+		return "", nil, fmt.Errorf("selecting device in %s: %w", scope, err)
+	}
+	if devices, err = p.withTopologySpreadLocked(pod, devices); err != nil {
+		return "", nil, fmt.Errorf("selecting device in %s: %w", scope, err)
+	}
+
+	p.applyAllocationsLocked(devices)
+	if devices, err = p.withDeviceScoresLocked(pod, devices); err != nil {
+		return "", nil, fmt.Errorf("selecting device in %s: %w", scope, err)
+	}
+
+	target := placement.target
+	requests := models.PodRequests(pod)
+	target.Requests = &requests
+	if err := p.setPlacementLocked(target, pod); err != nil {
+		return "", nil, err
+	}
+	device, err := target.SelectDevice(devices, p.podsByDeviceLocked())
+	if err != nil {
+		return "", nil, fmt.Errorf("selecting device in %s (%d devices): %w", scope, len(devices), err)
+	}
+
+	logger.FromContext(ctx).Info("Selected device %s from %s", device.ID, scope)
+	return device.ID, device, nil
+}
+
+// targetFleet returns the fleet a pod must run in: the fleet it targets by
+// annotation, or the node's fleet. It is empty for pods pinned to a device
+// and pods that may run in any fleet.
+func (p *Provider) targetFleet(pod *corev1.Pod) string {
+	if pod.Annotations[deviceIDAnnotation] != "" {
+		return ""
+	}
+	if fleetID := pod.Annotations[fleetIDAnnotation]; fleetID != "" {
+		return fleetID
+	}
+	return p.fleetID
+}
+
+// placementFleet returns the fleet a pod was placed in: its target fleet,
+// else the default fleet for pods without device or fleet targeting.
+func (p *Provider) placementFleet(pod *corev1.Pod) string {
+	if fleetID := p.targetFleet(pod); fleetID != "" {
+		return fleetID
+	}
+	if pod.Annotations[deviceIDAnnotation] != "" || len(deviceSelectorsFromNodeSelector(pod.Spec.NodeSelector)) > 0 {
+		return ""
+	}
+	return p.defaultFleet
+}
+
+// deviceSelectorsFromNodeSelector extracts device label selectors from the
+// flightctl.io/-prefixed nodeSelector entries of a pod.
+func deviceSelectorsFromNodeSelector(nodeSelector map[string]string) map[string]string {
+	var selectors map[string]string
+	for key, value := range nodeSelector {
+		if !strings.HasPrefix(key, deviceSelectorPrefix) {
+			continue
+		}
+		label := strings.TrimPrefix(key, deviceSelectorPrefix)
+		if label == "" {
+			continue
+		}
+		if selectors == nil {
+			selectors = make(map[string]string)
+		}
+		selectors[label] = value
+	}
+	return selectors
+}
+
+// applyAllocationsLocked sets the allocatable resources of each device to its
+// capacity, scaled by the overcommit ratio, minus the requests of the pods
+// already placed on it, other than completed ones. Caller must hold p.mu.
+func (p *Provider) applyAllocationsLocked(devices []*models.Device) {
+	allocated := make(map[string]models.ResourceList)
+	for _, mapping := range p.podMappings {
+		if mapping.IsCompleted() {
+			continue
+		}
+		for _, deviceID := range mapping.Devices() {
+			allocated[deviceID] = allocated[deviceID].Add(mapping.Requests)
+		}
+	}
+
+	for _, device := range devices {
+		device.Allocatable = p.overcommit.Apply(device.Capacity).Sub(allocated[device.ID])
+	}
+}
+
+// checkPlacementLocked returns an error if a pod cannot be placed on the
+// device: the device is cordoned, under disk pressure or beyond the power
+// limits, or it lacks the allocatable resources the pod requests (an
+// InsufficientResourcesError).
+// Devices that don't report capacity are assumed to fit, other than for
+// extended resources. Caller must hold p.mu.
+func (p *Provider) checkPlacementLocked(pod *corev1.Pod, device *models.Device) error {
+	if device.Cordoned {
+		return fmt.Errorf("device is cordoned (%s)", flightctl.CordonKey)
+	}
+	if device.Health.DiskPressure {
+		return fmt.Errorf("device reports critical disk usage")
+	}
+	if err := p.power.check(pod, device); err != nil {
+		return err
+	}
+	p.applyAllocationsLocked([]*models.Device{device})
+	requests := models.PodRequests(pod)
+	if !device.HasExtendedResources(requests.Extended) || !device.HasSufficientStorage(requests.EphemeralStorage) ||
+		device.HasCapacityInfo() && !device.HasSufficientResources(requests.CPU, requests.Memory) {
+		return &models.InsufficientResourcesError{Requested: requests, Candidates: 1}
+	}
+	return nil
+}
+
+// setPlacementLocked sets the target's placement strategy, the pod's
+// annotation or else the provider's, along with the placement history it
+// needs. Caller must hold p.mu.
+func (p *Provider) setPlacementLocked(target *models.DeploymentTarget, pod *corev1.Pod) error {
+	target.Strategy = p.placement
+	if name := pod.Annotations[placementStrategyAnnotation]; name != "" {
+		strategy, err := models.ParsePlacementStrategy(name)
+		if err != nil {
+			return fmt.Errorf("invalid %s annotation: %w", placementStrategyAnnotation, err)
+		}
+		target.Strategy = strategy
+	}
+	if target.Strategy == models.PlacementLeastRecentlyUsed {
+		target.LastUsed = p.lastPlacementsLocked()
+	}
+	return nil
+}
+
+// lastPlacementsLocked returns when each device last received one of the
+// tracked pods. Caller must hold p.mu.
+func (p *Provider) lastPlacementsLocked() map[string]time.Time {
+	last := make(map[string]time.Time)
+	for _, mapping := range p.podMappings {
+		for _, deviceID := range mapping.Devices() {
+			if mapping.DeployedAt.After(last[deviceID]) {
+				last[deviceID] = mapping.DeployedAt
+			}
+		}
+	}
+	return last
+}
+
+// podsByDeviceLocked counts tracked pods per device, other than completed
+// ones. Caller must hold p.mu.
+func (p *Provider) podsByDeviceLocked() map[string]int {
+	counts := make(map[string]int)
+	for _, mapping := range p.podMappings {
+		if mapping.IsCompleted() {
+			continue
+		}
+		for _, deviceID := range mapping.Devices() {
+			counts[deviceID]++
+		}
+	}
+	return counts
 }
 
 // PodLifecycleHandler interface implementation
 
 // CreatePod deploys a pod to an edge device.
 func (p *Provider) CreatePod(ctx context.Context, pod *corev1.Pod) error {
-	logger.Info("Provider Create Pod %s", pod.Name)
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
 	podKey := fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
+	ctx = logger.EnsureCorrelationID(ctx)
+	ctx, span := startPodSpan(ctx, "Provider.CreatePod", pod)
+	defer span.End()
+	log := logger.FromContext(ctx).With("pod", podKey)
+	log.Info("Provider Create Pod %s", pod.Name)
+	started := time.Now()
+
+	if err := p.validatePod(ctx, pod); err != nil {
+		tracing.RecordError(span, err)
+		p.recordReconcile(reconcileRecord(podKey, models.ReconcileCreate, models.ActionNone), started, err)
+		return err
+	}
 
-	// Select device from pod annotations or use default
-	deviceID, err := p.selectDeviceForPod(pod)
-	if err != nil {
-		return fmt.Errorf("selecting device for pod: %w", err)
+	// A pod reusing the name of a deleted pod waits until the devices
+	// stopped the old application, which has the same name
+	p.mu.RLock()
+	previous := p.podMappings[podKey]
+	p.mu.RUnlock()
+	if previous != nil && previous.IsTerminating() && previous.PodUID != pod.UID {
+		err := fmt.Errorf("previous pod %s is still terminating on devices %v", podKey, previous.Devices())
+		tracing.RecordError(span, err)
+		p.recordReconcile(reconcileRecord(podKey, models.ReconcileCreate, models.ActionNone, previous.Devices()...), started, err)
+		return err
 	}
 
-	logger.Info("Deploying pod %s to device %s", podKey, deviceID)
+	if isSpreadPod(pod) {
+		p.mu.RLock()
+		err := p.checkQuotaLocked(pod)
+		p.mu.RUnlock()
+		if err != nil {
+			p.rejectOverQuota(pod, err)
+			tracing.RecordError(span, err)
+			p.recordReconcile(reconcileRecord(podKey, models.ReconcileCreate, models.ActionNone), started, err)
+			return err
+		}
+		mapping, err := p.createSpreadPod(ctx, pod)
+		if err != nil {
+			p.imagePins.forget(pod.UID)
+			err = fmt.Errorf("spreading pod: %w", err)
+			tracing.RecordError(span, err)
+			p.recordReconcile(reconcileRecord(podKey, models.ReconcileCreate, models.ActionDeploy), started, err)
+			return err
+		}
+		mapping.Requests = models.PodRequests(pod)
+		mapping.Pod = pod.DeepCopy()
+		p.mu.Lock()
+		p.startReadyDeadline(mapping, nil)
+		p.podMappings[podKey] = mapping
+		p.mu.Unlock()
+		p.queueReconcile(mapping.SpreadDevices...)
+		p.recordReconcile(reconcileRecord(podKey, models.ReconcileCreate, models.ActionDeploy, mapping.SpreadDevices...), started, nil)
+		p.syncMappingRecords(ctx)
+		log.Info("Pod %s spread to devices %v with initial Pending status", podKey, mapping.SpreadDevices)
+		return nil
+	}
 
-	// Deploy to Flightctl
-	if err := p.podManager.DeployPod(ctx, pod, deviceID); err != nil {
-		return fmt.Errorf("deploying pod to device %s: %w", deviceID, err)
+	// Select device from pod annotations or use default, unless the pod
+	// was deployed before the provider restarted. FlightCtl is read before
+	// the lock is taken; the device is picked and reserved under it.
+	recorded := p.recordedDevice(ctx, pod)
+	if recorded != "" {
+		log.Info("Pod %s is recorded on device %s, deploying it there again", podKey, recorded)
 	}
+	placement, err := p.fetchPlacement(ctx, pod, recorded)
+	if err != nil {
+		err = fmt.Errorf("selecting device for pod: %w", err)
+		tracing.RecordError(span, err)
+		p.recordReconcile(reconcileRecord(podKey, models.ReconcileCreate, models.ActionDeploy), started, err)
+		return err
+	}
+	p.mu.Lock()
+	if err := p.checkQuotaLocked(pod); err != nil {
+		p.mu.Unlock()
+		p.rejectOverQuota(pod, err)
+		tracing.RecordError(span, err)
+		p.recordReconcile(reconcileRecord(podKey, models.ReconcileCreate, models.ActionNone), started, err)
+		return err
+	}
+	deviceID, device, err := p.placeLocked(ctx, pod, placement)
+	if err != nil {
+		p.mu.Unlock()
+		err = fmt.Errorf("selecting device for pod: %w", err)
+		tracing.RecordError(span, err)
+		p.recordReconcile(reconcileRecord(podKey, models.ReconcileCreate, models.ActionDeploy), started, err)
+		return err
+	}
+	if err := p.hostPortConflictLocked(pod, deviceID); err != nil {
+		p.mu.Unlock()
+		tracing.RecordError(span, err)
+		p.recordReconcile(reconcileRecord(podKey, models.ReconcileCreate, models.ActionNone, deviceID), started, err)
+		return err
+	}
+	if err := p.deviceAffinityConflictLocked(pod, deviceID); err != nil {
+		p.mu.Unlock()
+		err = fmt.Errorf("placing pod on device %s: %w", deviceID, err)
+		tracing.RecordError(span, err)
+		p.recordReconcile(reconcileRecord(podKey, models.ReconcileCreate, models.ActionNone, deviceID), started, err)
+		return err
+	}
+	span.SetAttributes(tracing.DeviceIDKey.String(deviceID))
 
-	// Track mapping
+	// Track the mapping before deploying, so the pod counts against the
+	// device's capacity while the lock is released for the device update
 	mapping := models.NewPodDeviceMapping(pod.Namespace, pod.Name, pod.UID, deviceID)
+	mapping.Requests = models.PodRequests(pod)
+	mapping.Pod = pod.DeepCopy()
+
+	// A pod for an offline device is held until the device reconnects
+	if until := p.offlineDeadline(pod, device); !until.IsZero() {
+		mapping.AwaitingDeviceUntil = until
+		mapping.Status = awaitingDeviceStatus(deviceID, until)
+		p.podMappings[podKey] = mapping
+		p.recordPodEvent(mapping, corev1.EventTypeNormal, awaitingDeviceReason,
+			"Device %s is offline, holding the pod until it reconnects", deviceID)
+		p.mu.Unlock()
+		p.recordReconcile(reconcileRecord(podKey, models.ReconcileCreate, models.ActionNone, deviceID), started, nil)
+		p.syncMappingRecords(ctx)
+		log.Info("Device %s is offline, holding pod %s until %s", deviceID, podKey, until.Format(time.RFC3339))
+		return nil
+	}
+
+	mapping.InFlight = true
+	mapping.Status = scheduledPodStatus(deviceID)
+	p.podMappings[podKey] = mapping
+	p.mu.Unlock()
 
-	// Set initial Pending status
-	mapping.Status = &corev1.PodStatus{
+	log.Info("Deploying pod %s to device %s", podKey, deviceID)
+
+	// Deploy to Flightctl
+	err = p.podQueue.run(ctx, deviceID, &podOperation{
+		description: fmt.Sprintf("deploying pod %s to device %s", podKey, deviceID),
+		pod:         podKey,
+		run: func(ctx context.Context) error {
+			return p.podManager.DeployPod(ctx, pod, deviceID)
+		},
+		done: func(err error, background bool) error {
+			return p.deployedPod(ctx, mapping, deviceID, started, err, background)
+		},
+	})
+	if err != nil {
+		tracing.RecordError(span, err)
+	}
+	return err
+}
+
+// scheduledPodStatus returns the initial Pending status of a pod deployed to
+// a device.
+func scheduledPodStatus(deviceID string) *corev1.PodStatus {
+	return &corev1.PodStatus{
 		Phase: corev1.PodPending,
 		Conditions: []corev1.PodCondition{
 			{
@@ -209,51 +1355,260 @@ func (p *Provider) CreatePod(ctx context.Context, pod *corev1.Pod) error {
 			},
 		},
 	}
+}
 
-	p.podMappings[podKey] = mapping
+// deployedPod completes the creation of a pod once its application was
+// written to its device, or drops the pod if that failed. A pod whose
+// deployment failed in the background was already created, so it is marked
+// Failed instead.
+func (p *Provider) deployedPod(ctx context.Context, mapping *models.PodDeviceMapping, deviceID string, started time.Time, err error, background bool) error {
+	podKey := mapping.PodKey
+	record := reconcileRecord(podKey, models.ReconcileCreate, models.ActionDeploy, deviceID)
 
-	logger.Info("Pod %s created with initial Pending status", podKey)
+	p.mu.Lock()
+	if err != nil {
+		current := p.podMappings[podKey] == mapping
+		switch {
+		case !current:
+		case background:
+			mapping.InFlight = false
+			mapping.RolledBack = true
+		default:
+			delete(p.podMappings, podKey)
+			p.imagePins.forget(mapping.PodUID)
+		}
+		p.mu.Unlock()
+		err = fmt.Errorf("deploying pod to device %s: %w", deviceID, err)
+		p.recordReconcile(record, started, err)
+		if current && background {
+			p.failPod(podKey, "DeploymentFailed", err.Error())
+		}
+		return err
+	}
+	mapping.InFlight = false
+	p.startReadyDeadline(mapping, nil)
+	p.mu.Unlock()
+	p.queueReconcile(deviceID)
+	p.recordReconcile(record, started, nil)
+	p.recordDryRun(mapping, "deploying the pod to", []string{deviceID})
+	p.syncMappingRecords(ctx)
+
+	logger.FromContext(ctx).With("pod", podKey).Info("Pod %s created with initial Pending status", podKey)
 	return nil
 }
 
 // UpdatePod updates a pod on an edge device.
 func (p *Provider) UpdatePod(ctx context.Context, pod *corev1.Pod) error {
-	logger.Info("Provider Update Pod %s", pod.Name)
-	p.mu.RLock()
 	podKey := fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
+	ctx = logger.EnsureCorrelationID(ctx)
+	ctx, span := startPodSpan(ctx, "Provider.UpdatePod", pod)
+	defer span.End()
+	logger.FromContext(ctx).With("pod", podKey).Info("Provider Update Pod %s", pod.Name)
+
+	p.mu.RLock()
 	mapping := p.podMappings[podKey]
+	var devices []string
+	if mapping != nil {
+		devices = mapping.Devices()
+	}
 	p.mu.RUnlock()
 
 	if mapping == nil {
 		return fmt.Errorf("pod %s not found", podKey)
 	}
+	if pod.UID != "" && mapping.PodUID != "" && mapping.PodUID != pod.UID {
+		// Updates of a recreated pod never change the pod it replaced
+		return fmt.Errorf("pod %s with UID %s not found (the tracked pod has UID %s)", podKey, pod.UID, mapping.PodUID)
+	}
 
-	return p.podManager.UpdatePod(ctx, pod, mapping.DeviceID)
+	started := time.Now()
+	record := reconcileRecord(podKey, models.ReconcileUpdate, models.ActionUpdate, devices...)
+	if err := p.validatePod(ctx, pod); err != nil {
+		tracing.RecordError(span, err)
+		record.Action = models.ActionNone
+		p.recordReconcile(record, started, err)
+		return err
+	}
+	p.mu.Lock()
+	if mapping.IsAwaitingDevice() {
+		// A held pod is deployed as it is once its device reconnects
+		mapping.Pod = pod.DeepCopy()
+		mapping.Requests = models.PodRequests(pod)
+		p.mu.Unlock()
+		record.Action = models.ActionNone
+		p.recordReconcile(record, started, nil)
+		return nil
+	}
+	// Rescheduling and fleet rollouts move the pod under the lock, so its
+	// devices are read again now that validation is done
+	devices = mapping.Devices()
+	rolloutFleet := mapping.RolloutFleet
+	p.mu.Unlock()
+	for _, deviceID := range devices {
+		span.SetAttributes(tracing.DeviceIDKey.String(deviceID))
+	}
+	err := p.podQueue.run(ctx, devices[0], &podOperation{
+		description: fmt.Sprintf("updating pod %s on devices %v", podKey, devices),
+		pod:         podKey,
+		run: func(ctx context.Context) error {
+			if rolloutFleet != "" {
+				return p.podManager.DeployPodToFleet(ctx, pod, rolloutFleet)
+			}
+			for _, deviceID := range devices {
+				if err := p.podManager.UpdatePod(ctx, pod, deviceID); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		done: func(err error, background bool) error {
+			p.recordReconcile(record, started, err)
+			if err != nil {
+				if background {
+					p.recordPodEvent(mapping, corev1.EventTypeWarning, "UpdateFailed", "Updating the pod on devices %v failed: %v", devices, err)
+				}
+				return err
+			}
+			p.mu.Lock()
+			p.startReadyDeadline(mapping, mapping.Pod)
+			mapping.Pod = pod.DeepCopy()
+			mapping.Requests = models.PodRequests(pod)
+			p.mu.Unlock()
+			p.queueReconcile(devices...)
+			p.recordDryRun(mapping, "updating the pod on", devices)
+			return nil
+		},
+	})
+	if err != nil {
+		tracing.RecordError(span, err)
+	}
+	return err
 }
 
 // DeletePod removes a pod from an edge device.
 func (p *Provider) DeletePod(ctx context.Context, pod *corev1.Pod) error {
-	logger.Info("Provider Delete Pod %s", pod.Name)
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
 	podKey := fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
-	mapping := p.podMappings[podKey]
+	ctx = logger.EnsureCorrelationID(ctx)
+	ctx, span := startPodSpan(ctx, "Provider.DeletePod", pod)
+	defer span.End()
+	log := logger.FromContext(ctx).With("pod", podKey)
+	log.Info("Provider Delete Pod %s", pod.Name)
 
+	p.mu.Lock()
+	mapping := p.podMappings[podKey]
 	if mapping == nil {
 		// Already deleted (idempotent)
+		p.mu.Unlock()
+		return nil
+	}
+	if pod.UID != "" && mapping.PodUID != "" && mapping.PodUID != pod.UID {
+		// The pod was recreated with the same name; the deleted one is gone
+		p.mu.Unlock()
+		log.Info("Pod %s with UID %s is not tracked, the tracked pod has UID %s; nothing to delete", podKey, pod.UID, mapping.PodUID)
+		return nil
+	}
+	if mapping.IsAwaitingDevice() {
+		// A held pod was never written to its device
+		delete(p.podMappings, podKey)
+		p.imagePins.forget(mapping.PodUID)
+		p.mu.Unlock()
+		p.recordReconcile(reconcileRecord(podKey, models.ReconcileDelete, models.ActionNone, mapping.DeviceID), time.Now(), nil)
+		p.syncMappingRecords(ctx)
+		log.Info("Pod %s deleted while awaiting device %s", podKey, mapping.DeviceID)
 		return nil
 	}
+	// The lock is released while the devices are updated; the mapping is
+	// kept until then so the pod still counts against their capacity
+	mapping.InFlight = true
+	devices := mapping.Devices()
+	p.mu.Unlock()
 
 	// Delete from Flightctl
-	if err := p.podManager.DeletePod(ctx, pod, mapping.DeviceID); err != nil {
-		return fmt.Errorf("deleting pod from device: %w", err)
+	started := time.Now()
+	for _, deviceID := range devices {
+		span.SetAttributes(tracing.DeviceIDKey.String(deviceID))
 	}
+	err := p.podQueue.run(ctx, devices[0], &podOperation{
+		description: fmt.Sprintf("removing pod %s from devices %v", podKey, devices),
+		pod:         podKey,
+		run: func(ctx context.Context) error {
+			if mapping.RolloutFleet != "" {
+				return p.podManager.DeletePodFromFleet(ctx, pod, mapping.RolloutFleet)
+			}
+			for _, deviceID := range devices {
+				err := p.podManager.DeletePod(ctx, pod, deviceID)
+				if errors.Is(err, flightctl.ErrNotFound) {
+					// Device no longer exists, so there is nothing left to remove
+					log.Warn("Device %s for pod %s not found, dropping pod: %v", deviceID, podKey, err)
+				} else if err != nil {
+					return fmt.Errorf("deleting pod from device %s: %w", deviceID, err)
+				}
+			}
+			return nil
+		},
+		done: func(err error, background bool) error {
+			p.recordReconcile(reconcileRecord(podKey, models.ReconcileDelete, models.ActionRemove, devices...), started, err)
+			if err == nil {
+				p.recordDryRun(mapping, "removing the pod from", devices)
+			}
+			switch {
+			case err != nil:
+				p.mu.Lock()
+				mapping.InFlight = false
+				p.mu.Unlock()
+				if background {
+					p.recordPodEvent(mapping, corev1.EventTypeWarning, "DeleteFailed", "Removing the pod from devices %v failed: %v", devices, err)
+				}
+				return err
+			default:
+				p.removedPod(context.WithoutCancel(ctx), mapping, pod, devices)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		tracing.RecordError(span, err)
+	}
+	return err
+}
 
-	// Remove mapping
-	delete(p.podMappings, podKey)
+// removedPod stops tracking a pod once its application was removed from its
+// devices. A pod with a deletion grace period is kept Terminating while the
+// devices stop it, waiting in the background so the caller returns.
+func (p *Provider) removedPod(ctx context.Context, mapping *models.PodDeviceMapping, pod *corev1.Pod, devices []string) {
+	podKey := mapping.PodKey
+	log := logger.FromContext(ctx).With("pod", podKey)
 
-	return nil
+	grace := deletionGracePeriod(pod)
+	if grace <= 0 {
+		p.forgetRemovedPod(mapping)
+		return
+	}
+	p.mu.Lock()
+	mapping.TerminationDeadline = time.Now().Add(grace)
+	p.mu.Unlock()
+	log.Info("Waiting up to %s for devices %v to stop pod %s", grace, devices, podKey)
+	go func() {
+		if p.awaitTermination(ctx, mapping, pod, devices) {
+			log.Info("Devices %v stopped pod %s", devices, podKey)
+		}
+		p.forgetRemovedPod(mapping)
+	}()
+}
+
+// forgetRemovedPod stops tracking a pod removed from its devices, unless it
+// was replaced since.
+func (p *Provider) forgetRemovedPod(mapping *models.PodDeviceMapping) {
+	podKey := mapping.PodKey
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Remove mapping
+	if p.podMappings[podKey] == mapping {
+		delete(p.podMappings, podKey)
+		p.retainPodLocked(mapping)
+		p.imagePins.forget(mapping.PodUID)
+		p.logCache.forget(podKey)
+	}
 }
 
 // GetPod retrieves a pod's current status.
@@ -265,6 +1620,13 @@ func (p *Provider) GetPod(ctx context.Context, namespace, name string) (*corev1.
 	p.mu.RUnlock()
 
 	if mapping == nil {
+		// A deleted pod's last status is served for a while
+		if retained, ok := p.retainedPod(podKey); ok {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, UID: retained.uid},
+				Status:     *retained.status,
+			}, nil
+		}
 		return nil, fmt.Errorf("pod not found")
 	}
 
@@ -282,14 +1644,20 @@ func (p *Provider) GetPod(ctx context.Context, namespace, name string) (*corev1.
 	p.mu.RUnlock()
 
 	if cachedStatus != nil {
-		pod.Status = *cachedStatus
+		pod.Status = staleIfUnavailable(*cachedStatus, p.apiUnavailableSince())
 		return pod, nil
 	}
 
 	// Fallback: query FlightCtl if no cached status (shouldn't happen after reconciliation starts)
-	status, err := p.podManager.GetPodStatus(ctx, pod, mapping.DeviceID)
-	if err != nil {
-		return nil, fmt.Errorf("getting pod status: %w", err)
+	var status *corev1.PodStatus
+	if mapping.IsSpread() {
+		status = p.spreadPodStatus(ctx, mapping)
+	} else {
+		var err error
+		status, err = p.podManager.GetPodStatus(ctx, podForMapping(mapping), mapping.DeviceID)
+		if err != nil {
+			return nil, fmt.Errorf("getting pod status: %w", err)
+		}
 	}
 
 	// Update cache
@@ -304,6 +1672,7 @@ func (p *Provider) GetPod(ctx context.Context, namespace, name string) (*corev1.
 // GetPods retrieves all pods managed by this provider.
 func (p *Provider) GetPods(ctx context.Context) ([]*corev1.Pod, error) {
 	logger.Debug("Provider Get Pods")
+	unavailableSince := p.apiUnavailableSince()
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
@@ -319,7 +1688,7 @@ func (p *Provider) GetPods(ctx context.Context) ([]*corev1.Pod, error) {
 
 		// Use cached status if available
 		if mapping.Status != nil {
-			pod.Status = *mapping.Status
+			pod.Status = staleIfUnavailable(*mapping.Status, unavailableSince)
 		}
 
 		pods = append(pods, pod)
@@ -344,12 +1713,23 @@ func (p *Provider) Ping(ctx context.Context) error {
 	return p.flightctl.Ping(ctx)
 }
 
+// CheckAuth verifies the FlightCtl credentials, if the client supports it.
+func (p *Provider) CheckAuth(ctx context.Context) error {
+	if checker, ok := p.flightctl.(interface{ CheckToken(context.Context) error }); ok {
+		return checker.CheckToken(ctx)
+	}
+	return nil
+}
+
 // NotifyNodeStatus registers a node status callback.
 // This method should be non-blocking and call the callback whenever the node status changes.
 func (p *Provider) NotifyNodeStatus(ctx context.Context, callback func(*corev1.Node)) {
-	// For now, just call once with initial node status
-	// In a full implementation, this would monitor edge device status
-	// and call the callback whenever capacity or conditions change
+	// Pinned providers call back again whenever SetDevice or SetFleet
+	// changes what the node represents; otherwise the node status is static
+	p.mu.Lock()
+	p.nodeCallback = callback
+	p.mu.Unlock()
+
 	node, err := p.GetNode(ctx)
 	if err != nil {
 		// Log error but don't block - NotifyNodeStatus should not return errors
@@ -359,6 +1739,28 @@ func (p *Provider) NotifyNodeStatus(ctx context.Context, callback func(*corev1.N
 	callback(node)
 }
 
+// nodeHeartbeatLoop pushes the node status every heartbeat interval, so the
+// heartbeat times of its conditions stay current while nothing changes.
+func (p *Provider) nodeHeartbeatLoop() {
+	ticker := time.NewTicker(p.nodeHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.reconcileCtx.Done():
+			return
+		case <-ticker.C:
+			p.pushNodeStatus()
+		}
+	}
+}
+
+// InformerResyncPeriod returns how often the pod informers of the node
+// resync.
+func (p *Provider) InformerResyncPeriod() time.Duration {
+	return p.informerResyncPeriod
+}
+
 // GetNode returns the virtual node representing edge devices.
 func (p *Provider) GetNode(ctx context.Context) (*corev1.Node, error) {
 	// Minimal implementation with mock capacity
@@ -373,15 +1775,7 @@ func (p *Provider) GetNode(ctx context.Context) (*corev1.Node, error) {
 				"node.kubernetes.io/vk":  "flightctl",
 				"alpha.service-controller.kubernetes.io/exclude-balancer": "true",
 				"node.kubernetes.io/exclude-from-external-load-balancers": "true",
-			},
-		},
-		Spec: corev1.NodeSpec{
-			Taints: []corev1.Taint{
-				{
-					Key:    "vkubelet-flightctl",
-					Value:  "true",
-					Effect: "NoSchedule",
-				},
+				corev1.LabelOSStable: "linux",
 			},
 		},
 		Status: corev1.NodeStatus{
@@ -395,69 +1789,63 @@ func (p *Provider) GetNode(ctx context.Context) (*corev1.Node, error) {
 					Reason:             "KubeletReady",
 					Message:            "kubelet is ready.",
 				},
+				{
+					Type:               corev1.NodeMemoryPressure,
+					Status:             corev1.ConditionFalse,
+					LastHeartbeatTime:  metav1.Now(),
+					LastTransitionTime: metav1.Now(),
+					Reason:             "KubeletHasSufficientMemory",
+					Message:            "kubelet has sufficient memory available",
+				},
+				{
+					Type:               corev1.NodeDiskPressure,
+					Status:             corev1.ConditionFalse,
+					LastHeartbeatTime:  metav1.Now(),
+					LastTransitionTime: metav1.Now(),
+					Reason:             "KubeletHasNoDiskPressure",
+					Message:            "kubelet has no disk pressure",
+				},
 			},
 			Capacity: corev1.ResourceList{
-				corev1.ResourceCPU:    resource.MustParse("4"),
-				corev1.ResourceMemory: resource.MustParse("8Gi"),
-				corev1.ResourcePods:   resource.MustParse("100"),
+				corev1.ResourceCPU:              resource.MustParse("4"),
+				corev1.ResourceMemory:           resource.MustParse("8Gi"),
+				corev1.ResourceEphemeralStorage: resource.MustParse("20Gi"),
+				corev1.ResourcePods:             resource.MustParse("100"),
 			},
 			Allocatable: corev1.ResourceList{
-				corev1.ResourceCPU:    resource.MustParse("4"),
-				corev1.ResourceMemory: resource.MustParse("8Gi"),
-				corev1.ResourcePods:   resource.MustParse("100"),
+				corev1.ResourceCPU:              resource.MustParse("4"),
+				corev1.ResourceMemory:           resource.MustParse("8Gi"),
+				corev1.ResourceEphemeralStorage: resource.MustParse("20Gi"),
+				corev1.ResourcePods:             resource.MustParse("100"),
 			},
+			DaemonEndpoints: corev1.NodeDaemonEndpoints{
+				KubeletEndpoint: corev1.DaemonEndpoint{Port: p.kubeletPort},
+			},
+			// FlightCtl devices run Linux; the architecture is only known
+			// once the node represents a device or a uniform fleet
 			NodeInfo: corev1.NodeSystemInfo{
 				KubeletVersion:  "vk-flightctl-v1.0.0",
-				Architecture:    "amd64",
-				OperatingSystem: "Linux",
+				OperatingSystem: "linux",
 			},
 		},
 	}
 
-	return node, nil
-}
-
-// Additional nodeutil.Provider interface methods
-
-// GetContainerLogs retrieves the logs of a container by name from the provider.
-func (p *Provider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts api.ContainerLogOpts) (io.ReadCloser, error) {
-	// TODO: Implement log streaming from edge devices via Flightctl
-	return nil, fmt.Errorf("GetContainerLogs not yet implemented")
-}
-
-// RunInContainer executes a command in a container in the pod.
-func (p *Provider) RunInContainer(ctx context.Context, namespace, podName, containerName string, cmd []string, attach api.AttachIO) error {
-	// TODO: Implement command execution on edge devices via Flightctl
-	return fmt.Errorf("RunInContainer not yet implemented")
-}
-
-// AttachToContainer attaches to the executing process of a container in the pod.
-func (p *Provider) AttachToContainer(ctx context.Context, namespace, podName, containerName string, attach api.AttachIO) error {
-	// TODO: Implement container attach via Flightctl
-	return fmt.Errorf("AttachToContainer not yet implemented")
-}
-
-// GetStatsSummary gets the stats for the node, including running pods.
-func (p *Provider) GetStatsSummary(ctx context.Context) (*statsv1alpha1.Summary, error) {
-	// TODO: Aggregate stats from edge devices via Flightctl
-	// For now, return minimal stats
-	return &statsv1alpha1.Summary{
-		Node: statsv1alpha1.NodeStats{
-			NodeName: p.nodeName,
-		},
-		Pods: []statsv1alpha1.PodStats{},
-	}, nil
-}
+	switch {
+	case p.deviceID != "":
+		p.applyDevice(node)
+	case p.fleetID != "":
+		p.applyFleet(node)
+	default:
+		p.applyNodeResources(node)
+	}
+	p.applyAPIOutage(node)
+	p.applyEnrollments(node)
+	if len(node.Status.Addresses) == 0 && p.nodeIP != "" {
+		node.Status.Addresses = []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: p.nodeIP}}
+	}
 
-// GetMetricsResource gets the metrics for the node, including running pods.
-func (p *Provider) GetMetricsResource(ctx context.Context) ([]*dto.MetricFamily, error) {
-	// TODO: Aggregate metrics from edge devices via Flightctl
-	// For now, return empty metrics
-	return []*dto.MetricFamily{}, nil
-}
+	p.applyNodeMetadata(node)
+	p.applyDeviceLabels(node)
 
-// PortForward forwards a local port to a port on the pod.
-func (p *Provider) PortForward(ctx context.Context, namespace, pod string, port int32, stream io.ReadWriteCloser) error {
-	// TODO: Implement port forwarding to edge devices via Flightctl
-	return fmt.Errorf("PortForward not yet implemented")
+	return node, nil
 }
@@ -0,0 +1,165 @@
+package provider
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+// newTestProvider starts a provider with cfg against the fake server and
+// shuts it down when the test ends. overrides adjust the FlightCtl client
+// configuration.
+func newTestProvider(t *testing.T, server *fake.Server, cfg Config, overrides ...func(*flightctl.Config)) *Provider {
+	t.Helper()
+	clientCfg := server.ClientConfig()
+	for _, override := range overrides {
+		override(&clientCfg)
+	}
+	client, err := flightctl.NewClient(clientCfg)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	p, err := NewProviderWithClient(cfg, client)
+	if err != nil {
+		t.Fatalf("NewProviderWithClient: %v", err)
+	}
+	t.Cleanup(p.Shutdown)
+	return p
+}
+
+func TestDeviceSelectorsFromNodeSelector(t *testing.T) {
+	nodeSelector := map[string]string{
+		"flightctl.io/region":    "galway",
+		"flightctl.io/camera":    "true",
+		"kubernetes.io/hostname": "vk-flightctl-node",
+		"flightctl.io/":          "ignored",
+	}
+
+	got := deviceSelectorsFromNodeSelector(nodeSelector)
+	want := map[string]string{"region": "galway", "camera": "true"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected selectors %v, got %v", want, got)
+	}
+
+	if got := deviceSelectorsFromNodeSelector(map[string]string{"type": "virtual-kubelet"}); got != nil {
+		t.Errorf("Expected nil selectors without flightctl.io/ entries, got %v", got)
+	}
+}
+
+func TestPodLifecycleAgainstFakeFlightctl(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("device-1", "edge", nil)
+
+	p := newTestProvider(t, server, Config{NodeName: "vk-test", DefaultFleet: "edge"})
+
+	ctx := context.Background()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}}},
+	}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	device, _ := server.Device("device-1")
+	if len(device.Spec.Applications) != 1 {
+		t.Fatalf("applications on device = %+v, want one", device.Spec.Applications)
+	}
+
+	p.reconcilePodStatus(ctx)
+	status, err := p.GetPodStatus(ctx, "default", "web")
+	if err != nil {
+		t.Fatalf("GetPodStatus: %v", err)
+	}
+	if status.Phase != corev1.PodRunning {
+		t.Errorf("phase = %s, want Running", status.Phase)
+	}
+
+	if err := p.DeletePod(ctx, pod); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+	device, _ = server.Device("device-1")
+	if len(device.Spec.Applications) != 0 {
+		t.Errorf("applications on device after delete = %+v, want none", device.Spec.Applications)
+	}
+}
+
+func TestDrainStopsLoopsAndWritesQueuedUpdates(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}}},
+	}
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Drain(ctx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if p.reconcileCtx.Err() == nil {
+		t.Error("reconciliation still running after Drain")
+	}
+	if apps := fetchDevice(t, server, "device-1").Spec.Applications; len(apps) != 1 {
+		t.Errorf("applications = %+v, want the pod's application kept", apps)
+	}
+}
+
+func TestDryRunLeavesDevicesUnchanged(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1", DryRun: true}, func(cfg *flightctl.Config) {
+		cfg.DryRun = true
+	})
+	recorder := record.NewFakeRecorder(10)
+	p.SetEventRecorder(recorder)
+	ctx := context.Background()
+
+	if err := p.CreatePod(ctx, cpuPod("web", "100m")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if apps := fetchDevice(t, server, "d1").Spec.Applications; len(apps) != 0 {
+		t.Errorf("dry run wrote applications to the device: %+v", apps)
+	}
+	p.mu.RLock()
+	mapping := p.podMappings["default/web"]
+	p.mu.RUnlock()
+	if mapping == nil || !mapping.ReadyDeadline.IsZero() {
+		t.Fatalf("mapping = %+v, want the pod tracked without a ready deadline", mapping)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "DryRun") || !strings.Contains(event, "[d1]") {
+			t.Errorf("event = %q, want DryRun naming the device", event)
+		}
+	default:
+		t.Error("no DryRun event recorded")
+	}
+
+	// The device reads as deployed, so the pod is removed as usual
+	if err := p.DeletePod(ctx, cpuPod("web", "100m")); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if _, ok := p.podMappings["default/web"]; ok {
+		t.Error("deleted pod still tracked")
+	}
+}
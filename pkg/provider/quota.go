@@ -0,0 +1,178 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// NamespaceQuota is the most CPU and memory the pods of a namespace may
+// request on the node. Like Kubernetes resource quotas it counts each pod's
+// requests once, also for pods spread over several devices. Nil fields are
+// unlimited.
+type NamespaceQuota struct {
+	CPU    *resource.Quantity
+	Memory *resource.Quantity
+}
+
+// QuotaExceededError is returned when creating a pod would take its
+// namespace over its quota.
+type QuotaExceededError struct {
+	Namespace string
+	Resource  corev1.ResourceName
+	Requested resource.Quantity
+	Used      resource.Quantity
+	Quota     resource.Quantity
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("namespace %s would exceed its %s quota: pod requests %s, %s of %s already requested",
+		e.Namespace, e.Resource, e.Requested.String(), e.Used.String(), e.Quota.String())
+}
+
+// ParseNamespaceQuotas builds quotas from namespace=quantity budgets of CPU
+// and memory. The AnyNamespace entry is the budget of each namespace without
+// one of its own.
+func ParseNamespaceQuotas(cpu, memory map[string]string) (map[string]NamespaceQuota, error) {
+	if len(cpu) == 0 && len(memory) == 0 {
+		return nil, nil
+	}
+	quotas := make(map[string]NamespaceQuota)
+	parse := func(budgets map[string]string, name corev1.ResourceName, set func(*NamespaceQuota, *resource.Quantity)) error {
+		for namespace, value := range budgets {
+			quantity, err := resource.ParseQuantity(strings.TrimSpace(value))
+			if err != nil {
+				return fmt.Errorf("invalid %s quota %q for namespace %s: %w", name, value, namespace, err)
+			}
+			if quantity.Sign() < 0 {
+				return fmt.Errorf("invalid %s quota %q for namespace %s: must not be negative", name, value, namespace)
+			}
+			quota := quotas[namespace]
+			set(&quota, &quantity)
+			quotas[namespace] = quota
+		}
+		return nil
+	}
+	if err := parse(cpu, corev1.ResourceCPU, func(q *NamespaceQuota, v *resource.Quantity) { q.CPU = v }); err != nil {
+		return nil, err
+	}
+	if err := parse(memory, corev1.ResourceMemory, func(q *NamespaceQuota, v *resource.Quantity) { q.Memory = v }); err != nil {
+		return nil, err
+	}
+	return quotas, nil
+}
+
+// namespaceQuota returns the quota of a namespace, if it has one.
+func (p *Provider) namespaceQuota(namespace string) (NamespaceQuota, bool) {
+	if quota, ok := p.quotas[namespace]; ok {
+		return quota, true
+	}
+	quota, ok := p.quotas[AnyNamespace]
+	return quota, ok
+}
+
+// namespaceUsageLocked sums the requests of the tracked pods of each
+// namespace, other than completed ones and the pod with key exclude.
+// Caller must hold p.mu.
+func (p *Provider) namespaceUsageLocked(exclude string) map[string]models.ResourceList {
+	usage := make(map[string]models.ResourceList)
+	for key, mapping := range p.podMappings {
+		if key == exclude || mapping.IsCompleted() {
+			continue
+		}
+		usage[mapping.Namespace] = usage[mapping.Namespace].Add(mapping.Requests)
+	}
+	return usage
+}
+
+// checkQuotaLocked returns a QuotaExceededError if the pod's requests would
+// take its namespace over its quota. A tracked pod of the same name, which
+// the pod replaces, is not counted. Caller must hold p.mu.
+func (p *Provider) checkQuotaLocked(pod *corev1.Pod) error {
+	quota, ok := p.namespaceQuota(pod.Namespace)
+	if !ok {
+		return nil
+	}
+	used := p.namespaceUsageLocked(pod.Namespace + "/" + pod.Name)[pod.Namespace]
+	requests := models.PodRequests(pod)
+	for _, check := range []struct {
+		name       corev1.ResourceName
+		limit      *resource.Quantity
+		used, want resource.Quantity
+	}{
+		{corev1.ResourceCPU, quota.CPU, used.CPU, requests.CPU},
+		{corev1.ResourceMemory, quota.Memory, used.Memory, requests.Memory},
+	} {
+		if check.limit == nil {
+			continue
+		}
+		total := check.used.DeepCopy()
+		total.Add(check.want)
+		if total.Cmp(*check.limit) > 0 {
+			return &QuotaExceededError{
+				Namespace: pod.Namespace,
+				Resource:  check.name,
+				Requested: check.want,
+				Used:      check.used,
+				Quota:     *check.limit,
+			}
+		}
+	}
+	return nil
+}
+
+// rejectOverQuota reports a pod rejected for its namespace quota.
+func (p *Provider) rejectOverQuota(pod *corev1.Pod, err error) {
+	p.recordEvent(pod, corev1.EventTypeWarning, "QuotaExceeded", "Pod rejected: %v", err)
+}
+
+// quotaMetricFamilies returns the requests and quotas of the namespaces
+// with a quota.
+func (p *Provider) quotaMetricFamilies() []*dto.MetricFamily {
+	if len(p.quotas) == 0 {
+		return nil
+	}
+	p.mu.RLock()
+	usage := p.namespaceUsageLocked("")
+	p.mu.RUnlock()
+
+	namespaces := make([]string, 0, len(p.quotas)+len(usage))
+	for namespace := range p.quotas {
+		if namespace != AnyNamespace {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	for namespace := range usage {
+		if _, ok := p.quotas[namespace]; !ok {
+			if _, ok := p.quotas[AnyNamespace]; ok {
+				namespaces = append(namespaces, namespace)
+			}
+		}
+	}
+	sort.Strings(namespaces)
+
+	cpuRequests := newMetricFamily("namespace_cpu_requests_cores", "CPU requested by the pods of the namespace on the node in cores", dto.MetricType_GAUGE)
+	cpuQuota := newMetricFamily("namespace_cpu_quota_cores", "CPU the pods of the namespace may request on the node in cores", dto.MetricType_GAUGE)
+	memoryRequests := newMetricFamily("namespace_memory_requests_bytes", "Memory requested by the pods of the namespace on the node in bytes", dto.MetricType_GAUGE)
+	memoryQuota := newMetricFamily("namespace_memory_quota_bytes", "Memory the pods of the namespace may request on the node in bytes", dto.MetricType_GAUGE)
+	for _, namespace := range namespaces {
+		quota, _ := p.namespaceQuota(namespace)
+		used := usage[namespace]
+		if quota.CPU != nil {
+			addSample(cpuRequests, used.CPU.AsApproximateFloat64(), time.Time{}, "namespace", namespace)
+			addSample(cpuQuota, quota.CPU.AsApproximateFloat64(), time.Time{}, "namespace", namespace)
+		}
+		if quota.Memory != nil {
+			addSample(memoryRequests, used.Memory.AsApproximateFloat64(), time.Time{}, "namespace", namespace)
+			addSample(memoryQuota, quota.Memory.AsApproximateFloat64(), time.Time{}, "namespace", namespace)
+		}
+	}
+	return []*dto.MetricFamily{cpuQuota, cpuRequests, memoryQuota, memoryRequests}
+}
@@ -0,0 +1,72 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func TestParseNamespaceQuotas(t *testing.T) {
+	quotas, err := ParseNamespaceQuotas(map[string]string{"tenant-a": "2"}, map[string]string{"tenant-a": "1Gi", "*": "512Mi"})
+	if err != nil {
+		t.Fatalf("ParseNamespaceQuotas: %v", err)
+	}
+	if q := quotas["tenant-a"]; q.CPU.String() != "2" || q.Memory.String() != "1Gi" {
+		t.Errorf("tenant-a quota = %+v, want 2 CPU and 1Gi", q)
+	}
+	if q := quotas[AnyNamespace]; q.CPU != nil || q.Memory.String() != "512Mi" {
+		t.Errorf("* quota = %+v, want 512Mi memory only", q)
+	}
+	if _, err := ParseNamespaceQuotas(map[string]string{"tenant-a": "two"}, nil); err == nil {
+		t.Error("expected an error for an invalid quantity")
+	}
+}
+
+func TestNamespaceQuotaRejectsPods(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "", nil)
+	quotas, _ := ParseNamespaceQuotas(map[string]string{"tenant-a": "1"}, nil)
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1", NamespaceQuotas: quotas})
+	ctx := context.Background()
+
+	create := func(name, namespace string) error {
+		pod := cpuPod(name, "600m")
+		pod.Namespace = namespace
+		return p.CreatePod(ctx, pod)
+	}
+	if err := create("a", "tenant-a"); err != nil {
+		t.Fatalf("CreatePod a: %v", err)
+	}
+	var exceeded *QuotaExceededError
+	if err := create("b", "tenant-a"); !errors.As(err, &exceeded) {
+		t.Fatalf("CreatePod b error = %v, want quota exceeded", err)
+	}
+	if exceeded.Used.String() != "600m" || exceeded.Quota.String() != "1" {
+		t.Errorf("exceeded = %+v, want 600m used of 1", exceeded)
+	}
+	if err := create("c", "tenant-b"); err != nil {
+		t.Errorf("CreatePod in a namespace without quota: %v", err)
+	}
+
+	families, err := p.GetMetricsResource(ctx)
+	if err != nil {
+		t.Fatalf("GetMetricsResource: %v", err)
+	}
+	found := false
+	for _, family := range families {
+		if family.GetName() != "namespace_cpu_requests_cores" {
+			continue
+		}
+		for _, metric := range family.Metric {
+			if metric.Label[0].GetValue() == "tenant-a" && metric.GetGauge().GetValue() == 0.6 {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Error("expected namespace_cpu_requests_cores of 0.6 for tenant-a")
+	}
+}
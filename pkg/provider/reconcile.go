@@ -0,0 +1,396 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/tracing"
+)
+
+// Pod status is refreshed per device rather than per pod: every reconcile
+// interval each device running tracked pods is queued, and a pool of workers
+// reads each queued device once and updates the status of all its pods.
+// Devices are queued with a random delay of up to the reconcile jitter, so
+// the reads are spread out instead of bursting at every tick. A device that
+// cannot be read is retried with a per-device exponential backoff. Creating
+// or updating a pod queues its devices right away.
+
+// DefaultReconcileWorkers is how many devices are reconciled concurrently.
+const DefaultReconcileWorkers = 4
+
+// Backoff of devices whose status could not be read.
+const (
+	reconcileRetryBaseDelay = time.Second
+	reconcileRetryMaxDelay  = 2 * time.Minute
+)
+
+// errStatusNotRead marks the devices of a spread pod not read yet.
+var errStatusNotRead = errors.New("status not read yet")
+
+// newReconcileQueue returns the queue of devices to reconcile.
+func newReconcileQueue() workqueue.RateLimitingInterface {
+	return workqueue.NewRateLimitingQueue(
+		workqueue.NewItemExponentialFailureRateLimiter(reconcileRetryBaseDelay, reconcileRetryMaxDelay))
+}
+
+// reconcileCycle tracks the devices queued at a reconcile interval until the
+// workers are done with them, so that a cycle outlasting the interval is not
+// overlapped by the next one.
+type reconcileCycle struct {
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+// start begins a cycle reconciling the devices, unless the previous one is
+// still running. It returns the number of devices the previous cycle has
+// left.
+func (c *reconcileCycle) start(devices []string) (left int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) > 0 {
+		return len(c.pending)
+	}
+	c.pending = make(map[string]bool, len(devices))
+	for _, deviceID := range devices {
+		c.pending[deviceID] = true
+	}
+	return 0
+}
+
+// done records that a device was reconciled, successfully or not.
+func (c *reconcileCycle) done(deviceID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, deviceID)
+}
+
+// syncPodStatusLoop queues the devices running tracked pods every reconcile
+// interval, until the provider is shut down. An interval is skipped while
+// the devices queued at the previous one are still being reconciled.
+func (p *Provider) syncPodStatusLoop() {
+	defer p.reconcileQueue.ShutDown()
+
+	ticker := time.NewTicker(p.Tunables().ReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.reconcileCtx.Done():
+			logger.Info("Status reconciliation loop stopped")
+			return
+		case <-p.reconcileIntervalChanged:
+			ticker.Reset(p.Tunables().ReconcileInterval)
+		case <-ticker.C:
+			if p.checkAPIOutage() {
+				// Pod statuses are served stale until the API recovers
+				continue
+			}
+			p.cleanupCompletedPods(p.reconcileCtx)
+			p.pruneRetainedPods()
+			var devices []string
+			for _, deviceID := range p.reconcileDevices() {
+				// Devices that failed, e.g. timed out, are retried with
+				// backoff, so a slow device does not take a worker every
+				// interval
+				if p.reconcileQueue.NumRequeues(deviceID) == 0 {
+					devices = append(devices, deviceID)
+				}
+			}
+			if left := p.reconcileCycle.start(devices); left > 0 {
+				logger.Warn("Status reconciliation of node %s still has %d devices left from the previous interval, skipping this one", p.nodeName, left)
+				continue
+			}
+			jitter := p.Tunables().ReconcileJitter
+			for _, deviceID := range devices {
+				if jitter > 0 {
+					p.reconcileQueue.AddAfter(deviceID, rand.N(jitter))
+				} else {
+					p.reconcileQueue.Add(deviceID)
+				}
+			}
+		}
+	}
+}
+
+// reconcileWorker reconciles queued devices until the queue is shut down.
+func (p *Provider) reconcileWorker(ctx context.Context) {
+	for {
+		item, shutdown := p.reconcileQueue.Get()
+		if shutdown {
+			return
+		}
+		deviceID := item.(string)
+		if err := p.reconcileDevice(ctx, deviceID); err != nil && ctx.Err() == nil {
+			if errors.Is(err, flightctl.ErrTimeout) {
+				logger.Warn("Reading the status of pods on device %s timed out, retrying with backoff: %v", deviceID, err)
+			} else {
+				logger.Error("Failed to get status for pods on device %s: %v", deviceID, err)
+			}
+			p.reconcileQueue.AddRateLimited(deviceID)
+		} else {
+			p.reconcileQueue.Forget(deviceID)
+		}
+		p.reconcileCycle.done(deviceID)
+		p.reconcileQueue.Done(deviceID)
+	}
+}
+
+// queueReconcile queues devices for an immediate status refresh.
+func (p *Provider) queueReconcile(deviceIDs ...string) {
+	for _, deviceID := range deviceIDs {
+		p.reconcileQueue.Add(deviceID)
+	}
+}
+
+// reconcilePodStatus refreshes the status of all tracked pods, reconciling
+// as many devices at once as there are reconcile workers.
+func (p *Provider) reconcilePodStatus(ctx context.Context) {
+	ctx, span := tracing.Tracer().Start(ctx, "Provider.reconcilePodStatus")
+	defer span.End()
+
+	p.refreshFleetRollouts(ctx)
+	devices := p.reconcileDevices()
+	span.SetAttributes(attribute.Int("devices", len(devices)))
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, p.reconcileWorkers)
+	for _, deviceID := range devices {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if err := p.reconcileDevice(ctx, deviceID); err != nil {
+				logger.Error("Failed to get status for pods on device %s: %v", deviceID, err)
+			}
+		}()
+	}
+	wg.Wait()
+	p.syncMappingRecords(ctx)
+}
+
+// reconcileDevices returns the devices running pods whose status is
+// refreshed, and forgets the device statuses of spread pods no longer
+// tracked.
+func (p *Provider) reconcileDevices() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for podKey := range p.spreadStatuses {
+		if mapping, ok := p.podMappings[podKey]; !ok || !mapping.IsSpread() {
+			delete(p.spreadStatuses, podKey)
+		}
+	}
+
+	var devices []string
+	for _, mapping := range p.podMappings {
+		if mapping.RolledBack || mapping.InFlight || mapping.IsCompleted() || mapping.IsAwaitingDevice() {
+			continue
+		}
+		for _, deviceID := range mapping.Devices() {
+			if !slices.Contains(devices, deviceID) {
+				devices = append(devices, deviceID)
+			}
+		}
+	}
+	slices.Sort(devices)
+	return devices
+}
+
+// reconcileDevice reads a device once and updates the status of the pods on
+// it. It returns an error if the device could not be read.
+func (p *Provider) reconcileDevice(ctx context.Context, deviceID string) error {
+	ctx, span := tracing.Tracer().Start(ctx, "Provider.reconcileDevice",
+		trace.WithAttributes(tracing.DeviceIDKey.String(deviceID)))
+	defer span.End()
+
+	// Pods on disconnected devices keep their NotReady status until the
+	// device reconnects or the timeout is handled; rolled back pods stay
+	// Failed, completed pods keep their final status, and pods being created
+	// or deleted, or held for an offline device, are left until done. Spread
+	// pods count the device as offline.
+	p.mu.RLock()
+	_, disconnected := p.disconnects[deviceID]
+	var mappings []*models.PodDeviceMapping
+	for _, mapping := range p.podMappings {
+		if mapping.RolledBack || mapping.InFlight || mapping.IsCompleted() || mapping.IsAwaitingDevice() || !slices.Contains(mapping.Devices(), deviceID) {
+			continue
+		}
+		if disconnected && !mapping.IsSpread() {
+			continue
+		}
+		mappings = append(mappings, mapping)
+	}
+	p.mu.RUnlock()
+	span.SetAttributes(attribute.Int("pods", len(mappings)))
+	if len(mappings) == 0 {
+		return nil
+	}
+
+	pods := make([]*corev1.Pod, len(mappings))
+	for i, mapping := range mappings {
+		pods[i] = podForMapping(mapping)
+	}
+	results, err := p.podManager.GetPodStatuses(ctx, pods, deviceID)
+	removed := errors.Is(err, flightctl.ErrNotFound)
+	if removed {
+		// The device was removed, and its applications with it
+		results = make([]flightctl.PodStatusResult, len(mappings))
+		for i := range results {
+			results[i].Err = err
+		}
+	} else if err != nil {
+		tracing.RecordError(span, err)
+		return err
+	}
+
+	for i, mapping := range mappings {
+		switch {
+		case mapping.IsSpread():
+			p.updateSpreadStatus(ctx, mapping, deviceID, results[i])
+		case removed:
+			// Left to the disconnection monitor, which moves or fails the
+			// pods of removed devices
+		default:
+			p.updatePodStatus(ctx, mapping, results[i])
+		}
+	}
+	if !disconnected && !removed {
+		p.refreshLogCache(ctx, deviceID, mappings)
+	}
+	return nil
+}
+
+// updatePodStatus caches the status read for a pod.
+func (p *Provider) updatePodStatus(ctx context.Context, mapping *models.PodDeviceMapping, result flightctl.PodStatusResult) {
+	switch {
+	case errors.Is(result.Err, flightctl.ErrDeviceOffline):
+		// Left to the disconnection monitor
+		logger.Debug("Skipping status for pod %s/%s: %v", mapping.Namespace, mapping.Name, result.Err)
+		return
+	case errors.Is(result.Err, flightctl.ErrNotFound):
+		// The application (or device) was removed outside the provider
+		p.failPod(mapping.PodKey, "ApplicationNotFound", result.Err.Error())
+		return
+	case result.Err != nil:
+		logger.Error("Failed to get status for pod %s/%s: %v", mapping.Namespace, mapping.Name, result.Err)
+		return
+	}
+
+	p.mu.Lock()
+	if !p.isCurrent(mapping) || result.NotReported && p.keepsTerminatedStatusLocked(mapping) {
+		p.mu.Unlock()
+		return
+	}
+	previous := mapping.Status
+	changed := mapping.SetStatus(result.Status, podStatusHash(result.Status))
+	if changed {
+		p.markCompleted(mapping)
+	}
+	status := mapping.Status
+	p.mu.Unlock()
+	if changed {
+		p.recordPhaseChange(mapping, previous, status)
+	}
+	p.checkReadyDeadline(ctx, mapping.PodKey, status)
+}
+
+// isCurrent reports whether a pod's status read can still be applied: the
+// pod was not replaced, rolled back, redeployed or completed while it was
+// read. Caller must hold p.mu.
+func (p *Provider) isCurrent(mapping *models.PodDeviceMapping) bool {
+	return p.podMappings[mapping.PodKey] == mapping && !mapping.RolledBack && !mapping.InFlight && !mapping.IsCompleted() && !mapping.IsAwaitingDevice()
+}
+
+// updateSpreadStatus records the status read for a spread pod on one of its
+// devices and caches the pod status aggregated over all of them.
+func (p *Provider) updateSpreadStatus(ctx context.Context, mapping *models.PodDeviceMapping, deviceID string, result flightctl.PodStatusResult) {
+	err := result.Err
+	if err != nil && !errors.Is(err, flightctl.ErrNotFound) && !errors.Is(err, flightctl.ErrDeviceOffline) {
+		logger.Warn("Failed to get status for pod %s/%s on device %s: %v", mapping.Namespace, mapping.Name, deviceID, err)
+	}
+
+	p.mu.Lock()
+	if !p.isCurrent(mapping) {
+		p.mu.Unlock()
+		return
+	}
+	statuses := p.spreadStatuses[mapping.PodKey]
+	if statuses == nil {
+		statuses = make(map[string]spreadDeviceStatus)
+		p.spreadStatuses[mapping.PodKey] = statuses
+	}
+	if mapping.RolloutFleet != "" && errors.Is(err, flightctl.ErrNotFound) {
+		err = errAwaitingRollout
+	}
+	statuses[deviceID] = spreadDeviceStatus{DeviceID: deviceID, Status: result.Status, Err: err}
+
+	results := make([]spreadDeviceStatus, 0, len(mapping.SpreadDevices))
+	for _, spreadDevice := range mapping.SpreadDevices {
+		status, ok := statuses[spreadDevice]
+		if !ok {
+			status = spreadDeviceStatus{DeviceID: spreadDevice, Err: errStatusNotRead}
+		}
+		results = append(results, status)
+	}
+	status := aggregateSpreadStatus(results, mapping.SpreadQuorum)
+	if mapping.RolloutFleet != "" {
+		status = p.withFleetRolloutLocked(mapping, status)
+	}
+	previous := mapping.Status
+	changed := mapping.SetStatus(status, podStatusHash(status))
+	if changed {
+		p.markCompleted(mapping)
+	}
+	status = mapping.Status
+	p.mu.Unlock()
+	if changed {
+		p.recordPhaseChange(mapping, previous, status)
+	}
+	p.checkReadyDeadline(ctx, mapping.PodKey, status)
+}
+
+// podStatusHash hashes a pod status without its timestamps. Statuses are
+// built afresh from each read of a device, with the time of the read in
+// their condition transition and container finish times; a status whose
+// hash did not change is not replaced, so the pod status reported to
+// Kubernetes, and the PodDeviceMapping resources, are only rewritten when
+// something other than those times changed.
+func podStatusHash(status *corev1.PodStatus) uint64 {
+	status = status.DeepCopy()
+	for i := range status.Conditions {
+		status.Conditions[i].LastProbeTime = metav1.Time{}
+		status.Conditions[i].LastTransitionTime = metav1.Time{}
+	}
+	for _, containers := range [][]corev1.ContainerStatus{status.InitContainerStatuses, status.ContainerStatuses, status.EphemeralContainerStatuses} {
+		for i := range containers {
+			if terminated := containers[i].State.Terminated; terminated != nil {
+				terminated.FinishedAt = metav1.Time{}
+			}
+			if terminated := containers[i].LastTerminationState.Terminated; terminated != nil {
+				terminated.FinishedAt = metav1.Time{}
+			}
+		}
+	}
+	// Marshaling a PodStatus cannot fail
+	data, _ := json.Marshal(status)
+	hash := fnv.New64a()
+	hash.Write(data)
+	return hash.Sum64()
+}
@@ -0,0 +1,295 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/audit"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// stopReconcileWorkers stops the background status refreshes, for tests that
+// drive reconciliation with reconcilePodStatus.
+func stopReconcileWorkers(p *Provider) {
+	p.reconcileQueue.ShutDown()
+}
+
+// deviceReads counts the reads of a device received by the server.
+func deviceReads(server *fake.Server, deviceID string) int {
+	n := 0
+	for _, r := range server.Requests() {
+		if r.Method == "GET" && r.Path == "/api/v1/devices/"+deviceID {
+			n++
+		}
+	}
+	return n
+}
+
+func TestReconcileReadsEachDeviceOnce(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("d1", "edge", nil)
+	server.AddDevice("d2", "edge", nil)
+
+	p := newTestProvider(t, server, Config{NodeName: "vk-test", DefaultFleet: "edge"})
+	stopReconcileWorkers(p)
+
+	ctx := context.Background()
+	for name, deviceID := range map[string]string{"web": "d1", "api": "d1", "db": "d2"} {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: map[string]string{deviceIDAnnotation: deviceID}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app:1"}}},
+		}
+		if err := p.CreatePod(ctx, pod); err != nil {
+			t.Fatalf("CreatePod %s: %v", name, err)
+		}
+	}
+
+	d1, d2 := deviceReads(server, "d1"), deviceReads(server, "d2")
+	p.reconcilePodStatus(ctx)
+	if n := deviceReads(server, "d1") - d1; n != 1 {
+		t.Errorf("device d1 read %d times for its two pods, want once", n)
+	}
+	if n := deviceReads(server, "d2") - d2; n != 1 {
+		t.Errorf("device d2 read %d times, want once", n)
+	}
+	for _, name := range []string{"web", "api", "db"} {
+		status, err := p.GetPodStatus(ctx, "default", name)
+		if err != nil {
+			t.Fatalf("GetPodStatus %s: %v", name, err)
+		}
+		if status.Phase != corev1.PodRunning {
+			t.Errorf("pod %s phase = %s, want Running", name, status.Phase)
+		}
+	}
+}
+
+func TestCreatedPodStatusIsRefreshedOnDemand(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+
+	// The pod's device is queued on creation rather than at the next interval
+	ctx := context.Background()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}}},
+	}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := p.GetPodStatus(ctx, "default", "web")
+		if err != nil {
+			t.Fatalf("GetPodStatus: %v", err)
+		}
+		if status.Phase == corev1.PodRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("phase = %s, want Running without waiting for the reconcile interval", status.Phase)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReconcileJitterDefaults(t *testing.T) {
+	tunables := Tunables{ReconcileInterval: 30 * time.Second}
+	if err := tunables.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if tunables.ReconcileJitter != 3*time.Second {
+		t.Errorf("jitter = %s, want a tenth of the interval", tunables.ReconcileJitter)
+	}
+
+	tunables.ReconcileJitter = 30 * time.Second
+	if err := tunables.Validate(); err == nil {
+		t.Error("expected an error for jitter as long as the interval")
+	}
+}
+
+func TestNodeIntervalDefaults(t *testing.T) {
+	cfg := Config{NodeName: "node"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if cfg.DeviceRefreshInterval != DefaultDeviceRefreshInterval || cfg.InformerResyncPeriod != DefaultInformerResyncPeriod || cfg.NodeHeartbeatInterval != DefaultNodeHeartbeatInterval {
+		t.Errorf("intervals = %s, %s, %s, want the defaults", cfg.DeviceRefreshInterval, cfg.InformerResyncPeriod, cfg.NodeHeartbeatInterval)
+	}
+
+	for _, cfg := range []Config{
+		{NodeName: "node", DeviceRefreshInterval: time.Millisecond},
+		{NodeName: "node", InformerResyncPeriod: -time.Second},
+		{NodeName: "node", NodeHeartbeatInterval: 500 * time.Millisecond},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected an error for intervals under 1s: %+v", cfg)
+		}
+	}
+}
+
+func TestReconcileActionsAreAudited(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "edge", nil)
+
+	trail := audit.NewTrail(10, false)
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1", AuditTrail: trail})
+	stopReconcileWorkers(p)
+
+	ctx := context.Background()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app:1"}}},
+	}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	p.reconcilePodStatus(ctx)
+	p.reconcilePodStatus(ctx)
+	if err := p.DeletePod(ctx, pod); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+
+	records := trail.Records("default/web", 0)
+	want := []struct {
+		operation models.ReconcileOperation
+		action    models.ReconcileAction
+	}{
+		{models.ReconcileCreate, models.ActionDeploy},
+		{models.ReconcileStatus, models.ActionNone}, // only the first status read changes the phase
+		{models.ReconcileDelete, models.ActionRemove},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d: %+v", len(records), len(want), records)
+	}
+	for i, w := range want {
+		r := records[i]
+		if r.Operation != w.operation || r.Action != w.action || r.Result != models.ResultSuccess || r.DeviceID != "d1" {
+			t.Errorf("record %d = %+v, want %s/%s succeeded on d1", i, r, w.operation, w.action)
+		}
+	}
+	if records[1].ActualState != corev1.PodRunning {
+		t.Errorf("status record phase = %s, want Running", records[1].ActualState)
+	}
+}
+
+func TestReconcileDevicesInParallel(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	const devices = 8
+	for i := range devices {
+		server.AddDevice(fmt.Sprintf("d%d", i), "edge", nil)
+	}
+
+	p := newTestProvider(t, server, Config{NodeName: "vk-test", DefaultFleet: "edge", ReconcileWorkers: 4})
+	stopReconcileWorkers(p)
+
+	ctx := context.Background()
+	for i := range devices {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("web-%d", i), Namespace: "default", Annotations: map[string]string{deviceIDAnnotation: fmt.Sprintf("d%d", i)}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app:1"}}},
+		}
+		if err := p.CreatePod(ctx, pod); err != nil {
+			t.Fatalf("CreatePod %s: %v", pod.Name, err)
+		}
+	}
+
+	// One device at a time would take at least 8 × 200ms
+	server.SetLatency(200 * time.Millisecond)
+	start := time.Now()
+	p.reconcilePodStatus(ctx)
+	if elapsed := time.Since(start); elapsed > 1200*time.Millisecond {
+		t.Errorf("reconciling %d devices with 4 workers took %s, want about 2 × 200ms", devices, elapsed)
+	}
+}
+
+func TestReconcileCycleSkipsWhileRunning(t *testing.T) {
+	var cycle reconcileCycle
+	if left := cycle.start([]string{"d1", "d2"}); left != 0 {
+		t.Fatalf("first cycle: %d devices left, want it started", left)
+	}
+	cycle.done("d1")
+	if left := cycle.start([]string{"d1", "d2", "d3"}); left != 1 {
+		t.Errorf("cycle while d2 is pending: %d devices left, want 1", left)
+	}
+	cycle.done("d2")
+	if left := cycle.start([]string{"d3"}); left != 0 {
+		t.Errorf("cycle after the previous one finished: %d devices left, want it started", left)
+	}
+}
+
+func TestReconcileKeepsUnchangedStatus(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	stopReconcileWorkers(p)
+
+	ctx := context.Background()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}}},
+	}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	cachedStatus := func() *corev1.PodStatus {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return p.podMappings["default/web"].Status
+	}
+
+	p.reconcilePodStatus(ctx)
+	running := cachedStatus()
+	if running.Phase != corev1.PodRunning {
+		t.Fatalf("phase = %s, want Running", running.Phase)
+	}
+
+	// Reads only differing in their timestamps keep the cached status
+	time.Sleep(10 * time.Millisecond)
+	p.reconcilePodStatus(ctx)
+	if status := cachedStatus(); status != running {
+		t.Errorf("status replaced by an unchanged read:\n%+v\nwas\n%+v", status, running)
+	}
+
+	if err := server.SetApplicationStatus("device-1", flightctl.FlightctlApplicationStatus{Name: "default-web", Status: "Error"}); err != nil {
+		t.Fatal(err)
+	}
+	p.reconcilePodStatus(ctx)
+	if status := cachedStatus(); status.Phase != corev1.PodFailed {
+		t.Errorf("phase = %s after the application failed, want Failed", status.Phase)
+	}
+}
+
+func TestPodStatusHashIgnoresTimestamps(t *testing.T) {
+	status := func(at time.Time, phase corev1.PodPhase) *corev1.PodStatus {
+		return &corev1.PodStatus{
+			Phase:      phase,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse, LastTransitionTime: metav1.NewTime(at)}},
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "app",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, FinishedAt: metav1.NewTime(at)}},
+			}},
+		}
+	}
+	now := time.Now()
+	if podStatusHash(status(now, corev1.PodFailed)) != podStatusHash(status(now.Add(time.Minute), corev1.PodFailed)) {
+		t.Error("statuses differing only in their timestamps hash differently")
+	}
+	if podStatusHash(status(now, corev1.PodFailed)) == podStatusHash(status(now, corev1.PodSucceeded)) {
+		t.Error("statuses of different phases hash the same")
+	}
+}
@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// Devices stop reporting the applications they stopped, so the final status
+// of a pod would otherwise be lost: a pod that succeeded or failed would go
+// back to Pending once its device drops its application, and a deleted pod
+// would have no status at all once it is no longer tracked. For the
+// terminated pod retention, a pod keeps its Succeeded or Failed status after
+// its device stops reporting its application, and a deleted pod that had
+// succeeded or failed is still served with that status by GetPod and
+// GetPodStatus. Deleted pods still running are not retained: their status
+// would contradict the terminated one virtual-kubelet reports for them. The
+// debug state shows the retained pods, and the last phase transitions of
+// every pod.
+
+// DefaultTerminatedPodRetention is how long the final statuses of
+// terminated pods are kept.
+const DefaultTerminatedPodRetention = 5 * time.Minute
+
+// retainedPod is the last state of a deleted pod that had terminated.
+type retainedPod struct {
+	uid       types.UID
+	status    *corev1.PodStatus
+	history   []models.PhaseTransition
+	deletedAt time.Time
+}
+
+// retainPodLocked keeps the last state of a pod no longer tracked, if it
+// had terminated. Caller must hold p.mu.
+func (p *Provider) retainPodLocked(mapping *models.PodDeviceMapping) {
+	if _, terminated := mapping.TerminatedSince(); p.terminatedPodRetention <= 0 || !terminated {
+		return
+	}
+	p.retainedPods[mapping.PodKey] = &retainedPod{
+		uid:       mapping.PodUID,
+		status:    mapping.Status,
+		history:   mapping.PhaseHistory,
+		deletedAt: time.Now(),
+	}
+}
+
+// retainedPod returns the last state of a deleted pod, unless it was
+// deleted longer than the retention ago.
+func (p *Provider) retainedPod(podKey string) (*retainedPod, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	retained, ok := p.retainedPods[podKey]
+	if !ok || time.Since(retained.deletedAt) >= p.terminatedPodRetention {
+		return nil, false
+	}
+	return retained, true
+}
+
+// pruneRetainedPods forgets the deleted pods retained longer than the
+// retention.
+func (p *Provider) pruneRetainedPods() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for podKey, retained := range p.retainedPods {
+		if time.Since(retained.deletedAt) >= p.terminatedPodRetention {
+			delete(p.retainedPods, podKey)
+		}
+	}
+}
+
+// keepsTerminatedStatusLocked reports whether a pod keeps its Succeeded or
+// Failed status although its device no longer reports its application.
+// Caller must hold p.mu.
+func (p *Provider) keepsTerminatedStatusLocked(mapping *models.PodDeviceMapping) bool {
+	since, terminated := mapping.TerminatedSince()
+	return terminated && p.terminatedPodRetention > 0 && time.Since(since) < p.terminatedPodRetention
+}
@@ -0,0 +1,105 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func TestTerminatedPodKeepsStatus(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	stopReconcileWorkers(p)
+
+	ctx := context.Background()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid-1"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}}},
+	}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	p.reconcilePodStatus(ctx)
+	if err := server.SetApplicationStatus("device-1", flightctl.FlightctlApplicationStatus{Name: "default-web", Status: "Error", Summary: "Exited (3)"}); err != nil {
+		t.Fatal(err)
+	}
+	p.reconcilePodStatus(ctx)
+
+	// The device stops reporting the failed application
+	if err := server.RemoveApplicationStatus("device-1", "default-web"); err != nil {
+		t.Fatal(err)
+	}
+	p.reconcilePodStatus(ctx)
+	status, err := p.GetPodStatus(ctx, "default", "web")
+	if err != nil {
+		t.Fatalf("GetPodStatus: %v", err)
+	}
+	if status.Phase != corev1.PodFailed {
+		t.Errorf("phase = %s once the device stopped reporting the application, want Failed kept", status.Phase)
+	}
+
+	state := p.DebugState()
+	if len(state.Pods) != 1 {
+		t.Fatalf("debug pods = %+v, want one", state.Pods)
+	}
+	history := state.Pods[0].PhaseHistory
+	if len(history) != 2 || history[0].To != corev1.PodRunning || history[1].From != corev1.PodRunning || history[1].To != corev1.PodFailed {
+		t.Errorf("phase history = %+v, want Pending -> Running -> Failed", history)
+	}
+
+	// Past the retention, the pod is back to waiting for its application
+	p.terminatedPodRetention = time.Nanosecond
+	p.reconcilePodStatus(ctx)
+	if status, _ := p.GetPodStatus(ctx, "default", "web"); status.Phase != corev1.PodPending {
+		t.Errorf("phase = %s past the retention, want Pending", status.Phase)
+	}
+}
+
+func TestDeletedPodStatusIsRetained(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	stopReconcileWorkers(p)
+
+	ctx := context.Background()
+	pod := jobPod(corev1.RestartPolicyNever)
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if err := server.SetApplicationStatus("device-1", flightctl.FlightctlApplicationStatus{Name: "default-job", Status: "Completed"}); err != nil {
+		t.Fatal(err)
+	}
+	p.reconcilePodStatus(ctx)
+	if err := p.DeletePod(ctx, pod); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+
+	status, err := p.GetPodStatus(ctx, "default", "job")
+	if err != nil {
+		t.Fatalf("GetPodStatus of the deleted pod: %v", err)
+	}
+	if status.Phase != corev1.PodSucceeded {
+		t.Errorf("phase = %s, want the final Succeeded", status.Phase)
+	}
+	if pods, _ := p.GetPods(ctx); len(pods) != 0 {
+		t.Errorf("GetPods = %d pods, want the deleted pod left out", len(pods))
+	}
+	if retained := p.DebugState().RetainedPods; len(retained) != 1 || retained[0].Phase != corev1.PodSucceeded {
+		t.Errorf("retained pods = %+v, want the deleted pod", retained)
+	}
+
+	p.terminatedPodRetention = time.Nanosecond
+	p.pruneRetainedPods()
+	if _, err := p.GetPodStatus(ctx, "default", "job"); err == nil {
+		t.Error("GetPodStatus succeeded past the retention, want the pod not found")
+	}
+}
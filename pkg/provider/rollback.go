@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// startReadyDeadline gives a newly deployed or updated pod the deployment
+// ready timeout to start running. On update, the last spec known to run is
+// kept so it can be restored. Pods never start in a dry run, so they get no
+// deadline, and pods rolled out by their fleet's rollout policy are paced by
+// it instead. Caller must hold p.mu.
+func (p *Provider) startReadyDeadline(mapping *models.PodDeviceMapping, previous *corev1.Pod) {
+	if p.dryRun || mapping.RolloutFleet != "" {
+		return
+	}
+	if mapping.ReadyDeadline.IsZero() {
+		// A pending deployment has not proven itself, so keep the spec
+		// restored for it (nil for a new pod) instead
+		mapping.PreviousPod = previous
+	}
+	mapping.ReadyDeadline = time.Now().Add(p.tunables.DeploymentReadyTimeout)
+}
+
+// checkReadyDeadline clears the ready deadline of a pod whose application
+// started running, and rolls back the deployment of one that did not by its
+// deadline: the application is removed from its devices, or the previous
+// definition restored after an update, and the pod is failed.
+func (p *Provider) checkReadyDeadline(ctx context.Context, podKey string, status *corev1.PodStatus) {
+	p.mu.Lock()
+	mapping, ok := p.podMappings[podKey]
+	if !ok || mapping.ReadyDeadline.IsZero() {
+		p.mu.Unlock()
+		return
+	}
+	if status.Phase == corev1.PodRunning || status.Phase == corev1.PodSucceeded {
+		mapping.ReadyDeadline = time.Time{}
+		mapping.PreviousPod = nil
+		p.mu.Unlock()
+		return
+	}
+	if time.Now().Before(mapping.ReadyDeadline) {
+		p.mu.Unlock()
+		return
+	}
+
+	pod := podForMapping(mapping)
+	previous := mapping.PreviousPod
+	devices := mapping.Devices()
+	timeout := p.tunables.DeploymentReadyTimeout
+	mapping.ReadyDeadline = time.Time{}
+	mapping.PreviousPod = nil
+	mapping.RolledBack = true
+	p.mu.Unlock()
+
+	log := logger.FromContext(ctx).With("pod", podKey)
+	action := "removed the application"
+	if previous != nil {
+		action = "restored the previous application definition"
+	}
+	log.Warn("Pod %s did not start running within %s (phase %s), rolling back: %s on %v",
+		podKey, timeout, status.Phase, action, devices)
+
+	record := reconcileRecord(podKey, models.ReconcileUpdate, models.ActionRemove, devices...)
+	if previous != nil {
+		record.Action = models.ActionReplace
+	}
+	record.ActualState = status.Phase
+	record.Message = fmt.Sprintf("rolled back: %s", action)
+	started := time.Now()
+	var rollbackErr error
+	for _, deviceID := range devices {
+		var err error
+		if previous != nil {
+			err = p.podManager.UpdatePod(ctx, previous, deviceID)
+		} else {
+			err = p.podManager.DeletePod(ctx, pod, deviceID)
+		}
+		if err != nil {
+			log.Error("Rolling back pod %s on device %s: %v", podKey, deviceID, err)
+			rollbackErr = errors.Join(rollbackErr, fmt.Errorf("device %s: %w", deviceID, err))
+		}
+	}
+	p.recordReconcile(record, started, rollbackErr)
+
+	p.failPod(podKey, "DeploymentTimeout",
+		fmt.Sprintf("Application did not start running within %s; %s", timeout, action))
+}
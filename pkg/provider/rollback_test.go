@@ -0,0 +1,129 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+// expireReadyDeadline moves a pod's ready deadline into the past.
+func expireReadyDeadline(t *testing.T, p *Provider, podKey string) {
+	t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	mapping, ok := p.podMappings[podKey]
+	if !ok || mapping.ReadyDeadline.IsZero() {
+		t.Fatalf("pod %s has no ready deadline", podKey)
+	}
+	mapping.ReadyDeadline = time.Now().Add(-time.Second)
+}
+
+func TestNewPodIsRemovedWhenNotRunningByDeadline(t *testing.T) {
+	server := fake.NewServer(fake.WithApplicationStatus("Preparing"))
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	stopReconcileWorkers(p)
+
+	ctx := context.Background()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}}},
+	}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+
+	// Still within the deadline: the pod is left pending
+	p.reconcilePodStatus(ctx)
+	if apps := fetchDevice(t, server, "device-1").Spec.Applications; len(apps) != 1 {
+		t.Fatalf("applications = %+v, want one before the deadline", apps)
+	}
+
+	expireReadyDeadline(t, p, "default/web")
+	p.reconcilePodStatus(ctx)
+	if apps := fetchDevice(t, server, "device-1").Spec.Applications; len(apps) != 0 {
+		t.Errorf("applications = %+v, want the application removed", apps)
+	}
+
+	// The pod stays Failed on later reconciliations
+	p.reconcilePodStatus(ctx)
+	status, err := p.GetPodStatus(ctx, "default", "web")
+	if err != nil {
+		t.Fatalf("GetPodStatus: %v", err)
+	}
+	if status.Phase != corev1.PodFailed || status.Reason != "DeploymentTimeout" {
+		t.Errorf("status = %s/%s, want Failed/DeploymentTimeout", status.Phase, status.Reason)
+	}
+}
+
+func TestUpdateIsRevertedWhenNotRunningByDeadline(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	stopReconcileWorkers(p)
+
+	ctx := context.Background()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}}},
+	}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	p.reconcilePodStatus(ctx)
+
+	updated := pod.DeepCopy()
+	updated.Spec.Containers[0].Image = "nginx:broken"
+	if err := p.UpdatePod(ctx, updated); err != nil {
+		t.Fatalf("UpdatePod: %v", err)
+	}
+	if err := server.SetApplicationStatus("device-1", flightctl.FlightctlApplicationStatus{Name: "default-web", Status: "Error"}); err != nil {
+		t.Fatal(err)
+	}
+
+	expireReadyDeadline(t, p, "default/web")
+	p.reconcilePodStatus(ctx)
+
+	apps := fetchDevice(t, server, "device-1").Spec.Applications
+	if len(apps) != 1 || len(apps[0].Inline) == 0 || !strings.Contains(apps[0].Inline[0].Content, "nginx:1.25") {
+		t.Errorf("applications = %+v, want the nginx:1.25 definition restored", apps)
+	}
+	status, _ := p.GetPodStatus(ctx, "default", "web")
+	if status.Phase != corev1.PodFailed || status.Reason != "DeploymentTimeout" {
+		t.Errorf("status = %s/%s, want Failed/DeploymentTimeout", status.Phase, status.Reason)
+	}
+}
+
+func TestRunningPodClearsReadyDeadline(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	stopReconcileWorkers(p)
+
+	ctx := context.Background()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}}},
+	}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	p.reconcilePodStatus(ctx)
+
+	p.mu.RLock()
+	deadline := p.podMappings["default/web"].ReadyDeadline
+	p.mu.RUnlock()
+	if !deadline.IsZero() {
+		t.Errorf("ready deadline = %s, want cleared once running", deadline)
+	}
+}
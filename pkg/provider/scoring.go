@@ -0,0 +1,185 @@
+package provider
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// MaxDeviceScore is the score of the best device for a pod.
+const MaxDeviceScore = 100
+
+// DeviceScorer scores the devices a pod fits on when a device is selected
+// for it. A pod is placed on the device with the highest score, summed over
+// the scorers by weight; the placement strategy picks among devices with
+// the same score.
+type DeviceScorer interface {
+	// Name identifies the scorer in configuration and errors.
+	Name() string
+	// Score returns the score of placing the pod on the device, from 0 to
+	// MaxDeviceScore, or an error if the pod must not be placed on it.
+	// placed holds the pods on each device, other than the pod itself, and
+	// the device's Allocatable is what its pods leave free.
+	Score(device *models.Device, pod *corev1.Pod, placed map[string][]*corev1.Pod) (int, error)
+}
+
+// WeightedScorer is a DeviceScorer with the weight of its scores.
+type WeightedScorer struct {
+	Scorer DeviceScorer
+	Weight int
+}
+
+// Names of the built-in scorers.
+const (
+	ScorerFreeCPU     = "free-cpu"
+	ScorerFreeMemory  = "free-memory"
+	ScorerPodCount    = "pod-count"
+	ScorerTemperature = "temperature"
+	ScorerBattery     = "battery"
+)
+
+// builtinScorers are the built-in scorers by name.
+var builtinScorers = map[string]DeviceScorer{
+	ScorerFreeCPU:    resourceScorer{ScorerFreeCPU, func(r models.ResourceList) int64 { return r.CPU.MilliValue() }},
+	ScorerFreeMemory: resourceScorer{ScorerFreeMemory, func(r models.ResourceList) int64 { return r.Memory.Value() }},
+	ScorerPodCount:   podCountScorer{},
+	// Devices of unknown temperature score half the maximum, mains powered
+	// ones the maximum
+	ScorerTemperature: readingScorer{ScorerTemperature, MaxDeviceScore / 2, func(h models.DeviceHealth) *float64 { return h.Temperature },
+		func(celsius float64) float64 { return MaxDeviceScore - celsius }},
+	ScorerBattery: readingScorer{ScorerBattery, MaxDeviceScore, func(h models.DeviceHealth) *float64 { return h.Battery },
+		func(percent float64) float64 { return percent }},
+}
+
+// ParseDeviceScorers returns the built-in scorers with the given weights,
+// keyed by scorer name: free-cpu and free-memory prefer devices with more of
+// their capacity free, pod-count devices running fewer pods, temperature
+// cooler devices and battery devices with more charge, or mains power
+// (see flightctl.TemperatureInfoKey and flightctl.BatteryInfoKey). A weight
+// of 0 disables a scorer.
+func ParseDeviceScorers(weights map[string]string) ([]WeightedScorer, error) {
+	names := make([]string, 0, len(weights))
+	for name := range weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var scorers []WeightedScorer
+	for _, name := range names {
+		scorer, ok := builtinScorers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown device scorer %q (expected %s, %s, %s, %s or %s)",
+				name, ScorerFreeCPU, ScorerFreeMemory, ScorerPodCount, ScorerTemperature, ScorerBattery)
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(weights[name]))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q for device scorer %s: must be a non-negative integer", weights[name], name)
+		}
+		if weight > 0 {
+			scorers = append(scorers, WeightedScorer{Scorer: scorer, Weight: weight})
+		}
+	}
+	return scorers, nil
+}
+
+// withDeviceScoresLocked returns the devices the scorers and power limits
+// do not veto, narrowed to those with the best weighted score. Caller must
+// hold p.mu.
+func (p *Provider) withDeviceScoresLocked(pod *corev1.Pod, devices []*models.Device) ([]*models.Device, error) {
+	scorers := p.scorers
+	if p.power.enabled() {
+		scorers = append(slices.Clip(scorers), WeightedScorer{Scorer: p.power})
+	}
+	if len(scorers) == 0 {
+		return devices, nil
+	}
+	placed := p.devicePodsLocked(fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+
+	var best []*models.Device
+	bestScore := 0
+	var lastErr error
+	for _, device := range devices {
+		score, err := deviceScore(scorers, device, pod, placed)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		switch {
+		case len(best) == 0 || score > bestScore:
+			best, bestScore = []*models.Device{device}, score
+		case score == bestScore:
+			best = append(best, device)
+		}
+	}
+	if len(best) == 0 && lastErr != nil {
+		return nil, fmt.Errorf("device scorers rule out all %d devices: %w", len(devices), lastErr)
+	}
+	return best, nil
+}
+
+// deviceScore sums the weighted scores of a device, or returns the error of
+// the first scorer that vetoes it.
+func deviceScore(scorers []WeightedScorer, device *models.Device, pod *corev1.Pod, placed map[string][]*corev1.Pod) (int, error) {
+	total := 0
+	for _, weighted := range scorers {
+		score, err := weighted.Scorer.Score(device, pod, placed)
+		if err != nil {
+			return 0, fmt.Errorf("scorer %s vetoes device %s: %w", weighted.Scorer.Name(), device.ID, err)
+		}
+		total += weighted.Weight * min(max(score, 0), MaxDeviceScore)
+	}
+	return total, nil
+}
+
+// resourceScorer scores devices by the share of a resource they have free.
+// Devices that don't report the resource get half the maximum score.
+type resourceScorer struct {
+	name     string
+	quantity func(models.ResourceList) int64
+}
+
+func (s resourceScorer) Name() string { return s.name }
+
+func (s resourceScorer) Score(device *models.Device, _ *corev1.Pod, _ map[string][]*corev1.Pod) (int, error) {
+	capacity := s.quantity(device.Capacity)
+	if capacity <= 0 {
+		return MaxDeviceScore / 2, nil
+	}
+	free := min(max(s.quantity(device.Allocatable), 0), capacity)
+	return int(free * MaxDeviceScore / capacity), nil
+}
+
+// podCountScorer scores devices by the pods placed on them, fewer scoring
+// higher.
+type podCountScorer struct{}
+
+func (podCountScorer) Name() string { return ScorerPodCount }
+
+func (podCountScorer) Score(device *models.Device, _ *corev1.Pod, placed map[string][]*corev1.Pod) (int, error) {
+	return MaxDeviceScore / (1 + len(placed[device.ID])), nil
+}
+
+// readingScorer scores devices by a power or thermal reading of theirs.
+// Devices without the reading get the unreported score.
+type readingScorer struct {
+	name       string
+	unreported int
+	reading    func(models.DeviceHealth) *float64
+	score      func(value float64) float64
+}
+
+func (s readingScorer) Name() string { return s.name }
+
+func (s readingScorer) Score(device *models.Device, _ *corev1.Pod, _ map[string][]*corev1.Pod) (int, error) {
+	value := s.reading(device.Health)
+	if value == nil {
+		return s.unreported, nil
+	}
+	return int(s.score(*value)), nil
+}
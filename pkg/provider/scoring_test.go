@@ -0,0 +1,103 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// vetoScorer vetoes the devices of a set.
+type vetoScorer map[string]bool
+
+func (vetoScorer) Name() string { return "veto" }
+
+func (s vetoScorer) Score(device *models.Device, _ *corev1.Pod, _ map[string][]*corev1.Pod) (int, error) {
+	if s[device.ID] {
+		return 0, fmt.Errorf("device is vetoed")
+	}
+	return MaxDeviceScore, nil
+}
+
+func TestParseDeviceScorers(t *testing.T) {
+	scorers, err := ParseDeviceScorers(map[string]string{"pod-count": "2", "temperature": " 1", "battery": "0"})
+	if err != nil {
+		t.Fatalf("ParseDeviceScorers: %v", err)
+	}
+	if len(scorers) != 2 || scorers[0].Scorer.Name() != "pod-count" || scorers[0].Weight != 2 ||
+		scorers[1].Scorer.Name() != "temperature" || scorers[1].Weight != 1 {
+		t.Errorf("scorers = %+v, want pod-count with weight 2 and temperature with weight 1", scorers)
+	}
+
+	for _, weights := range []map[string]string{{"gpu": "1"}, {"free-cpu": "-1"}, {"free-cpu": "high"}} {
+		if _, err := ParseDeviceScorers(weights); err == nil {
+			t.Errorf("ParseDeviceScorers(%v) succeeded, want an error", weights)
+		}
+	}
+}
+
+func TestBuiltinScorers(t *testing.T) {
+	temperature, battery := 70.0, 30.0
+	device := &models.Device{
+		ID:          "d1",
+		Health:      models.DeviceHealth{Temperature: &temperature, Battery: &battery},
+		Capacity:    models.ResourceList{CPU: resource.MustParse("4")},
+		Allocatable: models.ResourceList{CPU: resource.MustParse("1")},
+	}
+	placed := map[string][]*corev1.Pod{"d1": {cpuPod("a", "1"), cpuPod("b", "1"), cpuPod("c", "1")}}
+
+	for name, want := range map[string]int{
+		ScorerFreeCPU:     25,
+		ScorerFreeMemory:  MaxDeviceScore / 2,
+		ScorerPodCount:    25,
+		ScorerTemperature: 30,
+		ScorerBattery:     30,
+	} {
+		if score, err := builtinScorers[name].Score(device, nil, placed); err != nil || score != want {
+			t.Errorf("%s score = %d (%v), want %d", name, score, err, want)
+		}
+	}
+	if score, _ := builtinScorers[ScorerBattery].Score(&models.Device{ID: "d2"}, nil, placed); score != MaxDeviceScore {
+		t.Errorf("battery score of a mains powered device = %d, want %d", score, MaxDeviceScore)
+	}
+}
+
+func TestDeviceScorersPickDevice(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("hot", "edge", map[string]string{flightctl.TemperatureLabel: "80"})
+	server.AddDevice("cool", "edge", map[string]string{flightctl.TemperatureLabel: "40C"})
+	server.AddDevice("warm", "edge", map[string]string{flightctl.TemperatureLabel: "60"})
+	p := newTestProvider(t, server, Config{NodeName: "fleet-edge", FleetID: "edge"})
+	ctx := context.Background()
+
+	p.scorers, _ = ParseDeviceScorers(map[string]string{ScorerTemperature: "1"})
+	if err := p.CreatePod(ctx, cpuPod("a", "100m")); err != nil {
+		t.Fatalf("CreatePod a: %v", err)
+	}
+	if device := p.placedDevice(t, "default/a"); device != "cool" {
+		t.Errorf("pod a placed on %s, want the coolest device", device)
+	}
+
+	p.scorers = append(p.scorers, WeightedScorer{Scorer: vetoScorer{"cool": true}, Weight: 1})
+	if err := p.CreatePod(ctx, cpuPod("b", "100m")); err != nil {
+		t.Fatalf("CreatePod b: %v", err)
+	}
+	if device := p.placedDevice(t, "default/b"); device != "warm" {
+		t.Errorf("pod b placed on %s, want the coolest device not vetoed", device)
+	}
+
+	p.scorers = []WeightedScorer{{Scorer: vetoScorer{"hot": true, "cool": true, "warm": true}, Weight: 1}}
+	err := p.CreatePod(ctx, cpuPod("c", "100m"))
+	if err == nil || !strings.Contains(err.Error(), "vetoes") {
+		t.Errorf("CreatePod c error = %v, want every device vetoed", err)
+	}
+}
@@ -0,0 +1,319 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// Spread pods run as an application on every ready device of a fleet, like a
+// DaemonSet scoped to the fleet.
+const (
+	// SpreadAnnotation selects how a pod is spread over devices.
+	SpreadAnnotation = "flightctl.io/spread"
+	// SpreadAllDevicesInFleet deploys the pod to every ready device in the
+	// targeted fleet.
+	SpreadAllDevicesInFleet = "all-devices-in-fleet"
+	// SpreadQuorumAnnotation is the number ("2") or percentage ("75%") of
+	// devices that must run the pod for it to be Ready. Defaults to all of
+	// them.
+	SpreadQuorumAnnotation = "flightctl.io/spread-quorum"
+)
+
+// isSpreadPod reports whether the pod asks to be spread over a fleet.
+func isSpreadPod(pod *corev1.Pod) bool {
+	_, ok := pod.Annotations[SpreadAnnotation]
+	return ok
+}
+
+// createSpreadPod deploys a spread pod to every ready device of its fleet
+// (the fleet-id annotation, the node's fleet or the default fleet) that
+// matches its device label selectors, or through the fleet's template if it
+// has a batch rollout policy and the pod targets all its devices. The pod is only tracked if at least
+// the quorum of deployments succeeded; otherwise the successful ones are
+// rolled back. The caller tracks the returned mapping.
+func (p *Provider) createSpreadPod(ctx context.Context, pod *corev1.Pod) (*models.PodDeviceMapping, error) {
+	log := logger.FromContext(ctx)
+
+	if mode := pod.Annotations[SpreadAnnotation]; mode != SpreadAllDevicesInFleet {
+		return nil, fmt.Errorf("unsupported %s annotation %q (expected %s)", SpreadAnnotation, mode, SpreadAllDevicesInFleet)
+	}
+	if p.deviceID != "" {
+		return nil, fmt.Errorf("node %s represents a single device and cannot run spread pods", p.nodeName)
+	}
+
+	fleetID := pod.Annotations[fleetIDAnnotation]
+	switch {
+	case p.fleetID != "" && fleetID != "" && fleetID != p.fleetID:
+		return nil, fmt.Errorf("pod requests fleet %s but node %s represents fleet %s", fleetID, p.nodeName, p.fleetID)
+	case p.fleetID != "":
+		fleetID = p.fleetID
+	case fleetID == "":
+		fleetID = p.defaultFleet
+	}
+	if fleetID == "" {
+		return nil, fmt.Errorf("spread pods need a fleet: set the %s annotation or a default fleet", fleetIDAnnotation)
+	}
+
+	selectors := deviceSelectorsFromNodeSelector(pod.Spec.NodeSelector)
+	devices, err := p.flightctl.ListDevices(ctx, fleetID, selectors)
+	if err != nil {
+		return nil, fmt.Errorf("listing devices in fleet %s: %w", fleetID, err)
+	}
+
+	// A fleet's template covers all its devices, so only pods targeting the
+	// whole fleet can be rolled out by its rollout policy
+	fleet, err := p.flightctl.GetFleetResource(ctx, fleetID)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case fleet.HasBatchRollout() && len(selectors) == 0:
+		return p.createFleetRolloutPod(ctx, pod, fleet, devices)
+	case fleet.HasBatchRollout():
+		log.Info("Fleet %s has a rollout policy, but pod %s/%s selects devices by label; deploying it to each device",
+			fleetID, pod.Namespace, pod.Name)
+	}
+	var ready []string
+	p.mu.RLock()
+	for _, device := range devices {
+		if !device.IsReady() || p.deviceAccess.Allows(pod.Namespace, device) != nil {
+			continue
+		}
+		if err := p.hostPortConflictLocked(pod, device.ID); err != nil {
+			log.Warn("Not spreading pod %s/%s to device %s: %v", pod.Namespace, pod.Name, device.ID, err)
+			continue
+		}
+		if err := p.deviceAffinityConflictLocked(pod, device.ID); err != nil {
+			log.Warn("Not spreading pod %s/%s to device %s: %v", pod.Namespace, pod.Name, device.ID, err)
+			continue
+		}
+		ready = append(ready, device.ID)
+	}
+	p.mu.RUnlock()
+	if len(ready) == 0 {
+		return nil, fmt.Errorf("no ready devices in fleet %s with device labels %v", fleetID, selectors)
+	}
+
+	quorum, err := spreadQuorum(pod.Annotations[SpreadQuorumAnnotation], len(ready))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Spreading pod %s/%s to %d device(s) in fleet %s (quorum %d)",
+		pod.Namespace, pod.Name, len(ready), fleetID, quorum)
+
+	var deployed []string
+	var errs []error
+	for _, deviceID := range ready {
+		if err := p.podManager.DeployPod(ctx, pod, deviceID); err != nil {
+			log.Warn("Deploying spread pod %s/%s to device %s: %v", pod.Namespace, pod.Name, deviceID, err)
+			errs = append(errs, fmt.Errorf("device %s: %w", deviceID, err))
+			continue
+		}
+		deployed = append(deployed, deviceID)
+	}
+
+	if len(deployed) < quorum {
+		for _, deviceID := range deployed {
+			if err := p.podManager.DeletePod(ctx, pod, deviceID); err != nil {
+				log.Warn("Rolling back spread pod %s/%s on device %s: %v", pod.Namespace, pod.Name, deviceID, err)
+			}
+		}
+		return nil, fmt.Errorf("deployed to %d of %d devices in fleet %s, quorum is %d: %w",
+			len(deployed), len(ready), fleetID, quorum, errors.Join(errs...))
+	}
+
+	mapping := models.NewPodDeviceMapping(pod.Namespace, pod.Name, pod.UID, "")
+	mapping.SpreadDevices = deployed
+	mapping.SpreadQuorum = quorum
+	mapping.Status = &corev1.PodStatus{
+		Phase: corev1.PodPending,
+		Conditions: []corev1.PodCondition{
+			{
+				Type:               corev1.PodScheduled,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.Now(),
+				Reason:             "Scheduled",
+				Message:            fmt.Sprintf("Pod spread to %d device(s) in FlightCtl fleet %s", len(deployed), fleetID),
+			},
+		},
+	}
+	return mapping, nil
+}
+
+// spreadQuorum resolves the spread-quorum annotation against the number of
+// devices the pod is spread to. An empty value requires all devices.
+func spreadQuorum(value string, devices int) (int, error) {
+	if value == "" {
+		return devices, nil
+	}
+
+	quorum := intstr.Parse(value)
+	n, err := intstr.GetScaledValueFromIntOrPercent(&quorum, devices, true)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation %q: %w", SpreadQuorumAnnotation, value, err)
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("invalid %s annotation %q: must require at least one device", SpreadQuorumAnnotation, value)
+	}
+	if n > devices {
+		return 0, fmt.Errorf("%s annotation %q requires %d devices but only %d are ready", SpreadQuorumAnnotation, value, n, devices)
+	}
+	return n, nil
+}
+
+// spreadDeviceStatus is the status of a spread pod's application on one device.
+type spreadDeviceStatus struct {
+	DeviceID string
+	Status   *corev1.PodStatus
+	Err      error
+}
+
+// spreadPodStatus queries the status of a spread pod on each of its devices
+// and aggregates it.
+func (p *Provider) spreadPodStatus(ctx context.Context, mapping *models.PodDeviceMapping) *corev1.PodStatus {
+	pod := podForMapping(mapping)
+	results := make([]spreadDeviceStatus, 0, len(mapping.SpreadDevices))
+	for _, deviceID := range mapping.SpreadDevices {
+		status, err := p.podManager.GetPodStatus(ctx, pod, deviceID)
+		if err != nil && !errors.Is(err, flightctl.ErrNotFound) && !errors.Is(err, flightctl.ErrDeviceOffline) {
+			logger.Warn("Failed to get status for pod %s/%s on device %s: %v", mapping.Namespace, mapping.Name, deviceID, err)
+		}
+		if mapping.RolloutFleet != "" && errors.Is(err, flightctl.ErrNotFound) {
+			err = errAwaitingRollout
+		}
+		results = append(results, spreadDeviceStatus{DeviceID: deviceID, Status: status, Err: err})
+	}
+	status := aggregateSpreadStatus(results, mapping.SpreadQuorum)
+	if mapping.RolloutFleet != "" {
+		p.mu.RLock()
+		status = p.withFleetRolloutLocked(mapping, status)
+		p.mu.RUnlock()
+	}
+	return status
+}
+
+// aggregateSpreadStatus combines the per-device statuses of a spread pod.
+// The pod is Ready once quorum devices are ready, and Failed once so many
+// devices lost or failed the application that the quorum cannot be reached.
+// Devices that are offline, could not be queried or await their fleet's
+// rollout may still recover. The
+// message lists the devices that are not ready.
+func aggregateSpreadStatus(results []spreadDeviceStatus, quorum int) *corev1.PodStatus {
+	var base *corev1.PodStatus
+	var ready, lost, running int
+	var notReady []string
+	for _, r := range results {
+		var state string
+		switch {
+		case errors.Is(r.Err, flightctl.ErrNotFound):
+			lost++
+			state = "application not found"
+		case errors.Is(r.Err, flightctl.ErrDeviceOffline):
+			state = "offline"
+		case errors.Is(r.Err, errAwaitingRollout):
+			state = "awaiting rollout"
+		case r.Err != nil:
+			state = "unknown"
+		case r.Status.Phase == corev1.PodFailed:
+			lost++
+			state = string(corev1.PodFailed)
+		case isPodReady(r.Status):
+			ready++
+			running++
+			if base == nil || !isPodReady(base) {
+				base = r.Status
+			}
+			continue
+		case r.Status.Phase == corev1.PodRunning:
+			running++
+			state = "not ready"
+		default:
+			state = string(r.Status.Phase)
+		}
+		if base == nil && r.Status != nil {
+			base = r.Status
+		}
+		notReady = append(notReady, fmt.Sprintf("%s (%s)", r.DeviceID, state))
+	}
+
+	status := &corev1.PodStatus{}
+	if base != nil {
+		status = base.DeepCopy()
+	}
+	status.Reason = ""
+	status.Message = fmt.Sprintf("%d/%d devices ready (quorum %d)", ready, len(results), quorum)
+	if len(notReady) > 0 {
+		sort.Strings(notReady)
+		status.Message += "; not ready: " + strings.Join(notReady, ", ")
+	}
+
+	switch {
+	case ready >= quorum:
+		status.Phase = corev1.PodRunning
+		return withReadyCondition(status, corev1.ConditionTrue, "", status.Message)
+	case len(results)-lost < quorum:
+		status.Phase = corev1.PodFailed
+		status.Reason = "SpreadQuorumLost"
+	case running > 0:
+		status.Phase = corev1.PodRunning
+	default:
+		status.Phase = corev1.PodPending
+	}
+	reason := status.Reason
+	if reason == "" {
+		reason = "SpreadQuorumNotMet"
+	}
+	return withReadyCondition(status, corev1.ConditionFalse, reason, status.Message)
+}
+
+// isPodReady reports whether the status has a true Ready condition.
+func isPodReady(status *corev1.PodStatus) bool {
+	for _, cond := range status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// withReadyCondition sets the Ready condition of status, keeping its
+// transition time if the condition status is unchanged.
+func withReadyCondition(status *corev1.PodStatus, value corev1.ConditionStatus, reason, message string) *corev1.PodStatus {
+	return withPodCondition(status, corev1.PodReady, value, reason, message)
+}
+
+// withPodCondition sets a condition of status, keeping its transition time
+// if the condition status is unchanged.
+func withPodCondition(status *corev1.PodStatus, condType corev1.PodConditionType, value corev1.ConditionStatus, reason, message string) *corev1.PodStatus {
+	ready := corev1.PodCondition{
+		Type:               condType,
+		Status:             value,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+	for i := range status.Conditions {
+		if status.Conditions[i].Type != condType {
+			continue
+		}
+		if status.Conditions[i].Status == value {
+			ready.LastTransitionTime = status.Conditions[i].LastTransitionTime
+		}
+		status.Conditions[i] = ready
+		return status
+	}
+	status.Conditions = append(status.Conditions, ready)
+	return status
+}
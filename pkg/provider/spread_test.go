@@ -0,0 +1,176 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func spreadPod(quorum string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "agent",
+			Namespace:   "default",
+			Annotations: map[string]string{SpreadAnnotation: SpreadAllDevicesInFleet},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "agent", Image: "agent:1.0"}}},
+	}
+	if quorum != "" {
+		pod.Annotations[SpreadQuorumAnnotation] = quorum
+	}
+	return pod
+}
+
+func TestSpreadPodLifecycle(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	for _, id := range []string{"d1", "d2", "d3"} {
+		server.AddDevice(id, "edge", nil)
+	}
+	if err := server.SetDeviceSummary("d3", "Offline"); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProvider(t, server, Config{NodeName: "vk-test", DefaultFleet: "edge"})
+
+	ctx := context.Background()
+	pod := spreadPod("")
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	for id, want := range map[string]int{"d1": 1, "d2": 1, "d3": 0} {
+		if apps := fetchDevice(t, server, id).Spec.Applications; len(apps) != want {
+			t.Errorf("applications on %s = %d, want %d", id, len(apps), want)
+		}
+	}
+
+	p.reconcilePodStatus(ctx)
+	status, err := p.GetPodStatus(ctx, "default", "agent")
+	if err != nil {
+		t.Fatalf("GetPodStatus: %v", err)
+	}
+	if status.Phase != corev1.PodRunning || !isPodReady(status) {
+		t.Errorf("status = %s ready=%v, want Running and Ready (%s)", status.Phase, isPodReady(status), status.Message)
+	}
+
+	// Losing one of the two devices loses the default (all devices) quorum
+	if err := server.SetApplicationStatus("d2", flightctl.FlightctlApplicationStatus{Name: "default-agent", Status: "Error"}); err != nil {
+		t.Fatal(err)
+	}
+	p.reconcilePodStatus(ctx)
+	status, _ = p.GetPodStatus(ctx, "default", "agent")
+	if status.Phase != corev1.PodFailed || status.Reason != "SpreadQuorumLost" {
+		t.Errorf("status = %s/%s, want Failed/SpreadQuorumLost (%s)", status.Phase, status.Reason, status.Message)
+	}
+
+	if err := p.DeletePod(ctx, pod); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+	for _, id := range []string{"d1", "d2"} {
+		if apps := fetchDevice(t, server, id).Spec.Applications; len(apps) != 0 {
+			t.Errorf("applications on %s after delete = %+v, want none", id, apps)
+		}
+	}
+}
+
+func TestSpreadPodRollsBackBelowQuorum(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("d1", "edge", nil)
+	server.AddDevice("d2", "edge", nil)
+	server.InjectFailure(fake.Failure{Method: http.MethodPut, PathPrefix: "/api/v1/devices/d2", StatusCode: http.StatusConflict})
+
+	p := newTestProvider(t, server, Config{NodeName: "vk-test"})
+
+	pod := spreadPod("")
+	pod.Annotations[fleetIDAnnotation] = "edge"
+	if err := p.CreatePod(context.Background(), pod); err == nil {
+		t.Fatal("expected an error when the quorum cannot be deployed")
+	}
+	if apps := fetchDevice(t, server, "d1").Spec.Applications; len(apps) != 0 {
+		t.Errorf("applications on d1 = %+v, want the deployment rolled back", apps)
+	}
+	if _, err := p.GetPod(context.Background(), "default", "agent"); err == nil {
+		t.Error("pod tracked after a failed spread")
+	}
+}
+
+func TestSpreadQuorum(t *testing.T) {
+	tests := []struct {
+		value   string
+		devices int
+		want    int
+		wantErr bool
+	}{
+		{value: "", devices: 4, want: 4},
+		{value: "2", devices: 4, want: 2},
+		{value: "75%", devices: 4, want: 3},
+		{value: "50%", devices: 3, want: 2},
+		{value: "5", devices: 4, wantErr: true},
+		{value: "0", devices: 4, wantErr: true},
+		{value: "many", devices: 4, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := spreadQuorum(tt.value, tt.devices)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("spreadQuorum(%q, %d) = %d, %v; want %d, error %v", tt.value, tt.devices, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestAggregateSpreadStatus(t *testing.T) {
+	ready := func(id string) spreadDeviceStatus {
+		return spreadDeviceStatus{DeviceID: id, Status: &corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		}}
+	}
+	pending := func(id string) spreadDeviceStatus {
+		return spreadDeviceStatus{DeviceID: id, Status: &corev1.PodStatus{Phase: corev1.PodPending}}
+	}
+	failed := func(id string) spreadDeviceStatus {
+		return spreadDeviceStatus{DeviceID: id, Status: &corev1.PodStatus{Phase: corev1.PodFailed}}
+	}
+	offline := func(id string) spreadDeviceStatus {
+		return spreadDeviceStatus{DeviceID: id, Err: fmt.Errorf("getting device %s: %w", id, flightctl.ErrDeviceOffline)}
+	}
+
+	tests := []struct {
+		name      string
+		results   []spreadDeviceStatus
+		quorum    int
+		phase     corev1.PodPhase
+		ready     bool
+		reason    string
+		mentioned string
+	}{
+		{name: "all ready", results: []spreadDeviceStatus{ready("d1"), ready("d2")}, quorum: 2, phase: corev1.PodRunning, ready: true},
+		{name: "quorum ready", results: []spreadDeviceStatus{ready("d1"), ready("d2"), offline("d3")}, quorum: 2, phase: corev1.PodRunning, ready: true, mentioned: "d3 (offline)"},
+		{name: "waiting", results: []spreadDeviceStatus{ready("d1"), pending("d2")}, quorum: 2, phase: corev1.PodRunning, mentioned: "d2 (Pending)"},
+		{name: "all pending", results: []spreadDeviceStatus{pending("d1"), pending("d2")}, quorum: 1, phase: corev1.PodPending},
+		{name: "offline may recover", results: []spreadDeviceStatus{ready("d1"), offline("d2")}, quorum: 2, phase: corev1.PodRunning},
+		{name: "quorum lost", results: []spreadDeviceStatus{ready("d1"), failed("d2"), failed("d3")}, quorum: 2, phase: corev1.PodFailed, reason: "SpreadQuorumLost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := aggregateSpreadStatus(tt.results, tt.quorum)
+			if status.Phase != tt.phase || isPodReady(status) != tt.ready || status.Reason != tt.reason {
+				t.Errorf("status = %s ready=%v reason=%q, want %s ready=%v reason=%q (%s)",
+					status.Phase, isPodReady(status), status.Reason, tt.phase, tt.ready, tt.reason, status.Message)
+			}
+			if !strings.Contains(status.Message, tt.mentioned) {
+				t.Errorf("message %q does not mention %q", status.Message, tt.mentioned)
+			}
+		})
+	}
+}
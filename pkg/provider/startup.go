@@ -0,0 +1,268 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// Before the pod controller starts, the provider compares the pods of its
+// node in the API server, their PodDeviceMapping records and the
+// applications in the device specs, so a restart does not leave the devices
+// drifting from the API server:
+//
+//   - A pod whose application is on a device is adopted: it is tracked on
+//     that device, preferring the one its record names, and the pod
+//     controller's update of the pod rewrites the application only if its
+//     content changed.
+//   - A pod without an application is deployed when the pod controller
+//     creates it, to the device its record names if it has one.
+//   - An application whose pod no longer exists, was recreated with another
+//     UID, or that duplicates the adopted application of its pod on another
+//     device is orphaned, and removed unless orphaned applications are kept.
+//
+// Spread pods, whose applications run on several devices, and pods that
+// completed are left to the pod controller.
+
+// StartupReconciliation is the outcome of ReconcileStartup.
+type StartupReconciliation struct {
+	Adopted  []string // Pods tracked on the device already running them
+	Missing  []string // Pods the pod controller deploys
+	Orphaned []string // Orphaned applications, as device/application
+}
+
+// podLookup returns a pod from the API server, or nil if it does not exist.
+type podLookup func(ctx context.Context, namespace, name string) (*corev1.Pod, error)
+
+// ReconcileStartup reconciles the applications on the node's devices with
+// the pods of the node in the API server. It must run before the pod
+// controller starts, and does nothing without a Kubernetes client.
+func (p *Provider) ReconcileStartup(ctx context.Context) (StartupReconciliation, error) {
+	if p.kubeClient == nil {
+		return StartupReconciliation{}, nil
+	}
+	list, err := p.kubeClient.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", p.nodeName).String(),
+	})
+	if err != nil {
+		return StartupReconciliation{}, fmt.Errorf("listing pods of node %s: %w", p.nodeName, err)
+	}
+	pods := make([]*corev1.Pod, 0, len(list.Items))
+	for i := range list.Items {
+		pods = append(pods, &list.Items[i])
+	}
+	return p.reconcileStartup(ctx, pods, func(ctx context.Context, namespace, name string) (*corev1.Pod, error) {
+		pod, err := p.kubeClient.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return pod, err
+	})
+}
+
+// deviceApp is an application found on a device.
+type deviceApp struct {
+	deviceID string
+	app      flightctl.FlightctlApplication
+}
+
+// reconcileStartup reconciles the devices with the given pods of the node,
+// looking up the pods of other applications with lookup.
+func (p *Provider) reconcileStartup(ctx context.Context, pods []*corev1.Pod, lookup podLookup) (StartupReconciliation, error) {
+	log := logger.FromContext(ctx)
+	var result StartupReconciliation
+
+	nodePods := make(map[string]*corev1.Pod)
+	recorded := make(map[string]string) // podKey -> recorded device
+	for _, pod := range pods {
+		if isSpreadPod(pod) || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		podKey := pod.Namespace + "/" + pod.Name
+		nodePods[podKey] = pod
+		if deviceID := p.recordedDevice(ctx, pod); deviceID != "" {
+			recorded[podKey] = deviceID
+		}
+	}
+
+	deviceIDs, err := p.startupDevices(ctx, nodePods, recorded)
+	if err != nil {
+		return result, err
+	}
+	apps := make(map[string][]deviceApp) // podKey -> applications
+	for _, deviceID := range deviceIDs {
+		device, err := p.flightctl.GetDevice(ctx, deviceID)
+		if errors.Is(err, flightctl.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Warn("Reading device %s for the startup reconciliation failed, leaving its applications: %v", deviceID, err)
+			continue
+		}
+		for _, app := range device.Spec.Applications {
+			if podKey, ok := app.PodKey(); ok {
+				apps[podKey] = append(apps[podKey], deviceApp{deviceID: deviceID, app: app})
+			}
+		}
+	}
+
+	podKeys := make([]string, 0, len(apps))
+	for podKey := range apps {
+		podKeys = append(podKeys, podKey)
+	}
+	sort.Strings(podKeys)
+	var adopted []string
+	for _, podKey := range podKeys {
+		p.mu.RLock()
+		_, tracked := p.podMappings[podKey]
+		p.mu.RUnlock()
+		if tracked {
+			continue
+		}
+
+		pod := nodePods[podKey]
+		kept := -1
+		if pod != nil {
+			kept = adoptableApp(pod, apps[podKey], recorded[podKey])
+		}
+		if kept >= 0 {
+			found := apps[podKey][kept]
+			p.adoptPod(pod, found)
+			result.Adopted = append(result.Adopted, podKey)
+			adopted = append(adopted, found.deviceID)
+		}
+		for i, found := range apps[podKey] {
+			if i == kept || (pod == nil && !p.appOrphaned(ctx, found, lookup)) {
+				continue
+			}
+			result.Orphaned = append(result.Orphaned, found.deviceID+"/"+found.app.Name)
+			p.removeOrphanedApp(ctx, podKey, found)
+		}
+	}
+	for podKey := range nodePods {
+		if !slices.Contains(result.Adopted, podKey) {
+			result.Missing = append(result.Missing, podKey)
+		}
+	}
+	sort.Strings(result.Missing)
+
+	p.queueReconcile(adopted...)
+	p.syncMappingRecords(ctx)
+	log.Info("Startup reconciliation of %d device(s): adopted %d pod(s), %d pod(s) to deploy, %d orphaned application(s)",
+		len(deviceIDs), len(result.Adopted), len(result.Missing), len(result.Orphaned))
+	return result, nil
+}
+
+// startupDevices returns the devices the startup reconciliation reads: those
+// of the node, and those the pods are pinned to or recorded on.
+func (p *Provider) startupDevices(ctx context.Context, pods map[string]*corev1.Pod, recorded map[string]string) ([]string, error) {
+	var deviceIDs []string
+	if p.deviceID != "" {
+		deviceIDs = append(deviceIDs, p.deviceID)
+	} else {
+		fleetID := p.fleetID
+		if fleetID == "" {
+			fleetID = p.defaultFleet
+		}
+		devices, err := p.flightctl.ListDevices(ctx, fleetID, nil)
+		if err != nil {
+			return nil, fmt.Errorf("listing devices for the startup reconciliation: %w", err)
+		}
+		for _, device := range devices {
+			deviceIDs = append(deviceIDs, device.ID)
+		}
+	}
+	for podKey, pod := range pods {
+		if deviceID := pod.Annotations[deviceIDAnnotation]; deviceID != "" {
+			deviceIDs = append(deviceIDs, deviceID)
+		}
+		if deviceID := recorded[podKey]; deviceID != "" {
+			deviceIDs = append(deviceIDs, deviceID)
+		}
+	}
+	slices.Sort(deviceIDs)
+	return slices.Compact(deviceIDs), nil
+}
+
+// adoptableApp returns the index of the application a pod is adopted with,
+// preferring the one on its recorded device, or -1 if none runs the pod.
+func adoptableApp(pod *corev1.Pod, apps []deviceApp, recorded string) int {
+	adoptable := -1
+	for i, found := range apps {
+		if uid := found.app.PodUID(); uid != "" && uid != pod.UID {
+			continue
+		}
+		if adoptable < 0 || found.deviceID == recorded {
+			adoptable = i
+		}
+	}
+	return adoptable
+}
+
+// appOrphaned reports whether the pod of an application not on the node's
+// pods is gone: it no longer exists, or was recreated with another UID.
+// Applications whose pod cannot be looked up are kept.
+func (p *Provider) appOrphaned(ctx context.Context, found deviceApp, lookup podLookup) bool {
+	podKey, _ := found.app.PodKey()
+	namespace, name := found.app.EnvVars[flightctl.PodNamespaceEnvVar], found.app.EnvVars[flightctl.PodNameEnvVar]
+	pod, err := lookup(ctx, namespace, name)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Looking up pod %s of application %s on device %s failed, keeping it: %v",
+			podKey, found.app.Name, found.deviceID, err)
+		return false
+	}
+	if pod == nil {
+		return true
+	}
+	uid := found.app.PodUID()
+	return uid != "" && uid != pod.UID
+}
+
+// adoptPod tracks a pod on the device already running its application.
+func (p *Provider) adoptPod(pod *corev1.Pod, found deviceApp) {
+	podKey := pod.Namespace + "/" + pod.Name
+	mapping := models.NewPodDeviceMapping(pod.Namespace, pod.Name, pod.UID, found.deviceID)
+	mapping.Requests = models.PodRequests(pod)
+	mapping.Pod = pod.DeepCopy()
+	// The pod keeps the status last reported for it until the device is read
+	if pod.Status.Phase != "" {
+		mapping.Status = pod.Status.DeepCopy()
+	} else {
+		mapping.Status = scheduledPodStatus(found.deviceID)
+	}
+	if r := p.mappingRecords; r != nil {
+		r.mu.Lock()
+		r.apps[podKey] = deployedApp{uid: pod.UID, name: found.app.Name, contentHash: found.app.ContentHash()}
+		r.mu.Unlock()
+	}
+	p.mu.Lock()
+	p.podMappings[podKey] = mapping
+	p.mu.Unlock()
+	logger.Info("Adopted pod %s running as application %s on device %s", podKey, found.app.Name, found.deviceID)
+}
+
+// removeOrphanedApp removes an orphaned application from its device, unless
+// orphaned applications are kept.
+func (p *Provider) removeOrphanedApp(ctx context.Context, podKey string, found deviceApp) {
+	log := logger.FromContext(ctx).With("device", found.deviceID)
+	if p.keepOrphanedApps {
+		log.Warn("Application %s of pod %s on device %s is orphaned, keeping it", found.app.Name, podKey, found.deviceID)
+		return
+	}
+	if err := p.podManager.RemoveApplication(ctx, found.deviceID, found.app.Name, found.app.PodUID()); err != nil {
+		log.Error("Removing orphaned application %s of pod %s from device %s failed: %v", found.app.Name, podKey, found.deviceID, err)
+		return
+	}
+	log.Info("Removed orphaned application %s of pod %s from device %s", found.app.Name, podKey, found.deviceID)
+}
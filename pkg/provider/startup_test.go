@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+// appPods returns the pod keys of the managed applications on a device.
+func appPods(t *testing.T, server *fake.Server, deviceID string) []string {
+	t.Helper()
+	var podKeys []string
+	for _, app := range fetchDevice(t, server, deviceID).Spec.Applications {
+		if podKey, ok := app.PodKey(); ok {
+			podKeys = append(podKeys, podKey)
+		}
+	}
+	slices.Sort(podKeys)
+	return podKeys
+}
+
+func TestStartupReconciliation(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("d1", "edge", nil)
+	server.AddDevice("d2", "edge", nil)
+	ctx := context.Background()
+
+	// Before the restart: web runs on d1, gone (deleted while the provider
+	// was down) and recreated (recreated with another UID) on d2, and web
+	// also ran on d2 before drifting
+	before := newTestProvider(t, server, Config{NodeName: "fleet-edge", FleetID: "edge"})
+	for name, deviceID := range map[string]string{"web": "d1", "gone": "d2", "recreated": "d2"} {
+		pod := cpuPod(name, "100m")
+		pod.Annotations = map[string]string{deviceIDAnnotation: deviceID}
+		if err := before.CreatePod(ctx, pod); err != nil {
+			t.Fatalf("CreatePod %s: %v", name, err)
+		}
+	}
+	if err := before.podManager.DeployPod(ctx, cpuPod("web", "100m"), "d2"); err != nil {
+		t.Fatalf("DeployPod: %v", err)
+	}
+
+	recreated := cpuPod("recreated", "100m")
+	recreated.UID = types.UID("uid-recreated-2")
+	pending := cpuPod("pending", "100m")
+	pods := []*corev1.Pod{cpuPod("web", "100m"), recreated, pending}
+	lookup := func(_ context.Context, namespace, name string) (*corev1.Pod, error) {
+		for _, pod := range pods {
+			if pod.Namespace == namespace && pod.Name == name {
+				return pod, nil
+			}
+		}
+		return nil, nil
+	}
+
+	p := newTestProvider(t, server, Config{NodeName: "fleet-edge", FleetID: "edge"})
+	result, err := p.reconcileStartup(ctx, pods, lookup)
+	if err != nil {
+		t.Fatalf("reconcileStartup: %v", err)
+	}
+	if !slices.Equal(result.Adopted, []string{"default/web"}) {
+		t.Errorf("adopted = %v, want default/web", result.Adopted)
+	}
+	if !slices.Equal(result.Missing, []string{"default/pending", "default/recreated"}) {
+		t.Errorf("missing = %v, want default/pending and default/recreated", result.Missing)
+	}
+	if len(result.Orphaned) != 3 {
+		t.Errorf("orphaned = %v, want gone, the old recreated and the duplicate web", result.Orphaned)
+	}
+	if device := p.placedDevice(t, "default/web"); device != "d1" {
+		t.Errorf("web adopted on %s, want d1", device)
+	}
+	if apps := appPods(t, server, "d1"); !slices.Equal(apps, []string{"default/web"}) {
+		t.Errorf("applications on d1 = %v, want web", apps)
+	}
+	if apps := appPods(t, server, "d2"); len(apps) != 0 {
+		t.Errorf("applications on d2 = %v, want the orphans removed", apps)
+	}
+
+	// The pod controller finds the adopted pod and leaves its application
+	if pod, err := p.GetPod(ctx, "default", "web"); err != nil || pod == nil {
+		t.Errorf("GetPod of the adopted pod = %v, %v", pod, err)
+	}
+}
+
+func TestStartupReconciliationKeepsOrphans(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("d1", "edge", nil)
+	ctx := context.Background()
+
+	before := newTestProvider(t, server, Config{NodeName: "fleet-edge", FleetID: "edge"})
+	if err := before.CreatePod(ctx, cpuPod("gone", "100m")); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+
+	p := newTestProvider(t, server, Config{NodeName: "fleet-edge", FleetID: "edge"})
+	p.keepOrphanedApps = true
+	notFound := func(context.Context, string, string) (*corev1.Pod, error) { return nil, nil }
+	result, err := p.reconcileStartup(ctx, nil, notFound)
+	if err != nil {
+		t.Fatalf("reconcileStartup: %v", err)
+	}
+	if len(result.Orphaned) != 1 {
+		t.Errorf("orphaned = %v, want gone", result.Orphaned)
+	}
+	if apps := appPods(t, server, "d1"); !slices.Equal(apps, []string{"default/gone"}) {
+		t.Errorf("applications on d1 = %v, want the orphan kept", apps)
+	}
+}
@@ -0,0 +1,295 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api/statsv1alpha1"
+	"go.opentelemetry.io/otel/attribute"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/tracing"
+)
+
+// Resource usage is reported by the devices themselves in their custom
+// system info (see flightctl.DeviceUsage); devices that report none, or are
+// offline, are left out of the node's usage.
+
+// usageTotal sums the usage of a node, pod or container over devices.
+type usageTotal struct {
+	time   time.Time
+	cpu    *resource.Quantity
+	memory *resource.Quantity
+
+	// cpuSeconds is the cumulative CPU time, valid when cpu is set
+	cpuSeconds float64
+}
+
+// add adds the usage reported at t.
+func (u *usageTotal) add(t time.Time, cpu, memory *resource.Quantity) {
+	if t.After(u.time) {
+		u.time = t
+	}
+	u.cpu = addQuantity(u.cpu, cpu)
+	u.memory = addQuantity(u.memory, memory)
+}
+
+func addQuantity(total, q *resource.Quantity) *resource.Quantity {
+	if q == nil {
+		return total
+	}
+	if total == nil {
+		sum := q.DeepCopy()
+		return &sum
+	}
+	total.Add(*q)
+	return total
+}
+
+// merge adds the usage and cumulative CPU time of o.
+func (u *usageTotal) merge(o *usageTotal) {
+	u.add(o.time, o.cpu, o.memory)
+	u.cpuSeconds += o.cpuSeconds
+}
+
+// cpuStats returns the CPU usage as kubelet stats, or nil if unknown.
+func (u *usageTotal) cpuStats() *statsv1alpha1.CPUStats {
+	if u.cpu == nil {
+		return nil
+	}
+	nanoCores := uint64(u.cpu.ScaledValue(resource.Nano))
+	coreNanoSeconds := uint64(u.cpuSeconds * 1e9)
+	return &statsv1alpha1.CPUStats{Time: metav1.NewTime(u.time), UsageNanoCores: &nanoCores, UsageCoreNanoSeconds: &coreNanoSeconds}
+}
+
+// memoryStats returns the memory usage as kubelet stats, or nil if unknown.
+func (u *usageTotal) memoryStats() *statsv1alpha1.MemoryStats {
+	if u.memory == nil {
+		return nil
+	}
+	bytes := uint64(u.memory.Value())
+	return &statsv1alpha1.MemoryStats{Time: metav1.NewTime(u.time), UsageBytes: &bytes, WorkingSetBytes: &bytes}
+}
+
+// podUsage is the usage of a pod's containers, summed over its devices.
+type podUsage struct {
+	pod        *corev1.Pod
+	startTime  time.Time
+	containers map[string]*usageTotal // container name -> usage
+}
+
+// GetStatsSummary gets the stats for the node, including running pods, from
+// the resource usage its devices report.
+func (p *Provider) GetStatsSummary(ctx context.Context) (*statsv1alpha1.Summary, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Provider.GetStatsSummary")
+	defer span.End()
+
+	node, pods, _ := p.collectUsage(ctx)
+	span.SetAttributes(attribute.Int("pods", len(pods)))
+
+	summary := &statsv1alpha1.Summary{
+		Node: statsv1alpha1.NodeStats{
+			NodeName: p.nodeName,
+			CPU:      node.cpuStats(),
+			Memory:   node.memoryStats(),
+		},
+		Pods: []statsv1alpha1.PodStats{},
+	}
+	for _, usage := range pods {
+		podStats := statsv1alpha1.PodStats{
+			PodRef: statsv1alpha1.PodReference{
+				Name:      usage.pod.Name,
+				Namespace: usage.pod.Namespace,
+				UID:       string(usage.pod.UID),
+			},
+			StartTime: metav1.NewTime(usage.startTime),
+		}
+		var total usageTotal
+		for _, container := range usage.pod.Spec.Containers {
+			containerUsage, ok := usage.containers[container.Name]
+			if !ok {
+				continue
+			}
+			total.merge(containerUsage)
+			podStats.Containers = append(podStats.Containers, statsv1alpha1.ContainerStats{
+				Name:      container.Name,
+				StartTime: metav1.NewTime(usage.startTime),
+				CPU:       containerUsage.cpuStats(),
+				Memory:    containerUsage.memoryStats(),
+			})
+		}
+		podStats.CPU = total.cpuStats()
+		podStats.Memory = total.memoryStats()
+		summary.Pods = append(summary.Pods, podStats)
+	}
+	return summary, nil
+}
+
+// collectUsage reads the usage reported by the node's devices: the pinned
+// device or the fleet's devices, and the devices running tracked pods. It
+// returns the node's total usage, the usage of the pods that report any, and
+// the number of devices whose usage could not be read.
+func (p *Provider) collectUsage(ctx context.Context) (usageTotal, []*podUsage, int) {
+	log := logger.FromContext(ctx)
+
+	p.mu.RLock()
+	deviceIDs := make(map[string]bool)
+	if p.deviceID != "" {
+		deviceIDs[p.deviceID] = true
+	}
+	for _, device := range p.fleetDevices {
+		deviceIDs[device.ID] = true
+	}
+	mappings := make([]*models.PodDeviceMapping, 0, len(p.podMappings))
+	for _, mapping := range p.podMappings {
+		if mapping.InFlight || mapping.Pod == nil {
+			continue
+		}
+		mappings = append(mappings, mapping)
+		for _, deviceID := range mapping.Devices() {
+			deviceIDs[deviceID] = true
+		}
+	}
+	p.mu.RUnlock()
+
+	var node usageTotal
+	failed := 0
+	usages := make(map[string]*flightctl.DeviceUsage, len(deviceIDs))
+	for _, deviceID := range sortedKeys(deviceIDs) {
+		usage, err := p.deviceUsage(ctx, deviceID)
+		if err != nil {
+			log.Warn("Reading resource usage of device %s: %v", deviceID, err)
+			failed++
+			continue
+		}
+		if usage == nil {
+			continue
+		}
+		usages[deviceID] = usage
+		node.add(usage.Time, usage.CPU, usage.Memory)
+	}
+
+	var pods []*podUsage
+	for _, mapping := range mappings {
+		pod := &podUsage{pod: mapping.Pod, startTime: mapping.DeployedAt, containers: make(map[string]*usageTotal)}
+		for _, deviceID := range mapping.Devices() {
+			usage, ok := usages[deviceID]
+			if !ok {
+				continue
+			}
+			for name, container := range usage.PodUsage(mapping.Pod, p.appNaming.ApplicationName(mapping.Pod)) {
+				if pod.containers[name] == nil {
+					pod.containers[name] = &usageTotal{}
+				}
+				pod.containers[name].add(usage.Time, &container.CPU, &container.Memory)
+			}
+		}
+		if len(pod.containers) > 0 {
+			pods = append(pods, pod)
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		a, b := pods[i].pod, pods[j].pod
+		return a.Namespace < b.Namespace || (a.Namespace == b.Namespace && a.Name < b.Name)
+	})
+
+	// Devices report CPU usage as a rate; the cumulative CPU time kubelet
+	// stats and metrics carry is integrated from it across collections
+	observed := make(map[string]bool)
+	observe := func(key string, usage *usageTotal) {
+		if usage.cpu != nil {
+			observed[key] = true
+			usage.cpuSeconds = p.cpuTime.observe(key, usage.time, usage.cpu)
+		}
+	}
+	observe("", &node)
+	for _, pod := range pods {
+		for name, container := range pod.containers {
+			observe(pod.pod.Namespace+"/"+pod.pod.Name+"/"+string(pod.pod.UID)+"/"+name, container)
+		}
+	}
+	p.cpuTime.retain(observed)
+
+	return node, pods, failed
+}
+
+// deviceUsage returns the usage a device reports, or nil if it reports none
+// or is offline, so its last report is stale.
+func (p *Provider) deviceUsage(ctx context.Context, deviceID string) (*flightctl.DeviceUsage, error) {
+	device, err := p.flightctl.GetDevice(ctx, deviceID)
+	if errors.Is(err, flightctl.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if device.ToModel().ConnectionState == models.Disconnected {
+		return nil, nil
+	}
+	usage, ok, err := device.Usage()
+	if !ok || err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// cpuTimeCounters integrates reported CPU usage into cumulative CPU time,
+// keyed by node ("") or pod container.
+type cpuTimeCounters struct {
+	mu       sync.Mutex
+	counters map[string]*cpuTimeCounter
+}
+
+type cpuTimeCounter struct {
+	time    time.Time
+	seconds float64
+}
+
+func newCPUTimeCounters() *cpuTimeCounters {
+	return &cpuTimeCounters{counters: make(map[string]*cpuTimeCounter)}
+}
+
+// observe adds usage reported at t, taken as the usage since the previous
+// report, and returns the cumulative CPU time in core-seconds.
+func (c *cpuTimeCounters) observe(key string, t time.Time, usage *resource.Quantity) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counter, ok := c.counters[key]
+	if !ok {
+		c.counters[key] = &cpuTimeCounter{time: t}
+		return 0
+	}
+	if t.After(counter.time) {
+		counter.seconds += usage.AsApproximateFloat64() * t.Sub(counter.time).Seconds()
+		counter.time = t
+	}
+	return counter.seconds
+}
+
+// retain drops the counters of containers that are gone.
+func (c *cpuTimeCounters) retain(keys map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.counters {
+		if !keys[key] {
+			delete(c.counters, key)
+		}
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,167 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func TestStatsSummaryReportsDeviceUsage(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	ctx := context.Background()
+
+	// Without reported usage the summary only names the node
+	summary, err := p.GetStatsSummary(ctx)
+	if err != nil {
+		t.Fatalf("GetStatsSummary: %v", err)
+	}
+	if summary.Node.NodeName != "device-1" || summary.Node.CPU != nil || len(summary.Pods) != 0 {
+		t.Errorf("unexpected summary without usage: %+v", summary)
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid-1"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}, {Name: "Sidecar", Image: "busybox:1.36"}},
+		},
+	}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if err := server.SetCustomInfo("device-1", map[string]string{
+		flightctl.CPUUsageInfoKey:    "1500m",
+		flightctl.MemoryUsageInfoKey: "1Gi",
+		flightctl.ContainerUsageInfoKey: `[{"app":"default-web","container":"nginx","cpu":"250m","memory":"64Mi"},` +
+			`{"app":"default-web","container":"sidecar","cpu":"50m","memory":"16Mi"}]`,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err = p.GetStatsSummary(ctx)
+	if err != nil {
+		t.Fatalf("GetStatsSummary: %v", err)
+	}
+	if cpu := summary.Node.CPU; cpu == nil || *cpu.UsageNanoCores != 1500000000 {
+		t.Errorf("node CPU = %+v, want 1.5 cores", cpu)
+	}
+	if mem := summary.Node.Memory; mem == nil || *mem.WorkingSetBytes != 1<<30 {
+		t.Errorf("node memory = %+v, want 1Gi", mem)
+	}
+	if len(summary.Pods) != 1 {
+		t.Fatalf("expected stats for one pod, got %+v", summary.Pods)
+	}
+	podStats := summary.Pods[0]
+	if podStats.PodRef.Name != "web" || podStats.PodRef.UID != "uid-1" || len(podStats.Containers) != 2 {
+		t.Fatalf("unexpected pod stats %+v", podStats)
+	}
+	if *podStats.CPU.UsageNanoCores != 300000000 || *podStats.Memory.WorkingSetBytes != 80<<20 {
+		t.Errorf("pod usage = %d nanocores, %d bytes; want the sum of its containers",
+			*podStats.CPU.UsageNanoCores, *podStats.Memory.WorkingSetBytes)
+	}
+	if podStats.Containers[1].Name != "Sidecar" {
+		t.Errorf("expected usage keyed by pod container name, got %q", podStats.Containers[1].Name)
+	}
+
+	// An offline device's last report is stale
+	if err := server.SetDeviceSummary("device-1", "Offline"); err != nil {
+		t.Fatal(err)
+	}
+	summary, err = p.GetStatsSummary(ctx)
+	if err != nil {
+		t.Fatalf("GetStatsSummary: %v", err)
+	}
+	if summary.Node.CPU != nil || len(summary.Pods) != 0 {
+		t.Errorf("expected no usage from an offline device, got %+v", summary)
+	}
+}
+
+func TestMetricsResourceReportsDeviceUsage(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	ctx := context.Background()
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}}},
+	}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if err := server.SetCustomInfo("device-1", map[string]string{
+		flightctl.CPUUsageInfoKey:       "2",
+		flightctl.MemoryUsageInfoKey:    "1Gi",
+		flightctl.ContainerUsageInfoKey: `[{"app":"default-web","container":"nginx","cpu":"500m","memory":"64Mi"}]`,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	scrape := func() string {
+		t.Helper()
+		families, err := p.GetMetricsResource(ctx)
+		if err != nil {
+			t.Fatalf("GetMetricsResource: %v", err)
+		}
+		var buf bytes.Buffer
+		enc := expfmt.NewEncoder(&buf, expfmt.FmtText)
+		for _, family := range families {
+			if err := enc.Encode(family); err != nil {
+				t.Fatalf("encoding %s: %v", family.GetName(), err)
+			}
+		}
+		return buf.String()
+	}
+	hasSample := func(text, sample string) bool {
+		for _, line := range strings.Split(text, "\n") {
+			if line == sample || strings.HasPrefix(line, sample+" ") {
+				return true
+			}
+		}
+		return false
+	}
+
+	text := scrape()
+	for _, sample := range []string{
+		`node_memory_working_set_bytes 1.073741824e+09`,
+		`node_cpu_usage_seconds_total 0`,
+		`container_memory_working_set_bytes{container="nginx",namespace="default",pod="web"} 6.7108864e+07`,
+		`pod_cpu_usage_seconds_total{namespace="default",pod="web"} 0`,
+		`scrape_error 0`,
+	} {
+		if !hasSample(text, sample) {
+			t.Errorf("missing sample %s in:\n%s", sample, text)
+		}
+	}
+
+	// CPU time accumulates at the reported rate between device reports
+	device := fetchDevice(t, server, "device-1")
+	later := device.Status.LastSeen.Add(10 * time.Second)
+	device.Status.LastSeen = &later
+	server.PutDevice(*device)
+
+	text = scrape()
+	for _, sample := range []string{
+		`node_cpu_usage_seconds_total 20`,
+		`container_cpu_usage_seconds_total{container="nginx",namespace="default",pod="web"} 5`,
+		`pod_cpu_usage_seconds_total{namespace="default",pod="web"} 5`,
+	} {
+		if !hasSample(text, sample) {
+			t.Errorf("missing sample %s in:\n%s", sample, text)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package provider
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// AnyNamespace is the DeviceAccessPolicy entry for namespaces without a rule
+// of their own.
+const AnyNamespace = "*"
+
+// DeviceAccessRule limits the devices the pods of a namespace may run on to
+// those in one of Fleets, if any are listed, that carry all DeviceSelector
+// labels.
+type DeviceAccessRule struct {
+	Fleets         []string          `json:"fleets,omitempty"`
+	DeviceSelector map[string]string `json:"deviceSelector,omitempty"`
+}
+
+// DeviceAccessPolicy maps namespaces to the devices their pods may run on,
+// keeping the pods of one tenant off the devices of another whatever device
+// or fleet they ask for. Namespaces without a rule use the AnyNamespace
+// rule, and may not run pods on any device if there is none either. A nil
+// policy allows every namespace all devices.
+type DeviceAccessPolicy map[string]DeviceAccessRule
+
+// LoadDeviceAccessPolicy reads a policy from a YAML or JSON file keyed by
+// namespace, e.g.
+//
+//	tenant-a:
+//	  fleets: [factory-a]
+//	"*":
+//	  deviceSelector:
+//	    tenant: shared
+func LoadDeviceAccessPolicy(path string) (DeviceAccessPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading device access policy: %w", err)
+	}
+	var policy DeviceAccessPolicy
+	if err := yaml.UnmarshalStrict(data, &policy); err != nil {
+		return nil, fmt.Errorf("parsing device access policy %s: %w", path, err)
+	}
+	if policy == nil {
+		policy = DeviceAccessPolicy{}
+	}
+	return policy, nil
+}
+
+// Allows returns an error if pods of the namespace may not run on the
+// device.
+func (policy DeviceAccessPolicy) Allows(namespace string, device *models.Device) error {
+	if policy == nil {
+		return nil
+	}
+	rule, ok := policy[namespace]
+	if !ok {
+		if rule, ok = policy[AnyNamespace]; !ok {
+			return fmt.Errorf("namespace %s may not use any device", namespace)
+		}
+	}
+	if len(rule.Fleets) > 0 && !slices.Contains(rule.Fleets, device.FleetID) {
+		return fmt.Errorf("namespace %s may not use device %s: fleet %q is not one of %v", namespace, device.ID, device.FleetID, rule.Fleets)
+	}
+	for key, value := range rule.DeviceSelector {
+		if device.Labels[key] != value {
+			return fmt.Errorf("namespace %s may not use device %s: it lacks label %s=%s", namespace, device.ID, key, value)
+		}
+	}
+	return nil
+}
+
+// allowedDevices returns the devices pods of the namespace may run on.
+func (policy DeviceAccessPolicy) allowedDevices(namespace string, devices []*models.Device) []*models.Device {
+	if policy == nil {
+		return devices
+	}
+	allowed := make([]*models.Device, 0, len(devices))
+	for _, device := range devices {
+		if policy.Allows(namespace, device) == nil {
+			allowed = append(allowed, device)
+		}
+	}
+	return allowed
+}
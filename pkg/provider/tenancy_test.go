@@ -0,0 +1,77 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func TestLoadDeviceAccessPolicy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.yaml")
+	if err := os.WriteFile(path, []byte("tenant-a:\n  fleets: [factory-a]\n\"*\":\n  deviceSelector:\n    tenant: shared\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadDeviceAccessPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadDeviceAccessPolicy: %v", err)
+	}
+	if fleets := policy["tenant-a"].Fleets; len(fleets) != 1 || fleets[0] != "factory-a" {
+		t.Errorf("tenant-a fleets = %v, want [factory-a]", fleets)
+	}
+	if selector := policy[AnyNamespace].DeviceSelector; selector["tenant"] != "shared" {
+		t.Errorf("* device selector = %v, want tenant=shared", selector)
+	}
+
+	if err := os.WriteFile(path, []byte("tenant-a:\n  fleet: factory-a\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDeviceAccessPolicy(path); err == nil {
+		t.Error("expected an error for an unknown rule field")
+	}
+}
+
+func TestDeviceAccessPolicyKeepsTenantsApart(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("factory-a", nil)
+	server.AddFleet("factory-b", nil)
+	server.AddDevice("a1", "factory-a", nil)
+	server.AddDevice("b1", "factory-b", map[string]string{"tenant": "b"})
+	p := newTestProvider(t, server, Config{NodeName: "vk", DeviceAccessPolicy: DeviceAccessPolicy{
+		"tenant-a": {Fleets: []string{"factory-a"}},
+		"tenant-b": {DeviceSelector: map[string]string{"tenant": "b"}},
+	}})
+	ctx := context.Background()
+
+	create := func(name, namespace, key, value string) error {
+		pod := cpuPod(name, "100m")
+		pod.Namespace = namespace
+		pod.Annotations = map[string]string{key: value}
+		return p.CreatePod(ctx, pod)
+	}
+
+	if err := create("guess", "tenant-a", deviceIDAnnotation, "b1"); err == nil || !strings.Contains(err.Error(), "may not use device b1") {
+		t.Errorf("tenant-a pod on b1 error = %v, want access denied", err)
+	}
+	if err := create("other-fleet", "tenant-a", fleetIDAnnotation, "factory-b"); err == nil {
+		t.Error("expected tenant-a pod targeting factory-b to be rejected")
+	}
+	if err := create("own-fleet", "tenant-a", fleetIDAnnotation, "factory-a"); err != nil {
+		t.Errorf("tenant-a pod in factory-a: %v", err)
+	}
+	if err := create("own-device", "tenant-b", deviceIDAnnotation, "b1"); err != nil {
+		t.Errorf("tenant-b pod on b1: %v", err)
+	}
+	if err := create("unlisted", "default", deviceIDAnnotation, "a1"); err == nil {
+		t.Error("expected a pod of a namespace without a rule to be rejected")
+	}
+
+	if apps := fetchDevice(t, server, "b1").Spec.Applications; len(apps) != 1 {
+		t.Errorf("applications on b1 = %+v, want only tenant-b's pod", apps)
+	}
+}
@@ -0,0 +1,73 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// Removing an application from a device spec only asks the device to stop
+// it; the containers keep running until the agent applies the spec. A
+// deleted pod is therefore kept Terminating, with its mapping, until its
+// devices no longer report the application or its deletion grace period
+// runs out. The wait runs in the background, so DeletePod returns right
+// away; until it ends, a new pod reusing the name is rejected rather than
+// replacing the application that is still stopping.
+
+// defaultTerminationPollInterval is how often the devices of a terminating
+// pod are read.
+const defaultTerminationPollInterval = 2 * time.Second
+
+// deletionGracePeriod returns how long the devices may take to stop a
+// deleted pod. Pods without a deletion grace period, e.g. dangling pods
+// removed by Virtual Kubelet, are not waited for.
+func deletionGracePeriod(pod *corev1.Pod) time.Duration {
+	if pod.DeletionGracePeriodSeconds == nil || *pod.DeletionGracePeriodSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(*pod.DeletionGracePeriodSeconds) * time.Second
+}
+
+// awaitTermination waits until the devices no longer report the pod's
+// application, or until its deadline. It reports whether the application
+// was stopped on all devices.
+func (p *Provider) awaitTermination(ctx context.Context, mapping *models.PodDeviceMapping, pod *corev1.Pod, devices []string) bool {
+	ctx, cancel := context.WithDeadline(ctx, mapping.TerminationDeadline)
+	defer cancel()
+	log := logger.FromContext(ctx).With("pod", mapping.PodKey)
+
+	remaining := slices.Clone(devices)
+	for {
+		remaining = slices.DeleteFunc(remaining, func(deviceID string) bool {
+			reported, err := p.podManager.ApplicationReported(ctx, pod, deviceID)
+			if errors.Is(err, flightctl.ErrNotFound) {
+				// The device was removed, and its applications with it
+				return true
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Warn("Failed to check whether device %s stopped pod %s: %v", deviceID, mapping.PodKey, err)
+				}
+				return false
+			}
+			return !reported
+		})
+		if len(remaining) == 0 {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Warn("Devices %v did not stop pod %s within its grace period", remaining, mapping.PodKey)
+			return false
+		case <-time.After(p.terminationPollInterval):
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+// waitFor polls cond until it holds, failing the test after 10s.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func terminatingPod(uid string, grace int64) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "web", Namespace: "default", UID: types.UID("uid-" + uid),
+			DeletionGracePeriodSeconds: &grace,
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}}},
+	}
+}
+
+func TestDeletePodWaitsForDeviceToStopApplication(t *testing.T) {
+	server := fake.NewServer(fake.WithManualRollout())
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	p.terminationPollInterval = 10 * time.Millisecond
+
+	ctx := context.Background()
+	pod := terminatingPod("1", 30)
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if err := server.CompleteRollout("device-1"); err != nil {
+		t.Fatalf("CompleteRollout: %v", err)
+	}
+
+	// DeletePod returns once the application is removed from the spec, and
+	// the pod stays Terminating while the device still runs it
+	started := time.Now()
+	if err := p.DeletePod(ctx, pod); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("DeletePod took %s, want it to return without waiting for the device", elapsed)
+	}
+	p.mu.RLock()
+	mapping := p.podMappings["default/web"]
+	terminating := mapping != nil && mapping.IsTerminating()
+	p.mu.RUnlock()
+	if !terminating {
+		t.Fatal("pod not tracked as terminating after DeletePod")
+	}
+	if _, err := p.GetPodStatus(ctx, "default", "web"); err != nil {
+		t.Errorf("GetPodStatus of the terminating pod: %v", err)
+	}
+	if apps := fetchDevice(t, server, "device-1").Spec.Applications; len(apps) != 0 {
+		t.Errorf("applications = %+v, want none in the spec", apps)
+	}
+
+	// A new pod with the same name waits for the old application
+	if err := p.CreatePod(ctx, terminatingPod("2", 30)); err == nil {
+		t.Error("CreatePod of a pod reusing the name of a terminating pod succeeded")
+	}
+
+	if err := server.CompleteRollout("device-1"); err != nil {
+		t.Fatalf("CompleteRollout: %v", err)
+	}
+	waitFor(t, "the pod to be untracked once the device stopped it", func() bool {
+		_, err := p.GetPodStatus(ctx, "default", "web")
+		return err != nil
+	})
+	if err := p.CreatePod(ctx, terminatingPod("2", 30)); err != nil {
+		t.Errorf("CreatePod after the old pod terminated: %v", err)
+	}
+}
+
+func TestDeletePodGivesUpAfterGracePeriod(t *testing.T) {
+	server := fake.NewServer(fake.WithManualRollout())
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+	p.terminationPollInterval = 10 * time.Millisecond
+
+	ctx := context.Background()
+	pod := terminatingPod("1", 1)
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if err := server.CompleteRollout("device-1"); err != nil {
+		t.Fatalf("CompleteRollout: %v", err)
+	}
+
+	started := time.Now()
+	if err := p.DeletePod(ctx, pod); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+	waitFor(t, "the pod to be untracked after its grace period", func() bool {
+		_, err := p.GetPodStatus(ctx, "default", "web")
+		return err != nil
+	})
+	if elapsed := time.Since(started); elapsed < time.Second {
+		t.Errorf("pod untracked after %s, want it kept for the 1s grace period", elapsed)
+	}
+}
+
+func TestDeletePodWithoutGracePeriodDoesNotWait(t *testing.T) {
+	server := fake.NewServer(fake.WithManualRollout())
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+
+	ctx := context.Background()
+	pod := terminatingPod("1", 0)
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if err := server.CompleteRollout("device-1"); err != nil {
+		t.Fatalf("CompleteRollout: %v", err)
+	}
+
+	started := time.Now()
+	if err := p.DeletePod(ctx, pod); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("DeletePod took %s with a zero grace period", elapsed)
+	}
+}
+
+func TestDeleteOfRecreatedPodLeavesNewPod(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("device-1", "", nil)
+	p := newTestProvider(t, server, Config{NodeName: "device-1", DeviceID: "device-1"})
+
+	ctx := context.Background()
+	old, recreated := terminatingPod("1", 0), terminatingPod("2", 0)
+	if err := p.CreatePod(ctx, old); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	// The pod is recreated before the deletion of the old one reaches the
+	// provider
+	if err := p.CreatePod(ctx, recreated); err != nil {
+		t.Fatalf("CreatePod of the recreated pod: %v", err)
+	}
+	appUID := func() types.UID {
+		t.Helper()
+		device, _ := server.Device("device-1")
+		for _, app := range device.Spec.Applications {
+			if app.Name == "default-web" {
+				return app.PodUID()
+			}
+		}
+		return ""
+	}
+	if uid := appUID(); uid != recreated.UID {
+		t.Fatalf("application runs pod UID %q, want the recreated %s", uid, recreated.UID)
+	}
+
+	// The recreated pod's status is not reported as the old one's
+	if status, err := p.podManager.GetPodStatus(ctx, old, "device-1"); err != nil || status.Phase != corev1.PodPending {
+		t.Errorf("old pod status = %v, %v; want Pending, not the recreated pod's", status, err)
+	}
+
+	if err := p.DeletePod(ctx, old); err != nil {
+		t.Fatalf("DeletePod of the old pod: %v", err)
+	}
+	if err := p.podManager.DeletePod(ctx, old, "device-1"); err != nil {
+		t.Fatalf("removing the old pod's application: %v", err)
+	}
+	if err := p.UpdatePod(ctx, old); err == nil {
+		t.Error("UpdatePod of the old pod succeeded, want the recreated pod left alone")
+	}
+	p.mu.RLock()
+	mapping := p.podMappings["default/web"]
+	p.mu.RUnlock()
+	if mapping == nil || mapping.PodUID != recreated.UID || mapping.IsTerminating() {
+		t.Errorf("mapping = %+v, want the recreated pod still tracked", mapping)
+	}
+	if uid := appUID(); uid != recreated.UID {
+		t.Errorf("application runs pod UID %q after deleting the old pod, want %s", uid, recreated.UID)
+	}
+}
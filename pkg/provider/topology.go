@@ -0,0 +1,198 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/models"
+)
+
+// DefaultTopologyLabels maps the well-known topology labels to the device
+// labels that set them, when Config.TopologyLabels is nil.
+var DefaultTopologyLabels = map[string]string{
+	corev1.LabelTopologyRegion: "region",
+	corev1.LabelTopologyZone:   "zone",
+}
+
+// validateTopologyLabels checks that the topology keys are valid label keys
+// and the device labels they are read from are set.
+func validateTopologyLabels(topologyLabels map[string]string) error {
+	for key, deviceLabel := range topologyLabels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("topology label %q: %s", key, strings.Join(errs, "; "))
+		}
+		if deviceLabel == "" {
+			return fmt.Errorf("topology label %q: no device label", key)
+		}
+	}
+	return nil
+}
+
+// deviceTopology returns the device's domain for a topology key, and
+// whether it has one. The configured topology labels are read from their
+// device labels, DeviceTopologyKey makes each device a domain of its own, and
+// the flightctl.io/ labels of device nodes from the device they describe.
+func (p *Provider) deviceTopology(device *models.Device, key string) (string, bool) {
+	if deviceLabel, ok := p.topologyLabels[key]; ok {
+		value := device.Labels[deviceLabel]
+		return value, value != ""
+	}
+	switch key {
+	case DeviceTopologyKey, DeviceIDLabel:
+		return device.ID, true
+	case FleetIDLabel:
+		return device.FleetID, device.FleetID != ""
+	}
+	if label, ok := strings.CutPrefix(key, deviceSelectorPrefix); ok {
+		value, ok := device.Labels[label]
+		return value, ok
+	}
+	return "", false
+}
+
+// isDeviceTopologyKey reports whether deviceTopology knows the key.
+func (p *Provider) isDeviceTopologyKey(key string) bool {
+	_, configured := p.topologyLabels[key]
+	return configured || strings.HasPrefix(key, deviceSelectorPrefix)
+}
+
+// applyTopology sets the configured topology labels on a node from its
+// devices, when they all share the value: a fleet spanning several zones
+// belongs to none.
+func (p *Provider) applyTopology(node *corev1.Node, devices []*models.Device) {
+	if len(devices) == 0 {
+		return
+	}
+	for key := range p.topologyLabels {
+		value, ok := p.deviceTopology(devices[0], key)
+		for _, device := range devices[1:] {
+			if other, _ := p.deviceTopology(device, key); other != value {
+				ok = false
+				break
+			}
+		}
+		if ok && len(validation.IsValidLabelValue(value)) == 0 {
+			node.Labels[key] = value
+		}
+	}
+}
+
+// withTopologySpreadLocked returns the devices the pod may be placed on
+// under its topology spread constraints keyed on device topology: those
+// whose domain would not exceed the maximum skew of a DoNotSchedule
+// constraint, narrowed to the least loaded domains of ScheduleAnyway ones.
+// Like in the scheduler, the matching pods in the pod's namespace are
+// counted over the domains of the candidate devices, and devices without the
+// topology key are not candidates of DoNotSchedule constraints. Constraints
+// with other topology keys are left to the scheduler. Caller must hold p.mu.
+func (p *Provider) withTopologySpreadLocked(pod *corev1.Pod, devices []*models.Device) ([]*models.Device, error) {
+	var hard, soft []corev1.TopologySpreadConstraint
+	for _, constraint := range pod.Spec.TopologySpreadConstraints {
+		switch {
+		case !p.isDeviceTopologyKey(constraint.TopologyKey):
+		case constraint.WhenUnsatisfiable == corev1.ScheduleAnyway:
+			soft = append(soft, constraint)
+		default:
+			hard = append(hard, constraint)
+		}
+	}
+	if len(hard)+len(soft) == 0 {
+		return devices, nil
+	}
+	placed := p.devicePodsLocked(fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+
+	for _, constraint := range hard {
+		skews, err := p.topologySkews(pod, constraint, devices, placed)
+		if err != nil {
+			return nil, err
+		}
+		var allowed []*models.Device
+		for _, device := range devices {
+			if skew, ok := skews[device.ID]; ok && skew <= int(constraint.MaxSkew) {
+				allowed = append(allowed, device)
+			}
+		}
+		if len(allowed) == 0 {
+			return nil, fmt.Errorf("topology spread constraint on %s (maxSkew %d) rules out all %d devices",
+				constraint.TopologyKey, constraint.MaxSkew, len(devices))
+		}
+		devices = allowed
+	}
+
+	for _, constraint := range soft {
+		skews, err := p.topologySkews(pod, constraint, devices, placed)
+		if err != nil {
+			return nil, err
+		}
+		var best []*models.Device
+		bestSkew := 0
+		for _, device := range devices {
+			skew, ok := skews[device.ID]
+			switch {
+			case !ok:
+			case len(best) == 0 || skew < bestSkew:
+				best, bestSkew = []*models.Device{device}, skew
+			case skew == bestSkew:
+				best = append(best, device)
+			}
+		}
+		if len(best) > 0 {
+			devices = best
+		}
+	}
+	return devices, nil
+}
+
+// topologySkews returns the skew placing the pod on each device with the
+// constraint's topology key would cause: the matching pods in its domain,
+// counting the pod if it matches, above those in the least loaded domain.
+func (p *Provider) topologySkews(pod *corev1.Pod, constraint corev1.TopologySpreadConstraint,
+	devices []*models.Device, placed map[string][]*corev1.Pod) (map[string]int, error) {
+	selector, err := metav1.LabelSelectorAsSelector(constraint.LabelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("topology spread constraint on %s: %w", constraint.TopologyKey, err)
+	}
+
+	domains := make(map[string]string, len(devices)) // device ID -> domain
+	counts := make(map[string]int)
+	for _, device := range devices {
+		domain, ok := p.deviceTopology(device, constraint.TopologyKey)
+		if !ok {
+			continue
+		}
+		domains[device.ID] = domain
+		if _, seen := counts[domain]; !seen {
+			counts[domain] = 0
+		}
+		for _, other := range placed[device.ID] {
+			if other.Namespace == pod.Namespace && selector.Matches(labels.Set(other.Labels)) {
+				counts[domain]++
+			}
+		}
+	}
+
+	minCount := -1
+	for _, count := range counts {
+		if minCount < 0 || count < minCount {
+			minCount = count
+		}
+	}
+	if constraint.MinDomains != nil && len(counts) < int(*constraint.MinDomains) {
+		minCount = 0
+	}
+	self := 0
+	if selector.Matches(labels.Set(pod.Labels)) {
+		self = 1
+	}
+
+	skews := make(map[string]int, len(domains))
+	for deviceID, domain := range domains {
+		skews[deviceID] = counts[domain] + self - minCount
+	}
+	return skews, nil
+}
@@ -0,0 +1,114 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func zoneSpreadPod(name string, minDomains *int32) *corev1.Pod {
+	pod := affinityPod(name, "web", nil)
+	pod.Spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{{
+		MaxSkew:           1,
+		TopologyKey:       corev1.LabelTopologyZone,
+		WhenUnsatisfiable: corev1.DoNotSchedule,
+		LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		MinDomains:        minDomains,
+	}}
+	return pod
+}
+
+func pinnedWebPod(name, deviceID string) *corev1.Pod {
+	pod := affinityPod(name, "web", nil)
+	pod.Annotations = map[string]string{deviceIDAnnotation: deviceID}
+	return pod
+}
+
+func TestTopologySpreadAcrossZones(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("d1", "edge", map[string]string{"zone": "a"})
+	server.AddDevice("d2", "edge", map[string]string{"zone": "a"})
+	server.AddDevice("d3", "edge", map[string]string{"zone": "b"})
+	server.AddDevice("d4", "edge", nil)
+	p := newTestProvider(t, server, Config{NodeName: "fleet-edge", FleetID: "edge"})
+	ctx := context.Background()
+
+	if err := p.CreatePod(ctx, pinnedWebPod("web-0", "d1")); err != nil {
+		t.Fatalf("CreatePod web-0: %v", err)
+	}
+	// Zone a runs a pod, so the next one goes to zone b; d4 has no zone
+	if err := p.CreatePod(ctx, zoneSpreadPod("web-1", nil)); err != nil {
+		t.Fatalf("CreatePod web-1: %v", err)
+	}
+	if deviceID := p.placedDevice(t, "default/web-1"); deviceID != "d3" {
+		t.Errorf("web-1 placed on %s, want d3 in zone b", deviceID)
+	}
+
+	if err := p.CreatePod(ctx, pinnedWebPod("web-2", "d2")); err != nil {
+		t.Fatalf("CreatePod web-2: %v", err)
+	}
+	// Zone a runs two pods and zone b one: a third in zone a is a skew of 2
+	if err := p.CreatePod(ctx, zoneSpreadPod("web-3", nil)); err != nil {
+		t.Fatalf("CreatePod web-3: %v", err)
+	}
+	if deviceID := p.placedDevice(t, "default/web-3"); deviceID != "d3" {
+		t.Errorf("web-3 placed on %s, want d3 in zone b", deviceID)
+	}
+}
+
+func TestTopologySpreadMinDomains(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("d1", "edge", map[string]string{"zone": "a"})
+	server.AddDevice("d2", "edge", map[string]string{"zone": "a"})
+	p := newTestProvider(t, server, Config{NodeName: "fleet-edge", FleetID: "edge"})
+	ctx := context.Background()
+
+	if err := p.CreatePod(ctx, pinnedWebPod("web-0", "d1")); err != nil {
+		t.Fatalf("CreatePod web-0: %v", err)
+	}
+	// With one zone short of minDomains, its pod count is the skew
+	two := int32(2)
+	err := p.CreatePod(ctx, zoneSpreadPod("web-1", &two))
+	if err == nil || !strings.Contains(err.Error(), "topology spread constraint") {
+		t.Errorf("CreatePod web-1 error = %v, want every device ruled out by the spread constraint", err)
+	}
+}
+
+func TestFleetNodeTopologyLabels(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddFleet("edge", nil)
+	server.AddDevice("d1", "edge", map[string]string{"region": "eu", "zone": "a"})
+	server.AddDevice("d2", "edge", map[string]string{"region": "eu", "zone": "b"})
+	p := newTestProvider(t, server, Config{NodeName: "fleet-edge", FleetID: "edge"})
+	ctx := context.Background()
+	fleet, err := p.flightctl.GetFleet(ctx, "edge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := p.flightctl.ListDevices(ctx, "edge", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetFleet(fleet, devices)
+
+	node, err := p.GetNode(ctx)
+	if err != nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	if region := node.Labels[corev1.LabelTopologyRegion]; region != "eu" {
+		t.Errorf("region label = %q, want eu", region)
+	}
+	if zone, ok := node.Labels[corev1.LabelTopologyZone]; ok {
+		t.Errorf("zone label = %q on a fleet spanning two zones", zone)
+	}
+}
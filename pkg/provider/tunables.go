@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// Default loop intervals.
+const (
+	DefaultReconcileInterval       = 15 * time.Second
+	DefaultDisconnectCheckInterval = 30 * time.Second
+)
+
+// Default node intervals.
+const (
+	DefaultDeviceRefreshInterval = 30 * time.Second
+	DefaultInformerResyncPeriod  = 30 * time.Second
+	DefaultNodeHeartbeatInterval = time.Minute
+)
+
+// DefaultDeploymentReadyTimeout is how long a deployed application has to
+// start running before it is rolled back.
+const DefaultDeploymentReadyTimeout = 10 * time.Minute
+
+// Tunables are the provider settings that can be changed at runtime without
+// restarting the node controller.
+type Tunables struct {
+	// ReconcileInterval is how often pod status is refreshed from FlightCtl.
+	ReconcileInterval time.Duration
+	// ReconcileJitter is the longest random delay of a device's refresh
+	// after each interval.
+	ReconcileJitter time.Duration
+	// DisconnectCheckInterval is how often device connectivity is checked.
+	DisconnectCheckInterval time.Duration
+	// DeviceReconnectTimeout applies to disconnections detected after the
+	// change; timeouts already running keep their original deadline.
+	DeviceReconnectTimeout time.Duration
+	// DeploymentReadyTimeout applies to pods created or updated after the
+	// change.
+	DeploymentReadyTimeout time.Duration
+}
+
+// Validate fills in defaults and checks the values.
+func (t *Tunables) Validate() error {
+	if t.ReconcileInterval == 0 {
+		t.ReconcileInterval = DefaultReconcileInterval
+	}
+	if t.ReconcileInterval < time.Second {
+		return fmt.Errorf("reconcile interval must be at least 1s, got %s", t.ReconcileInterval)
+	}
+	if t.ReconcileJitter == 0 {
+		t.ReconcileJitter = t.ReconcileInterval / 10
+	}
+	if t.ReconcileJitter < 0 || t.ReconcileJitter >= t.ReconcileInterval {
+		return fmt.Errorf("reconcile jitter must be positive and less than the reconcile interval (%s), got %s", t.ReconcileInterval, t.ReconcileJitter)
+	}
+
+	if t.DisconnectCheckInterval == 0 {
+		t.DisconnectCheckInterval = DefaultDisconnectCheckInterval
+	}
+	if t.DisconnectCheckInterval < time.Second {
+		return fmt.Errorf("disconnect check interval must be at least 1s, got %s", t.DisconnectCheckInterval)
+	}
+
+	if t.DeviceReconnectTimeout == 0 {
+		t.DeviceReconnectTimeout = defaultDeviceReconnectTimeout
+	}
+	if t.DeviceReconnectTimeout < time.Minute || t.DeviceReconnectTimeout > 30*time.Minute {
+		return fmt.Errorf("device reconnect timeout must be between 1m and 30m, got %s", t.DeviceReconnectTimeout)
+	}
+
+	if t.DeploymentReadyTimeout == 0 {
+		t.DeploymentReadyTimeout = DefaultDeploymentReadyTimeout
+	}
+	if t.DeploymentReadyTimeout < time.Minute {
+		return fmt.Errorf("deployment ready timeout must be at least 1m, got %s", t.DeploymentReadyTimeout)
+	}
+	return nil
+}
+
+// Tunables returns the settings currently in effect.
+func (p *Provider) Tunables() Tunables {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.tunables
+}
+
+// UpdateTunables validates and applies new runtime settings. The background
+// loops pick up new intervals immediately.
+func (p *Provider) UpdateTunables(t Tunables) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	old := p.tunables
+	p.tunables = t
+	p.mu.Unlock()
+
+	if old == t {
+		return nil
+	}
+	logger.Info("Provider settings updated: reconcile interval %s (jitter %s), disconnect check interval %s, device reconnect timeout %s, deployment ready timeout %s",
+		t.ReconcileInterval, t.ReconcileJitter, t.DisconnectCheckInterval, t.DeviceReconnectTimeout, t.DeploymentReadyTimeout)
+
+	if old.ReconcileInterval != t.ReconcileInterval {
+		notify(p.reconcileIntervalChanged)
+	}
+	if old.DisconnectCheckInterval != t.DisconnectCheckInterval {
+		notify(p.disconnectIntervalChanged)
+	}
+	return nil
+}
+
+// notify signals ch without blocking; a pending signal is enough.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,121 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// Pod validation modes: how pods using features that do not survive the
+// translation to a FlightCtl application are handled.
+const (
+	// PodValidationPermissive deploys the pod without the features, with a
+	// warning event and the UnsupportedFeaturesAnnotation.
+	PodValidationPermissive = "permissive"
+	// PodValidationStrict rejects the pod.
+	PodValidationStrict = "strict"
+)
+
+// UnsupportedFeaturesAnnotation lists the fields of a pod that were dropped
+// when it was deployed in permissive mode.
+const UnsupportedFeaturesAnnotation = "flightctl.io/unsupported-features"
+
+// SetKubeClient sets the Kubernetes client used to annotate pods. Without
+// one, pods are not annotated.
+func (p *Provider) SetKubeClient(client kubernetes.Interface) {
+	p.kubeClient = client
+}
+
+// validatePod checks a pod for unsupported features. In strict mode a pod
+// using any is rejected with an error naming them; in permissive mode they
+// are reported in a warning event and an annotation, and nil is returned.
+// Privileged pods in a namespace denied privileged containers are rejected
+// in either mode.
+func (p *Provider) validatePod(ctx context.Context, pod *corev1.Pod) error {
+	if flightctl.PodPrivileged(pod) && p.privilegedDenied(pod.Namespace) {
+		p.recordEvent(pod, corev1.EventTypeWarning, "PrivilegedPodDenied",
+			"Pod rejected: privileged containers are not allowed in namespace %s", pod.Namespace)
+		return fmt.Errorf("privileged containers are not allowed in namespace %s", pod.Namespace)
+	}
+
+	resolved, err := p.resolveConfigRefs(pod)
+	if err != nil {
+		return err
+	}
+	features := flightctl.UnsupportedFeatures(resolved)
+	if len(features) == 0 {
+		return nil
+	}
+	descriptions := make([]string, len(features))
+	fields := make([]string, len(features))
+	for i, feature := range features {
+		descriptions[i] = feature.String()
+		fields[i] = feature.Field
+	}
+	summary := strings.Join(descriptions, "; ")
+
+	if p.podValidation == PodValidationStrict {
+		p.recordEvent(pod, corev1.EventTypeWarning, "UnsupportedPodFeatures", "Pod rejected: %s", summary)
+		return fmt.Errorf("pod uses features not supported on FlightCtl devices: %s", summary)
+	}
+
+	logger.FromContext(ctx).Warn("Pod %s/%s is deployed without unsupported features: %s", pod.Namespace, pod.Name, summary)
+	p.recordEvent(pod, corev1.EventTypeWarning, "UnsupportedPodFeatures", "Dropped on the device: %s", summary)
+	p.annotatePod(ctx, pod, map[string]string{UnsupportedFeaturesAnnotation: strings.Join(fields, ",")})
+	return nil
+}
+
+// privilegedDenied reports whether pods of the namespace may not run
+// privileged containers.
+func (p *Provider) privilegedDenied(namespace string) bool {
+	return slices.ContainsFunc(p.denyPrivileged, func(denied string) bool {
+		return denied == "*" || denied == namespace
+	})
+}
+
+// recordEvent emits an event for a pod, if an event recorder is configured.
+func (p *Provider) recordEvent(pod *corev1.Pod, eventType, reason, messageFmt string, args ...interface{}) {
+	if p.eventRecorder == nil {
+		return
+	}
+	p.eventRecorder.Eventf(pod, eventType, reason, messageFmt, args...)
+}
+
+// annotatePod sets annotations on a pod in a single patch, if a Kubernetes
+// client is configured. Annotations the pod already has are left out, and
+// nothing is patched if it has them all. Failures are only logged.
+func (p *Provider) annotatePod(ctx context.Context, pod *corev1.Pod, annotations map[string]string) {
+	if p.kubeClient == nil {
+		return
+	}
+	changed := make(map[string]string, len(annotations))
+	for key, value := range annotations {
+		if current, ok := pod.Annotations[key]; !ok || current != value {
+			changed[key] = value
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": changed},
+	})
+	if err != nil {
+		return
+	}
+	if _, err := p.kubeClient.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		keys := slices.Sorted(maps.Keys(changed))
+		logger.FromContext(ctx).Warn("Annotating pod %s/%s with %s: %v", pod.Namespace, pod.Name, strings.Join(keys, ", "), err)
+	}
+}
@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+func hostPIDPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			HostPID:    true,
+			Containers: []corev1.Container{{Name: "app", Image: "app:1"}},
+		},
+	}
+}
+
+func TestStrictValidationRejectsUnsupportedPods(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "edge", nil)
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1", PodValidation: PodValidationStrict})
+	recorder := record.NewFakeRecorder(10)
+	p.SetEventRecorder(recorder)
+
+	err := p.CreatePod(context.Background(), hostPIDPod())
+	if err == nil || !strings.Contains(err.Error(), "spec.hostPID") {
+		t.Fatalf("CreatePod error = %v, want a rejection naming spec.hostPID", err)
+	}
+	if device := fetchDevice(t, server, "d1"); len(device.Spec.Applications) != 0 {
+		t.Errorf("rejected pod was deployed: %+v", device.Spec.Applications)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "UnsupportedPodFeatures") {
+			t.Errorf("event = %q, want UnsupportedPodFeatures", event)
+		}
+	default:
+		t.Error("no event recorded for the rejected pod")
+	}
+}
+
+func TestPermissiveValidationDeploysWithWarning(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "edge", nil)
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1"})
+	recorder := record.NewFakeRecorder(10)
+	p.SetEventRecorder(recorder)
+
+	if err := p.CreatePod(context.Background(), hostPIDPod()); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	if device := fetchDevice(t, server, "d1"); len(device.Spec.Applications) != 1 {
+		t.Errorf("applications = %+v, want the pod deployed", device.Spec.Applications)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, corev1.EventTypeWarning+" UnsupportedPodFeatures") {
+			t.Errorf("event = %q, want an UnsupportedPodFeatures warning", event)
+		}
+	default:
+		t.Error("no warning event recorded")
+	}
+}
+
+func TestDenyPrivilegedNamespaces(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddDevice("d1", "edge", nil)
+	p := newTestProvider(t, server, Config{NodeName: "d1", DeviceID: "d1", DenyPrivilegedNamespaces: []string{"apps"}})
+	recorder := record.NewFakeRecorder(10)
+	p.SetEventRecorder(recorder)
+
+	privileged := true
+	privilegedPod := func(namespace string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: namespace},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:            "agent",
+				Image:           "agent:1",
+				SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+			}}},
+		}
+	}
+
+	err := p.CreatePod(context.Background(), privilegedPod("apps"))
+	if err == nil || !strings.Contains(err.Error(), "privileged") {
+		t.Fatalf("CreatePod error = %v, want a privileged pod rejection", err)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "PrivilegedPodDenied") {
+			t.Errorf("event = %q, want PrivilegedPodDenied", event)
+		}
+	default:
+		t.Error("no event recorded for the rejected pod")
+	}
+
+	if err := p.CreatePod(context.Background(), privilegedPod("system")); err != nil {
+		t.Errorf("CreatePod in a namespace allowing privileged pods: %v", err)
+	}
+	if apps := fetchDevice(t, server, "d1").Spec.Applications; len(apps) != 1 {
+		t.Errorf("applications = %+v, want only the system pod", apps)
+	}
+}
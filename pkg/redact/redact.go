@@ -0,0 +1,151 @@
+// Package redact masks secret values before they are written to the logs.
+//
+// Environment variables are treated as secret when their name matches one of
+// the env patterns, and inline files when their path matches one of the file
+// patterns. Patterns are case-insensitive path.Match globs. The patterns are
+// global, like the logger configuration, and are set once at startup and on
+// config reload.
+package redact
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Mask replaces redacted values.
+const Mask = "***"
+
+// Default patterns, as comma-separated lists.
+const (
+	DefaultEnvPatterns  = "*PASSWORD*,*PASSWD*,*SECRET*,*TOKEN*,*KEY*,*CREDENTIAL*,*AUTH*"
+	DefaultFilePatterns = "*secret*,*.key,*.pem,*.p12,*credentials*"
+)
+
+var (
+	mu           sync.RWMutex
+	envPatterns  = mustParse(DefaultEnvPatterns)
+	filePatterns = mustParse(DefaultFilePatterns)
+)
+
+// ParsePatterns parses a comma-separated list of glob patterns. An empty list
+// or "none" disables redaction.
+func ParsePatterns(spec string) ([]string, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || strings.EqualFold(spec, "none") {
+		return []string{}, nil
+	}
+	var patterns []string
+	for _, pattern := range strings.Split(spec, ",") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+func mustParse(spec string) []string {
+	patterns, err := ParsePatterns(spec)
+	if err != nil {
+		panic(err)
+	}
+	return patterns
+}
+
+// SetEnvPatterns sets the env variable name patterns from a comma-separated
+// list.
+func SetEnvPatterns(spec string) error {
+	patterns, err := ParsePatterns(spec)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	envPatterns = patterns
+	return nil
+}
+
+// SetFilePatterns sets the inline file path patterns from a comma-separated
+// list.
+func SetFilePatterns(spec string) error {
+	patterns, err := ParsePatterns(spec)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	filePatterns = patterns
+	return nil
+}
+
+func matches(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SecretEnv reports whether the value of the env variable must be redacted.
+func SecretEnv(name string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return matches(envPatterns, name)
+}
+
+// SecretFile reports whether the content of the file must be redacted. The
+// full path and each of its directories and base name are matched, so
+// *secret* matches secrets/db.env.
+func SecretFile(filePath string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	if matches(filePatterns, filePath) {
+		return true
+	}
+	for _, element := range strings.Split(filePath, "/") {
+		if element != "" && matches(filePatterns, element) {
+			return true
+		}
+	}
+	return false
+}
+
+// Env returns the value of the env variable, or Mask if it is secret.
+func Env(name, value string) string {
+	if value != "" && SecretEnv(name) {
+		return Mask
+	}
+	return value
+}
+
+// assignment matches NAME=value and NAME: value settings, optionally quoted
+// or as a YAML list item, up to the end of the line.
+var assignment = regexp.MustCompile(`(?m)^([ \t]*(?:-[ \t]*)?["']?(?:export[ \t]+|Environment=)?)([A-Za-z_][A-Za-z0-9_.-]*)(["']?[ \t]*[=:][ \t]*)(\S.*?)[ \t\r]*$`)
+
+// Text masks the values of secret env variables set in text, such as a
+// compose file, a quadlet unit or an env file. Lines are matched as
+// NAME=value or NAME: value; any other content is returned unchanged.
+func Text(text string) string {
+	return TextWith(text, nil)
+}
+
+// TextWith masks the values of secret env variables set in text like Text,
+// and also those of the variables in names, whatever their name.
+func TextWith(text string, names map[string]bool) string {
+	return assignment.ReplaceAllStringFunc(text, func(line string) string {
+		m := assignment.FindStringSubmatch(line)
+		if !names[m[2]] && !SecretEnv(m[2]) {
+			return line
+		}
+		return m[1] + m[2] + m[3] + Mask
+	})
+}
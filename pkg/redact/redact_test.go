@@ -0,0 +1,98 @@
+package redact
+
+import (
+	"testing"
+)
+
+func TestSecretEnvDefaults(t *testing.T) {
+	for name, want := range map[string]bool{
+		"DB_PASSWORD":       true,
+		"api_token":         true,
+		"AWS_SECRET_ACCESS": true,
+		"SSH_KEY":           true,
+		"LOG_LEVEL":         false,
+		"HOME":              false,
+	} {
+		if got := SecretEnv(name); got != want {
+			t.Errorf("SecretEnv(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestSecretFileDefaults(t *testing.T) {
+	for filePath, want := range map[string]bool{
+		"secrets/db.env":          true,
+		"tls/server.key":          true,
+		"tls/ca.PEM":              true,
+		"docker-compose.yaml":     false,
+		"config/app.conf":         false,
+		"aws/credentials":         true,
+		"web.container":           false,
+		"volumes/my-secret/token": true,
+	} {
+		if got := SecretFile(filePath); got != want {
+			t.Errorf("SecretFile(%q) = %v, want %v", filePath, got, want)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	compose := `services:
+  web:
+    image: nginx
+    environment:
+      - DB_PASSWORD=hunter2
+      - LOG_LEVEL=debug
+      API_TOKEN: "abc"
+`
+	want := `services:
+  web:
+    image: nginx
+    environment:
+      - DB_PASSWORD=***
+      - LOG_LEVEL=debug
+      API_TOKEN: ***
+`
+	if got := Text(compose); got != want {
+		t.Errorf("Text(compose) =\n%s\nwant\n%s", got, want)
+	}
+
+	quadlet := "[Container]\nImage=nginx\nEnvironment=DB_PASSWORD=hunter2\nEnvironment=MODE=prod\n"
+	wantQuadlet := "[Container]\nImage=nginx\nEnvironment=DB_PASSWORD=***\nEnvironment=MODE=prod\n"
+	if got := Text(quadlet); got != wantQuadlet {
+		t.Errorf("Text(quadlet) = %q, want %q", got, wantQuadlet)
+	}
+}
+
+func TestSetEnvPatterns(t *testing.T) {
+	t.Cleanup(func() { _ = SetEnvPatterns(DefaultEnvPatterns) })
+
+	if err := SetEnvPatterns("MY_*, *_PIN"); err != nil {
+		t.Fatalf("SetEnvPatterns: %v", err)
+	}
+	if !SecretEnv("my_value") || !SecretEnv("CARD_PIN") || SecretEnv("DB_PASSWORD") {
+		t.Error("expected only the configured patterns to match")
+	}
+	if got := Env("CARD_PIN", "1234"); got != Mask {
+		t.Errorf("Env(CARD_PIN) = %q, want %q", got, Mask)
+	}
+
+	if err := SetEnvPatterns("none"); err != nil {
+		t.Fatalf("SetEnvPatterns(none): %v", err)
+	}
+	if SecretEnv("CARD_PIN") {
+		t.Error("expected no redaction with none")
+	}
+
+	if err := SetEnvPatterns("[A-"); err == nil {
+		t.Error("expected an error for a malformed pattern")
+	}
+}
+
+func TestTextWithNames(t *testing.T) {
+	text := "      - DB_URL=postgres://admin:hunter2@db\n      - MODE=edge\n"
+	want := "      - DB_URL=***\n      - MODE=edge\n"
+	if got := TextWith(text, map[string]bool{"DB_URL": true}); got != want {
+		t.Errorf("TextWith =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,224 @@
+// Package registry resolves container image tags to the digests their
+// registries currently serve them under, so that deployments can pin the
+// exact image rather than a tag that may be pushed again.
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Docker Hub is addressed as docker.io in image references but serves the
+// registry API from registryHubHost.
+const (
+	dockerHubDomain = "docker.io"
+	registryHubHost = "registry-1.docker.io"
+)
+
+// manifestMediaTypes are the manifest types accepted when resolving a tag:
+// image indexes first, so multi-platform images resolve to the digest each
+// device then picks its platform from.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// Reference is a parsed image reference.
+type Reference struct {
+	Domain     string // Registry, e.g. docker.io or quay.io:443
+	Repository string // Path in the registry, e.g. library/nginx
+	Tag        string // Tag, latest if the reference has neither tag nor digest
+	Digest     string // Digest, e.g. sha256:..., if the reference is pinned
+}
+
+// ParseReference parses an image reference the way container engines do:
+// references without a registry are on Docker Hub, and single-component
+// Docker Hub repositories are in library/.
+func ParseReference(image string) (Reference, error) {
+	var ref Reference
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.Digest = name[:i], name[i+1:]
+		if !strings.Contains(ref.Digest, ":") {
+			return Reference{}, fmt.Errorf("invalid image reference %q: malformed digest", image)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+	}
+	if name == "" || ref.Tag == "" && strings.HasSuffix(image, ":") {
+		return Reference{}, fmt.Errorf("invalid image reference %q", image)
+	}
+
+	ref.Domain = dockerHubDomain
+	if i := strings.Index(name, "/"); i >= 0 {
+		first := name[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			ref.Domain, name = first, name[i+1:]
+		}
+	}
+	if ref.Domain == dockerHubDomain && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	ref.Repository = name
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	return ref, nil
+}
+
+// Name returns the fully qualified repository name, e.g.
+// docker.io/library/nginx.
+func (r Reference) Name() string {
+	return r.Domain + "/" + r.Repository
+}
+
+// Resolver resolves image tags with registry API manifest requests, using
+// anonymous bearer tokens for registries that ask for them.
+type Resolver struct {
+	client *http.Client
+}
+
+// NewResolver returns a resolver making requests with client, or a client
+// with a 30s timeout if nil.
+func NewResolver(client *http.Client) *Resolver {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Resolver{client: client}
+}
+
+// Resolve returns the digest the registry serves an image's tag under. The
+// digest of a pinned reference is returned as is.
+func (r *Resolver) Resolve(ctx context.Context, image string) (string, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+
+	host := ref.Domain
+	if host == dockerHubDomain {
+		host = registryHubHost
+	}
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, ref.Repository, ref.Tag)
+
+	resp, err := r.headManifest(ctx, manifestURL, "")
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", image, err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		token, err := r.token(ctx, challenge, ref)
+		if err != nil {
+			return "", fmt.Errorf("resolving %s: %w", image, err)
+		}
+		if resp, err = r.headManifest(ctx, manifestURL, token); err != nil {
+			return "", fmt.Errorf("resolving %s: %w", image, err)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("resolving %s: registry returned status %d", image, resp.StatusCode)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("resolving %s: registry returned no digest", image)
+	}
+	return digest, nil
+}
+
+// headManifest makes a manifest HEAD request, with a bearer token if given.
+func (r *Resolver) headManifest(ctx context.Context, manifestURL, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, manifestURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting manifest: %w", err)
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// token gets an anonymous pull token from the realm of a bearer challenge.
+func (r *Resolver) token(ctx context.Context, challenge string, ref Reference) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("registry requires %q authentication", scheme)
+	}
+	fields := parseChallenge(params)
+	realm, err := url.Parse(fields["realm"])
+	if err != nil || fields["realm"] == "" {
+		return "", fmt.Errorf("invalid bearer challenge %q", challenge)
+	}
+	query := realm.Query()
+	if service := fields["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := fields["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", ref.Repository)
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("creating token request: %w", err)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("requesting token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request returned status %d", resp.StatusCode)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding token: %w", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", fmt.Errorf("token response carries no token")
+}
+
+// parseChallenge parses the comma-separated key="value" parameters of a
+// WWW-Authenticate challenge.
+func parseChallenge(params string) map[string]string {
+	fields := make(map[string]string)
+	for params != "" {
+		var key, value string
+		key, params, _ = strings.Cut(params, "=")
+		key = strings.TrimSpace(key)
+		if strings.HasPrefix(params, `"`) {
+			value, params, _ = strings.Cut(params[1:], `"`)
+			params = strings.TrimPrefix(strings.TrimSpace(params), ",")
+		} else {
+			value, params, _ = strings.Cut(params, ",")
+		}
+		fields[strings.ToLower(key)] = strings.TrimSpace(value)
+	}
+	return fields
+}
@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	for _, tc := range []struct {
+		image string
+		want  Reference
+	}{
+		{"nginx", Reference{Domain: "docker.io", Repository: "library/nginx", Tag: "latest"}},
+		{"nginx:1.25", Reference{Domain: "docker.io", Repository: "library/nginx", Tag: "1.25"}},
+		{"grafana/agent:v0.40", Reference{Domain: "docker.io", Repository: "grafana/agent", Tag: "v0.40"}},
+		{"quay.io/flightctl/agent", Reference{Domain: "quay.io", Repository: "flightctl/agent", Tag: "latest"}},
+		{"localhost:5000/app:dev", Reference{Domain: "localhost:5000", Repository: "app", Tag: "dev"}},
+		{"nginx:1.25@sha256:abc", Reference{Domain: "docker.io", Repository: "library/nginx", Tag: "1.25", Digest: "sha256:abc"}},
+		{"registry.local/app@sha256:abc", Reference{Domain: "registry.local", Repository: "app", Digest: "sha256:abc"}},
+	} {
+		got, err := ParseReference(tc.image)
+		if err != nil {
+			t.Errorf("ParseReference(%q): %v", tc.image, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseReference(%q) = %+v, want %+v", tc.image, got, tc.want)
+		}
+	}
+	for _, image := range []string{"", "nginx:", "nginx@abc"} {
+		if _, err := ParseReference(image); err == nil {
+			t.Errorf("ParseReference(%q): expected an error", image)
+		}
+	}
+}
+
+func TestResolveWithBearerToken(t *testing.T) {
+	const digest = "sha256:0123456789abcdef"
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if r.URL.Query().Get("scope") != "repository:edge/app:pull" || r.URL.Query().Get("service") != "test-registry" {
+				t.Errorf("token query = %s", r.URL.RawQuery)
+			}
+			_, _ = w.Write([]byte(`{"token":"t0ken"}`))
+		case "/v2/edge/app/manifests/1.0":
+			if r.Method != http.MethodHead || !strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+				t.Errorf("manifest request = %s with Accept %q", r.Method, r.Header.Get("Accept"))
+			}
+			if r.Header.Get("Authorization") != "Bearer t0ken" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="test-registry",scope="repository:edge/app:pull"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", digest)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+	resolver := NewResolver(srv.Client())
+	ctx := context.Background()
+
+	got, err := resolver.Resolve(ctx, host+"/edge/app:1.0")
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if got != digest {
+		t.Errorf("digest = %s, want %s", got, digest)
+	}
+
+	if _, err := resolver.Resolve(ctx, host+"/edge/app:missing"); err == nil {
+		t.Error("expected an error for an unknown tag")
+	}
+	if got, _ := resolver.Resolve(ctx, host+"/edge/app@sha256:fixed"); got != "sha256:fixed" {
+		t.Errorf("pinned digest = %s, want it unchanged", got)
+	}
+}
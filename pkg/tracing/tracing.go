@@ -0,0 +1,153 @@
+// Package tracing sets up OpenTelemetry tracing for the provider and the
+// FlightCtl client.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// ServiceName is the default service.name resource attribute.
+const ServiceName = "vk-flightctl-provider"
+
+const instrumentationName = "github.com/raycarroll/vk-flightctl-provider"
+
+// Span attribute keys shared across the provider and the client.
+const (
+	PodNameKey      = attribute.Key("k8s.pod.name")
+	PodNamespaceKey = attribute.Key("k8s.namespace.name")
+	DeviceIDKey     = attribute.Key("flightctl.device.id")
+	AppNameKey      = attribute.Key("flightctl.app.name")
+)
+
+// Tracer returns the tracer used by the provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Enabled reports whether the standard OTel environment variables request
+// OTLP trace export.
+func Enabled() bool {
+	if exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter != "" {
+		return strings.EqualFold(exporter, "otlp")
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Setup installs a global tracer provider exporting spans over OTLP/gRPC.
+// The exporter, sampler and resource are configured by the standard OTEL_*
+// environment variables. If tracing is not enabled, spans are no-ops. The
+// returned function flushes and stops the exporter.
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	if !Enabled() {
+		logger.Debug("OTLP endpoint not configured, tracing disabled")
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP trace exporter: %w", err)
+	}
+
+	// Attributes from OTEL_SERVICE_NAME / OTEL_RESOURCE_ATTRIBUTES override the default name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating trace resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	logger.Info("OpenTelemetry tracing enabled")
+	return tp.Shutdown, nil
+}
+
+// RecordError marks the span as failed if err is non-nil.
+func RecordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+type attributesKey struct{}
+
+// WithAttributes returns a context whose HTTP client spans carry the given
+// attributes in addition to any already attached.
+func WithAttributes(ctx context.Context, attrs ...attribute.KeyValue) context.Context {
+	existing := contextAttributes(ctx)
+	merged := make([]attribute.KeyValue, 0, len(existing)+len(attrs))
+	merged = append(merged, existing...)
+	merged = append(merged, attrs...)
+	return context.WithValue(ctx, attributesKey{}, merged)
+}
+
+func contextAttributes(ctx context.Context) []attribute.KeyValue {
+	attrs, _ := ctx.Value(attributesKey{}).([]attribute.KeyValue)
+	return attrs
+}
+
+// Transport wraps an http.RoundTripper with a client span per request,
+// tagged with the attributes attached to the request context, and injects
+// the trace context into the request headers.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper interface.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := Tracer().Start(req.Context(), req.Method+" "+req.URL.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLFull(req.URL.Redacted()),
+		),
+		trace.WithAttributes(contextAttributes(req.Context())...),
+	)
+	defer span.End()
+
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		RecordError(span, err)
+		return nil, err
+	}
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// CloseIdleConnections closes idle connections of the base transport.
+func (t *Transport) CloseIdleConnections() {
+	if c, ok := t.Base.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spanRecorder keeps the spans ended while it is installed.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (r *spanRecorder) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *spanRecorder) Shutdown(context.Context) error { return nil }
+
+func (r *spanRecorder) ended() []sdktrace.ReadOnlySpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]sdktrace.ReadOnlySpan(nil), r.spans...)
+}
+
+// recordSpans installs a global tracer provider exporting to a recorder.
+func recordSpans(t *testing.T) *spanRecorder {
+	t.Helper()
+	recorder := &spanRecorder{}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(recorder))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+	return recorder
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTransportStartsClientSpanWithContextAttributes(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var traceparent string
+	transport := &Transport{Base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		traceparent = req.Header.Get("traceparent")
+		return &http.Response{StatusCode: http.StatusNotFound, Body: http.NoBody, Request: req}, nil
+	})}
+	ctx := WithAttributes(context.Background(), DeviceIDKey.String("d1"))
+	ctx = WithAttributes(ctx, PodNameKey.String("web"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://flightctl.example.com/api/v1/devices/d1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+
+	spans := recorder.ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "GET /api/v1/devices/d1" || span.SpanKind() != trace.SpanKindClient {
+		t.Errorf("span = %q (%s), want a client span named GET /api/v1/devices/d1", span.Name(), span.SpanKind())
+	}
+	for key, want := range map[attribute.Key]string{DeviceIDKey: "d1", PodNameKey: "web"} {
+		if got, ok := spanAttribute(span, key); !ok || got.AsString() != want {
+			t.Errorf("%s = %q, want %q from the request context", key, got.AsString(), want)
+		}
+	}
+	if span.Status().Code != codes.Error {
+		t.Errorf("status = %v, want an error for a 404 response", span.Status().Code)
+	}
+	if traceparent == "" {
+		t.Error("request has no traceparent header, want the span's context injected")
+	}
+}
+
+func TestRecordErrorSetsSpanStatus(t *testing.T) {
+	recorder := recordSpans(t)
+
+	_, ok := Tracer().Start(context.Background(), "ok")
+	RecordError(ok, nil)
+	ok.End()
+	_, failed := Tracer().Start(context.Background(), "failed")
+	RecordError(failed, errors.New("device not found"))
+	failed.End()
+
+	spans := recorder.ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if status := spans[0].Status(); status.Code != codes.Unset {
+		t.Errorf("status without an error = %v, want it left unset", status.Code)
+	}
+	if status := spans[1].Status(); status.Code != codes.Error || status.Description != "device not found" {
+		t.Errorf("status = %v (%q), want Error (device not found)", status.Code, status.Description)
+	}
+	if events := spans[1].Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("events = %+v, want the error recorded", events)
+	}
+}
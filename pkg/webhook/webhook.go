@@ -0,0 +1,201 @@
+// Package webhook serves a validating admission webhook for pods, so pods
+// the provider could never deploy are rejected when they are created rather
+// than left Pending.
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/logger"
+)
+
+// ValidatePodsPath is where pod admission reviews are served.
+const ValidatePodsPath = "/validate-pods"
+
+// maxReviewSize bounds the size of an admission review.
+const maxReviewSize = 3 << 20
+
+// Admitter checks the pods meant for it.
+type Admitter interface {
+	// TargetsPod reports whether the pod is meant for the admitter.
+	TargetsPod(pod *corev1.Pod) bool
+	// AdmitPod returns warnings for the pod, and an error if it is
+	// rejected.
+	AdmitPod(ctx context.Context, pod *corev1.Pod) ([]string, error)
+}
+
+// Handler serves pod admission reviews. A pod is allowed if no admitter
+// targets it, or if one of those targeting it admits it; otherwise it is
+// rejected with the first admitter's error. Only pod creations are checked.
+type Handler struct {
+	admitters []Admitter
+}
+
+// NewHandler returns a handler checking pods with the admitters, e.g. the
+// providers of several FlightCtl endpoints.
+func NewHandler(admitters ...Admitter) *Handler {
+	return &Handler{admitters: admitters}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxReviewSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var review admissionv1.AdmissionReview
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, "invalid admission review", http.StatusBadRequest)
+		return
+	}
+
+	response := h.review(r.Context(), review.Request)
+	response.UID = review.Request.UID
+	review.Request = nil
+	review.Response = response
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		logger.Warn("Writing admission response: %v", err)
+	}
+}
+
+// review checks the pod of an admission request.
+func (h *Handler) review(ctx context.Context, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	allowed := &admissionv1.AdmissionResponse{Allowed: true}
+	if request.Kind.Kind != "Pod" || request.Operation != admissionv1.Create || request.SubResource != "" {
+		return allowed
+	}
+	var pod corev1.Pod
+	if err := json.Unmarshal(request.Object.Raw, &pod); err != nil {
+		return &admissionv1.AdmissionResponse{Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusBadRequest,
+			Reason:  metav1.StatusReasonBadRequest,
+			Message: fmt.Sprintf("decoding pod: %v", err),
+		}}
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = request.Namespace
+	}
+	if pod.Name == "" {
+		// Pods named by generateName have no name yet
+		pod.Name = pod.GenerateName
+	}
+
+	var rejection error
+	var warnings []string
+	for _, admitter := range h.admitters {
+		if !admitter.TargetsPod(&pod) {
+			continue
+		}
+		podWarnings, err := admitter.AdmitPod(ctx, &pod)
+		if err == nil {
+			allowed.Warnings = podWarnings
+			return allowed
+		}
+		if rejection == nil {
+			rejection, warnings = err, podWarnings
+		}
+	}
+	if rejection == nil {
+		return allowed
+	}
+	logger.FromContext(ctx).Info("Rejecting pod %s/%s: %v", pod.Namespace, pod.Name, rejection)
+	return &admissionv1.AdmissionResponse{
+		Warnings: warnings,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusForbidden,
+			Reason:  metav1.StatusReasonForbidden,
+			Message: rejection.Error(),
+		},
+	}
+}
+
+// Server serves the webhook over TLS. The certificate is read again when its
+// files change, so rotated certificates are picked up without a restart.
+type Server struct {
+	server   *http.Server
+	certFile string
+	keyFile  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	certTime time.Time // Modification time of the loaded certificate file
+}
+
+// NewServer creates a server for the handler on addr, with the TLS
+// certificate and key in the files. The key pair is checked right away.
+func NewServer(addr, certFile, keyFile string, handler *Handler) (*Server, error) {
+	s := &Server{certFile: certFile, keyFile: keyFile}
+	if _, err := s.certificate(nil); err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(ValidatePodsPath, handler)
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: s.certificate},
+	}
+	return s, nil
+}
+
+// certificate returns the key pair, loading it again if the certificate file
+// changed.
+func (s *Server) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	info, err := os.Stat(s.certFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading webhook certificate: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cert != nil && info.ModTime().Equal(s.certTime) {
+		return s.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		if s.cert != nil {
+			// Keep serving the previous certificate while the files are
+			// being replaced
+			logger.Warn("Loading webhook certificate %s: %v", s.certFile, err)
+			return s.cert, nil
+		}
+		return nil, fmt.Errorf("loading webhook certificate %s: %w", s.certFile, err)
+	}
+	s.cert, s.certTime = &cert, info.ModTime()
+	return s.cert, nil
+}
+
+// Start serves the webhook in the background.
+func (s *Server) Start() {
+	go func() {
+		logger.Info("Admission webhook listening on %s", s.server.Addr)
+		if err := s.server.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Admission webhook server failed: %v", err)
+		}
+	}()
+}
+
+// Shutdown stops the server gracefully.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
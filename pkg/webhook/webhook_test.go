@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// stubAdmitter targets pods with a label and rejects those named "bad".
+type stubAdmitter struct {
+	label string
+}
+
+func (a stubAdmitter) TargetsPod(pod *corev1.Pod) bool {
+	_, ok := pod.Labels[a.label]
+	return ok
+}
+
+func (a stubAdmitter) AdmitPod(ctx context.Context, pod *corev1.Pod) ([]string, error) {
+	if pod.Name == "bad" {
+		return nil, errors.New("rejected by " + a.label)
+	}
+	return []string{"checked by " + a.label}, nil
+}
+
+func review(t *testing.T, handler http.Handler, operation admissionv1.Operation, pod *corev1.Pod) *admissionv1.AdmissionResponse {
+	t.Helper()
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "req-1",
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Operation: operation,
+			Namespace: "default",
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, ValidatePodsPath, bytes.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", recorder.Code, recorder.Body)
+	}
+	var response admissionv1.AdmissionReview
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if response.Response == nil || response.Response.UID != "req-1" {
+		t.Fatalf("response = %+v, want one for request req-1", response.Response)
+	}
+	return response.Response
+}
+
+func TestHandler(t *testing.T) {
+	handler := NewHandler(stubAdmitter{label: "a"}, stubAdmitter{label: "b"})
+	pod := func(name string, labels ...string) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}}}
+		for _, label := range labels {
+			pod.Labels[label] = ""
+		}
+		return pod
+	}
+
+	if response := review(t, handler, admissionv1.Create, pod("bad")); !response.Allowed {
+		t.Errorf("pod no admitter targets was rejected: %+v", response.Result)
+	}
+	response := review(t, handler, admissionv1.Create, pod("web", "b"))
+	if !response.Allowed || len(response.Warnings) != 1 || response.Warnings[0] != "checked by b" {
+		t.Errorf("response = %+v, want allowed with the warning of b", response)
+	}
+	response = review(t, handler, admissionv1.Create, pod("bad", "a"))
+	if response.Allowed || response.Result == nil || response.Result.Message != "rejected by a" || response.Result.Code != http.StatusForbidden {
+		t.Errorf("response = %+v, want rejected by a", response)
+	}
+	if response := review(t, handler, admissionv1.Update, pod("bad", "a")); !response.Allowed {
+		t.Errorf("pod update was rejected: %+v", response.Result)
+	}
+}
@@ -0,0 +1,346 @@
+//go:build e2e
+
+// Package e2e runs the provider binary against a kind cluster and the fake
+// FlightCtl API, and checks that pods scheduled to the virtual node are
+// deployed to the (fake) devices and report their status back.
+//
+// Run with `make test-e2e`. Requires kind and Docker (or Podman) unless
+// E2E_KUBECONFIG points at an existing cluster.
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl"
+	"github.com/raycarroll/vk-flightctl-provider/pkg/flightctl/fake"
+)
+
+const (
+	nodeName         = "vk-flightctl-e2e"
+	fleetName        = "edge"
+	defaultCluster   = "vk-flightctl-e2e"
+	pollInterval     = time.Second
+	nodeReadyWait    = 2 * time.Minute
+	podTransitWait   = time.Minute
+	deviceIDKey      = "flightctl.io/device-id"
+	virtualNodeTaint = "vkubelet-flightctl"
+)
+
+// Shared by all tests; set up in TestMain.
+var (
+	server    *fake.Server
+	k8s       *kubernetes.Clientset
+	namespace string
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	workDir, err := os.MkdirTemp("", "vk-flightctl-e2e")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "creating work directory: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(workDir)
+
+	kubeconfig, deleteCluster, err := setupCluster(workDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "setting up cluster: %v\n", err)
+		return 1
+	}
+	defer deleteCluster()
+
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loading kubeconfig: %v\n", err)
+		return 1
+	}
+	if k8s, err = kubernetes.NewForConfig(config); err != nil {
+		fmt.Fprintf(os.Stderr, "creating Kubernetes client: %v\n", err)
+		return 1
+	}
+
+	server = fake.NewServer()
+	defer server.Close()
+	server.AddFleet(fleetName, nil)
+	server.AddDevice("device-1", fleetName, map[string]string{"region": "galway"})
+	server.AddDevice("device-2", fleetName, map[string]string{"region": "cork"})
+
+	stopProvider, err := startProvider(workDir, kubeconfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "starting provider: %v\n", err)
+		return 1
+	}
+	defer stopProvider()
+
+	ctx := context.Background()
+	if err := waitForNodeReady(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "waiting for virtual node: %v\n", err)
+		return 1
+	}
+
+	namespace = "vk-e2e-" + rand.String(5)
+	if _, err := k8s.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: namespace},
+	}, metav1.CreateOptions{}); err != nil {
+		fmt.Fprintf(os.Stderr, "creating namespace: %v\n", err)
+		return 1
+	}
+	defer k8s.CoreV1().Namespaces().Delete(context.Background(), namespace, metav1.DeleteOptions{})
+
+	return m.Run()
+}
+
+// setupCluster creates a kind cluster, or uses E2E_KUBECONFIG if set, and
+// returns its kubeconfig and a cleanup function. The cluster is kept if
+// E2E_KEEP_CLUSTER=true.
+func setupCluster(workDir string) (string, func(), error) {
+	if kubeconfig := os.Getenv("E2E_KUBECONFIG"); kubeconfig != "" {
+		return kubeconfig, func() {}, nil
+	}
+
+	cluster := os.Getenv("E2E_KIND_CLUSTER")
+	if cluster == "" {
+		cluster = defaultCluster
+	}
+	kubeconfig := filepath.Join(workDir, "kubeconfig")
+
+	if _, err := exec.LookPath("kind"); err != nil {
+		return "", nil, fmt.Errorf("kind is required (or set E2E_KUBECONFIG): %w", err)
+	}
+	cmd := exec.Command("kind", "create", "cluster", "--name", cluster, "--kubeconfig", kubeconfig, "--wait", "2m")
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", nil, fmt.Errorf("creating kind cluster %s: %w", cluster, err)
+	}
+
+	cleanup := func() {
+		if os.Getenv("E2E_KEEP_CLUSTER") == "true" {
+			fmt.Fprintf(os.Stderr, "Keeping kind cluster %s\n", cluster)
+			return
+		}
+		cmd := exec.Command("kind", "delete", "cluster", "--name", cluster)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		_ = cmd.Run()
+	}
+	return kubeconfig, cleanup, nil
+}
+
+// startProvider builds the provider binary and runs it outside the cluster
+// against the fake FlightCtl API. Its output is printed when it is stopped.
+func startProvider(workDir, kubeconfig string) (func(), error) {
+	binary := filepath.Join(workDir, "vk-flightctl-provider")
+	build := exec.Command("go", "build", "-mod=vendor", "-o", binary, "../../cmd/vk-flightctl-provider")
+	build.Stdout, build.Stderr = os.Stderr, os.Stderr
+	if err := build.Run(); err != nil {
+		return nil, fmt.Errorf("building provider: %w", err)
+	}
+
+	logFile, err := os.Create(filepath.Join(workDir, "provider.log"))
+	if err != nil {
+		return nil, fmt.Errorf("creating provider log: %w", err)
+	}
+
+	fc := server.ClientConfig()
+	cmd := exec.Command(binary,
+		"--kubeconfig", kubeconfig,
+		"--node-name", nodeName,
+		"--flightctl-api-url", fc.APIURL,
+		"--flightctl-token-url", fc.TokenURL,
+		"--flightctl-client-id", fc.ClientID,
+		"--default-fleet", fleetName,
+		"--reconcile-interval", "1s",
+		"--disconnect-check-interval", "1s",
+		"--health-probe-addr", "127.0.0.1:0",
+		"--log-level", "debug",
+	)
+	cmd.Env = append(os.Environ(), "FLIGHTCTL_CLIENT_SECRET="+fc.ClientSecret)
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return nil, fmt.Errorf("starting provider: %w", err)
+	}
+
+	return func() {
+		_ = cmd.Process.Signal(os.Interrupt)
+		done := make(chan struct{})
+		go func() {
+			_ = cmd.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			_ = cmd.Process.Kill()
+			<-done
+		}
+		logFile.Close()
+		if output, err := os.ReadFile(logFile.Name()); err == nil {
+			fmt.Fprintf(os.Stderr, "=== provider output ===\n%s\n", output)
+		}
+	}, nil
+}
+
+func waitForNodeReady(ctx context.Context) error {
+	return wait.PollUntilContextTimeout(ctx, pollInterval, nodeReadyWait, true, func(ctx context.Context) (bool, error) {
+		node, err := k8s.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, cond := range node.Status.Conditions {
+			if cond.Type == corev1.NodeReady {
+				return cond.Status == corev1.ConditionTrue, nil
+			}
+		}
+		return false, nil
+	})
+}
+
+// newPod returns a pod bound to the virtual node.
+func newPod(name string, annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Annotations: annotations},
+		Spec: corev1.PodSpec{
+			NodeName:    nodeName,
+			Tolerations: []corev1.Toleration{{Key: virtualNodeTaint, Operator: corev1.TolerationOpExists}},
+			Containers:  []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}},
+		},
+	}
+}
+
+func createPod(t *testing.T, pod *corev1.Pod) {
+	t.Helper()
+	if _, err := k8s.CoreV1().Pods(namespace).Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("creating pod %s: %v", pod.Name, err)
+	}
+}
+
+// deviceApplication returns the application deployed for a pod on a device.
+func deviceApplication(deviceID, pod string) (flightctl.FlightctlApplication, bool) {
+	device, ok := server.Device(deviceID)
+	if !ok {
+		return flightctl.FlightctlApplication{}, false
+	}
+	for _, app := range device.Spec.Applications {
+		if app.Name == namespace+"-"+pod {
+			return app, true
+		}
+	}
+	return flightctl.FlightctlApplication{}, false
+}
+
+func waitForApplication(t *testing.T, deviceID, pod string, present bool) {
+	t.Helper()
+	err := wait.PollUntilContextTimeout(context.Background(), pollInterval, podTransitWait, true, func(context.Context) (bool, error) {
+		_, ok := deviceApplication(deviceID, pod)
+		return ok == present, nil
+	})
+	if err != nil {
+		t.Fatalf("application for pod %s present=%t on %s: %v", pod, present, deviceID, err)
+	}
+}
+
+func waitForPodPhase(t *testing.T, name string, phase corev1.PodPhase) {
+	t.Helper()
+	var last corev1.PodPhase
+	err := wait.PollUntilContextTimeout(context.Background(), pollInterval, podTransitWait, true, func(ctx context.Context) (bool, error) {
+		pod, err := k8s.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		last = pod.Status.Phase
+		return last == phase, nil
+	})
+	if err != nil {
+		t.Fatalf("pod %s phase = %s, want %s: %v", name, last, phase, err)
+	}
+}
+
+func deletePod(t *testing.T, name string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := k8s.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("deleting pod %s: %v", name, err)
+	}
+	err := wait.PollUntilContextTimeout(ctx, pollInterval, podTransitWait, true, func(ctx context.Context) (bool, error) {
+		_, err := k8s.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		return apierrors.IsNotFound(err), nil
+	})
+	if err != nil {
+		t.Fatalf("pod %s was not removed: %v", name, err)
+	}
+}
+
+func TestPodIsDeployedToDevice(t *testing.T) {
+	createPod(t, newPod("web", map[string]string{deviceIDKey: "device-1"}))
+	waitForApplication(t, "device-1", "web", true)
+
+	app, _ := deviceApplication("device-1", "web")
+	if len(app.Inline) == 0 {
+		t.Errorf("application %s has no inline content", app.Name)
+	}
+
+	waitForPodPhase(t, "web", corev1.PodRunning)
+
+	deletePod(t, "web")
+	waitForApplication(t, "device-1", "web", false)
+}
+
+func TestApplicationStatusFlowsBack(t *testing.T) {
+	createPod(t, newPod("worker", map[string]string{deviceIDKey: "device-2"}))
+	waitForApplication(t, "device-2", "worker", true)
+	waitForPodPhase(t, "worker", corev1.PodRunning)
+
+	err := server.SetApplicationStatus("device-2", flightctl.FlightctlApplicationStatus{
+		Name:   namespace + "-worker",
+		Status: "Error",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForPodPhase(t, "worker", corev1.PodFailed)
+
+	deletePod(t, "worker")
+	waitForApplication(t, "device-2", "worker", false)
+}
+
+func TestDefaultFleetPlacement(t *testing.T) {
+	createPod(t, newPod("fleet-pod", nil))
+
+	var deviceID string
+	err := wait.PollUntilContextTimeout(context.Background(), pollInterval, podTransitWait, true, func(context.Context) (bool, error) {
+		for _, id := range []string{"device-1", "device-2"} {
+			if _, ok := deviceApplication(id, "fleet-pod"); ok {
+				deviceID = id
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		t.Fatalf("pod was not deployed to a device in fleet %s: %v", fleetName, err)
+	}
+	waitForPodPhase(t, "fleet-pod", corev1.PodRunning)
+
+	deletePod(t, "fleet-pod")
+	waitForApplication(t, deviceID, "fleet-pod", false)
+}